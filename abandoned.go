@@ -0,0 +1,91 @@
+package main
+
+import (
+	"convertyApi/api"
+	"convertyApi/service"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// abandonedSyncJobType is the job queue type of the abandoned-cart sync
+const abandonedSyncJobType = "sync_abandoned_carts"
+
+// abandonedSyncMaxPages bounds how many Converty pages one sync walks
+const abandonedSyncMaxPages = 20
+
+// registerAbandonedSyncJob registers the handler that pulls abandoned orders from Converty per tenant
+func registerAbandonedSyncJob(jobService service.JobService, dataService service.DataService, tenantService service.TenantService, cartService service.AbandonedCartService) {
+	jobService.RegisterHandler(abandonedSyncJobType, func(payload json.RawMessage) (interface{}, error) {
+		tenants, err := jobTenants(tenantService, payload)
+		if err != nil {
+			return nil, err
+		}
+		created := make(map[string]int)
+		for _, tenant := range tenants {
+			count, err := cartService.Sync(tenant.ID, dataService.ForTenant(tenant), abandonedSyncMaxPages)
+			if err != nil {
+				log.Printf("Abandoned-cart sync for tenant %s failed: %v", tenant.Slug, err)
+				continue
+			}
+			log.Printf("Abandoned-cart sync stored %d new carts for tenant %s", count, tenant.Slug)
+			created[tenant.Slug] = count
+		}
+		return created, nil
+	})
+}
+
+// registerAbandonedCartRoutes mounts the abandoned-cart listing and the chatbot follow-up hook
+func registerAbandonedCartRoutes(r chi.Router, jobService service.JobService, dataService service.DataService, cartService service.AbandonedCartService) {
+	r.Get("/api/v1/abandoned", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		carts, err := cartService.ListCarts(tenantFrom(r).ID, r.URL.Query().Get("status"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, carts, params))
+	})
+
+	r.Get("/api/v1/abandoned/{orderId}", func(w http.ResponseWriter, r *http.Request) {
+		cart, err := cartService.GetCart(tenantFrom(r).ID, chi.URLParam(r, "orderId"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, cart)
+	})
+
+	// Triggers a sync now instead of waiting for the schedule
+	r.Post("/api/v1/abandoned/sync", func(w http.ResponseWriter, r *http.Request) {
+		job, err := jobService.Enqueue(tenantFrom(r).ID, abandonedSyncJobType, tenantJobPayload{Tenant: tenantFrom(r).Slug})
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusAccepted, job)
+	})
+
+	// The chatbot reports each follow-up message and its outcome here
+	r.Post("/api/v1/abandoned/{orderId}/follow-ups", func(w http.ResponseWriter, r *http.Request) {
+		var input service.FollowUp
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		cart, record, err := cartService.RecordFollowUp(tenantFrom(r).ID, tenantData(r, dataService), chi.URLParam(r, "orderId"), input)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, map[string]interface{}{
+			"cart":   cart,
+			"record": record,
+		})
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// loadGeocoder returns the geocoder of GEOCODER_URL, a Nominatim-compatible server, or nil when unset
+func loadGeocoder() service.Geocoder {
+	if url := os.Getenv("GEOCODER_URL"); url != "" {
+		return service.NewNominatimGeocoder(url)
+	}
+	return nil
+}
+
+// addressStatus maps the errors of the address book to a status code
+func addressStatus(err error) int {
+	if errors.Is(err, service.ErrAddressNotFound) {
+		return http.StatusNotFound
+	}
+	return serviceStatus(err, http.StatusInternalServerError)
+}
+
+// registerAddressRoutes mounts the address books of the customers, identified by phone
+func registerAddressRoutes(r chi.Router, addressService service.AddressService) {
+	r.Get("/api/v1/customers/{phone}/addresses", func(w http.ResponseWriter, r *http.Request) {
+		addresses, err := addressService.ListAddresses(tenantFrom(r).ID, chi.URLParam(r, "phone"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, addresses)
+	})
+
+	// {"line1": "12 rue de Marseille", "city": "Tunis", "governorate": "Tunis", "postal_code": "1000", "default": true}
+	r.Post("/api/v1/customers/{phone}/addresses", func(w http.ResponseWriter, r *http.Request) {
+		var input addressRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		address, err := addressService.SaveAddress(service.Address{
+			TenantID:    tenantFrom(r).ID,
+			Phone:       chi.URLParam(r, "phone"),
+			Label:       input.Label,
+			Recipient:   input.Recipient,
+			Line1:       input.Line1,
+			Line2:       input.Line2,
+			City:        input.City,
+			Governorate: input.Governorate,
+			PostalCode:  input.PostalCode,
+			Country:     input.Country,
+			Latitude:    input.Latitude,
+			Longitude:   input.Longitude,
+			IsDefault:   input.Default,
+		})
+		if err != nil {
+			writeError(w, err.Error(), addressStatus(err))
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, address)
+	})
+
+	r.Get("/api/v1/customers/{phone}/addresses/default", func(w http.ResponseWriter, r *http.Request) {
+		address, err := addressService.DefaultAddress(tenantFrom(r).ID, chi.URLParam(r, "phone"))
+		if err != nil {
+			writeError(w, err.Error(), addressStatus(err))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, address)
+	})
+
+	// {"address_id": 3}; the next orders of the customer without an address are sent to it
+	r.Put("/api/v1/customers/{phone}/addresses/default", func(w http.ResponseWriter, r *http.Request) {
+		var input defaultAddressRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		address, err := addressService.SetDefault(tenantFrom(r).ID, chi.URLParam(r, "phone"), input.AddressID)
+		if err != nil {
+			writeError(w, err.Error(), addressStatus(err))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, address)
+	})
+
+	r.Delete("/api/v1/customers/{phone}/addresses/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		if err := addressService.DeleteAddress(tenantFrom(r).ID, chi.URLParam(r, "phone"), uint(id)); err != nil {
+			writeError(w, err.Error(), addressStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
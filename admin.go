@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Built-in admin roles; the access policy may define more
+const (
+	roleAdmin  = "admin"
+	roleViewer = "viewer"
+)
+
+// adminAPIKey protects the /api/v1/admin routes; the API key backend is disabled when empty
+var adminAPIKey string
+
+// adminIdentity is the staff member behind an admin request
+type adminIdentity struct {
+	Subject string `json:"subject"`
+	Email   string `json:"email,omitempty"`
+	Role    string `json:"role"`
+	Backend string `json:"backend"`
+	// SecondFactor is set when the staff member passed TOTP verification in this session
+	SecondFactor bool `json:"second_factor"`
+}
+
+// needsSecondFactor reports whether identity has to pass two-factor verification first. The
+// policy marks the roles that can delete data or manage tokens; the API key backend is a
+// machine credential and is not affected.
+func needsSecondFactor(identity adminIdentity) bool {
+	return identity.Backend == "oidc" && policy().requiresSecondFactor(identity.Role) && !identity.SecondFactor
+}
+
+// adminAuthBackend authenticates admin requests. ok is false when the request carries no
+// credentials for the backend; err is set when it carries invalid ones.
+type adminAuthBackend interface {
+	Name() string
+	Authenticate(r *http.Request) (identity adminIdentity, ok bool, err error)
+}
+
+// adminBackends are tried in order; loadAdminBackends fills them from the configuration
+var adminBackends []adminAuthBackend
+
+type adminContextKey struct{}
+
+// apiKeyBackend accepts the shared admin API key in X-Admin-Key
+type apiKeyBackend struct {
+	key string
+}
+
+func (b apiKeyBackend) Name() string { return "api_key" }
+
+func (b apiKeyBackend) Authenticate(r *http.Request) (adminIdentity, bool, error) {
+	key := r.Header.Get("X-Admin-Key")
+	if key == "" {
+		return adminIdentity{}, false, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(b.key)) != 1 {
+		return adminIdentity{}, true, errors.New("Invalid or missing admin API key")
+	}
+	subject := r.Header.Get("X-Admin-User")
+	if subject == "" {
+		subject = "admin"
+	}
+	return adminIdentity{Subject: subject, Role: roleAdmin, Backend: b.Name()}, true, nil
+}
+
+// loadAdminBackends enables the API key backend and, when OIDC_ISSUER is set, the OIDC login backend
+func loadAdminBackends(sessions service.SessionService) error {
+	adminBackends = nil
+	if adminAPIKey != "" {
+		adminBackends = append(adminBackends, apiKeyBackend{key: adminAPIKey})
+	}
+	provider, err := loadOIDCProvider()
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		adminOIDC = provider
+		adminBackends = append(adminBackends, adminSessionBackend{sessions: sessions})
+	}
+	return nil
+}
+
+// adminOnly rejects requests without valid staff credentials or outside the role's policy
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(adminBackends) == 0 {
+			writeError(w, "Admin API is disabled: neither ADMIN_API_KEY nor OIDC_ISSUER is set", http.StatusForbidden)
+			return
+		}
+		for _, backend := range adminBackends {
+			identity, ok, err := backend.Authenticate(r)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if !ok {
+				continue
+			}
+			if needsSecondFactor(identity) {
+				writeError(w, "Two-factor authentication is required for role "+identity.Role+
+					": enroll with POST /admin/2fa/enroll or verify with POST /admin/2fa/verify", http.StatusForbidden)
+				return
+			}
+			if !policy().roleAllows(identity.Role, r.Method, r.URL.Path) {
+				writeError(w, fmt.Sprintf("Role %s may not %s %s", identity.Role, r.Method, r.URL.Path), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminContextKey{}, identity)))
+			return
+		}
+		writeError(w, "Invalid or missing admin credentials", http.StatusUnauthorized)
+	})
+}
+
+// adminActor identifies the operator performing an admin action
+func adminActor(r *http.Request) string {
+	if identity, ok := r.Context().Value(adminContextKey{}).(adminIdentity); ok {
+		if identity.Email != "" {
+			return identity.Email
+		}
+		return identity.Subject
+	}
+	if actor := r.Header.Get("X-Admin-User"); actor != "" {
+		return actor
+	}
+	return "admin"
+}
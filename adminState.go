@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// defaultStateArchive is where `admin export` writes and `admin import` reads by default
+const defaultStateArchive = "convertyapi-state.jsonl.gz"
+
+// tokenStateTable carries the stored Converty tokens in a state archive
+var tokenStateTable = service.StateTable{Name: "tokens", Model: TokenInfo{}, Order: "id"}
+
+// runAdminCommand runs `admin <subcommand>` from the command line and returns the exit code.
+// `admin export [--out FILE] [--tokens] [--passphrase-env NAME]` writes the interactions with their
+// issue history, the record schemas, the classification rules and the tags, and with --tokens the
+// Converty tokens, to a portable archive. `admin import [--in FILE] [--replace] [--passphrase-env NAME]`
+// restores one, so a staging environment can be seeded from production.
+func runAdminCommand(args []string, out io.Writer) int {
+	usage := "Usage: admin export [--out FILE] [--tokens] [--passphrase-env NAME] | admin import [--in FILE] [--replace] [--passphrase-env NAME]"
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	fs := flag.NewFlagSet("admin "+args[0], flag.ContinueOnError)
+	var path string
+	var withTokens, replace bool
+	if args[0] == "export" {
+		fs.StringVar(&path, "out", defaultStateArchive, "archive to write")
+		fs.BoolVar(&withTokens, "tokens", false, "also export the stored Converty tokens")
+	} else {
+		fs.StringVar(&path, "in", defaultStateArchive, "archive to read")
+		fs.BoolVar(&replace, "replace", false, "delete the rows of the imported tables first instead of requiring them empty")
+	}
+	passphraseEnv := fs.String("passphrase-env", "", "environment variable holding the passphrase that encrypts the archive")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	var passphrase string
+	if *passphraseEnv != "" {
+		if passphrase = os.Getenv(*passphraseEnv); passphrase == "" {
+			fmt.Fprintf(os.Stderr, "Error: %s is empty\n", *passphraseEnv)
+			return 2
+		}
+	}
+
+	// Records are read and written through the PII hooks, so the archive holds them in clear and
+	// the target encrypts them with its own key
+	if err := loadPIIProtection(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid PII encryption configuration: %v\n", err)
+		return 1
+	}
+	if err := waitForDB(context.Background(), db, durationEnv("DB_CONNECT_TIMEOUT", dbConnectWindow)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to connect to database: %v\n", err)
+		return 1
+	}
+	if args[0] == "export" {
+		return exportState(path, withTokens, passphrase, out)
+	}
+	return importState(path, replace, passphrase, out)
+}
+
+// exportState writes the state archive to path
+func exportState(path string, withTokens bool, passphrase string, out io.Writer) int {
+	tables := service.StateTables()
+	if withTokens {
+		tables = append(tables, tokenStateTable)
+	}
+	if passphrase == "" {
+		fmt.Fprintln(os.Stderr, "Warning: the customer records are written in clear, set --passphrase-env to encrypt the archive")
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	counts, err := service.ExportState(db, file, tables, passphrase)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "Exported to %s:\n", path)
+	for _, table := range tables {
+		fmt.Fprintf(out, "  %-22s %d\n", table.Name, counts[table.Name])
+	}
+	return 0
+}
+
+// importState restores the state archive at path into the database
+func importState(path string, replace bool, passphrase string, out io.Writer) int {
+	if err := migrateDB(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to migrate database: %v\n", err)
+		return 1
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+	result, err := service.ImportState(db, file, append(service.StateTables(), tokenStateTable), passphrase, replace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: nothing was imported: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "Imported from %s:\n", path)
+	for _, table := range append(service.StateTables(), tokenStateTable) {
+		if count, ok := result.Rows[table.Name]; ok {
+			fmt.Fprintf(out, "  %-22s %d\n", table.Name, count)
+		}
+	}
+	slugs := make([]string, 0, len(result.TenantKeys))
+	for slug := range result.TenantKeys {
+		slugs = append(slugs, slug)
+	}
+	sort.Strings(slugs)
+	for _, slug := range slugs {
+		fmt.Fprintf(out, "Created tenant %s, API key (shown once): %s\n", slug, result.TenantKeys[slug])
+	}
+	return 0
+}
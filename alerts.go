@@ -0,0 +1,86 @@
+package main
+
+import (
+	"convertyApi/api"
+	"convertyApi/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultAlertThresholds alert when fewer than 5 units are left or a price moves by 10% or more
+var defaultAlertThresholds = service.AlertThresholds{LowStock: 5, PriceChangePercent: 10}
+
+// loadAlertThresholds reads ALERT_LOW_STOCK and ALERT_PRICE_CHANGE_PERCENT; 0 disables either alert
+func loadAlertThresholds(getenv func(string) string) (service.AlertThresholds, error) {
+	thresholds := defaultAlertThresholds
+	if value := getenv("ALERT_LOW_STOCK"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return thresholds, fmt.Errorf("invalid ALERT_LOW_STOCK %q", value)
+		}
+		thresholds.LowStock = parsed
+	}
+	if value := getenv("ALERT_PRICE_CHANGE_PERCENT"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 {
+			return thresholds, fmt.Errorf("invalid ALERT_PRICE_CHANGE_PERCENT %q", value)
+		}
+		thresholds.PriceChangePercent = parsed
+	}
+	return thresholds, nil
+}
+
+// registerAlertRoutes mounts the product alert feed raised by the stock sync
+func registerAlertRoutes(r chi.Router, alertService service.ProductAlertService) {
+	// /api/v1/alerts?kind=out_of_stock&product=42&since=2025-01-01T00:00:00Z&unacknowledged=true
+	r.Get("/api/v1/alerts", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+		filter := service.AlertFilter{
+			Kind:           query.Get("kind"),
+			ProductID:      query.Get("product"),
+			Unacknowledged: query.Get("unacknowledged") == "true",
+		}
+		if value := query.Get("since"); value != "" {
+			since, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, "since must be an RFC3339 time", http.StatusBadRequest)
+				return
+			}
+			filter.Since = &since
+		}
+		alerts, err := alertService.ListAlerts(tenantFrom(r).ID, filter)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, alerts, params))
+	})
+
+	r.Post("/api/v1/alerts/{id}/ack", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid alert ID", http.StatusBadRequest)
+			return
+		}
+		alert, err := alertService.Acknowledge(tenantFrom(r).ID, uint(id), requestActor(r))
+		if errors.Is(err, service.ErrAlertNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, alert)
+	})
+}
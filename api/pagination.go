@@ -0,0 +1,119 @@
+// Package api holds the response shapes shared by the HTTP endpoints.
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Page size bounds for list endpoints
+const (
+	DefaultLimit = 10
+	MaxLimit     = 100
+)
+
+// PageParams is the requested page of a list endpoint
+type PageParams struct {
+	Page  int
+	Limit int
+}
+
+// Offset returns how many items precede the page
+func (p PageParams) Offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+// ParsePageParams reads ?page= and ?limit=, defaulting to the first page of DefaultLimit items
+func ParsePageParams(r *http.Request) (PageParams, error) {
+	params := PageParams{Page: 1, Limit: DefaultLimit}
+	query := r.URL.Query()
+	if value := query.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return params, fmt.Errorf("invalid page %q", value)
+		}
+		params.Page = page
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return params, fmt.Errorf("invalid limit %q", value)
+		}
+		params.Limit = min(limit, MaxLimit)
+	}
+	return params, nil
+}
+
+// Meta describes the returned page; Total and TotalPages are null when the source cannot count
+type Meta struct {
+	Page       int    `json:"page"`
+	Limit      int    `json:"limit"`
+	Total      *int64 `json:"total"`
+	TotalPages *int   `json:"total_pages"`
+}
+
+// Links point to the neighbouring pages; a null link means there is no such page
+type Links struct {
+	Next *string `json:"next"`
+	Prev *string `json:"prev"`
+}
+
+// Envelope is the body of every list endpoint
+type Envelope[T any] struct {
+	Data  []T   `json:"data"`
+	Meta  Meta  `json:"meta"`
+	Links Links `json:"links"`
+}
+
+// NewPage wraps one page of a listing whose total size is known
+func NewPage[T any](r *http.Request, data []T, params PageParams, total int64) Envelope[T] {
+	totalPages := int((total + int64(params.Limit) - 1) / int64(params.Limit))
+	return newEnvelope(r, data, params, &total, &totalPages, params.Page < totalPages)
+}
+
+// NewOpenPage wraps one page of a listing whose total is unknown (e.g. an upstream API);
+// a full page is assumed to have a successor
+func NewOpenPage[T any](r *http.Request, data []T, params PageParams) Envelope[T] {
+	return newEnvelope(r, data, params, nil, nil, len(data) >= params.Limit)
+}
+
+// Slice paginates an in-memory listing
+func Slice[T any](r *http.Request, items []T, params PageParams) Envelope[T] {
+	start := min(params.Offset(), len(items))
+	end := min(start+params.Limit, len(items))
+	return NewPage(r, items[start:end], params, int64(len(items)))
+}
+
+// All wraps a complete listing, such as a batch lookup by IDs, as a single page
+func All[T any](r *http.Request, items []T) Envelope[T] {
+	return NewPage(r, items, PageParams{Page: 1, Limit: max(len(items), 1)}, int64(len(items)))
+}
+
+func newEnvelope[T any](r *http.Request, data []T, params PageParams, total *int64, totalPages *int, hasNext bool) Envelope[T] {
+	if data == nil {
+		data = []T{}
+	}
+	envelope := Envelope[T]{
+		Data:  data,
+		Meta:  Meta{Page: params.Page, Limit: params.Limit, Total: total, TotalPages: totalPages},
+		Links: Links{},
+	}
+	if hasNext {
+		next := pageURL(r, params, params.Page+1)
+		envelope.Links.Next = &next
+	}
+	if params.Page > 1 {
+		prev := pageURL(r, params, params.Page-1)
+		envelope.Links.Prev = &prev
+	}
+	return envelope
+}
+
+// pageURL rebuilds the request URL for another page, keeping every other query parameter
+func pageURL(r *http.Request, params PageParams, page int) string {
+	query := r.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	query.Set("limit", strconv.Itoa(params.Limit))
+	return r.URL.Path + "?" + query.Encode()
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParsePageParams(t *testing.T) {
+	params, err := ParsePageParams(httptest.NewRequest("GET", "/api/v1/records?page=3&limit=500", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Page != 3 || params.Limit != MaxLimit || params.Offset() != 2*MaxLimit {
+		t.Errorf("unexpected params %+v", params)
+	}
+	if _, err := ParsePageParams(httptest.NewRequest("GET", "/api/v1/records?page=0", nil)); err == nil {
+		t.Error("expected an error for page 0")
+	}
+}
+
+func TestSliceLinks(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/v1/records?status=pending&page=2&limit=2", nil)
+	page := Slice(r, []int{1, 2, 3, 4, 5}, PageParams{Page: 2, Limit: 2})
+
+	if len(page.Data) != 2 || page.Data[0] != 3 {
+		t.Errorf("unexpected data %v", page.Data)
+	}
+	if *page.Meta.Total != 5 || *page.Meta.TotalPages != 3 {
+		t.Errorf("unexpected meta %+v", page.Meta)
+	}
+	if page.Links.Next == nil || *page.Links.Next != "/api/v1/records?limit=2&page=3&status=pending" {
+		t.Errorf("unexpected next link %v", page.Links.Next)
+	}
+	if page.Links.Prev == nil || *page.Links.Prev != "/api/v1/records?limit=2&page=1&status=pending" {
+		t.Errorf("unexpected prev link %v", page.Links.Prev)
+	}
+}
+
+func TestOpenPageAndEmptyData(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/v1/orders", nil)
+	page := NewOpenPage[string](r, nil, PageParams{Page: 1, Limit: 10})
+	if page.Data == nil || len(page.Data) != 0 {
+		t.Errorf("expected an empty, non-nil data slice")
+	}
+	if page.Meta.Total != nil || page.Links.Next != nil || page.Links.Prev != nil {
+		t.Errorf("unexpected meta/links %+v %+v", page.Meta, page.Links)
+	}
+}
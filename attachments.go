@@ -0,0 +1,230 @@
+package main
+
+import (
+	"convertyApi/service"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Attachment limits: the size of one file and how many files one upload may carry
+var (
+	attachmentMaxSize  int64 = 10 << 20
+	attachmentMaxFiles       = 5
+)
+
+// attachmentURLTTL is how long a signed download URL stays valid
+var attachmentURLTTL = 15 * time.Minute
+
+// loadAttachmentStore configures the attachment backend from ATTACHMENT_STORAGE (local or s3)
+func loadAttachmentStore() (service.BlobStore, error) {
+	if value := os.Getenv("ATTACHMENT_MAX_SIZE"); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid ATTACHMENT_MAX_SIZE %q", value)
+		}
+		attachmentMaxSize = size
+	}
+	attachmentURLTTL = durationEnv("ATTACHMENT_URL_TTL", attachmentURLTTL)
+
+	switch backend := envOr("ATTACHMENT_STORAGE", "local"); backend {
+	case "local":
+		return &service.LocalBlobStore{Dir: envOr("ATTACHMENT_DIR", "attachments")}, nil
+	case "s3":
+		store := &service.S3BlobStore{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    envOr("S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+		}
+		if store.Bucket == "" || store.AccessKey == "" || store.SecretKey == "" {
+			return nil, fmt.Errorf("S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required for s3 storage")
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown ATTACHMENT_STORAGE %q, expected local or s3", backend)
+	}
+}
+
+// attachmentSignature authenticates a download URL of an attachment until expires
+func attachmentSignature(tenantID, id uint, expires int64) string {
+	mac := hmac.New(sha256.New, sessionSecret)
+	fmt.Fprintf(mac, "attachment:%d:%d:%d", tenantID, id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// attachmentURL returns a signed download URL that works without an API key until it expires
+func attachmentURL(attachment service.Attachment) string {
+	expires := time.Now().Add(attachmentURLTTL).Unix()
+	params := url.Values{}
+	params.Set("tenant", strconv.FormatUint(uint64(attachment.TenantID), 10))
+	params.Set("expires", strconv.FormatInt(expires, 10))
+	params.Set("signature", attachmentSignature(attachment.TenantID, attachment.ID, expires))
+	return fmt.Sprintf("%s/api/v1/attachments/%d/download?%s", publicBaseURL, attachment.ID, params.Encode())
+}
+
+// isSignedDownload reports whether a request carries an attachment download signature; the
+// handler checks it, so such requests need no API key
+func isSignedDownload(r *http.Request) bool {
+	return r.Method == http.MethodGet && isStreamingPath(r.URL.Path) && r.URL.Query().Get("signature") != ""
+}
+
+// attachmentView is an attachment with its download URL
+type attachmentView struct {
+	service.Attachment
+	DownloadURL string `json:"download_url"`
+}
+
+func viewAttachment(attachment service.Attachment) attachmentView {
+	return attachmentView{Attachment: attachment, DownloadURL: attachmentURL(attachment)}
+}
+
+// registerAttachmentRoutes mounts the upload, listing and download of record attachments
+func registerAttachmentRoutes(r chi.Router, dataService service.DataService, attachments service.AttachmentService) {
+	// Multipart upload with one or more "file" parts
+	r.Post("/api/v1/records/{id}/attachments", func(w http.ResponseWriter, r *http.Request) {
+		recordID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		if _, err := tenantData(r, dataService).QueryByID(uint(recordID)); err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, attachmentMaxSize*int64(attachmentMaxFiles)+1<<20)
+		reader, err := r.MultipartReader()
+		if err != nil {
+			writeError(w, fmt.Sprintf("Expected a multipart/form-data upload: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		tenantID := tenantFrom(r).ID
+		var created []attachmentView
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				writeError(w, fmt.Sprintf("Invalid multipart upload: %v", err), http.StatusBadRequest)
+				return
+			}
+			if part.FormName() != "file" {
+				part.Close()
+				continue
+			}
+			if len(created) == attachmentMaxFiles {
+				writeError(w, fmt.Sprintf("At most %d files can be uploaded at once", attachmentMaxFiles), http.StatusRequestEntityTooLarge)
+				return
+			}
+			attachment, err := attachments.Create(r.Context(), tenantID, uint(recordID), part.FileName(), part)
+			part.Close()
+			if err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, service.ErrAttachmentRejected) {
+					status = http.StatusUnprocessableEntity
+				}
+				writeError(w, err.Error(), status)
+				return
+			}
+			created = append(created, viewAttachment(attachment))
+		}
+		if len(created) == 0 {
+			writeError(w, `No "file" part in the upload`, http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, created)
+	})
+
+	r.Get("/api/v1/records/{id}/attachments", func(w http.ResponseWriter, r *http.Request) {
+		recordID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		list, err := attachments.List(tenantFrom(r).ID, uint(recordID))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		views := make([]attachmentView, 0, len(list))
+		for _, attachment := range list {
+			views = append(views, viewAttachment(attachment))
+		}
+		writeJSON(w, r, http.StatusOK, views)
+	})
+
+	r.Delete("/api/v1/records/{id}/attachments/{attachmentId}", func(w http.ResponseWriter, r *http.Request) {
+		recordID, errRecord := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		id, err := strconv.ParseUint(chi.URLParam(r, "attachmentId"), 10, 64)
+		if errRecord != nil || err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		if err := attachments.Delete(r.Context(), tenantFrom(r).ID, uint(recordID), uint(id)); err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Signed download: /api/v1/attachments/{id}/download?tenant=...&expires=...&signature=...
+	r.Get("/api/v1/attachments/{id}/download", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		tenantID, errTenant := strconv.ParseUint(query.Get("tenant"), 10, 64)
+		expires, errExpires := strconv.ParseInt(query.Get("expires"), 10, 64)
+		expected := attachmentSignature(uint(tenantID), uint(id), expires)
+		if errTenant != nil || errExpires != nil || !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+			writeError(w, "Invalid download signature", http.StatusForbidden)
+			return
+		}
+		if time.Now().Unix() > expires {
+			writeError(w, "Download link expired", http.StatusGone)
+			return
+		}
+
+		attachment, err := attachments.Get(uint(tenantID), uint(id))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		body, err := attachments.Open(r.Context(), attachment)
+		if err != nil {
+			status := http.StatusBadGateway
+			if errors.Is(err, service.ErrBlobNotFound) {
+				status = http.StatusNotFound
+			}
+			writeError(w, err.Error(), status)
+			return
+		}
+		defer body.Close()
+		w.Header().Set("Content-Type", attachment.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachment.FileName}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, max-age=300")
+		if _, err := io.Copy(w, body); err != nil {
+			log.Printf("Failed to stream attachment %d: %v", attachment.ID, err)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"convertyApi/service"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dryRunAll makes every mutating Converty endpoint behave as if ?dry_run=true was passed;
+// set DRY_RUN=true while testing the chatbot against the production shop
+var dryRunAll = false
+
+// loadDryRun reads the global dry-run toggle
+func loadDryRun() {
+	dryRunAll = os.Getenv("DRY_RUN") == "true"
+	if dryRunAll {
+		log.Println("DRY_RUN is set: mutating Converty calls are validated and logged but not sent")
+	}
+}
+
+// dryRunRequested reports whether the request must not reach Converty
+func dryRunRequested(r *http.Request) bool {
+	return dryRunAll || r.URL.Query().Get("dry_run") == "true"
+}
+
+// requestActor names the caller of a chatbot or operator request for the audit trail
+func requestActor(r *http.Request) string {
+	if account, ok := serviceAccountFrom(r); ok {
+		return "service-account:" + account.Name
+	}
+	if claims, ok := userFrom(r); ok {
+		return "user:" + claims.Subject
+	}
+	if claims, ok := sessionFrom(r); ok {
+		return claims.Subject
+	}
+	return "api"
+}
+
+// registerAuditAdminRoutes mounts the audit trail
+func registerAuditAdminRoutes(r chi.Router, auditService service.AuditService) {
+	// /api/v1/admin/audit?action=order.create.dry_run&since=2025-01-01T00:00:00Z&limit=50
+	r.Get("/audit", func(w http.ResponseWriter, r *http.Request) {
+		filter := service.AuditFilter{Action: r.URL.Query().Get("action")}
+		if value := r.URL.Query().Get("since"); value != "" {
+			since, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			filter.Since = since
+		}
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				writeError(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = limit
+		}
+		if value := r.URL.Query().Get("tenant"); value != "" {
+			tenantID, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				writeError(w, "tenant must be a tenant ID", http.StatusBadRequest)
+				return
+			}
+			filter.TenantID = uint(tenantID)
+		}
+		entries, err := auditService.ListEntries(filter)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, entries)
+	})
+}
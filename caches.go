@@ -0,0 +1,35 @@
+package main
+
+import (
+	"convertyApi/service"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// registerCacheRoutes mounts the manual invalidation of in-memory caches
+func registerCacheRoutes(r chi.Router) {
+	r.Route("/api/v1/cache", func(r chi.Router) {
+		r.Use(adminOnly)
+
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, r, http.StatusOK, map[string]interface{}{"resources": service.Caches.Resources()})
+		})
+
+		// {"resource": "orders", "pattern": "orders:user1:*"}; an empty resource or pattern selects everything
+		r.Post("/invalidate", func(w http.ResponseWriter, r *http.Request) {
+			var input cacheInvalidateRequest
+			if r.ContentLength != 0 {
+				if !bindJSON(w, r, &input) {
+					return
+				}
+			}
+			removed, err := service.Caches.Invalidate(input.Resource, input.Pattern)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, r, http.StatusOK, map[string]interface{}{"invalidated": removed})
+		})
+	})
+}
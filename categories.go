@@ -0,0 +1,114 @@
+package main
+
+import (
+	"convertyApi/api"
+	"convertyApi/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// categorySyncJobType is the job queue type of the Converty category sync
+const categorySyncJobType = "sync_categories"
+
+// registerCategorySyncJob registers the handler that mirrors Converty categories per tenant
+func registerCategorySyncJob(jobService service.JobService, dataService service.DataService, tenantService service.TenantService, categoryService service.CategoryService) {
+	jobService.RegisterHandler(categorySyncJobType, func(payload json.RawMessage) (interface{}, error) {
+		tenants, err := jobTenants(tenantService, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		synced := make(map[string]int)
+		for _, tenant := range tenants {
+			count, err := categoryService.Sync(tenant.ID, dataService.ForTenant(tenant))
+			if err != nil {
+				// One tenant without a Converty token must not block the others
+				log.Printf("Category sync for tenant %s failed: %v", tenant.Slug, err)
+				continue
+			}
+			synced[tenant.Slug] = count
+		}
+		return synced, nil
+	})
+}
+
+// registerCategoryRoutes mounts the category taxonomy endpoints
+func registerCategoryRoutes(r chi.Router, jobService service.JobService, categoryService service.CategoryService) {
+	r.Get("/api/v1/categories", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		categories, err := categoryService.ListCategories(tenantFrom(r).ID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, categories, params))
+	})
+
+	r.Post("/api/v1/categories/sync", func(w http.ResponseWriter, r *http.Request) {
+		job, err := jobService.Enqueue(tenantFrom(r).ID, categorySyncJobType, tenantJobPayload{Tenant: tenantFrom(r).Slug})
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusAccepted, job)
+	})
+
+	// Routing: issues mentioning the category are assigned to this person
+	r.With(adminOnly).Put("/api/v1/categories/{id}/assignee", func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		var id uint
+		_, err := fmt.Sscanf(idStr, "%d", &id)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		var input categoryAssigneeRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		category, err := categoryService.SetAssignee(tenantFrom(r).ID, id, input.Assignee)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, category)
+	})
+}
+
+// requestCategory resolves the ?category= filter of a request; ok is false when an error was written
+func requestCategory(w http.ResponseWriter, r *http.Request, categoryService service.CategoryService) (category *service.Category, ok bool) {
+	ref := r.URL.Query().Get("category")
+	if ref == "" {
+		return nil, true
+	}
+	found, err := categoryService.FindCategory(tenantFrom(r).ID, ref)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &found, true
+}
+
+// routeByCategory assigns a new record to the owner of the category named in its details
+func routeByCategory(r *http.Request, categoryService service.CategoryService, details map[string]interface{}) {
+	ref, _ := details["category"].(string)
+	if ref == "" {
+		return
+	}
+	if _, assigned := details["assignee"]; assigned {
+		return
+	}
+	category, err := categoryService.FindCategory(tenantFrom(r).ID, ref)
+	if err != nil || category.Assignee == "" {
+		return
+	}
+	details["assignee"] = category.Assignee
+}
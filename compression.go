@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// compressibleTypes are the response types worth compressing; attachments and exports of other types pass through
+var compressibleTypes = []string{"application/json", "text/plain", "text/html", "text/csv", "application/x-ndjson"}
+
+// compressResponses gzip-, deflate- or brotli-encodes responses according to Accept-Encoding, brotli first.
+// COMPRESSION_LEVEL (1-9, default 5) trades CPU for size; COMPRESSION=off disables it.
+func compressResponses() func(http.Handler) http.Handler {
+	if os.Getenv("COMPRESSION") == "off" {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	level := 5
+	if value, err := strconv.Atoi(os.Getenv("COMPRESSION_LEVEL")); err == nil && value >= 1 && value <= 9 {
+		level = value
+	}
+	compressor := middleware.NewCompressor(level, compressibleTypes...)
+	compressor.SetEncoder("br", func(w io.Writer, level int) io.Writer {
+		return brotli.NewWriterLevel(w, level)
+	})
+	return compressor.Handler
+}
+
+// conditionalGET gives successful GET responses an ETag over their uncompressed body and answers
+// 304 Not Modified when If-None-Match already names it, so pollers skip unchanged lists
+func conditionalGET(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recorder := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.status != http.StatusOK {
+			w.WriteHeader(recorder.status)
+			w.Write(recorder.body.Bytes())
+			return
+		}
+		sum := sha256.Sum256(recorder.body.Bytes())
+		// Weak: the same body is served gzip-, brotli- or identity-encoded under one tag
+		etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(recorder.body.Bytes())
+	})
+}
+
+// etagMatches applies the weak comparison of If-None-Match to a list of tags
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	opaque := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets Last-Modified to modified and answers 304 Not Modified when If-Modified-Since
+// shows the client already has that version, reporting whether it did. If-None-Match takes precedence,
+// as conditionalGET compares it against the body; a zero modified sets nothing.
+func notModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	if modified.IsZero() {
+		return false
+	}
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	// HTTP dates have whole seconds
+	if err != nil || modified.Truncate(time.Second).After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// bufferedResponse holds a response until its ETag is known; headers go straight to the real writer
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if !b.wrote {
+		b.status, b.wrote = status, true
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.wrote = true
+	return b.body.Write(p)
+}
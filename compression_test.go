@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestConditionalGET(t *testing.T) {
+	body := `{"data": [{"id": 1}]}`
+	handler := conditionalGET(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeRawJSON(w, r, http.StatusOK, []byte(body))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/records", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || rec.Body.String() != body || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("first request: %d %q %s", rec.Code, etag, rec.Body)
+	}
+
+	for _, ifNoneMatch := range []string{etag, strings.TrimPrefix(etag, "W/"), `"other", ` + etag, "*"} {
+		req := httptest.NewRequest("GET", "/api/v1/records", nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: got %d with %d bytes", ifNoneMatch, rec.Code, rec.Body.Len())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/records", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != body {
+		t.Errorf("stale tag: got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2026, 10, 1, 12, 0, 0, 500e6, time.UTC)
+	cases := []struct {
+		method, ifModifiedSince, ifNoneMatch string
+		want                                 bool
+	}{
+		{"GET", "", "", false},
+		{"GET", "Thu, 01 Oct 2026 12:00:00 GMT", "", true},
+		{"HEAD", "Thu, 01 Oct 2026 13:00:00 GMT", "", true},
+		{"GET", "Thu, 01 Oct 2026 11:59:59 GMT", "", false},
+		{"GET", "yesterday", "", false},
+		// If-None-Match wins over If-Modified-Since
+		{"GET", "Thu, 01 Oct 2026 12:00:00 GMT", `"stale"`, false},
+		{"POST", "Thu, 01 Oct 2026 12:00:00 GMT", "", false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/api/v1/records/1", nil)
+		if c.ifModifiedSince != "" {
+			req.Header.Set("If-Modified-Since", c.ifModifiedSince)
+		}
+		if c.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", c.ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		got := notModified(rec, req, modified)
+		if got != c.want || (got && rec.Code != http.StatusNotModified) {
+			t.Errorf("%s since %q match %q: got %v (%d), want %v", c.method, c.ifModifiedSince, c.ifNoneMatch, got, rec.Code, c.want)
+		}
+		if rec.Header().Get("Last-Modified") != "Thu, 01 Oct 2026 12:00:00 GMT" {
+			t.Errorf("Last-Modified = %q", rec.Header().Get("Last-Modified"))
+		}
+	}
+}
+
+func TestCompressResponsesPrefersBrotli(t *testing.T) {
+	body := strings.Repeat(`{"id": 1, "status": "pending"},`, 100)
+	handler := compressResponses()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeRawJSON(w, r, http.StatusOK, []byte(body))
+	}))
+	req := httptest.NewRequest("GET", "/api/v1/orders", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "br" || rec.Body.Len() >= len(body) {
+		t.Fatalf("expected a smaller brotli body, got %q with %d bytes", rec.Header().Get("Content-Encoding"), rec.Body.Len())
+	}
+	decoded, err := io.ReadAll(brotli.NewReader(rec.Body))
+	if err != nil || string(decoded) != body {
+		t.Fatalf("brotli body does not decode: %v", err)
+	}
+}
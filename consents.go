@@ -0,0 +1,89 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// loadConsentMode reads CONSENT_MODE: opt_out (the default) contacts customers until they opt out,
+// opt_in only those who granted consent
+func loadConsentMode() (optIn bool, err error) {
+	switch mode := envOr("CONSENT_MODE", "opt_out"); mode {
+	case "opt_out":
+		return false, nil
+	case "opt_in":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid CONSENT_MODE %q, expected opt_out or opt_in", mode)
+	}
+}
+
+// consentView is a customer's consent with whether follow-ups may be sent and the compliance trail
+type consentView struct {
+	Phone      string                  `json:"phone"`
+	Consent    *service.Consent        `json:"consent,omitempty"`
+	MayContact bool                    `json:"may_contact"`
+	History    []service.ConsentChange `json:"history"`
+}
+
+// registerConsentRoutes mounts the follow-up consents of the customers, identified by phone
+func registerConsentRoutes(r chi.Router, consentService service.ConsentService) {
+	// GET /api/v1/consents?status=opted_out
+	r.Get("/api/v1/consents", func(w http.ResponseWriter, r *http.Request) {
+		consents, err := consentService.ListConsents(tenantFrom(r).ID, r.URL.Query().Get("status"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, consents)
+	})
+
+	// The chatbot checks may_contact before any follow-up of its own
+	r.Get("/api/v1/customers/{phone}/consent", func(w http.ResponseWriter, r *http.Request) {
+		tenantID, phone := tenantFrom(r).ID, chi.URLParam(r, "phone")
+		view := consentView{Phone: service.NormalizePhone(phone)}
+		consent, err := consentService.GetConsent(tenantID, phone)
+		switch {
+		case errors.Is(err, service.ErrNotFound):
+		case err != nil:
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		default:
+			view.Consent = &consent
+		}
+		if view.MayContact, err = consentService.MayContact(tenantID, phone); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if view.History, err = consentService.History(tenantID, phone); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, view)
+	})
+
+	// {"status": "opted_out", "source": "whatsapp", "note": "replied STOP"}
+	r.Put("/api/v1/customers/{phone}/consent", func(w http.ResponseWriter, r *http.Request) {
+		var input consentRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		consent, err := consentService.SetConsent(tenantFrom(r).ID, chi.URLParam(r, "phone"), service.ConsentUpdate{
+			Status: input.Status,
+			Source: input.Source,
+			Actor:  requestActor(r),
+			Note:   input.Note,
+		})
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		log.Printf("Consent of %s set to %s by %s", consent.Phone, consent.Status, requestActor(r))
+		writeJSON(w, r, http.StatusOK, consent)
+	})
+}
@@ -4,175 +4,352 @@ import (
 	"convertyApi/service"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/manifoldco/promptui"
 	"github.com/olekukonko/tablewriter"
 )
 
-// Run starts the console interface
-func Run(dataService service.DataService) {
-	for {
-		prompt := promptui.Select{
-			Label: "Select Action",
-			Items: []string{
-				"List All Records",
-				"List Issues",
-				"List Orders",
-				"Query by ID",
-				"Insert New Record",
-				"Exit",
-			},
-		}
+// Run starts the console interface in lang (see Languages), scoped to a tenant chosen at startup when
+// several exist
+func Run(dataService service.DataService, tenantService service.TenantService, tokens TokenManager, lang string) {
+	tx := textsFor(lang)
+	tenant, err := selectTenant(tenantService, tx)
+	if err != nil {
+		tx.say("msg.tenant_failed", err)
+		return
+	}
+	tx.say("msg.working_on", tenant.Slug)
+	dataService = dataService.ForTenant(tenant).WithPriority(service.PriorityInteractive)
+	output := DefaultOutput()
+	output.Lang = tx.lang
 
-		_, result, err := prompt.Run()
+	for {
+		action, err := tx.choose("menu.title",
+			"menu.list_records",
+			"menu.list_issues",
+			"menu.bulk_issue_status",
+			"menu.list_orders",
+			"menu.query_by_id",
+			"menu.insert_record",
+			"menu.token_status",
+			"menu.output_settings",
+			"menu.exit",
+		)
 		if err != nil {
-			fmt.Printf("Prompt failed: %v\n", err)
+			tx.say("msg.prompt_failed", err)
 			return
 		}
 
-		switch result {
-		case "List All Records":
-			listRecords(dataService)
-		case "List Issues":
-			listIssues(dataService)
-		case "List Orders":
-			listOrders(dataService)
-		case "Query by ID":
-			queryByID(dataService)
-		case "Insert New Record":
-			insertRecord(dataService)
-		case "Exit":
-			fmt.Println("Exiting...")
+		switch action {
+		case "menu.list_records":
+			listRecords(dataService, output, tx)
+		case "menu.list_issues":
+			listIssues(dataService, output, tx)
+		case "menu.bulk_issue_status":
+			bulkIssueStatus(dataService, output, tx)
+		case "menu.list_orders":
+			listOrders(dataService, output, tx)
+		case "menu.query_by_id":
+			queryByID(dataService, tx)
+		case "menu.insert_record":
+			insertRecord(dataService, tx)
+		case "menu.token_status":
+			tokenMenu(tokens, tenant.TokenUserID, tx)
+		case "menu.output_settings":
+			promptOutput(&output, tx)
+		case "menu.exit":
+			tx.say("msg.exiting")
 			return
 		}
 	}
 }
 
-func listRecords(dataService service.DataService) {
-	records, err := dataService.ListRecords()
+func listRecords(dataService service.DataService, output OutputOptions, tx texts) {
+	mode, err := tx.choose("records.mode", "records.show_all", "records.filter")
+	if err != nil {
+		tx.say("msg.prompt_failed", err)
+		return
+	}
+
+	var records []service.Data
+	if mode == "records.filter" {
+		filter, ok := promptRecordFilter(tx)
+		if !ok {
+			return
+		}
+		records, err = dataService.SearchRecords(filter)
+	} else {
+		records, err = dataService.ListRecords()
+	}
 	if err != nil {
-		fmt.Printf("Error fetching records: %v\n", err)
+		tx.say("records.fetch_failed", err)
 		return
 	}
 	if len(records) == 0 {
-		fmt.Println("No records found in the database")
+		tx.say("records.none")
 		return
 	}
+	tx.say("records.heading")
+	if err := output.write(os.Stdout, records, recordListing(records)); err != nil {
+		tx.say("msg.error", err)
+	}
+}
 
-	// Create table
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"ID", "UserID", "Type", "Details", "Status", "CreatedAt"})
-	table.SetBorder(true)
-	table.SetAutoWrapText(false)
-	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-	table.SetColumnSeparator("|")
-	table.SetCenterSeparator("+")
-	table.SetRowSeparator("-")
-
+// recordListing lays records out with their details as compact JSON, shortened in the table format
+func recordListing(records []service.Data) listing {
+	l := listing{header: []string{"ID", "UserID", "Type", "Details", "Status", "CreatedAt"}, shorten: []int{3}, status: 4}
 	for _, record := range records {
+		details := string(record.Details)
 		var detailsMap map[string]interface{}
-		if err := json.Unmarshal(record.Details, &detailsMap); err != nil {
-			fmt.Printf("Error unmarshaling details for record %d: %v\n", record.ID, err)
-			continue
+		if json.Unmarshal(record.Details, &detailsMap) == nil {
+			if compact, err := json.Marshal(detailsMap); err == nil {
+				details = string(compact)
+			}
 		}
-		details, err := json.Marshal(detailsMap)
-		if err != nil {
-			fmt.Printf("Error marshaling details for record %d: %v\n", record.ID, err)
-			continue
-		}
-		detailsStr := string(details)
-		if len(detailsStr) > 50 {
-			detailsStr = detailsStr[:47] + "..."
-		}
-		createdAtStr := record.CreatedAt.Format("2006-01-02 15:04:05")
-		table.Append([]string{
+		l.rows = append(l.rows, []string{
 			fmt.Sprintf("%d", record.ID),
 			fmt.Sprintf("%d", record.UserID),
 			record.Type,
-			detailsStr,
+			details,
 			record.Status,
-			createdAtStr,
+			record.CreatedAt.Format("2006-01-02 15:04:05"),
 		})
 	}
+	return l
+}
+
+// promptRecordFilter asks for optional filter values; empty answers are skipped
+func promptRecordFilter(tx texts) (service.RecordFilter, bool) {
+	var filter service.RecordFilter
+
+	ask := func(labelID string) (string, bool) {
+		value, err := tx.ask("filter.optional", "", tx.t(labelID))
+		if err != nil {
+			tx.say("msg.prompt_failed", err)
+			return "", false
+		}
+		return strings.TrimSpace(value), true
+	}
+
+	var ok bool
+	if filter.Type, ok = ask("filter.type"); !ok {
+		return filter, false
+	}
+	if filter.Status, ok = ask("filter.status"); !ok {
+		return filter, false
+	}
 
-	fmt.Println("\nRecords from chatbot.interactions:")
-	table.Render()
+	userIDStr, ok := ask("filter.user_id")
+	if !ok {
+		return filter, false
+	}
+	if userIDStr != "" {
+		userID, err := strconv.ParseUint(userIDStr, 10, 64)
+		if err != nil {
+			tx.say("msg.invalid_user_id")
+			return filter, false
+		}
+		id := uint(userID)
+		filter.UserID = &id
+	}
+
+	fromStr, ok := ask("filter.from")
+	if !ok {
+		return filter, false
+	}
+	from, err := parseFilterDate(fromStr, false)
+	if err != nil {
+		tx.say("msg.invalid_date")
+		return filter, false
+	}
+	filter.From = from
+
+	toStr, ok := ask("filter.to")
+	if !ok {
+		return filter, false
+	}
+	to, err := parseFilterDate(toStr, true)
+	if err != nil {
+		tx.say("msg.invalid_date")
+		return filter, false
+	}
+	filter.To = to
+
+	if filter.Text, ok = ask("filter.text"); !ok {
+		return filter, false
+	}
+	return filter, true
+}
+
+// parseFilterDate reads a YYYY-MM-DD filter answer in local time; nil when empty. An inclusive end
+// date becomes the start of the next day, since the filter's To bound is exclusive.
+func parseFilterDate(value string, inclusiveEnd bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	if inclusiveEnd {
+		date = date.AddDate(0, 0, 1)
+	}
+	return &date, nil
 }
 
-func listIssues(dataService service.DataService) {
+func listIssues(dataService service.DataService, output OutputOptions, tx texts) {
 	issues, err := dataService.ListIssues()
 	if err != nil {
-		fmt.Printf("Error fetching issues: %v\n", err)
+		tx.say("issues.fetch_failed", err)
 		return
 	}
 	if len(issues) == 0 {
-		fmt.Println("No issues found in the database")
+		tx.say("issues.none")
 		return
 	}
+	tx.say("issues.heading")
+	if err := output.write(os.Stdout, issues, issueListing(issues)); err != nil {
+		tx.say("msg.error", err)
+	}
+}
 
-	// Create table
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Type", "Name", "Product", "Description", "Phone Number", "Status", "CreatedAt"})
-	table.SetBorder(true)
-	table.SetAutoWrapText(false)
-	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-	table.SetColumnSeparator("|")
-	table.SetCenterSeparator("+")
-	table.SetRowSeparator("-")
-	table.SetColWidth(80)
-
+// issueListing lays issues out by their detail fields, shortening the description in the table format
+func issueListing(issues []service.Data) listing {
+	l := listing{header: []string{"Type", "Name", "Product", "Description", "Phone Number", "Status", "CreatedAt"}, shorten: []int{3}, status: 5}
 	for _, issue := range issues {
 		var detailsMap map[string]interface{}
 		if err := json.Unmarshal(issue.Details, &detailsMap); err != nil {
-			fmt.Printf("Error unmarshaling details for issue: %v\n", err)
-			continue
+			detailsMap = map[string]interface{}{"description": string(issue.Details)}
 		}
-		issueType := fmt.Sprintf("%v", detailsMap["type"])
-		name := fmt.Sprintf("%v", detailsMap["name"])
-		product := fmt.Sprintf("%v", detailsMap["product"])
-		description := fmt.Sprintf("%v", detailsMap["description"])
-		phoneNumber := fmt.Sprintf("%v", detailsMap["phone_number"])
-		detailStatus := fmt.Sprintf("%v", detailsMap["status"])
-		if len(description) > 60 {
-			description = description[:57] + "..."
-		}
-		createdAtStr := issue.CreatedAt.Format("2006-01-02 15:04:05")
-		table.Append([]string{
-			issueType,
-			name,
-			product,
-			description,
-			phoneNumber,
-			detailStatus,
-			createdAtStr,
+		l.rows = append(l.rows, []string{
+			fmt.Sprintf("%v", detailsMap["type"]),
+			fmt.Sprintf("%v", detailsMap["name"]),
+			fmt.Sprintf("%v", detailsMap["product"]),
+			fmt.Sprintf("%v", detailsMap["description"]),
+			fmt.Sprintf("%v", detailsMap["phone_number"]),
+			fmt.Sprintf("%v", detailsMap["status"]),
+			issue.CreatedAt.Format("2006-01-02 15:04:05"),
 		})
 	}
+	return l
+}
 
-	fmt.Println("\nIssues from chatbot.interactions:")
-	table.Render()
+// bulkIssueStatus lets the operator pick open issues one by one, then moves them all to a status at
+// once, e.g. to resolve the duplicate delivery complaints of one courier delay
+func bulkIssueStatus(dataService service.DataService, output OutputOptions, tx texts) {
+	issues, err := dataService.ListIssues()
+	if err != nil {
+		tx.say("issues.fetch_failed", err)
+		return
+	}
+	open := issues[:0]
+	for _, issue := range issues {
+		if issue.Status != service.StatusCompleted && issue.Status != service.StatusCancelled {
+			open = append(open, issue)
+		}
+	}
+	if len(open) == 0 {
+		tx.say("issues.none")
+		return
+	}
+
+	// promptui has no multi-select: each pick toggles an issue until the operator applies or cancels
+	selected := make([]bool, len(open))
+pick:
+	for {
+		count := 0
+		items := make([]string, 0, len(open)+2)
+		for i, issue := range open {
+			mark := "[ ]"
+			if selected[i] {
+				mark = "[x]"
+				count++
+			}
+			items = append(items, bidiSafe(fmt.Sprintf("%s #%d %s", mark, issue.ID, issueSummary(issue))))
+		}
+		items = append(items, tx.t("bulk.apply", count), tx.t("bulk.cancel"))
+		index, err := tx.selectIndex(tx.t("bulk.select"), items)
+		if err != nil {
+			tx.say("msg.prompt_failed", err)
+			return
+		}
+		switch index {
+		case len(open) + 1:
+			return
+		case len(open):
+			if count == 0 {
+				tx.say("bulk.none_selected")
+				continue
+			}
+			break pick
+		default:
+			selected[index] = !selected[index]
+		}
+	}
+
+	var ids []uint
+	for i, issue := range open {
+		if selected[i] {
+			ids = append(ids, issue.ID)
+		}
+	}
+	statuses := []string{service.StatusInProgress, service.StatusCompleted, service.StatusCancelled}
+	index, err := tx.selectIndex(tx.t("bulk.status", len(ids)), statuses)
+	if err != nil {
+		tx.say("msg.prompt_failed", err)
+		return
+	}
+	results, err := dataService.BulkUpdateStatus(ids, "issue", statuses[index], "console")
+	if err != nil && results == nil {
+		tx.say("bulk.failed", err)
+		return
+	}
+	if err != nil {
+		tx.say("bulk.rolled_back", err)
+	} else {
+		tx.say("bulk.updated", len(ids), statuses[index])
+	}
+	if err := output.write(os.Stdout, results, bulkStatusListing(results)); err != nil {
+		tx.say("msg.error", err)
+	}
+}
+
+// issueSummary is the name and description of an issue, for picking it in a menu
+func issueSummary(issue service.Data) string {
+	var details struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	json.Unmarshal(issue.Details, &details)
+	return shorten(strings.TrimSpace(details.Name+" - "+details.Description), 60)
 }
 
-func listOrders(dataService service.DataService) {
+// bulkStatusListing lays out the outcome of each record of a bulk status change
+func bulkStatusListing(results []service.BulkStatusResult) listing {
+	l := listing{header: []string{"ID", "Result", "From", "Error"}, shorten: []int{3}, status: 1}
+	for _, result := range results {
+		l.rows = append(l.rows, []string{fmt.Sprintf("%d", result.ID), result.Result, result.From, result.Error})
+	}
+	return l
+}
+
+func listOrders(dataService service.DataService, output OutputOptions, tx texts) {
 	// Prompt for query parameters
 	query := service.CustomerOrderQuery{}
 
-	pagePrompt := promptui.Prompt{
-		Label:   "Enter Page (default 1)",
-		Default: "1",
-	}
-	pageStr, err := pagePrompt.Run()
+	pageStr, err := tx.ask("orders.page", "1")
 	if err != nil {
-		fmt.Printf("Prompt failed: %v\n", err)
+		tx.say("msg.prompt_failed", err)
 		return
 	}
 	if pageStr != "" {
 		page, err := strconv.Atoi(pageStr)
 		if err != nil {
-			fmt.Println("Invalid page number")
+			tx.say("orders.invalid_page")
 			return
 		}
 		query.Page = page
@@ -180,19 +357,15 @@ func listOrders(dataService service.DataService) {
 		query.Page = 1
 	}
 
-	limitPrompt := promptui.Prompt{
-		Label:   "Enter Limit (default 10)",
-		Default: "10",
-	}
-	limitStr, err := limitPrompt.Run()
+	limitStr, err := tx.ask("orders.limit", "10")
 	if err != nil {
-		fmt.Printf("Prompt failed: %v\n", err)
+		tx.say("msg.prompt_failed", err)
 		return
 	}
 	if limitStr != "" {
 		limit, err := strconv.Atoi(limitStr)
 		if err != nil {
-			fmt.Println("Invalid limit number")
+			tx.say("orders.invalid_limit")
 			return
 		}
 		query.Limit = limit
@@ -200,12 +373,9 @@ func listOrders(dataService service.DataService) {
 		query.Limit = 10
 	}
 
-	statusPrompt := promptui.Prompt{
-		Label: "Enter Status (e.g., pending, shipped, optional)",
-	}
-	status, err := statusPrompt.Run()
+	status, err := tx.ask("orders.status", "")
 	if err != nil {
-		fmt.Printf("Prompt failed: %v\n", err)
+		tx.say("msg.prompt_failed", err)
 		return
 	}
 	query.Status = status
@@ -217,207 +387,206 @@ func listOrders(dataService service.DataService) {
 
 	orders, err := dataService.ListOrders(query)
 	if err != nil {
-		fmt.Printf("Error fetching orders: %v\n", err)
+		tx.say("orders.fetch_failed", err)
 		return
 	}
 	if len(orders) == 0 {
-		fmt.Println("No orders found")
+		tx.say("orders.none")
 		return
 	}
+	tx.say("orders.heading")
+	if err := output.write(os.Stdout, orders, orderListing(orders)); err != nil {
+		tx.say("msg.error", err)
+	}
+}
 
-	// Create table
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"ID", "Name", "Address", "Note", "Email", "Phone", "City", "Status", "CreatedAt"})
-	table.SetBorder(true)
-	table.SetAutoWrapText(false)
-	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-	table.SetColumnSeparator("|")
-	table.SetCenterSeparator("+")
-	table.SetRowSeparator("-")
-	table.SetColWidth(80)
-
+// orderListing lays orders out by their customer fields, shortening the address and note in the table format
+func orderListing(orders []service.Order) listing {
+	l := listing{header: []string{"ID", "Name", "Address", "Note", "Email", "Phone", "City", "Status", "CreatedAt"}, shorten: []int{2, 3}, status: 7}
 	for _, order := range orders {
-		address := order.Customer.Address
-		if len(address) > 60 {
-			address = address[:57] + "..."
-		}
-		createdAtStr := order.CreatedAt.Format("2006-01-02 15:04:05")
-		table.Append([]string{
+		l.rows = append(l.rows, []string{
 			order.ID,
 			order.Customer.Name,
-			address,
+			order.Customer.Address,
 			order.Customer.Note,
 			order.Customer.Email,
 			order.Customer.Phone,
 			order.Customer.City,
 			order.Status,
-			createdAtStr,
+			order.CreatedAt.Format("2006-01-02 15:04:05"),
 		})
 	}
-
-	fmt.Println("\nOrders from Converty.shop:")
-	table.Render()
+	return l
 }
 
-func queryByID(dataService service.DataService) {
-	prompt := promptui.Prompt{
-		Label: "Enter Record ID",
-	}
-
-	idStr, err := prompt.Run()
+func queryByID(dataService service.DataService, tx texts) {
+	idStr, err := tx.ask("query.id", "")
 	if err != nil {
-		fmt.Printf("Prompt failed: %v\n", err)
+		tx.say("msg.prompt_failed", err)
 		return
 	}
 
 	var id uint
 	_, err = fmt.Sscanf(idStr, "%d", &id)
 	if err != nil {
-		fmt.Println("Invalid ID format")
+		tx.say("msg.invalid_id")
 		return
 	}
 
 	record, err := dataService.QueryByID(id)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
+		tx.say("msg.error", err)
 		return
 	}
+	if err := printRecord(os.Stdout, record); err != nil {
+		tx.say("msg.error", err)
+	}
+}
 
+// printRecord writes one record with its details indented
+func printRecord(w io.Writer, record service.Data) error {
 	var detailsMap map[string]interface{}
 	if err := json.Unmarshal(record.Details, &detailsMap); err != nil {
-		fmt.Printf("Error unmarshaling details: %v\n", err)
-		return
+		return fmt.Errorf("failed to unmarshal details: %v", err)
 	}
 	details, err := json.MarshalIndent(detailsMap, "", "  ")
 	if err != nil {
-		fmt.Printf("Error marshaling details: %v\n", err)
-		return
+		return fmt.Errorf("failed to marshal details: %v", err)
 	}
-	fmt.Printf("ID: %d\nUserID: %d\nType: %s\nDetails: %s\nStatus: %s\nCreatedAt: %s\n",
+	fmt.Fprintf(w, "ID: %d\nUserID: %d\nType: %s\nDetails: %s\nStatus: %s\nCreatedAt: %s\n",
 		record.ID, record.UserID, record.Type, details, record.Status, record.CreatedAt)
+	return nil
 }
 
-func insertRecord(dataService service.DataService) {
-	userIDPrompt := promptui.Prompt{
-		Label: "Enter User ID",
-	}
-	userIDStr, err := userIDPrompt.Run()
+func insertRecord(dataService service.DataService, tx texts) {
+	userIDStr, err := tx.ask("insert.user_id", "")
 	if err != nil {
-		fmt.Printf("Prompt failed: %v\n", err)
+		tx.say("msg.prompt_failed", err)
 		return
 	}
 
 	var userID uint
 	_, err = fmt.Sscanf(userIDStr, "%d", &userID)
 	if err != nil {
-		fmt.Println("Invalid User ID format")
+		tx.say("msg.invalid_user_id")
 		return
 	}
 
-	typePrompt := promptui.Prompt{
-		Label: "Enter Table Type (address/order/issue)",
-	}
-	tableType, err := typePrompt.Run()
+	tableType, err := tx.ask("insert.type", "")
 	if err != nil {
-		fmt.Printf("Prompt failed: %v\n", err)
+		tx.say("msg.prompt_failed", err)
 		return
 	}
 
 	var details map[string]interface{}
 	if tableType == "issue" {
-		issueTypePrompt := promptui.Prompt{
-			Label: "Enter Issue Type (e.g., defective, delivery)",
-		}
-		issueType, err := issueTypePrompt.Run()
-		if err != nil {
-			fmt.Printf("Prompt failed: %v\n", err)
-			return
+		// The issue fields in the order they are asked for
+		fields := []struct{ key, labelID string }{
+			{"type", "insert.issue_type"},
+			{"name", "insert.name"},
+			{"product", "insert.product"},
+			{"description", "insert.description"},
+			{"phone_number", "insert.phone"},
+			{"status", "insert.detail_status"},
 		}
-
-		namePrompt := promptui.Prompt{
-			Label: "Enter Name",
+		details = map[string]interface{}{}
+		for _, field := range fields {
+			value, err := tx.ask(field.labelID, "")
+			if err != nil {
+				tx.say("msg.prompt_failed", err)
+				return
+			}
+			details[field.key] = value
 		}
-		name, err := namePrompt.Run()
-		if err != nil {
-			fmt.Printf("Prompt failed: %v\n", err)
-			return
-		}
-
-		productPrompt := promptui.Prompt{
-			Label: "Enter Product",
-		}
-		product, err := productPrompt.Run()
+	} else {
+		detailsStr, err := tx.ask("insert.details", "")
 		if err != nil {
-			fmt.Printf("Prompt failed: %v\n", err)
+			tx.say("msg.prompt_failed", err)
 			return
 		}
 
-		descPrompt := promptui.Prompt{
-			Label: "Enter Description",
-		}
-		description, err := descPrompt.Run()
-		if err != nil {
-			fmt.Printf("Prompt failed: %v\n", err)
+		if err := json.Unmarshal([]byte(detailsStr), &details); err != nil {
+			tx.say("insert.invalid_json", err)
 			return
 		}
+	}
 
-		phonePrompt := promptui.Prompt{
-			Label: "Enter Phone Number",
-		}
-		phoneNumber, err := phonePrompt.Run()
-		if err != nil {
-			fmt.Printf("Prompt failed: %v\n", err)
-			return
-		}
+	tableStatus, err := tx.ask("insert.status", "")
+	if err != nil {
+		tx.say("msg.prompt_failed", err)
+		return
+	}
 
-		detailStatusPrompt := promptui.Prompt{
-			Label: "Enter Detail Status (e.g., Pending, Resolved)",
-		}
-		detailStatus, err := detailStatusPrompt.Run()
-		if err != nil {
-			fmt.Printf("Prompt failed: %v\n", err)
-			return
-		}
+	_, err = dataService.InsertRecord(userID, tableType, details, tableStatus)
+	if err != nil {
+		tx.say("insert.failed", err)
+		return
+	}
 
-		details = map[string]interface{}{
-			"type":         issueType,
-			"name":         name,
-			"product":      product,
-			"description":  description,
-			"phone_number": phoneNumber,
-			"status":       detailStatus,
-		}
-	} else {
-		detailsPrompt := promptui.Prompt{
-			Label: "Enter JSON Details (e.g., {\"key\": \"value\"})",
-		}
-		detailsStr, err := detailsPrompt.Run()
-		if err != nil {
-			fmt.Printf("Prompt failed: %v\n", err)
-			return
-		}
+	tx.say("insert.created")
+}
 
-		if err := json.Unmarshal([]byte(detailsStr), &details); err != nil {
-			fmt.Printf("Invalid JSON format: %v\n", err)
-			return
-		}
+// promptOutput asks for the listing format, table width and colors; a cancelled prompt keeps the settings
+func promptOutput(output *OutputOptions, tx texts) {
+	index, err := tx.selectIndex(tx.t("output.format", output.Format), Formats)
+	if err != nil {
+		tx.say("msg.prompt_failed", err)
+		return
 	}
+	format := Formats[index]
 
-	statusPrompt := promptui.Prompt{
-		Label: "Enter Table Status (pending/completed)",
-	}
-	tableStatus, err := statusPrompt.Run()
+	widthStr, err := tx.ask("output.width", strconv.Itoa(output.Width))
 	if err != nil {
-		fmt.Printf("Prompt failed: %v\n", err)
+		tx.say("msg.prompt_failed", err)
+		return
+	}
+	width, err := strconv.Atoi(strings.TrimSpace(widthStr))
+	if err != nil || width < 0 {
+		tx.say("output.invalid_width")
 		return
 	}
 
-	_, err = dataService.InsertRecord(userID, tableType, details, tableStatus)
+	color, err := tx.choose("output.colors", "output.on", "output.off")
 	if err != nil {
-		fmt.Printf("Error inserting record: %v\n", err)
+		tx.say("msg.prompt_failed", err)
 		return
 	}
 
-	fmt.Println("Record created successfully!")
+	*output = OutputOptions{Format: format, Width: width, NoColor: color == "output.off", Lang: output.Lang}
+	tx.say("output.now", format)
+}
+
+// newTable creates a left-aligned bordered table writing to w
+func newTable(w io.Writer, header []string) *tablewriter.Table {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColumnSeparator("|")
+	table.SetCenterSeparator("+")
+	table.SetRowSeparator("-")
+	return table
+}
+
+// selectTenant prompts for the tenant to work on; single-shop deployments skip the prompt
+func selectTenant(tenantService service.TenantService, tx texts) (service.Tenant, error) {
+	tenants, err := tenantService.ListTenants()
+	if err != nil {
+		return service.Tenant{}, err
+	}
+	if len(tenants) == 0 {
+		return service.DefaultTenant, nil
+	}
+	tenants = append([]service.Tenant{service.DefaultTenant}, tenants...)
+	items := make([]string, len(tenants))
+	for i, tenant := range tenants {
+		items[i] = bidiSafe(fmt.Sprintf("%s (%s)", tenant.Slug, tenant.Name))
+	}
+	index, err := tx.selectIndex(tx.t("tenant.select"), items)
+	if err != nil {
+		return service.Tenant{}, err
+	}
+	return tenants[index], nil
 }
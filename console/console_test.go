@@ -0,0 +1,35 @@
+package console
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFilterDate(t *testing.T) {
+	for _, c := range []struct {
+		value        string
+		inclusiveEnd bool
+		want         string
+		wantErr      bool
+	}{
+		{"", false, "", false},
+		{"", true, "", false},
+		{"2025-05-01", false, "2025-05-01", false},
+		{"2025-05-01", true, "2025-05-02", false},
+		{"2025-12-31", true, "2026-01-01", false},
+		{"01/05/2025", false, "", true},
+		{"2025-02-30", true, "", true},
+	} {
+		got, err := parseFilterDate(c.value, c.inclusiveEnd)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parseFilterDate(%q, %v) error = %v", c.value, c.inclusiveEnd, err)
+			continue
+		}
+		switch {
+		case c.want == "" && got != nil:
+			t.Errorf("parseFilterDate(%q, %v) = %v, want nil", c.value, c.inclusiveEnd, got)
+		case c.want != "" && (got == nil || got.Format("2006-01-02") != c.want || got.Location() != time.Local || got.Hour() != 0):
+			t.Errorf("parseFilterDate(%q, %v) = %v, want local midnight of %s", c.value, c.inclusiveEnd, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package console
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/manifoldco/promptui"
+)
+
+// catalogFiles holds the console's labels and messages, one JSON catalog per language keyed like
+// locales/en.json. They are for the operators, apart from the customer-facing catalogs of the service.
+//
+//go:embed locales/*.json
+var catalogFiles embed.FS
+
+// defaultLang is the console language when none is chosen, and the fallback of missing messages
+const defaultLang = "en"
+
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	loaded := make(map[string]map[string]string)
+	entries, err := catalogFiles.ReadDir("locales")
+	if err != nil {
+		log.Fatalf("Failed to read console catalogs: %v", err)
+	}
+	for _, entry := range entries {
+		body, err := catalogFiles.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			log.Fatalf("Failed to read console catalog %s: %v", entry.Name(), err)
+		}
+		messages := make(map[string]string)
+		if err := json.Unmarshal(body, &messages); err != nil {
+			log.Fatalf("Invalid console catalog %s: %v", entry.Name(), err)
+		}
+		loaded[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+	return loaded
+}
+
+// Languages lists the console languages accepted by --lang
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// ParseLang returns the console language of a code such as "fr", "AR" or "fr-TN"; empty is English
+func ParseLang(code string) (string, error) {
+	lang := strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	if lang == "" {
+		return defaultLang, nil
+	}
+	if _, ok := catalogs[lang]; !ok {
+		return "", fmt.Errorf("unsupported language %q, use %s", code, strings.Join(Languages(), ", "))
+	}
+	return lang, nil
+}
+
+// texts are the console messages in one language
+type texts struct {
+	lang     string
+	messages map[string]string
+}
+
+// textsFor returns the messages of lang, English for an unknown or empty one
+func textsFor(lang string) texts {
+	if _, ok := catalogs[lang]; !ok {
+		lang = defaultLang
+	}
+	return texts{lang: lang, messages: catalogs[lang]}
+}
+
+// t returns the message with the given ID formatted with args, falling back to English and then to the ID
+func (tx texts) t(id string, args ...interface{}) string {
+	message, ok := tx.messages[id]
+	if !ok {
+		message, ok = catalogs[defaultLang][id]
+	}
+	if !ok {
+		message = id
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// say writes the message with the given ID to stdout
+func (tx texts) say(id string, args ...interface{}) {
+	fmt.Println(tx.t(id, args...))
+}
+
+// column translates a listing header, e.g. "Phone Number" by "column.phone_number"
+func (tx texts) column(header string) string {
+	id := "column." + strings.ReplaceAll(strings.ToLower(header), " ", "_")
+	if message, ok := tx.messages[id]; ok {
+		return message
+	}
+	return header
+}
+
+// choose shows a menu of the messages with the given IDs and returns the ID chosen
+func (tx texts) choose(labelID string, itemIDs ...string) (string, error) {
+	items := make([]string, len(itemIDs))
+	for i, id := range itemIDs {
+		items[i] = bidiSafe(tx.t(id))
+	}
+	index, err := tx.selectIndex(tx.t(labelID), items)
+	if err != nil {
+		return "", err
+	}
+	return itemIDs[index], nil
+}
+
+// selectIndex shows a menu of items under label, with the navigation help in the console language
+func (tx texts) selectIndex(label string, items []string) (int, error) {
+	prompt := promptui.Select{
+		Label: bidiSafe(label),
+		Items: items,
+		Templates: &promptui.SelectTemplates{
+			Help: fmt.Sprintf(`{{ %q | faint }} {{ .NextKey | faint }} {{ .PrevKey | faint }} {{ .PageDownKey | faint }} {{ .PageUpKey | faint }}`,
+				tx.t("prompt.help")),
+		},
+	}
+	index, _, err := prompt.Run()
+	return index, err
+}
+
+// ask prompts for a value under the message with the given ID; def is the value kept on enter
+func (tx texts) ask(labelID, def string, args ...interface{}) (string, error) {
+	prompt := promptui.Prompt{Label: bidiSafe(tx.t(labelID, args...)), Default: def}
+	return prompt.Run()
+}
+
+// bidiSafe keeps text holding right-to-left letters, such as an Arabic customer name, from reordering
+// what surrounds it: the left-to-right marks around it hold the table borders and prompt punctuation in
+// place on bidi-aware terminals. The marks have no width, so the table padding is unchanged.
+func bidiSafe(s string) string {
+	for _, r := range s {
+		if unicode.In(r, unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana) {
+			return "\u200e" + s + "\u200e"
+		}
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package console
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+func TestCatalogsComplete(t *testing.T) {
+	for _, lang := range Languages() {
+		for id := range catalogs[defaultLang] {
+			if _, ok := catalogs[lang][id]; !ok {
+				t.Errorf("%s catalog lacks %s", lang, id)
+			}
+		}
+		for id := range catalogs[lang] {
+			if _, ok := catalogs[defaultLang][id]; !ok && !strings.HasPrefix(id, "column.") {
+				t.Errorf("%s catalog has unknown message %s", lang, id)
+			}
+		}
+	}
+}
+
+func TestParseLang(t *testing.T) {
+	for code, want := range map[string]string{"": "en", "fr": "fr", "AR": "ar", "fr-TN": "fr", "ar_TN": "ar"} {
+		if lang, err := ParseLang(code); err != nil || lang != want {
+			t.Errorf("ParseLang(%q) = %q, %v, want %q", code, lang, err, want)
+		}
+	}
+	if _, err := ParseLang("de"); err == nil {
+		t.Error("unsupported language accepted")
+	}
+	if got := textsFor("fr").t("msg.error", "boom"); got != "Erreur : boom" {
+		t.Errorf("French message = %q", got)
+	}
+	// Messages missing from a catalog fall back to English, unknown ones to their ID
+	if got := textsFor("xx").t("menu.exit"); got != "Exit" {
+		t.Errorf("fallback = %q", got)
+	}
+}
+
+func TestOutputRightToLeft(t *testing.T) {
+	issues := testIssues()
+	issues[0].Details = []byte(`{"type": "delivery", "name": "سامي بن علي", "product": "Blender", "phone_number": "+216 98 111 222", "status": "Pending"}`)
+	var out bytes.Buffer
+	if err := (OutputOptions{Format: FormatTable, Width: 100, NoColor: true, Lang: "ar"}).write(&out, nil, issueListing(issues)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "\u200eسامي بن علي\u200e") || !strings.Contains(out.String(), "رقم الهاتف") {
+		t.Errorf("Arabic name or header not marked:\n%s", out.String())
+	}
+	// The marks take no room, so every line keeps the table's width
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	for _, line := range lines {
+		if tablewriter.DisplayWidth(line) != tablewriter.DisplayWidth(lines[0]) {
+			t.Errorf("misaligned line %q", line)
+		}
+	}
+
+	// CSV keeps the English headers and the names unmarked
+	out.Reset()
+	(OutputOptions{Format: FormatCSV, Lang: "ar"}).write(&out, nil, issueListing(issues))
+	if !strings.HasPrefix(out.String(), "Type,Name") || strings.Contains(out.String(), "\u200e") {
+		t.Errorf("unexpected CSV:\n%s", out.String())
+	}
+	if (OutputOptions{Format: FormatTable, Lang: "de"}).Validate() == nil {
+		t.Error("unsupported language accepted")
+	}
+}
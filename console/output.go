@@ -0,0 +1,233 @@
+package console
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Output formats of the console listings
+const (
+	// FormatTable is a bordered table with the long columns shortened to fit the width
+	FormatTable = "table"
+	// FormatWide is the table without shortening anything
+	FormatWide = "wide"
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// Formats are the output formats accepted by --format
+var Formats = []string{FormatTable, FormatWide, FormatCSV, FormatJSON}
+
+// defaultWidth is the table width when $COLUMNS does not say
+const defaultWidth = 120
+
+// minColumnWidth is the narrowest a shortened column gets, whatever the width
+const minColumnWidth = 12
+
+// OutputOptions chooses how listings are written
+type OutputOptions struct {
+	Format string
+	// Width is the width the table format fits into; 0 reads $COLUMNS
+	Width int
+	// NoColor leaves out the status colors, as does a set NO_COLOR
+	NoColor bool
+	// Lang translates the table headers (see Languages); CSV and JSON keep theirs for scripts
+	Lang string
+}
+
+// DefaultOutput is a colored table fitting the terminal
+func DefaultOutput() OutputOptions {
+	return OutputOptions{Format: FormatTable, NoColor: os.Getenv("NO_COLOR") != ""}
+}
+
+// Validate rejects an unknown format or language or a negative width
+func (o OutputOptions) Validate() error {
+	if o.Lang != "" {
+		if _, ok := catalogs[o.Lang]; !ok {
+			return fmt.Errorf("unsupported language %q, use %s", o.Lang, strings.Join(Languages(), ", "))
+		}
+	}
+	for _, format := range Formats {
+		if o.Format == format {
+			if o.Width < 0 {
+				return fmt.Errorf("invalid width %d", o.Width)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q, use %s", o.Format, strings.Join(Formats, ", "))
+}
+
+// width is the table width in force
+func (o OutputOptions) width() int {
+	if o.Width > 0 {
+		return o.Width
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return defaultWidth
+}
+
+// listing is tabular output: a header and the cells of each row
+type listing struct {
+	header []string
+	rows   [][]string
+	// shorten are the columns cut to fit the width in the table format, e.g. descriptions
+	shorten []int
+	// status is the column colored by value, -1 for none
+	status int
+}
+
+// write outputs a listing of v: v itself as JSON, the cells otherwise
+func (o OutputOptions) write(w io.Writer, v interface{}, l listing) error {
+	switch o.Format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(l.header)
+		writer.WriteAll(l.rows)
+		return writer.Error()
+	case FormatWide:
+		o.render(w, o.translate(l))
+		return nil
+	default:
+		o.render(w, shortenListing(o.translate(l), o.width()))
+		return nil
+	}
+}
+
+// translate returns the listing with its headers in the output language
+func (o OutputOptions) translate(l listing) listing {
+	if o.Lang == "" || o.Lang == defaultLang {
+		return l
+	}
+	tx := textsFor(o.Lang)
+	header := make([]string, len(l.header))
+	for i, h := range l.header {
+		header[i] = tx.column(h)
+	}
+	l.header = header
+	return l
+}
+
+// render draws the listing as a table. Cells in right-to-left scripts, such as Arabic customer names,
+// are marked so terminals keep them inside their column.
+func (o OutputOptions) render(w io.Writer, l listing) {
+	header := make([]string, len(l.header))
+	for i, h := range l.header {
+		header[i] = bidiSafe(h)
+	}
+	table := newTable(w, header)
+	for _, row := range l.rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = bidiSafe(cell)
+		}
+		if o.NoColor || l.status < 0 || l.status >= len(row) {
+			table.Append(cells)
+			continue
+		}
+		colors := make([]tablewriter.Colors, len(row))
+		colors[l.status] = statusColor(row[l.status])
+		table.Rich(cells, colors)
+	}
+	table.Render()
+}
+
+// statusColor is green for finished work, red for failures and yellow for work still open
+func statusColor(status string) tablewriter.Colors {
+	status = strings.ToLower(status)
+	for _, word := range []string{"fail", "cancel", "reject", "return", "invalid"} {
+		if strings.Contains(status, word) {
+			return tablewriter.Colors{tablewriter.FgRedColor}
+		}
+	}
+	for _, word := range []string{"resolved", "completed", "delivered", "shipped", "refreshed", "paid"} {
+		if strings.Contains(status, word) {
+			return tablewriter.Colors{tablewriter.FgGreenColor}
+		}
+	}
+	for _, word := range []string{"pending", "progress", "open", "new"} {
+		if strings.Contains(status, word) {
+			return tablewriter.Colors{tablewriter.FgYellowColor}
+		}
+	}
+	return nil
+}
+
+// shortenListing cuts the shortenable columns so the table fits width. The other columns keep their
+// full text, so phone numbers and IDs stay readable; the space left is shared among the shortened
+// columns, a narrow one giving what it does not need to the wider ones.
+func shortenListing(l listing, width int) listing {
+	if len(l.shorten) == 0 {
+		return l
+	}
+	natural := make([]int, len(l.header))
+	for i, cell := range l.header {
+		natural[i] = tablewriter.DisplayWidth(cell)
+	}
+	for _, row := range l.rows {
+		for i, cell := range row {
+			if i < len(natural) && tablewriter.DisplayWidth(cell) > natural[i] {
+				natural[i] = tablewriter.DisplayWidth(cell)
+			}
+		}
+	}
+	// Each column adds a separator and two spaces of padding, the border one more character
+	remaining := width - 1 - 3*len(natural)
+	shortened := map[int]bool{}
+	for _, i := range l.shorten {
+		shortened[i] = true
+	}
+	for i, w := range natural {
+		if !shortened[i] {
+			remaining -= w
+		}
+	}
+
+	columns := append([]int(nil), l.shorten...)
+	sort.Slice(columns, func(a, b int) bool { return natural[columns[a]] < natural[columns[b]] })
+	limits := map[int]int{}
+	for n, i := range columns {
+		share := remaining / (len(columns) - n)
+		limit := max(min(natural[i], share), minColumnWidth, tablewriter.DisplayWidth(l.header[i]))
+		limits[i] = limit
+		remaining -= limit
+	}
+
+	rows := make([][]string, len(l.rows))
+	for r, row := range l.rows {
+		rows[r] = append([]string(nil), row...)
+		for i, limit := range limits {
+			if i < len(row) {
+				rows[r][i] = shorten(row[i], limit)
+			}
+		}
+	}
+	l.rows = rows
+	return l
+}
+
+// shorten cuts s to limit characters, ending it with "..."
+func shorten(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	if limit <= 3 {
+		return string(runes[:limit])
+	}
+	return string(runes[:limit-3]) + "..."
+}
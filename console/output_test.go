@@ -0,0 +1,83 @@
+package console
+
+import (
+	"bytes"
+	"convertyApi/service"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testIssues() []service.Data {
+	return []service.Data{{
+		ID:        7,
+		Details:   []byte(`{"type": "delivery", "name": "Sami", "product": "Blender", "phone_number": "+216 98 111 222", "status": "Pending", "description": "` + strings.Repeat("The parcel never arrived at the relay point. ", 4) + `"}`),
+		CreatedAt: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+	}}
+}
+
+func TestOutputTableFitsWidth(t *testing.T) {
+	var out bytes.Buffer
+	output := OutputOptions{Format: FormatTable, Width: 110, NoColor: true}
+	if err := output.write(&out, nil, issueListing(testIssues())); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if len(line) > 110 {
+			t.Errorf("line of %d characters exceeds the width: %q", len(line), line)
+		}
+	}
+	if !strings.Contains(out.String(), "+216 98 111 222") || !strings.Contains(out.String(), "...") {
+		t.Errorf("expected the full phone number and a shortened description:\n%s", out.String())
+	}
+
+	out.Reset()
+	output.Format = FormatWide
+	output.write(&out, nil, issueListing(testIssues()))
+	if strings.Contains(out.String(), "...") {
+		t.Errorf("wide format shortened a column:\n%s", out.String())
+	}
+}
+
+func TestOutputCSVAndJSON(t *testing.T) {
+	issues := testIssues()
+	var out bytes.Buffer
+	if err := (OutputOptions{Format: FormatCSV}).write(&out, issues, issueListing(issues)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || lines[0] != "Type,Name,Product,Description,Phone Number,Status,CreatedAt" ||
+		!strings.Contains(lines[1], "relay point. The parcel") || strings.Contains(out.String(), "\x1b[") {
+		t.Errorf("unexpected CSV:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := (OutputOptions{Format: FormatJSON}).write(&out, issues, issueListing(issues)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "[") || !strings.Contains(out.String(), `"id": 7`) {
+		t.Errorf("unexpected JSON:\n%s", out.String())
+	}
+}
+
+func TestOutputColors(t *testing.T) {
+	var out bytes.Buffer
+	(OutputOptions{Format: FormatTable}).write(&out, nil, issueListing(testIssues()))
+	if !strings.Contains(out.String(), "\x1b[33mPending\x1b[0m") {
+		t.Errorf("pending status not colored:\n%q", out.String())
+	}
+	if len(statusColor("Resolved")) == 0 || len(statusColor("payment_failed")) == 0 || statusColor("archived") != nil {
+		t.Error("unexpected status colors")
+	}
+}
+
+func TestOutputValidate(t *testing.T) {
+	for _, format := range Formats {
+		if err := (OutputOptions{Format: format}).Validate(); err != nil {
+			t.Errorf("%s rejected: %v", format, err)
+		}
+	}
+	if (OutputOptions{Format: "yaml"}).Validate() == nil || (OutputOptions{Format: FormatTable, Width: -1}).Validate() == nil {
+		t.Error("invalid options accepted")
+	}
+}
@@ -0,0 +1,384 @@
+package console
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknownCommand is returned by Exec for a command it does not know
+var ErrUnknownCommand = errors.New("unknown console command")
+
+// scriptEnv is what a scripted command works with
+type scriptEnv struct {
+	data   service.DataService
+	tokens TokenManager
+	tenant service.Tenant
+	output OutputOptions
+	out    io.Writer
+}
+
+// scriptCommand is one console operation runnable without prompts
+type scriptCommand struct {
+	usage string
+	// flags declares the command's options on fs and returns the function running it once they are parsed
+	flags func(fs *flag.FlagSet) func(env scriptEnv) error
+}
+
+// scriptCommands are the operations of the menu, by command name
+var scriptCommands = map[string]scriptCommand{
+	"list-records": {
+		usage: "list records, optionally filtered",
+		flags: func(fs *flag.FlagSet) func(env scriptEnv) error {
+			recordType := fs.String("type", "", "record type (address/order/issue)")
+			status := fs.String("status", "", "record status")
+			userID := fs.Uint("user-id", 0, "records of this user")
+			from := fs.String("from", "", "created on or after this date (YYYY-MM-DD)")
+			to := fs.String("to", "", "created on or before this date, inclusive (YYYY-MM-DD)")
+			search := fs.String("search", "", "search details (text or key=value)")
+			return func(env scriptEnv) error {
+				filter := service.RecordFilter{Type: *recordType, Status: *status, Text: *search}
+				if *userID != 0 {
+					id := *userID
+					filter.UserID = &id
+				}
+				var err error
+				if filter.From, err = parseScriptDate(*from, 0); err != nil {
+					return err
+				}
+				if filter.To, err = parseScriptDate(*to, 1); err != nil {
+					return err
+				}
+				records, err := env.data.SearchRecords(filter)
+				if err != nil {
+					return fmt.Errorf("failed to fetch records: %v", err)
+				}
+				return env.output.write(env.out, records, recordListing(records))
+			}
+		},
+	},
+	"list-issues": {
+		usage: "list open issues",
+		flags: func(fs *flag.FlagSet) func(env scriptEnv) error {
+			status := fs.String("status", "", "issue status, as stored in the details (e.g. Pending)")
+			return func(env scriptEnv) error {
+				issues, err := env.data.ListIssues()
+				if err != nil {
+					return fmt.Errorf("failed to fetch issues: %v", err)
+				}
+				if *status != "" {
+					issues = filterIssues(issues, *status)
+				}
+				return env.output.write(env.out, issues, issueListing(issues))
+			}
+		},
+	},
+	"set-issue-status": {
+		usage: "move several issues to a status at once, all or none",
+		flags: func(fs *flag.FlagSet) func(env scriptEnv) error {
+			ids := fs.String("ids", "", "comma-separated issue IDs, e.g. 12,15,18")
+			status := fs.String("status", "", "target status (in_progress/completed/cancelled)")
+			actor := fs.String("actor", "console", "who made the change, for the status history")
+			return func(env scriptEnv) error {
+				issueIDs, err := parseIDList(*ids)
+				if err != nil {
+					return err
+				}
+				if *status == "" {
+					return errors.New("--status is required")
+				}
+				results, err := env.data.BulkUpdateStatus(issueIDs, "issue", *status, *actor)
+				if results == nil {
+					return err
+				}
+				if writeErr := env.output.write(env.out, results, bulkStatusListing(results)); writeErr != nil {
+					return writeErr
+				}
+				return err
+			}
+		},
+	},
+	"list-orders": {
+		usage: "list Converty orders",
+		flags: func(fs *flag.FlagSet) func(env scriptEnv) error {
+			page := fs.Int("page", 1, "page number")
+			limit := fs.Int("limit", 10, "orders per page")
+			status := fs.String("status", "", "order status (e.g. pending, shipped)")
+			archived := fs.Bool("archived", false, "list archived orders")
+			return func(env scriptEnv) error {
+				orders, err := env.data.ListOrders(service.CustomerOrderQuery{
+					Page: *page, Limit: *limit, Status: *status, Archived: archived,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to fetch orders: %v", err)
+				}
+				return env.output.write(env.out, orders, orderListing(orders))
+			}
+		},
+	},
+	"get-record": {
+		usage: "show one record",
+		flags: func(fs *flag.FlagSet) func(env scriptEnv) error {
+			id := fs.Uint("id", 0, "record ID")
+			return func(env scriptEnv) error {
+				if *id == 0 {
+					return errors.New("--id is required")
+				}
+				record, err := env.data.QueryByID(*id)
+				if err != nil {
+					return err
+				}
+				if env.output.Format == FormatJSON {
+					return env.write(record, nil)
+				}
+				return printRecord(env.out, record)
+			}
+		},
+	},
+	"insert-record": {
+		usage: "create a record",
+		flags: func(fs *flag.FlagSet) func(env scriptEnv) error {
+			userID := fs.Uint("user-id", 0, "user the record belongs to")
+			recordType := fs.String("type", "", "record type (address/order/issue)")
+			details := fs.String("details", "{}", "JSON details")
+			status := fs.String("status", "pending", "record status")
+			return func(env scriptEnv) error {
+				if *recordType == "" {
+					return errors.New("--type is required")
+				}
+				var detailsMap map[string]interface{}
+				if err := json.Unmarshal([]byte(*details), &detailsMap); err != nil {
+					return fmt.Errorf("invalid JSON details: %v", err)
+				}
+				record, err := env.data.InsertRecord(*userID, *recordType, detailsMap, *status)
+				if err != nil {
+					return fmt.Errorf("failed to insert record: %v", err)
+				}
+				if env.output.Format == FormatJSON {
+					return env.write(record, nil)
+				}
+				fmt.Fprintf(env.out, "Record %d created successfully!\n", record.ID)
+				return nil
+			}
+		},
+	},
+	"token-status": {
+		usage: "show the tenant's Converty token",
+		flags: func(fs *flag.FlagSet) func(env scriptEnv) error {
+			return func(env scriptEnv) error {
+				status, err := env.tokens.TokenStatus(env.tenant.TokenUserID)
+				if err != nil {
+					return err
+				}
+				return env.write(status, func() { printTokenStatus(env.out, status, time.Now()) })
+			}
+		},
+	},
+	"token-refresh": {
+		usage: "refresh the tenant's Converty token now",
+		flags: func(fs *flag.FlagSet) func(env scriptEnv) error {
+			return func(env scriptEnv) error {
+				status, err := env.tokens.RefreshToken(env.tenant.TokenUserID)
+				if err != nil {
+					return fmt.Errorf("refresh failed: %v", err)
+				}
+				return env.write(status, func() { printTokenStatus(env.out, status, time.Now()) })
+			}
+		},
+	},
+	"login-url": {
+		usage: "print the URL re-authorizing the tenant's Converty store",
+		flags: func(fs *flag.FlagSet) func(env scriptEnv) error {
+			return func(env scriptEnv) error {
+				loginURL, err := env.tokens.LoginURL(env.tenant.TokenUserID)
+				if err != nil {
+					return err
+				}
+				return env.write(map[string]string{"login_url": loginURL}, func() { fmt.Fprintln(env.out, loginURL) })
+			}
+		},
+	},
+}
+
+// Exec runs one console command without prompts, e.g. `list-issues --status Pending --format json`,
+// writing its output to out. Every command accepts --tenant (a tenant slug, default the default tenant),
+// --format (table, wide, csv or json), --width (the table width, $COLUMNS by default), --no-color and
+// --lang (the language of the table headers, $CONSOLE_LANG by default).
+func Exec(dataService service.DataService, tenantService service.TenantService, tokens TokenManager, commandLine string, out io.Writer) error {
+	args, err := splitCommand(commandLine)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || args[0] == "help" {
+		printScriptUsage(out)
+		return nil
+	}
+	command, ok := scriptCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("%w %q, run \"help\" for the list", ErrUnknownCommand, args[0])
+	}
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	tenantSlug := fs.String("tenant", service.DefaultTenant.Slug, "tenant slug")
+	format := fs.String("format", FormatTable, "output format: "+strings.Join(Formats, ", "))
+	width := fs.Int("width", 0, "table width, $COLUMNS or 120 when 0")
+	noColor := fs.Bool("no-color", os.Getenv("NO_COLOR") != "", "leave out the status colors")
+	langCode := fs.String("lang", os.Getenv("CONSOLE_LANG"), "table header language: "+strings.Join(Languages(), ", "))
+	run := command.flags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	lang, err := ParseLang(*langCode)
+	if err != nil {
+		return err
+	}
+	output := OutputOptions{Format: *format, Width: *width, NoColor: *noColor, Lang: lang}
+	if err := output.Validate(); err != nil {
+		return err
+	}
+
+	tenant := service.DefaultTenant
+	if *tenantSlug != service.DefaultTenant.Slug {
+		if tenant, err = tenantService.GetTenant(*tenantSlug); err != nil {
+			return err
+		}
+	}
+	return run(scriptEnv{
+		data:   dataService.ForTenant(tenant).WithPriority(service.PriorityInteractive),
+		tokens: tokens,
+		tenant: tenant,
+		output: output,
+		out:    out,
+	})
+}
+
+// write outputs v as indented JSON in the json format or without table, and runs table otherwise
+func (env scriptEnv) write(v interface{}, table func()) error {
+	if env.output.Format == FormatJSON || table == nil {
+		encoder := json.NewEncoder(env.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+	table()
+	return nil
+}
+
+// filterIssues keeps the issues whose details status matches status, ignoring case
+func filterIssues(issues []service.Data, status string) []service.Data {
+	matching := []service.Data{}
+	for _, issue := range issues {
+		var details struct {
+			Status string `json:"status"`
+		}
+		if json.Unmarshal(issue.Details, &details) == nil && strings.EqualFold(details.Status, status) {
+			matching = append(matching, issue)
+		}
+	}
+	return matching
+}
+
+// parseIDList parses comma-separated record IDs
+func parseIDList(value string) ([]uint, error) {
+	var ids []uint
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(field, 10, 64)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid ID %q in --ids", field)
+		}
+		ids = append(ids, uint(id))
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("--ids is required")
+	}
+	return ids, nil
+}
+
+// parseScriptDate parses an optional YYYY-MM-DD date, shifted by days
+func parseScriptDate(value string, days int) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q, use YYYY-MM-DD", value)
+	}
+	date = date.AddDate(0, 0, days)
+	return &date, nil
+}
+
+// splitCommand splits a command line into words; single and double quotes group words
+// and a backslash escapes the next character outside single quotes
+func splitCommand(line string) ([]string, error) {
+	var (
+		args    []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape in command")
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// printScriptUsage lists the scripted commands
+func printScriptUsage(out io.Writer) {
+	names := make([]string, 0, len(scriptCommands))
+	for name := range scriptCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(out, "Commands (each accepts --tenant <slug>, --format table|wide|csv|json, --width <columns>, --no-color and --lang en|fr|ar; run <command> --help for its options):")
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-17s %s\n", name, scriptCommands[name].usage)
+	}
+}
@@ -0,0 +1,45 @@
+package console
+
+import (
+	"convertyApi/service"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	args, err := splitCommand(`insert-record --type issue --details '{"name": "Sami Ben Ali"}' --status "in progress" a\ b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"insert-record", "--type", "issue", "--details", `{"name": "Sami Ben Ali"}`, "--status", "in progress", "a b"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("splitCommand = %q, want %q", args, want)
+	}
+	if _, err := splitCommand(`list-issues --status "Pending`); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
+
+func TestFilterIssues(t *testing.T) {
+	issues := []service.Data{
+		{ID: 1, Details: []byte(`{"status": "Pending"}`)},
+		{ID: 2, Details: []byte(`{"status": "Resolved"}`)},
+		{ID: 3, Details: []byte(`{"status": "pending"}`)},
+	}
+	matching := filterIssues(issues, "PENDING")
+	if len(matching) != 2 || matching[0].ID != 1 || matching[1].ID != 3 {
+		t.Errorf("filterIssues = %+v", matching)
+	}
+}
+
+func TestParseIDList(t *testing.T) {
+	ids, err := parseIDList(" 12, 15,,18 ")
+	if err != nil || !reflect.DeepEqual(ids, []uint{12, 15, 18}) {
+		t.Errorf("parseIDList = %v, %v", ids, err)
+	}
+	for _, value := range []string{"", "12,abc", "0"} {
+		if _, err := parseIDList(value); err == nil {
+			t.Errorf("parseIDList(%q) accepted", value)
+		}
+	}
+}
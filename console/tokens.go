@@ -0,0 +1,169 @@
+package console
+
+import (
+	"convertyApi/service"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// TokenStatus is what the console shows of a stored Converty token; the token values are never shown
+type TokenStatus struct {
+	UserID           string    `json:"user_id"`
+	IssuedAt         time.Time `json:"issued_at"`
+	AccessExpiresAt  time.Time `json:"access_expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	Scopes           []string  `json:"scopes"`
+	MissingScopes    []string  `json:"missing_scopes,omitempty"`
+	Invalid          bool      `json:"invalid"`
+	InvalidReason    string    `json:"invalid_reason,omitempty"`
+}
+
+// TokenManager inspects and renews the stored Converty tokens
+type TokenManager interface {
+	TokenStatus(userID string) (TokenStatus, error)
+	// RefreshToken runs a refresh grant now, whatever the access expiry
+	RefreshToken(userID string) (TokenStatus, error)
+	// LoginURL is where the operator re-authorizes the user's Converty store
+	LoginURL(userID string) (string, error)
+	// UserIDs lists the users having a stored token
+	UserIDs() ([]string, error)
+}
+
+// tokenMenu shows the tenant's token and offers to refresh it or print the login URL
+func tokenMenu(tokens TokenManager, userID string, tx texts) {
+	status, err := tokens.TokenStatus(userID)
+	if err != nil {
+		tx.say("msg.error", err)
+	} else {
+		printTokenStatus(os.Stdout, status, time.Now())
+	}
+
+	for {
+		action, err := tx.choose("token.action", "token.refresh", "token.login_url", "token.back")
+		if err != nil {
+			tx.say("msg.prompt_failed", err)
+			return
+		}
+
+		switch action {
+		case "token.refresh":
+			status, err := tokens.RefreshToken(userID)
+			if err != nil {
+				tx.say("token.refresh_failed", err)
+				continue
+			}
+			tx.say("token.refreshed")
+			printTokenStatus(os.Stdout, status, time.Now())
+		case "token.login_url":
+			loginURL, err := tokens.LoginURL(userID)
+			if err != nil {
+				tx.say("msg.error", err)
+				continue
+			}
+			tx.say("token.open_url", userID, loginURL)
+		case "token.back":
+			return
+		}
+	}
+}
+
+// printTokenStatus writes the expiries of a token relative to now to w
+func printTokenStatus(w io.Writer, status TokenStatus, now time.Time) {
+	fmt.Fprintf(w, "User:            %s\n", status.UserID)
+	fmt.Fprintf(w, "Issued:          %s\n", formatTokenTime(status.IssuedAt, now))
+	fmt.Fprintf(w, "Access expires:  %s\n", formatTokenTime(status.AccessExpiresAt, now))
+	fmt.Fprintf(w, "Refresh expires: %s\n", formatTokenTime(status.RefreshExpiresAt, now))
+	fmt.Fprintf(w, "Scopes:          %s\n", strings.Join(status.Scopes, " "))
+	if len(status.MissingScopes) > 0 {
+		fmt.Fprintf(w, "Missing scopes:  %s\n", strings.Join(status.MissingScopes, " "))
+	}
+	if status.Invalid {
+		fmt.Fprintf(w, "INVALID:         %s (re-authentication required)\n", status.InvalidReason)
+	}
+}
+
+// formatTokenTime shows a timestamp with how long ago or from now it is
+func formatTokenTime(t, now time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	delta := t.Sub(now).Round(time.Second)
+	switch {
+	case delta > 0:
+		return fmt.Sprintf("%s (in %s)", t.Format(time.RFC3339), delta)
+	case delta < 0:
+		return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), -delta)
+	default:
+		return t.Format(time.RFC3339) + " (now)"
+	}
+}
+
+// TokenRefreshResult is the outcome of refreshing one stored token in RefreshAllTokens
+type TokenRefreshResult struct {
+	UserID          string     `json:"user_id"`
+	Refreshed       bool       `json:"refreshed"`
+	AccessExpiresAt *time.Time `json:"access_expires_at,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// RefreshAllTokens runs a refresh grant for every stored token, e.g. after the service was down for
+// longer than the access token lifetime. It writes a summary to out in the output format and reports the
+// failures to notifier in one notification; the returned error counts the failed refreshes.
+func RefreshAllTokens(tokens TokenManager, notifier service.Notifier, out io.Writer, output OutputOptions) ([]TokenRefreshResult, error) {
+	userIDs, err := tokens.UserIDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored tokens: %v", err)
+	}
+	results := make([]TokenRefreshResult, 0, len(userIDs))
+	failures := map[string]interface{}{}
+	for _, userID := range userIDs {
+		result := TokenRefreshResult{UserID: userID}
+		status, err := tokens.RefreshToken(userID)
+		if err != nil {
+			result.Error = err.Error()
+			failures[userID] = result.Error
+		} else {
+			result.Refreshed = true
+			result.AccessExpiresAt = &status.AccessExpiresAt
+		}
+		results = append(results, result)
+	}
+
+	if err := output.write(out, results, refreshListing(results)); err != nil {
+		return results, err
+	}
+	if output.Format == FormatTable || output.Format == FormatWide {
+		refreshed := len(results) - len(failures)
+		fmt.Fprintf(out, "%d refreshed, %d failed\n", refreshed, len(failures))
+	}
+
+	if len(failures) == 0 {
+		return results, nil
+	}
+	if err := notifier.Notify(service.Notification{
+		Event:     "token_refresh_failed",
+		Message:   fmt.Sprintf("%d of %d Converty tokens could not be refreshed", len(failures), len(results)),
+		Data:      failures,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		fmt.Fprintf(out, "Failed to notify operators: %v\n", err)
+	}
+	return results, fmt.Errorf("%d of %d token refreshes failed", len(failures), len(results))
+}
+
+// refreshListing lays the outcome of RefreshAllTokens out, one row per stored token
+func refreshListing(results []TokenRefreshResult) listing {
+	l := listing{header: []string{"User", "Result", "Access expires", "Error"}, shorten: []int{3}, status: 1}
+	for _, result := range results {
+		outcome, expires := "FAILED", ""
+		if result.Refreshed {
+			outcome = "refreshed"
+			expires = result.AccessExpiresAt.Format(time.RFC3339)
+		}
+		l.rows = append(l.rows, []string{result.UserID, outcome, expires, result.Error})
+	}
+	return l
+}
@@ -0,0 +1,113 @@
+package console
+
+import (
+	"bytes"
+	"convertyApi/service"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubTokens refreshes every user except the ones listed in rejected
+type stubTokens struct {
+	users    []string
+	rejected map[string]bool
+}
+
+func (s stubTokens) TokenStatus(userID string) (TokenStatus, error) {
+	return TokenStatus{UserID: userID}, nil
+}
+
+func (s stubTokens) RefreshToken(userID string) (TokenStatus, error) {
+	if s.rejected[userID] {
+		return TokenStatus{}, errors.New("refresh token rejected")
+	}
+	return TokenStatus{UserID: userID, AccessExpiresAt: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}, nil
+}
+
+func (s stubTokens) LoginURL(userID string) (string, error) { return "", nil }
+
+func (s stubTokens) UserIDs() ([]string, error) { return s.users, nil }
+
+type recordingNotifier struct {
+	sent []service.Notification
+}
+
+func (n *recordingNotifier) Notify(notification service.Notification) error {
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+func TestRefreshAllTokensReportsFailures(t *testing.T) {
+	tokens := stubTokens{users: []string{"admin", "shop-2", "shop-3"}, rejected: map[string]bool{"shop-2": true}}
+	notifier := &recordingNotifier{}
+	var out bytes.Buffer
+	results, err := RefreshAllTokens(tokens, notifier, &out, OutputOptions{Format: FormatTable, NoColor: true})
+	if err == nil || err.Error() != "1 of 3 token refreshes failed" {
+		t.Errorf("error = %v", err)
+	}
+	if len(results) != 3 || !results[0].Refreshed || results[1].Refreshed || results[1].Error == "" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if !strings.Contains(out.String(), "2 refreshed, 1 failed") {
+		t.Errorf("summary missing from:\n%s", out.String())
+	}
+	if len(notifier.sent) != 1 || notifier.sent[0].Data["shop-2"] != "refresh token rejected" {
+		t.Errorf("unexpected notifications %+v", notifier.sent)
+	}
+
+	notifier.sent = nil
+	if _, err := RefreshAllTokens(stubTokens{users: []string{"admin"}}, notifier, &out, OutputOptions{Format: FormatJSON}); err != nil || len(notifier.sent) != 0 {
+		t.Errorf("clean run: err %v, notifications %+v", err, notifier.sent)
+	}
+}
+
+func TestFormatTokenTime(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		at   time.Time
+		want string
+	}{
+		{time.Time{}, "unknown"},
+		{now, "2025-03-01T12:00:00Z (now)"},
+		{now.Add(90 * time.Minute), "2025-03-01T13:30:00Z (in 1h30m0s)"},
+		{now.Add(-45 * time.Second), "2025-03-01T11:59:15Z (45s ago)"},
+		{now.Add(300 * time.Millisecond), "2025-03-01T12:00:00Z (now)"},
+	} {
+		if got := formatTokenTime(c.at, now); got != c.want {
+			t.Errorf("formatTokenTime(%v) = %q, want %q", c.at, got, c.want)
+		}
+	}
+}
+
+func TestPrintTokenStatus(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		name    string
+		status  TokenStatus
+		want    []string
+		notWant []string
+	}{
+		{"healthy", TokenStatus{UserID: "1", AccessExpiresAt: now.Add(time.Hour), Scopes: []string{"read-orders", "write-orders"}},
+			[]string{"User:            1", "(in 1h0m0s)", "Scopes:          read-orders write-orders", "Refresh expires: unknown"},
+			[]string{"Missing scopes", "INVALID"}},
+		{"missing scopes", TokenStatus{UserID: "1", Scopes: []string{"read-orders"}, MissingScopes: []string{"write-orders"}},
+			[]string{"Missing scopes:  write-orders"}, []string{"INVALID"}},
+		{"invalid", TokenStatus{UserID: "1", Invalid: true, InvalidReason: "invalid_grant"},
+			[]string{"INVALID:         invalid_grant (re-authentication required)"}, []string{"Missing scopes"}},
+	} {
+		var out bytes.Buffer
+		printTokenStatus(&out, c.status, now)
+		for _, want := range c.want {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: %q missing from\n%s", c.name, want, out.String())
+			}
+		}
+		for _, notWant := range c.notWant {
+			if strings.Contains(out.String(), notWant) {
+				t.Errorf("%s: unexpected %q in\n%s", c.name, notWant, out.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"convertyApi/api"
+	"convertyApi/service"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// registerConversationRoutes mounts chatbot conversation ingestion and thread lookup
+func registerConversationRoutes(r chi.Router, conversationService service.ConversationService) {
+	// The chatbot posts every turn: {"role": "user", "text": "...", "intent": "track_order", "entities": {"order_id": "..."}}
+	r.Post("/api/v1/conversations/{conversation_id}/messages", func(w http.ResponseWriter, r *http.Request) {
+		var input service.NewConversationMessage
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		message, err := conversationService.AddMessage(tenantFrom(r).ID, chi.URLParam(r, "conversation_id"), input)
+		if errors.Is(err, service.ErrInvalidMessage) {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, message)
+	})
+
+	r.Get("/api/v1/conversations/{id}", func(w http.ResponseWriter, r *http.Request) {
+		conversation, err := conversationService.GetConversation(tenantFrom(r).ID, chi.URLParam(r, "id"))
+		if errors.Is(err, service.ErrConversationNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, conversation)
+	})
+
+	// /api/v1/conversations?user_id=7&intent=track_order lists conversations by latest activity
+	r.Get("/api/v1/conversations", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var userID *uint
+		if value := r.URL.Query().Get("user_id"); value != "" {
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				writeError(w, "user_id must be a number", http.StatusBadRequest)
+				return
+			}
+			user := uint(id)
+			userID = &user
+		}
+		conversations, err := conversationService.ListConversations(tenantFrom(r).ID, userID, r.URL.Query().Get("intent"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, conversations, params))
+	})
+}
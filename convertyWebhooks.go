@@ -0,0 +1,194 @@
+package main
+
+import (
+	"convertyApi/api"
+	"convertyApi/service"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// convertyBackfillJobType is the job queue type of the Converty webhook gap backfill
+const convertyBackfillJobType = "backfill_converty_events"
+
+// convertyWebhookPath receives the Converty order webhooks; it is public and authenticated by signature
+const convertyWebhookPath = "/api/v1/webhooks/converty"
+
+// convertyWebhookMaxBody bounds a webhook payload
+const convertyWebhookMaxBody = 1 << 20
+
+// convertyBackfillGap is the silence in order updates treated as missed webhooks, and
+// convertyBackfillLookback how far back each backfill looks for one
+var (
+	convertyBackfillGap      = 30 * time.Minute
+	convertyBackfillLookback = 24 * time.Hour
+)
+
+// convertyWebhookTolerance is how far the signed timestamp of a delivery may be from our clock
+var convertyWebhookTolerance = 5 * time.Minute
+
+// loadConvertyWebhooks reads CONVERTY_BACKFILL_GAP, CONVERTY_BACKFILL_LOOKBACK and
+// CONVERTY_WEBHOOK_TOLERANCE from the environment
+func loadConvertyWebhooks() {
+	convertyBackfillGap = durationEnv("CONVERTY_BACKFILL_GAP", convertyBackfillGap)
+	convertyBackfillLookback = durationEnv("CONVERTY_BACKFILL_LOOKBACK", convertyBackfillLookback)
+	convertyWebhookTolerance = durationEnv("CONVERTY_WEBHOOK_TOLERANCE", convertyWebhookTolerance)
+}
+
+// backfillTenantEvents runs one backfill per tenant; a failing tenant is logged and skipped
+func backfillTenantEvents(tenants []service.Tenant, dataService service.DataService, events service.ConvertyEventService) map[string]service.ConvertyBackfillResult {
+	results := make(map[string]service.ConvertyBackfillResult)
+	now := time.Now()
+	for _, tenant := range tenants {
+		result, err := events.Backfill(tenant.ID, dataService.ForTenant(tenant), service.ConvertyBackfillOptions{
+			Since: now.Add(-convertyBackfillLookback), Until: now, Gap: convertyBackfillGap, MaxPages: orderSyncMaxPages,
+		})
+		if err != nil {
+			log.Printf("Converty event backfill for tenant %s failed: %v", tenant.Slug, err)
+			continue
+		}
+		results[tenant.Slug] = result
+	}
+	return results
+}
+
+// registerConvertyBackfillJob registers the handler fetching the orders missed by the webhooks
+func registerConvertyBackfillJob(jobService service.JobService, dataService service.DataService, tenantService service.TenantService, events service.ConvertyEventService) {
+	jobService.RegisterHandler(convertyBackfillJobType, func(payload json.RawMessage) (interface{}, error) {
+		tenants, err := jobTenants(tenantService, payload)
+		if err != nil {
+			return nil, err
+		}
+		return backfillTenantEvents(tenants, dataService, events), nil
+	})
+}
+
+// registerConvertyWebhookRoutes mounts the Converty webhook receiver and the log of received events.
+// The webhook URL selects the tenant with ?tenant=<slug>, the default tenant without it. Converty signs
+// each delivery with the tenant's secret, derived from CONVERTY_WEBHOOK_SECRET and served to admins at
+// /api/v1/webhooks/converty/secret: X-Converty-Signature holds the signature of the body and of
+// X-Converty-Timestamp, which must be within CONVERTY_WEBHOOK_TOLERANCE.
+func registerConvertyWebhookRoutes(r chi.Router, jobService service.JobService, tenantService service.TenantService, events service.ConvertyEventService) {
+	r.Post(convertyWebhookPath, func(w http.ResponseWriter, r *http.Request) {
+		secret := os.Getenv("CONVERTY_WEBHOOK_SECRET")
+		if secret == "" {
+			writeError(w, "Converty webhooks are not configured", http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, convertyWebhookMaxBody))
+		if err != nil {
+			writeError(w, "Failed to read body", http.StatusRequestEntityTooLarge)
+			return
+		}
+		tenant := tenantFrom(r)
+		if slug := r.URL.Query().Get("tenant"); slug != "" {
+			if tenant, err = tenantService.GetTenant(slug); err != nil {
+				writeError(w, "Invalid signature", http.StatusUnauthorized)
+				return
+			}
+		}
+		err = service.VerifyConvertySignature(service.ConvertyWebhookSecret(secret, tenant.Slug), body,
+			r.Header.Get("X-Converty-Signature"), r.Header.Get("X-Converty-Timestamp"), convertyWebhookTolerance, time.Now())
+		if err != nil {
+			writeError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		event, err := events.Receive(tenant.ID, body)
+		if errors.Is(err, service.ErrConvertyEventID) {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if event.ID == 0 {
+			// Not stored: a non-2xx makes Converty deliver it again
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			// Stored but not applied: it is kept for replay, so Converty need not retry
+			log.Printf("Converty event %d stored but not applied: %v", event.ID, err)
+		}
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"id": event.ID, "status": event.Status})
+	})
+
+	// The secret to configure in Converty for the caller's tenant; the policy keeps it to admins
+	r.Get(convertyWebhookPath+"/secret", func(w http.ResponseWriter, r *http.Request) {
+		secret := os.Getenv("CONVERTY_WEBHOOK_SECRET")
+		if secret == "" {
+			writeError(w, "Converty webhooks are not configured", http.StatusServiceUnavailable)
+			return
+		}
+		tenant := tenantFrom(r)
+		writeJSON(w, r, http.StatusOK, map[string]string{"tenant": tenant.Slug, "secret": service.ConvertyWebhookSecret(secret, tenant.Slug)})
+	})
+
+	// /api/v1/webhooks/converty/events?status=failed&order=1001&source=backfill
+	r.Get(convertyWebhookPath+"/events", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+		list, err := events.ListEvents(tenantFrom(r).ID, service.ConvertyEventFilter{
+			Status: query.Get("status"), OrderID: query.Get("order"), Source: query.Get("source"),
+		})
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, list, params))
+	})
+
+	r.Get(convertyWebhookPath+"/events/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		event, err := events.GetEvent(tenantFrom(r).ID, uint(id))
+		if errors.Is(err, service.ErrConvertyEventNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, event)
+	})
+
+	// Applies the stored payload again, e.g. after an outage of the database or a fix of the decoder
+	r.Post(convertyWebhookPath+"/events/{id}/replay", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		event, err := events.Replay(tenantFrom(r).ID, uint(id))
+		if errors.Is(err, service.ErrConvertyEventNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeJSON(w, r, http.StatusUnprocessableEntity, event)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, event)
+	})
+
+	r.Post(convertyWebhookPath+"/backfill", func(w http.ResponseWriter, r *http.Request) {
+		job, err := jobService.Enqueue(tenantFrom(r).ID, convertyBackfillJobType, tenantJobPayload{Tenant: tenantFrom(r).Slug})
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusAccepted, job)
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"convertyApi/service"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// registerCouponRoutes lets the chatbot check discount codes against the Converty store
+func registerCouponRoutes(upstream chi.Router, dataService service.DataService) {
+	// /api/v1/coupons/RAMADAN20/validate?total=120.5; unknown codes answer valid=false with reason not_found
+	upstream.Get("/api/v1/coupons/{code}/validate", func(w http.ResponseWriter, r *http.Request) {
+		var total float64
+		if value := r.URL.Query().Get("total"); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed < 0 {
+				writeError(w, "total must be a non-negative amount", http.StatusBadRequest)
+				return
+			}
+			total = parsed
+		}
+		check, err := tenantData(r, dataService).CheckCoupon(chi.URLParam(r, "code"), total)
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, check)
+	})
+}
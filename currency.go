@@ -0,0 +1,33 @@
+package main
+
+import (
+	"convertyApi/service"
+	"os"
+	"strings"
+	"time"
+)
+
+// currencyConverter converts order amounts into the reporting currency
+var currencyConverter = &service.CurrencyConverter{StoreCurrency: "TND", ReportingCurrency: "TND"}
+
+// loadCurrencyConverter configures the exchange-rate provider from the environment.
+// EXCHANGE_RATE_API_URL takes precedence over the static EXCHANGE_RATES table.
+func loadCurrencyConverter() error {
+	if currency := os.Getenv("STORE_CURRENCY"); currency != "" {
+		currencyConverter.StoreCurrency = strings.ToUpper(currency)
+	}
+	if currency := os.Getenv("REPORTING_CURRENCY"); currency != "" {
+		currencyConverter.ReportingCurrency = strings.ToUpper(currency)
+	}
+
+	if apiURL := os.Getenv("EXCHANGE_RATE_API_URL"); apiURL != "" {
+		currencyConverter.Provider = service.NewHTTPRateProvider(apiURL, durationEnv("EXCHANGE_RATE_TTL", time.Hour))
+		return nil
+	}
+	rates, err := service.ParseStaticRates(os.Getenv("EXCHANGE_RATES"))
+	if err != nil {
+		return err
+	}
+	currencyConverter.Provider = rates
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// registerCustomerRoutes mounts the customer identity merge tool
+func registerCustomerRoutes(r chi.Router, mergeService service.CustomerMergeService) {
+	r.Route("/api/v1/customers", func(r chi.Router) {
+		r.Use(adminOnly)
+
+		// Folds a second phone number or chatbot user into the customer's primary identity
+		r.Post("/merge", func(w http.ResponseWriter, r *http.Request) {
+			var input customerMergeRequest
+			if !bindJSON(w, r, &input) {
+				return
+			}
+			merge, err := mergeService.Merge(tenantFrom(r).ID, input.Primary, input.Secondary, input.Reason, adminActor(r))
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, r, http.StatusCreated, merge)
+		})
+
+		r.Get("/merges", func(w http.ResponseWriter, r *http.Request) {
+			merges, err := mergeService.ListMerges(tenantFrom(r).ID)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, r, http.StatusOK, merges)
+		})
+
+		// The rows a merge rewrote, with their values before and after
+		r.Get("/merges/{id}", func(w http.ResponseWriter, r *http.Request) {
+			id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+			if err != nil {
+				writeError(w, "Invalid ID format", http.StatusBadRequest)
+				return
+			}
+			changes, err := mergeService.Changes(tenantFrom(r).ID, uint(id))
+			if err != nil {
+				writeError(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			writeJSON(w, r, http.StatusOK, changes)
+		})
+
+		r.Post("/merges/{id}/undo", func(w http.ResponseWriter, r *http.Request) {
+			id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+			if err != nil {
+				writeError(w, "Invalid ID format", http.StatusBadRequest)
+				return
+			}
+			merge, err := mergeService.Undo(tenantFrom(r).ID, uint(id), adminActor(r))
+			if err != nil {
+				if errors.Is(err, service.ErrMergeUndone) {
+					writeError(w, err.Error(), http.StatusConflict)
+					return
+				}
+				writeError(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			writeJSON(w, r, http.StatusOK, merge)
+		})
+	})
+}
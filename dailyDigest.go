@@ -0,0 +1,183 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dailyDigestJobType is the job queue type compiling and delivering one tenant's daily digest
+const dailyDigestJobType = "daily_digest"
+
+// digestTokenWarning is how close to its expiry a refresh token is reported as expiring
+const digestTokenWarning = 72 * time.Hour
+
+// dailyDigestJobPayload compiles the digest of one tenant and day
+type dailyDigestJobPayload struct {
+	TenantID uint   `json:"tenant_id"`
+	Date     string `json:"date"`
+}
+
+// dailyDigestConfig is when the digest of the previous day is compiled
+type dailyDigestConfig struct {
+	hour, minute int
+	location     *time.Location
+}
+
+// loadDailyDigest reads DAILY_DIGEST_AT, the time of day (HH:MM, default 07:00) the digest of the day
+// before is sent, "off" disabling it, and DAILY_DIGEST_TIMEZONE, the IANA zone days are read in
+func loadDailyDigest() (*dailyDigestConfig, error) {
+	location := time.Local
+	if zone := os.Getenv("DAILY_DIGEST_TIMEZONE"); zone != "" {
+		loaded, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DAILY_DIGEST_TIMEZONE %q: %v", zone, err)
+		}
+		location = loaded
+	}
+	at := envOr("DAILY_DIGEST_AT", "07:00")
+	if at == "off" {
+		return &dailyDigestConfig{hour: -1, location: location}, nil
+	}
+	parsed, err := time.Parse("15:04", at)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DAILY_DIGEST_AT %q, use HH:MM or off", at)
+	}
+	return &dailyDigestConfig{hour: parsed.Hour(), minute: parsed.Minute(), location: location}, nil
+}
+
+// dueDigestDate returns the day whose digest is due at now: the day before, once the digest time passed
+func (c dailyDigestConfig) dueDigestDate(now time.Time) (string, bool) {
+	if c.hour < 0 {
+		return "", false
+	}
+	now = now.In(c.location)
+	sendAt := time.Date(now.Year(), now.Month(), now.Day(), c.hour, c.minute, 0, 0, c.location)
+	if now.Before(sendAt) {
+		return "", false
+	}
+	return now.AddDate(0, 0, -1).Format(service.DigestDateLayout), true
+}
+
+// scheduleDailyDigests enqueues the digest of each tenant once a day. The digests are claimed
+// per tenant and day, so several instances and restarts still send each digest once.
+func scheduleDailyDigests(jobService service.JobService, tenantService service.TenantService, digests service.DailyDigestService, config *dailyDigestConfig) {
+	if config.hour < 0 || reportScheduleInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(reportScheduleInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			enqueueDueDigests(jobService, tenantService, digests, config, time.Now())
+		}
+	}()
+	log.Printf("Daily digests sent at %02d:%02d %s", config.hour, config.minute, config.location)
+}
+
+// enqueueDueDigests enqueues the digests due at now that no instance claimed yet
+func enqueueDueDigests(jobService service.JobService, tenantService service.TenantService, digests service.DailyDigestService, config *dailyDigestConfig, now time.Time) {
+	date, due := config.dueDigestDate(now)
+	if !due {
+		return
+	}
+	tenants, err := jobTenants(tenantService, nil)
+	if err != nil {
+		log.Printf("Daily digest scheduling failed: %v", err)
+		return
+	}
+	for _, tenant := range tenants {
+		claimed, err := digests.ClaimDigest(tenant.ID, date)
+		if err != nil {
+			log.Printf("Daily digest scheduling failed: %v", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if _, err := jobService.Enqueue(tenant.ID, dailyDigestJobType, dailyDigestJobPayload{TenantID: tenant.ID, Date: date}); err != nil {
+			log.Printf("Failed to schedule the daily digest of tenant %d: %v", tenant.ID, err)
+		}
+	}
+}
+
+// registerDailyDigestJob registers the handler compiling a digest and sending it to the operators
+func registerDailyDigestJob(jobService service.JobService, digests service.DailyDigestService, notifier service.Notifier) {
+	jobService.RegisterHandler(dailyDigestJobType, func(payload json.RawMessage) (interface{}, error) {
+		var input dailyDigestJobPayload
+		if err := json.Unmarshal(payload, &input); err != nil {
+			return nil, fmt.Errorf("invalid payload: %v", err)
+		}
+		now := time.Now()
+		tokens, err := digestTokens(input.TenantID, now)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := digests.CompileDigest(input.TenantID, input.Date, tokens, now)
+		if err != nil {
+			return nil, err
+		}
+		err = notifier.Notify(digest.Notification())
+		if recordErr := digests.RecordDelivery(digest.ID, time.Now(), err); recordErr != nil {
+			log.Printf("Daily digest %s of tenant %d: %v", digest.Date, digest.TenantID, recordErr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"digest_id": digest.ID, "date": digest.Date, "token_health": digest.TokenHealth}, nil
+	})
+}
+
+// digestTokens rates the stored Converty tokens of a tenant as of now
+func digestTokens(tenantID uint, now time.Time) ([]service.DigestToken, error) {
+	var stored []TokenInfo
+	if err := db.Where("tenant_id = ?", tenantID).Find(&stored).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch tokens: %v", err)
+	}
+	tokens := make([]service.DigestToken, 0, len(stored))
+	for _, token := range stored {
+		tokens = append(tokens, service.DigestToken{
+			UserID:           token.UserID,
+			Health:           service.ClassifyToken(token.RefreshExpiresAt, token.Invalid, now, digestTokenWarning),
+			RefreshExpiresAt: token.RefreshExpiresAt,
+			Reason:           token.InvalidReason,
+		})
+	}
+	return tokens, nil
+}
+
+// registerDailyDigestRoutes serves the stored digests of the request tenant
+func registerDailyDigestRoutes(r chi.Router, digests service.DailyDigestService) {
+	r.Get("/api/v1/reports/daily/{date}", func(w http.ResponseWriter, r *http.Request) {
+		digest, err := digests.GetDigest(tenantFrom(r).ID, chi.URLParam(r, "date"))
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, digest)
+	})
+}
+
+// registerDailyDigestAdminRoutes mounts compiling a digest on demand, e.g. one missed while the service was down
+func registerDailyDigestAdminRoutes(r chi.Router, jobService service.JobService) {
+	r.Post("/reports/daily/{date}/run", func(w http.ResponseWriter, r *http.Request) {
+		date := chi.URLParam(r, "date")
+		if _, err := service.ParseDigestDate(date, time.Local); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		job, err := jobService.Enqueue(tenantFrom(r).ID, dailyDigestJobType, dailyDigestJobPayload{TenantID: tenantFrom(r).ID, Date: date})
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/jobs/%d", job.ID))
+		writeJSON(w, r, http.StatusAccepted, job)
+	})
+}
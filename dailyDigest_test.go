@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDueDigestDate(t *testing.T) {
+	tunis := time.FixedZone("Africa/Tunis", 3600)
+	config := dailyDigestConfig{hour: 7, minute: 30, location: tunis}
+	if _, due := config.dueDigestDate(time.Date(2026, 10, 16, 7, 29, 0, 0, tunis)); due {
+		t.Error("digest due before its time")
+	}
+	// 06:45 UTC is 07:45 in the digest zone
+	if date, due := config.dueDigestDate(time.Date(2026, 10, 16, 6, 45, 0, 0, time.UTC)); !due || date != "2026-10-15" {
+		t.Errorf("due date = %q, %v", date, due)
+	}
+	if date, _ := config.dueDigestDate(time.Date(2026, 10, 1, 23, 0, 0, 0, tunis)); date != "2026-09-30" {
+		t.Errorf("due date across months = %q", date)
+	}
+	if _, due := (dailyDigestConfig{hour: -1, location: tunis}).dueDigestDate(time.Now()); due {
+		t.Error("disabled digest due")
+	}
+}
+
+func TestLoadDailyDigest(t *testing.T) {
+	t.Setenv("DAILY_DIGEST_AT", "08:15")
+	t.Setenv("DAILY_DIGEST_TIMEZONE", "UTC")
+	config, err := loadDailyDigest()
+	if err != nil || config.hour != 8 || config.minute != 15 || config.location != time.UTC {
+		t.Fatalf("config = %+v, %v", config, err)
+	}
+	t.Setenv("DAILY_DIGEST_AT", "off")
+	if config, err := loadDailyDigest(); err != nil || config.hour >= 0 {
+		t.Errorf("off: %+v, %v", config, err)
+	}
+	for name, value := range map[string]string{"DAILY_DIGEST_AT": "7am", "DAILY_DIGEST_TIMEZONE": "Mars/Olympus"} {
+		t.Setenv("DAILY_DIGEST_AT", "07:00")
+		t.Setenv("DAILY_DIGEST_TIMEZONE", "")
+		t.Setenv(name, value)
+		if _, err := loadDailyDigest(); err == nil {
+			t.Errorf("%s=%s accepted", name, value)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// dashboardPingInterval keeps idle dashboard connections alive through proxies
+const dashboardPingInterval = 30 * time.Second
+
+// dashboardRefreshInterval is how often the counted figures of watched tenants are recomputed
+var dashboardRefreshInterval = 5 * time.Minute
+
+var dashboardUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// registerDashboardRoutes mounts the live counters channel of the admin UI header
+func registerDashboardRoutes(r chi.Router, dataService service.DataService, dashboard *service.Dashboard) {
+	r.Get("/ws/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		tenantID := tenantFrom(r).ID
+		data := tenantData(r, dataService)
+		conn, err := dashboardUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has already written the error response
+			return
+		}
+		defer conn.Close()
+
+		signals, unsubscribe := dashboard.Subscribe(tenantID)
+		defer unsubscribe()
+		if time.Since(dashboard.Snapshot(tenantID).RefreshedAt) > dashboardRefreshInterval {
+			go refreshDashboard(dashboard, data, tenantID)
+		}
+
+		// The client only sends control frames; reading detects when it goes away
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(dashboardPingInterval)
+		defer ping.Stop()
+		if err := conn.WriteJSON(dashboard.Snapshot(tenantID)); err != nil {
+			return
+		}
+		for {
+			select {
+			case <-closed:
+				return
+			case <-signals:
+				if err := conn.WriteJSON(dashboard.Snapshot(tenantID)); err != nil {
+					return
+				}
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+// refreshDashboard recomputes today's orders and the pending issues of a tenant
+func refreshDashboard(dashboard *service.Dashboard, dataService service.DataService, tenantID uint) {
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	orders, err := service.CollectOrders(dataService, service.CustomerOrderQuery{Limit: 100}, midnight, now, 10)
+	if errors.Is(err, service.ErrOrdersTruncated) {
+		// The baseline counts the first thousand orders of the day; the live events add to it
+		log.Printf("Dashboard refresh for tenant %d: %v", tenantID, err)
+		err = nil
+	}
+	if err != nil {
+		log.Printf("Dashboard refresh for tenant %d failed: %v", tenantID, err)
+		return
+	}
+	issues, err := dataService.ListIssues()
+	if err != nil {
+		log.Printf("Dashboard refresh for tenant %d failed: %v", tenantID, err)
+		return
+	}
+	pending := 0
+	for _, issue := range issues {
+		if issue.Status == service.StatusPending {
+			pending++
+		}
+	}
+	dashboard.SetBaseline(tenantID, len(orders), pending)
+}
+
+// scheduleDashboardRefresh periodically refreshes the tenants with an open dashboard
+func scheduleDashboardRefresh(dashboard *service.Dashboard, dataService service.DataService, tenantService service.TenantService) {
+	go func() {
+		ticker := time.NewTicker(dashboardRefreshInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			watched := make(map[uint]bool)
+			for _, tenantID := range dashboard.WatchedTenants() {
+				watched[tenantID] = true
+			}
+			if len(watched) == 0 {
+				continue
+			}
+			tenants, err := jobTenants(tenantService, nil)
+			if err != nil {
+				log.Printf("Dashboard refresh failed: %v", err)
+				continue
+			}
+			for _, tenant := range tenants {
+				if watched[tenant.ID] {
+					refreshDashboard(dashboard, dataService.ForTenant(tenant), tenant.ID)
+				}
+			}
+		}
+	}()
+}
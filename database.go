@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// dbPoolConfig sizes the connection pool and sets the slow query threshold
+type dbPoolConfig struct {
+	MaxOpen     int
+	MaxIdle     int
+	MaxLifetime time.Duration
+	MaxIdleTime time.Duration
+	// SlowQuery is the duration from which a statement is logged as slow; 0 disables the log
+	SlowQuery time.Duration
+}
+
+// defaultDBPool keeps chatbot bursts from opening an unbounded number of connections
+var defaultDBPool = dbPoolConfig{
+	MaxOpen:     25,
+	MaxIdle:     10,
+	MaxLifetime: 30 * time.Minute,
+	MaxIdleTime: 5 * time.Minute,
+	SlowQuery:   200 * time.Millisecond,
+}
+
+// loadDBPool reads DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME, DB_CONN_MAX_IDLE_TIME
+// and DB_SLOW_QUERY_THRESHOLD
+func loadDBPool() (dbPoolConfig, error) {
+	pool := defaultDBPool
+	for name, target := range map[string]*int{
+		"DB_MAX_OPEN_CONNS": &pool.MaxOpen,
+		"DB_MAX_IDLE_CONNS": &pool.MaxIdle,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return pool, fmt.Errorf("invalid %s %q", name, value)
+		}
+		*target = n
+	}
+	if pool.MaxOpen > 0 && pool.MaxIdle > pool.MaxOpen {
+		return pool, fmt.Errorf("DB_MAX_IDLE_CONNS (%d) cannot exceed DB_MAX_OPEN_CONNS (%d)", pool.MaxIdle, pool.MaxOpen)
+	}
+	pool.MaxLifetime = durationEnv("DB_CONN_MAX_LIFETIME", pool.MaxLifetime)
+	pool.MaxIdleTime = durationEnv("DB_CONN_MAX_IDLE_TIME", pool.MaxIdleTime)
+	if value := os.Getenv("DB_SLOW_QUERY_THRESHOLD"); value == "0" {
+		pool.SlowQuery = 0
+	} else {
+		pool.SlowQuery = durationEnv("DB_SLOW_QUERY_THRESHOLD", pool.SlowQuery)
+	}
+	return pool, nil
+}
+
+// configureDB applies the pool settings and installs the query metrics and tracing plugins
+func configureDB(db *gorm.DB, pool dbPoolConfig) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	sqlDB.SetMaxOpenConns(pool.MaxOpen)
+	sqlDB.SetMaxIdleConns(pool.MaxIdle)
+	sqlDB.SetConnMaxLifetime(pool.MaxLifetime)
+	sqlDB.SetConnMaxIdleTime(pool.MaxIdleTime)
+	if err := db.Use(&service.QueryMetricsPlugin{Metrics: service.DBQueries, Threshold: pool.SlowQuery}); err != nil {
+		return fmt.Errorf("failed to install query metrics: %v", err)
+	}
+	if err := db.Use(service.TracingPlugin{}); err != nil {
+		return fmt.Errorf("failed to install query tracing: %v", err)
+	}
+	log.Printf("Database pool: %d open, %d idle, lifetime %v; slow queries from %v", pool.MaxOpen, pool.MaxIdle, pool.MaxLifetime, pool.SlowQuery)
+	return nil
+}
+
+// PoolStats is the connection pool state reported by /readyz
+type PoolStats struct {
+	MaxOpen           int   `json:"max_open"`
+	Open              int   `json:"open"`
+	InUse             int   `json:"in_use"`
+	Idle              int   `json:"idle"`
+	WaitCount         int64 `json:"wait_count"`
+	WaitMS            int64 `json:"wait_ms"`
+	MaxIdleClosed     int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64 `json:"max_lifetime_closed"`
+}
+
+// ReadinessResponse for the /readyz endpoint
+type ReadinessResponse struct {
+	Status   string             `json:"status"`
+	Database string             `json:"database,omitempty"`
+	Pool     *PoolStats         `json:"pool,omitempty"`
+	Queries  service.QueryStats `json:"queries"`
+}
+
+// readinessHandler answers 200 when the database responds within two seconds and 503 otherwise,
+// with the pool and query counters either way
+func readinessHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := ReadinessResponse{Status: "ok", Queries: service.DBQueries.Stats()}
+		sqlDB, err := db.DB()
+		if err == nil {
+			stats := sqlDB.Stats()
+			response.Pool = &PoolStats{
+				MaxOpen:           stats.MaxOpenConnections,
+				Open:              stats.OpenConnections,
+				InUse:             stats.InUse,
+				Idle:              stats.Idle,
+				WaitCount:         stats.WaitCount,
+				WaitMS:            stats.WaitDuration.Milliseconds(),
+				MaxIdleClosed:     stats.MaxIdleClosed,
+				MaxIdleTimeClosed: stats.MaxIdleTimeClosed,
+				MaxLifetimeClosed: stats.MaxLifetimeClosed,
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+			err = sqlDB.PingContext(ctx)
+			cancel()
+		}
+		if err != nil {
+			response.Status = "unavailable"
+			response.Database = err.Error()
+			writeJSON(w, r, http.StatusServiceUnavailable, response)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, response)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"convertyApi/api"
+	"convertyApi/service"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// registerDebugRoutes mounts operator diagnostics
+func registerDebugRoutes(r chi.Router) {
+	// Recent Converty calls, newest first: /api/v1/debug/upstream?status=4xx&method=GET
+	r.With(adminOnly).Get("/api/v1/debug/upstream", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		method := r.URL.Query().Get("method")
+		exchanges := []service.UpstreamExchange{}
+		for _, exchange := range service.UpstreamExchanges.Recent() {
+			if method != "" && !strings.EqualFold(exchange.Method, method) {
+				continue
+			}
+			if status != "" && !upstreamStatusMatches(exchange, status) {
+				continue
+			}
+			exchanges = append(exchanges, exchange)
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, exchanges, params))
+	})
+
+	// Converty limiter occupancy, queue lengths and wait times per priority
+	r.With(adminOnly).Get("/api/v1/debug/upstream/limiter", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, service.UpstreamLimit.Stats())
+	})
+
+	// Statements slower than DB_SLOW_QUERY_THRESHOLD, newest first, without their bound values
+	r.With(adminOnly).Get("/api/v1/debug/db/slow-queries", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, service.DBQueries.SlowQueries(), params))
+	})
+}
+
+// upstreamStatusMatches filters exchanges by exact status ("404"), class ("4xx") or "error"
+func upstreamStatusMatches(exchange service.UpstreamExchange, filter string) bool {
+	filter = strings.ToLower(filter)
+	switch {
+	case filter == "error":
+		return exchange.Error != ""
+	case len(filter) == 3 && strings.HasSuffix(filter, "xx"):
+		return exchange.Status/100 == int(filter[0]-'0')
+	default:
+		return filter == strconv.Itoa(exchange.Status)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gorm.io/gorm"
+)
+
+// Outcomes of a doctor check. Skipped checks could not run because an earlier one failed.
+const (
+	doctorPass = "PASS"
+	doctorWarn = "WARN"
+	doctorFail = "FAIL"
+	doctorSkip = "SKIP"
+)
+
+// doctorColors are the ANSI colors of the outcomes
+var doctorColors = map[string]string{
+	doctorPass: "\033[32m",
+	doctorWarn: "\033[33m",
+	doctorFail: "\033[31m",
+	doctorSkip: "\033[90m",
+}
+
+// doctorDurationSuffixes name the variables read as Go durations
+var doctorDurationSuffixes = []string{
+	"_INTERVAL", "_TTL", "_TIMEOUT", "_WINDOW", "_LIFETIME", "_IDLE_TIME", "_RETENTION",
+	"_AFTER", "_GRACE", "_COOLDOWN", "_THRESHOLD", "_RANGE",
+}
+
+// doctorCheck is one line of the doctor report
+type doctorCheck struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+// doctorReport collects the checks of a doctor run
+type doctorReport struct {
+	checks []doctorCheck
+}
+
+func (r *doctorReport) add(name, status, detail string) {
+	r.checks = append(r.checks, doctorCheck{Name: name, Status: status, Detail: detail})
+}
+
+// failed tells whether any check failed; warnings do not fail the run
+func (r *doctorReport) failed() bool {
+	for _, check := range r.checks {
+		if check.Status == doctorFail {
+			return true
+		}
+	}
+	return false
+}
+
+// print writes one line per check and a summary, coloring the outcomes when color is set
+func (r *doctorReport) print(out io.Writer, color bool) {
+	width := 0
+	for _, check := range r.checks {
+		if len(check.Name) > width {
+			width = len(check.Name)
+		}
+	}
+	counts := map[string]int{}
+	for _, check := range r.checks {
+		counts[check.Status]++
+		status := check.Status
+		if color {
+			status = doctorColors[check.Status] + status + "\033[0m"
+		}
+		fmt.Fprintf(out, "[%s] %-*s  %s\n", status, width, check.Name, check.Detail)
+	}
+	fmt.Fprintf(out, "\n%d passed, %d warnings, %d failed, %d skipped\n",
+		counts[doctorPass], counts[doctorWarn], counts[doctorFail], counts[doctorSkip])
+}
+
+// runDoctor is the `doctor` subcommand: it checks the configuration, the database, the Converty
+// tokens and the clock before a deploy, so problems show up as a report instead of runtime errors.
+// It exits 1 when a check fails.
+func runDoctor(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	callConverty := fs.Bool("converty", false, "also call the Converty API with the stored token")
+	maxSkew := fs.Duration("max-skew", 30*time.Second, "largest accepted clock difference with the database and Converty")
+	noColor := fs.Bool("no-color", false, "print the report without colors")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	report := &doctorReport{}
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		report.add(".env", doctorFail, err.Error())
+	}
+	envOK := checkDoctorEnv(report, os.Getenv)
+	client := checkDoctorConverty(report)
+
+	var database *gorm.DB
+	if envOK {
+		database = checkDoctorDatabase(report, *maxSkew)
+	} else {
+		report.add("database", doctorSkip, "configuration incomplete")
+	}
+	if database != nil {
+		checkDoctorTokens(report, database, time.Now())
+	} else {
+		report.add("tokens", doctorSkip, "database unavailable")
+	}
+	if client != nil {
+		checkDoctorConvertyClock(report, client, *maxSkew)
+	}
+	if *callConverty {
+		if database != nil && client != nil {
+			dataService := service.NewGormDataService(database, service.DataServiceOptions{Converty: client})
+			if _, err := dataService.ListProducts(1, 1); err != nil {
+				report.add("converty api", doctorFail, err.Error())
+			} else {
+				report.add("converty api", doctorPass, "listed products with the stored token")
+			}
+		} else {
+			report.add("converty api", doctorSkip, "database or Converty configuration unavailable")
+		}
+	}
+
+	report.print(out, !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(out))
+	if report.failed() {
+		return 1
+	}
+	return 0
+}
+
+// checkDoctorEnv checks the required variables and the format of the optional ones, reporting
+// whether the database settings are complete
+func checkDoctorEnv(report *doctorReport, getenv func(string) string) bool {
+	envOK := true
+	if err := validateEnv(getenv); err != nil {
+		report.add("env", doctorFail, strings.ReplaceAll(err.Error(), "\n", "; "))
+		envOK = false
+	} else {
+		report.add("env", doctorPass, fmt.Sprintf("%d required variables set", len(requiredEnv)))
+	}
+
+	var invalid []string
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		if value == "" || !hasDurationSuffix(name) || (name == "DB_SLOW_QUERY_THRESHOLD" && value == "0") {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			invalid = append(invalid, fmt.Sprintf("%s=%q", name, value))
+		}
+	}
+	sort.Strings(invalid)
+	if len(invalid) > 0 {
+		report.add("env durations", doctorWarn, "invalid, defaults apply: "+strings.Join(invalid, ", "))
+	} else {
+		report.add("env durations", doctorPass, "all set durations parse")
+	}
+
+	if _, err := parseRuntimeConfig(os.Getenv); err != nil {
+		report.add("runtime config", doctorFail, strings.ReplaceAll(err.Error(), "\n", "; "))
+	} else {
+		report.add("runtime config", doctorPass, "log level, limits, cache TTLs, alerts and policy valid")
+	}
+	if _, err := loadDBPool(); err != nil {
+		report.add("db pool", doctorFail, err.Error())
+	} else {
+		report.add("db pool", doctorPass, "pool settings valid")
+	}
+	if err := loadOutboundHTTP(); err != nil {
+		report.add("outbound http", doctorFail, err.Error())
+	} else {
+		report.add("outbound http", doctorPass, "proxy and TLS settings valid")
+	}
+	if err := loadPIIProtection(); err != nil {
+		report.add("pii encryption", doctorFail, err.Error())
+	} else if service.PII == nil {
+		report.add("pii encryption", doctorWarn, "PII_ENCRYPTION_KEY not set, customer fields are stored in clear")
+	} else {
+		report.add("pii encryption", doctorPass, "key and fields valid")
+	}
+	return envOK
+}
+
+// hasDurationSuffix tells whether name is read as a Go duration
+func hasDurationSuffix(name string) bool {
+	for _, suffix := range doctorDurationSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDoctorConverty checks the Converty API version and URL
+func checkDoctorConverty(report *doctorReport) service.ConvertyClient {
+	client, err := service.NewConvertyClient(os.Getenv("CONVERTY_API_VERSION"), os.Getenv("CONVERTY_API_URL"))
+	if err != nil {
+		report.add("converty config", doctorFail, err.Error())
+		return nil
+	}
+	report.add("converty config", doctorPass, fmt.Sprintf("API %s at %s", client.Version(), client.URL("")))
+	return client
+}
+
+// checkDoctorDatabase connects to the database and checks that the schema is migrated and the clocks
+// agree; it returns nil when the database cannot be reached
+func checkDoctorDatabase(report *doctorReport, maxSkew time.Duration) *gorm.DB {
+	database, err := openDB()
+	if err != nil {
+		report.add("database", doctorFail, err.Error())
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var version string
+	before := time.Now()
+	var dbNow time.Time
+	if err := database.WithContext(ctx).Raw("SELECT now()").Scan(&dbNow).Error; err != nil {
+		report.add("database", doctorFail, fmt.Sprintf("cannot reach %s:%s: %v", os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), err))
+		return nil
+	}
+	local := before.Add(time.Since(before) / 2)
+	database.WithContext(ctx).Raw("SHOW server_version").Scan(&version)
+	report.add("database", doctorPass, fmt.Sprintf("connected to %s on %s, PostgreSQL %s", os.Getenv("DB_NAME"), os.Getenv("DB_HOST"), version))
+
+	missing := missingSchema(database)
+	if len(missing) > 0 {
+		report.add("schema", doctorFail, fmt.Sprintf("not migrated, start the server once to create: %s", strings.Join(missing, ", ")))
+	} else {
+		report.add("schema", doctorPass, fmt.Sprintf("%d tables up to date", len(schemaModels)))
+	}
+
+	report.add(clockCheck("database clock", dbNow.Sub(local), maxSkew))
+	return database
+}
+
+// missingSchema lists the tables, columns and functions of the current schema the database lacks
+func missingSchema(database *gorm.DB) []string {
+	var missing []string
+	migrator := database.Migrator()
+	for _, model := range schemaModels {
+		stmt := &gorm.Statement{DB: database}
+		if err := stmt.Parse(model); err != nil {
+			missing = append(missing, fmt.Sprintf("%T", model))
+			continue
+		}
+		if !migrator.HasTable(model) {
+			missing = append(missing, stmt.Schema.Table)
+			continue
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !migrator.HasColumn(model, field.DBName) {
+				missing = append(missing, stmt.Schema.Table+"."+field.DBName)
+			}
+		}
+	}
+	var function bool
+	database.Raw("SELECT to_regprocedure('chatbot.jsonb_merge_patch(jsonb,jsonb)') IS NOT NULL").Scan(&function)
+	if !function {
+		missing = append(missing, "chatbot.jsonb_merge_patch()")
+	}
+	return missing
+}
+
+// checkDoctorTokens checks the stored Converty tokens
+func checkDoctorTokens(report *doctorReport, database *gorm.DB, now time.Time) {
+	var tokens []TokenInfo
+	if err := database.Find(&tokens).Error; err != nil {
+		report.add("tokens", doctorFail, fmt.Sprintf("failed to load tokens: %v", err))
+		return
+	}
+	for _, check := range tokenChecks(tokens, now) {
+		report.add(check.Name, check.Status, check.Detail)
+	}
+}
+
+// tokenChecks rates each token: unusable refresh tokens fail, expired access tokens only warn since
+// they are refreshed on first use
+func tokenChecks(tokens []TokenInfo, now time.Time) []doctorCheck {
+	if len(tokens) == 0 {
+		return []doctorCheck{{Name: "tokens", Status: doctorFail, Detail: "no Converty token stored, log in through /login"}}
+	}
+	checks := make([]doctorCheck, 0, len(tokens))
+	for _, token := range tokens {
+		check := doctorCheck{Name: "token " + token.UserID}
+		switch {
+		case token.Invalid:
+			check.Status = doctorFail
+			check.Detail = "invalidated, log in again: " + token.InvalidReason
+		case refreshExpired(token, now):
+			check.Status = doctorFail
+			check.Detail = fmt.Sprintf("refresh token expired at %s, log in again", token.RefreshExpiresAt.Format(time.RFC3339))
+		case service.TokenExpiresWithin(token.ExpiresAt, now, 0):
+			check.Status = doctorWarn
+			check.Detail = fmt.Sprintf("access token expired, refreshed on next use; refresh token valid until %s", token.RefreshExpiresAt.Format(time.RFC3339))
+		case token.MissingScopes != "":
+			check.Status = doctorWarn
+			check.Detail = "Converty refused scopes " + token.MissingScopes
+		default:
+			check.Status = doctorPass
+			check.Detail = fmt.Sprintf("valid until %s", token.ExpiresAt.Format(time.RFC3339))
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// checkDoctorConvertyClock compares the local clock with the Date header of the Converty API;
+// token expiry times are computed locally, so a skewed clock uses expired tokens
+func checkDoctorConvertyClock(report *doctorReport, client service.ConvertyClient, maxSkew time.Duration) {
+	req, err := http.NewRequest(http.MethodHead, client.URL(""), nil)
+	if err != nil {
+		report.add("converty clock", doctorFail, err.Error())
+		return
+	}
+	before := time.Now()
+	resp, err := service.NewHTTPClient(10 * time.Second).Do(req)
+	if err != nil {
+		report.add("converty clock", doctorFail, fmt.Sprintf("cannot reach Converty: %v", err))
+		return
+	}
+	resp.Body.Close()
+	local := before.Add(time.Since(before) / 2)
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		report.add("converty clock", doctorWarn, "Converty sent no Date header")
+		return
+	}
+	// the Date header has a one second resolution
+	report.add(clockCheck("converty clock", remote.Sub(local.Truncate(time.Second)), maxSkew+time.Second))
+}
+
+// clockCheck rates the difference between a remote clock and the local one
+func clockCheck(name string, skew, maxSkew time.Duration) (string, string, string) {
+	detail := fmt.Sprintf("local clock is %v off", skew.Abs().Round(time.Millisecond))
+	if skew.Abs() > maxSkew {
+		return name, doctorFail, detail + fmt.Sprintf(", more than %v: sync the clock (NTP)", maxSkew)
+	}
+	return name, doctorPass, detail
+}
+
+// isTerminal tells whether out is a character device, i.e. colors can be shown
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenChecks(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	tokens := []TokenInfo{
+		{UserID: "fresh", ExpiresAt: now.Add(time.Hour), RefreshExpiresAt: now.Add(24 * time.Hour)},
+		{UserID: "stale", ExpiresAt: now.Add(-time.Minute), RefreshExpiresAt: now.Add(24 * time.Hour)},
+		{UserID: "gone", ExpiresAt: now.Add(-time.Hour), RefreshExpiresAt: now.Add(-time.Minute)},
+		{UserID: "revoked", ExpiresAt: now.Add(time.Hour), RefreshExpiresAt: now.Add(24 * time.Hour), Invalid: true, InvalidReason: "invalid_grant"},
+	}
+	want := []string{doctorPass, doctorWarn, doctorFail, doctorFail}
+	checks := tokenChecks(tokens, now)
+	for i, check := range checks {
+		if check.Status != want[i] {
+			t.Errorf("%s: got %s (%s), want %s", check.Name, check.Status, check.Detail, want[i])
+		}
+	}
+
+	if checks := tokenChecks(nil, now); len(checks) != 1 || checks[0].Status != doctorFail {
+		t.Errorf("no tokens should fail, got %+v", checks)
+	}
+}
+
+func TestClockCheck(t *testing.T) {
+	if _, status, _ := clockCheck("clock", -2*time.Second, 30*time.Second); status != doctorPass {
+		t.Errorf("2s skew: got %s", status)
+	}
+	_, status, detail := clockCheck("clock", -45*time.Second, 30*time.Second)
+	if status != doctorFail || !strings.Contains(detail, "45s") {
+		t.Errorf("45s skew: got %s %q", status, detail)
+	}
+}
+
+func TestDoctorReport(t *testing.T) {
+	report := &doctorReport{}
+	report.add("env", doctorPass, "ok")
+	report.add("pii encryption", doctorWarn, "not set")
+	if report.failed() {
+		t.Error("warnings should not fail the report")
+	}
+	report.add("database", doctorFail, "down")
+	if !report.failed() {
+		t.Error("a failed check should fail the report")
+	}
+
+	var plain, colored bytes.Buffer
+	report.print(&plain, false)
+	report.print(&colored, true)
+	if strings.Contains(plain.String(), "\033[") {
+		t.Errorf("plain report has colors: %q", plain.String())
+	}
+	if !strings.Contains(plain.String(), "[FAIL] database") || !strings.Contains(plain.String(), "1 passed, 1 warnings, 1 failed, 0 skipped") {
+		t.Errorf("unexpected report:\n%s", plain.String())
+	}
+	if !strings.Contains(colored.String(), "\033[31mFAIL\033[0m") {
+		t.Errorf("colored report lacks the red FAIL: %q", colored.String())
+	}
+}
+
+func TestCheckDoctorEnv(t *testing.T) {
+	t.Setenv("ROUTE_TIMEOUT", "soon")
+	report := &doctorReport{}
+	if checkDoctorEnv(report, func(string) string { return "" }) {
+		t.Error("an empty environment should not be usable")
+	}
+	statuses := map[string]string{}
+	for _, check := range report.checks {
+		statuses[check.Name] = check.Status
+	}
+	if statuses["env"] != doctorFail || statuses["env durations"] != doctorWarn {
+		t.Errorf("unexpected checks: %+v", report.checks)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// orderTrackingJobType is the job queue type of the order status tracking that feeds delivery ETAs
+const orderTrackingJobType = "track_order_statuses"
+
+// orderTrackingMaxPages bounds how many Converty order pages one tracking run walks
+const orderTrackingMaxPages = 20
+
+// registerOrderTrackingJob registers the handler that records order status changes per tenant
+func registerOrderTrackingJob(jobService service.JobService, dataService service.DataService, tenantService service.TenantService, etaService service.ETAService) {
+	jobService.RegisterHandler(orderTrackingJobType, func(payload json.RawMessage) (interface{}, error) {
+		tenants, err := jobTenants(tenantService, payload)
+		if err != nil {
+			return nil, err
+		}
+		changes := make(map[string]int)
+		for _, tenant := range tenants {
+			count, err := etaService.TrackOrders(tenant.ID, dataService.ForTenant(tenant), orderTrackingMaxPages)
+			if err != nil {
+				log.Printf("Order tracking for tenant %s failed: %v", tenant.Slug, err)
+				continue
+			}
+			changes[tenant.Slug] = count
+		}
+		return changes, nil
+	})
+}
+
+// registerETARoutes mounts the delivery prediction the chatbot quotes to customers. The order is read
+// like GET /api/v1/orders/{id}, from the local mirror when Converty is down and the fallback is on.
+func registerETARoutes(upstream chi.Router, dataService service.DataService, mirror service.OrderMirrorService, etaService service.ETAService) {
+	upstream.Get("/api/v1/orders/{id}/eta", func(w http.ResponseWriter, r *http.Request) {
+		order, err := getOrder(w, r, dataService, mirror, chi.URLParam(r, "id"))
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		estimate, err := etaService.Estimate(tenantFrom(r).ID, order)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		estimate.Localize(customerLanguage(w, r))
+		writeJSON(w, r, http.StatusOK, estimate)
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConverty stands in for partner.converty.shop and api.converty.shop in tests
+type fakeConverty struct {
+	*httptest.Server
+	mu sync.Mutex
+	// grants records the form of every token request
+	grants []url.Values
+	// orders is served by GET /api/v1/orders; POST /api/v1/orders appends to it
+	orders []map[string]interface{}
+}
+
+// newFakeConverty starts the fake and routes every *.converty.shop request of the process to it
+func newFakeConverty(t *testing.T) *fakeConverty {
+	t.Helper()
+	fake := &fakeConverty{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", fake.token)
+	mux.HandleFunc("/api/v1/orders", fake.handleOrders)
+	mux.HandleFunc("/api/v1/orders/", fake.handleOrder)
+	fake.Server = httptest.NewServer(mux)
+	t.Cleanup(fake.Close)
+
+	target, _ := url.Parse(fake.URL)
+	base := http.DefaultTransport
+	http.DefaultTransport = rewriteTransport{base: base, target: target}
+	t.Cleanup(func() { http.DefaultTransport = base })
+	return fake
+}
+
+func (f *fakeConverty) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.grants = append(f.grants, r.PostForm)
+	f.mu.Unlock()
+	switch {
+	case r.PostForm.Get("grant_type") == "authorization_code" && r.PostForm.Get("code") != "":
+	case r.PostForm.Get("grant_type") == "refresh_token" && r.PostForm.Get("refresh_token") == "refresh-1":
+	default:
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	writeFakeJSON(w, map[string]interface{}{
+		"access_token":  "access-" + r.PostForm.Get("grant_type"),
+		"refresh_token": "refresh-1",
+		"expires_in":    3600,
+		"token_type":    "Bearer",
+	})
+}
+
+func (f *fakeConverty) handleOrders(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer access-") {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method == http.MethodPost {
+		var order map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+			http.Error(w, `{"success":false,"message":"invalid order"}`, http.StatusBadRequest)
+			return
+		}
+		order["id"] = fmt.Sprintf("o-%d", len(f.orders)+1)
+		order["status"] = "pending"
+		order["created_at"] = time.Now().UTC().Format(time.RFC3339)
+		// Newest first, like the real listing
+		f.orders = append([]map[string]interface{}{order}, f.orders...)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "data": order})
+		return
+	}
+	writeFakeJSON(w, map[string]interface{}{"success": true, "data": f.orders})
+}
+
+// handleOrder serves GET /api/v1/orders/{id} and status updates with PUT
+func (f *fakeConverty) handleOrder(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer access-") {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/orders/")
+	for _, order := range f.orders {
+		if order["id"] != id {
+			continue
+		}
+		if r.Method == http.MethodPut {
+			var update map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				http.Error(w, `{"success":false,"message":"invalid update"}`, http.StatusBadRequest)
+				return
+			}
+			for key, value := range update {
+				order[key] = value
+			}
+		}
+		writeFakeJSON(w, map[string]interface{}{"success": true, "data": order})
+		return
+	}
+	http.Error(w, `{"success":false,"message":"order not found"}`, http.StatusNotFound)
+}
+
+// grantTypes lists the grant_type of every token request so far
+func (f *fakeConverty) grantTypes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var types []string
+	for _, grant := range f.grants {
+		types = append(types, grant.Get("grant_type"))
+	}
+	return types
+}
+
+func writeFakeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// rewriteTransport sends Converty requests to target and everything else to base
+type rewriteTransport struct {
+	base   http.RoundTripper
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Hostname(), "converty.shop") {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = t.target.Scheme
+		req.URL.Host = t.target.Host
+		req.Host = t.target.Host
+	}
+	return t.base.RoundTrip(req)
+}
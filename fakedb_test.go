@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeTokenColumns are the public.token_infos columns the fake database returns
+var fakeTokenColumns = []string{
+	"id", "user_id", "tenant_id", "store_id", "access_token", "refresh_token", "token_type", "expires_in",
+	"issued_at", "expires_at", "refresh_issued_at", "refresh_expires_at", "invalid", "scopes", "missing_scopes",
+}
+
+// fakeTokenDB answers the token lookups of the handlers from memory, so handler tests run without
+// PostgreSQL. Any other query fails, which the handlers treat like an unreachable database.
+type fakeTokenDB struct {
+	mu     sync.Mutex
+	tokens []TokenInfo
+}
+
+var registerFakeDriver sync.Once
+
+// useFakeTokenDB points the package database at a fake holding tokens for the duration of the test
+func useFakeTokenDB(t *testing.T, tokens ...TokenInfo) {
+	t.Helper()
+	fake := &fakeTokenDB{tokens: tokens}
+	registerFakeDriver.Do(func() { sql.Register("fake-tokens", fakeTokenDriver{}) })
+	name := fmt.Sprintf("%p", fake)
+	fakeTokenDBs.Store(name, fake)
+	conn, err := sql.Open("fake-tokens", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeDB, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = fakeDB
+	// Tokens cached from the previous database would hide the fake's
+	service.Caches.Invalidate(service.CacheTokens, "")
+	t.Cleanup(func() {
+		db = previous
+		service.Caches.Invalidate(service.CacheTokens, "")
+		conn.Close()
+		fakeTokenDBs.Delete(name)
+	})
+}
+
+// fakeTokenDBs finds the fake of a connection by its DSN
+var fakeTokenDBs sync.Map
+
+type fakeTokenDriver struct{}
+
+func (fakeTokenDriver) Open(name string) (driver.Conn, error) {
+	fake, ok := fakeTokenDBs.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("no fake database %s", name)
+	}
+	return fakeTokenConn{db: fake.(*fakeTokenDB)}, nil
+}
+
+type fakeTokenConn struct {
+	db *fakeTokenDB
+}
+
+func (c fakeTokenConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fake database: prepared statements are not supported")
+}
+
+func (c fakeTokenConn) Close() error { return nil }
+
+func (c fakeTokenConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake database: transactions are not supported")
+}
+
+// QueryContext serves SELECTs of token_infos filtered by user_id
+func (c fakeTokenConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if !strings.HasPrefix(query, "SELECT") || !strings.Contains(query, "token_infos") || !strings.Contains(query, "user_id =") || len(args) == 0 {
+		return nil, fmt.Errorf("fake database: unsupported query %s", query)
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	rows := &fakeTokenRows{}
+	for _, token := range c.db.tokens {
+		if token.UserID == args[0].Value {
+			rows.values = append(rows.values, []driver.Value{
+				int64(token.ID), token.UserID, int64(token.TenantID), token.StoreID, token.AccessToken, token.RefreshToken,
+				token.TokenType, token.ExpiresIn, token.IssuedAt, token.ExpiresAt, token.RefreshIssuedAt,
+				token.RefreshExpiresAt, token.Invalid, token.Scopes, token.MissingScopes,
+			})
+		}
+	}
+	return rows, nil
+}
+
+func (c fakeTokenConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return nil, fmt.Errorf("fake database: unsupported statement %s", query)
+}
+
+type fakeTokenRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeTokenRows) Columns() []string { return fakeTokenColumns }
+
+func (r *fakeTokenRows) Close() error { return nil }
+
+func (r *fakeTokenRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// goldenTime is the fixed time of the snapshot fixtures
+var goldenTime = time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC)
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"convertyApi/service"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// parseFeatureFlags reads FEATURE_FLAGS, e.g. "exports=off,acme:exports=on,new_eta=on": a flag
+// name, optionally prefixed by a tenant slug, and on/off or a boolean
+func parseFeatureFlags(value string) (service.FlagConfig, error) {
+	cfg := service.FlagConfig{Values: map[string]bool{}, Tenants: map[string]map[string]bool{}}
+	for _, item := range splitList(value) {
+		key, setting, ok := strings.Cut(item, "=")
+		if !ok {
+			return service.FlagConfig{}, fmt.Errorf("invalid FEATURE_FLAGS entry %q, expected name=on or tenant:name=off", item)
+		}
+		enabled, err := parseFlagSetting(setting)
+		if err != nil {
+			return service.FlagConfig{}, fmt.Errorf("invalid FEATURE_FLAGS entry %q: %v", item, err)
+		}
+		tenant, name, scoped := strings.Cut(strings.TrimSpace(key), ":")
+		if !scoped {
+			tenant, name = "", tenant
+		}
+		if name, err = service.NormalizeFlagName(name); err != nil {
+			return service.FlagConfig{}, fmt.Errorf("invalid FEATURE_FLAGS entry %q: %v", item, err)
+		}
+		if tenant == "" {
+			cfg.Values[name] = enabled
+			continue
+		}
+		if cfg.Tenants[tenant] == nil {
+			cfg.Tenants[tenant] = map[string]bool{}
+		}
+		cfg.Tenants[tenant][name] = enabled
+	}
+	return cfg, nil
+}
+
+// parseFlagSetting accepts on and off next to the strconv booleans
+func parseFlagSetting(value string) (bool, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%q is not on or off", value)
+	}
+	return enabled, nil
+}
+
+// requireFlag answers 403 when the flag is off for the request tenant. Without a flag service,
+// e.g. in tests, every route is served.
+func requireFlag(flags service.FeatureFlagService, name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if flags != nil && !flags.Enabled(name, tenantFrom(r).Slug) {
+				writeError(w, fmt.Sprintf("Feature %s is disabled for tenant %s", name, tenantFrom(r).Slug), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// registerFeatureFlagAdminRoutes mounts the management of feature flags under the admin router
+func registerFeatureFlagAdminRoutes(r chi.Router, tenantService service.TenantService, flags service.FeatureFlagService) {
+	// GET /flags lists the stored values; GET /flags?tenant=acme resolves every flag for a tenant
+	r.Get("/flags", func(w http.ResponseWriter, r *http.Request) {
+		if slug := r.URL.Query().Get("tenant"); slug != "" {
+			if _, err := tenantService.GetTenant(slug); err != nil {
+				writeError(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			states, err := flags.Flags(slug)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, r, http.StatusOK, map[string]interface{}{"tenant": slug, "flags": states})
+			return
+		}
+		stored, err := flags.ListFlags()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, stored)
+	})
+
+	// {"enabled": true, "tenant": "acme"} turns a flag on for one merchant first
+	r.Put("/flags/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var input featureFlagRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		if input.Tenant != "" {
+			if _, err := tenantService.GetTenant(input.Tenant); err != nil {
+				writeError(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		}
+		flag, err := flags.SetFlag(chi.URLParam(r, "name"), input.Tenant, *input.Enabled, input.Description, adminActor(r))
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		log.Printf("Flag %s set to %t for tenant %q by %s", flag.Name, flag.Enabled, flag.Tenant, adminActor(r))
+		writeJSON(w, r, http.StatusOK, flag)
+	})
+
+	// DELETE /flags/{name}?tenant=acme drops the merchant's value, falling back to the global one
+	r.Delete("/flags/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name, tenant := chi.URLParam(r, "name"), r.URL.Query().Get("tenant")
+		if err := flags.DeleteFlag(name, tenant); err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		log.Printf("Flag %s for tenant %q removed by %s", name, tenant, adminActor(r))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
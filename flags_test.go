@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseFeatureFlags(t *testing.T) {
+	cfg, err := parseFeatureFlags("exports=off, acme:exports=on,new_eta=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled, ok := cfg.Values[service.FlagExports]; !ok || enabled {
+		t.Errorf("exports = %t, %t", enabled, ok)
+	}
+	if !cfg.Tenants["acme"][service.FlagExports] || !cfg.Values["new_eta"] {
+		t.Errorf("unexpected configuration %+v", cfg)
+	}
+	for _, value := range []string{"exports", "exports=maybe", "Bad Name=on"} {
+		if _, err := parseFeatureFlags(value); err == nil {
+			t.Errorf("%q accepted", value)
+		}
+	}
+}
+
+// stubFlags switches off the flags listed for each tenant
+type stubFlags struct {
+	service.FeatureFlagService
+	off map[string]string
+}
+
+func (f stubFlags) Enabled(name, tenant string) bool {
+	return f.off[tenant] != name
+}
+
+func TestRequireFlag(t *testing.T) {
+	flags := stubFlags{off: map[string]string{"acme": service.FlagExports}}
+	served := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := requireFlag(flags, service.FlagExports)(served)
+	for slug, want := range map[string]int{"acme": http.StatusForbidden, "other": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/export", nil)
+		req = req.WithContext(context.WithValue(req.Context(), tenantContextKey{}, service.Tenant{Slug: slug}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("tenant %s: status %d, want %d", slug, rec.Code, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	requireFlag(nil, service.FlagExports)(served).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("without a flag service: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// updateGolden rewrites the golden files instead of comparing against them:
+//
+//	go test -run Golden -update
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of the handler snapshot tests")
+
+// goldenHeaders are the response headers that belong to the API contract
+var goldenHeaders = []string{"Content-Type", "ETag", "Last-Modified", "Location", "Link", "X-Total-Count", orderSourceHeader}
+
+// goldenResponse is what a golden file records of a response
+type goldenResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    interface{}       `json:"body,omitempty"`
+}
+
+// assertGolden compares a response with testdata/golden/<name>.json. The values of the scrub
+// keys, at any depth, are replaced by a placeholder so times relative to now do not break the
+// snapshot; the keys themselves stay part of it.
+func assertGolden(t *testing.T, name string, rec *httptest.ResponseRecorder, scrub ...string) {
+	t.Helper()
+	snapshot := goldenResponse{Status: rec.Code, Headers: map[string]string{}}
+	for _, header := range goldenHeaders {
+		if value := rec.Header().Get(header); value != "" {
+			snapshot.Headers[header] = value
+		}
+	}
+	if body := bytes.TrimSpace(rec.Body.Bytes()); len(body) > 0 {
+		if err := json.Unmarshal(body, &snapshot.Body); err != nil {
+			// Not JSON: the text itself is the contract
+			snapshot.Body = string(body)
+		}
+	}
+	scrubbed := map[string]bool{}
+	for _, key := range scrub {
+		scrubbed[key] = true
+	}
+	snapshot.Body = scrubGolden(snapshot.Body, scrubbed)
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.Bytes()
+
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("missing golden file %s, create it with go test -run %s -update: %v", path, t.Name(), err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("response of %s changed; if intended, rerun with -update and review the diff\n--- want %s\n%s\n--- got\n%s", name, path, want, got)
+	}
+}
+
+// scrubGolden replaces the values of the scrubbed keys of a decoded JSON value
+func scrubGolden(value interface{}, scrubbed map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if scrubbed[key] {
+				v[key] = "<scrubbed>"
+				continue
+			}
+			v[key] = scrubGolden(item, scrubbed)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = scrubGolden(v[i], scrubbed)
+		}
+	}
+	return value
+}
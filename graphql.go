@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"convertyApi/graphqlapi"
+	"convertyApi/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/sync/errgroup"
+)
+
+// graphMaxBody bounds a GraphQL request body; queries are small
+const graphMaxBody = 1 << 20
+
+// graphMaxLimit bounds the limit argument of the list fields
+const graphMaxLimit = 100
+
+// Resources of the GraphQL schema. Reading one needs the policy to allow GET /graphql/<resource>,
+// so roles and service account permissions decide field by field what a query may return.
+const (
+	graphRecords   = "records"
+	graphCustomers = "customers"
+	graphOrders    = "orders"
+	graphProducts  = "products"
+)
+
+type graphContextKey struct{}
+
+// graphRequest is what the resolvers of one GraphQL request share: the caller, its data service and
+// the products already read from Converty
+type graphRequest struct {
+	r        *http.Request
+	data     service.DataService
+	tenantID uint
+	mu       sync.Mutex
+	products map[string]*service.Product
+}
+
+func graphFrom(ctx context.Context) *graphRequest {
+	return ctx.Value(graphContextKey{}).(*graphRequest)
+}
+
+// graphAllowed reports whether the caller may read a resource of the schema; tenant key callers
+// may read everything their tenant holds, like on the REST routes
+func graphAllowed(r *http.Request, resource string) bool {
+	path := "/graphql/" + resource
+	if claims, ok := userFrom(r); ok {
+		return policy().roleAllows(claims.Role, http.MethodGet, path)
+	}
+	if account, ok := serviceAccountFrom(r); ok {
+		return policy().permissionsAllow(account.PermissionList(), http.MethodGet, path)
+	}
+	return true
+}
+
+// guard resolves a field only when the caller may read its resource
+func guard(resource string, resolve graphqlapi.Resolver) graphqlapi.Resolver {
+	return func(ctx context.Context, parents []interface{}, args graphqlapi.Args) ([]interface{}, error) {
+		if !graphAllowed(graphFrom(ctx).r, resource) {
+			return nil, fmt.Errorf("not allowed to read %s", resource)
+		}
+		return resolve(ctx, parents, args)
+	}
+}
+
+// graphLimit reads the limit argument of a list field
+func graphLimit(args graphqlapi.Args) (int, error) {
+	limit := args.Int("limit")
+	if limit < 1 || limit > graphMaxLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", graphMaxLimit)
+	}
+	return limit, nil
+}
+
+// graphPage reads the limit and offset arguments of a list field
+func graphPage(args graphqlapi.Args) (limit, offset int, err error) {
+	if limit, err = graphLimit(args); err != nil {
+		return 0, 0, err
+	}
+	if offset = args.Int("offset"); offset < 0 {
+		return 0, 0, fmt.Errorf("offset must not be negative")
+	}
+	return limit, offset, nil
+}
+
+// graphPageArgs are the arguments of the paged list fields
+var graphPageArgs = []graphqlapi.Argument{
+	{Name: "limit", Type: "Int", Default: 20},
+	{Name: "offset", Type: "Int", Default: 0},
+}
+
+// newGraphSchema builds the GraphQL schema over the records, the order mirror and Converty.
+// Nested fields are resolved for a whole level at once: the orders of the selected customers come
+// from one mirror query and the products of every order line from one round of Converty lookups.
+func newGraphSchema(mirror service.OrderMirrorService) (*graphqlapi.Schema, error) {
+	record := &graphqlapi.Object{
+		Name:        "Record",
+		Description: "A chatbot interaction: an order, issue, feedback or other record",
+		FieldOrder:  []string{"id", "type", "status", "userId", "details", "version", "createdAt", "updatedAt"},
+		Fields: map[string]*graphqlapi.Field{
+			"id":        {Type: "ID!", Resolve: graphqlapi.Map(func(d service.Data) interface{} { return strconv.FormatUint(uint64(d.ID), 10) })},
+			"type":      {Type: "String!", Resolve: graphqlapi.Map(func(d service.Data) interface{} { return d.Type })},
+			"status":    {Type: "String!", Resolve: graphqlapi.Map(func(d service.Data) interface{} { return d.Status })},
+			"userId":    {Type: "Int!", Resolve: graphqlapi.Map(func(d service.Data) interface{} { return d.UserID })},
+			"details":   {Type: "JSON", Resolve: graphqlapi.Map(func(d service.Data) interface{} { return d.Details })},
+			"version":   {Type: "Int!", Resolve: graphqlapi.Map(func(d service.Data) interface{} { return d.Version })},
+			"createdAt": {Type: "Time!", Resolve: graphqlapi.Map(func(d service.Data) interface{} { return d.CreatedAt })},
+			"updatedAt": {Type: "Time!", Resolve: graphqlapi.Map(func(d service.Data) interface{} { return d.UpdatedAt })},
+		},
+	}
+
+	product := &graphqlapi.Object{
+		Name:        "Product",
+		Description: "A product of the Converty store",
+		FieldOrder:  []string{"id", "name", "category", "price", "currency", "stock", "inStock"},
+		Fields: map[string]*graphqlapi.Field{
+			"id":       {Type: "ID!", Resolve: graphqlapi.Map(func(p service.Product) interface{} { return p.ID })},
+			"name":     {Type: "String!", Resolve: graphqlapi.Map(func(p service.Product) interface{} { return p.Name })},
+			"category": {Type: "String", Resolve: graphqlapi.Map(func(p service.Product) interface{} { return p.Category })},
+			"price":    {Type: "Float!", Resolve: graphqlapi.Map(func(p service.Product) interface{} { return p.Price })},
+			"currency": {Type: "String", Resolve: graphqlapi.Map(func(p service.Product) interface{} { return p.Currency })},
+			"stock":    {Type: "Int!", Resolve: graphqlapi.Map(func(p service.Product) interface{} { return p.Stock })},
+			"inStock":  {Type: "Boolean!", Resolve: graphqlapi.Map(func(p service.Product) interface{} { return p.InStock() })},
+		},
+	}
+
+	orderItem := &graphqlapi.Object{
+		Name:        "OrderItem",
+		Description: "A product line of an order",
+		FieldOrder:  []string{"productId", "name", "category", "quantity", "price", "product"},
+		Fields: map[string]*graphqlapi.Field{
+			"productId": {Type: "ID!", Resolve: graphqlapi.Map(func(l service.OrderLine) interface{} { return l.ProductID })},
+			"name":      {Type: "String!", Resolve: graphqlapi.Map(func(l service.OrderLine) interface{} { return l.Name })},
+			"category":  {Type: "String", Resolve: graphqlapi.Map(func(l service.OrderLine) interface{} { return l.Category })},
+			"quantity":  {Type: "Int!", Resolve: graphqlapi.Map(func(l service.OrderLine) interface{} { return l.Quantity })},
+			"price":     {Type: "Float!", Resolve: graphqlapi.Map(func(l service.OrderLine) interface{} { return l.Price })},
+			"product": {
+				Type:        "Product",
+				Description: "The product as Converty has it now; null when it was deleted",
+				Resolve:     guard(graphProducts, resolveLineProducts),
+			},
+		},
+	}
+
+	order := &graphqlapi.Object{
+		Name:        "Order",
+		Description: "A Converty order",
+		FieldOrder: []string{
+			"id", "status", "total", "currency", "createdAt", "updatedAt", "deliveryCompany", "trackingNumber",
+			"customer", "items",
+		},
+		Fields: map[string]*graphqlapi.Field{
+			"id":              {Type: "ID!", Resolve: graphqlapi.Map(func(o service.Order) interface{} { return o.ID })},
+			"status":          {Type: "String!", Resolve: graphqlapi.Map(func(o service.Order) interface{} { return o.Status })},
+			"total":           {Type: "Float!", Resolve: graphqlapi.Map(func(o service.Order) interface{} { return o.Total })},
+			"currency":        {Type: "String", Resolve: graphqlapi.Map(func(o service.Order) interface{} { return o.Currency })},
+			"createdAt":       {Type: "Time!", Resolve: graphqlapi.Map(func(o service.Order) interface{} { return o.CreatedAt })},
+			"updatedAt":       {Type: "Time!", Resolve: graphqlapi.Map(func(o service.Order) interface{} { return o.UpdatedAt })},
+			"deliveryCompany": {Type: "String", Resolve: graphqlapi.Map(func(o service.Order) interface{} { return o.DeliveryCompany })},
+			"trackingNumber":  {Type: "String", Resolve: graphqlapi.Map(func(o service.Order) interface{} { return o.TrackingNumber })},
+			"items": {Type: "[OrderItem!]!", Resolve: graphqlapi.Map(func(o service.Order) interface{} {
+				if o.Items == nil {
+					return []service.OrderLine{}
+				}
+				return o.Items
+			})},
+			"customer": {
+				Type:        "Customer",
+				Description: "The customer who placed the order, null without a phone number",
+				Resolve:     guard(graphCustomers, resolveOrderCustomers(mirror)),
+			},
+		},
+	}
+
+	customer := &graphqlapi.Object{
+		Name:        "Customer",
+		Description: "A customer of the mirrored orders, identified by normalized phone number",
+		FieldOrder:  []string{"phone", "name", "email", "city", "orderCount", "lastOrderAt", "orders"},
+		Fields: map[string]*graphqlapi.Field{
+			"phone":       {Type: "String!", Resolve: graphqlapi.Map(func(c service.CustomerSummary) interface{} { return c.Phone })},
+			"name":        {Type: "String", Resolve: graphqlapi.Map(func(c service.CustomerSummary) interface{} { return c.Name })},
+			"email":       {Type: "String", Resolve: graphqlapi.Map(func(c service.CustomerSummary) interface{} { return c.Email })},
+			"city":        {Type: "String", Resolve: graphqlapi.Map(func(c service.CustomerSummary) interface{} { return c.City })},
+			"orderCount":  {Type: "Int!", Resolve: graphqlapi.Map(func(c service.CustomerSummary) interface{} { return c.Orders })},
+			"lastOrderAt": {Type: "Time", Resolve: graphqlapi.Map(func(c service.CustomerSummary) interface{} { return nullTime(c.LastOrderAt) })},
+			"orders": {
+				Type:        "[Order!]!",
+				Args:        []graphqlapi.Argument{{Name: "limit", Type: "Int", Default: 10}},
+				Description: "The customer's latest mirrored orders, newest first",
+				Resolve:     guard(graphOrders, resolveCustomerOrders(mirror)),
+			},
+		},
+	}
+
+	query := &graphqlapi.Object{
+		Name:       "Query",
+		FieldOrder: []string{"records", "record", "issues", "customers", "customer", "orders", "order", "products", "product"},
+		Fields: map[string]*graphqlapi.Field{
+			"records": {
+				Type: "[Record!]!",
+				Args: append([]graphqlapi.Argument{
+					{Name: "type", Type: "String"}, {Name: "status", Type: "String"},
+					{Name: "search", Type: "String", Description: `"key=value" matches one detail field, anything else any value`},
+				}, graphPageArgs...),
+				Resolve: guard(graphRecords, graphqlapi.Root(func(ctx context.Context, args graphqlapi.Args) (interface{}, error) {
+					return pageGraphRecords(ctx, service.RecordFilter{Type: args.String("type"), Status: args.String("status"), Text: args.String("search")}, args)
+				})),
+			},
+			"record": {
+				Type: "Record",
+				Args: []graphqlapi.Argument{{Name: "id", Type: "ID!"}},
+				Resolve: guard(graphRecords, graphqlapi.Root(func(ctx context.Context, args graphqlapi.Args) (interface{}, error) {
+					id, err := strconv.ParseUint(args.String("id"), 10, 64)
+					if err != nil {
+						return nil, fmt.Errorf("invalid record ID %q", args.String("id"))
+					}
+					record, err := graphFrom(ctx).data.QueryByID(uint(id))
+					if errors.Is(err, service.ErrNotFound) {
+						return nil, nil
+					}
+					return record, err
+				})),
+			},
+			"issues": {
+				Type: "[Record!]!",
+				Args: append([]graphqlapi.Argument{{Name: "status", Type: "String"}}, graphPageArgs...),
+				Resolve: guard(graphRecords, graphqlapi.Root(func(ctx context.Context, args graphqlapi.Args) (interface{}, error) {
+					return pageGraphRecords(ctx, service.RecordFilter{Type: "issue", Status: args.String("status")}, args)
+				})),
+			},
+			"customers": {
+				Type: "[Customer!]!",
+				Args: append([]graphqlapi.Argument{{Name: "search", Type: "String", Description: "Matches the name or the phone number"}}, graphPageArgs...),
+				Resolve: guard(graphCustomers, graphqlapi.Root(func(ctx context.Context, args graphqlapi.Args) (interface{}, error) {
+					limit, offset, err := graphPage(args)
+					if err != nil {
+						return nil, err
+					}
+					return mirror.ListCustomers(graphFrom(ctx).tenantID, service.CustomerQuery{Search: args.String("search"), Offset: offset, Limit: limit})
+				})),
+			},
+			"customer": {
+				Type: "Customer",
+				Args: []graphqlapi.Argument{{Name: "phone", Type: "String!"}},
+				Resolve: guard(graphCustomers, graphqlapi.Root(func(ctx context.Context, args graphqlapi.Args) (interface{}, error) {
+					customers, err := mirror.ListCustomers(graphFrom(ctx).tenantID, service.CustomerQuery{Phones: []string{args.String("phone")}, Limit: 1})
+					if err != nil || len(customers) == 0 {
+						return nil, err
+					}
+					return customers[0], nil
+				})),
+			},
+			"orders": {
+				Type: "[Order!]!",
+				Args: []graphqlapi.Argument{
+					{Name: "status", Type: "String"}, {Name: "search", Type: "String"},
+					{Name: "page", Type: "Int", Default: 1}, {Name: "limit", Type: "Int", Default: 20},
+				},
+				Description: "The store's orders, read live from Converty",
+				Resolve: guard(graphOrders, graphqlapi.Root(func(ctx context.Context, args graphqlapi.Args) (interface{}, error) {
+					limit, err := graphLimit(args)
+					if err != nil {
+						return nil, err
+					}
+					if args.Int("page") < 1 {
+						return nil, fmt.Errorf("page must be 1 or more")
+					}
+					query := service.CustomerOrderQuery{Page: args.Int("page"), Limit: limit, Status: args.String("status"), Search: args.String("search")}
+					return graphFrom(ctx).data.ListOrders(query)
+				})),
+			},
+			"order": {
+				Type: "Order",
+				Args: []graphqlapi.Argument{{Name: "id", Type: "ID!"}},
+				Resolve: guard(graphOrders, graphqlapi.Root(func(ctx context.Context, args graphqlapi.Args) (interface{}, error) {
+					order, err := graphFrom(ctx).data.GetOrder(args.String("id"))
+					if errors.Is(err, service.ErrNotFound) {
+						return nil, nil
+					}
+					return order, err
+				})),
+			},
+			"products": {
+				Type: "[Product!]!",
+				Args: []graphqlapi.Argument{{Name: "page", Type: "Int", Default: 1}, {Name: "limit", Type: "Int", Default: 20}},
+				Resolve: guard(graphProducts, graphqlapi.Root(func(ctx context.Context, args graphqlapi.Args) (interface{}, error) {
+					limit, err := graphLimit(args)
+					if err != nil {
+						return nil, err
+					}
+					if args.Int("page") < 1 {
+						return nil, fmt.Errorf("page must be 1 or more")
+					}
+					return graphFrom(ctx).data.ListProducts(args.Int("page"), limit)
+				})),
+			},
+			"product": {
+				Type: "Product",
+				Args: []graphqlapi.Argument{{Name: "id", Type: "ID!"}},
+				Resolve: guard(graphProducts, graphqlapi.Root(func(ctx context.Context, args graphqlapi.Args) (interface{}, error) {
+					products, err := graphFrom(ctx).loadProducts([]string{args.String("id")})
+					if err != nil {
+						return nil, err
+					}
+					if product := products[args.String("id")]; product != nil {
+						return *product, nil
+					}
+					return nil, nil
+				})),
+			},
+		},
+	}
+	return graphqlapi.NewSchema(query, record, customer, order, orderItem, product)
+}
+
+// pageGraphRecords reads a page of the records matching filter
+func pageGraphRecords(ctx context.Context, filter service.RecordFilter, args graphqlapi.Args) ([]service.Data, error) {
+	limit, offset, err := graphPage(args)
+	if err != nil {
+		return nil, err
+	}
+	records, _, err := graphFrom(ctx).data.PageRecords(filter, offset, limit)
+	return records, err
+}
+
+// resolveCustomerOrders reads the orders of every customer of the level in one mirror query
+func resolveCustomerOrders(mirror service.OrderMirrorService) graphqlapi.Resolver {
+	return func(ctx context.Context, parents []interface{}, args graphqlapi.Args) ([]interface{}, error) {
+		limit, err := graphLimit(args)
+		if err != nil {
+			return nil, err
+		}
+		phones := make([]string, len(parents))
+		for i, parent := range parents {
+			phones[i] = parent.(service.CustomerSummary).Phone
+		}
+		byPhone, err := mirror.CustomerOrders(graphFrom(ctx).tenantID, phones, limit)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(parents))
+		for i, phone := range phones {
+			orders := byPhone[service.NormalizePhone(phone)]
+			if orders == nil {
+				orders = []service.Order{}
+			}
+			values[i] = orders
+		}
+		return values, nil
+	}
+}
+
+// resolveOrderCustomers looks the customers of every order of the level up in one mirror query;
+// customers the mirror does not know yet are described from the order alone
+func resolveOrderCustomers(mirror service.OrderMirrorService) graphqlapi.Resolver {
+	return func(ctx context.Context, parents []interface{}, _ graphqlapi.Args) ([]interface{}, error) {
+		var phones []string
+		for _, parent := range parents {
+			if phone := service.NormalizePhone(parent.(service.Order).Customer.Phone); phone != "" {
+				phones = append(phones, phone)
+			}
+		}
+		known := map[string]service.CustomerSummary{}
+		if len(phones) > 0 {
+			customers, err := mirror.ListCustomers(graphFrom(ctx).tenantID, service.CustomerQuery{Phones: phones, Limit: len(phones)})
+			if err != nil {
+				return nil, err
+			}
+			for _, customer := range customers {
+				known[customer.Phone] = customer
+			}
+		}
+		values := make([]interface{}, len(parents))
+		for i, parent := range parents {
+			buyer := parent.(service.Order).Customer
+			phone := service.NormalizePhone(buyer.Phone)
+			if phone == "" {
+				continue
+			}
+			if customer, ok := known[phone]; ok {
+				values[i] = customer
+				continue
+			}
+			values[i] = service.CustomerSummary{Phone: phone, Name: buyer.Name, Email: buyer.Email, City: buyer.City}
+		}
+		return values, nil
+	}
+}
+
+// resolveLineProducts reads the distinct products of every order line of the level from Converty
+func resolveLineProducts(ctx context.Context, parents []interface{}, _ graphqlapi.Args) ([]interface{}, error) {
+	ids := make([]string, len(parents))
+	for i, parent := range parents {
+		ids[i] = parent.(service.OrderLine).ProductID
+	}
+	products, err := graphFrom(ctx).loadProducts(ids)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(parents))
+	for i, id := range ids {
+		if product := products[id]; product != nil {
+			values[i] = *product
+		}
+	}
+	return values, nil
+}
+
+// loadProducts reads the products not read yet during the request, a few at a time; products
+// Converty does not know are nil
+func (g *graphRequest) loadProducts(ids []string) (map[string]*service.Product, error) {
+	g.mu.Lock()
+	var missing []string
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if _, loaded := g.products[id]; !loaded && id != "" && !seen[id] {
+			seen[id] = true
+			missing = append(missing, id)
+		}
+	}
+	g.mu.Unlock()
+
+	var group errgroup.Group
+	group.SetLimit(service.UpstreamLimit.PageWorkers(service.PriorityInteractive))
+	for _, id := range missing {
+		group.Go(func() error {
+			product, err := g.data.GetProduct(id)
+			if err != nil && !errors.Is(err, service.ErrNotFound) {
+				return err
+			}
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if err == nil {
+				g.products[id] = &product
+			} else {
+				g.products[id] = nil
+			}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	products := make(map[string]*service.Product, len(ids))
+	for _, id := range ids {
+		products[id] = g.products[id]
+	}
+	return products, nil
+}
+
+// nullTime leaves unknown times out of the response
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// registerGraphQLRoutes mounts the GraphQL endpoint the dashboard uses to read records, customers,
+// orders and products in one round trip. GET /graphql without a query returns the schema.
+func registerGraphQLRoutes(upstream chi.Router, dataService service.DataService, mirror service.OrderMirrorService) {
+	schema, err := newGraphSchema(mirror)
+	if err != nil {
+		log.Fatalf("Invalid GraphQL schema: %v", err)
+	}
+	serve := func(w http.ResponseWriter, r *http.Request, req graphqlapi.Request) {
+		ctx := context.WithValue(r.Context(), graphContextKey{}, &graphRequest{
+			r: r, data: tenantData(r, dataService), tenantID: tenantFrom(r).ID, products: map[string]*service.Product{},
+		})
+		response, err := schema.Execute(ctx, req)
+		if err != nil {
+			writeJSON(w, r, http.StatusBadRequest, graphqlapi.Response{Errors: []graphqlapi.Error{{Message: err.Error()}}})
+			return
+		}
+		writeJSON(w, r, http.StatusOK, response)
+	}
+
+	upstream.Get("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("query") == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, schema.SDL())
+			return
+		}
+		req := graphqlapi.Request{Query: query.Get("query"), OperationName: query.Get("operationName")}
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				writeJSON(w, r, http.StatusBadRequest, graphqlapi.Response{Errors: []graphqlapi.Error{{Message: "invalid variables: " + err.Error()}}})
+				return
+			}
+		}
+		serve(w, r, req)
+	})
+
+	upstream.Post("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		var req graphqlapi.Request
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, graphMaxBody)).Decode(&req); err != nil {
+			writeJSON(w, r, bodyErrorStatus(err), graphqlapi.Response{Errors: []graphqlapi.Error{{Message: "invalid request body: " + err.Error()}}})
+			return
+		}
+		serve(w, r, req)
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGraphAllowed(t *testing.T) {
+	account := service.ServiceAccount{Name: "dashboard", Permissions: "orders:read customers:read"}
+	req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	req = req.WithContext(context.WithValue(req.Context(), serviceAccountContextKey{}, account))
+	for resource, want := range map[string]bool{graphOrders: true, graphCustomers: true, graphRecords: false, graphProducts: false} {
+		if got := graphAllowed(req, resource); got != want {
+			t.Errorf("service account reading %s = %v, want %v", resource, got, want)
+		}
+	}
+
+	viewer := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	viewer = viewer.WithContext(context.WithValue(viewer.Context(), userContextKey{}, &UserClaims{Role: roleViewer}))
+	if !policy().roleAllows(roleViewer, http.MethodPost, "/graphql") || !graphAllowed(viewer, graphRecords) {
+		t.Error("viewers cannot run read-only GraphQL queries")
+	}
+	if !graphAllowed(httptest.NewRequest(http.MethodPost, "/graphql", nil), graphRecords) {
+		t.Error("tenant key callers cannot read their records")
+	}
+}
@@ -0,0 +1,507 @@
+package graphqlapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Request is the body of a GraphQL request
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response carries the data of a query and the errors of the fields that could not be resolved;
+// those fields are null in the data
+type Response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// Error is a GraphQL error. Path lists the response keys down to the failed field; list indices
+// are left out because a field is resolved for every item of a list at once.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// RequestError rejects a request that cannot be run: a syntax error, an unknown field or argument,
+// a missing variable or a query nested too deep
+type RequestError struct {
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func requestErrorf(format string, args ...interface{}) error {
+	return &RequestError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Execute validates the query and resolves it level by level. A *RequestError means nothing was
+// resolved; otherwise the response holds the data, with errors for the fields that failed.
+func (s *Schema) Execute(ctx context.Context, req Request) (Response, error) {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return Response{}, &RequestError{Message: err.Error()}
+	}
+	operation, err := doc.Operation(req.OperationName)
+	if err != nil {
+		return Response{}, &RequestError{Message: err.Error()}
+	}
+	if operation.Kind != "query" {
+		return Response{}, requestErrorf("%s operations are not supported, only queries", operation.Kind)
+	}
+	variables, err := coerceVariables(operation.Variables, req.Variables)
+	if err != nil {
+		return Response{}, err
+	}
+	v := &validator{schema: s, doc: doc, variables: variables, args: map[*FieldSelection]Args{},
+		maxDepth: limit(s.MaxDepth, DefaultMaxDepth), maxAliases: limit(s.MaxAliases, DefaultMaxAliases)}
+	fields, err := v.selections(s.Query, operation.Selections, 1, map[string]bool{})
+	if err != nil {
+		return Response{}, err
+	}
+	maxComplexity := limit(s.MaxComplexity, DefaultMaxComplexity)
+	if cost := v.complexity(s.Query, fields, maxComplexity); cost > maxComplexity {
+		return Response{}, requestErrorf("the query is too complex: its cost exceeds %d", maxComplexity)
+	}
+	e := &executor{schema: s, args: v.args}
+	data := e.object(ctx, s.Query, []interface{}{struct{}{}}, fields, nil)
+	return Response{Data: data[0], Errors: e.errors}, nil
+}
+
+// coerceVariables applies the defaults of the operation's variables and checks the required ones
+func coerceVariables(definitions []VariableDefinition, given map[string]interface{}) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+	for _, definition := range definitions {
+		value, ok := given[definition.Name]
+		if !ok {
+			value = definition.Default
+		}
+		coerced, err := coerce(definition.Type, value, nil)
+		if err != nil {
+			return nil, requestErrorf("variable $%s: %v", definition.Name, err)
+		}
+		variables[definition.Name] = coerced
+	}
+	return variables, nil
+}
+
+// coerce checks a value against an SDL type. Numbers become int64 for Int, whether they come from
+// JSON as float64 or from Go defaults as int; variables are substituted when vars is not nil.
+func coerce(typ string, value interface{}, vars map[string]interface{}) (interface{}, error) {
+	if name, ok := value.(Variable); ok {
+		if vars == nil {
+			return nil, fmt.Errorf("variables cannot be used here")
+		}
+		defined, ok := vars[string(name)]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s is not defined by the operation", name)
+		}
+		value = defined
+	}
+	if n, ok := value.(int); ok {
+		value = int64(n)
+	}
+	nonNull := len(typ) > 0 && typ[len(typ)-1] == '!'
+	if nonNull {
+		typ = typ[:len(typ)-1]
+	}
+	if value == nil {
+		if nonNull {
+			return nil, fmt.Errorf("a value of type %s! is required", typ)
+		}
+		return nil, nil
+	}
+	if isList(typ) {
+		inner := typ[1 : len(typ)-1]
+		var items []interface{}
+		switch list := value.(type) {
+		case []interface{}:
+			items = list
+		default:
+			items = []interface{}{value}
+		}
+		coerced := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if coerced[i], err = coerce(inner, item, vars); err != nil {
+				return nil, err
+			}
+		}
+		return coerced, nil
+	}
+	switch typ {
+	case "Int":
+		switch n := value.(type) {
+		case int64:
+			if n >= math.MinInt32 && n <= math.MaxInt32 {
+				return n, nil
+			}
+		case float64:
+			if n == math.Trunc(n) && n >= math.MinInt32 && n <= math.MaxInt32 {
+				return int64(n), nil
+			}
+		}
+	case "Float":
+		switch n := value.(type) {
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case "ID":
+		switch id := value.(type) {
+		case string:
+			return id, nil
+		case int64:
+			return fmt.Sprint(id), nil
+		case float64:
+			if id == math.Trunc(id) {
+				return fmt.Sprint(int64(id)), nil
+			}
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	default:
+		return value, nil
+	}
+	return nil, fmt.Errorf("%v is not a valid %s", value, typ)
+}
+
+// limit returns configured, or def when it is not positive
+func limit(configured, def int) int {
+	if configured <= 0 {
+		return def
+	}
+	return configured
+}
+
+// validator checks a selection tree against the schema and resolves the arguments of every field
+type validator struct {
+	schema     *Schema
+	doc        *Document
+	variables  map[string]interface{}
+	args       map[*FieldSelection]Args
+	maxDepth   int
+	maxAliases int
+	aliases    int
+}
+
+// complexity estimates the cost of resolving fields on object: 1 per field, with the cost of the
+// sub-selection of a list field multiplied by the items it may return. It stops adding once the
+// cost passes max, so a query multiplying lists cannot overflow it.
+func (v *validator) complexity(object *Object, fields []*FieldSelection, max int) int {
+	cost := 0
+	for _, selection := range fields {
+		cost++
+		field, ok := object.Fields[selection.Name]
+		if !ok || len(selection.Selections) == 0 {
+			continue
+		}
+		children := make([]*FieldSelection, len(selection.Selections))
+		for i, child := range selection.Selections {
+			children[i] = child.Field
+		}
+		items := 1
+		if isList(field.Type) {
+			items = listSize(v.args[selection])
+		}
+		child := v.complexity(v.schema.types[namedType(field.Type)], children, max)
+		if child > (max-cost)/items {
+			return max + 1
+		}
+		cost += items * child
+		if cost > max {
+			return cost
+		}
+	}
+	return cost
+}
+
+// listSize is the item count a list field may return: its limit or first argument, or DefaultListSize
+func listSize(args Args) int {
+	for _, name := range []string{"limit", "first"} {
+		if n, ok := args[name].(int64); ok && n > 0 {
+			return int(n)
+		}
+	}
+	return DefaultListSize
+}
+
+// selections expands the fragments of a selection set and merges the fields selected twice under
+// the same response key
+func (v *validator) selections(object *Object, selections []Selection, depth int, spreading map[string]bool) ([]*FieldSelection, error) {
+	if depth > v.maxDepth {
+		return nil, requestErrorf("the query is nested deeper than %d levels", v.maxDepth)
+	}
+	var fields []*FieldSelection
+	byKey := map[string]*FieldSelection{}
+	var collect func(selections []Selection) error
+	collect = func(selections []Selection) error {
+		for _, selection := range selections {
+			switch {
+			case selection.Spread != "":
+				fragment, ok := v.doc.Fragments[selection.Spread]
+				if !ok {
+					return requestErrorf("unknown fragment %q", selection.Spread)
+				}
+				if spreading[fragment.Name] {
+					return requestErrorf("fragment %q spreads itself", fragment.Name)
+				}
+				if fragment.On != object.Name {
+					return requestErrorf("fragment %q on %s cannot be spread in %s", fragment.Name, fragment.On, object.Name)
+				}
+				spreading[fragment.Name] = true
+				err := collect(fragment.Selections)
+				delete(spreading, fragment.Name)
+				if err != nil {
+					return err
+				}
+			case selection.Inline != nil:
+				if on := selection.Inline.On; on != "" && on != object.Name {
+					return requestErrorf("inline fragment on %s cannot be used in %s", on, object.Name)
+				}
+				if err := collect(selection.Inline.Selections); err != nil {
+					return err
+				}
+			default:
+				field := selection.Field
+				if field.Alias != "" {
+					if v.aliases++; v.aliases > v.maxAliases {
+						return requestErrorf("the query uses more than %d aliases", v.maxAliases)
+					}
+				}
+				if seen, ok := byKey[field.Key()]; ok {
+					if seen.Name != field.Name {
+						return requestErrorf("%s selects both %s and %s", field.Key(), seen.Name, field.Name)
+					}
+					merged := *seen
+					merged.Selections = append(append([]Selection{}, seen.Selections...), field.Selections...)
+					*seen = merged
+					continue
+				}
+				copied := *field
+				byKey[field.Key()] = &copied
+				fields = append(fields, &copied)
+			}
+		}
+		return nil
+	}
+	if err := collect(selections); err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if err := v.field(object, field, depth, spreading); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+func (v *validator) field(object *Object, selection *FieldSelection, depth int, spreading map[string]bool) error {
+	if selection.Name == "__typename" {
+		if len(selection.Selections) > 0 || len(selection.Arguments) > 0 {
+			return requestErrorf("__typename takes no arguments or sub-selection")
+		}
+		return nil
+	}
+	field, ok := object.Fields[selection.Name]
+	if !ok {
+		return requestErrorf("cannot query field %q on type %s", selection.Name, object.Name)
+	}
+	args := Args{}
+	declared := map[string]bool{}
+	for _, arg := range field.Args {
+		declared[arg.Name] = true
+		value, given := selection.Arguments[arg.Name]
+		if !given {
+			value = arg.Default
+		}
+		coerced, err := coerce(arg.Type, value, v.variables)
+		if err != nil {
+			return requestErrorf("argument %s of %s.%s: %v", arg.Name, object.Name, selection.Name, err)
+		}
+		args[arg.Name] = coerced
+	}
+	for name := range selection.Arguments {
+		if !declared[name] {
+			return requestErrorf("unknown argument %q on field %s.%s", name, object.Name, selection.Name)
+		}
+	}
+	v.args[selection] = args
+
+	named := namedType(field.Type)
+	child := v.schema.types[named]
+	if child == nil {
+		if len(selection.Selections) > 0 {
+			return requestErrorf("field %s.%s of type %s has no sub-fields", object.Name, selection.Name, field.Type)
+		}
+		return nil
+	}
+	if len(selection.Selections) == 0 {
+		return requestErrorf("field %s.%s of type %s must select sub-fields", object.Name, selection.Name, field.Type)
+	}
+	fields, err := v.selections(child, selection.Selections, depth+1, spreading)
+	if err != nil {
+		return err
+	}
+	selection.Selections = make([]Selection, len(fields))
+	for i, field := range fields {
+		selection.Selections[i] = Selection{Field: field}
+	}
+	return nil
+}
+
+// executor resolves a validated query breadth first
+type executor struct {
+	schema *Schema
+	args   map[*FieldSelection]Args
+	errors []Error
+}
+
+// object resolves fields for every parent and returns one result object per parent
+func (e *executor) object(ctx context.Context, object *Object, parents []interface{}, fields []*FieldSelection, path []interface{}) []*resultObject {
+	results := make([]*resultObject, len(parents))
+	for i := range results {
+		results[i] = &resultObject{values: map[string]interface{}{}}
+	}
+	for _, selection := range fields {
+		key := selection.Key()
+		if selection.Name == "__typename" {
+			for _, result := range results {
+				result.set(key, object.Name)
+			}
+			continue
+		}
+		fieldPath := append(append([]interface{}{}, path...), key)
+		field := object.Fields[selection.Name]
+		values, err := field.Resolve(ctx, parents, e.args[selection])
+		if err == nil && len(values) != len(parents) {
+			err = fmt.Errorf("resolver of %s.%s returned %d values for %d parents", object.Name, selection.Name, len(values), len(parents))
+		}
+		if err != nil {
+			e.errors = append(e.errors, Error{Message: err.Error(), Path: fieldPath})
+			for _, result := range results {
+				result.set(key, nil)
+			}
+			continue
+		}
+		child := e.schema.types[namedType(field.Type)]
+		if child == nil {
+			for i, result := range results {
+				result.set(key, values[i])
+			}
+			continue
+		}
+		var subFields []*FieldSelection
+		for _, sub := range selection.Selections {
+			subFields = append(subFields, sub.Field)
+		}
+		e.nested(ctx, child, isList(field.Type), values, subFields, fieldPath, func(i int, value interface{}) {
+			results[i].set(key, value)
+		})
+	}
+	return results
+}
+
+// nested resolves the objects every parent's value holds in one batch and hands each parent its
+// result: an object, a list of objects or null
+func (e *executor) nested(ctx context.Context, object *Object, list bool, values []interface{}, fields []*FieldSelection, path []interface{}, set func(i int, value interface{})) {
+	var children []interface{}
+	counts := make([]int, len(values))
+	for i, value := range values {
+		if isNil(value) {
+			counts[i] = -1
+			continue
+		}
+		if !list {
+			children = append(children, value)
+			counts[i] = 1
+			continue
+		}
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice {
+			counts[i] = -1
+			continue
+		}
+		for j := 0; j < items.Len(); j++ {
+			children = append(children, items.Index(j).Interface())
+		}
+		counts[i] = items.Len()
+	}
+	results := e.object(ctx, object, children, fields, path)
+	offset := 0
+	for i, count := range counts {
+		switch {
+		case count < 0:
+			set(i, nil)
+		case !list:
+			set(i, results[offset])
+			offset++
+		default:
+			items := make([]*resultObject, count)
+			copy(items, results[offset:offset+count])
+			set(i, items)
+			offset += count
+		}
+	}
+}
+
+func isNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// resultObject is a result object whose keys keep the order of the selection
+type resultObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *resultObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *resultObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", key, err)
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
@@ -0,0 +1,222 @@
+package graphqlapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testAuthor struct {
+	ID   int
+	Name string
+}
+
+type testBook struct {
+	Title    string
+	AuthorID int
+}
+
+// newTestSchema serves authors and their books, counting the book lookups
+func newTestSchema(t *testing.T, bookLookups *int) *Schema {
+	t.Helper()
+	books := []testBook{{"Dune", 1}, {"Children of Dune", 1}, {"Solaris", 2}}
+	book := &Object{
+		Name:       "Book",
+		FieldOrder: []string{"title"},
+		Fields: map[string]*Field{
+			"title": {Type: "String!", Resolve: Map(func(b testBook) interface{} { return b.Title })},
+		},
+	}
+	author := &Object{
+		Name: "Author",
+		Fields: map[string]*Field{
+			"id":   {Type: "ID!", Resolve: Map(func(a testAuthor) interface{} { return fmt.Sprint(a.ID) })},
+			"name": {Type: "String!", Resolve: Map(func(a testAuthor) interface{} { return a.Name })},
+			"books": {
+				Type: "[Book!]!",
+				Args: []Argument{{Name: "limit", Type: "Int", Default: 10}},
+				Resolve: func(_ context.Context, parents []interface{}, args Args) ([]interface{}, error) {
+					*bookLookups++
+					values := make([]interface{}, len(parents))
+					for i, parent := range parents {
+						var own []testBook
+						for _, b := range books {
+							if b.AuthorID == parent.(testAuthor).ID && len(own) < args.Int("limit") {
+								own = append(own, b)
+							}
+						}
+						values[i] = own
+					}
+					return values, nil
+				},
+			},
+			"agent": {Type: "String", Resolve: func(context.Context, []interface{}, Args) ([]interface{}, error) {
+				return nil, errors.New("agent unavailable")
+			}},
+		},
+	}
+	query := &Object{
+		Name: "Query",
+		Fields: map[string]*Field{
+			"authors": {Type: "[Author!]!", Args: []Argument{{Name: "name", Type: "String"}}, Resolve: Root(func(_ context.Context, args Args) (interface{}, error) {
+				authors := []testAuthor{{1, "Frank Herbert"}, {2, "Stanislaw Lem"}}
+				if name := args.String("name"); name != "" {
+					for _, a := range authors {
+						if a.Name == name {
+							return []testAuthor{a}, nil
+						}
+					}
+					return []testAuthor{}, nil
+				}
+				return authors, nil
+			})},
+			"author": {Type: "Author", Args: []Argument{{Name: "id", Type: "ID!"}}, Resolve: Root(func(_ context.Context, args Args) (interface{}, error) {
+				if args.String("id") == "1" {
+					return testAuthor{1, "Frank Herbert"}, nil
+				}
+				return nil, nil
+			})},
+		},
+	}
+	schema, err := NewSchema(query, author, book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func execute(t *testing.T, schema *Schema, req Request) string {
+	t.Helper()
+	response, err := schema.Execute(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestExecuteBatchesNestedFields(t *testing.T) {
+	lookups := 0
+	schema := newTestSchema(t, &lookups)
+	got := execute(t, schema, Request{Query: `{ authors { name books(limit: 1) { title } } }`})
+	want := `{"data":{"authors":[{"name":"Frank Herbert","books":[{"title":"Dune"}]},{"name":"Stanislaw Lem","books":[{"title":"Solaris"}]}]}}`
+	if got != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+	if lookups != 1 {
+		t.Fatalf("books were looked up %d times, want once for every author together", lookups)
+	}
+}
+
+func TestExecuteAliasesFragmentsAndVariables(t *testing.T) {
+	lookups := 0
+	schema := newTestSchema(t, &lookups)
+	got := execute(t, schema, Request{
+		Query: `
+			query Author($id: ID!, $name: String = "Stanislaw Lem") {
+				herbert: author(id: $id) { ...names books { title } }
+				lem: authors(name: $name) { __typename ... on Author { id } }
+				missing: author(id: "2") { name }
+			}
+			fragment names on Author { id name }`,
+		Variables: map[string]interface{}{"id": 1},
+	})
+	want := `{"data":{"herbert":{"id":"1","name":"Frank Herbert","books":[{"title":"Dune"},{"title":"Children of Dune"}]},` +
+		`"lem":[{"__typename":"Author","id":"2"}],"missing":null}}`
+	if got != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestExecuteFieldErrors(t *testing.T) {
+	lookups := 0
+	schema := newTestSchema(t, &lookups)
+	got := execute(t, schema, Request{Query: `{ authors { name agent } }`})
+	want := `{"data":{"authors":[{"name":"Frank Herbert","agent":null},{"name":"Stanislaw Lem","agent":null}]},` +
+		`"errors":[{"message":"agent unavailable","path":["authors","agent"]}]}`
+	if got != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestExecuteRejectsInvalidRequests(t *testing.T) {
+	lookups := 0
+	schema := newTestSchema(t, &lookups)
+	schema.MaxDepth = 2
+	for _, c := range []struct {
+		query string
+		want  string
+	}{
+		{`{ authors { name `, "unexpected end"},
+		{`{ authors { nickname } }`, `cannot query field "nickname" on type Author`},
+		{`{ authors }`, "must select sub-fields"},
+		{`{ authors { name { first } } }`, "has no sub-fields"},
+		{`{ author { name } }`, "a value of type ID! is required"},
+		{`{ authors(country: "PL") { name } }`, `unknown argument "country"`},
+		{`{ authors { books(limit: "two") { title } } }`, "is not a valid Int"},
+		{`query($id: ID!) { author(id: $id) { name } }`, "variable $id"},
+		{`{ author(id: $id) { name } }`, "not defined by the operation"},
+		{`{ authors { ...missing } }`, `unknown fragment "missing"`},
+		{`{ authors { ...loop } } fragment loop on Author { ...loop }`, "spreads itself"},
+		{`{ authors { books { title } } }`, "nested deeper than 2 levels"},
+		{`mutation { authors { name } }`, "only queries"},
+		{`query A { authors { name } } query B { authors { id } }`, "operationName must name one"},
+	} {
+		_, err := schema.Execute(context.Background(), Request{Query: c.query})
+		var requestErr *RequestError
+		if !errors.As(err, &requestErr) || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want a request error containing %q", c.query, err, c.want)
+		}
+	}
+}
+
+func TestExecuteLimitsComplexityAndAliases(t *testing.T) {
+	lookups := 0
+	schema := newTestSchema(t, &lookups)
+	schema.MaxComplexity = 100
+	schema.MaxAliases = 2
+
+	// authors may hold DefaultListSize items: 1 + 10 * (1 + 2 books) = 31
+	if _, err := schema.Execute(context.Background(), Request{Query: `{ authors { books(limit: 2) { title } } }`}); err != nil {
+		t.Fatalf("cheap query refused: %v", err)
+	}
+	for _, c := range []struct {
+		query string
+		want  string
+	}{
+		// 1 + 10 * (1 + 10 books)
+		{`{ authors { books { title } } }`, "too complex"},
+		{`{ authors { books(limit: 1000000000) { title } } }`, "too complex"},
+		{`{ a: authors { name } b: authors { name } c: authors { name } }`, "more than 2 aliases"},
+		{`{ authors { ...f ...f ...f } } fragment f on Author { n: name }`, "more than 2 aliases"},
+	} {
+		_, err := schema.Execute(context.Background(), Request{Query: c.query})
+		var requestErr *RequestError
+		if !errors.As(err, &requestErr) || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want a request error containing %q", c.query, err, c.want)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("rejected queries were resolved: %d book lookups", lookups)
+	}
+}
+
+func TestSchemaSDL(t *testing.T) {
+	lookups := 0
+	sdl := newTestSchema(t, &lookups).SDL()
+	for _, want := range []string{
+		"type Query {\n  author(id: ID!): Author\n  authors(name: String): [Author!]!\n}",
+		"  books(limit: Int = 10): [Book!]!\n",
+		"type Book {\n  title: String!\n}",
+	} {
+		if !strings.Contains(sdl, want) {
+			t.Errorf("SDL misses %q:\n%s", want, sdl)
+		}
+	}
+}
@@ -0,0 +1,619 @@
+// Package graphqlapi runs GraphQL queries against a schema declared in Go. It covers what the
+// dashboard needs from the language: queries with aliases, arguments, variables and fragments, and
+// resolves every level of the result in one batch per field. Mutations, subscriptions, directives
+// and introspection are not supported; Schema.SDL describes the schema instead.
+//
+// It is kept instead of a generated server such as gqlgen because the read-only schema is declared
+// next to the REST handlers it shares resolvers with, and batching per level is what keeps order and
+// customer lookups to one upstream call each. Every query is bounded before anything is resolved by
+// Schema.MaxDepth, MaxComplexity and MaxAliases.
+package graphqlapi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Document is a parsed GraphQL request document
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation is a query, mutation or subscription of a document
+type Operation struct {
+	Kind       string
+	Name       string
+	Variables  []VariableDefinition
+	Selections []Selection
+}
+
+// VariableDefinition declares an operation variable; Default is nil without a default value
+type VariableDefinition struct {
+	Name    string
+	Type    string
+	Default Value
+}
+
+// Fragment is a named fragment definition
+type Fragment struct {
+	Name       string
+	On         string
+	Selections []Selection
+}
+
+// Selection is a field, a fragment spread or an inline fragment; exactly one is set
+type Selection struct {
+	Field  *FieldSelection
+	Spread string
+	Inline *Fragment
+}
+
+// FieldSelection is a selected field with its alias, arguments and sub-selections
+type FieldSelection struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]Value
+	Selections []Selection
+}
+
+// Key is the name of the field in the response
+func (f *FieldSelection) Key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// Value is a literal argument value: nil, bool, int64, float64, string, Enum, Variable, []Value or
+// map[string]Value
+type Value = interface{}
+
+// Enum is an unquoted enum value
+type Enum string
+
+// Variable references an operation variable
+type Variable string
+
+// Parse reads a GraphQL document
+func Parse(source string) (*Document, error) {
+	p := &parser{lexer: lexer{src: source}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &Document{Fragments: map[string]*Fragment{}}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.tok.is(tokPunct, "{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &Operation{Kind: "query", Selections: selections})
+		case p.tok.is(tokName, "fragment"):
+			fragment, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.Fragments[fragment.Name]; ok {
+				return nil, fmt.Errorf("fragment %q is defined twice", fragment.Name)
+			}
+			doc.Fragments[fragment.Name] = fragment
+		case p.tok.is(tokName, "query"), p.tok.is(tokName, "mutation"), p.tok.is(tokName, "subscription"):
+			operation, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, operation)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, fmt.Errorf("the document has no operation")
+	}
+	return doc, nil
+}
+
+// Operation picks the operation to run: the named one, or the only one when name is empty
+func (d *Document) Operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(d.Operations) > 1 {
+			return nil, fmt.Errorf("the document has several operations, operationName must name one")
+		}
+		return d.Operations[0], nil
+	}
+	for _, operation := range d.Operations {
+		if operation.Name == name {
+			return operation, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+type parser struct {
+	lexer lexer
+	tok   token
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return fmt.Errorf("syntax error: unexpected end of document")
+	}
+	return fmt.Errorf("syntax error at offset %d: unexpected %q", p.tok.pos, p.tok.text)
+}
+
+// expect consumes the punctuator text or fails
+func (p *parser) expect(text string) error {
+	if !p.tok.is(tokPunct, text) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+// skip consumes the punctuator text when it comes next
+func (p *parser) skip(text string) (bool, error) {
+	if !p.tok.is(tokPunct, text) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.text
+	return name, p.advance()
+}
+
+func (p *parser) operation() (*Operation, error) {
+	operation := &Operation{Kind: p.tok.text}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokName {
+		operation.Name = p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if ok, err := p.skip("("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.tok.is(tokPunct, ")") {
+			definition, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			operation.Variables = append(operation.Variables, definition)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.directives(); err != nil {
+		return nil, err
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	operation.Selections = selections
+	return operation, nil
+}
+
+func (p *parser) variableDefinition() (VariableDefinition, error) {
+	if err := p.expect("$"); err != nil {
+		return VariableDefinition{}, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return VariableDefinition{}, err
+	}
+	if err := p.expect(":"); err != nil {
+		return VariableDefinition{}, err
+	}
+	typ, err := p.typeRef()
+	if err != nil {
+		return VariableDefinition{}, err
+	}
+	definition := VariableDefinition{Name: name, Type: typ}
+	if ok, err := p.skip("="); err != nil {
+		return VariableDefinition{}, err
+	} else if ok {
+		if definition.Default, err = p.value(true); err != nil {
+			return VariableDefinition{}, err
+		}
+	}
+	return definition, nil
+}
+
+// typeRef reads a type reference such as [String!]! back into its text
+func (p *parser) typeRef() (string, error) {
+	var typ string
+	if ok, err := p.skip("["); err != nil {
+		return "", err
+	} else if ok {
+		inner, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if ok, err := p.skip("!"); err != nil {
+		return "", err
+	} else if ok {
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *parser) fragment() (*Fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, fmt.Errorf("syntax error: a fragment cannot be named \"on\"")
+	}
+	if !p.tok.is(tokName, "on") {
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	on, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.directives(); err != nil {
+		return nil, err
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &Fragment{Name: name, On: on, Selections: selections}, nil
+}
+
+func (p *parser) selectionSet() ([]Selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []Selection
+	for !p.tok.is(tokPunct, "}") {
+		selection, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("syntax error at offset %d: empty selection set", p.tok.pos)
+	}
+	return selections, p.advance()
+}
+
+func (p *parser) selection() (Selection, error) {
+	if ok, err := p.skip("..."); err != nil {
+		return Selection{}, err
+	} else if ok {
+		if p.tok.kind == tokName && p.tok.text != "on" {
+			name := p.tok.text
+			if err := p.advance(); err != nil {
+				return Selection{}, err
+			}
+			return Selection{Spread: name}, p.directives()
+		}
+		inline := &Fragment{}
+		if p.tok.is(tokName, "on") {
+			if err := p.advance(); err != nil {
+				return Selection{}, err
+			}
+			on, err := p.name()
+			if err != nil {
+				return Selection{}, err
+			}
+			inline.On = on
+		}
+		if err := p.directives(); err != nil {
+			return Selection{}, err
+		}
+		selections, err := p.selectionSet()
+		if err != nil {
+			return Selection{}, err
+		}
+		inline.Selections = selections
+		return Selection{Inline: inline}, nil
+	}
+
+	field := &FieldSelection{}
+	name, err := p.name()
+	if err != nil {
+		return Selection{}, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return Selection{}, err
+	} else if ok {
+		field.Alias = name
+		if name, err = p.name(); err != nil {
+			return Selection{}, err
+		}
+	}
+	field.Name = name
+	if field.Arguments, err = p.arguments(); err != nil {
+		return Selection{}, err
+	}
+	if err := p.directives(); err != nil {
+		return Selection{}, err
+	}
+	if p.tok.is(tokPunct, "{") {
+		if field.Selections, err = p.selectionSet(); err != nil {
+			return Selection{}, err
+		}
+	}
+	return Selection{Field: field}, nil
+}
+
+func (p *parser) arguments() (map[string]Value, error) {
+	if ok, err := p.skip("("); err != nil || !ok {
+		return nil, err
+	}
+	arguments := map[string]Value{}
+	for !p.tok.is(tokPunct, ")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.value(false)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := arguments[name]; ok {
+			return nil, fmt.Errorf("argument %q is given twice", name)
+		}
+		arguments[name] = value
+	}
+	return arguments, p.advance()
+}
+
+// directives rejects directives, which no field of the schema supports
+func (p *parser) directives() error {
+	if p.tok.is(tokPunct, "@") {
+		return fmt.Errorf("directives are not supported (offset %d)", p.tok.pos)
+	}
+	return nil
+}
+
+// value reads a literal; constant values, such as variable defaults, cannot reference variables
+func (p *parser) value(constant bool) (Value, error) {
+	tok := p.tok
+	switch {
+	case tok.is(tokPunct, "$") && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		return Variable(name), nil
+	case tok.is(tokPunct, "["):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		list := []Value{}
+		for !p.tok.is(tokPunct, "]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, p.advance()
+	case tok.is(tokPunct, "{"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		object := map[string]Value{}
+		for !p.tok.is(tokPunct, "}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if object[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return object, p.advance()
+	case tok.kind == tokString:
+		return tok.text, p.advance()
+	case tok.kind == tokInt:
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %s", tok.text)
+		}
+		return n, p.advance()
+	case tok.kind == tokFloat:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", tok.text)
+		}
+		return f, p.advance()
+	case tok.kind == tokName:
+		var value Value
+		switch tok.text {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			value = Enum(tok.text)
+		}
+		return value, p.advance()
+	}
+	return nil, p.unexpected()
+}
+
+const (
+	tokEOF = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+func (t token) is(kind int, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+// lexer splits a document into tokens, skipping whitespace, commas and comments
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			l.pos++
+			continue
+		}
+		if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		}
+		break
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokPunct, text: "...", pos: start}, nil
+	case strings.IndexByte("!$&()[]{}:=@|", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, text: string(c), pos: start}, nil
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokName, text: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		return l.string()
+	}
+	return token{}, fmt.Errorf("syntax error at offset %d: unexpected character %q", start, c)
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := func() {
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+	}
+	digits()
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokFloat
+		l.pos++
+		digits()
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		digits()
+	}
+	text := l.src[start:l.pos]
+	if text == "-" || strings.HasSuffix(text, ".") || strings.HasSuffix(text, "e") || strings.HasSuffix(text, "E") {
+		return token{}, fmt.Errorf("syntax error at offset %d: invalid number %q", start, text)
+	}
+	return token{kind: kind, text: text, pos: start}, nil
+}
+
+// string reads a quoted string; block strings are not supported
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		return token{}, fmt.Errorf("syntax error at offset %d: block strings are not supported", start)
+	}
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos += 2
+			continue
+		case '\n':
+			return token{}, fmt.Errorf("syntax error at offset %d: unterminated string", start)
+		case '"':
+			l.pos++
+			text, err := strconv.Unquote(strings.ReplaceAll(l.src[start:l.pos], `\/`, "/"))
+			if err != nil {
+				return token{}, fmt.Errorf("syntax error at offset %d: invalid string", start)
+			}
+			return token{kind: tokString, text: text, pos: start}, nil
+		}
+		l.pos++
+	}
+	return token{}, fmt.Errorf("syntax error at offset %d: unterminated string", start)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
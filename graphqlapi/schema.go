@@ -0,0 +1,238 @@
+package graphqlapi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Resolver resolves a field for a batch of parents at once and returns one value per parent, in
+// order. Resolving a whole level of the result in one call is what batches the lookups of nested
+// fields: the orders of every selected customer are read together, not customer by customer.
+type Resolver func(ctx context.Context, parents []interface{}, args Args) ([]interface{}, error)
+
+// Argument declares a field argument; Default applies when the query leaves it out
+type Argument struct {
+	Name        string
+	Type        string
+	Default     interface{}
+	Description string
+}
+
+// Field declares a field of an object type. Type is written in SDL, e.g. "[Order!]!"; a field whose
+// named type is an object of the schema needs a sub-selection, any other is a leaf.
+type Field struct {
+	Type        string
+	Args        []Argument
+	Description string
+	Resolve     Resolver
+}
+
+// Object is an object type; Fields are served in the order of FieldOrder, then by name
+type Object struct {
+	Name        string
+	Description string
+	Fields      map[string]*Field
+	FieldOrder  []string
+}
+
+// Schema holds the object types reachable from the query root
+type Schema struct {
+	Query *Object
+	// MaxDepth bounds the nesting of a query; 0 means DefaultMaxDepth
+	MaxDepth int
+	// MaxComplexity bounds the estimated cost of a query; 0 means DefaultMaxComplexity
+	MaxComplexity int
+	// MaxAliases bounds the aliased fields of a query, fragments counted where spread; 0 means DefaultMaxAliases
+	MaxAliases int
+	types      map[string]*Object
+}
+
+// Default limits of a query
+const (
+	// DefaultMaxDepth is the deepest nesting a query may select
+	DefaultMaxDepth = 8
+	// DefaultMaxComplexity is the highest cost a query may have: every resolved field costs 1 and
+	// the fields under a list count once per item the list may hold
+	DefaultMaxComplexity = 10000
+	// DefaultMaxAliases is the most aliased fields a query may select
+	DefaultMaxAliases = 30
+	// DefaultListSize is the item count assumed for a list field without a limit or first argument
+	DefaultListSize = 10
+)
+
+// NewSchema collects the object types and checks that every field can be resolved
+func NewSchema(query *Object, types ...*Object) (*Schema, error) {
+	schema := &Schema{Query: query, types: map[string]*Object{query.Name: query}}
+	for _, object := range types {
+		if _, ok := schema.types[object.Name]; ok {
+			return nil, fmt.Errorf("type %s is declared twice", object.Name)
+		}
+		schema.types[object.Name] = object
+	}
+	for _, object := range schema.types {
+		for name, field := range object.Fields {
+			if field.Resolve == nil {
+				return nil, fmt.Errorf("field %s.%s has no resolver", object.Name, name)
+			}
+			if named := namedType(field.Type); !isScalar(named) && schema.types[named] == nil {
+				return nil, fmt.Errorf("field %s.%s has unknown type %s", object.Name, name, named)
+			}
+		}
+	}
+	return schema, nil
+}
+
+// SDL describes the schema in the GraphQL schema definition language
+func (s *Schema) SDL() string {
+	names := make([]string, 0, len(s.types))
+	for name := range s.types {
+		if name != s.Query.Name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("scalar JSON\n\nscalar Time\n")
+	for _, name := range append([]string{s.Query.Name}, names...) {
+		object := s.types[name]
+		b.WriteString("\n")
+		writeDescription(&b, "", object.Description)
+		fmt.Fprintf(&b, "type %s {\n", object.Name)
+		for _, fieldName := range object.fieldNames() {
+			field := object.Fields[fieldName]
+			writeDescription(&b, "  ", field.Description)
+			b.WriteString("  " + fieldName)
+			if len(field.Args) > 0 {
+				args := make([]string, len(field.Args))
+				for i, arg := range field.Args {
+					args[i] = arg.Name + ": " + arg.Type
+					if arg.Default != nil {
+						args[i] += fmt.Sprintf(" = %v", formatDefault(arg.Default))
+					}
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + field.Type + "\n")
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+func writeDescription(b *strings.Builder, indent, description string) {
+	if description != "" {
+		fmt.Fprintf(b, "%s%q\n", indent, description)
+	}
+}
+
+func formatDefault(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(value)
+}
+
+// fieldNames lists the fields in FieldOrder first, then the others by name
+func (o *Object) fieldNames() []string {
+	seen := map[string]bool{}
+	names := make([]string, 0, len(o.Fields))
+	for _, name := range o.FieldOrder {
+		if _, ok := o.Fields[name]; ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	var rest []string
+	for name := range o.Fields {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+// namedType strips the list and non-null markers of an SDL type
+func namedType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+func isList(typ string) bool {
+	return strings.HasPrefix(typ, "[")
+}
+
+func isScalar(name string) bool {
+	switch name {
+	case "String", "Int", "Float", "Boolean", "ID", "JSON", "Time":
+		return true
+	}
+	return false
+}
+
+// Args are the resolved arguments of a field, defaults included
+type Args map[string]interface{}
+
+// String returns a string or ID argument, empty when null
+func (a Args) String(name string) string {
+	switch value := a[name].(type) {
+	case string:
+		return value
+	case Enum:
+		return string(value)
+	case int64:
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// Int returns an integer argument, 0 when null
+func (a Args) Int(name string) int {
+	switch value := a[name].(type) {
+	case int64:
+		return int(value)
+	case int:
+		return value
+	case float64:
+		return int(value)
+	}
+	return 0
+}
+
+// Bool returns a boolean argument, false when null
+func (a Args) Bool(name string) bool {
+	value, _ := a[name].(bool)
+	return value
+}
+
+// Has reports whether the argument was given a non-null value
+func (a Args) Has(name string) bool {
+	return a[name] != nil
+}
+
+// Map is a leaf Resolver reading the same property of every parent
+func Map[T any](get func(parent T) interface{}) Resolver {
+	return func(_ context.Context, parents []interface{}, _ Args) ([]interface{}, error) {
+		values := make([]interface{}, len(parents))
+		for i, parent := range parents {
+			values[i] = get(parent.(T))
+		}
+		return values, nil
+	}
+}
+
+// Root is a Resolver for the fields of the query root, which has a single parent
+func Root(resolve func(ctx context.Context, args Args) (interface{}, error)) Resolver {
+	return func(ctx context.Context, parents []interface{}, args Args) ([]interface{}, error) {
+		value, err := resolve(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(parents))
+		for i := range values {
+			values[i] = value
+		}
+		return values, nil
+	}
+}
@@ -0,0 +1,99 @@
+package grpcapi
+
+import (
+	"context"
+	"convertyApi/grpcapi/pb"
+	"convertyApi/service"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// APIKeyMetadata is the metadata key carrying the tenant or service account API key,
+// the gRPC counterpart of the X-API-Key header
+const APIKeyMetadata = "x-api-key"
+
+// ErrForbidden is returned by an Authenticator when the caller is known but may not use the route
+var ErrForbidden = errors.New("forbidden")
+
+// Authenticator resolves the tenant of an API key and checks that the key may send method and
+// path, the HTTP request equivalent to the RPC. Any error other than ErrForbidden rejects the key.
+type Authenticator func(apiKey, method, path string) (service.Tenant, error)
+
+// route is the HTTP request an RPC is authorized as
+type route struct {
+	method, path string
+}
+
+// methodRoutes maps every RPC to the HTTP route with the same effect, so the RBAC policy of the
+// HTTP API applies unchanged. An RPC missing here is refused.
+var methodRoutes = map[string]route{
+	pb.DataService_ListRecords_FullMethodName:  {http.MethodGet, "/api/v1/records"},
+	pb.DataService_GetRecord_FullMethodName:    {http.MethodGet, "/api/v1/records/{id}"},
+	pb.DataService_GetRecords_FullMethodName:   {http.MethodGet, "/api/v1/records"},
+	pb.DataService_InsertRecord_FullMethodName: {http.MethodPost, "/api/v1/records"},
+	pb.DataService_ListIssues_FullMethodName:   {http.MethodGet, "/api/v1/records"},
+	pb.DataService_ListOrders_FullMethodName:   {http.MethodGet, "/api/v1/orders"},
+	pb.DataService_GetOrder_FullMethodName:     {http.MethodGet, "/api/v1/orders/{id}"},
+}
+
+type tenantContextKey struct{}
+
+// tenantFrom returns the tenant the interceptors resolved for the call
+func tenantFrom(ctx context.Context) (service.Tenant, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(service.Tenant)
+	return tenant, ok
+}
+
+// authorize resolves the caller of fullMethod from the incoming metadata
+func (a Authenticator) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	rt, ok := methodRoutes[fullMethod]
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not available", fullMethod)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(APIKeyMetadata)
+	if len(keys) == 0 || keys[0] == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "missing %s metadata", APIKeyMetadata)
+	}
+	tenant, err := a(keys[0], rt.method, rt.path)
+	if errors.Is(err, ErrForbidden) {
+		return nil, status.Errorf(codes.PermissionDenied, "the API key may not call %s", fullMethod)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	return context.WithValue(ctx, tenantContextKey{}, tenant), nil
+}
+
+// UnaryInterceptor authorizes unary RPCs
+func (a Authenticator) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor authorizes streaming RPCs
+func (a Authenticator) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authorizedStream carries the resolved tenant in its context
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,112 @@
+package grpcapi
+
+import (
+	"context"
+	"convertyApi/grpcapi/pb"
+	"convertyApi/service"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// stubAuthenticator knows a tenant key and a read-only key
+func stubAuthenticator(apiKey, method, path string) (service.Tenant, error) {
+	switch apiKey {
+	case "tk_shop":
+		return service.Tenant{ID: 7, Slug: "shop"}, nil
+	case "sa_reader":
+		if method != "GET" {
+			return service.Tenant{}, ErrForbidden
+		}
+		return service.Tenant{ID: 7, Slug: "shop"}, nil
+	}
+	return service.Tenant{}, errors.New("unknown API key")
+}
+
+func withKey(key string) context.Context {
+	if key == "" {
+		return context.Background()
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyMetadata, key))
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	auth := Authenticator(stubAuthenticator)
+	for _, c := range []struct {
+		key, method string
+		want        codes.Code
+	}{
+		{"tk_shop", pb.DataService_InsertRecord_FullMethodName, codes.OK},
+		{"sa_reader", pb.DataService_GetRecord_FullMethodName, codes.OK},
+		{"sa_reader", pb.DataService_InsertRecord_FullMethodName, codes.PermissionDenied},
+		{"tk_wrong", pb.DataService_GetRecord_FullMethodName, codes.Unauthenticated},
+		{"", pb.DataService_GetRecord_FullMethodName, codes.Unauthenticated},
+		{"tk_shop", "/convertyapi.v1.DataService/DropRecords", codes.PermissionDenied},
+	} {
+		var seen service.Tenant
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			seen, _ = tenantFrom(ctx)
+			return nil, nil
+		}
+		_, err := auth.UnaryInterceptor(withKey(c.key), nil, &grpc.UnaryServerInfo{FullMethod: c.method}, handler)
+		if got := status.Code(err); got != c.want {
+			t.Errorf("%s with %q: got %v, want %v", c.method, c.key, got, c.want)
+		}
+		if c.want == codes.OK && seen.Slug != "shop" {
+			t.Errorf("%s with %q: tenant not in the call context", c.method, c.key)
+		}
+	}
+}
+
+// stubStream is a server stream carrying ctx
+type stubStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s stubStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamInterceptor(t *testing.T) {
+	auth := Authenticator(stubAuthenticator)
+	info := &grpc.StreamServerInfo{FullMethod: pb.DataService_ListRecords_FullMethodName}
+	var seen service.Tenant
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		seen, _ = tenantFrom(stream.Context())
+		return nil
+	}
+	if err := auth.StreamInterceptor(nil, stubStream{ctx: withKey("sa_reader")}, info, handler); err != nil || seen.Slug != "shop" {
+		t.Fatalf("authorized stream: %v, tenant %+v", err, seen)
+	}
+	if err := auth.StreamInterceptor(nil, stubStream{ctx: withKey("")}, info, handler); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("stream without key: got %v", err)
+	}
+}
+
+func TestEveryMethodHasARoute(t *testing.T) {
+	for _, method := range pb.DataService_ServiceDesc.Methods {
+		if _, ok := methodRoutes["/"+pb.DataService_ServiceDesc.ServiceName+"/"+method.MethodName]; !ok {
+			t.Errorf("%s has no route", method.MethodName)
+		}
+	}
+	for _, stream := range pb.DataService_ServiceDesc.Streams {
+		if _, ok := methodRoutes["/"+pb.DataService_ServiceDesc.ServiceName+"/"+stream.StreamName]; !ok {
+			t.Errorf("%s has no route", stream.StreamName)
+		}
+	}
+}
+
+func TestListenAndServeRequiresTLS(t *testing.T) {
+	err := ListenAndServe("127.0.0.1:0", nil, Options{Authenticate: stubAuthenticator})
+	if err == nil {
+		t.Fatal("plaintext server started without Insecure")
+	}
+	if err := ListenAndServe("127.0.0.1:0", nil, Options{Insecure: true}); err == nil {
+		t.Fatal("server started without an authenticator")
+	}
+}
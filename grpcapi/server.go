@@ -0,0 +1,307 @@
+// Package grpcapi exposes DataService over gRPC for chatbot backends written in other languages.
+// The stubs in pb are generated from proto/convertyapi.proto.
+package grpcapi
+
+//go:generate protoc -I ../proto --go_out=.. --go_opt=module=convertyApi --go-grpc_out=.. --go-grpc_opt=module=convertyApi ../proto/convertyapi.proto
+
+import (
+	"context"
+	"convertyApi/grpcapi/pb"
+	"convertyApi/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements pb.DataServiceServer on top of service.DataService
+type Server struct {
+	pb.UnimplementedDataServiceServer
+	dataService service.DataService
+}
+
+// NewServer creates a new gRPC Server; every call is scoped to the tenant the interceptors resolved
+func NewServer(dataService service.DataService) *Server {
+	return &Server{dataService: dataService}
+}
+
+// Options configure ListenAndServe
+type Options struct {
+	// CertFile and KeyFile serve TLS; without them the server only starts when Insecure is set
+	CertFile, KeyFile string
+	// Insecure allows plaintext, for a server reachable only through a TLS-terminating proxy
+	Insecure bool
+	// Authenticate resolves the tenant of every call and applies the access policy
+	Authenticate Authenticator
+}
+
+// ListenAndServe starts the gRPC server on addr
+func ListenAndServe(addr string, dataService service.DataService, opts Options) error {
+	if opts.Authenticate == nil {
+		return fmt.Errorf("no authenticator configured")
+	}
+	serverOpts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(opts.Authenticate.UnaryInterceptor),
+		grpc.StreamInterceptor(opts.Authenticate.StreamInterceptor),
+	}
+	switch {
+	case opts.CertFile != "" && opts.KeyFile != "":
+		creds, err := credentials.NewServerTLSFromFile(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %v", err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	case !opts.Insecure:
+		return fmt.Errorf("TLS certificate and key are required unless plaintext is explicitly allowed")
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	s := grpc.NewServer(serverOpts...)
+	pb.RegisterDataServiceServer(s, NewServer(dataService))
+	log.Println("gRPC server starting on ", addr)
+	return s.Serve(lis)
+}
+
+// data scopes the data service to the tenant of the call
+func (s *Server) data(ctx context.Context) (service.DataService, error) {
+	tenant, ok := tenantFrom(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated call")
+	}
+	return s.dataService.ForTenant(tenant).WithPriority(service.PriorityInteractive).WithContext(ctx), nil
+}
+
+// ListRecords streams every record
+func (s *Server) ListRecords(_ *pb.ListRecordsRequest, stream pb.DataService_ListRecordsServer) error {
+	data, err := s.data(stream.Context())
+	if err != nil {
+		return err
+	}
+	records, err := data.ListRecords()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, record := range records {
+		msg, err := toRecord(record)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRecord fetches a record by ID
+func (s *Server) GetRecord(ctx context.Context, req *pb.GetRecordRequest) (*pb.Record, error) {
+	data, err := s.data(ctx)
+	if err != nil {
+		return nil, err
+	}
+	record, err := data.QueryByID(uint(req.GetId()))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	msg, err := toRecord(record)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return msg, nil
+}
+
+// GetRecords fetches several records in a single query
+func (s *Server) GetRecords(ctx context.Context, req *pb.GetRecordsRequest) (*pb.GetRecordsResponse, error) {
+	data, err := s.data(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GetIds()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no IDs provided")
+	}
+	if len(req.GetIds()) > service.MaxBatchLookupIDs {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d IDs may be fetched at once", service.MaxBatchLookupIDs)
+	}
+	ids := make([]uint, 0, len(req.GetIds()))
+	for _, id := range req.GetIds() {
+		ids = append(ids, uint(id))
+	}
+	records, err := data.QueryByIDs(ids)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &pb.GetRecordsResponse{}
+	for _, record := range records {
+		msg, err := toRecord(record)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Records = append(resp.Records, msg)
+	}
+	return resp, nil
+}
+
+// InsertRecord inserts a new record
+func (s *Server) InsertRecord(ctx context.Context, req *pb.InsertRecordRequest) (*pb.Record, error) {
+	data, err := s.data(ctx)
+	if err != nil {
+		return nil, err
+	}
+	record, err := data.InsertRecord(uint(req.GetUserId()), req.GetType(), req.GetDetails().AsMap(), req.GetStatus())
+	if errors.Is(err, service.ErrSchemaViolation) || errors.Is(err, service.ErrValidation) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	msg, err := toRecord(record)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return msg, nil
+}
+
+// ListIssues streams records with type=issue
+func (s *Server) ListIssues(_ *pb.ListIssuesRequest, stream pb.DataService_ListIssuesServer) error {
+	data, err := s.data(stream.Context())
+	if err != nil {
+		return err
+	}
+	issues, err := data.ListIssues()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, issue := range issues {
+		var details map[string]interface{}
+		if err := json.Unmarshal(issue.Details, &details); err != nil {
+			log.Printf("gRPC ListIssues: skipping issue %d with invalid details: %v", issue.ID, err)
+			continue
+		}
+		field := func(key string) string {
+			if value, ok := details[key]; ok && value != nil {
+				return fmt.Sprintf("%v", value)
+			}
+			return ""
+		}
+		if err := stream.Send(&pb.Issue{
+			Id:           uint64(issue.ID),
+			UserId:       uint64(issue.UserID),
+			IssueType:    field("type"),
+			Name:         field("name"),
+			Product:      field("product"),
+			Description:  field("description"),
+			PhoneNumber:  field("phone_number"),
+			DetailStatus: field("status"),
+			Status:       issue.Status,
+			CreatedAt:    timestamppb.New(issue.CreatedAt),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListOrders streams orders fetched from Converty.shop
+func (s *Server) ListOrders(req *pb.CustomerOrderQuery, stream pb.DataService_ListOrdersServer) error {
+	data, err := s.data(stream.Context())
+	if err != nil {
+		return err
+	}
+	query := service.CustomerOrderQuery{
+		Page:            int(req.GetPage()),
+		Limit:           int(req.GetLimit()),
+		Status:          req.GetStatus(),
+		Archived:        req.Archived,
+		Abandoned:       req.Abandoned,
+		Deleted:         req.Deleted,
+		Search:          req.GetSearch(),
+		Product:         req.GetProduct(),
+		DeliveryCompany: req.GetDeliveryCompany(),
+	}
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.Limit < 1 {
+		query.Limit = 10
+	}
+	orders, err := data.ListOrders(query)
+	if err != nil {
+		return upstreamError(err)
+	}
+	for _, order := range orders {
+		if err := stream.Send(toOrder(order)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOrder fetches a single order from Converty.shop
+func (s *Server) GetOrder(ctx context.Context, req *pb.GetOrderRequest) (*pb.Order, error) {
+	data, err := s.data(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := data.GetOrder(req.GetId())
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	return toOrder(order), nil
+}
+
+// upstreamError maps a Converty failure to a gRPC status
+func upstreamError(err error) error {
+	if errors.Is(err, service.ErrUpstreamUnavailable) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+func toRecord(record service.Data) (*pb.Record, error) {
+	var details map[string]interface{}
+	if len(record.Details) > 0 {
+		if err := json.Unmarshal(record.Details, &details); err != nil {
+			return nil, fmt.Errorf("invalid details for record %d: %v", record.ID, err)
+		}
+	}
+	detailsStruct, err := structpb.NewStruct(details)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert details for record %d: %v", record.ID, err)
+	}
+	return &pb.Record{
+		Id:        uint64(record.ID),
+		UserId:    uint64(record.UserID),
+		Type:      record.Type,
+		Details:   detailsStruct,
+		Status:    record.Status,
+		CreatedAt: timestamppb.New(record.CreatedAt),
+	}, nil
+}
+
+func toOrder(order service.Order) *pb.Order {
+	return &pb.Order{
+		Id: order.ID,
+		Customer: &pb.Customer{
+			Name:    order.Customer.Name,
+			Address: order.Customer.Address,
+			Note:    order.Customer.Note,
+			Email:   order.Customer.Email,
+			Phone:   order.Customer.Phone,
+			City:    order.Customer.City,
+		},
+		Status:    order.Status,
+		Total:     order.Total,
+		Currency:  order.Currency,
+		CreatedAt: timestamppb.New(order.CreatedAt),
+	}
+}
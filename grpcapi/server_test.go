@@ -0,0 +1,128 @@
+package grpcapi
+
+import (
+	"context"
+	"convertyApi/grpcapi/pb"
+	"convertyApi/service"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stubData serves one record per requested ID
+type stubData struct {
+	service.DataService
+}
+
+func (d stubData) ForTenant(tenant service.Tenant) service.DataService                { return d }
+func (d stubData) WithPriority(priority service.UpstreamPriority) service.DataService { return d }
+func (d stubData) WithContext(ctx context.Context) service.DataService                { return d }
+
+func (stubData) QueryByIDs(ids []uint) ([]service.Data, error) {
+	records := make([]service.Data, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, service.Data{ID: id, Type: "issue"})
+	}
+	return records, nil
+}
+
+func TestGetRecordsBatchSize(t *testing.T) {
+	server := NewServer(stubData{})
+	ctx := context.WithValue(context.Background(), tenantContextKey{}, service.Tenant{ID: 7, Slug: "shop"})
+	ids := func(n int) []uint64 {
+		list := make([]uint64, n)
+		for i := range list {
+			list[i] = uint64(i + 1)
+		}
+		return list
+	}
+	for _, c := range []struct {
+		name  string
+		ids   []uint64
+		wantN int
+		want  codes.Code
+	}{
+		{"no IDs", nil, 0, codes.InvalidArgument},
+		{"one ID", ids(1), 1, codes.OK},
+		{"at the cap", ids(service.MaxBatchLookupIDs), service.MaxBatchLookupIDs, codes.OK},
+		{"past the cap", ids(service.MaxBatchLookupIDs + 1), 0, codes.InvalidArgument},
+	} {
+		resp, err := server.GetRecords(ctx, &pb.GetRecordsRequest{Ids: c.ids})
+		if status.Code(err) != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+			continue
+		}
+		if err == nil && len(resp.GetRecords()) != c.wantN {
+			t.Errorf("%s: %d records, want %d", c.name, len(resp.GetRecords()), c.wantN)
+		}
+	}
+}
+
+// scopedData records the tenant calls were scoped to and fails the way its err asks
+type scopedData struct {
+	stubData
+	tenant *service.Tenant
+	err    error
+}
+
+func (d scopedData) ForTenant(tenant service.Tenant) service.DataService {
+	*d.tenant = tenant
+	return d
+}
+
+func (d scopedData) WithPriority(priority service.UpstreamPriority) service.DataService { return d }
+func (d scopedData) WithContext(ctx context.Context) service.DataService                { return d }
+
+func (d scopedData) InsertRecord(userID uint, dataType string, details map[string]interface{}, status string) (service.Data, error) {
+	return service.Data{ID: 1, UserID: userID, Type: dataType}, d.err
+}
+
+func (d scopedData) GetOrder(id string) (service.Order, error) {
+	return service.Order{}, d.err
+}
+
+func TestCallsAreScopedToTheTenant(t *testing.T) {
+	tenantCtx := context.WithValue(context.Background(), tenantContextKey{}, service.Tenant{ID: 7, Slug: "shop"})
+	for _, c := range []struct {
+		name string
+		ctx  context.Context
+		err  error
+		call func(*Server, context.Context) error
+		want codes.Code
+	}{
+		{"record without tenant", context.Background(), nil, func(s *Server, ctx context.Context) error {
+			_, err := s.GetRecords(ctx, &pb.GetRecordsRequest{Ids: []uint64{1}})
+			return err
+		}, codes.Unauthenticated},
+		{"order without tenant", context.Background(), nil, func(s *Server, ctx context.Context) error {
+			_, err := s.GetOrder(ctx, &pb.GetOrderRequest{Id: "o1"})
+			return err
+		}, codes.Unauthenticated},
+		{"insert", tenantCtx, nil, func(s *Server, ctx context.Context) error {
+			_, err := s.InsertRecord(ctx, &pb.InsertRecordRequest{UserId: 3, Type: "issue"})
+			return err
+		}, codes.OK},
+		{"insert rejected", tenantCtx, service.ErrValidation, func(s *Server, ctx context.Context) error {
+			_, err := s.InsertRecord(ctx, &pb.InsertRecordRequest{UserId: 3, Type: "issue"})
+			return err
+		}, codes.InvalidArgument},
+		{"order upstream down", tenantCtx, service.ErrUpstreamUnavailable, func(s *Server, ctx context.Context) error {
+			_, err := s.GetOrder(ctx, &pb.GetOrderRequest{Id: "o1"})
+			return err
+		}, codes.Unavailable},
+	} {
+		var scoped service.Tenant
+		err := c.call(NewServer(scopedData{tenant: &scoped, err: c.err}), c.ctx)
+		if status.Code(err) != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+		wantTenant := uint(7)
+		if c.want == codes.Unauthenticated {
+			wantTenant = 0
+		}
+		if scoped.ID != wantTenant {
+			t.Errorf("%s: scoped to tenant %d, want %d", c.name, scoped.ID, wantTenant)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// fakeRecords is an in-memory DataService holding the records and Converty orders of the snapshot tests
+type fakeRecords struct {
+	service.DataService
+	records map[uint]service.Data
+	// deleted holds the soft-deleted records RestoreRecord brings back
+	deleted  map[uint]service.Data
+	orders   []service.Order
+	products map[string]service.Product
+	// productLookups counts the GetProduct calls
+	productLookups int
+	nextID         uint
+}
+
+func newFakeRecords() *fakeRecords {
+	return &fakeRecords{records: map[uint]service.Data{}, deleted: map[uint]service.Data{}, nextID: 1}
+}
+
+func (f *fakeRecords) ForTenant(tenant service.Tenant) service.DataService                { return f }
+func (f *fakeRecords) WithPriority(priority service.UpstreamPriority) service.DataService { return f }
+func (f *fakeRecords) WithContext(ctx context.Context) service.DataService                { return f }
+
+func (f *fakeRecords) InsertRecord(userID uint, dataType string, details map[string]interface{}, status string) (service.Data, error) {
+	body, _ := json.Marshal(details)
+	record := service.Data{
+		ID: f.nextID, UserID: userID, Type: dataType, Details: datatypes.JSON(body), Status: status,
+		CreatedAt: goldenTime, UpdatedAt: goldenTime, Version: 1, LineageID: f.nextID, Revision: 1,
+	}
+	f.records[record.ID] = record
+	f.nextID++
+	return record, nil
+}
+
+func (f *fakeRecords) QueryByID(id uint) (service.Data, error) {
+	record, ok := f.records[id]
+	if !ok {
+		return service.Data{}, fmt.Errorf("record with ID %d: %w", id, service.ErrNotFound)
+	}
+	return record, nil
+}
+
+func (f *fakeRecords) RecordsLastModified() (time.Time, error) {
+	var last time.Time
+	for _, record := range f.records {
+		if record.LastModified().After(last) {
+			last = record.LastModified()
+		}
+	}
+	return last, nil
+}
+
+func (f *fakeRecords) PageRecords(filter service.RecordFilter, offset, limit int) ([]service.Data, int64, error) {
+	records := make([]service.Data, 0, len(f.records))
+	for _, record := range f.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	total := int64(len(records))
+	records = records[min(offset, len(records)):min(offset+limit, len(records))]
+	return records, total, nil
+}
+
+func (f *fakeRecords) UpdateRecordStatus(id uint, newStatus, actor string) (service.Data, error) {
+	record, err := f.QueryByID(id)
+	if err != nil {
+		return service.Data{}, err
+	}
+	record.Status, record.UpdatedAt = newStatus, goldenTime.Add(time.Hour)
+	f.records[id] = record
+	return record, nil
+}
+
+func (f *fakeRecords) DeleteRecord(id uint) error {
+	record, err := f.QueryByID(id)
+	if err != nil {
+		return err
+	}
+	if service.IsImmutableType(record.Type) {
+		return fmt.Errorf("%w: %s records cannot be deleted", service.ErrImmutableRecord, record.Type)
+	}
+	f.deleted[id] = record
+	delete(f.records, id)
+	return nil
+}
+
+func (f *fakeRecords) ArchiveRecord(id uint) (service.Data, error) {
+	record, err := f.QueryByID(id)
+	if err != nil {
+		return service.Data{}, err
+	}
+	if service.IsImmutableType(record.Type) {
+		return service.Data{}, fmt.Errorf("%w: %s records cannot be archived", service.ErrImmutableRecord, record.Type)
+	}
+	if record.ArchivedAt == nil {
+		archivedAt := goldenTime.Add(time.Hour)
+		record.ArchivedAt = &archivedAt
+		f.records[id] = record
+	}
+	return record, nil
+}
+
+func (f *fakeRecords) RestoreRecord(id uint) (service.Data, error) {
+	record, ok := f.deleted[id]
+	if !ok {
+		var err error
+		if record, err = f.QueryByID(id); err != nil {
+			return service.Data{}, err
+		}
+	}
+	record.ArchivedAt = nil
+	f.records[id] = record
+	delete(f.deleted, id)
+	return record, nil
+}
+
+// BulkUpdateStatus follows the workflow and rolls the whole batch back when a record fails
+func (f *fakeRecords) BulkUpdateStatus(ids []uint, recordType, newStatus, actor string) ([]service.BulkStatusResult, error) {
+	results := make([]service.BulkStatusResult, len(ids))
+	failed := 0
+	for i, id := range ids {
+		results[i] = service.BulkStatusResult{ID: id, Result: service.BulkStatusUpdated}
+		record, err := f.QueryByID(id)
+		switch {
+		case err != nil:
+		case record.Type != recordType:
+			err = fmt.Errorf("%w: record %d is of type %s, not %s", service.ErrValidation, id, record.Type, recordType)
+		case !service.CanTransition(record.Status, newStatus):
+			err = fmt.Errorf("%w: %q -> %q", service.ErrInvalidTransition, record.Status, newStatus)
+		}
+		if err != nil {
+			results[i].Result, results[i].Error = service.BulkStatusFailed, err.Error()
+			failed++
+			continue
+		}
+		results[i].From = record.Status
+	}
+	if failed > 0 {
+		for i := range results {
+			if results[i].Result == service.BulkStatusUpdated {
+				results[i].Result = service.BulkStatusRolledBack
+			}
+		}
+		return results, fmt.Errorf("%w: %d of %d records cannot move to %s", service.ErrBulkRolledBack, failed, len(ids), newStatus)
+	}
+	for i, id := range ids {
+		record, _ := f.UpdateRecordStatus(id, newStatus, actor)
+		results[i].Record = &record
+	}
+	return results, nil
+}
+
+func (f *fakeRecords) ListOrders(query service.CustomerOrderQuery) ([]service.Order, error) {
+	return f.orders, nil
+}
+
+func (f *fakeRecords) GetProduct(id string) (service.Product, error) {
+	f.productLookups++
+	product, ok := f.products[id]
+	if !ok {
+		return service.Product{}, fmt.Errorf("failed to fetch product %s: %w", id, service.ErrNotFound)
+	}
+	return product, nil
+}
+
+// fakeMirror answers the customer lookups of the order mirror from the orders of a fakeRecords
+type fakeMirror struct {
+	service.OrderMirrorService
+	data *fakeRecords
+	// customerQueries counts the ListCustomers and CustomerOrders calls
+	customerQueries int
+}
+
+func (m *fakeMirror) ListCustomers(tenantID uint, query service.CustomerQuery) ([]service.CustomerSummary, error) {
+	m.customerQueries++
+	byPhone := map[string]*service.CustomerSummary{}
+	var customers []service.CustomerSummary
+	for _, order := range m.data.orders {
+		phone := service.NormalizePhone(order.Customer.Phone)
+		if customer, ok := byPhone[phone]; ok {
+			customer.Orders++
+			continue
+		}
+		byPhone[phone] = &service.CustomerSummary{Phone: phone, Name: order.Customer.Name, City: order.Customer.City, Orders: 1, LastOrderAt: order.CreatedAt}
+	}
+	for _, phone := range query.Phones {
+		if customer, ok := byPhone[service.NormalizePhone(phone)]; ok {
+			customers = append(customers, *customer)
+		}
+	}
+	return customers, nil
+}
+
+func (m *fakeMirror) CustomerOrders(tenantID uint, phones []string, limit int) (map[string][]service.Order, error) {
+	m.customerQueries++
+	byPhone := map[string][]service.Order{}
+	for _, order := range m.data.orders {
+		phone := service.NormalizePhone(order.Customer.Phone)
+		if len(byPhone[phone]) < limit {
+			byPhone[phone] = append(byPhone[phone], order)
+		}
+	}
+	return byPhone, nil
+}
+
+// newGoldenRouter serves the API around data with a single stored Converty token
+func newGoldenRouter(t *testing.T, data service.DataService) http.Handler {
+	t.Helper()
+	return newGoldenRouterWith(t, serverServices{Data: data})
+}
+
+// newGoldenRouterWith serves the API around services, filling in the tenants and sessions
+func newGoldenRouterWith(t *testing.T, services serverServices) http.Handler {
+	t.Helper()
+	useFakeTokenDB(t, TokenInfo{
+		UserID: service.DefaultTenant.TokenUserID, AccessToken: "access-1", RefreshToken: "refresh-1", TokenType: "Bearer",
+		ExpiresIn: 3600, IssuedAt: goldenTime, ExpiresAt: goldenTime.AddDate(100, 0, 0),
+		RefreshIssuedAt: goldenTime, RefreshExpiresAt: goldenTime.AddDate(100, 0, 0), Scopes: "read-orders write-orders",
+	})
+	services.Tenants, services.Sessions, services.LoginThrottle = stubTenantService{}, newMemorySessions(), newMemoryLoginThrottle()
+	return newRouter(services)
+}
+
+// serveGolden sends one request to the router
+func serveGolden(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRecordsGolden(t *testing.T) {
+	router := newGoldenRouter(t, newFakeRecords())
+
+	assertGolden(t, "records_create", serveGolden(router, http.MethodPost, "/api/v1/records",
+		`{"user_id": 42, "type": "issue", "details": {"phone": "+21620000000", "message": "Blender arrived broken"}, "status": "pending"}`))
+	serveGolden(router, http.MethodPost, "/api/v1/records", `{"user_id": 42, "type": "feedback", "details": {"rating": 5}, "status": "completed"}`)
+	assertGolden(t, "records_create_invalid", serveGolden(router, http.MethodPost, "/api/v1/records", `{"details": {}}`))
+	assertGolden(t, "records_get", serveGolden(router, http.MethodGet, "/api/v1/records/1", ""))
+	assertGolden(t, "records_get_missing", serveGolden(router, http.MethodGet, "/api/v1/records/99", ""))
+	assertGolden(t, "records_list", serveGolden(router, http.MethodGet, "/api/v1/records?page=1&limit=10", ""))
+	assertGolden(t, "records_update_status", serveGolden(router, http.MethodPut, "/api/v1/records/1/status", `{"status": "in_progress", "actor": "agent-7"}`))
+	assertGolden(t, "records_delete", serveGolden(router, http.MethodDelete, "/api/v1/records/2", ""))
+}
+
+func TestOrdersGolden(t *testing.T) {
+	data := newFakeRecords()
+	data.orders = []service.Order{{
+		ID:       "o-1001",
+		Customer: service.Customer{Name: "Amira Ben Salah", Phone: "+21620000000", City: "Sfax", Address: "12 rue de Marseille"},
+		Status:   "pending", Total: 89.5, Currency: "TND", CreatedAt: goldenTime, UpdatedAt: goldenTime,
+		Items: []service.OrderLine{{ProductID: "p-7", Name: "Blender", Quantity: 1, Price: 89.5}},
+	}}
+	router := newGoldenRouter(t, data)
+	assertGolden(t, "orders_list", serveGolden(router, http.MethodGet, "/api/v1/orders?page=1&limit=20", ""))
+}
+
+func TestTokenStatusGolden(t *testing.T) {
+	router := newGoldenRouter(t, newFakeRecords())
+	// The countdowns depend on the time the test runs
+	assertGolden(t, "token_status", serveGolden(router, http.MethodGet, "/api/v1/token/status", ""),
+		"access_expires_in", "refresh_expires_in")
+
+	useFakeTokenDB(t)
+	assertGolden(t, "token_status_missing", serveGolden(router, http.MethodGet, "/api/v1/token/status", ""))
+}
+
+func TestIssuesBulkStatusGolden(t *testing.T) {
+	router := newGoldenRouter(t, newFakeRecords())
+	for _, body := range []string{
+		`{"user_id": 42, "type": "issue", "details": {"description": "Parcel late"}, "status": "in_progress"}`,
+		`{"user_id": 43, "type": "issue", "details": {"description": "Parcel late again"}, "status": "in_progress"}`,
+		`{"user_id": 44, "type": "issue", "details": {"description": "Wrong colour"}, "status": "pending"}`,
+		`{"user_id": 44, "type": "feedback", "details": {"rating": 2}, "status": "in_progress"}`,
+	} {
+		serveGolden(router, http.MethodPost, "/api/v1/records", body)
+	}
+
+	assertGolden(t, "issues_bulk_status_rolled_back", serveGolden(router, http.MethodPost, "/api/v1/issues/bulk-status",
+		`{"ids": [1, 2, 3, 4, 99], "status": "completed", "actor": "agent-7"}`))
+	assertGolden(t, "issues_bulk_status_invalid", serveGolden(router, http.MethodPost, "/api/v1/issues/bulk-status",
+		`{"ids": [], "status": "resolved"}`))
+	assertGolden(t, "issues_bulk_status", serveGolden(router, http.MethodPost, "/api/v1/issues/bulk-status",
+		`{"ids": [1, 2], "status": "completed", "actor": "agent-7"}`))
+}
+
+func TestGraphQLGolden(t *testing.T) {
+	data := newFakeRecords()
+	data.products = map[string]service.Product{"p-7": {ID: "p-7", Name: "Blender", Price: 89.5, Currency: "TND", Stock: 3}}
+	buyer := service.Customer{Name: "Amira Ben Salah", Phone: "+216 20 000 000", City: "Sfax"}
+	data.orders = []service.Order{
+		{ID: "o-1002", Customer: buyer, Status: "pending", Total: 179, Currency: "TND", CreatedAt: goldenTime, UpdatedAt: goldenTime,
+			Items: []service.OrderLine{{ProductID: "p-7", Name: "Blender", Quantity: 2, Price: 89.5}}},
+		{ID: "o-1001", Customer: buyer, Status: "delivered", Total: 101.5, Currency: "TND", CreatedAt: goldenTime, UpdatedAt: goldenTime,
+			Items: []service.OrderLine{{ProductID: "p-7", Name: "Blender", Quantity: 1, Price: 89.5}, {ProductID: "p-9", Name: "Filter", Quantity: 1, Price: 12}}},
+	}
+	mirror := &fakeMirror{data: data}
+	router := newGoldenRouterWith(t, serverServices{Data: data, OrderMirror: mirror})
+	serveGolden(router, http.MethodPost, "/api/v1/records", `{"user_id": 42, "type": "issue", "details": {"message": "Blender arrived broken"}, "status": "pending"}`)
+
+	assertGolden(t, "graphql_nested", serveGolden(router, http.MethodPost, "/graphql", `{
+		"query": "query Dashboard($limit: Int) { issues(limit: $limit) { id status details } orders { id total customer { name orderCount orders(limit: 5) { id items { quantity product { name inStock } } } } } }",
+		"variables": {"limit": 5}
+	}`))
+	// Both orders name the same customer and product: one mirror query per level, one Converty lookup
+	// per distinct product
+	if mirror.customerQueries != 2 || data.productLookups != 2 {
+		t.Errorf("%d customer queries and %d product lookups, want 2 and 2", mirror.customerQueries, data.productLookups)
+	}
+	assertGolden(t, "graphql_invalid", serveGolden(router, http.MethodPost, "/graphql", `{"query": "{ orders { id secret } }"}`))
+}
+
+func TestRecordArchiveDeleteRestore(t *testing.T) {
+	service.SetImmutableTypes([]string{"consent"})
+	defer service.SetImmutableTypes(nil)
+	data := newFakeRecords()
+	router := newGoldenRouter(t, data)
+	serveGolden(router, http.MethodPost, "/api/v1/records", `{"user_id": 42, "type": "issue", "details": {"message": "Parcel late"}, "status": "pending"}`)
+	serveGolden(router, http.MethodPost, "/api/v1/records", `{"user_id": 42, "type": "consent", "details": {"channel": "whatsapp"}, "status": "completed"}`)
+
+	for _, c := range []struct {
+		method, path string
+		want         int
+		archived     bool
+	}{
+		{http.MethodPost, "/api/v1/records/1/archive", http.StatusOK, true},
+		{http.MethodPost, "/api/v1/records/1/archive", http.StatusOK, true},
+		{http.MethodPost, "/api/v1/records/1/restore", http.StatusOK, false},
+		{http.MethodDelete, "/api/v1/records/1", http.StatusNoContent, false},
+		{http.MethodGet, "/api/v1/records/1", http.StatusNotFound, false},
+		{http.MethodPost, "/api/v1/records/1/restore", http.StatusOK, false},
+		{http.MethodGet, "/api/v1/records/1", http.StatusOK, false},
+		{http.MethodPost, "/api/v1/records/2/archive", http.StatusConflict, false},
+		{http.MethodDelete, "/api/v1/records/2", http.StatusConflict, false},
+		{http.MethodPost, "/api/v1/records/99/archive", http.StatusNotFound, false},
+		{http.MethodDelete, "/api/v1/records/99", http.StatusNotFound, false},
+		{http.MethodPost, "/api/v1/records/abc/restore", http.StatusBadRequest, false},
+	} {
+		rec := serveGolden(router, c.method, c.path, "")
+		if rec.Code != c.want {
+			t.Errorf("%s %s: got %d, want %d: %s", c.method, c.path, rec.Code, c.want, rec.Body)
+			continue
+		}
+		if c.want == http.StatusOK && c.method == http.MethodPost {
+			var record service.Data
+			if err := json.Unmarshal(rec.Body.Bytes(), &record); err != nil {
+				t.Fatal(err)
+			}
+			if (record.ArchivedAt != nil) != c.archived {
+				t.Errorf("%s %s: archived_at %v, want archived %v", c.method, c.path, record.ArchivedAt, c.archived)
+			}
+		}
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
-	"encoding/json"
+	"convertyApi/service"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 // writeError writes an error response with logging
@@ -16,46 +22,116 @@ func writeError(w http.ResponseWriter, message string, statusCode int) {
 	http.Error(w, message, statusCode)
 }
 
-// GetAccessToken refreshes the access token by calling the Converty.shop token endpoint
-func GetAccessToken(refreshToken string) (string, error) {
-	data := url.Values{}
-	data.Set("grant_type", "refresh_token")
-	data.Set("client_id", clientID)
-	data.Set("client_secret", clientSecret)
-	data.Set("refresh_token", refreshToken)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.PostForm(tokenURL, data)
-	if err != nil {
-		return "", fmt.Errorf("failed to refresh token: %v", err)
+// splitList splits a comma-separated query parameter, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
-	defer resp.Body.Close()
+	return items
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("refresh token request failed with status %d: %s", resp.StatusCode, string(body))
+// parseIDList parses numeric record IDs
+func parseIDList(items []string) ([]uint, error) {
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		id, err := strconv.ParseUint(item, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q", item)
+		}
+		ids = append(ids, uint(id))
 	}
+	return ids, nil
+}
 
-	var tokenResponse struct {
-		AccessToken string `json:"access_token"`
+// parseOrderQuery builds a CustomerOrderQuery from the request's query string
+func parseOrderQuery(r *http.Request) (service.CustomerOrderQuery, error) {
+	params := r.URL.Query()
+	query := service.CustomerOrderQuery{
+		Page:            1,
+		Limit:           10,
+		Status:          params.Get("status"),
+		Search:          params.Get("search"),
+		Product:         params.Get("product"),
+		DeliveryCompany: params.Get("delivery_company"),
 	}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read refresh response: %v", err)
+	if value := params.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return query, fmt.Errorf("invalid page %q", value)
+		}
+		query.Page = page
 	}
-	if err := json.Unmarshal(body, &tokenResponse); err != nil {
-		return "", fmt.Errorf("failed to parse refresh response: %v", err)
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return query, fmt.Errorf("invalid limit %q", value)
+		}
+		query.Limit = limit
 	}
+	for name, target := range map[string]**bool{
+		"archived":  &query.Archived,
+		"abandoned": &query.Abandoned,
+		"deleted":   &query.Deleted,
+	} {
+		if value := params.Get(name); value != "" {
+			flag, err := strconv.ParseBool(value)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = &flag
+		}
+	}
+	return query, nil
+}
 
-	if tokenResponse.AccessToken == "" {
-		return "", fmt.Errorf("no access token in refresh response")
+// parseRecordFilterValues reads type, status, category, tag (repeatable), include_archived and
+// the from/to dates of a record filter
+func parseRecordFilterValues(params url.Values) (service.RecordFilter, error) {
+	filter := service.RecordFilter{
+		Type:            params.Get("type"),
+		Status:          params.Get("status"),
+		IncludeArchived: params.Get("include_archived") == "true",
+		Category:        params.Get("category"),
 	}
-	return tokenResponse.AccessToken, nil
+	if len(params["tag"]) > 0 {
+		tags, err := service.NormalizeTags(params["tag"])
+		if err != nil {
+			return filter, err
+		}
+		filter.Tags = tags
+	}
+	for name, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			if parsed, err = time.Parse(time.RFC3339, value); err != nil {
+				return filter, fmt.Errorf("invalid %s date %q, expected YYYY-MM-DD or RFC 3339", name, value)
+			}
+		}
+		*target = &parsed
+	}
+	return filter, nil
+}
+
+// GetAccessToken refreshes the access token by calling the Converty.shop token endpoint
+func GetAccessToken(refreshToken string) (string, error) {
+	tokenResp, _, err := requestRefreshGrant(refreshToken)
+	if err != nil {
+		return "", err
+	}
+	return tokenResp.AccessToken, nil
 }
 
 // callConvertyAPIAndWrite makes an API call to Converty.shop and writes the response
-func callConvertyAPIAndWrite(w http.ResponseWriter, method, url, accessToken string) bool {
-	req, err := http.NewRequest(method, url, nil)
+func callConvertyAPIAndWrite(w http.ResponseWriter, r *http.Request, method, url, accessToken string) bool {
+	ctx := service.WithUpstreamPriority(r.Context(), service.PriorityInteractive)
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		writeError(w, fmt.Sprintf("Failed to create API request: %v", err), http.StatusInternalServerError)
 		return false
@@ -64,9 +140,21 @@ func callConvertyAPIAndWrite(w http.ResponseWriter, method, url, accessToken str
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 	req.Header.Set("Accept", "application/json")
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	client := service.NewUpstreamClient(10 * time.Second)
+	resp, err := service.DoUpstream(client, req)
 	if err != nil {
+		// Fall back to the last good response while the upstream is down
+		if cached, ok := service.CachedResponse(tenantFrom(r).ID, url); ok {
+			log.Printf("Converty unavailable (%v), serving cached response for %s", err, url)
+			w.Header().Set("X-Cache", "stale")
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+			writeRawJSON(w, r, http.StatusOK, cached)
+			return true
+		}
+		if errors.Is(err, service.ErrUpstreamUnavailable) || errors.Is(err, service.ErrUpstreamBusy) {
+			writeError(w, err.Error(), http.StatusServiceUnavailable)
+			return false
+		}
 		writeError(w, fmt.Sprintf("Failed to make API request to Converty.shop: %v", err), http.StatusInternalServerError)
 		return false
 	}
@@ -78,16 +166,174 @@ func callConvertyAPIAndWrite(w http.ResponseWriter, method, url, accessToken str
 		return false
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		writeError(w, fmt.Sprintf("API request failed with status %d: %s", resp.StatusCode, string(body)), http.StatusBadGateway)
+	if scopes, ok := service.InsufficientScope(resp, body); ok {
+		if err := service.RecordMissingScopes(db, tokenUserFor(r), scopes); err != nil {
+			log.Printf("Failed to record missing scopes %v: %v", scopes, err)
+		}
+		writeError(w, service.ScopeError(scopes).Error(), http.StatusForbidden)
 		return false
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write(body); err != nil {
-		log.Printf("Failed to write response: %v", err)
+	if resp.StatusCode != http.StatusOK {
+		writeUpstreamError(w, r, service.UpstreamStatusError(resp.StatusCode, body))
 		return false
 	}
+
+	service.RememberResponse(tenantFrom(r).ID, url, body)
+	writeRawJSON(w, r, http.StatusOK, body)
 	return true
 }
+
+// upstreamStatus maps the error of a Converty call to a status code, 502 when the cause is unknown
+func upstreamStatus(err error) int {
+	return serviceStatus(err, http.StatusBadGateway)
+}
+
+// writeUpstreamError answers a failed Converty call. Errors Converty described itself are sent as JSON
+// with its status, code and message, so clients can act on the code; others are sent as text.
+func writeUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	var upstream *service.ConvertyError
+	if !errors.As(err, &upstream) {
+		writeError(w, err.Error(), upstreamStatus(err))
+		return
+	}
+	status := upstreamStatus(err)
+	log.Printf("Error: %s (Status: %d)", err.Error(), status)
+	writeJSON(w, r, status, map[string]interface{}{
+		"error":    "converty_error",
+		"message":  err.Error(),
+		"converty": upstream,
+	})
+}
+
+// serviceStatus maps a service error to a status code: 404 for a missing resource, 422 for rejected input,
+// 401 for a missing or expired Converty token, 503 for an unavailable or saturated upstream and 403 for a missing scope.
+// Other errors get fallback.
+func serviceStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, service.ErrTokenExpired):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrUpstreamUnavailable) || errors.Is(err, service.ErrUpstreamBusy):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, service.ErrInsufficientScope):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrStoreUnsupported):
+		return http.StatusNotImplemented
+	}
+	return fallback
+}
+
+// customerLanguage picks the language of customer-facing text from ?lang= then Accept-Language
+func customerLanguage(w http.ResponseWriter, r *http.Request) *service.Localizer {
+	localizer := service.NewLocalizer(r.URL.Query().Get("lang"), r.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Language", localizer.Language)
+	w.Header().Add("Vary", "Accept-Language")
+	return localizer
+}
+
+// recordChangeStatus maps an archive, restore or delete error to 409 for append-only records
+func recordChangeStatus(err error) int {
+	if errors.Is(err, service.ErrImmutableRecord) {
+		return http.StatusConflict
+	}
+	return serviceStatus(err, http.StatusInternalServerError)
+}
+
+// streamingPaths are routes that stream their response and must not be buffered by routeTimeout
+var streamingPaths = []string{"/api/v1/orders/export", "/api/v1/attachments"}
+
+// routeTimeout bounds how long a route may run, answering 503 once the deadline passes
+func routeTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		timed := http.TimeoutHandler(next, timeout, "Request timed out")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Websocket connections are long-lived and need the hijackable writer TimeoutHandler hides
+			if websocket.IsWebSocketUpgrade(r) || isStreamingPath(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			timed.ServeHTTP(w, r)
+		})
+	}
+}
+
+// publicPaths are served without an API key even when REQUIRE_TENANT is set
+var publicPaths = append([]string{"/status", convertyWebhookPath, "/api/v1/auth/login", "/api/v1/auth/refresh"}, paymentWebhookPaths...)
+
+// isPublicPath reports whether path is reachable without credentials
+func isPublicPath(path string) bool {
+	for _, public := range publicPaths {
+		if path == public {
+			return true
+		}
+	}
+	return false
+}
+
+// isStreamingPath reports whether path belongs to a streaming route
+func isStreamingPath(path string) bool {
+	for _, prefix := range streamingPaths {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleJob enqueues a payload-less job of jobType every interval; a zero interval disables it.
+// Ticks fall in slots of interval since the epoch and each slot queues one job, so several
+// instances ticking in the same slot do not multiply the job.
+func scheduleJob(jobService service.JobService, jobType string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if _, _, err := jobService.EnqueueScheduled(service.DefaultTenant.ID, jobType, nil, now.Truncate(interval)); err != nil {
+				log.Printf("Failed to schedule %s job: %v", jobType, err)
+			}
+		}
+	}()
+	log.Printf("Job %s scheduled every %v", jobType, interval)
+}
+
+// envOr returns the environment variable name or def when it is unset
+func envOr(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// durationEnv reads a Go duration from the environment, falling back to def
+func durationEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using default %v", name, value, def)
+		return def
+	}
+	return d
+}
+
+// intEnv reads a positive integer from the environment, falling back to def
+func intEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid %s %q, using default %d", name, value, def)
+		return def
+	}
+	return n
+}
@@ -1,75 +1,132 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/joho/godotenv"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
+	"convertyApi/service"
 )
 
 func TestGetAccessToken(t *testing.T) {
-	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		t.Fatalf("Error loading .env file: %v", err)
-	}
+	fake := newFakeConverty(t)
+	clientID, clientSecret = "client", "secret"
 
-	// Validate environment variables
-	clientID = os.Getenv("CLIENT_ID")
-	clientSecret = os.Getenv("CLIENT_SECRET")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
+	newToken, err := GetAccessToken("refresh-1")
+	if err != nil {
+		t.Fatalf("GetAccessToken failed: %v", err)
+	}
+	if newToken != "access-refresh_token" {
+		t.Fatalf("GetAccessToken returned %q", newToken)
+	}
+	grant := fake.grants[0]
+	if grant.Get("client_id") != "client" || grant.Get("client_secret") != "secret" || grant.Get("refresh_token") != "refresh-1" {
+		t.Fatalf("unexpected refresh grant: %v", grant)
+	}
 
-	if clientID == "" || clientSecret == "" {
-		t.Fatal("CLIENT_ID or CLIENT_SECRET not set in .env file")
+	// A revoked refresh token is reported as rejected so the user is asked to log in again
+	_, err = GetAccessToken("revoked")
+	var rejected *refreshRejectedError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("revoked refresh token: got %v, want refreshRejectedError", err)
 	}
-	if dbHost == "" || dbPort == "" || dbUser == "" || dbPassword == "" || dbName == "" {
-		t.Fatal("Database configuration not set in .env file")
+	if got := fake.grantTypes(); !reflect.DeepEqual(got, []string{"refresh_token", "refresh_token"}) {
+		t.Fatalf("token requests: %v", got)
 	}
+}
 
-	// Initialize database
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		t.Fatalf("Failed to connect to database: %v", err)
+func TestServiceStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("record with ID 3: %w", service.ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("%w: bad phone", service.ErrValidation), http.StatusUnprocessableEntity},
+		{fmt.Errorf("no token found: %w", service.ErrTokenExpired), http.StatusUnauthorized},
+		{service.ErrUpstreamBusy, http.StatusServiceUnavailable},
+		{service.ScopeError([]string{"orders:write"}), http.StatusForbidden},
+		{errors.New("disk full"), http.StatusInternalServerError},
 	}
-	defer func() {
-		sqlDB, err := db.DB()
-		if err == nil {
-			sqlDB.Close()
+	for _, tt := range tests {
+		if got := serviceStatus(tt.err, http.StatusInternalServerError); got != tt.want {
+			t.Errorf("serviceStatus(%v) = %d, want %d", tt.err, got, tt.want)
 		}
-	}()
-
-	// Retrieve refresh token
-	var tokenInfo TokenInfo
-	if err := db.Where("user_id = ?", "user1").First(&tokenInfo).Error; err != nil {
-		t.Fatalf("No token found for user_id=user1, please authenticate via /login: %v", err)
 	}
+}
 
-	if tokenInfo.RefreshToken == "" {
-		t.Fatal("No refresh token available for user_id=user1")
+func TestWriteUpstreamError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	err := fmt.Errorf("failed to create order: %w", service.UpstreamStatusError(http.StatusBadRequest,
+		[]byte(`{"success": false, "message": "Phone is invalid", "code": "INVALID_PHONE"}`)))
+	writeUpstreamError(rec, httptest.NewRequest("POST", "/api/v1/orders", nil), err)
+	var body struct {
+		Error    string                `json:"error"`
+		Converty service.ConvertyError `json:"converty"`
 	}
-
-	if time.Now().After(tokenInfo.RefreshExpiresAt) {
-		t.Fatal("Refresh token has expired, please re-authenticate via /login")
+	if decodeErr := json.Unmarshal(rec.Body.Bytes(), &body); decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+	if rec.Code != http.StatusUnprocessableEntity || body.Error != "converty_error" || body.Converty.Code != "INVALID_PHONE" ||
+		body.Converty.Status != http.StatusBadRequest {
+		t.Errorf("unexpected response %d %s", rec.Code, rec.Body)
 	}
 
-	// Test GetAccessToken
-	newToken, err := GetAccessToken(tokenInfo.RefreshToken)
-	if err != nil {
-		t.Fatalf("GetAccessToken failed: %v", err)
+	rec = httptest.NewRecorder()
+	writeUpstreamError(rec, httptest.NewRequest("GET", "/api/v1/orders", nil), service.ErrUpstreamUnavailable)
+	if rec.Code != http.StatusServiceUnavailable || strings.Contains(rec.Header().Get("Content-Type"), "json") {
+		t.Errorf("plain error: %d %s", rec.Code, rec.Header().Get("Content-Type"))
 	}
+}
 
-	if newToken == "" {
-		t.Fatal("GetAccessToken returned an empty access token")
+func TestParseIDList(t *testing.T) {
+	for _, c := range []struct {
+		list    string
+		want    []uint
+		wantErr string
+	}{
+		{"4, 9,,12", []uint{4, 9, 12}, ""},
+		{"7", []uint{7}, ""},
+		{"4,x", nil, `invalid ID "x"`},
+		{"-3", nil, `invalid ID "-3"`},
+	} {
+		ids, err := parseIDList(splitList(c.list))
+		if c.wantErr != "" {
+			if err == nil || err.Error() != c.wantErr {
+				t.Errorf("%q: got %v, want error %q", c.list, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(ids, c.want) {
+			t.Errorf("%q: got %v, %v, want %v", c.list, ids, err, c.want)
+		}
 	}
+}
 
-	t.Logf("Successfully refreshed access token: %s", newToken)
+func TestRouteTimeout(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := routeTimeout(10 * time.Millisecond)(slow)
+	for _, c := range []struct {
+		path string
+		want int
+	}{
+		{"/api/v1/orders", http.StatusServiceUnavailable},
+		{"/api/v1/orders/export", http.StatusOK},
+		{"/api/v1/attachments/3/download", http.StatusOK},
+		{"/api/v1/attachmentsx", http.StatusServiceUnavailable},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if rec.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.path, rec.Code, c.want)
+		}
+	}
 }
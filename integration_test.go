@@ -0,0 +1,2087 @@
+//go:build integration
+
+// Integration tests run the full HTTP router against a real Postgres and a fake Converty:
+//
+//	go test -tags integration -run Integration ./...
+//
+// Postgres is started with testcontainers unless INTEGRATION_DB_HOST (and the other INTEGRATION_DB_*
+// variables) point at an existing disposable database.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"convertyApi/api"
+	"convertyApi/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+const integrationPostgresImage = "postgres:16-alpine"
+
+// integrationAdminKey authenticates adminClient requests
+const integrationAdminKey = "integration-admin-key"
+
+// startPostgres points the DB_* variables at a fresh database and returns once it answers
+func startPostgres(t *testing.T) {
+	t.Helper()
+	if host := os.Getenv("INTEGRATION_DB_HOST"); host != "" {
+		t.Setenv("DB_HOST", host)
+		t.Setenv("DB_PORT", envOr("INTEGRATION_DB_PORT", "5432"))
+		t.Setenv("DB_USER", envOr("INTEGRATION_DB_USER", "postgres"))
+		t.Setenv("DB_PASSWORD", envOr("INTEGRATION_DB_PASSWORD", "postgres"))
+		t.Setenv("DB_NAME", envOr("INTEGRATION_DB_NAME", "postgres"))
+		return
+	}
+	skipWithoutDocker(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	container, err := postgres.Run(ctx, integrationPostgresImage,
+		postgres.WithDatabase("converty"), postgres.WithUsername("converty"), postgres.WithPassword("converty"),
+		postgres.BasicWaitStrategies())
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("failed to start postgres container: %v", err)
+	}
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("failed to read postgres host: %v", err)
+	}
+	port, err := container.MappedPort(ctx, "5432/tcp")
+	if err != nil {
+		t.Fatalf("failed to read postgres port: %v", err)
+	}
+	t.Setenv("DB_HOST", host)
+	t.Setenv("DB_PORT", port.Port())
+	t.Setenv("DB_USER", "converty")
+	t.Setenv("DB_PASSWORD", "converty")
+	t.Setenv("DB_NAME", "converty")
+}
+
+// skipWithoutDocker skips the test when testcontainers finds no healthy Docker, which it reports by
+// panicking when no Docker host can be found at all
+func skipWithoutDocker(t *testing.T) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skipf("docker not available and INTEGRATION_DB_HOST not set: %v", r)
+		}
+	}()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+}
+
+// startIntegrationServer migrates a fresh database and serves the full router, like main does
+func startIntegrationServer(t *testing.T) (*httptest.Server, *fakeConverty) {
+	t.Helper()
+	fake := newFakeConverty(t)
+	startPostgres(t)
+	t.Setenv("CLIENT_ID", "client")
+	t.Setenv("CLIENT_SECRET", "secret")
+	t.Setenv("ATTACHMENT_DIR", t.TempDir())
+	if err := loadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	clientID, clientSecret = os.Getenv("CLIENT_ID"), os.Getenv("CLIENT_SECRET")
+
+	var err error
+	if db, err = openDB(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := waitForDB(ctx, db, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	for _, load := range []func() error{loadSessionConfig, loadOAuthScopes, loadPolicy, loadCurrencyConverter, loadTaxConfig} {
+		if err := load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	service.RefreshToken = refreshServiceToken
+	store, err := loadAttachmentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachmentService := service.NewGormAttachmentService(db, store, attachmentMaxSize)
+	ruleService := service.NewGormRuleService(db)
+	schemaService := service.NewGormRecordSchemaService(db)
+	dataService := service.NewGormDataService(db, service.DataServiceOptions{Classifier: ruleService, Validator: schemaService})
+	jobService := service.NewGormJobService(db)
+	tenantService := service.NewGormTenantService(db)
+	sessionService := service.NewGormSessionService(db)
+	adminAPIKey = integrationAdminKey
+	if err := loadAdminBackends(sessionService); err != nil {
+		t.Fatal(err)
+	}
+	walletService := service.NewGormWalletService(db, currencyConverter.StoreCurrency)
+	loyaltyRules, err := loadLoyaltyRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alertService := service.NewGormProductAlertService(db, notifier, defaultAlertThresholds)
+	server := httptest.NewServer(newRouter(serverServices{
+		Data:            dataService,
+		Jobs:            jobService,
+		LegalHold:       service.NewGormLegalHoldService(db),
+		Payments:        service.NewGormPaymentService(db, dataService, notifier),
+		Carts:           service.NewGormAbandonedCartService(db, notifier),
+		Wallets:         walletService,
+		Loyalty:         service.NewGormLoyaltyService(db, walletService, loyaltyRules),
+		Tenants:         tenantService,
+		Categories:      service.NewGormCategoryService(db),
+		Waitlist:        service.NewGormWaitlistService(db, notifier, alertService),
+		ETA:             service.NewGormETAService(db),
+		Dashboard:       service.NewDashboard(5 * time.Minute),
+		Tracking:        service.NewTrackingService(),
+		Sessions:        sessionService,
+		TOTP:            service.NewGormTOTPService(db),
+		Attachments:     attachmentService,
+		ServiceAccounts: service.NewGormServiceAccountService(db),
+		Rules:           ruleService,
+		Merges:          service.NewGormCustomerMergeService(db),
+		Status:          service.NewGormStatusService(db),
+		ReportSchedules: service.NewGormReportScheduleService(db),
+		Orders:          service.NewGormOrderService(db, service.OrderDedupSettings{Mode: service.DedupReject, WindowMinutes: 60}, notifier),
+		Schemas:         schemaService,
+		Audit:           service.NewGormAuditService(db),
+		OrderReports:    service.NewGormOrderReportService(db),
+		OrderMirror:     service.NewGormOrderMirrorService(db),
+		Outbox:          service.NewGormOutboxService(db, nil, 3),
+		Conversations:   service.NewGormConversationService(db),
+		Alerts:          alertService,
+		Retention:       service.NewGormRetentionService(db, dataService, attachmentService),
+		Webhooks:        service.NewGormWebhookService(db, 3, true),
+		Tags:            service.NewGormTagService(db),
+		Addresses:       service.NewGormAddressService(db, nil),
+		Reservations:    service.NewGormReservationService(db, time.Minute),
+		ConvertyEvents:  service.NewGormConvertyEventService(db),
+		Users:           service.NewGormUserService(db),
+		LoginThrottle:   service.NewGormLoginThrottle(db, time.Minute, time.Minute),
+		DailyDigests:    service.NewGormDailyDigestService(db, time.Local),
+	}))
+	t.Cleanup(server.Close)
+	return server, fake
+}
+
+// integrationClient keeps cookies and does not follow redirects
+func integrationClient(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// adminTransport authenticates every request with the admin API key
+type adminTransport struct{}
+
+func (adminTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Admin-Key", integrationAdminKey)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// adminClient is an integrationClient authenticated as an operator
+func adminClient(t *testing.T) *http.Client {
+	client := integrationClient(t)
+	client.Transport = adminTransport{}
+	return client
+}
+
+// authorize completes the Converty authorization through /login and the callback
+func authorize(t *testing.T, client *http.Client, baseURL string) {
+	t.Helper()
+	resp, err := client.Get(baseURL + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := location.Query().Get("state")
+	call(t, client, "GET", baseURL+"/api/v1/callback?code=abc&state="+url.QueryEscape(state), "", http.StatusOK, nil)
+}
+
+// call sends a request and decodes a JSON response into out when it is not nil
+func call(t *testing.T, client *http.Client, method, url, body string, want int, out interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != want {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, url, resp.StatusCode, want, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: invalid JSON %q: %v", method, url, data, err)
+		}
+	}
+}
+
+func TestIntegrationLoginCallbackOrders(t *testing.T) {
+	server, fake := startIntegrationServer(t)
+	fake.orders = []map[string]interface{}{
+		{"id": "o-1", "status": "pending", "total": 42.5, "currency": "tnd", "created_at": "2024-05-01T10:00:00Z",
+			"customer": map[string]string{"name": "Amira", "phone": "+21674000000"}},
+	}
+	client := integrationClient(t)
+
+	// /login redirects to the Converty authorization page
+	req, _ := http.NewRequest("GET", server.URL+"/login", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(resp.Header.Get("Location"), authURL) {
+		t.Fatalf("login: status %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	// The callback exchanges the code and stores the token
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/callback?code=abc&state="+url.QueryEscape(location.Query().Get("state")), "", http.StatusOK, nil)
+	// A forged or replayed state is refused
+	call(t, client, "GET", server.URL+"/api/v1/callback?code=abc&state=xyz123", "", http.StatusBadRequest, nil)
+	var stored TokenInfo
+	if err := db.Where("user_id = ?", service.DefaultTenant.TokenUserID).First(&stored).Error; err != nil {
+		t.Fatalf("token not stored: %v", err)
+	}
+	if stored.AccessToken != "access-authorization_code" || stored.RefreshToken != "refresh-1" {
+		t.Fatalf("unexpected stored token: %+v", stored)
+	}
+
+	// Orders come from the fake Converty with the stored token
+	var orders struct {
+		Data []service.Order `json:"data"`
+	}
+	call(t, client, "GET", server.URL+"/api/v1/orders", "", http.StatusOK, &orders)
+	if len(orders.Data) != 1 || orders.Data[0].ID != "o-1" || orders.Data[0].Currency != "TND" {
+		t.Fatalf("unexpected orders: %+v", orders)
+	}
+	if got := fake.grantTypes(); len(got) != 1 || got[0] != "authorization_code" {
+		t.Fatalf("token requests: %v", got)
+	}
+}
+
+func TestIntegrationOAuthAttempts(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+
+	resp, err := client.Get(server.URL + "/login?remember=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := location.Query().Get("state")
+	var attempt OAuthAttempt
+	if err := db.Where("state = ?", state).First(&attempt).Error; err != nil || !attempt.Remember || attempt.Scopes == "" {
+		t.Fatalf("attempt not stored: %+v %v", attempt, err)
+	}
+
+	// The attempt lives in the database, so a fresh client (or a restarted server) completes the flow
+	call(t, integrationClient(t), "GET", server.URL+"/api/v1/callback?code=abc&state="+state, "", http.StatusOK, nil)
+	call(t, integrationClient(t), "GET", server.URL+"/api/v1/callback?code=abc&state="+state, "", http.StatusBadRequest, nil)
+
+	expired := OAuthAttempt{State: "expired-" + state, ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := db.Create(&expired).Error; err != nil {
+		t.Fatal(err)
+	}
+	call(t, integrationClient(t), "GET", server.URL+"/api/v1/callback?code=abc&state="+expired.State, "", http.StatusBadRequest, nil)
+	if purged, err := purgeOAuthAttempts(time.Now()); err != nil || purged == 0 {
+		t.Fatalf("expired attempt not purged: %d %v", purged, err)
+	}
+}
+
+func TestIntegrationRecords(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+
+	var created service.Data
+	call(t, client, "POST", server.URL+"/api/v1/records",
+		`{"user_id": 7, "type": "issue", "status": "pending", "details": {"description": "colis en retard"}}`,
+		http.StatusCreated, &created)
+	if created.ID == 0 || created.Type != "issue" {
+		t.Fatalf("unexpected record: %+v", created)
+	}
+
+	recordURL := fmt.Sprintf("%s/api/v1/records/%d", server.URL, created.ID)
+	var updated service.Data
+	call(t, client, "PUT", recordURL+"/status", `{"status": "in_progress", "actor": "agent"}`, http.StatusOK, &updated)
+	if updated.Status != service.StatusInProgress {
+		t.Fatalf("status not updated: %+v", updated)
+	}
+	call(t, client, "PUT", recordURL+"/status", `{"status": "pending"}`, http.StatusConflict, nil)
+
+	var history struct {
+		Data []service.StatusChange `json:"data"`
+	}
+	call(t, client, "GET", recordURL+"/history", "", http.StatusOK, &history)
+	if len(history.Data) != 1 || history.Data[0].Actor != "agent" {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+
+	call(t, client, "DELETE", recordURL, "", http.StatusNoContent, nil)
+	call(t, client, "GET", recordURL, "", http.StatusNotFound, nil)
+}
+
+func TestIntegrationOutbox(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	service.OutboxEnabled = true
+	t.Cleanup(func() { service.OutboxEnabled = false })
+	db.Where("1 = 1").Delete(&service.OutboxEvent{})
+
+	var created service.Data
+	call(t, integrationClient(t), "POST", server.URL+"/api/v1/records",
+		`{"user_id": 7, "type": "issue", "status": "pending", "details": {"description": "outbox"}}`, http.StatusCreated, &created)
+	call(t, integrationClient(t), "PUT", fmt.Sprintf("%s/api/v1/records/%d/status", server.URL, created.ID),
+		`{"status": "in_progress"}`, http.StatusOK, nil)
+
+	var failing atomic.Bool
+	failing.Store(true)
+	var received []service.OutboxMessage
+	var mu sync.Mutex
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var message service.OutboxMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		mu.Lock()
+		received = append(received, message)
+		mu.Unlock()
+	}))
+	t.Cleanup(webhook.Close)
+	outbox := service.NewGormOutboxService(db, []service.OutboxDestination{service.NewWebhookDestination(webhook.URL, "")}, 1)
+
+	// One failed attempt dead-letters both events
+	result, err := outbox.Dispatch()
+	if err != nil || result.Dead != 2 {
+		t.Fatalf("dispatch: %+v, %v", result, err)
+	}
+	var dead []service.OutboxEvent
+	call(t, adminClient(t), "GET", server.URL+"/api/v1/admin/outbox?status=dead", "", http.StatusOK, &dead)
+	if len(dead) != 2 || dead[0].Type != service.EventRecordStatusChanged || dead[1].Type != service.EventRecordCreated || dead[0].LastError == "" {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+
+	failing.Store(false)
+	for _, event := range dead {
+		call(t, adminClient(t), "POST", fmt.Sprintf("%s/api/v1/admin/outbox/%d/retry", server.URL, event.ID), "", http.StatusOK, nil)
+	}
+	if result, err = outbox.Dispatch(); err != nil || result.Delivered != 2 {
+		t.Fatalf("redelivery: %+v, %v", result, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[0].Type != service.EventRecordCreated || !strings.Contains(string(received[0].Data), `"issue"`) {
+		t.Fatalf("unexpected deliveries: %+v", received)
+	}
+	call(t, adminClient(t), "POST", server.URL+"/api/v1/admin/outbox/999999/retry", "", http.StatusNotFound, nil)
+}
+
+func TestIntegrationOutboxRecordLifecycle(t *testing.T) {
+	startIntegrationServer(t)
+	service.OutboxEnabled = true
+	t.Cleanup(func() { service.OutboxEnabled = false })
+	tenant, _, err := service.NewGormTenantService(db).CreateTenant("outbox-shop", "Outbox Shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataService := service.NewGormDataService(db, service.DataServiceOptions{}).ForTenant(tenant)
+	record, err := dataService.InsertRecord(4242, "issue", map[string]interface{}{"description": "lifecycle"}, service.StatusPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dataService.ArchiveRecord(record.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dataService.RestoreRecord(record.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataService.DeleteRecord(record.ID); err != nil {
+		t.Fatal(err)
+	}
+	// The purge spares the records of the other tests, kept like customers under legal hold
+	var others []uint
+	if err := db.Unscoped().Model(&service.Data{}).Where("user_id <> ?", 4242).Distinct().Pluck("user_id", &others).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dataService.PurgeRecords(time.Now().Add(time.Minute), others); err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	if err := db.Model(&service.OutboxEvent{}).Where("tenant_id = ?", tenant.ID).Order("id").Pluck("type", &types).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{service.EventRecordCreated, service.EventRecordArchived, service.EventRecordRestored, service.EventRecordDeleted, service.EventRecordPurged}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("outbox events %v, want %v", types, want)
+	}
+}
+
+func TestIntegrationConversations(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	id := fmt.Sprintf("wa-%d", time.Now().UnixNano())
+	messagesURL := server.URL + "/api/v1/conversations/" + id + "/messages"
+
+	call(t, client, "POST", messagesURL, `{"text": "where is my order?", "intent": "track_order", "confidence": 0.92, "entities": {"order_id": "o-1"}, "user_id": 7}`, http.StatusCreated, nil)
+	call(t, client, "POST", messagesURL, `{"role": "bot", "text": "It is on its way."}`, http.StatusCreated, nil)
+	var third service.ConversationMessage
+	call(t, client, "POST", messagesURL, `{"text": "it arrived broken", "intent": "report_issue", "record_id": 12}`, http.StatusCreated, &third)
+	if third.Seq != 3 {
+		t.Fatalf("unexpected sequence: %+v", third)
+	}
+	call(t, client, "POST", messagesURL, `{"role": "system", "text": "x"}`, http.StatusBadRequest, nil)
+
+	var conversation service.Conversation
+	call(t, client, "GET", server.URL+"/api/v1/conversations/"+id, "", http.StatusOK, &conversation)
+	if conversation.MessageCount != 3 || conversation.UserID != 7 || conversation.LastIntent != "report_issue" || len(conversation.Messages) != 3 {
+		t.Fatalf("unexpected conversation: %+v", conversation)
+	}
+	if conversation.Messages[1].Role != service.RoleBot || !strings.Contains(string(conversation.Messages[0].Entities), "o-1") || *conversation.Messages[2].RecordID != 12 {
+		t.Fatalf("unexpected thread: %+v", conversation.Messages)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/conversations/missing", "", http.StatusNotFound, nil)
+
+	var listed struct {
+		Data []service.Conversation `json:"data"`
+	}
+	call(t, client, "GET", server.URL+"/api/v1/conversations?intent=report_issue&user_id=7", "", http.StatusOK, &listed)
+	if len(listed.Data) == 0 || listed.Data[0].ConversationID != id {
+		t.Fatalf("unexpected listing: %+v", listed.Data)
+	}
+}
+
+func TestIntegrationRetention(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	previous := retentionPolicy
+	retentionPolicy = service.RetentionPolicy{
+		AnonymizeAfter: 30 * 24 * time.Hour,
+		HashFields:     service.DefaultAnonymizeHashFields,
+		DropFields:     service.DefaultAnonymizeDropFields,
+	}
+	t.Cleanup(func() { retentionPolicy = previous })
+
+	var created service.Data
+	call(t, integrationClient(t), "POST", server.URL+"/api/v1/records", `{"user_id": 31, "type": "issue", "details": {"name": "Amira", "phone": "+21674000031"}}`, http.StatusCreated, &created)
+	if err := db.Model(&service.Data{}).Where("id = ?", created.ID).Update("created_at", time.Now().AddDate(0, -2, 0)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var report service.RetentionReport
+	call(t, adminClient(t), "GET", server.URL+"/api/v1/admin/retention", "", http.StatusOK, &report)
+	if !report.DryRun || report.Anonymized == 0 || report.AnonymizeCutoff == nil {
+		t.Fatalf("unexpected dry run: %+v", report)
+	}
+	report, err := service.NewGormRetentionService(db, nil, nil).Apply(retentionPolicy, nil, false)
+	if err != nil || report.Anonymized == 0 {
+		t.Fatalf("retention run: %+v %v", report, err)
+	}
+	var stored service.Data
+	if err := db.First(&stored, created.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.AnonymizedAt == nil || strings.Contains(string(stored.Details), "Amira") || strings.Contains(string(stored.Details), "+21674000031") {
+		t.Fatalf("record not anonymized: %+v %s", stored, stored.Details)
+	}
+}
+
+func TestIntegrationAttachmentsFollowTheirRecord(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ATTACHMENT_DIR", dir)
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	var owner, other service.Data
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"user_id": 51, "type": "issue", "details": {"message": "cracked screen"}}`, http.StatusCreated, &owner)
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"user_id": 52, "type": "issue", "details": {"message": "late"}}`, http.StatusCreated, &other)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "photo.png")
+	part.Write([]byte("\x89PNG\r\n\x1a\n0000"))
+	form.Close()
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/records/%d/attachments", server.URL, owner.ID), &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created []service.Attachment
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || len(created) != 1 {
+		t.Fatalf("upload: status %d, %+v", resp.StatusCode, created)
+	}
+
+	var stored service.Attachment
+	if err := db.First(&stored, created[0].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Another record's URL cannot reach the attachment
+	call(t, client, "DELETE", fmt.Sprintf("%s/api/v1/records/%d/attachments/%d", server.URL, other.ID, created[0].ID), "", http.StatusNotFound, nil)
+	// Deleting the record removes the file with it
+	call(t, client, "DELETE", fmt.Sprintf("%s/api/v1/records/%d", server.URL, owner.ID), "", http.StatusNoContent, nil)
+	var left int64
+	db.Model(&service.Attachment{}).Where("record_id = ?", owner.ID).Count(&left)
+	if _, err := os.Stat(filepath.Join(dir, stored.StorageKey)); left != 0 || !os.IsNotExist(err) {
+		t.Fatalf("attachment kept after its record was deleted: %d rows, %v", left, err)
+	}
+}
+
+func TestIntegrationWebhooks(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	received := make(chan *http.Request, 1)
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		received <- r
+	}))
+	t.Cleanup(receiver.Close)
+
+	var created struct {
+		Webhook service.WebhookEndpoint `json:"webhook"`
+		Secret  string                  `json:"secret"`
+	}
+	call(t, integrationClient(t), "POST", server.URL+"/api/v1/webhooks",
+		`{"url": "`+receiver.URL+`", "events": ["issue.created"]}`, http.StatusCreated, &created)
+	if created.Secret == "" {
+		t.Fatal("no webhook secret returned")
+	}
+	call(t, integrationClient(t), "POST", server.URL+"/api/v1/webhooks", `{"url": "ftp://bot", "events": ["issue.created"]}`, http.StatusUnprocessableEntity, nil)
+
+	webhooks := service.NewGormWebhookService(db, 3, true)
+	if queued, err := webhooks.Enqueue(0, service.WebhookIssueResolved, nil); err != nil || queued != 0 {
+		t.Fatalf("unsubscribed event queued: %d %v", queued, err)
+	}
+	if queued, err := webhooks.Enqueue(0, service.WebhookIssueCreated, map[string]interface{}{"id": 41, "type": "issue"}); err != nil || queued != 1 {
+		t.Fatalf("Enqueue: %d %v", queued, err)
+	}
+	if result, err := webhooks.Dispatch(); err != nil || result.Delivered != 1 {
+		t.Fatalf("Dispatch: %+v %v", result, err)
+	}
+	req := <-received
+	if req.Header.Get("X-Webhook-Signature") != "sha256="+service.SignWebhook(created.Secret, req.Header.Get("X-Webhook-Timestamp"), body) || req.Header.Get("X-Webhook-Event") != service.WebhookIssueCreated {
+		t.Fatalf("unexpected webhook headers %v", req.Header)
+	}
+
+	var deliveries struct {
+		Data []service.WebhookDelivery `json:"data"`
+	}
+	call(t, integrationClient(t), "GET", server.URL+"/api/v1/webhooks/deliveries?status=delivered", "", http.StatusOK, &deliveries)
+	if len(deliveries.Data) != 1 || deliveries.Data[0].ResponseStatus != http.StatusOK {
+		t.Fatalf("unexpected delivery log: %+v", deliveries.Data)
+	}
+}
+
+func TestIntegrationRecordTags(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	var urgent, other service.Data
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"user_id": 41, "type": "issue", "status": "pending", "details": {"message": "broken blender"}}`, http.StatusCreated, &urgent)
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"user_id": 42, "type": "issue", "status": "pending", "details": {"message": "late parcel"}}`, http.StatusCreated, &other)
+
+	var tagged struct {
+		Tags []string `json:"tags"`
+	}
+	call(t, client, "POST", fmt.Sprintf("%s/api/v1/records/%d/tags", server.URL, urgent.ID), `{"tags": ["Urgent", "refund", "urgent"]}`, http.StatusOK, &tagged)
+	if strings.Join(tagged.Tags, ",") != "refund,urgent" {
+		t.Fatalf("unexpected tags %v", tagged.Tags)
+	}
+	call(t, client, "POST", fmt.Sprintf("%s/api/v1/records/%d/tags", server.URL, other.ID), `{"tags": ["refund"]}`, http.StatusOK, nil)
+	call(t, client, "POST", fmt.Sprintf("%s/api/v1/records/%d/tags", server.URL, other.ID), `{"tags": ["no spaces"]}`, http.StatusUnprocessableEntity, nil)
+
+	var page struct {
+		Data []service.Data `json:"data"`
+	}
+	call(t, client, "GET", server.URL+"/api/v1/records?tag=refund&tag=urgent", "", http.StatusOK, &page)
+	if len(page.Data) != 1 || page.Data[0].ID != urgent.ID {
+		t.Fatalf("tag filter returned %+v", page.Data)
+	}
+
+	call(t, client, "PUT", server.URL+"/api/v1/records/filters/refunds", `{"query": "tag=refund&type=issue"}`, http.StatusOK, nil)
+	call(t, client, "PUT", server.URL+"/api/v1/records/filters/bad", `{"query": "user_id=1"}`, http.StatusUnprocessableEntity, nil)
+	call(t, client, "GET", server.URL+"/api/v1/records?filter=refunds", "", http.StatusOK, &page)
+	if len(page.Data) != 2 {
+		t.Fatalf("saved filter returned %d records", len(page.Data))
+	}
+	call(t, client, "DELETE", fmt.Sprintf("%s/api/v1/records/%d/tags/refund", server.URL, other.ID), "", http.StatusNoContent, nil)
+	call(t, client, "GET", server.URL+"/api/v1/records?filter=refunds", "", http.StatusOK, &page)
+	if len(page.Data) != 1 {
+		t.Fatalf("untagged record still listed: %+v", page.Data)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/records?filter=missing", "", http.StatusNotFound, nil)
+}
+
+func TestIntegrationAddressBook(t *testing.T) {
+	server, fake := startIntegrationServer(t)
+	client := integrationClient(t)
+	authorize(t, client, server.URL)
+	book := server.URL + "/api/v1/customers/+21698111222/addresses"
+
+	// The address records of the chatbot fill the address book
+	var record service.Data
+	call(t, client, "POST", server.URL+"/api/v1/records",
+		`{"user_id": 61, "type": "address", "details": {"phone": "+216 98 111 222", "name": "Salma", "address": {"street": "5 rue de Marseille", "city": "Tunis", "postal_code": "1001"}}}`,
+		http.StatusCreated, &record)
+	var addresses []service.Address
+	call(t, client, "GET", book, "", http.StatusOK, &addresses)
+	if len(addresses) != 1 || !addresses[0].IsDefault || addresses[0].RecordID == nil || *addresses[0].RecordID != record.ID {
+		t.Fatalf("address record not in the book: %+v", addresses)
+	}
+	home := addresses[0]
+
+	var work service.Address
+	call(t, client, "POST", book, `{"label": "work", "line1": "Route de Gabes km 4", "city": "Sakiet Ezzit", "governorate": "sfax", "postal_code": "3021"}`, http.StatusCreated, &work)
+	if work.IsDefault || work.Governorate != "Sfax" {
+		t.Fatalf("unexpected second address: %+v", work)
+	}
+	call(t, client, "POST", book, `{"line1": "1 avenue Habib Bourguiba", "city": "Tunis", "governorate": "Atlantis"}`, http.StatusUnprocessableEntity, nil)
+	call(t, client, "PUT", book+"/default", fmt.Sprintf(`{"address_id": %d}`, work.ID), http.StatusOK, nil)
+
+	// Orders without an address go to the default one
+	call(t, client, "POST", server.URL+"/api/v1/orders", `{"customer": {"phone": "+21698111222"}, "items": [{"product_id": "p-1", "quantity": 1}]}`, http.StatusCreated, nil)
+	fake.mu.Lock()
+	customer, _ := fake.orders[0]["customer"].(map[string]interface{})
+	fake.mu.Unlock()
+	if customer["address"] != "Route de Gabes km 4, 3021 Sfax" || customer["city"] != "Sakiet Ezzit" || customer["name"] != "" {
+		t.Fatalf("order not completed from the default address: %+v", customer)
+	}
+
+	call(t, client, "DELETE", fmt.Sprintf("%s/%d", book, work.ID), "", http.StatusNoContent, nil)
+	var fallback service.Address
+	call(t, client, "GET", book+"/default", "", http.StatusOK, &fallback)
+	if fallback.ID != home.ID {
+		t.Fatalf("default after deleting it: %+v", fallback)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/customers/+21600000000/addresses/default", "", http.StatusNotFound, nil)
+}
+
+func TestIntegrationStockReservations(t *testing.T) {
+	server, fake := startIntegrationServer(t)
+	client := integrationClient(t)
+	authorize(t, client, server.URL)
+	if err := db.Create(&service.ProductStock{ProductID: "last-unit", Name: "Lamp", Stock: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The first chat session holds the last unit; the second one is told it is gone
+	var held service.Reservation
+	call(t, client, "POST", server.URL+"/api/v1/reservations", `{"product_id": "last-unit", "quantity": 1, "phone": "+216 98 111 222", "ttl": "5m"}`, http.StatusCreated, &held)
+	var shortage map[string]interface{}
+	call(t, client, "POST", server.URL+"/api/v1/reservations", `{"product_id": "last-unit", "quantity": 1, "phone": "+21655000111"}`, http.StatusConflict, &shortage)
+	if shortage["available"] != float64(0) {
+		t.Fatalf("unexpected shortage answer: %+v", shortage)
+	}
+	call(t, client, "POST", server.URL+"/api/v1/reservations", `{"product_id": "unknown", "quantity": 1, "phone": "+21655000111"}`, http.StatusNotFound, nil)
+
+	// Only the holder's order gets the unit
+	fake.mu.Lock()
+	sent := len(fake.orders)
+	fake.mu.Unlock()
+	order := `{"customer": {"phone": "%s", "address": "5 rue de Marseille", "city": "Tunis"}, "items": [{"product_id": "last-unit", "quantity": 1}]}`
+	call(t, client, "POST", server.URL+"/api/v1/orders", fmt.Sprintf(order, "+21655000111"), http.StatusConflict, nil)
+	fake.mu.Lock()
+	if len(fake.orders) != sent {
+		t.Error("an order beyond the stock reached Converty")
+	}
+	fake.mu.Unlock()
+	var created service.OrderCreation
+	call(t, client, "POST", server.URL+"/api/v1/orders", fmt.Sprintf(order, "+21698111222"), http.StatusCreated, &created)
+
+	var consumed service.Reservation
+	call(t, client, "GET", fmt.Sprintf("%s/api/v1/reservations/%d", server.URL, held.ID), "", http.StatusOK, &consumed)
+	if consumed.Status != service.ReservationConsumed || consumed.OrderID != created.Order.ID {
+		t.Fatalf("reservation not consumed by order %s: %+v", created.Order.ID, consumed)
+	}
+	call(t, client, "DELETE", fmt.Sprintf("%s/api/v1/reservations/%d", server.URL, held.ID), "", http.StatusConflict, nil)
+}
+
+func TestIntegrationWarehouseExport(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	dir := t.TempDir()
+	exporter := service.NewGormWarehouseExportService(db, service.WarehouseExportOptions{Store: &service.LocalBlobStore{Dir: dir}, BatchSize: 2})
+
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"user_id": 71, "type": "issue", "details": {"note": "late"}}`, http.StatusCreated, nil)
+	first, err := exporter.Export(context.Background(), 0)
+	if err != nil || first.Rows == 0 {
+		t.Fatalf("first export: %+v, %v", first, err)
+	}
+	for _, object := range first.Objects {
+		if !strings.HasPrefix(object, "interactions/dt=") {
+			t.Errorf("object %s not partitioned by date", object)
+		}
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(object))); err != nil {
+			t.Errorf("object %s not written: %v", object, err)
+		}
+	}
+
+	// Only the rows added since are exported next
+	if again, err := exporter.Export(context.Background(), 0); err != nil || again.Rows != 0 {
+		t.Fatalf("repeated export: %+v, %v", again, err)
+	}
+	var record service.Data
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"user_id": 71, "type": "issue", "details": {"note": "lost"}}`, http.StatusCreated, &record)
+	next, err := exporter.Export(context.Background(), 0)
+	if err != nil || next.Rows != 1 || next.Cursor != record.ID {
+		t.Fatalf("incremental export: %+v, %v", next, err)
+	}
+	state, err := exporter.State()
+	if err != nil || state.Cursor != record.ID || state.LastExported != 1 {
+		t.Fatalf("unexpected state: %+v, %v", state, err)
+	}
+}
+
+func TestIntegrationRecordDetailsPatch(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	var record service.Data
+	call(t, client, "POST", server.URL+"/api/v1/records",
+		`{"user_id": 51, "type": "address", "details": {"address": {"street": "5 rue de Marseille", "city": "Tunis"}, "note": "ring twice"}}`,
+		http.StatusCreated, &record)
+
+	patch := func(body, ifMatch string) *http.Response {
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/api/v1/records/%d/details", server.URL, record.ID), strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("If-Match", ifMatch)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := patch(`{"address": {"city": "Sfax", "zip": "3000"}, "note": null}`, `"1"`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != `"2"` {
+		t.Fatalf("patch: status %d, ETag %s", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	var patched service.Data
+	if err := json.NewDecoder(resp.Body).Decode(&patched); err != nil {
+		t.Fatal(err)
+	}
+	var details map[string]interface{}
+	json.Unmarshal(patched.Details, &details)
+	address, _ := details["address"].(map[string]interface{})
+	if _, kept := details["note"]; kept || address["street"] != "5 rue de Marseille" || address["city"] != "Sfax" || address["zip"] != "3000" {
+		t.Fatalf("unexpected merged details %s", patched.Details)
+	}
+
+	if resp := patch(`{"note": "stale"}`, `"1"`); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("stale revision: status %d, want 412", resp.StatusCode)
+	}
+	if resp := patch(`["not", "an", "object"]`, ""); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("array patch: status %d, want 422", resp.StatusCode)
+	}
+}
+
+func TestIntegrationCustomerMerge(t *testing.T) {
+	startIntegrationServer(t)
+	dataService := service.NewGormDataService(db, service.DataServiceOptions{})
+	wallets := service.NewGormWalletService(db, "TND")
+	merges := service.NewGormCustomerMergeService(db)
+
+	record, err := dataService.InsertRecord(2, "issue", map[string]interface{}{"phone": "+216 98 111 222"}, "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&service.LoyaltyEntry{CustomerPhone: "+21698111222", Kind: service.LoyaltyAccrual, Points: 40}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wallets.Credit(0, "+21698111222", service.WalletRefundCredit, 5, "o-1", "refund", "test"); err != nil {
+		t.Fatal(err)
+	}
+	// The same phone in another tenant is another customer, with its own wallet
+	if _, err := wallets.Credit(7, "+21698111222", service.WalletRefundCredit, 3, "o-1", "refund", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	merge, err := merges.Merge(0, service.CustomerIdentity{Phone: "+21674000000", UserID: 1},
+		service.CustomerIdentity{Phone: "+21698111222", UserID: 2}, "same customer", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, _ := dataService.QueryByID(record.ID)
+	if moved.UserID != 1 || !strings.Contains(string(moved.Details), `"+21674000000"`) {
+		t.Fatalf("record not relinked: %+v", moved)
+	}
+	if balance, _ := wallets.Balance(0, "+21674000000", false); balance.Balance != 5 {
+		t.Fatalf("wallet not relinked: %+v", balance)
+	}
+	if balance, _ := wallets.Balance(7, "+21698111222", false); balance.Balance != 3 {
+		t.Fatalf("merge moved another tenant's wallet: %+v", balance)
+	}
+	if merge.Relinked["chatbot.loyalty_entries"] != 1 {
+		t.Fatalf("unexpected relinked counts: %v", merge.Relinked)
+	}
+
+	if _, err := merges.Undo(0, merge.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := dataService.QueryByID(record.ID)
+	if restored.UserID != 2 || !strings.Contains(string(restored.Details), `"+216 98 111 222"`) {
+		t.Fatalf("record not restored: %+v", restored)
+	}
+	if balance, _ := wallets.Balance(0, "+21698111222", false); balance.Balance != 5 {
+		t.Fatalf("wallet not restored: %+v", balance)
+	}
+	if _, err := merges.Undo(0, merge.ID, "test"); !errors.Is(err, service.ErrMergeUndone) {
+		t.Fatalf("second undo: got %v, want ErrMergeUndone", err)
+	}
+}
+
+// deliveredOrders serves a fixed listing of delivered orders page by page
+type deliveredOrders struct {
+	service.DataService
+	orders []service.Order
+}
+
+func (d deliveredOrders) ListOrders(query service.CustomerOrderQuery) ([]service.Order, error) {
+	start := min((query.Page-1)*query.Limit, len(d.orders))
+	return d.orders[start:min(start+query.Limit, len(d.orders))], nil
+}
+
+func (d deliveredOrders) GetOrder(id string) (service.Order, error) {
+	for _, order := range d.orders {
+		if order.ID == id {
+			return order, nil
+		}
+	}
+	return service.Order{}, fmt.Errorf("order %s not found", id)
+}
+
+func TestIntegrationLoyaltyAccrualResumes(t *testing.T) {
+	startIntegrationServer(t)
+	rules := service.DefaultLoyaltyRules
+	rules.MaxAccrualsPerDay = 1
+	loyalty := service.NewGormLoyaltyService(db, service.NewGormWalletService(db, "TND"), rules)
+	delivered := func(id, phone string) service.Order {
+		return service.Order{ID: id, Status: service.OrderDelivered, Total: 20, Customer: service.Customer{Phone: phone}}
+	}
+	// 61 orders over two pages of 50; the second order of +21690000000 hits the daily limit
+	orders := []service.Order{delivered("o-0", "+21690000000"), delivered("o-extra", "+21690000000")}
+	for i := 1; i < 60; i++ {
+		orders = append(orders, delivered(fmt.Sprintf("o-%d", i), fmt.Sprintf("+2169000%04d", i)))
+	}
+	data := deliveredOrders{orders: orders}
+	const tenantID = 11
+
+	for _, run := range []struct {
+		name string
+		want int
+	}{
+		{"first page, one order deferred", 49},
+		{"second page resumed from the cursor", 11},
+	} {
+		if accrued, err := loyalty.AccrueDelivered(tenantID, data, 1); err != nil || accrued != run.want {
+			t.Fatalf("%s: accrued %d, %v, want %d", run.name, accrued, err, run.want)
+		}
+	}
+	var deferred int64
+	db.Model(&service.LoyaltyDeferral{}).Where("tenant_id = ?", tenantID).Count(&deferred)
+	if deferred != 1 {
+		t.Fatalf("%d deferred orders, want 1", deferred)
+	}
+
+	// A day later the deferred order earns its points
+	db.Model(&service.LoyaltyEntry{}).Where("tenant_id = ? AND customer_phone = ?", tenantID, "+21690000000").
+		Update("created_at", time.Now().Add(-25*time.Hour))
+	if accrued, err := loyalty.AccrueDelivered(tenantID, data, 1); err != nil || accrued != 1 {
+		t.Fatalf("deferred order: accrued %d, %v, want 1", accrued, err)
+	}
+	db.Model(&service.LoyaltyDeferral{}).Where("tenant_id = ?", tenantID).Count(&deferred)
+	if balance, _ := loyalty.Balance(tenantID, "+21690000000", false); deferred != 0 || balance.Points != 40 {
+		t.Fatalf("after the deferred accrual: %d deferred, %d points", deferred, balance.Points)
+	}
+}
+
+func TestIntegrationConcurrentLoyaltyRedeem(t *testing.T) {
+	startIntegrationServer(t)
+	rules := service.DefaultLoyaltyRules
+	rules.RedeemCooldown = 0
+	loyalty := service.NewGormLoyaltyService(db, service.NewGormWalletService(db, "TND"), rules)
+	if err := db.Create(&service.LoyaltyEntry{TenantID: 3, CustomerPhone: "+21698333444", Kind: service.LoyaltyAccrual, Points: 300}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var redeemed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := loyalty.Redeem(3, "+21698333444", 200); err == nil {
+				redeemed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	balance, err := loyalty.Balance(3, "+21698333444", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redeemed.Load() != 1 || balance.Points != 100 {
+		t.Fatalf("%d redemptions left %d points, want 1 leaving 100", redeemed.Load(), balance.Points)
+	}
+}
+
+func TestIntegrationJobQueue(t *testing.T) {
+	startIntegrationServer(t)
+	jobs := service.NewGormJobService(db)
+	var calls atomic.Int32
+	jobs.RegisterHandler("flaky", func(payload json.RawMessage) (interface{}, error) {
+		if calls.Add(1) == 1 {
+			return nil, errors.New("first attempt fails")
+		}
+		return "ok", nil
+	})
+	jobs.RegisterHandler("lost", func(payload json.RawMessage) (interface{}, error) { return "resumed", nil })
+	awaitJob := func(id uint, status string, attempts int) service.Job {
+		t.Helper()
+		deadline := time.Now().Add(15 * time.Second)
+		for {
+			job, err := jobs.GetJob(0, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.Status == status && job.Attempts == attempts {
+				return job
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %d is %s after %d attempts, want %s after %d", id, job.Status, job.Attempts, status, attempts)
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+	}
+
+	// Jobs whose worker died mid-run: one with attempts left runs again, the other fails
+	expired := time.Now().Add(-time.Minute)
+	lost := service.Job{Type: "lost", Status: service.JobRunning, Attempts: 1, MaxAttempts: 3, RunAt: expired, StartedAt: &expired, LockedUntil: &expired}
+	spent := service.Job{Type: "lost", Status: service.JobRunning, Attempts: 3, MaxAttempts: 3, RunAt: expired, StartedAt: &expired, LockedUntil: &expired}
+	if err := db.Create(&lost).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&spent).Error; err != nil {
+		t.Fatal(err)
+	}
+	flaky, err := jobs.Enqueue(0, "flaky", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs.Start(1)
+	defer jobs.Stop()
+
+	if job := awaitJob(lost.ID, service.JobCompleted, 2); job.LockedUntil != nil {
+		t.Fatalf("lost job resumed as %+v", job)
+	}
+	awaitJob(spent.ID, service.JobFailed, 3)
+	retried := awaitJob(flaky.ID, service.JobQueued, 1)
+	if retried.RunAt.Before(time.Now().Add(20 * time.Second)) {
+		t.Fatalf("failed attempt not backed off: %+v", retried)
+	}
+	// Skip the backoff rather than wait it out
+	db.Model(&service.Job{}).Where("id = ?", flaky.ID).Update("run_at", time.Now())
+	awaitJob(flaky.ID, service.JobCompleted, 2)
+
+	// Instances ticking in the same slot queue one job
+	slot := time.Now().Truncate(time.Hour)
+	queued := 0
+	for i := 0; i < 3; i++ {
+		if _, ok, err := jobs.EnqueueScheduled(0, "scheduled", nil, slot); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			queued++
+		}
+	}
+	if _, ok, err := jobs.EnqueueScheduled(0, "scheduled", nil, slot.Add(time.Hour)); err != nil || !ok || queued != 1 {
+		t.Fatalf("one slot queued %d jobs, the next %v, %v", queued, ok, err)
+	}
+}
+
+func TestIntegrationAdminTokens(t *testing.T) {
+	server, fake := startIntegrationServer(t)
+	authorize(t, integrationClient(t), server.URL)
+	admin := adminClient(t)
+	user := service.DefaultTenant.TokenUserID
+
+	call(t, integrationClient(t), "GET", server.URL+"/api/v1/admin/tokens", "", http.StatusUnauthorized, nil)
+	var tokens struct {
+		Data []adminTokenView `json:"data"`
+	}
+	call(t, admin, "GET", server.URL+"/api/v1/admin/tokens", "", http.StatusOK, &tokens)
+	if len(tokens.Data) != 1 || tokens.Data[0].UserID != user || tokens.Data[0].AccessExpired {
+		t.Fatalf("unexpected tokens: %+v", tokens)
+	}
+
+	var refreshed adminTokenView
+	call(t, admin, "POST", server.URL+"/api/v1/admin/tokens/"+user+"/refresh", "", http.StatusOK, &refreshed)
+	if got := fake.grantTypes(); len(got) != 2 || got[1] != "refresh_token" {
+		t.Fatalf("token requests: %v", got)
+	}
+	var stored TokenInfo
+	db.Where("user_id = ?", user).First(&stored)
+	if stored.AccessToken != "access-refresh_token" {
+		t.Fatalf("refreshed token not stored: %+v", stored)
+	}
+
+	call(t, admin, "DELETE", server.URL+"/api/v1/admin/tokens/"+user, "", http.StatusNoContent, nil)
+	call(t, admin, "POST", server.URL+"/api/v1/admin/tokens/"+user+"/refresh", "", http.StatusNotFound, nil)
+	call(t, integrationClient(t), "GET", server.URL+"/api/v1/token/status", "", http.StatusNotFound, nil)
+}
+
+func TestIntegrationConcurrentTokenRefresh(t *testing.T) {
+	server, fake := startIntegrationServer(t)
+	authorize(t, integrationClient(t), server.URL)
+	user := service.DefaultTenant.TokenUserID
+	db.Model(&TokenInfo{}).Where("user_id = ?", user).Update("expires_at", time.Now().Add(-time.Minute))
+	var stale TokenInfo
+	db.Where("user_id = ?", user).First(&stale)
+
+	// Every caller read the expired token; only one refresh grant reaches the provider
+	var wg sync.WaitGroup
+	results := make([]TokenInfo, 8)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = refreshStoredToken(stale)
+		}(i)
+	}
+	wg.Wait()
+	for i := range results {
+		if errs[i] != nil || results[i].AccessToken != "access-refresh_token" {
+			t.Fatalf("caller %d: %+v, %v", i, results[i], errs[i])
+		}
+	}
+	if got := fake.grantTypes(); len(got) != 2 || got[1] != "refresh_token" {
+		t.Fatalf("token requests: %v", got)
+	}
+}
+
+func TestIntegrationDataServiceSharesTokenRefresh(t *testing.T) {
+	server, fake := startIntegrationServer(t)
+	fake.mu.Lock()
+	fake.orders = append(fake.orders, map[string]interface{}{"id": "r-1", "status": "pending"})
+	fake.mu.Unlock()
+	authorize(t, integrationClient(t), server.URL)
+	user := service.DefaultTenant.TokenUserID
+	db.Model(&TokenInfo{}).Where("user_id = ?", user).Update("expires_at", time.Now().Add(-time.Minute))
+	service.Caches.TokenChanged(user)
+
+	// The data service refreshes through the OAuth grant and stores the whole response
+	dataService := service.NewGormDataService(db, service.DataServiceOptions{}).ForTenant(service.DefaultTenant)
+	if _, err := dataService.GetOrder("r-1"); err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if got := fake.grantTypes(); len(got) != 2 || got[1] != "refresh_token" {
+		t.Fatalf("token requests: %v", got)
+	}
+	var stored TokenInfo
+	db.Where("user_id = ?", user).First(&stored)
+	if stored.AccessToken != "access-refresh_token" || stored.RefreshToken != "refresh-1" || tokenExpiring(stored, time.Now()) {
+		t.Fatalf("refreshed token not stored: %+v", stored)
+	}
+}
+
+func TestIntegrationTokenRefreshedBeforeExpiry(t *testing.T) {
+	server, fake := startIntegrationServer(t)
+	client := integrationClient(t)
+	authorize(t, client, server.URL)
+	user := service.DefaultTenant.TokenUserID
+
+	// Still valid, but within the refresh skew: refreshed before the call instead of dying during it
+	db.Model(&TokenInfo{}).Where("user_id = ?", user).Update("expires_at", time.Now().Add(30*time.Second))
+	call(t, client, "GET", server.URL+"/api/v1/orders", "", http.StatusOK, nil)
+	if got := fake.grantTypes(); len(got) != 2 || got[1] != "refresh_token" {
+		t.Fatalf("token requests: %v", got)
+	}
+	var stored TokenInfo
+	db.Where("user_id = ?", user).First(&stored)
+	if stored.AccessToken != "access-refresh_token" || tokenExpiring(stored, time.Now()) {
+		t.Fatalf("token not refreshed: %+v", stored)
+	}
+
+	// A token far from expiry is used as is
+	call(t, client, "GET", server.URL+"/api/v1/orders", "", http.StatusOK, nil)
+	if got := fake.grantTypes(); len(got) != 2 {
+		t.Fatalf("unexpected refresh: %v", got)
+	}
+
+	// An expiring token that cannot be refreshed is refused before calling Converty
+	db.Model(&TokenInfo{}).Where("user_id = ?", user).Updates(map[string]interface{}{
+		"expires_at": time.Now().Add(10 * time.Second), "refresh_expires_at": time.Now().Add(-time.Minute),
+	})
+	var refused ReauthRequiredResponse
+	call(t, client, "GET", server.URL+"/api/v1/orders", "", http.StatusUnauthorized, &refused)
+	if refused.Error != "reauth_required" {
+		t.Fatalf("unexpected refusal: %+v", refused)
+	}
+}
+
+func TestIntegrationRecordSearch(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	for _, details := range []string{
+		`{"description": "the red blender arrived broken", "product": "Blender X2"}`,
+		`{"description": "red dress too small"}`,
+		`{"description": "colis en retard", "city": "Sfax"}`,
+	} {
+		call(t, client, "POST", server.URL+"/api/v1/records", `{"type": "complaint", "status": "pending", "details": `+details+`}`, http.StatusCreated, nil)
+	}
+
+	var results api.Envelope[service.RecordMatch]
+	call(t, client, "GET", server.URL+"/api/v1/records/search?q=complaint+about+the+red+blender", "", http.StatusOK, &results)
+	if *results.Meta.Total != 3 || len(results.Data) != 3 || !strings.Contains(string(results.Data[0].Details), "blender") {
+		t.Fatalf("unexpected ranking: %+v", results)
+	}
+	if !strings.Contains(results.Data[0].Highlight, "<mark>blender</mark>") || results.Data[0].Rank <= results.Data[1].Rank {
+		t.Fatalf("unexpected highlight or rank: %+v", results.Data[0])
+	}
+
+	call(t, client, "GET", server.URL+"/api/v1/records/search?q=%22red+blender%22", "", http.StatusOK, &results)
+	if *results.Meta.Total != 1 {
+		t.Fatalf("phrase search matched %d records", *results.Meta.Total)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/records/search?q=retar&type=issue", "", http.StatusOK, &results)
+	if *results.Meta.Total != 0 {
+		t.Fatalf("type filter ignored: %+v", results)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/records/search?q=!", "", http.StatusBadRequest, nil)
+}
+
+func TestIntegrationPIIEncryption(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+
+	// Written before encryption was enabled
+	var legacy service.Data
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"user_id": 3, "type": "issue", "details": {"phone": "+216 74 000 002"}}`, http.StatusCreated, &legacy)
+
+	protector, err := service.NewPIIProtector(bytes.Repeat([]byte{9}, 32), service.DefaultPIIFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.PII = protector
+	t.Cleanup(func() { service.PII = nil })
+
+	var created service.Data
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"user_id": 4, "type": "issue", "details": {"name": "Amira", "note": "late"}}`, http.StatusCreated, &created)
+	if !strings.Contains(string(created.Details), "Amira") {
+		t.Fatalf("response should show the details in clear: %s", created.Details)
+	}
+	stored := func(id uint) string {
+		var details string
+		if err := db.Raw("SELECT details::text FROM chatbot.interactions WHERE id = ?", id).Scan(&details).Error; err != nil {
+			t.Fatal(err)
+		}
+		return details
+	}
+	if strings.Contains(stored(created.ID), "Amira") {
+		t.Fatalf("name stored in clear: %s", stored(created.ID))
+	}
+	var fetched service.Data
+	call(t, client, "GET", fmt.Sprintf("%s/api/v1/records/%d", server.URL, created.ID), "", http.StatusOK, &fetched)
+	if !strings.Contains(string(fetched.Details), "Amira") {
+		t.Fatalf("record not decrypted: %s", fetched.Details)
+	}
+
+	count, err := service.EncryptExistingRecords(db, 10)
+	if err != nil || count != 1 || strings.Contains(stored(legacy.ID), "74 000 002") {
+		t.Fatalf("existing record not encrypted (%d, %v): %s", count, err, stored(legacy.ID))
+	}
+	matches, err := service.NewGormDataService(db, service.DataServiceOptions{}).SearchRecords(service.RecordFilter{Text: "phone=+21674000002"})
+	if err != nil || len(matches) != 1 || matches[0].ID != legacy.ID {
+		t.Fatalf("lookup by encrypted phone failed: %+v %v", matches, err)
+	}
+}
+
+func TestIntegrationOrderDryRun(t *testing.T) {
+	server, fake := startIntegrationServer(t)
+	client := integrationClient(t)
+	authorize(t, client, server.URL)
+	fake.mu.Lock()
+	before := len(fake.orders)
+	fake.mu.Unlock()
+
+	var preview service.OrderPreview
+	call(t, client, "POST", server.URL+"/api/v1/orders?dry_run=true",
+		`{"customer": {"name": "Amira", "phone": "+216 74 000 001"}, "items": [{"product_id": "p-1", "quantity": 2}]}`, http.StatusOK, &preview)
+	if !preview.DryRun || preview.Request.Method != "POST" || !strings.Contains(preview.Request.URL, "/api/v1/orders?store_id=") {
+		t.Fatalf("unexpected preview: %+v", preview)
+	}
+	if preview.Request.Header["Authorization"] != "[REDACTED]" || !strings.Contains(string(preview.Request.Body), "+21674000001") {
+		t.Fatalf("preview request not redacted or not normalized: %+v", preview.Request)
+	}
+	fake.mu.Lock()
+	after := len(fake.orders)
+	fake.mu.Unlock()
+	if after != before {
+		t.Fatalf("dry run created an order upstream")
+	}
+	call(t, client, "POST", server.URL+"/api/v1/orders?dry_run=true", `{"customer": {}, "items": []}`, http.StatusBadRequest, nil)
+
+	var entries []service.AuditEntry
+	call(t, adminClient(t), "GET", server.URL+"/api/v1/admin/audit?action="+service.AuditOrderDryRun, "", http.StatusOK, &entries)
+	if len(entries) != 2 || !strings.Contains(string(entries[0].Detail), "order") || !strings.Contains(string(entries[1].Detail), "store_id") {
+		t.Fatalf("unexpected audit trail: %+v", entries)
+	}
+}
+
+func TestIntegrationOrderReports(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	authorize(t, client, server.URL)
+	call(t, client, "POST", server.URL+"/api/v1/orders",
+		`{"customer": {"phone": "+21674000010"}, "items": [{"product_id": "p-1", "name": "Lamp", "quantity": 2, "price": 30}]}`, http.StatusCreated, nil)
+	call(t, client, "POST", server.URL+"/api/v1/orders",
+		`{"customer": {"phone": "+21674000011"}, "items": [{"product_id": "p-1", "name": "Lamp", "quantity": 1, "price": 30}, {"product_id": "p-2", "name": "Rug", "quantity": 1, "price": 50}]}`, http.StatusCreated, nil)
+
+	var report service.OrderReport
+	call(t, client, "GET", server.URL+"/api/v1/reports/orders?group_by=product&source=chatbot", "", http.StatusOK, &report)
+	if len(report.Rows) != 2 || report.Rows[0].Group != "p-1" || report.Rows[0].Orders != 2 || report.Rows[0].Quantity != 3 || report.Rows[0].Revenue != 90 {
+		t.Fatalf("unexpected product report: %+v", report.Rows)
+	}
+	if len(report.Totals) != 1 || report.Totals[0].Orders != 2 {
+		t.Fatalf("unexpected totals: %+v", report.Totals)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/reports/orders?group_by=status", "", http.StatusOK, &report)
+	if len(report.Rows) != 1 || report.Rows[0].Group != "pending" || report.Rows[0].Orders != 2 {
+		t.Fatalf("unexpected status report: %+v", report.Rows)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/reports/orders?group_by=day&from=2000-01-01&to=2000-01-02", "", http.StatusOK, &report)
+	if len(report.Rows) != 0 {
+		t.Fatalf("date range ignored: %+v", report.Rows)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/reports/orders?group_by=month", "", http.StatusBadRequest, nil)
+
+	resp, err := client.Get(server.URL + "/api/v1/reports/orders?group_by=week&format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Type") != "text/csv" || !strings.HasPrefix(string(body), "week,name,currency") || !strings.Contains(string(body), "TOTAL") {
+		t.Fatalf("unexpected CSV report %s: %s", resp.Header, body)
+	}
+}
+
+func TestIntegrationOrderCancellation(t *testing.T) {
+	server, fake := startIntegrationServer(t)
+	client := integrationClient(t)
+	authorize(t, client, server.URL)
+	fake.mu.Lock()
+	fake.orders = []map[string]interface{}{
+		{"id": "o-cancel", "status": "pending", "total": 30, "created_at": "2024-05-01T10:00:00Z", "customer": map[string]string{"phone": "+21674000020"}},
+		{"id": "o-shipped", "status": "in_transit", "total": 30, "created_at": "2024-05-01T10:00:00Z"},
+	}
+	fake.mu.Unlock()
+
+	var cancellation service.OrderCancellation
+	call(t, client, "POST", server.URL+"/api/v1/orders/o-cancel/cancel", `{"reason": "ordered twice", "user_id": 9, "notify": true}`, http.StatusOK, &cancellation)
+	if cancellation.Order.Status != service.OrderCancelled || cancellation.PreviousStatus != "pending" || cancellation.RecordID == 0 || !cancellation.Notified {
+		t.Fatalf("unexpected cancellation: %+v", cancellation)
+	}
+	var record service.Data
+	if err := db.First(&record, cancellation.RecordID).Error; err != nil || record.Type != service.CancellationType || record.UserID != 9 {
+		t.Fatalf("cancellation record: %+v %v", record, err)
+	}
+
+	call(t, client, "POST", server.URL+"/api/v1/orders/o-cancel/cancel", "", http.StatusConflict, nil)
+	call(t, client, "POST", server.URL+"/api/v1/orders/o-shipped/cancel", "", http.StatusConflict, nil)
+	call(t, client, "POST", server.URL+"/api/v1/orders/o-cancel/cancel", `{"reason": 5}`, http.StatusBadRequest, nil)
+}
+
+func TestIntegrationOrderMirror(t *testing.T) {
+	server, fake := startIntegrationServer(t)
+	client := integrationClient(t)
+	authorize(t, client, server.URL)
+	created := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	fake.mu.Lock()
+	fake.orders = append(fake.orders,
+		map[string]interface{}{"id": "m-1", "status": "pending", "total": 40, "currency": "tnd", "created_at": created, "updated_at": created,
+			"customer": map[string]interface{}{"name": "Amira", "phone": "+21674000020"}},
+		map[string]interface{}{"id": "m-2", "status": "pending", "total": 15, "currency": "tnd", "created_at": created, "updated_at": created})
+	fake.mu.Unlock()
+
+	mirror := service.NewGormOrderMirrorService(db)
+	dataService := service.NewGormDataService(db, service.DataServiceOptions{}).ForTenant(service.DefaultTenant)
+	first, err := mirror.Sync(service.DefaultTenant.ID, dataService, 5)
+	if err != nil || first.Synced < 2 {
+		t.Fatalf("first sync: %+v, %v", first, err)
+	}
+
+	// Only the order changed since the cursor is copied again
+	fake.mu.Lock()
+	for _, order := range fake.orders {
+		if order["id"] == "m-2" {
+			order["status"] = "delivered"
+			order["updated_at"] = time.Now().UTC().Add(time.Minute).Format(time.RFC3339)
+		}
+	}
+	fake.mu.Unlock()
+	second, err := mirror.Sync(service.DefaultTenant.ID, dataService, 5)
+	if err != nil || second.Synced != 1 || !second.Cursor.After(first.Cursor) {
+		t.Fatalf("second sync: %+v, %v", second, err)
+	}
+	var state service.OrderSyncState
+	call(t, client, "GET", server.URL+"/api/v1/orders/sync", "", http.StatusOK, &state)
+	if !state.Cursor.Equal(second.Cursor) || state.LastSynced != 1 {
+		t.Fatalf("unexpected sync state: %+v", state)
+	}
+
+	var order service.Order
+	call(t, client, "GET", server.URL+"/api/v1/orders/m-2?mirror=true", "", http.StatusOK, &order)
+	if order.Status != "delivered" || order.Currency != "TND" {
+		t.Fatalf("unexpected mirrored order: %+v", order)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/orders/missing?mirror=true", "", http.StatusNotFound, nil)
+
+	// With Converty down the listing is answered from the mirror
+	fake.Close()
+	resp, err := client.Get(server.URL + "/api/v1/orders?search=Amira")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var page api.Envelope[service.Order]
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Orders-Source") != "mirror" {
+		t.Fatalf("no mirror fallback: %d %s", resp.StatusCode, resp.Header)
+	}
+	found := false
+	for _, order := range page.Data {
+		found = found || order.ID == "m-1"
+	}
+	if !found {
+		t.Fatalf("mirrored order missing from %+v", page.Data)
+	}
+}
+
+func TestIntegrationConvertyWebhooks(t *testing.T) {
+	t.Setenv("CONVERTY_WEBHOOK_SECRET", "whsec-test")
+	server, fake := startIntegrationServer(t)
+	client := integrationClient(t)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	deliver := func(body, signature string, want int) map[string]interface{} {
+		t.Helper()
+		req, _ := http.NewRequest("POST", server.URL+"/api/v1/webhooks/converty", strings.NewReader(body))
+		req.Header.Set("X-Converty-Signature", signature)
+		req.Header.Set("X-Converty-Timestamp", timestamp)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		if resp.StatusCode != want {
+			t.Fatalf("webhook: status %d, want %d: %v", resp.StatusCode, want, out)
+		}
+		return out
+	}
+	secret := service.ConvertyWebhookSecret("whsec-test", service.DefaultTenant.Slug)
+	sign := func(body string) string {
+		return "sha256=" + service.SignConvertyWebhook(secret, timestamp, []byte(body))
+	}
+
+	updated := time.Now().UTC().Add(-10 * time.Minute).Format(time.RFC3339)
+	body := `{"id": "evt-w1", "event": "order.updated", "data": {"id": "w-1", "status": "shipped", "total": 30, "currency": "tnd",
+		"created_at": "` + updated + `", "updated_at": "` + updated + `"}}`
+	deliver(body, "sha256=deadbeef", http.StatusUnauthorized)
+	// The master secret, or another tenant's, does not sign for the default tenant
+	deliver(body, "sha256="+service.SignConvertyWebhook("whsec-test", timestamp, []byte(body)), http.StatusUnauthorized)
+	deliver(body, "sha256="+service.SignConvertyWebhook(service.ConvertyWebhookSecret("whsec-test", "other"), timestamp, []byte(body)), http.StatusUnauthorized)
+	var served map[string]string
+	call(t, client, "GET", server.URL+"/api/v1/webhooks/converty/secret", "", http.StatusOK, &served)
+	if served["secret"] != secret {
+		t.Fatalf("unexpected tenant secret: %v", served)
+	}
+	// Without an event ID a delivery cannot be deduplicated
+	anonymous := `{"event": "order.updated", "data": {"id": "w-1"}}`
+	deliver(anonymous, sign(anonymous), http.StatusBadRequest)
+	first := deliver(body, sign(body), http.StatusOK)
+	if first["status"] != service.ConvertyEventProcessed {
+		t.Fatalf("unexpected receipt: %v", first)
+	}
+	var order service.Order
+	call(t, client, "GET", server.URL+"/api/v1/orders/w-1?mirror=true", "", http.StatusOK, &order)
+	if order.Status != "shipped" {
+		t.Fatalf("webhook not applied: %+v", order)
+	}
+	// Converty retrying the same delivery does not store it twice
+	if again := deliver(body, sign(body), http.StatusOK); again["id"] != first["id"] {
+		t.Fatalf("redelivery stored again: %v", again)
+	}
+
+	// A payload we cannot apply is kept, and replaying it fails until it can be
+	broken := `{"id": "evt-w2", "event": "order.updated", "data": {"status": "shipped"}}`
+	failed := deliver(broken, sign(broken), http.StatusOK)
+	if failed["status"] != service.ConvertyEventFailed {
+		t.Fatalf("unexpected receipt: %v", failed)
+	}
+	var replayed service.ConvertyEvent
+	call(t, client, "POST", fmt.Sprintf("%s/api/v1/webhooks/converty/events/%v/replay", server.URL, failed["id"]), "", http.StatusUnprocessableEntity, &replayed)
+	if replayed.Attempts != 2 || replayed.LastError == "" || string(replayed.Payload) != broken {
+		t.Fatalf("unexpected replay: %+v", replayed)
+	}
+	call(t, client, "POST", server.URL+"/api/v1/webhooks/converty/events/999999/replay", "", http.StatusNotFound, nil)
+
+	// Replaying an older event leaves the newer mirrored order alone
+	newer := time.Now().UTC().Add(-5 * time.Minute).Format(time.RFC3339)
+	delivered := `{"id": "evt-w3", "event": "order.updated", "data": {"id": "w-1", "status": "delivered", "total": 30, "currency": "tnd",
+		"created_at": "` + updated + `", "updated_at": "` + newer + `"}}`
+	deliver(delivered, sign(delivered), http.StatusOK)
+	call(t, client, "POST", fmt.Sprintf("%s/api/v1/webhooks/converty/events/%v/replay", server.URL, first["id"]), "", http.StatusOK, &replayed)
+	call(t, client, "GET", server.URL+"/api/v1/orders/w-1?mirror=true", "", http.StatusOK, &order)
+	if replayed.Status != service.ConvertyEventProcessed || order.Status != "delivered" {
+		t.Fatalf("replay overwrote a newer order: %+v, %+v", replayed, order)
+	}
+
+	var failures api.Envelope[service.ConvertyEvent]
+	call(t, client, "GET", server.URL+"/api/v1/webhooks/converty/events?status=failed", "", http.StatusOK, &failures)
+	if len(failures.Data) == 0 || failures.Data[0].EventID != "evt-w2" {
+		t.Fatalf("unexpected failed events: %+v", failures.Data)
+	}
+
+	// An order changed while no webhook arrived is found by the backfill
+	authorize(t, client, server.URL)
+	missed := time.Now().UTC().Add(-3 * time.Hour).Format(time.RFC3339)
+	fake.mu.Lock()
+	fake.orders = append([]map[string]interface{}{
+		{"id": "w-2", "status": "pending", "total": 12, "currency": "tnd", "created_at": missed, "updated_at": missed},
+	}, fake.orders...)
+	fake.mu.Unlock()
+	events := service.NewGormConvertyEventService(db)
+	dataService := service.NewGormDataService(db, service.DataServiceOptions{}).ForTenant(service.DefaultTenant)
+	now := time.Now()
+	result, err := events.Backfill(service.DefaultTenant.ID, dataService, service.ConvertyBackfillOptions{
+		Since: now.Add(-6 * time.Hour), Until: now, Gap: 30 * time.Minute, MaxPages: 5,
+	})
+	if err != nil || result.Stored != 1 || len(result.Gaps) == 0 {
+		t.Fatalf("backfill: %+v, %v", result, err)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/orders/w-2?mirror=true", "", http.StatusOK, &order)
+	again, err := events.Backfill(service.DefaultTenant.ID, dataService, service.ConvertyBackfillOptions{
+		Since: now.Add(-6 * time.Hour), Until: now, Gap: 30 * time.Minute, MaxPages: 5,
+	})
+	if err != nil || again.Stored != 0 {
+		t.Fatalf("second backfill stored again: %+v, %v", again, err)
+	}
+}
+
+func TestIntegrationDuplicateOrders(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	authorize(t, client, server.URL)
+	order := `{"customer": {"name": "Amira", "phone": "+216 74 000 000"}, "items": [{"product_id": "p-1", "quantity": 1}]}`
+
+	var created service.OrderCreation
+	call(t, client, "POST", server.URL+"/api/v1/orders", order, http.StatusCreated, &created)
+	if created.Order.ID == "" || created.Flagged {
+		t.Fatalf("unexpected creation: %+v", created)
+	}
+
+	// The integration server rejects repeats within an hour
+	var rejected struct {
+		Error      string   `json:"error"`
+		Duplicates []string `json:"duplicates"`
+	}
+	call(t, client, "POST", server.URL+"/api/v1/orders", order, http.StatusConflict, &rejected)
+	if rejected.Error != "duplicate_order" || len(rejected.Duplicates) != 1 || rejected.Duplicates[0] != created.Order.ID {
+		t.Fatalf("unexpected rejection: %+v", rejected)
+	}
+
+	// Another product is not a duplicate
+	call(t, client, "POST", server.URL+"/api/v1/orders",
+		`{"customer": {"phone": "+21674000000"}, "items": [{"product_id": "p-2", "quantity": 1}]}`, http.StatusCreated, nil)
+
+	// In flag mode the repeat is created and queued for review
+	call(t, adminClient(t), "PUT", server.URL+"/api/v1/admin/order-dedup", `{"mode": "flag", "window_minutes": 60}`, http.StatusOK, nil)
+	var flagged service.OrderCreation
+	call(t, client, "POST", server.URL+"/api/v1/orders", order, http.StatusCreated, &flagged)
+	if !flagged.Flagged || flagged.ReviewID == 0 || len(flagged.Duplicates) != 1 {
+		t.Fatalf("unexpected flagged creation: %+v", flagged)
+	}
+	var review service.Data
+	call(t, client, "GET", fmt.Sprintf("%s/api/v1/records/%d", server.URL, flagged.ReviewID), "", http.StatusOK, &review)
+	if review.Type != service.OrderReviewType || !strings.Contains(string(review.Details), created.Order.ID) {
+		t.Fatalf("unexpected review record: %+v", review)
+	}
+}
+
+func TestIntegrationRecordSchemas(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	admin := adminClient(t)
+
+	// Records of a type without a schema are stored as sent
+	var legacy service.Data
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"type": "issue", "details": {"description": "late"}}`, http.StatusCreated, &legacy)
+	if legacy.SchemaVersion != 0 {
+		t.Fatalf("unexpected schema version: %+v", legacy)
+	}
+
+	call(t, admin, "POST", server.URL+"/api/v1/admin/record-schemas",
+		`{"type": "issue", "schema": {"type": "object", "required": ["description"]}}`, http.StatusCreated, nil)
+	var v2 service.RecordSchema
+	call(t, admin, "POST", server.URL+"/api/v1/admin/record-schemas",
+		`{"type": "issue", "schema": {"type": "object", "required": ["description", "order_id"]}}`, http.StatusCreated, &v2)
+	if v2.Version != 2 || !v2.Active {
+		t.Fatalf("unexpected schema: %+v", v2)
+	}
+
+	var violation service.SchemaViolationError
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"type": "issue", "details": {"description": "late"}}`, http.StatusUnprocessableEntity, &violation)
+	if violation.Version != 2 || len(violation.Violations) != 1 {
+		t.Fatalf("unexpected violation: %+v", violation)
+	}
+	var created service.Data
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"type": "issue", "details": {"description": "late", "order_id": "o-1"}}`, http.StatusCreated, &created)
+	if created.SchemaVersion != 2 {
+		t.Fatalf("schema version not stamped: %+v", created)
+	}
+
+	// Rolling back to v1 accepts the old payload again
+	call(t, admin, "POST", server.URL+"/api/v1/admin/record-schemas/issue/1/activate", "", http.StatusOK, nil)
+	call(t, client, "POST", server.URL+"/api/v1/records", `{"type": "issue", "details": {"description": "late"}}`, http.StatusCreated, &created)
+	if created.SchemaVersion != 1 {
+		t.Fatalf("unexpected schema version after rollback: %+v", created)
+	}
+	var active service.RecordSchema
+	call(t, client, "GET", server.URL+"/api/v1/records/schemas/issue", "", http.StatusOK, &active)
+	if active.Version != 1 {
+		t.Fatalf("unexpected active schema: %+v", active)
+	}
+}
+
+func TestIntegrationUserLogin(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	admin, client := adminClient(t), integrationClient(t)
+	defer func() { userLoginRequired = false }()
+	userLoginRequired = true
+
+	var user service.User
+	call(t, admin, "POST", server.URL+"/api/v1/admin/users", `{"username": "short", "password": "123", "role": "viewer"}`, http.StatusUnprocessableEntity, nil)
+	call(t, admin, "POST", server.URL+"/api/v1/admin/users", `{"username": "ops", "password": "s3cret-pass", "role": "root"}`, http.StatusBadRequest, nil)
+	call(t, admin, "POST", server.URL+"/api/v1/admin/users", `{"username": "Ops.Viewer", "password": "s3cret-pass", "role": "viewer"}`, http.StatusCreated, &user)
+	if user.Username != "ops.viewer" || user.Tenant != service.DefaultTenant.Slug {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	call(t, admin, "POST", server.URL+"/api/v1/admin/users", `{"username": "ops.viewer", "password": "other-pass", "role": "viewer"}`, http.StatusUnprocessableEntity, nil)
+
+	// The records and orders routes now need an operator token
+	call(t, client, "GET", server.URL+"/api/v1/records", "", http.StatusUnauthorized, nil)
+	call(t, client, "POST", server.URL+"/api/v1/auth/login", `{"username": "ops.viewer", "password": "wrong-pass"}`, http.StatusUnauthorized, nil)
+	var tokens userTokens
+	call(t, client, "POST", server.URL+"/api/v1/auth/login", `{"username": "ops.viewer", "password": "s3cret-pass"}`, http.StatusOK, &tokens)
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.TokenType != "Bearer" {
+		t.Fatalf("unexpected tokens %+v", tokens)
+	}
+
+	bearer := func(method, path, token string, want int) {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: status %d, want %d", method, path, resp.StatusCode, want)
+		}
+	}
+	bearer("GET", "/api/v1/records", tokens.AccessToken, http.StatusOK)
+	bearer("GET", "/api/v1/auth/me", tokens.AccessToken, http.StatusOK)
+	// Viewers may not write
+	bearer("DELETE", "/api/v1/records/1", tokens.AccessToken, http.StatusForbidden)
+
+	// Refreshing rotates the refresh token
+	var refreshed userTokens
+	call(t, client, "POST", server.URL+"/api/v1/auth/refresh", `{"refresh_token": "`+tokens.RefreshToken+`"}`, http.StatusOK, &refreshed)
+	call(t, client, "POST", server.URL+"/api/v1/auth/refresh", `{"refresh_token": "`+tokens.RefreshToken+`"}`, http.StatusUnauthorized, nil)
+	bearer("GET", "/api/v1/records", tokens.AccessToken, http.StatusUnauthorized)
+	bearer("GET", "/api/v1/records", refreshed.AccessToken, http.StatusOK)
+
+	bearer("POST", "/api/v1/auth/logout", refreshed.AccessToken, http.StatusNoContent)
+	bearer("GET", "/api/v1/records", refreshed.AccessToken, http.StatusUnauthorized)
+	call(t, client, "POST", server.URL+"/api/v1/auth/refresh", `{"refresh_token": "`+refreshed.RefreshToken+`"}`, http.StatusUnauthorized, nil)
+
+	// Disabled users cannot log in
+	call(t, admin, "PATCH", server.URL+fmt.Sprintf("/api/v1/admin/users/%d", user.ID), `{"disabled": true}`, http.StatusOK, nil)
+	call(t, client, "POST", server.URL+"/api/v1/auth/login", `{"username": "ops.viewer", "password": "s3cret-pass"}`, http.StatusUnauthorized, nil)
+	call(t, admin, "DELETE", server.URL+fmt.Sprintf("/api/v1/admin/users/%d", user.ID), "", http.StatusNoContent, nil)
+}
+
+func TestIntegrationLoginThrottleAndSessionConsume(t *testing.T) {
+	startIntegrationServer(t)
+	throttle := service.NewGormLoginThrottle(db, time.Minute, time.Minute)
+	db.Where("1 = 1").Delete(&service.LoginFailure{})
+	for i := 0; i < 3; i++ {
+		if wait, err := throttle.Blocked("user:lock", "ip:lock"); err != nil || wait != 0 {
+			t.Fatalf("failure %d: blocked %v, %v", i, wait, err)
+		}
+		if err := throttle.Fail(service.LoginLimit{Key: "user:lock", Max: 3}, service.LoginLimit{Key: "ip:lock", Max: 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wait, err := throttle.Blocked("user:lock", "ip:lock"); err != nil || wait <= 0 || wait > time.Minute {
+		t.Fatalf("locked account: blocked %v, %v", wait, err)
+	}
+	if wait, err := throttle.Blocked("ip:lock"); err != nil || wait != 0 {
+		t.Fatalf("address under its limit: blocked %v, %v", wait, err)
+	}
+	if pruned, err := throttle.Prune(time.Now().Add(2 * time.Minute)); err != nil || pruned != 2 {
+		t.Fatalf("prune: %d, %v", pruned, err)
+	}
+
+	sessions := service.NewGormSessionService(db)
+	session, err := sessions.Create(service.UserSession{Kind: service.SessionUser, Subject: "consume", ExpiresAt: time.Now().Add(time.Hour)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var consumed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := sessions.Consume(session.ID, "refresh"); err == nil && got.Subject == "consume" {
+				consumed.Add(1)
+			} else if !errors.Is(err, service.ErrSessionInactive) {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if consumed.Load() != 1 {
+		t.Fatalf("the session was consumed %d times", consumed.Load())
+	}
+}
+
+func TestIntegrationRecordConditionalGET(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	var created service.Data
+	call(t, client, "POST", server.URL+"/api/v1/records",
+		`{"user_id": 7, "type": "issue", "status": "pending", "details": {"description": "colis en retard"}}`,
+		http.StatusCreated, &created)
+	recordURL := fmt.Sprintf("%s/api/v1/records/%d", server.URL, created.ID)
+
+	send := func(method, url, since string, want int) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, url, nil)
+		if since != "" {
+			req.Header.Set("If-Modified-Since", since)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s since %q: status %d, want %d: %s", method, url, since, resp.StatusCode, want, body)
+		}
+		if method == http.MethodHead && len(body) != 0 {
+			t.Fatalf("HEAD %s returned a body", url)
+		}
+		return resp
+	}
+	for _, url := range []string{recordURL, recordURL + "/history", server.URL + "/api/v1/records"} {
+		modified := send("GET", url, "", http.StatusOK).Header.Get("Last-Modified")
+		if modified == "" {
+			t.Fatalf("%s: no Last-Modified", url)
+		}
+		send("HEAD", url, "", http.StatusOK)
+		send("GET", url, modified, http.StatusNotModified)
+		send("HEAD", url, modified, http.StatusNotModified)
+	}
+
+	// HTTP dates have whole seconds: change the record in a later second
+	since := send("GET", recordURL, "", http.StatusOK).Header.Get("Last-Modified")
+	listSince := send("GET", server.URL+"/api/v1/records", "", http.StatusOK).Header.Get("Last-Modified")
+	time.Sleep(1100 * time.Millisecond)
+	call(t, client, "PUT", recordURL+"/status", `{"status": "in_progress"}`, http.StatusOK, nil)
+	send("GET", recordURL, since, http.StatusOK)
+	send("GET", recordURL+"/history", since, http.StatusOK)
+	send("GET", server.URL+"/api/v1/records", listSince, http.StatusOK)
+}
+
+func TestIntegrationConvertyErrors(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	authorize(t, client, server.URL)
+
+	var failure struct {
+		Error    string                `json:"error"`
+		Message  string                `json:"message"`
+		Converty service.ConvertyError `json:"converty"`
+	}
+	call(t, client, "GET", server.URL+"/api/v1/orders/o-missing", "", http.StatusNotFound, &failure)
+	if failure.Error != "converty_error" || failure.Converty.Status != http.StatusNotFound || failure.Converty.Message != "order not found" {
+		t.Fatalf("unexpected error response: %+v", failure)
+	}
+}
+
+func TestIntegrationRecordDedup(t *testing.T) {
+	startIntegrationServer(t)
+	details := map[string]interface{}{"issue": "Parcel never arrived", "order_id": "1001"}
+	retry := map[string]interface{}{"order_id": "1001", "issue": "parcel  never ARRIVED"}
+
+	merging := service.NewGormDataService(db, service.DataServiceOptions{
+		Dedup: service.RecordDedupSettings{Mode: service.RecordDedupMerge, Window: 10 * time.Minute},
+	}).ForTenant(service.DefaultTenant)
+	first, err := merging.InsertRecord(5, "delivery", details, "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := merging.InsertRecord(5, "delivery", retry, "pending")
+	if err != nil || merged.ID != first.ID || !merged.Duplicate {
+		t.Fatalf("retry not merged: %+v, %v", merged, err)
+	}
+	if other, err := merging.InsertRecord(6, "delivery", retry, "pending"); err != nil || other.ID == first.ID {
+		t.Fatalf("another user's record merged: %+v, %v", other, err)
+	}
+
+	rejecting := service.NewGormDataService(db, service.DataServiceOptions{
+		Dedup: service.RecordDedupSettings{Mode: service.RecordDedupReject, Window: 10 * time.Minute},
+	}).ForTenant(service.DefaultTenant)
+	var duplicate *service.DuplicateRecordError
+	if _, err := rejecting.InsertRecord(5, "delivery", retry, "pending"); !errors.As(err, &duplicate) || duplicate.Existing.ID != first.ID {
+		t.Fatalf("retry not rejected: %v", err)
+	}
+
+	// Records older than the window are not duplicates
+	db.Model(&service.Data{}).Where("id = ?", first.ID).Update("created_at", time.Now().Add(-time.Hour))
+	if again, err := rejecting.InsertRecord(5, "delivery", retry, "pending"); err != nil || again.ID == first.ID {
+		t.Fatalf("record outside the window: %+v, %v", again, err)
+	}
+}
+
+func TestIntegrationDailyDigest(t *testing.T) {
+	server, _ := startIntegrationServer(t)
+	client := integrationClient(t)
+	authorize(t, client, server.URL)
+
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	date := yesterday.Format(service.DigestDateLayout)
+	dataService := service.NewGormDataService(db, service.DataServiceOptions{}).ForTenant(service.DefaultTenant)
+	issue, err := dataService.InsertRecord(1, "issue", map[string]interface{}{"description": "broken lid"}, "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := dataService.InsertRecord(2, "issue", map[string]interface{}{"description": "late parcel"}, "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&service.Data{}).Where("id = ?", issue.ID).Update("created_at", yesterday)
+	db.Model(&service.Data{}).Where("id = ?", stale.ID).Update("created_at", now.Add(-72*time.Hour))
+	for i, total := range []float64{40, 60} {
+		if err := db.Create(&service.OrderRecord{OrderID: fmt.Sprintf("d-%d", i), Total: total, Currency: "TND", OrderedAt: yesterday}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digests := service.NewGormDailyDigestService(db, time.Local)
+	if claimed, err := digests.ClaimDigest(0, date); err != nil || !claimed {
+		t.Fatalf("first claim: %v, %v", claimed, err)
+	}
+	if claimed, _ := digests.ClaimDigest(0, date); claimed {
+		t.Fatal("digest claimed twice")
+	}
+	call(t, client, "GET", server.URL+"/api/v1/reports/daily/"+date, "", http.StatusNotFound, nil)
+
+	tokens, err := digestTokens(0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := digests.CompileDigest(0, date, tokens, now); err != nil {
+		t.Fatal(err)
+	}
+	var digest service.DailyDigest
+	call(t, client, "GET", server.URL+"/api/v1/reports/daily/"+date, "", http.StatusOK, &digest)
+	if digest.NewOrders != 2 || digest.OrderTotals["TND"] != 100 || digest.NewIssues != 1 || digest.UnresolvedIssues != 1 ||
+		len(digest.StaleIssues) != 1 || digest.StaleIssues[0].ID != stale.ID {
+		t.Fatalf("unexpected digest %+v", digest)
+	}
+	if len(digest.Tokens) != 1 || digest.TokenHealth != service.TokenHealthy {
+		t.Fatalf("unexpected token health %+v", digest.Tokens)
+	}
+	call(t, client, "GET", server.URL+"/api/v1/reports/daily/yesterday", "", http.StatusUnprocessableEntity, nil)
+}
+
+func TestIntegrationRecommendations(t *testing.T) {
+	startIntegrationServer(t)
+	now := time.Now()
+	orders := []struct {
+		id, phone, status string
+		lines             []service.OrderItemRecord
+	}{
+		{"r-1", "+216 20 000 001", "delivered", []service.OrderItemRecord{{ProductID: "blender", Category: "Kitchen", Quantity: 1}}},
+		{"r-2", "+21620000001", "cancelled", []service.OrderItemRecord{{ProductID: "lamp", Category: "Lighting", Quantity: 3}}},
+		{"r-3", "+21620000002", "delivered", []service.OrderItemRecord{{ProductID: "bulb", Category: "Lighting", Quantity: 5}}},
+	}
+	for _, order := range orders {
+		record := service.OrderRecord{OrderID: order.id, Customer: service.Customer{Phone: order.phone}, Status: order.status, OrderedAt: now}
+		if err := db.Create(&record).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range order.lines {
+			line.OrderID = order.id
+			if err := db.Create(&line).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	recommendations := service.NewGormRecommendationService(db, nil)
+	profile, err := recommendations.Profile(0, "+21620000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Orders != 1 || profile.Categories["kitchen"] != 1 || profile.Popularity["bulb"] != 5 || profile.Popularity["lamp"] != 0 {
+		t.Fatalf("unexpected profile %+v", profile)
+	}
+	catalogue := []service.Product{
+		{ID: "mixer", Category: "kitchen", Price: 120, Stock: 1},
+		{ID: "bulb", Category: "Lighting", Price: 5, Stock: 10},
+	}
+	ranked, _, err := recommendations.Recommend(0, "+21620000001", catalogue, "", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranked) != 2 || ranked[0].Product.ID != "mixer" || ranked[1].Product.ID != "bulb" {
+		t.Fatalf("unexpected ranking %+v", ranked)
+	}
+}
+
+func TestIntegrationMirrorCustomers(t *testing.T) {
+	startIntegrationServer(t)
+	now := time.Now()
+	for i, order := range []service.OrderRecord{
+		{OrderID: "c-1", Customer: service.Customer{Name: "Old Name", Phone: "+216 22 000 001"}, Status: "delivered", OrderedAt: now.Add(-2 * time.Hour)},
+		{OrderID: "c-2", Customer: service.Customer{Name: "Salma Trabelsi", Phone: "+21622000001", City: "Tunis"}, Status: "pending", OrderedAt: now.Add(-time.Hour)},
+		{OrderID: "c-3", Customer: service.Customer{Name: "Karim", Phone: "+21622000002"}, Status: "pending", OrderedAt: now},
+	} {
+		if err := db.Create(&order).Error; err != nil {
+			t.Fatal(err)
+		}
+		line := service.OrderItemRecord{OrderID: order.OrderID, ProductID: fmt.Sprintf("p-%d", i), Quantity: 1}
+		if err := db.Create(&line).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mirror := service.NewGormOrderMirrorService(db)
+	customers, err := mirror.ListCustomers(0, service.CustomerQuery{Phones: []string{"+216 22 000 001"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(customers) != 1 || customers[0].Name != "Salma Trabelsi" || customers[0].Orders != 2 || customers[0].City != "Tunis" {
+		t.Fatalf("unexpected customers %+v", customers)
+	}
+	byPhone, err := mirror.CustomerOrders(0, []string{"+21622000001", "+21622000002"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := byPhone["+21622000001"]; len(got) != 1 || got[0].ID != "c-2" || len(got[0].Items) != 1 {
+		t.Fatalf("unexpected orders %+v", got)
+	}
+	if got := byPhone["+21622000002"]; len(got) != 1 || got[0].ID != "c-3" {
+		t.Fatalf("unexpected orders %+v", got)
+	}
+}
+
+func TestIntegrationStateArchive(t *testing.T) {
+	startIntegrationServer(t)
+	tenant, _, err := service.NewGormTenantService(db).CreateTenant("clone-shop", "Clone Shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataService := service.NewGormDataService(db, service.DataServiceOptions{}).ForTenant(tenant)
+	record, err := dataService.InsertRecord(7, "issue", map[string]interface{}{"description": "parcel lost"}, service.StatusPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dataService.UpdateRecordStatus(record.ID, service.StatusInProgress, "agent"); err != nil {
+		t.Fatal(err)
+	}
+	token := TokenInfo{UserID: "tenant:clone-shop", TenantID: tenant.ID, AccessToken: "clone-access", RefreshToken: "clone-refresh",
+		IssuedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour), RefreshIssuedAt: time.Now(), RefreshExpiresAt: time.Now().Add(24 * time.Hour)}
+	if err := db.Create(&token).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tables := append(service.StateTables(), tokenStateTable)
+	var archive bytes.Buffer
+	exported, err := service.ExportState(db, &archive, tables, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported["interactions"] == 0 || exported["status_history"] == 0 || exported["tokens"] == 0 {
+		t.Fatalf("unexpected export counts %v", exported)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(zr); err != nil || bytes.Contains(body, []byte("parcel lost")) || bytes.Contains(body, []byte("clone-refresh")) {
+		t.Fatalf("the encrypted archive shows its rows in clear: %v", err)
+	}
+
+	if _, err := service.ImportState(db, bytes.NewReader(archive.Bytes()), tables, "correct horse", false); !errors.Is(err, service.ErrValidation) {
+		t.Fatalf("import over existing rows: got %v, want a validation error", err)
+	}
+	if _, err := service.ImportState(db, bytes.NewReader(archive.Bytes()), tables, "wrong", true); !errors.Is(err, service.ErrStatePassphrase) {
+		t.Fatalf("import with the wrong passphrase: got %v, want ErrStatePassphrase", err)
+	}
+	imported, err := service.ImportState(db, bytes.NewReader(archive.Bytes()), tables, "correct horse", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, count := range exported {
+		if imported.Rows[name] != count {
+			t.Errorf("%s: imported %d rows, exported %d", name, imported.Rows[name], count)
+		}
+	}
+	if len(imported.TenantKeys) != 0 {
+		t.Errorf("existing tenants should be matched by slug, got new keys for %v", imported.TenantKeys)
+	}
+
+	restored, err := dataService.QueryByID(record.ID)
+	if err != nil || restored.Status != service.StatusInProgress || !strings.Contains(string(restored.Details), "parcel lost") {
+		t.Fatalf("restored record %+v, %v", restored, err)
+	}
+	var contentHash string
+	if err := db.Raw("SELECT content_hash FROM interactions WHERE id = ?", record.ID).Scan(&contentHash).Error; err != nil || contentHash == "" {
+		t.Fatalf("the restored record has no content hash: %v", err)
+	}
+	var restoredToken TokenInfo
+	if err := db.Where("user_id = ?", "tenant:clone-shop").First(&restoredToken).Error; err != nil || restoredToken.RefreshToken != "clone-refresh" {
+		t.Fatalf("restored token %+v, %v", restoredToken, err)
+	}
+	next, err := dataService.InsertRecord(7, "issue", map[string]interface{}{"description": "after import"}, service.StatusPending)
+	if err != nil || next.ID <= record.ID {
+		t.Fatalf("a record inserted after the import got id %d, %v", next.ID, err)
+	}
+}
+
+func TestIntegrationMirrorOrdersByCategory(t *testing.T) {
+	startIntegrationServer(t)
+	tenant, _, err := service.NewGormTenantService(db).CreateTenant("category-shop", "Category Shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, category := range []string{"Kitchen", "Lighting", "kitchen", "Lighting", "cat-kitchen"} {
+		order := service.OrderRecord{TenantID: tenant.ID, OrderID: fmt.Sprintf("k-%d", i), Status: "pending", OrderedAt: now.Add(time.Duration(-i) * time.Minute)}
+		if err := db.Create(&order).Error; err != nil {
+			t.Fatal(err)
+		}
+		line := service.OrderItemRecord{TenantID: tenant.ID, OrderID: order.OrderID, ProductID: fmt.Sprintf("p-%d", i), Category: category, Quantity: 1}
+		if err := db.Create(&line).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The category is matched before paging, so every page is full until the last
+	mirror := service.NewGormOrderMirrorService(db)
+	kitchen := &service.Category{ExternalID: "cat-kitchen", Name: "Kitchen", Slug: "kitchen"}
+	var ids []string
+	for page := 1; page <= 2; page++ {
+		orders, err := mirror.ListOrders(tenant.ID, service.CustomerOrderQuery{Page: page, Limit: 2, Category: kitchen})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, order := range orders {
+			ids = append(ids, order.ID)
+		}
+	}
+	if strings.Join(ids, ",") != "k-0,k-2,k-4" {
+		t.Fatalf("orders in the category: %v", ids)
+	}
+}
+
+func TestIntegrationMirrorOrdersBetween(t *testing.T) {
+	startIntegrationServer(t)
+	tenant, _, err := service.NewGormTenantService(db).CreateTenant("quarter-shop", "Quarter Shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	for i, placed := range []time.Time{from.Add(-time.Second), from, from.AddDate(0, 2, 0), from.AddDate(0, 3, 0)} {
+		order := service.OrderRecord{TenantID: tenant.ID, OrderID: fmt.Sprintf("q-%d", i), Status: "delivered", OrderedAt: placed}
+		if err := db.Create(&order).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	orders, err := service.NewGormOrderMirrorService(db).OrdersBetween(tenant.ID, from, from.AddDate(0, 3, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 || orders[0].ID != "q-1" || orders[1].ID != "q-2" {
+		t.Fatalf("orders of the quarter: %+v", orders)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// bulkStatusResponse reports a bulk status change record by record
+type bulkStatusResponse struct {
+	// Applied is false when a record failed and the whole batch was rolled back
+	Applied bool                       `json:"applied"`
+	Status  string                     `json:"status"`
+	Updated int                        `json:"updated"`
+	Failed  int                        `json:"failed"`
+	Error   string                     `json:"error,omitempty"`
+	Results []service.BulkStatusResult `json:"results"`
+}
+
+// registerIssueRoutes mounts the operations on many issue records at once
+func registerIssueRoutes(r chi.Router, dataService service.DataService) {
+	// {"ids": [12, 15, 18], "status": "completed", "actor": "agent-7"} resolves a batch of duplicate
+	// complaints. Either every issue moves or none does: 409 lists the ones that could not.
+	r.Post("/api/v1/issues/bulk-status", func(w http.ResponseWriter, r *http.Request) {
+		var input bulkIssueStatusRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		actor := input.Actor
+		if actor == "" {
+			actor = requestActor(r)
+		}
+		results, err := tenantData(r, dataService).BulkUpdateStatus(input.IDs, "issue", input.Status, actor)
+		if err != nil && !errors.Is(err, service.ErrBulkRolledBack) {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		response := bulkStatusResponse{Applied: err == nil, Status: input.Status, Results: results}
+		for _, result := range results {
+			switch result.Result {
+			case service.BulkStatusUpdated:
+				response.Updated++
+			case service.BulkStatusFailed:
+				response.Failed++
+			}
+		}
+		if err != nil {
+			response.Error = err.Error()
+			writeJSON(w, r, http.StatusConflict, response)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, response)
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// loyaltyAccrualJobType is the job queue type of the delivered-order points accrual
+const loyaltyAccrualJobType = "accrue_loyalty_points"
+
+// loyaltyAccrualMaxPages bounds how many Converty pages one accrual run walks
+const loyaltyAccrualMaxPages = 20
+
+// loadLoyaltyRules reads the LOYALTY_* settings on top of the default rules
+func loadLoyaltyRules() (service.LoyaltyRules, error) {
+	rules := service.DefaultLoyaltyRules
+	floats := map[string]*float64{
+		"LOYALTY_POINTS_PER_UNIT": &rules.PointsPerUnit,
+		"LOYALTY_POINT_VALUE":     &rules.PointValue,
+		"LOYALTY_MIN_ORDER_TOTAL": &rules.MinOrderTotal,
+	}
+	for name, target := range floats {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed < 0 {
+				return rules, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = parsed
+		}
+	}
+	ints := map[string]*int{
+		"LOYALTY_MAX_POINTS_PER_ORDER": &rules.MaxPointsPerOrder,
+		"LOYALTY_MAX_ACCRUALS_PER_DAY": &rules.MaxAccrualsPerDay,
+		"LOYALTY_MIN_REDEEM_POINTS":    &rules.MinRedeemPoints,
+	}
+	for name, target := range ints {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return rules, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = parsed
+		}
+	}
+	if value := os.Getenv("LOYALTY_CATEGORY_RATES"); value != "" {
+		rates, err := service.ParseCategoryRates(value)
+		if err != nil {
+			return rules, err
+		}
+		rules.CategoryRates = rates
+	}
+	rules.RedeemCooldown = durationEnv("LOYALTY_REDEEM_COOLDOWN", rules.RedeemCooldown)
+	return rules, nil
+}
+
+// registerLoyaltyJob registers the handler that rewards the delivered orders of each tenant
+func registerLoyaltyJob(jobService service.JobService, dataService service.DataService, tenantService service.TenantService, loyaltyService service.LoyaltyService) {
+	jobService.RegisterHandler(loyaltyAccrualJobType, func(payload json.RawMessage) (interface{}, error) {
+		tenants, err := jobTenants(tenantService, payload)
+		if err != nil {
+			return nil, err
+		}
+		rewarded := make(map[string]int)
+		for _, tenant := range tenants {
+			accrued, err := loyaltyService.AccrueDelivered(tenant.ID, dataService.ForTenant(tenant), loyaltyAccrualMaxPages)
+			if err != nil {
+				log.Printf("Loyalty accrual for tenant %s failed: %v", tenant.Slug, err)
+				continue
+			}
+			log.Printf("Loyalty accrual rewarded %d delivered orders of tenant %s", accrued, tenant.Slug)
+			rewarded[tenant.Slug] = accrued
+		}
+		return rewarded, nil
+	})
+}
+
+// registerLoyaltyRoutes mounts the balance and redemption endpoints used by the chatbot
+func registerLoyaltyRoutes(r, upstream chi.Router, dataService service.DataService, loyaltyService service.LoyaltyService) {
+	r.Get("/api/v1/loyalty/{phone}", func(w http.ResponseWriter, r *http.Request) {
+		balance, err := loyaltyService.Balance(tenantFrom(r).ID, chi.URLParam(r, "phone"), r.URL.Query().Get("entries") == "true")
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, balance)
+	})
+
+	// Redemptions spend a customer's points, so only the tenant's own callers may make them
+	r.Post("/api/v1/loyalty/{phone}/redeem", func(w http.ResponseWriter, r *http.Request) {
+		if !hasCredentials(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="convertyapi"`)
+			writeError(w, "Redeeming points requires an API key or an operator login", http.StatusUnauthorized)
+			return
+		}
+		var input loyaltyRedeemRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		balance, wallet, err := loyaltyService.Redeem(tenantFrom(r).ID, chi.URLParam(r, "phone"), input.Points)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"loyalty": balance,
+			"wallet":  wallet,
+		})
+	})
+
+	// Rewards one order now instead of waiting for the scheduled accrual
+	upstream.Post("/api/v1/orders/{id}/loyalty", func(w http.ResponseWriter, r *http.Request) {
+		order, err := tenantData(r, dataService).GetOrder(chi.URLParam(r, "id"))
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		entry, err := loyaltyService.AccrueOrder(tenantFrom(r).ID, order)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, entry)
+	})
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"convertyApi/api"
 	"convertyApi/console"
+	"convertyApi/grpcapi"
 	"convertyApi/service"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -11,12 +15,14 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/joho/godotenv"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
@@ -24,13 +30,14 @@ const (
 	redirectURI = "https://convertyapi.serveo.net/api/v1/callback"
 	authURL     = "https://partner.converty.shop/oauth2/authorize"
 	tokenURL    = "https://partner.converty.shop/oauth2/token"
-	scope       = "read-products create-orders update-orders read-orders"
 )
 
 var (
 	clientID     = os.Getenv("CLIENT_ID")
 	clientSecret = os.Getenv("CLIENT_SECRET")
 	db           *gorm.DB
+	// converty is the Converty API version chosen by CONVERTY_API_VERSION
+	converty, _ = service.NewConvertyClient("v1", service.DefaultConvertyURL)
 )
 
 // TokenResponse matches converty.shop's token response
@@ -39,12 +46,20 @@ type TokenResponse struct {
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int    `json:"expires_in"`
 	TokenType    string `json:"token_type"`
+	// RefreshExpiresIn is optional; when absent REFRESH_TOKEN_TTL is used
+	RefreshExpiresIn int `json:"refresh_expires_in"`
+	// StoreID is optional; when present the store gets its own token and session
+	StoreID string `json:"store_id"`
+	// Scope lists the granted scopes when the provider reports them
+	Scope string `json:"scope"`
 }
 
 // TokenInfo stores token metadata in the database
 type TokenInfo struct {
 	gorm.Model
 	UserID           string    `gorm:"uniqueIndex;column:user_id"`
+	TenantID         uint      `gorm:"not null;default:0;index;column:tenant_id"`
+	StoreID          string    `gorm:"column:store_id"`
 	AccessToken      string    `gorm:"not null"`
 	RefreshToken     string    `gorm:"not null"`
 	TokenType        string    `gorm:"column:token_type"`
@@ -53,6 +68,13 @@ type TokenInfo struct {
 	ExpiresAt        time.Time `gorm:"not null;column:expires_at"`
 	RefreshIssuedAt  time.Time `gorm:"not null;column:refresh_issued_at"`
 	RefreshExpiresAt time.Time `gorm:"not null;column:refresh_expires_at"`
+	// Invalid is set when the refresh token can no longer be used and the operator must log in again
+	Invalid       bool       `gorm:"not null;default:false;column:invalid"`
+	InvalidatedAt *time.Time `gorm:"column:invalidated_at"`
+	InvalidReason string     `gorm:"column:invalid_reason"`
+	// Scopes the token was granted; MissingScopes were refused by Converty on use
+	Scopes        string `gorm:"column:scopes"`
+	MissingScopes string `gorm:"column:missing_scopes"`
 }
 
 // TableName specifies the table name for TokenInfo
@@ -63,46 +85,215 @@ func (TokenInfo) TableName() string {
 // HealthResponse for the /health endpoint
 type HealthResponse struct {
 	Status string `json:"status"`
+	// Database explains why the server runs in degraded mode
+	Database string `json:"database,omitempty"`
 }
 
 func initDB() {
-	err := godotenv.Load()
+	if err := loadEnv(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	var err error
+	db, err = openDB()
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	pool, err := loadDBPool()
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		log.Fatalf("Invalid database pool configuration: %v", err)
+	}
+	if err := configureDB(db, pool); err != nil {
+		log.Fatalf("Failed to configure database: %v", err)
 	}
+}
 
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
+// schemaModels are the tables migrateDB creates, in migration order
+var schemaModels = []interface{}{
+	&TokenInfo{}, &ReauthLink{}, &OAuthAttempt{}, &service.Job{}, &service.Tenant{},
+	&service.Data{}, &service.LegalHold{}, &service.StatusChange{}, &service.PaymentLink{},
+	&service.AbandonedCart{}, &service.WalletEntry{}, &service.LoyaltyEntry{}, &service.LoyaltyScanCursor{}, &service.LoyaltyDeferral{}, &service.Category{},
+	&service.ProductStock{}, &service.WaitlistEntry{}, &service.OrderStatusChange{}, &service.UserSession{},
+	&service.AdminTOTP{}, &service.Attachment{},
+	&service.ServiceAccount{}, &service.ServiceAccountKey{}, &service.ClassificationRule{},
+	&service.CustomerMerge{}, &service.CustomerMergeChange{}, &service.StatusIncident{},
+	&service.ReportSchedule{}, &service.OrderDedupSettings{}, &service.RecordSchema{}, &service.AuditEntry{},
+	&service.OrderRecord{}, &service.OrderItemRecord{}, &service.OrderSyncState{}, &service.OutboxEvent{},
+	&service.Conversation{}, &service.ConversationMessage{}, &service.ProductAlert{},
+	&service.WebhookEndpoint{}, &service.WebhookDelivery{},
+	&service.Tag{}, &service.RecordTag{}, &service.SavedFilter{}, &service.Address{},
+	&service.Reservation{}, &service.WarehouseExportState{}, &service.ConvertyEvent{},
+	&service.User{}, &service.LoginFailure{}, &service.DailyDigest{}, &service.FeatureFlag{}, &service.Consent{}, &service.ConsentChange{},
+}
 
-	if dbHost == "" || dbPort == "" || dbUser == "" || dbPassword == "" || dbName == "" {
-		log.Fatal("Database configuration not set in .env file")
+// migrateDB creates or updates the tables once the database is reachable
+func migrateDB() error {
+	if err := db.Exec("CREATE SCHEMA IF NOT EXISTS chatbot").Error; err != nil {
+		return fmt.Errorf("failed to create chatbot schema: %v", err)
+	}
+	if err := db.AutoMigrate(schemaModels...); err != nil {
+		return fmt.Errorf("failed to auto-migrate schema: %v", err)
 	}
+	if err := service.EnsureRecordSearchIndex(db); err != nil {
+		return err
+	}
+	if err := service.EnsureAbandonedCartIndex(db); err != nil {
+		return err
+	}
+	if err := service.EnsureRecordUpdatedAt(db); err != nil {
+		return err
+	}
+	if err := service.EnsureMergePatchFunction(db); err != nil {
+		return err
+	}
+	log.Println("Auto-migrated public and chatbot schema tables")
+	return nil
+}
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
-	log.Printf("Connecting to database with DSN: %s", dsn)
+// serverServices bundles the services the HTTP server depends on
+type serverServices struct {
+	Data            service.DataService
+	Jobs            service.JobService
+	LegalHold       service.LegalHoldService
+	Payments        service.PaymentService
+	Carts           service.AbandonedCartService
+	Wallets         service.WalletService
+	Loyalty         service.LoyaltyService
+	Tenants         service.TenantService
+	Categories      service.CategoryService
+	Waitlist        service.WaitlistService
+	ETA             service.ETAService
+	Dashboard       *service.Dashboard
+	Tracking        service.TrackingService
+	Sessions        service.SessionService
+	TOTP            service.TOTPService
+	Attachments     service.AttachmentService
+	ServiceAccounts service.ServiceAccountService
+	Rules           service.RuleService
+	Merges          service.CustomerMergeService
+	Status          service.StatusService
+	ReportSchedules service.ReportScheduleService
+	Orders          service.OrderService
+	Schemas         service.RecordSchemaService
+	Audit           service.AuditService
+	OrderReports    service.OrderReportService
+	OrderMirror     service.OrderMirrorService
+	Outbox          service.OutboxService
+	Conversations   service.ConversationService
+	Alerts          service.ProductAlertService
+	Retention       service.RetentionService
+	Webhooks        service.WebhookService
+	Tags            service.TagService
+	Addresses       service.AddressService
+	Reservations    service.ReservationService
+	ConvertyEvents  service.ConvertyEventService
+	Users           service.UserService
+	LoginThrottle   service.LoginThrottle
+	DailyDigests    service.DailyDigestService
+	// Warehouse is nil unless WAREHOUSE_EXPORT_STORAGE is set
+	Warehouse service.WarehouseExportService
+	// Flags switches route groups per tenant; without it every route is served
+	Flags service.FeatureFlagService
+	// Consents is nil when the routes are not served, e.g. in tests
+	Consents service.ConsentService
+	// Recommendations is nil when the routes are not served
+	Recommendations service.RecommendationService
+}
 
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+// loginHandler redirects to the Converty authorization page
+func loginHandler(tenantService service.TenantService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := url.Values{}
+		params.Add("client_id", clientID)
+		params.Add("redirect_uri", redirectURI)
+		params.Add("response_type", "code")
+		// Remember-me: /login?remember=true keeps the session across browser restarts
+		attempt := OAuthAttempt{Remember: r.URL.Query().Get("remember") == "true"}
+		tokenUserID := tokenUserFor(r)
+		// Tenant authorization: /login?tenant=<slug>
+		if slug := r.URL.Query().Get("tenant"); slug != "" {
+			tenant, err := tenantService.GetTenant(slug)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			attempt.TenantSlug = slug
+			tokenUserID = tenant.TokenUserID
+		}
+		// Incremental authorization: /login?scopes=read-customers adds to the scopes already granted
+		scopes, err := requestedScopes(r, tokenUserID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		attempt.Scopes = strings.Join(scopes, " ")
+		params.Add("scope", attempt.Scopes)
+		// One-time re-authentication link: /login?user=...&nonce=...
+		if user := r.URL.Query().Get("user"); user != "" {
+			link, ok := findReauthLink(user, r.URL.Query().Get("nonce"))
+			if !ok {
+				writeError(w, "Invalid or expired re-authentication link", http.StatusBadRequest)
+				return
+			}
+			attempt.TenantSlug, attempt.ReauthNonce = "", link.Nonce
+		}
+		// The state must be a stored attempt: without the database the login cannot be protected
+		attempt, err = startOAuthAttempt(attempt)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		params.Add("state", attempt.State)
+		authURLWithParams := fmt.Sprintf("%s?%s", authURL, params.Encode())
+		http.Redirect(w, r, authURLWithParams, http.StatusFound)
 	}
+}
 
-	if err := db.AutoMigrate(&TokenInfo{}, &service.Data{}); err != nil {
-		log.Printf("Warning: Failed to auto-migrate schema: %v", err)
-	} else {
-		log.Println("Auto-migrated schema for public.token_infos and chatbot.interactions")
+// startServer serves the HTTP API on serverAddr until SIGINT or SIGTERM, then drains the open
+// requests and stops the job workers once their running jobs finish
+func startServer(services serverServices) {
+	server := &http.Server{Addr: serverAddr, Handler: newRouter(services)}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), durationEnv("SHUTDOWN_TIMEOUT", 30*time.Second))
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Failed to drain open requests: %v", err)
+		}
+	}()
+	log.Println("Server starting on ", serverAddr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Server failed to start: %v", err)
 	}
-
-	log.Println("Database connection established successfully")
+	services.Jobs.Stop()
 }
 
-func startServer(dataService service.DataService) {
+// newRouter builds the HTTP API around services
+func newRouter(services serverServices) http.Handler {
+	dataService, jobService, legalHoldService := services.Data, services.Jobs, services.LegalHold
+	paymentService, cartService, walletService := services.Payments, services.Carts, services.Wallets
+	loyaltyService, tenantService, categoryService := services.Loyalty, services.Tenants, services.Categories
+	waitlistService, etaService, sessionService := services.Waitlist, services.ETA, services.Sessions
+
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(traceRequests)
+	r.Use(accessLog)
 	r.Use(middleware.Recoverer)
+	r.Use(compressResponses())
+
+	// Per-route timeouts: upstream-backed routes fail fast instead of waiting on the full client timeout
+	defaultRouteTimeout := durationEnv("ROUTE_TIMEOUT", 15*time.Second)
+	upstreamRouteTimeout := durationEnv("UPSTREAM_ROUTE_TIMEOUT", 5*time.Second)
+	r.Use(routeTimeout(defaultRouteTimeout))
+	r.Use(resolveUser(tenantService, sessionService))
+	r.Use(resolveTenant(tenantService, services.ServiceAccounts))
+	r.Use(requireUser)
+	r.Use(loadSession(sessionService))
+	r.Use(limitBodies(int64(intEnv("MAX_BODY_BYTES", defaultMaxBodyBytes))))
+	// Upstream-backed routes also refresh a Converty token about to expire before calling out
+	upstream := r.With(routeTimeout(upstreamRouteTimeout), freshToken)
 
 	// Health endpoint
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -115,16 +306,23 @@ func startServer(dataService service.DataService) {
 		}
 	})
 
+	// Readiness: the database answers, with the connection pool and query counters
+	r.Get("/readyz", readinessHandler(db))
+
+	// Public status page; incidents are posted through the admin API
+	statusPageCache := &statusCache{}
+	registerStatusRoutes(r, services.Status, statusPageCache)
+
 	// Login endpoint
-	r.Get("/login", func(w http.ResponseWriter, r *http.Request) {
-		params := url.Values{}
-		params.Add("client_id", clientID)
-		params.Add("redirect_uri", redirectURI)
-		params.Add("response_type", "code")
-		params.Add("scope", scope)
-		params.Add("state", "xyz123")
-		authURLWithParams := fmt.Sprintf("%s?%s", authURL, params.Encode())
-		http.Redirect(w, r, authURLWithParams, http.StatusFound)
+	r.Get("/login", loginHandler(tenantService))
+
+	// Operator login with username and password, issuing bearer tokens for the console and dashboard
+	registerUserRoutes(r, services.Users, sessionService, services.LoginThrottle)
+
+	// Logout ends the browser session; the stored Converty authorization is kept
+	r.Post("/logout", func(w http.ResponseWriter, r *http.Request) {
+		endSession(w, r, sessionService, sessionCookieName)
+		w.WriteHeader(http.StatusNoContent)
 	})
 
 	// Callback endpoint
@@ -132,10 +330,38 @@ func startServer(dataService service.DataService) {
 		code := r.URL.Query().Get("code")
 		state := r.URL.Query().Get("state")
 
-		if state != "xyz123" {
-			writeError(w, fmt.Sprintf("Invalid state parameter: received=%s, expected=xyz123", state), http.StatusBadRequest)
+		attempt, found, err := consumeOAuthAttempt(state)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			writeError(w, "Invalid or expired state parameter", http.StatusBadRequest)
 			return
 		}
+		tenant := service.DefaultTenant
+		if attempt.TenantSlug != "" {
+			resolved, err := tenantService.GetTenant(attempt.TenantSlug)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			tenant = resolved
+		}
+		var userID string
+		if attempt.ReauthNonce != "" {
+			linkUser, ok := consumeReauthLink(attempt.ReauthNonce)
+			if !ok {
+				writeError(w, fmt.Sprintf("Invalid or expired state parameter: %s", state), http.StatusBadRequest)
+				return
+			}
+			userID = linkUser
+			if slug, ok := strings.CutPrefix(linkUser, "tenant:"); ok {
+				if resolved, err := tenantService.GetTenant(slug); err == nil {
+					tenant = resolved
+				}
+			}
+		}
 		if code == "" {
 			writeError(w, "No authorization code received", http.StatusBadRequest)
 			return
@@ -145,10 +371,10 @@ func startServer(dataService service.DataService) {
 		data.Set("grant_type", "authorization_code")
 		data.Set("code", code)
 		data.Set("client_id", clientID)
-		data.Set("client_secret", clientSecret)
+		data.Set("client_secret", oauthClientSecret())
 		data.Set("redirect_uri", redirectURI)
 
-		client := &http.Client{}
+		client := service.NewUpstreamClient(0)
 		resp, err := client.PostForm(tokenURL, data)
 		if err != nil {
 			writeError(w, fmt.Sprintf("Failed to exchange code: %v", err), http.StatusInternalServerError)
@@ -168,24 +394,34 @@ func startServer(dataService service.DataService) {
 			return
 		}
 
-		issuedAt := time.Now()
-		expiresAt := issuedAt.Add(time.Second * time.Duration(tokenResp.ExpiresIn))
-		tokenInfo := &TokenInfo{
-			UserID:           "user1",
-			AccessToken:      tokenResp.AccessToken,
-			RefreshToken:     tokenResp.RefreshToken,
-			TokenType:        tokenResp.TokenType,
-			ExpiresIn:        int64(tokenResp.ExpiresIn),
-			IssuedAt:         issuedAt,
-			ExpiresAt:        expiresAt,
-			RefreshIssuedAt:  issuedAt,
-			RefreshExpiresAt: expiresAt,
+		// Re-authentication links renew an existing user; fresh logins get a per-store user when Converty names the store
+		if attempt.ReauthNonce == "" {
+			userID = sessionTokenUserID(tenant, tokenResp.StoreID)
 		}
+		tokenInfo := newTokenInfo(userID, tokenResp, service.UpstreamIssuedAt(resp.Header))
+		tokenInfo.TenantID = tenant.ID
+		tokenInfo.StoreID = tokenResp.StoreID
+		granted := grantedScopes(tokenResp, attempt.requestedScopeList())
+		tokenInfo.Scopes = strings.Join(granted, " ")
 
-		if err := db.Where(TokenInfo{UserID: "user1"}).Assign(tokenInfo).FirstOrCreate(tokenInfo).Error; err != nil {
+		if err := db.Where(TokenInfo{UserID: userID}).Assign(tokenInfo).FirstOrCreate(tokenInfo).Error; err != nil {
 			writeError(w, fmt.Sprintf("Failed to save token to database: %v", err), http.StatusInternalServerError)
 			return
 		}
+		service.Caches.TokenChanged(userID)
+		if err := clearReauth(userID); err != nil {
+			writeError(w, fmt.Sprintf("Failed to mark token valid: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := clearMissingScopes(userID, granted); err != nil {
+			writeError(w, fmt.Sprintf("Failed to update token scopes: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := setSessionCookie(w, r, sessionService, userID, tokenResp.StoreID, tenant.Slug, attempt.Remember); err != nil {
+			writeError(w, fmt.Sprintf("Failed to create session: %v", err), http.StatusInternalServerError)
+			return
+		}
 
 		fmt.Fprintf(w, "Authorization successful! Access Token: %s\nRefresh Token: %s", tokenResp.AccessToken, tokenResp.RefreshToken)
 	})
@@ -193,7 +429,7 @@ func startServer(dataService service.DataService) {
 	// Refresh token endpoint
 	r.Post("/GetAccessToken", func(w http.ResponseWriter, r *http.Request) {
 		var tokenInfo TokenInfo
-		if err := db.Where("user_id = ?", "user1").First(&tokenInfo).Error; err != nil {
+		if err := db.Where("user_id = ?", tokenUserFor(r)).First(&tokenInfo).Error; err != nil {
 			writeError(w, "No token found, please re-authenticate via /login", http.StatusUnauthorized)
 			return
 		}
@@ -203,109 +439,315 @@ func startServer(dataService service.DataService) {
 			return
 		}
 
-		if time.Now().After(tokenInfo.RefreshExpiresAt) {
-			writeError(w, fmt.Sprintf("Refresh token has expired at: %v, please re-authenticate via /login", tokenInfo.RefreshExpiresAt), http.StatusUnauthorized)
+		if tokenInfo.Invalid {
+			writeReauthRequired(w, r, tokenInfo.UserID, tokenInfo.InvalidReason)
 			return
 		}
 
-		data := url.Values{}
-		data.Set("grant_type", "refresh_token")
-		data.Set("client_id", clientID)
-		data.Set("client_secret", clientSecret)
-		data.Set("refresh_token", tokenInfo.RefreshToken)
+		if refreshExpired(tokenInfo, service.UpstreamNow()) {
+			writeReauthRequired(w, r, tokenInfo.UserID, fmt.Sprintf("Refresh token has expired at: %v", tokenInfo.RefreshExpiresAt))
+			return
+		}
 
-		client := &http.Client{}
-		resp, err := client.PostForm(tokenURL, data)
+		refreshed, err := refreshStoredToken(tokenInfo)
 		if err != nil {
-			writeError(w, fmt.Sprintf("Failed to refresh token: %v", err), http.StatusInternalServerError)
+			if isRefreshRejected(err) {
+				writeReauthRequired(w, r, tokenInfo.UserID, err.Error())
+				return
+			}
+			writeError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			writeError(w, fmt.Sprintf("Refresh request failed with status %d: %s", resp.StatusCode, string(body)), http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokenResponseFor(refreshed, service.UpstreamNow()))
+	})
+
+	// Token status endpoint
+	r.Get("/api/v1/token/status", func(w http.ResponseWriter, r *http.Request) {
+		tokenInfo, err := loadStoredToken(tokenUserFor(r))
+		if err != nil {
+			writeError(w, "No token found, please authenticate via /login", http.StatusNotFound)
 			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokenStatus(tokenInfo, service.UpstreamNow()))
+	})
 
-		var tokenResp TokenResponse
-		if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-			writeError(w, fmt.Sprintf("Failed to parse refresh token response: %v", err), http.StatusInternalServerError)
+	// Get products endpoint
+	upstream.With(conditionalGET).Get("/get-products", func(w http.ResponseWriter, r *http.Request) {
+		tokenInfo, err := loadStoredToken(tokenUserFor(r))
+		if err != nil {
+			writeError(w, "No token found, please authenticate via /login", http.StatusUnauthorized)
+			return
+		}
+
+		if tokenInfo.Invalid {
+			writeReauthRequired(w, r, tokenInfo.UserID, tokenInfo.InvalidReason)
 			return
 		}
 
-		issuedAt := time.Now()
-		tokenInfo = TokenInfo{
-			UserID:           "user1",
-			AccessToken:      tokenResp.AccessToken,
-			RefreshToken:     tokenResp.RefreshToken,
-			TokenType:        tokenResp.TokenType,
-			ExpiresIn:        int64(tokenResp.ExpiresIn),
-			IssuedAt:         issuedAt,
-			ExpiresAt:        issuedAt.Add(time.Second * time.Duration(tokenResp.ExpiresIn)),
-			RefreshIssuedAt:  issuedAt,
-			RefreshExpiresAt: issuedAt.Add(time.Second * time.Duration(tokenResp.ExpiresIn)),
+		// Refresh token if expired or about to
+		if tokenExpiring(tokenInfo, service.UpstreamNow()) {
+			if refreshExpired(tokenInfo, service.UpstreamNow()) {
+				writeReauthRequired(w, r, tokenInfo.UserID, fmt.Sprintf("Refresh token has expired at: %v", tokenInfo.RefreshExpiresAt))
+				return
+			}
+			// The refresh expiry only moves if the refresh token rotated
+			refreshed, err := refreshStoredToken(tokenInfo)
+			if err != nil {
+				if isRefreshRejected(err) {
+					writeReauthRequired(w, r, tokenInfo.UserID, err.Error())
+					return
+				}
+				writeError(w, fmt.Sprintf("Access token expired, refresh failed: %v", err), http.StatusUnauthorized)
+				return
+			}
+			tokenInfo = refreshed
 		}
 
-		if err := db.Where(TokenInfo{UserID: "user1"}).Updates(&tokenInfo).Error; err != nil {
-			writeError(w, fmt.Sprintf("Failed to update token in database: %v", err), http.StatusInternalServerError)
+		// The upstream body is passed through as is, in the shape of the configured version
+		productsURL := converty.URL("products")
+		category, ok := requestCategory(w, r, categoryService)
+		if !ok {
 			return
 		}
+		if category != nil {
+			productsURL += "?category=" + url.QueryEscape(category.ExternalID)
+		}
+		if !callConvertyAPIAndWrite(w, r, "GET", productsURL, tokenInfo.AccessToken) {
+			return
+		}
+	})
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(tokenResp)
+	// Records endpoints using DataService. Record reads send Last-Modified and answer HEAD and
+	// If-Modified-Since, so pollers get 304 Not Modified while nothing changed.
+	listRecords := func(w http.ResponseWriter, r *http.Request) {
+		// Any record change, archive or deletion of the tenant invalidates every listing
+		modified, err := tenantData(r, dataService).RecordsLastModified()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if notModified(w, r, modified) {
+			return
+		}
+		// Batch lookup: /api/v1/records?ids=1,2,3
+		if r.URL.Query().Get("ids") != "" {
+			query, ok := bindIDList(w, r)
+			if !ok {
+				return
+			}
+			ids, err := parseIDList(query.IDs)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			records, err := tenantData(r, dataService).QueryByIDs(ids)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, r, http.StatusOK, api.All(r, records))
+			return
+		}
+
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// ?tag=urgent&tag=refund keeps records carrying both tags; ?filter=<name> applies a saved filter
+		filter, err := recordListFilter(r, services.Tags)
+		if err != nil {
+			writeError(w, err.Error(), recordFilterStatus(err))
+			return
+		}
+		records, total, err := tenantData(r, dataService).PageRecords(filter, params.Offset(), params.Limit)
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.NewPage(r, records, params, total))
+	}
+	r.With(conditionalGET).Get("/api/v1/records", listRecords)
+	r.With(conditionalGET).Head("/api/v1/records", listRecords)
+
+	// Full-text search: /api/v1/records/search?q=red blender complaint&type=issue&from=2025-03-01
+	r.Get("/api/v1/records/search", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter, err := recordListFilter(r, services.Tags)
+		if err != nil {
+			writeError(w, err.Error(), recordFilterStatus(err))
+			return
+		}
+		matches, total, err := tenantData(r, dataService).FullTextSearch(r.URL.Query().Get("q"), filter, params.Offset(), params.Limit)
+		if errors.Is(err, service.ErrEmptySearch) {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.NewPage(r, matches, params, total))
 	})
 
-	// Get products endpoint
-	// Get products endpoint
-	r.Get("/get-products", func(w http.ResponseWriter, r *http.Request) {
-		var tokenInfo TokenInfo
-		if err := db.Where("user_id = ?", "user1").First(&tokenInfo).Error; err != nil {
-			writeError(w, "No token found, please authenticate via /login", http.StatusUnauthorized)
+	getRecord := func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		var id uint
+		_, err := fmt.Sscanf(idStr, "%d", &id)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		record, err := tenantData(r, dataService).QueryByID(id)
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
 			return
 		}
+		if notModified(w, r, record.LastModified()) {
+			return
+		}
+		writeJSON(w, r, http.StatusOK, record)
+	}
+	r.Get("/api/v1/records/{id}", getRecord)
+	r.Head("/api/v1/records/{id}", getRecord)
 
-		// Refresh token if expired
-		if time.Now().After(tokenInfo.ExpiresAt) {
-			newToken, err := GetAccessToken(tokenInfo.RefreshToken)
-			if err != nil {
-				writeError(w, fmt.Sprintf("Access token expired, refresh failed: %v", err), http.StatusUnauthorized)
+	r.Put("/api/v1/records/{id}/status", func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		var id uint
+		_, err := fmt.Sscanf(idStr, "%d", &id)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		var input recordStatusRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		record, err := tenantData(r, dataService).UpdateRecordStatus(id, input.Status, input.Actor)
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidTransition) || errors.Is(err, service.ErrRecordSuperseded) {
+				writeError(w, err.Error(), http.StatusConflict)
 				return
 			}
-			// Update token in database
-			issuedAt := time.Now()
-			expiresAt := issuedAt.Add(time.Second * time.Duration(tokenInfo.ExpiresIn))
-			updates := map[string]interface{}{
-				"access_token":       newToken,
-				"expires_at":         expiresAt,
-				"issued_at":          issuedAt,
-				"refresh_issued_at":  issuedAt,
-				"refresh_expires_at": tokenInfo.RefreshExpiresAt, // Preserve existing refresh expiry
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, record)
+	})
+
+	// RFC 7386 merge patch of the details: {"address": {"city": "Sfax"}, "note": null}. If-Match: "<revision>"
+	// makes it fail with 412 when the record changed since that revision.
+	r.Patch("/api/v1/records/{id}/details", func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		var id uint
+		_, err := fmt.Sscanf(idStr, "%d", &id)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		var revision int
+		if match := strings.Trim(strings.TrimPrefix(r.Header.Get("If-Match"), "W/"), `"`); match != "" {
+			if revision, err = strconv.Atoi(match); err != nil || revision < 1 {
+				writeError(w, "If-Match must be a record revision", http.StatusBadRequest)
+				return
 			}
-			if err := db.Where(TokenInfo{UserID: "user1"}).Updates(updates).Error; err != nil {
-				writeError(w, fmt.Sprintf("Failed to update access token: %v", err), http.StatusInternalServerError)
+		}
+		patch, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to read request body: %v", err), bodyErrorStatus(err))
+			return
+		}
+		record, err := tenantData(r, dataService).PatchRecordDetails(id, patch, revision)
+		if err != nil {
+			if errors.Is(err, service.ErrRecordModified) {
+				writeError(w, err.Error(), http.StatusPreconditionFailed)
 				return
 			}
-			tokenInfo.AccessToken = newToken
+			writeError(w, err.Error(), recordChangeStatus(err))
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, record.Revision))
+		writeJSON(w, r, http.StatusOK, record)
+	})
+
+	recordHistory := func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		var id uint
+		_, err := fmt.Sscanf(idStr, "%d", &id)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		record, err := tenantData(r, dataService).QueryByID(id)
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		// Status changes update the record, so its modification time covers the history
+		if notModified(w, r, record.LastModified()) {
+			return
+		}
+		history, err := tenantData(r, dataService).RecordHistory(id)
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
 		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, history, params))
+	}
+	r.Get("/api/v1/records/{id}/history", recordHistory)
+	r.Head("/api/v1/records/{id}/history", recordHistory)
 
-		if !callConvertyAPIAndWrite(w, "GET", "https://api.converty.shop/api/v1/products", tokenInfo.AccessToken) {
+	// Versions of an append-only record, oldest first; other records have a single version
+	r.Get("/api/v1/records/{id}/versions", func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		var id uint
+		_, err := fmt.Sscanf(idStr, "%d", &id)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
 			return
 		}
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		versions, err := tenantData(r, dataService).RecordVersions(id)
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, versions, params))
 	})
 
-	// Records endpoints using DataService
-	r.Get("/api/v1/records", func(w http.ResponseWriter, r *http.Request) {
-		records, err := dataService.ListRecords()
+	r.Post("/api/v1/records/{id}/archive", func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		var id uint
+		_, err := fmt.Sscanf(idStr, "%d", &id)
 		if err != nil {
-			writeError(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(records)
+		record, err := tenantData(r, dataService).ArchiveRecord(id)
+		if err != nil {
+			writeError(w, err.Error(), recordChangeStatus(err))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, record)
 	})
 
-	r.Get("/api/v1/records/{id}", func(w http.ResponseWriter, r *http.Request) {
+	r.Post("/api/v1/records/{id}/restore", func(w http.ResponseWriter, r *http.Request) {
 		idStr := chi.URLParam(r, "id")
 		var id uint
 		_, err := fmt.Sscanf(idStr, "%d", &id)
@@ -313,70 +755,657 @@ func startServer(dataService service.DataService) {
 			writeError(w, "Invalid ID format", http.StatusBadRequest)
 			return
 		}
-		record, err := dataService.QueryByID(id)
+		record, err := tenantData(r, dataService).RestoreRecord(id)
 		if err != nil {
-			writeError(w, err.Error(), http.StatusNotFound)
+			writeError(w, err.Error(), recordChangeStatus(err))
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(record)
+		writeJSON(w, r, http.StatusOK, record)
+	})
+
+	r.Delete("/api/v1/records/{id}", func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		var id uint
+		_, err := fmt.Sscanf(idStr, "%d", &id)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		if err := tenantData(r, dataService).DeleteRecord(id); err != nil {
+			writeError(w, err.Error(), recordChangeStatus(err))
+			return
+		}
+		// Restoring the record does not bring its files back
+		if services.Attachments != nil {
+			if _, err := services.Attachments.PurgeRecords(r.Context(), []uint{id}); err != nil {
+				log.Printf("Record %d deleted, but not its attachments: %v", id, err)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
 	})
 
 	r.Post("/api/v1/records", func(w http.ResponseWriter, r *http.Request) {
-		var input struct {
-			UserID  uint                   `json:"user_id"`
-			Type    string                 `json:"type"`
-			Details map[string]interface{} `json:"details"`
-			Status  string                 `json:"status"`
+		var input createRecordRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		routeByCategory(r, categoryService, input.Details)
+		record, err := tenantData(r, dataService).InsertRecord(input.UserID, input.Type, input.Details, input.Status)
+		var violation *service.SchemaViolationError
+		if errors.As(err, &violation) {
+			writeJSON(w, r, http.StatusUnprocessableEntity, violation)
+			return
 		}
-		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-			writeError(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		// A repeat of a recent record is refused with the earlier one, or answered by it in merge mode
+		var duplicate *service.DuplicateRecordError
+		if errors.As(err, &duplicate) {
+			writeJSON(w, r, http.StatusConflict, map[string]interface{}{
+				"error":    "duplicate_record",
+				"message":  err.Error(),
+				"existing": duplicate.Existing,
+			})
 			return
 		}
-		record, err := dataService.InsertRecord(input.UserID, input.Type, input.Details, input.Status)
 		if err != nil {
-			writeError(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(record)
+		if record.Duplicate {
+			writeJSON(w, r, http.StatusOK, record)
+			return
+		}
+		if record.Type == service.AddressRecordType {
+			// The record stays the source of truth; an address the book rejects is only logged
+			if _, err := services.Addresses.SaveFromRecord(record, input.Details); err != nil {
+				log.Printf("Address record %d not added to the address book: %v", record.ID, err)
+			}
+		}
+		writeJSON(w, r, http.StatusCreated, record)
 	})
 
-	port := ":9001"
-	log.Println("Server starting on ", port)
-	if err := http.ListenAndServe(port, r); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	// Orders endpoints backed by the Converty API
+	upstream.With(conditionalGET).Get("/api/v1/orders", func(w http.ResponseWriter, r *http.Request) {
+		// Batch lookup: /api/v1/orders?ids=a,b,c
+		if r.URL.Query().Get("ids") != "" {
+			query, ok := bindIDList(w, r)
+			if !ok {
+				return
+			}
+			orders, err := tenantData(r, dataService).GetOrdersByIDs(query.IDs)
+			if err != nil {
+				writeUpstreamError(w, r, err)
+				return
+			}
+			if currency := r.URL.Query().Get("currency"); currency != "" {
+				if err := currencyConverter.ConvertOrders(orders, currency); err != nil {
+					writeError(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			customerLanguage(w, r).LocalizeOrders(orders)
+			writeJSON(w, r, http.StatusOK, api.All(r, orders))
+			return
+		}
+
+		query, err := parseOrderQuery(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		category, ok := requestCategory(w, r, categoryService)
+		if !ok {
+			return
+		}
+		// The Converty API cannot filter by category, and filtering one of its pages would return short,
+		// misnumbered pages: a category is served from the mirror, which filters before paging
+		var orders []service.Order
+		if category != nil {
+			query.Category = category
+			w.Header().Set(orderSourceHeader, "mirror")
+			orders, err = services.OrderMirror.ListOrders(tenantFrom(r).ID, query)
+		} else {
+			orders, err = listOrders(w, r, dataService, services.OrderMirror, query)
+		}
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		if currency := r.URL.Query().Get("currency"); currency != "" {
+			if err := currencyConverter.ConvertOrders(orders, currency); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		customerLanguage(w, r).LocalizeOrders(orders)
+		writeJSON(w, r, http.StatusOK, api.NewOpenPage(r, orders, api.PageParams{Page: query.Page, Limit: query.Limit}))
+	})
+
+	upstream.Get("/api/v1/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		order, err := getOrder(w, r, dataService, services.OrderMirror, chi.URLParam(r, "id"))
+		if errors.Is(err, service.ErrOrderNotMirrored) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		order.StatusLabel = customerLanguage(w, r).StatusLabel(order.Status)
+		writeJSON(w, r, http.StatusOK, order)
+	})
+
+	registerOrderRoutes(upstream, dataService, services.Orders, services.Audit, services.Addresses, services.Reservations, services.Flags)
+	registerReportRoutes(upstream, dataService, services.OrderMirror, categoryService)
+	registerPaymentRoutes(r, upstream, dataService, paymentService)
+	registerAbandonedCartRoutes(r, jobService, dataService, cartService)
+	registerWalletRoutes(r, upstream, dataService, walletService)
+	registerLoyaltyRoutes(r, upstream, dataService, loyaltyService)
+	registerCategoryRoutes(r, jobService, categoryService)
+	registerWaitlistRoutes(r, upstream, dataService, waitlistService)
+	registerETARoutes(upstream, dataService, services.OrderMirror, etaService)
+	registerTrackingRoutes(upstream, dataService, services.Tracking)
+	if services.Recommendations != nil {
+		registerRecommendationRoutes(upstream, dataService, services.Recommendations)
 	}
+	if services.OrderMirror != nil {
+		registerGraphQLRoutes(upstream, dataService, services.OrderMirror)
+	}
+	// Exports and webhooks can be switched off per tenant with the exports and webhooks flags
+	registerOrderExportRoutes(r.With(requireFlag(services.Flags, service.FlagExports)), dataService, jobService)
+	registerOrderReportRoutes(r, services.OrderReports)
+	registerDailyDigestRoutes(r, services.DailyDigests)
+	registerOrderSyncRoutes(r, jobService, services.OrderMirror)
+	registerConversationRoutes(r, services.Conversations)
+	registerAlertRoutes(r, services.Alerts)
+	registerWebhookRoutes(r.With(requireFlag(services.Flags, service.FlagWebhooks)), services.Webhooks)
+	registerConvertyWebhookRoutes(r, jobService, tenantService, services.ConvertyEvents)
+	registerTagRoutes(r, dataService, services.Tags)
+	registerIssueRoutes(r, dataService)
+	registerCouponRoutes(upstream, dataService)
+	registerDebugRoutes(r)
+	registerAdminLoginRoutes(r, sessionService)
+	registerTwoFactorRoutes(r, services.TOTP, sessionService)
+	registerDashboardRoutes(r, dataService, services.Dashboard)
+	registerAttachmentRoutes(r, dataService, services.Attachments)
+	registerRuleRoutes(r, jobService, services.Rules)
+	registerCustomerRoutes(r, services.Merges)
+	registerAddressRoutes(r, services.Addresses)
+	if services.Consents != nil {
+		registerConsentRoutes(r, services.Consents)
+	}
+	registerReservationRoutes(r, services.Reservations)
+	registerRecordSchemaRoutes(r, services.Schemas)
+	registerCacheRoutes(r)
+
+	// Abbreviations used by ?compact=true responses
+	r.Get("/api/v1/compact-keys", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(compactKeys)
+	})
+
+	// Job status polling endpoint
+	r.Get("/api/v1/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		var id uint
+		_, err := fmt.Sscanf(idStr, "%d", &id)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		job, err := jobService.GetJob(tenantFrom(r).ID, id)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+	})
+
+	// Admin endpoints
+	r.Route("/api/v1/admin", func(r chi.Router) {
+		r.Use(adminOnly)
+
+		registerTenantAdminRoutes(r, tenantService)
+		registerSessionAdminRoutes(r, sessionService)
+		registerTokenAdminRoutes(r, sessionService)
+		registerTwoFactorAdminRoutes(r, services.TOTP, sessionService)
+		registerServiceAccountAdminRoutes(r, tenantService, services.ServiceAccounts)
+		registerUserAdminRoutes(r, tenantService, services.Users, sessionService)
+		registerPolicyAdminRoutes(r)
+		registerConfigAdminRoutes(r)
+		registerIncidentAdminRoutes(r, services.Status, statusPageCache)
+		registerReportScheduleAdminRoutes(r, jobService, services.ReportSchedules)
+		registerDailyDigestAdminRoutes(r, jobService)
+		registerOrderDedupAdminRoutes(r, services.Orders)
+		registerRecordSchemaAdminRoutes(r, services.Schemas)
+		registerAuditAdminRoutes(r, services.Audit)
+		registerOutboxAdminRoutes(r, services.Outbox)
+		registerRetentionAdminRoutes(r, jobService, services.Retention, legalHoldService)
+		registerWarehouseExportAdminRoutes(r, jobService, services.Warehouse)
+		if services.Flags != nil {
+			registerFeatureFlagAdminRoutes(r, tenantService, services.Flags)
+		}
+
+		// Legal holds exempt a customer's records from retention deletion and erasure
+		r.Get("/legal-holds", func(w http.ResponseWriter, r *http.Request) {
+			params, err := api.ParsePageParams(r)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			holds, err := legalHoldService.ListHolds(tenantFrom(r).ID, r.URL.Query().Get("active") == "true")
+			if err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, r, http.StatusOK, api.Slice(r, holds, params))
+		})
+
+		r.Post("/legal-holds", func(w http.ResponseWriter, r *http.Request) {
+			var input legalHoldRequest
+			if !bindJSON(w, r, &input) {
+				return
+			}
+			hold, err := legalHoldService.PlaceHold(tenantFrom(r).ID, input.UserID, input.Reason, input.Reference, adminActor(r))
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, r, http.StatusCreated, hold)
+		})
+
+		r.Delete("/legal-holds/{id}", func(w http.ResponseWriter, r *http.Request) {
+			idStr := chi.URLParam(r, "id")
+			var id uint
+			_, err := fmt.Sscanf(idStr, "%d", &id)
+			if err != nil {
+				writeError(w, "Invalid ID format", http.StatusBadRequest)
+				return
+			}
+			hold, err := legalHoldService.ReleaseHold(tenantFrom(r).ID, id, adminActor(r))
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, r, http.StatusOK, hold)
+		})
+	})
+
+	return r
 }
 
 func main() {
 	// Parse command-line flags
 	consoleMode := flag.Bool("console", false, "Run in console mode")
+	consoleLang := flag.String("lang", os.Getenv("CONSOLE_LANG"), "Language of the interactive console: en, fr or ar")
+	consoleCmd := flag.String("cmd", "", `Run one console command without prompts and exit, e.g. "list-issues --status Pending --format json"`)
+	encryptPII := flag.Bool("encrypt-pii", false, "Encrypt the personal data of existing records and exit")
+	syncOrders := flag.Bool("sync-orders", false, "Sync the local order mirror of every tenant and exit")
+	syncInterval := flag.Duration("sync-interval", 0, "With -sync-orders, keep syncing at this interval instead of exiting")
+	exportWarehouse := flag.Bool("export", false, "Export the interactions not exported yet to the data warehouse and exit")
 	flag.Parse()
+	lang, err := console.ParseLang(*consoleLang)
+	if err != nil {
+		log.Fatalf("Invalid -lang: %v", err)
+	}
+	if flag.Arg(0) == "doctor" {
+		// Self-test before a deploy; runs before initDB so broken settings are reported, not fatal
+		os.Exit(runDoctor(flag.Args()[1:], os.Stdout))
+	}
 
 	// Initialize database
 	initDB()
+	if *encryptPII {
+		encryptExistingPII()
+		return
+	}
+	if flag.Arg(0) == "admin" {
+		// Environment cloning: `admin export` in production, `admin import` in staging
+		os.Exit(runAdminCommand(flag.Args()[1:], os.Stdout))
+	}
 
-	// Create DataService
-	dataService := service.NewGormDataService(db)
+	// Log level, Converty limits, cache TTLs, alert thresholds, feature flags and the access policy can be
+	// reloaded later with SIGHUP or POST /api/v1/admin/config/reload
+	tuning, err := parseRuntimeConfig(os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := initTracing(); err != nil {
+		log.Fatalf("Invalid tracing configuration: %v", err)
+	}
 
-	// Retrieve client ID and secret
+	// Create DataService; inserted records are classified by the tenant's rules and validated
+	// against the active schema of their type
+	ruleService := service.NewGormRuleService(db)
+	schemaService := service.NewGormRecordSchemaService(db)
+	client, err := service.NewConvertyClient(os.Getenv("CONVERTY_API_VERSION"), os.Getenv("CONVERTY_API_URL"))
+	if err != nil {
+		log.Fatalf("Invalid Converty API configuration: %v", err)
+	}
+	converty = client
+	log.Printf("Using Converty API %s", converty.Version())
+	recordDedup, err := loadRecordDedup()
+	if err != nil {
+		log.Fatalf("Invalid duplicate record configuration: %v", err)
+	}
+	dataOptions := service.DataServiceOptions{
+		OrderCacheTTL: tuning.OrderCacheTTL,
+		Classifier:    ruleService,
+		Validator:     schemaService,
+		Converty:      converty,
+		Dedup:         recordDedup,
+		Limits:        loadDetailsLimits(),
+	}
+	// RECORD_STORE=mongo keeps the interactions in MongoDB; everything else stays in PostgreSQL
+	recordStore, err := loadRecordStore()
+	if err != nil {
+		log.Fatalf("Invalid record store configuration: %v", err)
+	}
+	dataService, err := openRecordStore(recordStore, db, service.NewGormDataService(db, dataOptions), dataOptions)
+	if err != nil {
+		log.Fatalf("Failed to open the record store: %v", err)
+	}
+
+	// Create the background job queue
+	jobService := service.NewGormJobService(db)
+
+	legalHoldService := service.NewGormLegalHoldService(db)
+	tenantService := service.NewGormTenantService(db)
+	categoryService := service.NewGormCategoryService(db)
+	sessionService := service.NewGormSessionService(db)
+	totpService := service.NewGormTOTPService(db)
+	serviceAccountService := service.NewGormServiceAccountService(db)
+
+	// Retrieve client ID and secret; loadEnv has checked that both are set
 	clientID = os.Getenv("CLIENT_ID")
 	clientSecret = os.Getenv("CLIENT_SECRET")
-	if clientID == "" || clientSecret == "" {
-		log.Fatal("CLIENT_ID or CLIENT_SECRET not set in .env file")
+	loadRefreshTokenTTL()
+	loadTokenRefreshSkew()
+	loadTokenExpiryLeeway()
+	service.RefreshToken = refreshServiceToken
+	if baseURL := os.Getenv("PUBLIC_BASE_URL"); baseURL != "" {
+		publicBaseURL = strings.TrimRight(baseURL, "/")
+	}
+	notifier = service.NewNotifier(os.Getenv("OPERATOR_WEBHOOK_URL"))
+	adminAPIKey = os.Getenv("ADMIN_API_KEY")
+	if value := os.Getenv("UPSTREAM_LOG_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			log.Fatalf("Invalid UPSTREAM_LOG_SIZE %q", value)
+		}
+		service.UpstreamExchanges = service.NewUpstreamLog(size)
+	}
+	service.SetImmutableTypes(splitList(os.Getenv("IMMUTABLE_RECORD_TYPES")))
+	service.UpstreamLimit = service.NewUpstreamLimiter(tuning.UpstreamLimits)
+	log.Printf("Converty calls limited to %d concurrent and %d per minute", tuning.UpstreamLimits.MaxConcurrent, tuning.UpstreamLimits.PerMinute)
+	if err := loadOutboundHTTP(); err != nil {
+		log.Fatalf("Invalid outbound HTTP configuration: %v", err)
+	}
+	if err := loadPIIProtection(); err != nil {
+		log.Fatalf("Invalid PII encryption configuration: %v", err)
+	}
+	tenantRequired = os.Getenv("REQUIRE_TENANT") == "true"
+	if err := loadSessionConfig(); err != nil {
+		log.Fatalf("Invalid session configuration: %v", err)
+	}
+	loadUserAuth()
+	if err := loadOAuthScopes(); err != nil {
+		log.Fatalf("Invalid OAuth configuration: %v", err)
+	}
+	if err := loadPolicy(); err != nil {
+		log.Fatalf("Invalid access policy: %v", err)
+	}
+	if err := loadAdminBackends(sessionService); err != nil {
+		log.Fatalf("Invalid admin authentication configuration: %v", err)
+	}
+	if err := loadCurrencyConverter(); err != nil {
+		log.Fatalf("Invalid currency configuration: %v", err)
+	}
+	if err := loadTaxConfig(); err != nil {
+		log.Fatalf("Invalid tax configuration: %v", err)
+	}
+	attachmentStore, err := loadAttachmentStore()
+	if err != nil {
+		log.Fatalf("Invalid attachment configuration: %v", err)
+	}
+	reportDelivery, err := loadReportDelivery()
+	if err != nil {
+		log.Fatalf("Invalid report delivery configuration: %v", err)
+	}
+	orderDedupDefaults, err := loadOrderDedupDefaults()
+	if err != nil {
+		log.Fatalf("Invalid duplicate order configuration: %v", err)
+	}
+	loadDryRun()
+	if err := loadOrderMirror(); err != nil {
+		log.Fatalf("Invalid order mirror configuration: %v", err)
+	}
+	outboxDestinations, err := loadOutboxDestinations()
+	if err != nil {
+		log.Fatalf("Invalid outbox configuration: %v", err)
+	}
+	outboxAttempts, err := outboxMaxAttempts()
+	if err != nil {
+		log.Fatalf("Invalid outbox configuration: %v", err)
+	}
+	orderMirror := service.NewGormOrderMirrorService(db)
+	if *syncOrders {
+		runOrderSync(dataService, tenantService, orderMirror, *syncInterval)
+		return
+	}
+	warehouseExport, err := loadWarehouseExport()
+	if err != nil {
+		log.Fatalf("Invalid warehouse export configuration: %v", err)
+	}
+	if *exportWarehouse {
+		runWarehouseExport(warehouseExport)
+		return
+	}
+	// Token maintenance and scripted console runs return before any job worker, scheduler, dispatcher or
+	// listener starts, so they can be cron'd next to a live server without claiming its jobs
+	if flag.Arg(0) == "token" {
+		// e.g. `token refresh-all` after an outage longer than the access token lifetime
+		os.Exit(runTokenCommand(flag.Args()[1:], os.Stdout))
+	}
+	if *consoleCmd != "" {
+		if err := console.Exec(dataService, tenantService, consoleTokens{}, *consoleCmd, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	watchPolicy(durationEnv("POLICY_RELOAD_INTERVAL", 30*time.Second))
+
+	// Wait for Postgres, optionally serving /health and /readyz meanwhile, then migrate
+	awaitDatabase()
+	if err := migrateDB(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	log.Println("Database connection established successfully")
+
+	paymentService := service.NewGormPaymentService(db, dataService, notifier)
+	loadPaymentProviders(paymentService)
+
+	// New abandoned carts and back-in-stock messages go to the chatbot webhook when one is configured
+	chatbotNotifier := notifier
+	if url := os.Getenv("CHATBOT_WEBHOOK_URL"); url != "" {
+		chatbotNotifier = service.NewWebhookNotifier(url)
+	}
+	// Messages to customers who opted out, or never opted in with CONSENT_MODE=opt_in, are withheld
+	consentOptIn, err := loadConsentMode()
+	if err != nil {
+		log.Fatalf("Invalid consent configuration: %v", err)
+	}
+	consentService := service.NewGormConsentService(db, consentOptIn)
+	chatbotNotifier = service.ConsentNotifier{Next: chatbotNotifier, Consents: consentService}
+	cartService := service.NewGormAbandonedCartService(db, chatbotNotifier)
+	alertService := service.NewGormProductAlertService(db, chatbotNotifier, tuning.Alerts)
+	flagService := service.NewGormFeatureFlagService(db, tuning.Flags)
+	liveConfig.install(tuning, alertService, flagService)
+	reloadOnSIGHUP()
+	// Rotated secrets apply without a restart; POST /api/v1/cache/invalidate {"resource": "secrets"} reads them now
+	watchSecrets(jobService)
+	waitlistService := service.NewGormWaitlistService(db, chatbotNotifier, alertService)
+	walletService := service.NewGormWalletService(db, currencyConverter.StoreCurrency)
+	loyaltyRules, err := loadLoyaltyRules()
+	if err != nil {
+		log.Fatalf("Invalid loyalty configuration: %v", err)
+	}
+	loyaltyService := service.NewGormLoyaltyService(db, walletService, loyaltyRules)
+	etaService := service.NewGormETAService(db)
+	attachmentService := service.NewGormAttachmentService(db, attachmentStore, attachmentMaxSize)
+	trackingService := service.NewTrackingService()
+	loadCarriers(trackingService)
+	dashboard := service.NewDashboard(durationEnv("DASHBOARD_ERROR_WINDOW", 5*time.Minute))
+	go dashboard.Run(service.Events)
+	dashboardRefreshInterval = durationEnv("DASHBOARD_REFRESH_INTERVAL", dashboardRefreshInterval)
+	scheduleDashboardRefresh(dashboard, dataService, tenantService)
+
+	// Start job workers
+	workers := 2
+	if value := os.Getenv("JOB_WORKERS"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			workers = n
+		}
+	}
+	if err := loadRetentionPolicy(); err != nil {
+		log.Fatalf("Invalid retention configuration: %v", err)
+	}
+	retentionService := service.NewGormRetentionService(db, dataService, attachmentService)
+	registerPurgeJob(jobService, retentionService, legalHoldService)
+	registerAbandonedSyncJob(jobService, dataService, tenantService, cartService)
+	registerLoyaltyJob(jobService, dataService, tenantService, loyaltyService)
+	registerCategorySyncJob(jobService, dataService, tenantService, categoryService)
+	registerStockSyncJob(jobService, dataService, tenantService, waitlistService)
+	registerOrderTrackingJob(jobService, dataService, tenantService, etaService)
+	registerOrderExportJob(jobService, dataService, tenantService)
+	registerOrderSyncJob(jobService, dataService, tenantService, orderMirror)
+	loadConvertyWebhooks()
+	convertyEvents := service.NewGormConvertyEventService(db)
+	registerConvertyBackfillJob(jobService, dataService, tenantService, convertyEvents)
+	if warehouseExport != nil {
+		registerWarehouseExportJob(jobService, warehouseExport)
+	}
+	registerReclassifyJob(jobService, tenantService, ruleService)
+	registerOAuthAttemptCleanupJob(jobService)
+	loginThrottle := service.NewGormLoginThrottle(db, loginFailureWindow, loginLockout)
+	registerLoginFailureCleanupJob(jobService, loginThrottle)
+	registerPIIReencryptJob(jobService)
+	reportScheduleService := service.NewGormReportScheduleService(db)
+	registerReportDeliveryJob(jobService, dataService, tenantService, categoryService, orderMirror, reportScheduleService, reportDelivery)
+	dailyDigestConfig, err := loadDailyDigest()
+	if err != nil {
+		log.Fatalf("Invalid daily digest configuration: %v", err)
+	}
+	dailyDigests := service.NewGormDailyDigestService(db, dailyDigestConfig.location)
+	registerDailyDigestJob(jobService, dailyDigests, notifier)
+	orderExportSyncRange = durationEnv("ORDER_EXPORT_SYNC_RANGE", orderExportSyncRange)
+	orderExportDir = envOr("ORDER_EXPORT_DIR", orderExportDir)
+	serviceAccountKeyGrace = durationEnv("SERVICE_ACCOUNT_KEY_GRACE", serviceAccountKeyGrace)
+	oauthAttemptTTL = durationEnv("OAUTH_ATTEMPT_TTL", oauthAttemptTTL)
+	jobService.Start(workers)
+	schedulePurge(jobService, durationEnv("PURGE_INTERVAL", 24*time.Hour))
+	scheduleJob(jobService, abandonedSyncJobType, durationEnv("ABANDONED_SYNC_INTERVAL", 15*time.Minute))
+	scheduleJob(jobService, loyaltyAccrualJobType, durationEnv("LOYALTY_ACCRUAL_INTERVAL", time.Hour))
+	scheduleJob(jobService, categorySyncJobType, durationEnv("CATEGORY_SYNC_INTERVAL", 6*time.Hour))
+	scheduleJob(jobService, stockSyncJobType, durationEnv("STOCK_SYNC_INTERVAL", 15*time.Minute))
+	scheduleJob(jobService, orderTrackingJobType, durationEnv("ORDER_TRACKING_INTERVAL", 30*time.Minute))
+	scheduleJob(jobService, orderSyncJobType, durationEnv("ORDER_SYNC_INTERVAL", time.Hour))
+	scheduleJob(jobService, convertyBackfillJobType, durationEnv("CONVERTY_BACKFILL_INTERVAL", time.Hour))
+	if warehouseExport != nil {
+		scheduleJob(jobService, warehouseExportJobType, durationEnv("WAREHOUSE_EXPORT_INTERVAL", time.Hour))
+	}
+	scheduleJob(jobService, oauthAttemptCleanupJobType, durationEnv("OAUTH_ATTEMPT_CLEANUP_INTERVAL", time.Hour))
+	scheduleJob(jobService, loginFailureCleanupJobType, durationEnv("LOGIN_FAILURE_CLEANUP_INTERVAL", time.Hour))
+	reportScheduleInterval = durationEnv("REPORT_SCHEDULE_INTERVAL", reportScheduleInterval)
+	outboxService := service.NewGormOutboxService(db, outboxDestinations, outboxAttempts)
+	if service.OutboxEnabled {
+		go outboxService.Run(durationEnv("OUTBOX_DISPATCH_INTERVAL", 5*time.Second), durationEnv("OUTBOX_RETENTION", 7*24*time.Hour))
+	}
+	scheduleReportDeliveries(jobService, reportScheduleService)
+	scheduleDailyDigests(jobService, tenantService, dailyDigests, dailyDigestConfig)
+	webhookAttempts, err := webhookMaxAttempts()
+	if err != nil {
+		log.Fatalf("Invalid webhook configuration: %v", err)
+	}
+	// WEBHOOK_ALLOW_PRIVATE_NETWORKS lets endpoints reach loopback and private addresses, e.g. a chatbot on the same host
+	webhookService := service.NewGormWebhookService(db, webhookAttempts, os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true")
+	go webhookService.Listen(service.Events)
+	go webhookService.Run(durationEnv("WEBHOOK_DISPATCH_INTERVAL", 5*time.Second), durationEnv("WEBHOOK_RETENTION", 30*24*time.Hour))
+
+	// Start the gRPC API alongside the HTTP server
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = ":9002"
+	}
+	// GRPC_TLS_CERT and GRPC_TLS_KEY serve TLS; GRPC_INSECURE=true allows plaintext behind a TLS proxy
+	grpcOptions := grpcapi.Options{
+		CertFile:     os.Getenv("GRPC_TLS_CERT"),
+		KeyFile:      os.Getenv("GRPC_TLS_KEY"),
+		Insecure:     os.Getenv("GRPC_INSECURE") == "true",
+		Authenticate: grpcAuthenticator(tenantService, serviceAccountService),
+	}
+	go func() {
+		if err := grpcapi.ListenAndServe(grpcAddr, dataService, grpcOptions); err != nil {
+			log.Printf("gRPC server stopped: %v", err)
+		}
+	}()
+
+	services := serverServices{
+		Data:            dataService,
+		Jobs:            jobService,
+		LegalHold:       legalHoldService,
+		Payments:        paymentService,
+		Carts:           cartService,
+		Wallets:         walletService,
+		Loyalty:         loyaltyService,
+		Tenants:         tenantService,
+		Categories:      categoryService,
+		Waitlist:        waitlistService,
+		ETA:             etaService,
+		Dashboard:       dashboard,
+		Tracking:        trackingService,
+		Sessions:        sessionService,
+		TOTP:            totpService,
+		Attachments:     attachmentService,
+		ServiceAccounts: serviceAccountService,
+		Rules:           ruleService,
+		Merges:          service.NewGormCustomerMergeService(db),
+		Status:          service.NewGormStatusService(db),
+		ReportSchedules: reportScheduleService,
+		Orders:          service.NewGormOrderService(db, orderDedupDefaults, chatbotNotifier),
+		Schemas:         schemaService,
+		Audit:           service.NewGormAuditService(db),
+		OrderReports:    service.NewGormOrderReportService(db),
+		OrderMirror:     orderMirror,
+		Outbox:          outboxService,
+		Conversations:   service.NewGormConversationService(db),
+		Alerts:          alertService,
+		Retention:       retentionService,
+		Webhooks:        webhookService,
+		Tags:            service.NewGormTagService(db),
+		Addresses:       service.NewGormAddressService(db, loadGeocoder()),
+		Reservations:    service.NewGormReservationService(db, durationEnv("RESERVATION_TTL", defaultReservationTTL)),
+		ConvertyEvents:  convertyEvents,
+		Users:           service.NewGormUserService(db),
+		LoginThrottle:   loginThrottle,
+		DailyDigests:    dailyDigests,
+		Warehouse:       warehouseExport,
+		Flags:           flagService,
+		Consents:        consentService,
+		Recommendations: service.NewGormRecommendationService(db, categoryService),
 	}
 
 	if *consoleMode {
 		// Start server in a goroutine
-		go startServer(dataService)
+		go startServer(services)
 		// Wait briefly to ensure server starts
 		time.Sleep(1 * time.Second)
 		// Run console in main thread
-		console.Run(dataService)
+		console.Run(dataService, tenantService, consoleTokens{}, lang)
+		jobService.Stop()
 	} else {
 		// Run server only
-		startServer(dataService)
+		startServer(services)
 	}
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// oauthAttemptCleanupJobType is the job queue type that removes expired authorization attempts
+const oauthAttemptCleanupJobType = "purge_oauth_attempts"
+
+// oauthAttemptTTL is how long the user has to come back from the Converty authorization page
+var oauthAttemptTTL = 30 * time.Minute
+
+// OAuthAttempt is a /login redirect waiting for its callback. It is stored rather than kept
+// in memory so a restart between /login and /api/v1/callback does not break the flow.
+type OAuthAttempt struct {
+	ID         uint   `gorm:"primaryKey"`
+	State      string `gorm:"not null;uniqueIndex"`
+	TenantSlug string
+	// ReauthNonce is the re-authentication link the attempt renews; it is consumed by the callback
+	ReauthNonce string
+	// Scopes are the requested scopes, space separated
+	Scopes    string
+	Remember  bool
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// TableName specifies the table name for OAuthAttempt
+func (OAuthAttempt) TableName() string {
+	return "public.oauth_attempts"
+}
+
+// startOAuthAttempt stores attempt under a new random state
+func startOAuthAttempt(attempt OAuthAttempt) (OAuthAttempt, error) {
+	state, err := newNonce()
+	if err != nil {
+		return OAuthAttempt{}, fmt.Errorf("failed to generate state: %v", err)
+	}
+	now := time.Now()
+	attempt.State, attempt.CreatedAt, attempt.ExpiresAt = state, now, now.Add(oauthAttemptTTL)
+	if err := db.Create(&attempt).Error; err != nil {
+		return OAuthAttempt{}, fmt.Errorf("failed to save authorization attempt: %v", err)
+	}
+	return attempt, nil
+}
+
+// consumeOAuthAttempt removes and returns the pending attempt of a state; a state can only be used once
+func consumeOAuthAttempt(state string) (OAuthAttempt, bool, error) {
+	var attempts []OAuthAttempt
+	result := db.Clauses(clause.Returning{}).Where("state = ? AND expires_at > ?", state, time.Now()).Delete(&attempts)
+	if result.Error != nil {
+		return OAuthAttempt{}, false, fmt.Errorf("failed to load authorization attempt: %v", result.Error)
+	}
+	if len(attempts) == 0 {
+		return OAuthAttempt{}, false, nil
+	}
+	return attempts[0], true, nil
+}
+
+// requestedScopeList returns the scopes the attempt asked for
+func (a OAuthAttempt) requestedScopeList() []string {
+	return strings.Fields(a.Scopes)
+}
+
+// purgeOAuthAttempts deletes the attempts that expired before now
+func purgeOAuthAttempts(now time.Time) (int64, error) {
+	result := db.Where("expires_at <= ?", now).Delete(&OAuthAttempt{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge authorization attempts: %v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// registerOAuthAttemptCleanupJob registers the handler that removes abandoned authorization attempts
+func registerOAuthAttemptCleanupJob(jobService service.JobService) {
+	jobService.RegisterHandler(oauthAttemptCleanupJobType, func(payload json.RawMessage) (interface{}, error) {
+		purged, err := purgeOAuthAttempts(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if purged > 0 {
+			log.Printf("Removed %d expired authorization attempts", purged)
+		}
+		return map[string]int64{"purged": purged}, nil
+	})
+}
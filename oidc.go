@@ -0,0 +1,415 @@
+package main
+
+import (
+	"convertyApi/service"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// adminSessionCookieName carries the staff session issued after an OIDC login
+const adminSessionCookieName = "convertyapi_admin"
+
+// oidcStateCookieName carries the state and nonce of a login in progress
+const oidcStateCookieName = "convertyapi_oidc"
+
+// oidcStateTTL bounds how long a staff member may take at the identity provider
+const oidcStateTTL = 10 * time.Minute
+
+// adminOIDC is the configured identity provider, nil when OIDC login is disabled
+var adminOIDC *oidcProvider
+
+var oidcHTTPClient = service.NewHTTPClient(10 * time.Second)
+
+// oidcProvider logs staff in with an external OpenID Connect provider (Google Workspace, Keycloak)
+// and maps their groups to admin roles
+type oidcProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// GroupsClaim names the ID token claim listing the user's groups
+	GroupsClaim string
+	// RoleMap maps group names to roles; DefaultRole applies to users in no mapped group
+	RoleMap     map[string]string
+	DefaultRole string
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+// oidcDiscovery is the subset of the provider's openid-configuration in use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// loadOIDCProvider reads the OIDC_* settings; it returns nil when OIDC_ISSUER is unset
+func loadOIDCProvider() (*oidcProvider, error) {
+	issuer := strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/")
+	if issuer == "" {
+		return nil, nil
+	}
+	provider := &oidcProvider{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  envOr("OIDC_REDIRECT_URL", publicBaseURL+"/admin/callback"),
+		Scopes:       strings.Fields(envOr("OIDC_SCOPES", "openid email profile")),
+		GroupsClaim:  envOr("OIDC_GROUPS_CLAIM", "groups"),
+		RoleMap:      make(map[string]string),
+		DefaultRole:  os.Getenv("OIDC_DEFAULT_ROLE"),
+	}
+	if provider.ClientID == "" || provider.ClientSecret == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID and OIDC_CLIENT_SECRET are required with OIDC_ISSUER")
+	}
+	// OIDC_ROLE_MAP=shop-admins=admin,support=viewer
+	for _, pair := range strings.Split(os.Getenv("OIDC_ROLE_MAP"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		group, role, found := strings.Cut(pair, "=")
+		role = strings.TrimSpace(role)
+		if !found || !validRole(role) {
+			return nil, fmt.Errorf("invalid OIDC_ROLE_MAP entry %q, expected group=role with a role of the access policy", pair)
+		}
+		provider.RoleMap[strings.TrimSpace(group)] = role
+	}
+	if provider.DefaultRole != "" && !validRole(provider.DefaultRole) {
+		return nil, fmt.Errorf("invalid OIDC_DEFAULT_ROLE %q", provider.DefaultRole)
+	}
+	if len(provider.RoleMap) == 0 && provider.DefaultRole == "" {
+		return nil, fmt.Errorf("OIDC_ROLE_MAP or OIDC_DEFAULT_ROLE is required with OIDC_ISSUER")
+	}
+	return provider, nil
+}
+
+// validRole reports whether the access policy defines role
+func validRole(role string) bool {
+	return policy().hasRole(role)
+}
+
+// roleFor maps the groups of an ID token to the strongest role; ok is false without a role
+func (p *oidcProvider) roleFor(claims jwt.MapClaims) (string, bool) {
+	var groups []string
+	switch value := claims[p.GroupsClaim].(type) {
+	case []interface{}:
+		for _, group := range value {
+			if name, ok := group.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+	case string:
+		groups = strings.Fields(value)
+	}
+	role := ""
+	for _, group := range groups {
+		// Keycloak reports group paths such as "/shop-admins"
+		mapped, ok := p.RoleMap[group]
+		if !ok {
+			mapped, ok = p.RoleMap[strings.TrimPrefix(group, "/")]
+		}
+		if !ok {
+			continue
+		}
+		if role == "" || policy().roleRank(mapped) > policy().roleRank(role) {
+			role = mapped
+		}
+	}
+	if role == "" {
+		role = p.DefaultRole
+	}
+	return role, role != ""
+}
+
+// discover fetches and caches the provider's openid-configuration
+func (p *oidcProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var discovery oidcDiscovery
+	if err := getJSON(p.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %v", err)
+	}
+	if discovery.Issuer != p.Issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", discovery.Issuer, p.Issuer)
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// publicKey returns the signing key kid, refetching the JWKS at most once a minute for unknown keys
+func (p *oidcProvider) publicKey(kid string) (*rsa.PublicKey, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	p.keysFetchedAt = time.Now()
+	if err := getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %v", err)
+	}
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(key.N)
+		e, errE := base64.RawURLEncoding.DecodeString(key.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		p.keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token
+func (p *oidcProvider) verifyIDToken(raw, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.publicKey(kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+	if claims["nonce"] != nonce {
+		return nil, fmt.Errorf("invalid ID token: nonce mismatch")
+	}
+	return claims, nil
+}
+
+// exchangeCode trades an authorization code for the ID token
+func (p *oidcProvider) exchangeCode(code string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", p.RedirectURL)
+	data.Set("client_id", p.ClientID)
+	data.Set("client_secret", p.ClientSecret)
+	resp, err := oidcHTTPClient.PostForm(discovery.TokenEndpoint, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange code: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %d", resp.StatusCode)
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.IDToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+	return token.IDToken, nil
+}
+
+// getJSON fetches url and decodes the JSON answer into out
+func getJSON(url string, out interface{}) error {
+	resp, err := oidcHTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// adminSessionBackend accepts the staff session cookie issued after an OIDC login.
+// The cookie is SameSite=Lax, so cross-site forms cannot use it for admin writes.
+type adminSessionBackend struct {
+	sessions service.SessionService
+}
+
+func (adminSessionBackend) Name() string { return "oidc" }
+
+func (b adminSessionBackend) Authenticate(r *http.Request) (adminIdentity, bool, error) {
+	if _, err := r.Cookie(adminSessionCookieName); err != nil {
+		return adminIdentity{}, false, nil
+	}
+	_, session, err := sessionFromCookie(r, b.sessions, adminSessionCookieName)
+	if err != nil || session.Kind != service.SessionAdmin || !validRole(session.Role) {
+		return adminIdentity{}, true, fmt.Errorf("Invalid or expired admin session, please log in again")
+	}
+	return adminIdentity{
+		Subject:      session.Subject,
+		Email:        session.Email,
+		Role:         session.Role,
+		Backend:      "oidc",
+		SecondFactor: session.SecondFactor,
+	}, true, nil
+}
+
+// randomToken returns a random hex string for OAuth state and nonce values
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// registerAdminLoginRoutes mounts the staff OIDC login
+func registerAdminLoginRoutes(r chi.Router, sessions service.SessionService) {
+	r.Get("/admin/login", func(w http.ResponseWriter, r *http.Request) {
+		if adminOIDC == nil {
+			writeError(w, "OIDC login is not configured", http.StatusNotFound)
+			return
+		}
+		discovery, err := adminOIDC.discover()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		state, nonce := randomToken(), randomToken()
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"state": state,
+			"nonce": nonce,
+			"exp":   time.Now().Add(oidcStateTTL).Unix(),
+		}).SignedString(sessionSecret)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookieName,
+			Value:    signed,
+			Path:     "/admin",
+			MaxAge:   int(oidcStateTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(publicBaseURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		params := url.Values{}
+		params.Set("client_id", adminOIDC.ClientID)
+		params.Set("redirect_uri", adminOIDC.RedirectURL)
+		params.Set("response_type", "code")
+		params.Set("scope", strings.Join(adminOIDC.Scopes, " "))
+		params.Set("state", state)
+		params.Set("nonce", nonce)
+		http.Redirect(w, r, discovery.AuthorizationEndpoint+"?"+params.Encode(), http.StatusFound)
+	})
+
+	r.Get("/admin/callback", func(w http.ResponseWriter, r *http.Request) {
+		if adminOIDC == nil {
+			writeError(w, "OIDC login is not configured", http.StatusNotFound)
+			return
+		}
+		cookie, err := r.Cookie(oidcStateCookieName)
+		if err != nil {
+			writeError(w, "Login expired, please start again", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookieName, Value: "", Path: "/admin", MaxAge: -1, HttpOnly: true})
+		pending := jwt.MapClaims{}
+		if _, err := jwt.ParseWithClaims(cookie.Value, pending, func(t *jwt.Token) (interface{}, error) {
+			return sessionSecret, nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()})); err != nil {
+			writeError(w, "Login expired, please start again", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("state") != pending["state"] {
+			writeError(w, "Invalid state parameter", http.StatusBadRequest)
+			return
+		}
+		if errParam := r.URL.Query().Get("error"); errParam != "" {
+			writeError(w, "Login refused by the identity provider: "+errParam, http.StatusUnauthorized)
+			return
+		}
+
+		rawIDToken, err := adminOIDC.exchangeCode(r.URL.Query().Get("code"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		nonce, _ := pending["nonce"].(string)
+		claims, err := adminOIDC.verifyIDToken(rawIDToken, nonce)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		role, ok := adminOIDC.roleFor(claims)
+		if !ok {
+			writeError(w, "Your account is not in a group allowed to use the admin dashboard", http.StatusForbidden)
+			return
+		}
+		subject, _ := claims.GetSubject()
+		email, _ := claims["email"].(string)
+
+		if err := startSession(w, r, sessions, adminSessionCookieName, service.UserSession{
+			Kind:    service.SessionAdmin,
+			Subject: subject,
+			Email:   email,
+			Role:    role,
+		}); err != nil {
+			writeError(w, fmt.Sprintf("Failed to create session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, envOr("OIDC_POST_LOGIN_URL", "/admin/me"), http.StatusFound)
+	})
+
+	r.Post("/admin/logout", func(w http.ResponseWriter, r *http.Request) {
+		endSession(w, r, sessions, adminSessionCookieName)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// The identity and role the admin dashboard is used with
+	r.With(adminOnly).Get("/admin/me", func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := r.Context().Value(adminContextKey{}).(adminIdentity)
+		writeJSON(w, r, http.StatusOK, identity)
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"convertyApi/service"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestOIDCVerifyAndRoles(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/keys"})
+		case "/keys":
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kid": "k1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		}
+	}))
+	defer server.Close()
+
+	provider := &oidcProvider{
+		Issuer:      server.URL,
+		ClientID:    "dashboard",
+		GroupsClaim: "groups",
+		RoleMap:     map[string]string{"shop-admins": roleAdmin, "support": roleViewer},
+	}
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	valid := jwt.MapClaims{
+		"iss":    server.URL,
+		"aud":    "dashboard",
+		"sub":    "u1",
+		"nonce":  "n1",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"groups": []string{"staff", "/support"},
+	}
+
+	claims, err := provider.verifyIDToken(sign(valid), "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role, ok := provider.roleFor(claims); !ok || role != roleViewer {
+		t.Errorf("got role %q, want %q", role, roleViewer)
+	}
+
+	if _, err := provider.verifyIDToken(sign(valid), "other"); err == nil {
+		t.Error("nonce mismatch was accepted")
+	}
+	wrongAudience := jwt.MapClaims{}
+	for k, v := range valid {
+		wrongAudience[k] = v
+	}
+	wrongAudience["aud"] = "someone-else"
+	if _, err := provider.verifyIDToken(sign(wrongAudience), "n1"); err == nil {
+		t.Error("foreign audience was accepted")
+	}
+
+	if _, ok := provider.roleFor(jwt.MapClaims{"groups": []interface{}{"staff"}}); ok {
+		t.Error("unmapped groups were given a role")
+	}
+	if role, _ := provider.roleFor(jwt.MapClaims{"groups": []interface{}{"support", "shop-admins"}}); role != roleAdmin {
+		t.Errorf("got role %q, want the strongest role %q", role, roleAdmin)
+	}
+}
+
+func TestAdminOnlyViewerIsReadOnly(t *testing.T) {
+	previous := adminBackends
+	defer func() { adminBackends = previous }()
+	sessionSecret = []byte("0123456789abcdef0123456789abcdef")
+	sessions := newMemorySessions()
+	adminBackends = []adminAuthBackend{apiKeyBackend{key: "secret"}, adminSessionBackend{sessions: sessions}}
+
+	login := httptest.NewRecorder()
+	err := startSession(login, httptest.NewRequest(http.MethodGet, "/admin/callback", nil), sessions, adminSessionCookieName,
+		service.UserSession{Kind: service.SessionAdmin, Subject: "u1", Email: "support@example.com", Role: roleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer := login.Result().Cookies()[0].Value
+	revoked := httptest.NewRecorder()
+	startSession(revoked, httptest.NewRequest(http.MethodGet, "/admin/callback", nil), sessions, adminSessionCookieName,
+		service.UserSession{Kind: service.SessionAdmin, Subject: "u2", Role: roleAdmin})
+	sessions.RevokeSubject(service.SessionAdmin, "u2", "test")
+	revokedAdmin := revoked.Result().Cookies()[0].Value
+	unverified := httptest.NewRecorder()
+	startSession(unverified, httptest.NewRequest(http.MethodGet, "/admin/callback", nil), sessions, adminSessionCookieName,
+		service.UserSession{Kind: service.SessionAdmin, Subject: "u3", Role: roleAdmin})
+	pendingAdmin := unverified.Result().Cookies()[0].Value
+	verified := httptest.NewRecorder()
+	startSession(verified, httptest.NewRequest(http.MethodGet, "/admin/callback", nil), sessions, adminSessionCookieName,
+		service.UserSession{Kind: service.SessionAdmin, Subject: "u4", Role: roleAdmin})
+	verifiedAdmin := verified.Result().Cookies()[0].Value
+	claims, _ := parseSession(verifiedAdmin)
+	sessions.MarkSecondFactor(claims.ID)
+	handler := adminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(adminActor(r)))
+	}))
+
+	for _, tc := range []struct {
+		name, method, key, session string
+		want                       int
+	}{
+		{"api key", http.MethodPost, "secret", "", http.StatusOK},
+		{"wrong api key", http.MethodGet, "nope", "", http.StatusUnauthorized},
+		{"viewer read", http.MethodGet, "", viewer, http.StatusOK},
+		{"viewer write", http.MethodPost, "", viewer, http.StatusForbidden},
+		{"forged session", http.MethodGet, "", viewer + "x", http.StatusUnauthorized},
+		{"revoked session", http.MethodGet, "", revokedAdmin, http.StatusUnauthorized},
+		{"admin without second factor", http.MethodGet, "", pendingAdmin, http.StatusForbidden},
+		{"admin with second factor", http.MethodPost, "", verifiedAdmin, http.StatusOK},
+		{"anonymous", http.MethodGet, "", "", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(tc.method, "/api/v1/admin/tenants", nil)
+		if tc.key != "" {
+			req.Header.Set("X-Admin-Key", tc.key)
+		}
+		if tc.session != "" {
+			req.AddCookie(&http.Cookie{Name: adminSessionCookieName, Value: tc.session})
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, rec.Code, tc.want)
+		}
+		if tc.name == "viewer read" && rec.Body.String() != "support@example.com" {
+			t.Errorf("viewer actor is %q", rec.Body.String())
+		}
+	}
+}
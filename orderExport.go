@@ -0,0 +1,174 @@
+package main
+
+import (
+	"convertyApi/service"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// orderExportJobType is the job queue type of background order exports
+const orderExportJobType = "export_orders"
+
+// orderExportSyncRange is the widest date range exported inline; wider or open ranges run as a job
+var orderExportSyncRange = 31 * 24 * time.Hour
+
+// orderExportDir holds the files written by background exports
+var orderExportDir = filepath.Join(os.TempDir(), "convertyapi-exports")
+
+// orderExportJobPayload is a background export for one tenant and Converty token
+type orderExportJobPayload struct {
+	tenantJobPayload
+	TokenUserID string                   `json:"token_user_id"`
+	Query       service.OrderExportQuery `json:"query"`
+}
+
+// orderExportJobResult locates the file of a finished background export
+type orderExportJobResult struct {
+	Tenant string `json:"tenant"`
+	File   string `json:"file"`
+	Format string `json:"format"`
+	Rows   int    `json:"rows"`
+}
+
+// registerOrderExportJob registers the handler that writes large exports to disk
+func registerOrderExportJob(jobService service.JobService, dataService service.DataService, tenantService service.TenantService) {
+	jobService.RegisterHandler(orderExportJobType, func(payload json.RawMessage) (interface{}, error) {
+		var input orderExportJobPayload
+		if err := json.Unmarshal(payload, &input); err != nil {
+			return nil, fmt.Errorf("invalid payload: %v", err)
+		}
+		tenant, err := tenantService.GetTenant(input.Tenant)
+		if err != nil {
+			return nil, err
+		}
+		tenant.TokenUserID = input.TokenUserID
+
+		if err := os.MkdirAll(orderExportDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create export directory: %v", err)
+		}
+		name := orderExportFileName(input.Query.Format)
+		file, err := os.Create(filepath.Join(orderExportDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create export file: %v", err)
+		}
+		defer file.Close()
+
+		rows, err := service.ExportOrders(dataService.ForTenant(tenant), input.Query, file)
+		if err != nil {
+			os.Remove(file.Name())
+			return nil, err
+		}
+		return orderExportJobResult{Tenant: tenant.Slug, File: name, Format: input.Query.Format, Rows: rows}, nil
+	})
+}
+
+// registerOrderExportRoutes mounts the order export and the download of background exports
+func registerOrderExportRoutes(r chi.Router, dataService service.DataService, jobService service.JobService) {
+	// /api/v1/orders/export?format=csv|xlsx&status=delivered&from=2025-01-01&to=2025-02-01[&async=true]; to is exclusive
+	r.Get("/api/v1/orders/export", func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseOrderExportQuery(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if r.URL.Query().Get("async") == "true" || query.From == nil || query.To == nil || query.To.Sub(*query.From) > orderExportSyncRange {
+			job, err := jobService.Enqueue(tenantFrom(r).ID, orderExportJobType, orderExportJobPayload{
+				tenantJobPayload: tenantJobPayload{Tenant: tenantFrom(r).Slug},
+				TokenUserID:      tokenUserFor(r),
+				Query:            query,
+			})
+			if err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Location", fmt.Sprintf("/api/v1/orders/export/%d", job.ID))
+			writeJSON(w, r, http.StatusAccepted, job)
+			return
+		}
+
+		w.Header().Set("Content-Type", service.ExportContentType(query.Format))
+		w.Header().Set("Content-Disposition", "attachment; filename="+orderExportFileName(query.Format))
+		if _, err := service.ExportOrders(tenantData(r, dataService), query, w); err != nil {
+			// Headers are gone once rows were streamed; the truncated file is all the client gets
+			log.Printf("Order export failed: %v", err)
+		}
+	})
+
+	r.Get("/api/v1/orders/export/{jobId}", func(w http.ResponseWriter, r *http.Request) {
+		var id uint
+		if _, err := fmt.Sscanf(chi.URLParam(r, "jobId"), "%d", &id); err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		job, err := jobService.GetJob(tenantFrom(r).ID, id)
+		if err != nil || job.Type != orderExportJobType {
+			writeError(w, "Export not found", http.StatusNotFound)
+			return
+		}
+		if job.Status != service.JobCompleted {
+			writeJSON(w, r, http.StatusAccepted, job)
+			return
+		}
+		var result orderExportJobResult
+		if err := json.Unmarshal(job.Result, &result); err != nil || result.Tenant != tenantFrom(r).Slug {
+			writeError(w, "Export not found", http.StatusNotFound)
+			return
+		}
+		file, err := os.Open(filepath.Join(orderExportDir, filepath.Base(result.File)))
+		if err != nil {
+			writeError(w, "Export file is no longer available", http.StatusGone)
+			return
+		}
+		defer file.Close()
+		w.Header().Set("Content-Type", service.ExportContentType(result.Format))
+		w.Header().Set("Content-Disposition", "attachment; filename="+result.File)
+		io.Copy(w, file)
+	})
+}
+
+// parseOrderExportQuery reads the format, status and date range of an export request
+func parseOrderExportQuery(r *http.Request) (service.OrderExportQuery, error) {
+	params := r.URL.Query()
+	query := service.OrderExportQuery{Format: params.Get("format"), Status: params.Get("status")}
+	if query.Format == "" {
+		query.Format = service.ExportCSV
+	}
+	if !service.ValidExportFormat(query.Format) {
+		return query, fmt.Errorf("unsupported export format %q, expected csv or xlsx", query.Format)
+	}
+	for name, target := range map[string]**time.Time{"from": &query.From, "to": &query.To} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			if parsed, err = time.Parse(time.RFC3339, value); err != nil {
+				return query, fmt.Errorf("invalid %s date %q, expected YYYY-MM-DD or RFC 3339", name, value)
+			}
+		}
+		*target = &parsed
+	}
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		return query, fmt.Errorf("from must be before to")
+	}
+	return query, nil
+}
+
+// orderExportFileName names an export file uniquely
+func orderExportFileName(format string) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("orders-%s-%s.%s", time.Now().Format("20060102-150405"), hex.EncodeToString(suffix), format)
+}
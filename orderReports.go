@@ -0,0 +1,95 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// registerOrderReportRoutes mounts the order reports, which read the local copy of the orders
+func registerOrderReportRoutes(r chi.Router, reportService service.OrderReportService) {
+	// /api/v1/reports/orders?group_by=day|week|status|product&from=2025-01-01&to=2025-02-01&status=delivered&source=chatbot&format=csv
+	r.Get("/api/v1/reports/orders", func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseOrderReportQuery(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		report, err := reportService.OrderReport(tenantFrom(r).ID, query)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", orderReportFileName(report)))
+			writeOrderReportCSV(w, report)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, report)
+	})
+}
+
+// parseOrderReportQuery reads the grouping, filters and date range of an order report request
+func parseOrderReportQuery(r *http.Request) (service.OrderReportQuery, error) {
+	params := r.URL.Query()
+	query := service.OrderReportQuery{GroupBy: params.Get("group_by"), Status: params.Get("status"), Source: params.Get("source")}
+	if query.GroupBy == "" {
+		query.GroupBy = service.GroupByDay
+	}
+	if !service.ValidOrderGrouping(query.GroupBy) {
+		return query, fmt.Errorf("invalid group_by %q, expected day, week, status or product", query.GroupBy)
+	}
+	if format := params.Get("format"); format != "" && format != "json" && format != "csv" {
+		return query, fmt.Errorf("unsupported report format %q, expected json or csv", format)
+	}
+	for name, target := range map[string]**time.Time{"from": &query.From, "to": &query.To} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			if parsed, err = time.Parse(time.RFC3339, value); err != nil {
+				return query, fmt.Errorf("invalid %s date %q, expected YYYY-MM-DD or RFC 3339", name, value)
+			}
+		}
+		*target = &parsed
+	}
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		return query, fmt.Errorf("from must be before to")
+	}
+	return query, nil
+}
+
+// orderReportFileName names the CSV file of an order report
+func orderReportFileName(report service.OrderReport) string {
+	name := "orders-by-" + report.GroupBy
+	if report.From != nil {
+		name += "-from-" + report.From.Format("20060102")
+	}
+	if report.To != nil {
+		name += "-to-" + report.To.Format("20060102")
+	}
+	return name + ".csv"
+}
+
+// writeOrderReportCSV writes one line per group and currency followed by a totals line per currency
+func writeOrderReportCSV(w io.Writer, report service.OrderReport) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{report.GroupBy, "name", "currency", "orders", "quantity", "gross", "revenue"})
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	for _, row := range report.Rows {
+		cw.Write([]string{row.Group, row.Name, row.Currency, strconv.FormatInt(row.Orders, 10), strconv.FormatInt(row.Quantity, 10), money(row.Gross), money(row.Revenue)})
+	}
+	for _, total := range report.Totals {
+		cw.Write([]string{"TOTAL", "", total.Currency, strconv.FormatInt(total.Orders, 10), "", money(total.Gross), money(total.Revenue)})
+	}
+	cw.Flush()
+}
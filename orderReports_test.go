@@ -0,0 +1,42 @@
+package main
+
+import (
+	"convertyApi/service"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseOrderReportQuery(t *testing.T) {
+	query, err := parseOrderReportQuery(httptest.NewRequest("GET", "/api/v1/reports/orders?from=2025-01-01&to=2025-02-01&source=chatbot", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.GroupBy != service.GroupByDay || query.From == nil || query.To == nil || query.Source != "chatbot" {
+		t.Fatalf("unexpected query %+v", query)
+	}
+	for _, params := range []string{"group_by=month", "from=yesterday", "from=2025-02-01&to=2025-01-01", "format=xml"} {
+		if _, err := parseOrderReportQuery(httptest.NewRequest("GET", "/api/v1/reports/orders?"+params, nil)); err == nil {
+			t.Errorf("%s: expected an error", params)
+		}
+	}
+}
+
+func TestWriteOrderReportCSV(t *testing.T) {
+	report := service.OrderReport{
+		GroupBy: service.GroupByProduct,
+		Rows:    []service.OrderReportRow{{Group: "p-1", Name: "Lamp", Currency: "TND", Orders: 2, Quantity: 3, Gross: 90, Revenue: 60}},
+		Totals:  []service.OrderReportRow{{Group: "total", Currency: "TND", Orders: 2, Gross: 90, Revenue: 60}},
+	}
+	var out strings.Builder
+	writeOrderReportCSV(&out, report)
+	want := "product,name,currency,orders,quantity,gross,revenue\n" +
+		"p-1,Lamp,TND,2,3,90.000,60.000\n" +
+		"TOTAL,,TND,2,,90.000,60.000\n"
+	if out.String() != want {
+		t.Fatalf("unexpected CSV:\n%s", out.String())
+	}
+	if name := orderReportFileName(report); name != "orders-by-product.csv" {
+		t.Fatalf("unexpected file name %q", name)
+	}
+}
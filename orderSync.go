@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// orderSyncJobType is the job queue type of the incremental Converty order mirror
+const orderSyncJobType = "sync_orders"
+
+// orderSyncMaxPages bounds the upstream pages one tenant sync reads; the first sync of a large store
+// catches up over several runs
+var orderSyncMaxPages = 50
+
+// orderMirrorFallback serves order listings from the mirror when Converty fails
+var orderMirrorFallback = true
+
+// orderSourceHeader tells clients an order response came from the local mirror
+const orderSourceHeader = "X-Orders-Source"
+
+// loadOrderMirror reads ORDER_SYNC_MAX_PAGES and ORDER_MIRROR_FALLBACK from the environment
+func loadOrderMirror() error {
+	if value := envOr("ORDER_SYNC_MAX_PAGES", ""); value != "" {
+		pages, err := strconv.Atoi(value)
+		if err != nil || pages < 1 {
+			return errors.New("ORDER_SYNC_MAX_PAGES must be a positive integer")
+		}
+		orderSyncMaxPages = pages
+	}
+	if value := envOr("ORDER_MIRROR_FALLBACK", ""); value != "" {
+		fallback, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("ORDER_MIRROR_FALLBACK must be true or false")
+		}
+		orderMirrorFallback = fallback
+	}
+	return nil
+}
+
+// syncTenantOrders runs one incremental sync per tenant; a failing tenant is logged and skipped
+func syncTenantOrders(tenants []service.Tenant, dataService service.DataService, mirror service.OrderMirrorService) map[string]service.OrderSyncResult {
+	synced := make(map[string]service.OrderSyncResult)
+	for _, tenant := range tenants {
+		result, err := mirror.Sync(tenant.ID, dataService.ForTenant(tenant), orderSyncMaxPages)
+		if err != nil {
+			// One tenant without a Converty token must not block the others
+			log.Printf("Order sync for tenant %s failed: %v", tenant.Slug, err)
+			continue
+		}
+		synced[tenant.Slug] = result
+	}
+	return synced
+}
+
+// registerOrderSyncJob registers the handler that mirrors changed Converty orders per tenant
+func registerOrderSyncJob(jobService service.JobService, dataService service.DataService, tenantService service.TenantService, mirror service.OrderMirrorService) {
+	jobService.RegisterHandler(orderSyncJobType, func(payload json.RawMessage) (interface{}, error) {
+		tenants, err := jobTenants(tenantService, payload)
+		if err != nil {
+			return nil, err
+		}
+		return syncTenantOrders(tenants, dataService, mirror), nil
+	})
+}
+
+// runOrderSync backs -sync-orders: it syncs every tenant once, or every interval when one is given
+func runOrderSync(dataService service.DataService, tenantService service.TenantService, mirror service.OrderMirrorService, interval time.Duration) {
+	if err := waitForDB(context.Background(), db, durationEnv("DB_CONNECT_TIMEOUT", dbConnectWindow)); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	if err := migrateDB(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	for {
+		tenants, err := jobTenants(tenantService, nil)
+		if err != nil {
+			log.Fatalf("Failed to list tenants: %v", err)
+		}
+		for slug, result := range syncTenantOrders(tenants, dataService, mirror) {
+			log.Printf("Synced %d orders for tenant %s over %d pages, cursor %s", result.Synced, slug, result.Pages, result.Cursor.Format(time.RFC3339))
+		}
+		if interval <= 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+// registerOrderSyncRoutes mounts the sync trigger and the cursor of the tenant's order mirror
+func registerOrderSyncRoutes(r chi.Router, jobService service.JobService, mirror service.OrderMirrorService) {
+	r.Get("/api/v1/orders/sync", func(w http.ResponseWriter, r *http.Request) {
+		state, err := mirror.SyncState(tenantFrom(r).ID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, state)
+	})
+
+	r.Post("/api/v1/orders/sync", func(w http.ResponseWriter, r *http.Request) {
+		job, err := jobService.Enqueue(tenantFrom(r).ID, orderSyncJobType, tenantJobPayload{Tenant: tenantFrom(r).Slug})
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusAccepted, job)
+	})
+}
+
+// listOrders reads an order listing from Converty, or from the mirror on ?mirror=true or when Converty fails
+func listOrders(w http.ResponseWriter, r *http.Request, dataService service.DataService, mirror service.OrderMirrorService, query service.CustomerOrderQuery) ([]service.Order, error) {
+	if r.URL.Query().Get("mirror") == "true" {
+		w.Header().Set(orderSourceHeader, "mirror")
+		return mirror.ListOrders(tenantFrom(r).ID, query)
+	}
+	orders, err := tenantData(r, dataService).ListOrders(query)
+	if err == nil || !orderMirrorFallback {
+		return orders, err
+	}
+	local, localErr := mirror.ListOrders(tenantFrom(r).ID, query)
+	if localErr != nil {
+		log.Printf("Order mirror fallback failed: %v", localErr)
+		return nil, err
+	}
+	log.Printf("Serving orders from the mirror: %v", err)
+	w.Header().Set(orderSourceHeader, "mirror")
+	return local, nil
+}
+
+// getOrder is listOrders for a single order
+func getOrder(w http.ResponseWriter, r *http.Request, dataService service.DataService, mirror service.OrderMirrorService, id string) (service.Order, error) {
+	if r.URL.Query().Get("mirror") == "true" {
+		w.Header().Set(orderSourceHeader, "mirror")
+		return mirror.GetOrder(tenantFrom(r).ID, id)
+	}
+	order, err := tenantData(r, dataService).GetOrder(id)
+	if err == nil || !orderMirrorFallback {
+		return order, err
+	}
+	local, localErr := mirror.GetOrder(tenantFrom(r).ID, id)
+	if localErr != nil {
+		return service.Order{}, err
+	}
+	log.Printf("Serving order %s from the mirror: %v", id, err)
+	w.Header().Set(orderSourceHeader, "mirror")
+	return local, nil
+}
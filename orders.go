@@ -0,0 +1,169 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// loadOrderDedupDefaults reads ORDER_DEDUP_MODE (off, flag or reject) and ORDER_DEDUP_WINDOW, the
+// duplicate order handling of tenants without their own settings
+func loadOrderDedupDefaults() (service.OrderDedupSettings, error) {
+	defaults := service.OrderDedupSettings{
+		Mode:          envOr("ORDER_DEDUP_MODE", service.DedupFlag),
+		WindowMinutes: int(durationEnv("ORDER_DEDUP_WINDOW", 24*time.Hour) / time.Minute),
+	}
+	switch defaults.Mode {
+	case service.DedupOff, service.DedupFlag, service.DedupReject:
+	default:
+		return defaults, fmt.Errorf("invalid ORDER_DEDUP_MODE %q, expected off, flag or reject", os.Getenv("ORDER_DEDUP_MODE"))
+	}
+	if defaults.Mode != service.DedupOff && defaults.WindowMinutes <= 0 {
+		return defaults, fmt.Errorf("ORDER_DEDUP_WINDOW must be at least a minute")
+	}
+	return defaults, nil
+}
+
+// registerOrderRoutes mounts order creation, which the order_creation flag can switch off per tenant, and cancellation
+func registerOrderRoutes(r chi.Router, dataService service.DataService, orderService service.OrderService, auditService service.AuditService, addressService service.AddressService, reservationService service.ReservationService, flags service.FeatureFlagService) {
+	// The chatbot submits orders here; repeats of a recent order are rejected with 409 or flagged for review.
+	// With ?dry_run=true (or DRY_RUN=true) the order is checked and the Converty request returned, not sent.
+	// Orders without an address go to the customer's default address. The ordered quantities are taken
+	// from the synced stock, consuming the customer's reservations; a shortage answers 409.
+	r.With(requireFlag(flags, service.FlagOrderCreation)).Post("/api/v1/orders", func(w http.ResponseWriter, r *http.Request) {
+		var input service.NewOrder
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		if _, err := addressService.CompleteOrder(tenantFrom(r).ID, &input); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if dryRunRequested(r) {
+			previewOrder(w, r, tenantData(r, dataService), orderService, auditService, input)
+			return
+		}
+		claim, err := reservationService.ClaimStock(tenantFrom(r).ID, input)
+		if err != nil {
+			writeReservationError(w, r, err)
+			return
+		}
+		created, err := orderService.CreateOrder(tenantData(r, dataService), tenantFrom(r).ID, input)
+		// An order rejected or failed upstream gives its stock and reservations back
+		if settleErr := reservationService.SettleClaim(claim, created.Order.ID); settleErr != nil {
+			log.Printf("Failed to settle the stock claim of order %q: %v", created.Order.ID, settleErr)
+		}
+		switch {
+		case writeOrderError(w, r, err):
+			return
+		case err != nil && created.Order.ID != "":
+			// Created upstream but not flagged; report success so the order is not submitted twice
+			writeJSON(w, r, http.StatusCreated, created)
+			return
+		case err != nil:
+			writeUpstreamError(w, r, err)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/orders/"+created.Order.ID)
+		writeJSON(w, r, http.StatusCreated, created)
+	})
+
+	// The chatbot's "cancel my order" intent: {"reason": "...", "user_id": 7, "notify": true}.
+	// Orders that already left the store answer 409.
+	r.Post("/api/v1/orders/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		var input service.CancelOrderRequest
+		if r.ContentLength != 0 && !bindJSON(w, r, &input) {
+			return
+		}
+		if input.Actor == "" {
+			input.Actor = requestActor(r)
+		}
+		cancellation, err := orderService.CancelOrder(tenantData(r, dataService), tenantFrom(r).ID, chi.URLParam(r, "id"), input)
+		switch {
+		case errors.Is(err, service.ErrOrderNotCancellable):
+			writeError(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil && cancellation.Order.ID != "":
+			// Cancelled upstream; the caller must not retry
+			log.Printf("Order %s: %v", cancellation.Order.ID, err)
+		case err != nil:
+			writeUpstreamError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, cancellation)
+	})
+}
+
+// previewOrder answers a dry run with the Converty request the order would send and records it in the audit trail
+func previewOrder(w http.ResponseWriter, r *http.Request, dataService service.DataService, orderService service.OrderService, auditService service.AuditService, input service.NewOrder) {
+	preview, err := orderService.PreviewOrder(dataService, tenantFrom(r).ID, input)
+	detail := map[string]interface{}{"order": input}
+	if err != nil {
+		detail["error"] = err.Error()
+	} else {
+		detail["request"] = preview.Request
+		detail["duplicates"] = preview.Duplicates
+	}
+	if _, auditErr := auditService.Record(tenantFrom(r).ID, requestActor(r), service.AuditOrderDryRun, detail); auditErr != nil {
+		log.Printf("Failed to audit order dry run: %v", auditErr)
+	}
+	w.Header().Set("X-Dry-Run", "true")
+	if writeOrderError(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, preview)
+}
+
+// writeOrderError answers a rejected duplicate with 409 and an invalid order with 400
+func writeOrderError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var duplicate *service.DuplicateOrderError
+	switch {
+	case errors.As(err, &duplicate):
+		writeJSON(w, r, http.StatusConflict, map[string]interface{}{
+			"error":      service.ErrDuplicateOrder.Error(),
+			"message":    err.Error(),
+			"duplicates": duplicate.Duplicates,
+		})
+		return true
+	case errors.Is(err, service.ErrInvalidOrder):
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return true
+	}
+	return false
+}
+
+// registerOrderDedupAdminRoutes mounts the duplicate order settings of the request tenant
+func registerOrderDedupAdminRoutes(r chi.Router, orderService service.OrderService) {
+	r.Get("/order-dedup", func(w http.ResponseWriter, r *http.Request) {
+		settings, err := orderService.DedupSettings(tenantFrom(r).ID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, settings)
+	})
+
+	r.Put("/order-dedup", func(w http.ResponseWriter, r *http.Request) {
+		var input service.OrderDedupSettings
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		input.TenantID = tenantFrom(r).ID
+		settings, err := orderService.SetDedupSettings(input)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, settings)
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultOutboxSubject is the Kafka topic and NATS subject used when none is configured
+const defaultOutboxSubject = "convertyapi.events"
+
+// loadOutboxDestinations reads the outbox destinations and enables the outbox when there is one:
+// OUTBOX_WEBHOOK_URL (signed with OUTBOX_WEBHOOK_SECRET), OUTBOX_KAFKA_REST_URL with OUTBOX_KAFKA_TOPIC,
+// and OUTBOX_NATS_URL with OUTBOX_NATS_SUBJECT
+func loadOutboxDestinations() ([]service.OutboxDestination, error) {
+	var destinations []service.OutboxDestination
+	if url := os.Getenv("OUTBOX_WEBHOOK_URL"); url != "" {
+		destinations = append(destinations, service.NewWebhookDestination(url, os.Getenv("OUTBOX_WEBHOOK_SECRET")))
+	}
+	if url := os.Getenv("OUTBOX_KAFKA_REST_URL"); url != "" {
+		destinations = append(destinations, service.NewKafkaDestination(url, envOr("OUTBOX_KAFKA_TOPIC", defaultOutboxSubject)))
+	}
+	if url := os.Getenv("OUTBOX_NATS_URL"); url != "" {
+		nats, err := service.NewNATSDestination(url, envOr("OUTBOX_NATS_SUBJECT", defaultOutboxSubject))
+		if err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, nats)
+	}
+	service.OutboxEnabled = len(destinations) > 0
+	return destinations, nil
+}
+
+// outboxMaxAttempts reads OUTBOX_MAX_ATTEMPTS, the failed deliveries before an event is dead-lettered
+func outboxMaxAttempts() (int, error) {
+	value := os.Getenv("OUTBOX_MAX_ATTEMPTS")
+	if value == "" {
+		return 10, nil
+	}
+	attempts, err := strconv.Atoi(value)
+	if err != nil || attempts < 1 {
+		return 0, fmt.Errorf("OUTBOX_MAX_ATTEMPTS must be a positive integer, got %q", value)
+	}
+	return attempts, nil
+}
+
+// registerOutboxAdminRoutes lets operators inspect the outbox and replay dead-lettered events
+func registerOutboxAdminRoutes(r chi.Router, outboxService service.OutboxService) {
+	// /api/v1/admin/outbox?status=dead&type=record.created&limit=50
+	r.Get("/outbox", func(w http.ResponseWriter, r *http.Request) {
+		filter := service.OutboxFilter{Status: r.URL.Query().Get("status"), Type: r.URL.Query().Get("type")}
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				writeError(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = limit
+		}
+		events, err := outboxService.ListEvents(filter)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, events)
+	})
+
+	r.Post("/outbox/{id}/retry", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		event, err := outboxService.Retry(uint(id))
+		if errors.Is(err, service.ErrOutboxEventNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, event)
+	})
+
+	// Delivers one batch now instead of waiting for the dispatcher
+	r.Post("/outbox/dispatch", func(w http.ResponseWriter, r *http.Request) {
+		result, err := outboxService.Dispatch()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, result)
+	})
+}
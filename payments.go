@@ -0,0 +1,140 @@
+package main
+
+import (
+	"convertyApi/api"
+	"convertyApi/service"
+	"crypto/hmac"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// paymentWebhookPath receives the provider notifications under /<provider>; it is public and
+// authenticated by signature
+const paymentWebhookPath = "/api/v1/payments/webhooks"
+
+// paymentWebhookPaths are the public notification URLs of the supported providers
+var paymentWebhookPaths = []string{paymentWebhookPath + "/konnect", paymentWebhookPath + "/flouci", paymentWebhookPath + "/stripe"}
+
+// paymentProvidersSigning verify the signature of their own notifications in ParseWebhook; the others
+// only notify a payment reference, so their webhook URL carries a token derived from PAYMENT_WEBHOOK_SECRET
+var paymentProvidersSigning = map[string]bool{"stripe": true}
+
+// paymentWebhookToken is the token in the webhook URL of provider
+func paymentWebhookToken(secret, provider string) string {
+	return service.SignOutboxBody(secret, []byte("payment-webhook:"+provider))
+}
+
+// paymentWebhookURL is the notification URL given to provider when a link is created
+func paymentWebhookURL(provider string) string {
+	webhookURL := publicBaseURL + paymentWebhookPath + "/" + url.PathEscape(provider)
+	if secret := os.Getenv("PAYMENT_WEBHOOK_SECRET"); secret != "" && !paymentProvidersSigning[provider] {
+		webhookURL += "?token=" + paymentWebhookToken(secret, provider)
+	}
+	return webhookURL
+}
+
+// verifyPaymentWebhook authenticates a notification of a provider that does not sign its own
+func verifyPaymentWebhook(r *http.Request, provider string) (int, string) {
+	if paymentProvidersSigning[provider] {
+		return 0, ""
+	}
+	secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if secret == "" {
+		return http.StatusServiceUnavailable, "Payment webhooks are not configured: set PAYMENT_WEBHOOK_SECRET"
+	}
+	if !hmac.Equal([]byte(r.URL.Query().Get("token")), []byte(paymentWebhookToken(secret, provider))) {
+		return http.StatusUnauthorized, "Invalid webhook token"
+	}
+	return 0, ""
+}
+
+// loadPaymentProviders registers every payment provider whose credentials are configured
+func loadPaymentProviders(paymentService service.PaymentService) {
+	returnURL := os.Getenv("PAYMENT_RETURN_URL")
+	if returnURL == "" {
+		returnURL = publicBaseURL + "/health"
+	}
+	if apiKey := os.Getenv("KONNECT_API_KEY"); apiKey != "" {
+		paymentService.RegisterProvider(&service.KonnectProvider{
+			APIKey:   apiKey,
+			WalletID: os.Getenv("KONNECT_WALLET_ID"),
+			BaseURL:  envOr("KONNECT_BASE_URL", "https://api.konnect.network/api/v2"),
+		})
+	}
+	if appToken := os.Getenv("FLOUCI_APP_TOKEN"); appToken != "" {
+		paymentService.RegisterProvider(&service.FlouciProvider{
+			AppToken:  appToken,
+			AppSecret: os.Getenv("FLOUCI_APP_SECRET"),
+			BaseURL:   envOr("FLOUCI_BASE_URL", "https://developers.flouci.com/api"),
+			ReturnURL: returnURL,
+		})
+	}
+	if secretKey := os.Getenv("STRIPE_SECRET_KEY"); secretKey != "" {
+		paymentService.RegisterProvider(&service.StripeProvider{
+			SecretKey:     secretKey,
+			WebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+			BaseURL:       envOr("STRIPE_BASE_URL", "https://api.stripe.com/v1"),
+			ReturnURL:     returnURL,
+		})
+	}
+}
+
+// registerPaymentRoutes mounts the payment link endpoints on upstream and the provider webhooks,
+// which need neither tenant credentials nor a Converty token, on r
+func registerPaymentRoutes(r, upstream chi.Router, dataService service.DataService, paymentService service.PaymentService) {
+	upstream.Post("/api/v1/orders/{id}/payment-links", func(w http.ResponseWriter, r *http.Request) {
+		var input paymentLinkRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		order, err := tenantData(r, dataService).GetOrder(chi.URLParam(r, "id"))
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		if order.Currency == "" {
+			order.Currency = currencyConverter.StoreCurrency
+		}
+		link, err := paymentService.CreateLink(tenantFrom(r).ID, input.Provider, order, paymentWebhookURL(input.Provider))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, link)
+	})
+
+	upstream.Get("/api/v1/orders/{id}/payment-links", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		links, err := paymentService.ListLinks(tenantFrom(r).ID, chi.URLParam(r, "id"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, links, params))
+	})
+
+	// Konnect and Flouci notify with GET, Stripe with POST. The link's tenant comes from the
+	// stored payment reference, not from the caller.
+	webhook := func(w http.ResponseWriter, r *http.Request) {
+		provider := chi.URLParam(r, "provider")
+		if status, message := verifyPaymentWebhook(r, provider); status != 0 {
+			writeError(w, message, status)
+			return
+		}
+		link, err := paymentService.HandleWebhook(provider, r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, link)
+	}
+	r.Get(paymentWebhookPath+"/{provider}", webhook)
+	r.Post(paymentWebhookPath+"/{provider}", webhook)
+}
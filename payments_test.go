@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestVerifyPaymentWebhook(t *testing.T) {
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", "")
+	if status, _ := verifyPaymentWebhook(httptest.NewRequest("GET", paymentWebhookPath+"/konnect", nil), "konnect"); status != http.StatusServiceUnavailable {
+		t.Fatalf("unconfigured secret: got %d", status)
+	}
+
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", "whsec")
+	notification, err := url.Parse(paymentWebhookURL("konnect"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notification.RawQuery += "&payment_ref=p-1"
+	if status, message := verifyPaymentWebhook(httptest.NewRequest("GET", notification.String(), nil), "konnect"); status != 0 {
+		t.Fatalf("signed URL refused: %d %s", status, message)
+	}
+	forged := paymentWebhookPath + "/konnect?payment_ref=p-1&token=" + paymentWebhookToken("whsec", "flouci")
+	if status, _ := verifyPaymentWebhook(httptest.NewRequest("GET", forged, nil), "konnect"); status != http.StatusUnauthorized {
+		t.Fatalf("token of another provider: got %d", status)
+	}
+	// Stripe signs its notifications itself
+	if status, _ := verifyPaymentWebhook(httptest.NewRequest("POST", paymentWebhookPath+"/stripe", nil), "stripe"); status != 0 {
+		t.Fatalf("stripe: got %d", status)
+	}
+	if !isPublicPath(paymentWebhookPath + "/stripe") {
+		t.Error("payment webhooks require credentials")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// piiReencryptJobType is the job queue type that moves record details to the current PII key
+const piiReencryptJobType = "reencrypt_pii"
+
+// loadPIIProtection enables encryption of the customer fields of record details when PII_ENCRYPTION_KEY
+// (32 bytes, base64) is set; PII_FIELDS lists the dotted detail paths and defaults to name, phone,
+// phone_number and address. PII_PREVIOUS_ENCRYPTION_KEYS lists the keys rotated out, which still open
+// the values they sealed until -encrypt-pii or the re-encryption job has moved them to the current key.
+func loadPIIProtection() error {
+	encoded := os.Getenv("PII_ENCRYPTION_KEY")
+	if encoded == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("PII_ENCRYPTION_KEY must be base64: %v", err)
+	}
+	var previous [][]byte
+	for _, value := range splitList(os.Getenv("PII_PREVIOUS_ENCRYPTION_KEYS")) {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("PII_PREVIOUS_ENCRYPTION_KEYS must be base64: %v", err)
+		}
+		previous = append(previous, decoded)
+	}
+	fields := service.DefaultPIIFields
+	if value := os.Getenv("PII_FIELDS"); value != "" {
+		fields = splitList(value)
+	}
+	protector, err := service.NewPIIProtector(key, fields, previous...)
+	if err != nil {
+		return err
+	}
+	service.PII = protector
+	log.Printf("Encrypting record detail fields %v with key %s (%d previous keys)", fields, protector.KeyID(), len(previous))
+	return nil
+}
+
+// rotatePIIKey makes a rotated PII_ENCRYPTION_KEY the key of new values and queues the re-encryption
+// of the stored ones; the previous key keeps opening them meanwhile
+func rotatePIIKey(encoded string, jobService service.JobService) error {
+	if service.PII == nil {
+		return fmt.Errorf("PII encryption was not enabled at startup")
+	}
+	if recordStore, _ := loadRecordStore(); recordStore.Store == recordStoreMongo {
+		return fmt.Errorf("records stored in MongoDB cannot be re-encrypted")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("PII_ENCRYPTION_KEY must be base64: %v", err)
+	}
+	previous := service.PII.KeyID()
+	if err := service.PII.Rotate(key); err != nil {
+		return err
+	}
+	job, err := jobService.Enqueue(service.DefaultTenant.ID, piiReencryptJobType, nil)
+	if err != nil {
+		return fmt.Errorf("key %s in force but the re-encryption was not queued: %v", service.PII.KeyID(), err)
+	}
+	log.Printf("PII key rotated from %s to %s; re-encryption job %d queued. Keep the previous key in "+
+		"PII_PREVIOUS_ENCRYPTION_KEYS until it completes.", previous, service.PII.KeyID(), job.ID)
+	return nil
+}
+
+// registerPIIReencryptJob registers the handler that moves record details to the current PII key
+func registerPIIReencryptJob(jobService service.JobService) {
+	jobService.RegisterHandler(piiReencryptJobType, func(payload json.RawMessage) (interface{}, error) {
+		count, err := service.ReencryptRecords(db, 500)
+		if err != nil {
+			return nil, fmt.Errorf("re-encryption stopped after %d records: %v", count, err)
+		}
+		log.Printf("Re-encrypted personal data in %d records with key %s", count, service.PII.KeyID())
+		return map[string]interface{}{"reencrypted": count, "key": service.PII.KeyID()}, nil
+	})
+}
+
+// encryptExistingPII is the -encrypt-pii utility: it encrypts the protected fields of the records
+// written before encryption was enabled, then exits
+func encryptExistingPII() {
+	if err := loadPIIProtection(); err != nil {
+		log.Fatalf("Invalid PII encryption configuration: %v", err)
+	}
+	if service.PII == nil {
+		log.Fatalf("PII_ENCRYPTION_KEY is not set")
+	}
+	if err := waitForDB(context.Background(), db, durationEnv("DB_CONNECT_TIMEOUT", dbConnectWindow)); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	if err := migrateDB(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	count, err := service.EncryptExistingRecords(db, 500)
+	if err != nil {
+		log.Fatalf("Encryption stopped after %d records: %v", count, err)
+	}
+	log.Printf("Encrypted personal data in %d records", count)
+	count, err = service.ReencryptRecords(db, 500)
+	if err != nil {
+		log.Fatalf("Re-encryption stopped after %d records: %v", count, err)
+	}
+	log.Printf("Re-encrypted personal data of %d records with key %s", count, service.PII.KeyID())
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// accessPolicy is the RBAC matrix: staff roles and service account permissions mapped to the
+// routes they may use. It is read from POLICY_FILE (YAML or JSON) when set, for example:
+//
+//	roles:
+//	  admin: {rank: 100, second_factor: true, allow: ["*"]}
+//	  support:
+//	    rank: 20
+//	    allow: ["GET /**", "DELETE /api/v1/admin/sessions/*"]
+//	    deny: ["/api/v1/admin/service-accounts/**"]
+//	permissions:
+//	  records:write: {allow: ["POST,PUT,PATCH,DELETE /api/v1/records/**"]}
+//
+// A rule is "METHODS PATTERN" or just "PATTERN" for every method. In patterns "*" matches one
+// path segment and a trailing "**" any number of them; "*" alone matches every request.
+type accessPolicy struct {
+	Roles       map[string]policyRole `json:"roles" yaml:"roles"`
+	Permissions map[string]policyRule `json:"permissions" yaml:"permissions"`
+}
+
+// policyRule allows the requests matching Allow unless they match Deny
+type policyRule struct {
+	Allow []string `json:"allow" yaml:"allow"`
+	Deny  []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+}
+
+// policyRole is a staff role. Rank picks the strongest role when OIDC groups map to several;
+// SecondFactor requires TOTP verification before staff sessions with the role are accepted.
+type policyRole struct {
+	policyRule   `yaml:",inline"`
+	Rank         int  `json:"rank" yaml:"rank"`
+	SecondFactor bool `json:"second_factor,omitempty" yaml:"second_factor,omitempty"`
+}
+
+// defaultPolicy is used without POLICY_FILE: admins may do everything, viewers may only read,
+// and service account permissions cover the matching slices of the tenant API
+var defaultPolicy = accessPolicy{
+	Roles: map[string]policyRole{
+		roleAdmin: {policyRule: policyRule{Allow: []string{"*"}}, Rank: 100, SecondFactor: true},
+		// GraphQL queries only read, whatever their method
+		// The Converty webhook secret lets its holder forge deliveries, so it stays with admins
+		roleViewer: {policyRule: policyRule{Allow: []string{"GET /**", "POST /graphql"}, Deny: []string{"/api/v1/webhooks/converty/secret"}}, Rank: 10},
+	},
+	Permissions: map[string]policyRule{
+		service.PermRecordsRead: {Allow: []string{"GET /api/v1/records/**", "GET,POST /graphql", "GET /graphql/records"}},
+		// Deleting, archiving and restoring records stays with admins
+		service.PermRecordsWrite: {
+			Allow: []string{"POST,PUT,PATCH,DELETE /api/v1/records/**", "POST /api/v1/issues/bulk-status"},
+			Deny:  []string{"DELETE /api/v1/records/*", "POST /api/v1/records/*/archive", "POST /api/v1/records/*/restore"},
+		},
+		service.PermOrdersRead: {
+			Allow: []string{
+				"GET /api/v1/orders/**", "GET /api/v1/abandoned/**", "GET /api/v1/reservations/**",
+				"GET /api/v1/webhooks/converty/events/**", "GET,POST /graphql", "GET /graphql/orders",
+			},
+			Deny: []string{"/api/v1/orders/export/**"},
+		},
+		service.PermOrdersWrite: {
+			Allow: []string{
+				"POST,PUT,PATCH,DELETE /api/v1/orders/**", "POST,PUT,PATCH,DELETE /api/v1/abandoned/**",
+				"POST,PUT,PATCH,DELETE /api/v1/reservations/**",
+				"POST /api/v1/webhooks/converty/events/*/replay", "POST /api/v1/webhooks/converty/backfill",
+			},
+		},
+		service.PermCatalogRead: {
+			Allow: []string{
+				"GET /get-products", "GET /api/v1/categories/**", "GET /api/v1/waitlists/**", "GET /api/v1/coupons/**",
+				"GET,POST /graphql", "GET /graphql/products",
+			},
+			Deny: []string{"/api/v1/waitlists/report"},
+		},
+		service.PermCatalogWrite: {
+			Allow: []string{"POST,PUT,PATCH,DELETE /api/v1/categories/**", "POST,PUT,PATCH,DELETE /api/v1/products/**"},
+		},
+		service.PermCustomersRead: {
+			Allow: []string{
+				"GET /api/v1/loyalty/**", "GET /api/v1/wallets/**", "GET /api/v1/customers/*/addresses/**",
+				"GET /api/v1/customers/*/consent", "GET /api/v1/consents", "GET /api/v1/recommendations",
+				"GET,POST /graphql", "GET /graphql/customers",
+			},
+		},
+		service.PermCustomersWrite: {
+			Allow: []string{
+				"POST,PUT,PATCH,DELETE /api/v1/loyalty/**", "POST,PUT,PATCH,DELETE /api/v1/customers/*/addresses/**",
+				"PUT /api/v1/customers/*/consent",
+			},
+		},
+		service.PermReportsRead: {
+			Allow: []string{
+				"GET /api/v1/orders/export/**", "GET /api/v1/reports/**", "GET /api/v1/waitlists/report",
+				"GET /api/v1/jobs/**", "GET /ws/dashboard",
+			},
+		},
+	},
+}
+
+// routeMatcher is one compiled rule
+type routeMatcher struct {
+	methods  map[string]bool // nil matches every method
+	segments []string        // nil matches every path
+}
+
+func (m routeMatcher) matches(method, path string) bool {
+	if m.methods != nil && !m.methods[method] {
+		return false
+	}
+	if m.segments == nil {
+		return true
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range m.segments {
+		if segment == "**" {
+			return true
+		}
+		if i >= len(parts) || (segment != "*" && segment != parts[i]) {
+			return false
+		}
+	}
+	return len(parts) == len(m.segments)
+}
+
+// compileRoute parses "GET /api/v1/**", "/health" or "*"
+func compileRoute(rule string) (routeMatcher, error) {
+	rule = strings.TrimSpace(rule)
+	if rule == "*" {
+		return routeMatcher{}, nil
+	}
+	var matcher routeMatcher
+	pattern := rule
+	if methods, rest, found := strings.Cut(rule, " "); found {
+		pattern = strings.TrimSpace(rest)
+		matcher.methods = map[string]bool{}
+		for _, method := range strings.Split(methods, ",") {
+			matcher.methods[strings.ToUpper(strings.TrimSpace(method))] = true
+		}
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		return routeMatcher{}, fmt.Errorf("invalid rule %q: the pattern must start with /", rule)
+	}
+	matcher.segments = strings.Split(strings.Trim(pattern, "/"), "/")
+	for i, segment := range matcher.segments {
+		if segment == "**" && i != len(matcher.segments)-1 {
+			return routeMatcher{}, fmt.Errorf("invalid rule %q: ** must be the last segment", rule)
+		}
+	}
+	return matcher, nil
+}
+
+// compiledRule is a policyRule ready for matching
+type compiledRule struct {
+	allow, deny []routeMatcher
+}
+
+func compileRule(rule policyRule) (compiledRule, error) {
+	var compiled compiledRule
+	for _, list := range []struct {
+		rules  []string
+		target *[]routeMatcher
+	}{{rule.Allow, &compiled.allow}, {rule.Deny, &compiled.deny}} {
+		for _, text := range list.rules {
+			matcher, err := compileRoute(text)
+			if err != nil {
+				return compiledRule{}, err
+			}
+			*list.target = append(*list.target, matcher)
+		}
+	}
+	return compiled, nil
+}
+
+func (c compiledRule) allows(method, path string) bool {
+	if c.allowsMethod(method, path) {
+		return true
+	}
+	// HEAD is answered by the GET handlers
+	return method == http.MethodHead && c.allowsMethod(http.MethodGet, path)
+}
+
+func (c compiledRule) allowsMethod(method, path string) bool {
+	for _, matcher := range c.deny {
+		if matcher.matches(method, path) {
+			return false
+		}
+	}
+	for _, matcher := range c.allow {
+		if matcher.matches(method, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// compiledPolicy is the policy in force
+type compiledPolicy struct {
+	source      accessPolicy
+	origin      string
+	loadedAt    time.Time
+	roles       map[string]compiledRule
+	permissions map[string]compiledRule
+}
+
+func compilePolicy(policy accessPolicy, origin string) (*compiledPolicy, error) {
+	compiled := &compiledPolicy{
+		source:      policy,
+		origin:      origin,
+		loadedAt:    time.Now(),
+		roles:       map[string]compiledRule{},
+		permissions: map[string]compiledRule{},
+	}
+	if len(policy.Roles) == 0 {
+		return nil, fmt.Errorf("the policy defines no roles")
+	}
+	for name, role := range policy.Roles {
+		rule, err := compileRule(role.policyRule)
+		if err != nil {
+			return nil, fmt.Errorf("role %s: %v", name, err)
+		}
+		compiled.roles[name] = rule
+	}
+	for name, permission := range policy.Permissions {
+		if _, err := service.ExpandPermissions([]string{name}); err != nil {
+			return nil, err
+		}
+		rule, err := compileRule(permission)
+		if err != nil {
+			return nil, fmt.Errorf("permission %s: %v", name, err)
+		}
+		compiled.permissions[name] = rule
+	}
+	return compiled, nil
+}
+
+// currentPolicy holds the policy in force; reloads swap it atomically
+var currentPolicy atomic.Pointer[compiledPolicy]
+
+// policy returns the policy in force, compiling the default one on first use
+func policy() *compiledPolicy {
+	if p := currentPolicy.Load(); p != nil {
+		return p
+	}
+	compiled, err := compilePolicy(defaultPolicy, "default")
+	if err != nil {
+		panic(fmt.Sprintf("invalid default policy: %v", err))
+	}
+	currentPolicy.CompareAndSwap(nil, compiled)
+	return currentPolicy.Load()
+}
+
+// hasRole reports whether the policy defines role
+func (p *compiledPolicy) hasRole(role string) bool {
+	_, ok := p.roles[role]
+	return ok
+}
+
+// roleAllows reports whether staff with role may send the request
+func (p *compiledPolicy) roleAllows(role, method, path string) bool {
+	rule, ok := p.roles[role]
+	return ok && rule.allows(method, path)
+}
+
+// roleRank orders roles from weakest to strongest
+func (p *compiledPolicy) roleRank(role string) int {
+	return p.source.Roles[role].Rank
+}
+
+// requiresSecondFactor reports whether staff sessions with role must pass TOTP verification
+func (p *compiledPolicy) requiresSecondFactor(role string) bool {
+	return p.source.Roles[role].SecondFactor
+}
+
+// permissionsAllow reports whether one of the permissions covers the request
+func (p *compiledPolicy) permissionsAllow(permissions []string, method, path string) bool {
+	for _, permission := range permissions {
+		if rule, ok := p.permissions[permission]; ok && rule.allows(method, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// readPolicyFile parses a policy file, as JSON when it ends in .json and as YAML otherwise
+func readPolicyFile(path string) (*compiledPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %v", err)
+	}
+	var parsed accessPolicy
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &parsed)
+	} else {
+		err = yaml.Unmarshal(data, &parsed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %v", path, err)
+	}
+	return compilePolicy(parsed, path)
+}
+
+// loadPolicy installs POLICY_FILE, or the default policy when it is unset
+func loadPolicy() error {
+	path := os.Getenv("POLICY_FILE")
+	if path == "" {
+		policy()
+		return nil
+	}
+	compiled, err := readPolicyFile(path)
+	if err != nil {
+		return err
+	}
+	currentPolicy.Store(compiled)
+	log.Printf("Loaded access policy from %s (%d roles, %d permissions)", path, len(compiled.roles), len(compiled.permissions))
+	return nil
+}
+
+// reloadPolicy re-reads POLICY_FILE; an invalid file leaves the policy in force unchanged
+func reloadPolicy() error {
+	if os.Getenv("POLICY_FILE") == "" {
+		return fmt.Errorf("POLICY_FILE is not set, the default policy is in force")
+	}
+	return loadPolicy()
+}
+
+// watchPolicy reloads POLICY_FILE whenever its modification time changes
+func watchPolicy(interval time.Duration) {
+	path := os.Getenv("POLICY_FILE")
+	if path == "" || interval <= 0 {
+		return
+	}
+	go func() {
+		var lastMod time.Time
+		if info, err := os.Stat(path); err == nil {
+			lastMod = info.ModTime()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = info.ModTime()
+			if err := reloadPolicy(); err != nil {
+				log.Printf("Keeping the current access policy: %v", err)
+			}
+		}
+	}()
+	log.Printf("Watching %s for policy changes every %v", path, interval)
+}
+
+// registerPolicyAdminRoutes shows the policy in force and reloads it on demand
+func registerPolicyAdminRoutes(r chi.Router) {
+	r.Get("/policy", func(w http.ResponseWriter, r *http.Request) {
+		current := policy()
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"origin":    current.origin,
+			"loaded_at": current.loadedAt,
+			"policy":    current.source,
+		})
+	})
+
+	r.Post("/policy/reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := reloadPolicy(); err != nil {
+			writeError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		log.Printf("Access policy reloaded by %s", adminActor(r))
+		current := policy()
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"origin": current.origin, "loaded_at": current.loadedAt})
+	})
+}
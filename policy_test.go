@@ -0,0 +1,108 @@
+package main
+
+import (
+	"convertyApi/service"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRouteMatcher(t *testing.T) {
+	for _, c := range []struct {
+		rule, method, path string
+		want               bool
+	}{
+		{"*", http.MethodDelete, "/anything", true},
+		{"GET /api/v1/records/**", http.MethodGet, "/api/v1/records", true},
+		{"GET /api/v1/records/**", http.MethodGet, "/api/v1/records/4/history", true},
+		{"GET /api/v1/records/**", http.MethodPost, "/api/v1/records", false},
+		{"DELETE /api/v1/admin/sessions/*", http.MethodDelete, "/api/v1/admin/sessions/abc", true},
+		{"DELETE /api/v1/admin/sessions/*", http.MethodDelete, "/api/v1/admin/sessions", false},
+		{"/health", http.MethodPost, "/health", true},
+		{"/health", http.MethodGet, "/healthz", false},
+	} {
+		matcher, err := compileRoute(c.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matcher.matches(c.method, c.path); got != c.want {
+			t.Errorf("%q matches %s %s = %v, want %v", c.rule, c.method, c.path, got, c.want)
+		}
+	}
+	if _, err := compileRoute("GET /api/**/records"); err == nil {
+		t.Error("** in the middle of a pattern was accepted")
+	}
+}
+
+func TestDefaultPolicyKeepsRecordRemovalWithAdmins(t *testing.T) {
+	p := policy()
+	for _, c := range []struct{ method, path string }{
+		{http.MethodDelete, "/api/v1/records/7"},
+		{http.MethodPost, "/api/v1/records/7/archive"},
+		{http.MethodPost, "/api/v1/records/7/restore"},
+	} {
+		if !p.roleAllows(roleAdmin, c.method, c.path) {
+			t.Errorf("admin may not %s %s", c.method, c.path)
+		}
+		if p.roleAllows(roleViewer, c.method, c.path) || p.permissionsAllow([]string{service.PermRecordsWrite}, c.method, c.path) {
+			t.Errorf("%s %s is allowed to non-admins", c.method, c.path)
+		}
+	}
+	if !p.permissionsAllow([]string{service.PermRecordsWrite}, http.MethodPost, "/api/v1/records") ||
+		!p.permissionsAllow([]string{service.PermRecordsWrite}, http.MethodPut, "/api/v1/records/7/status") {
+		t.Error("records:write lost its record writes")
+	}
+}
+
+func TestPolicyFile(t *testing.T) {
+	defer currentPolicy.Store(nil)
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	os.WriteFile(path, []byte(`
+roles:
+  admin: {rank: 100, allow: ["*"]}
+  support:
+    rank: 20
+    second_factor: true
+    allow: ["GET /**", "DELETE /api/v1/admin/sessions/*"]
+    deny: ["/api/v1/admin/service-accounts/**"]
+permissions:
+  records:write: {allow: ["POST /api/v1/records"]}
+`), 0o600)
+	t.Setenv("POLICY_FILE", path)
+	if err := loadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := policy()
+	for _, c := range []struct {
+		role, method, path string
+		want               bool
+	}{
+		{"support", http.MethodGet, "/api/v1/admin/tenants", true},
+		{"support", http.MethodDelete, "/api/v1/admin/sessions/s1", true},
+		{"support", http.MethodGet, "/api/v1/admin/service-accounts", false},
+		{"support", http.MethodPost, "/api/v1/admin/tenants", false},
+		{"viewer", http.MethodGet, "/api/v1/admin/tenants", false},
+	} {
+		if got := p.roleAllows(c.role, c.method, c.path); got != c.want {
+			t.Errorf("%s %s %s = %v, want %v", c.role, c.method, c.path, got, c.want)
+		}
+	}
+	if !p.requiresSecondFactor("support") || p.requiresSecondFactor("admin") {
+		t.Error("second_factor flags not applied")
+	}
+	if !p.permissionsAllow([]string{"records:write"}, http.MethodPost, "/api/v1/records") ||
+		p.permissionsAllow([]string{"records:write"}, http.MethodDelete, "/api/v1/records/1") {
+		t.Error("permission rules not applied")
+	}
+
+	// A broken file is rejected and the loaded policy stays in force
+	os.WriteFile(path, []byte("roles:\n  admin: {allow: [\"records\"]}\n"), 0o600)
+	if err := reloadPolicy(); err == nil {
+		t.Fatal("invalid policy accepted")
+	}
+	if !policy().hasRole("support") {
+		t.Error("the previous policy was replaced by an invalid one")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"convertyApi/service"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// reauthLinkTTL is how long a one-time re-authentication link stays valid
+const reauthLinkTTL = 24 * time.Hour
+
+// publicBaseURL is the externally reachable base URL used in generated links
+var publicBaseURL = "https://convertyapi.serveo.net"
+
+// notifier delivers operator notifications
+var notifier service.Notifier = service.LogNotifier{}
+
+// ReauthLink stores a one-time /login link issued when a token can no longer be refreshed
+type ReauthLink struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    string     `gorm:"not null;index;column:user_id"`
+	Nonce     string     `gorm:"not null;uniqueIndex"`
+	Reason    string     `gorm:"column:reason"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+	CreatedAt time.Time
+}
+
+// TableName specifies the table name for ReauthLink
+func (ReauthLink) TableName() string {
+	return "public.reauth_links"
+}
+
+// ReauthRequiredResponse is the machine-readable error returned when the operator must log in again
+type ReauthRequiredResponse struct {
+	Error    string `json:"error"`
+	Message  string `json:"message"`
+	UserID   string `json:"user_id"`
+	LoginURL string `json:"login_url"`
+}
+
+// newNonce returns a random hex string
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// reauthLoginURL builds the one-time login URL for a link
+func reauthLoginURL(link ReauthLink) string {
+	params := url.Values{}
+	params.Set("user", link.UserID)
+	params.Set("nonce", link.Nonce)
+	return fmt.Sprintf("%s/login?%s", publicBaseURL, params.Encode())
+}
+
+// startReauth marks the user's token invalid, issues a one-time login link and notifies the operator
+func startReauth(userID, reason string) (string, error) {
+	now := time.Now()
+	if err := db.Model(&TokenInfo{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"invalid":        true,
+		"invalidated_at": now,
+		"invalid_reason": reason,
+	}).Error; err != nil {
+		return "", fmt.Errorf("failed to invalidate token: %v", err)
+	}
+	service.Caches.TokenChanged(userID)
+
+	// Reuse a pending link so repeated failures don't spam the operator
+	var link ReauthLink
+	result := db.Where("user_id = ? AND used_at IS NULL AND expires_at > ?", userID, now).Order("id desc").Limit(1).Find(&link)
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to look up re-authentication link: %v", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return reauthLoginURL(link), nil
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	link = ReauthLink{UserID: userID, Nonce: nonce, Reason: reason, ExpiresAt: now.Add(reauthLinkTTL)}
+	if err := db.Create(&link).Error; err != nil {
+		return "", fmt.Errorf("failed to save re-authentication link: %v", err)
+	}
+
+	loginURL := reauthLoginURL(link)
+	if err := notifier.Notify(service.Notification{
+		Event:     "reauth_required",
+		Message:   fmt.Sprintf("Converty authorization for %s must be renewed: %s", userID, reason),
+		Data:      map[string]interface{}{"user_id": userID, "login_url": loginURL},
+		CreatedAt: now,
+	}); err != nil {
+		log.Printf("Failed to notify operator about re-authentication: %v", err)
+	}
+	return loginURL, nil
+}
+
+// writeReauthRequired starts the re-auth workflow and writes a reauth_required error
+func writeReauthRequired(w http.ResponseWriter, r *http.Request, userID, reason string) {
+	loginURL, err := startReauth(userID, reason)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Error: re-authentication required for %s: %s (Status: %d)", userID, reason, http.StatusUnauthorized)
+	writeJSON(w, r, http.StatusUnauthorized, ReauthRequiredResponse{
+		Error:    "reauth_required",
+		Message:  reason,
+		UserID:   userID,
+		LoginURL: loginURL,
+	})
+}
+
+// findReauthLink returns the pending link for a user and nonce
+func findReauthLink(userID, nonce string) (ReauthLink, bool) {
+	var link ReauthLink
+	result := db.Where("user_id = ? AND nonce = ? AND used_at IS NULL AND expires_at > ?", userID, nonce, time.Now()).Limit(1).Find(&link)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return ReauthLink{}, false
+	}
+	return link, true
+}
+
+// consumeReauthLink marks the link identified by nonce as used and returns its user
+func consumeReauthLink(nonce string) (string, bool) {
+	var link ReauthLink
+	result := db.Where("nonce = ? AND used_at IS NULL AND expires_at > ?", nonce, time.Now()).Limit(1).Find(&link)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return "", false
+	}
+	if err := db.Model(&link).Update("used_at", time.Now()).Error; err != nil {
+		log.Printf("Failed to consume re-authentication link: %v", err)
+		return "", false
+	}
+	return link.UserID, true
+}
+
+// clearReauth marks the user's token valid again after a successful authorization
+func clearReauth(userID string) error {
+	defer service.Caches.TokenChanged(userID)
+	return db.Model(&TokenInfo{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"invalid":        false,
+		"invalidated_at": nil,
+		"invalid_reason": "",
+	}).Error
+}
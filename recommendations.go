@@ -0,0 +1,72 @@
+package main
+
+import (
+	"convertyApi/service"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Catalogue pages read per recommendation request; the chatbot shops have a few hundred products
+const (
+	recommendationPageSize = 50
+	recommendationMaxPages = 4
+)
+
+// recommendationResponse is the answer of GET /api/v1/recommendations
+type recommendationResponse struct {
+	Phone    string `json:"phone"`
+	Strategy string `json:"strategy"`
+	// Orders is how many mirrored orders of the customer were used; 0 leaves the store's best sellers
+	Orders          int                      `json:"orders"`
+	Recommendations []service.Recommendation `json:"recommendations"`
+}
+
+// registerRecommendationRoutes mounts the product suggestions the chatbot makes during conversations
+func registerRecommendationRoutes(upstream chi.Router, dataService service.DataService, recommendations service.RecommendationService) {
+	// /api/v1/recommendations?phone=+21620000000&limit=5&strategy=popular ranks the products in stock
+	// from the customer's mirrored orders; see service.ScoringStrategyNames for the strategies
+	upstream.Get("/api/v1/recommendations", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		phone := query.Get("phone")
+		if phone == "" {
+			writeError(w, "phone is required", http.StatusBadRequest)
+			return
+		}
+		limit := 10
+		if value := query.Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > 50 {
+				writeError(w, "limit must be between 1 and 50", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+		strategy := query.Get("strategy")
+		if strategy == "" {
+			strategy = service.StrategyCategoryAffinity
+		}
+		if strategies := service.ScoringStrategyNames(); !slices.Contains(strategies, strategy) {
+			writeError(w, fmt.Sprintf("Unknown strategy %q, expected one of %s", strategy, strings.Join(strategies, ", ")), http.StatusBadRequest)
+			return
+		}
+
+		catalogue, err := service.ListCatalogue(tenantData(r, dataService), service.PriorityInteractive, recommendationPageSize, recommendationMaxPages)
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		ranked, profile, err := recommendations.Recommend(tenantFrom(r).ID, phone, catalogue, strategy, limit)
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, recommendationResponse{
+			Phone: profile.Phone, Strategy: strategy, Orders: profile.Orders, Recommendations: ranked,
+		})
+	})
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"convertyApi/service"
+	"os"
+	"time"
+)
+
+// loadRecordDedup reads RECORD_DEDUP_MODE (off, reject or merge), RECORD_DEDUP_WINDOW and
+// RECORD_DEDUP_TYPES, the handling of records repeating one inserted shortly before. The chatbot
+// retries requests it did not get an answer to, which otherwise opens the same issue twice.
+func loadRecordDedup() (service.RecordDedupSettings, error) {
+	settings := service.RecordDedupSettings{
+		Mode:   envOr("RECORD_DEDUP_MODE", service.RecordDedupOff),
+		Window: durationEnv("RECORD_DEDUP_WINDOW", 10*time.Minute),
+		Types:  splitList(os.Getenv("RECORD_DEDUP_TYPES")),
+	}
+	return settings, settings.Validate()
+}
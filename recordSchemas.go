@@ -0,0 +1,87 @@
+package main
+
+import (
+	"convertyApi/service"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// registerRecordSchemaRoutes mounts the lookup of the schema records of a type must match
+func registerRecordSchemaRoutes(r chi.Router, schemas service.RecordSchemaService) {
+	r.Get("/api/v1/records/schemas/{type}", func(w http.ResponseWriter, r *http.Request) {
+		schema, err := schemas.ActiveSchema(chi.URLParam(r, "type"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, schema)
+	})
+}
+
+// registerRecordSchemaAdminRoutes mounts the management of record schema versions
+func registerRecordSchemaAdminRoutes(r chi.Router, schemas service.RecordSchemaService) {
+	// ?type=issue lists the versions of one type
+	r.Get("/record-schemas", func(w http.ResponseWriter, r *http.Request) {
+		list, err := schemas.ListSchemas(r.URL.Query().Get("type"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, list)
+	})
+
+	// Registers the next version of a type's schema; it becomes active unless "activate" is false
+	r.Post("/record-schemas", func(w http.ResponseWriter, r *http.Request) {
+		var input recordSchemaRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		activate := input.Activate == nil || *input.Activate
+		schema, err := schemas.RegisterSchema(input.Type, input.Schema, input.Description, adminActor(r), activate)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, schema)
+	})
+
+	r.Get("/record-schemas/{type}/{version}", func(w http.ResponseWriter, r *http.Request) {
+		version, err := strconv.Atoi(chi.URLParam(r, "version"))
+		if err != nil {
+			writeError(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+		schema, err := schemas.GetSchema(chi.URLParam(r, "type"), version)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, schema)
+	})
+
+	// Switches new records to another version, e.g. to roll back a schema change
+	r.Post("/record-schemas/{type}/{version}/activate", func(w http.ResponseWriter, r *http.Request) {
+		version, err := strconv.Atoi(chi.URLParam(r, "version"))
+		if err != nil {
+			writeError(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+		schema, err := schemas.ActivateSchema(chi.URLParam(r, "type"), version)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, schema)
+	})
+
+	// Stops validating new records of the type
+	r.Post("/record-schemas/{type}/deactivate", func(w http.ResponseWriter, r *http.Request) {
+		if err := schemas.DeactivateSchema(chi.URLParam(r, "type")); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gorm.io/gorm"
+)
+
+// Record stores selectable with RECORD_STORE
+const (
+	recordStorePostgres = "postgres"
+	recordStoreMongo    = "mongo"
+)
+
+// recordStoreConfig locates the store of the chatbot interactions
+type recordStoreConfig struct {
+	Store    string
+	MongoURI string
+	// MongoDatabase holds the interactions and counters collections
+	MongoDatabase string
+}
+
+// loadRecordStore reads RECORD_STORE (postgres or mongo), MONGODB_URI and MONGODB_DATABASE
+func loadRecordStore() (recordStoreConfig, error) {
+	config := recordStoreConfig{
+		Store:         envOr("RECORD_STORE", recordStorePostgres),
+		MongoURI:      os.Getenv("MONGODB_URI"),
+		MongoDatabase: envOr("MONGODB_DATABASE", "chatbot"),
+	}
+	switch config.Store {
+	case recordStorePostgres:
+		return config, nil
+	case recordStoreMongo:
+		if config.MongoURI == "" {
+			return config, fmt.Errorf("RECORD_STORE=mongo needs MONGODB_URI")
+		}
+		return config, nil
+	}
+	return config, fmt.Errorf("unknown RECORD_STORE %q, use %s or %s", config.Store, recordStorePostgres, recordStoreMongo)
+}
+
+// openRecordStore returns the DataService of the configured record store. The PostgreSQL service
+// answers the Converty calls either way, and receives the record events for the outbox.
+func openRecordStore(config recordStoreConfig, db *gorm.DB, dataService service.DataService, opts service.DataServiceOptions) (service.DataService, error) {
+	if config.Store != recordStoreMongo {
+		return dataService, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(config.MongoURI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		return nil, fmt.Errorf("failed to reach MongoDB: %v", err)
+	}
+	store, err := service.NewMongoDataService(client.Database(config.MongoDatabase), dataService, db, opts)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Storing records in MongoDB database %s", config.MongoDatabase)
+	return store, nil
+}
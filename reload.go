@@ -0,0 +1,235 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/joho/godotenv"
+)
+
+// Log levels of LOG_LEVEL. Requests are logged at info; warn and error keep only the problems.
+const (
+	logDebug int32 = iota - 1
+	logInfo
+	logWarn
+	logError
+)
+
+var logLevels = map[string]int32{"debug": logDebug, "info": logInfo, "warn": logWarn, "error": logError}
+
+// logLevel is the level in force; the zero value is info
+var logLevel atomic.Int32
+
+// accessLog logs every request like middleware.Logger while the log level is info or lower
+func accessLog(next http.Handler) http.Handler {
+	logged := middleware.Logger(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if logLevel.Load() > logInfo {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logged.ServeHTTP(w, r)
+	})
+}
+
+// runtimeConfig is the tuning that can change without a restart. The database, the listen address,
+// secrets and integrations are structural and still need one.
+type runtimeConfig struct {
+	LogLevel       string
+	UpstreamLimits service.LimiterConfig
+	OrderCacheTTL  time.Duration
+	// TokenCacheTTL is how long stored Converty tokens are served from memory; 0 reads them every time
+	TokenCacheTTL time.Duration
+	Alerts        service.AlertThresholds
+	// Flags is FEATURE_FLAGS; flags set through the admin API take precedence
+	Flags service.FlagConfig
+	// policy is POLICY_FILE compiled, nil when the default policy is in force
+	policy *compiledPolicy
+}
+
+// parseRuntimeConfig reads and validates the reloadable settings, reporting every problem at once
+func parseRuntimeConfig(getenv func(string) string) (runtimeConfig, error) {
+	cfg := runtimeConfig{LogLevel: "info", OrderCacheTTL: 30 * time.Second, TokenCacheTTL: service.DefaultTokenCacheTTL}
+	var errs []error
+	if value := getenv("LOG_LEVEL"); value != "" {
+		if _, ok := logLevels[value]; !ok {
+			errs = append(errs, fmt.Errorf("invalid LOG_LEVEL %q, expected debug, info, warn or error", value))
+		} else {
+			cfg.LogLevel = value
+		}
+	}
+	limits, err := upstreamLimiterConfig(getenv)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	cfg.UpstreamLimits = limits
+	if value := getenv("ORDER_CACHE_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err != nil || ttl < 0 {
+			errs = append(errs, fmt.Errorf("invalid ORDER_CACHE_TTL %q", value))
+		} else {
+			cfg.OrderCacheTTL = ttl
+		}
+	}
+	if value := getenv("TOKEN_CACHE_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err != nil || ttl < 0 {
+			errs = append(errs, fmt.Errorf("invalid TOKEN_CACHE_TTL %q", value))
+		} else {
+			cfg.TokenCacheTTL = ttl
+		}
+	}
+	thresholds, err := loadAlertThresholds(getenv)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	cfg.Alerts = thresholds
+	flags, err := parseFeatureFlags(getenv("FEATURE_FLAGS"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	cfg.Flags = flags
+	if path := getenv("POLICY_FILE"); path != "" {
+		compiled, err := readPolicyFile(path)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		cfg.policy = compiled
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// describe is the view of the configuration shown to operators
+func (cfg runtimeConfig) describe() map[string]interface{} {
+	policyOrigin := "default"
+	if cfg.policy != nil {
+		policyOrigin = cfg.policy.origin
+	}
+	return map[string]interface{}{
+		"log_level": cfg.LogLevel,
+		"upstream_limits": map[string]interface{}{
+			"max_concurrent":   cfg.UpstreamLimits.MaxConcurrent,
+			"per_minute":       cfg.UpstreamLimits.PerMinute,
+			"burst":            cfg.UpstreamLimits.Burst,
+			"background_share": cfg.UpstreamLimits.BackgroundShare,
+			"queue_timeout":    cfg.UpstreamLimits.MaxWait.String(),
+			"page_workers":     cfg.UpstreamLimits.PageWorkers,
+		},
+		"order_cache_ttl":  cfg.OrderCacheTTL.String(),
+		"token_cache_ttl":  cfg.TokenCacheTTL.String(),
+		"alert_thresholds": cfg.Alerts,
+		"feature_flags":    cfg.Flags,
+		"policy":           policyOrigin,
+	}
+}
+
+// configReloader holds the runtime configuration in force and swaps it on reload
+type configReloader struct {
+	mu       sync.Mutex
+	current  runtimeConfig
+	loadedAt time.Time
+	alerts   service.ProductAlertService
+	flags    service.FeatureFlagService
+}
+
+// liveConfig is the runtime configuration of the server
+var liveConfig = &configReloader{}
+
+// install applies cfg to every component at startup
+func (c *configReloader) install(cfg runtimeConfig, alerts service.ProductAlertService, flags service.FeatureFlagService) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.alerts, c.flags = alerts, flags
+	c.apply(cfg)
+}
+
+// apply swaps cfg into the components; the caller holds mu
+func (c *configReloader) apply(cfg runtimeConfig) {
+	logLevel.Store(logLevels[cfg.LogLevel])
+	service.UpstreamLimit.Reconfigure(cfg.UpstreamLimits)
+	service.Caches.SetTTL(service.CacheOrders, cfg.OrderCacheTTL)
+	service.Caches.SetTTL(service.CacheTokens, cfg.TokenCacheTTL)
+	if c.alerts != nil {
+		c.alerts.SetThresholds(cfg.Alerts)
+	}
+	if c.flags != nil {
+		c.flags.SetConfig(cfg.Flags)
+	}
+	if cfg.policy != nil {
+		currentPolicy.Store(cfg.policy)
+	}
+	c.current = cfg
+	c.loadedAt = time.Now()
+}
+
+// Reload re-reads .env and applies the reloadable settings. Variables of the process environment
+// keep their value; an invalid configuration is rejected as a whole and the current one stays.
+func (c *configReloader) Reload() (runtimeConfig, error) {
+	values, err := godotenv.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return runtimeConfig{}, fmt.Errorf("failed to read .env file: %v", err)
+	}
+	cfg, err := parseRuntimeConfig(func(name string) string {
+		if processEnv[name] {
+			return os.Getenv(name)
+		}
+		return values[name]
+	})
+	if err != nil {
+		return runtimeConfig{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apply(cfg)
+	return cfg, nil
+}
+
+// Current returns the configuration in force and when it was loaded
+func (c *configReloader) Current() (runtimeConfig, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current, c.loadedAt
+}
+
+// reloadOnSIGHUP reloads the runtime configuration whenever the process receives SIGHUP
+func reloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if _, err := liveConfig.Reload(); err != nil {
+				log.Printf("Keeping the current configuration: %v", err)
+				continue
+			}
+			log.Println("Configuration reloaded on SIGHUP")
+		}
+	}()
+}
+
+// registerConfigAdminRoutes shows the runtime configuration and reloads it on demand
+func registerConfigAdminRoutes(r chi.Router) {
+	r.Get("/config", func(w http.ResponseWriter, r *http.Request) {
+		cfg, loadedAt := liveConfig.Current()
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"loaded_at": loadedAt, "config": cfg.describe()})
+	})
+
+	r.Post("/config/reload", func(w http.ResponseWriter, r *http.Request) {
+		cfg, err := liveConfig.Reload()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		log.Printf("Configuration reloaded by %s", adminActor(r))
+		_, loadedAt := liveConfig.Current()
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"loaded_at": loadedAt, "config": cfg.describe()})
+	})
+}
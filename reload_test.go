@@ -0,0 +1,81 @@
+package main
+
+import (
+	"convertyApi/service"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRuntimeConfigReportsEveryProblem(t *testing.T) {
+	env := map[string]string{"LOG_LEVEL": "loud", "CONVERTY_RATE_PER_MINUTE": "-1", "ORDER_CACHE_TTL": "soon", "TOKEN_CACHE_TTL": "-5s"}
+	_, err := parseRuntimeConfig(func(name string) string { return env[name] })
+	if err == nil {
+		t.Fatal("expected a configuration error")
+	}
+	for _, want := range []string{"LOG_LEVEL", "CONVERTY_RATE_PER_MINUTE", "ORDER_CACHE_TTL", "TOKEN_CACHE_TTL"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	cfg, err := parseRuntimeConfig(func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("defaults rejected: %v", err)
+	}
+	if cfg.LogLevel != "info" || cfg.OrderCacheTTL != 30*time.Second || cfg.TokenCacheTTL != service.DefaultTokenCacheTTL || cfg.UpstreamLimits.PerMinute != 120 || cfg.Alerts != defaultAlertThresholds {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+}
+
+func TestConfigReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	previousLimits := service.UpstreamLimit.Config()
+	defer service.UpstreamLimit.Reconfigure(previousLimits)
+	defer logLevel.Store(logInfo)
+
+	// The process environment wins over .env, as at startup
+	t.Setenv("ALERT_LOW_STOCK", "3")
+	processEnv["ALERT_LOW_STOCK"] = true
+	defer delete(processEnv, "ALERT_LOW_STOCK")
+
+	reloader := &configReloader{}
+	writeEnv := func(content string) {
+		if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEnv("LOG_LEVEL=warn\nCONVERTY_RATE_PER_MINUTE=30\nALERT_LOW_STOCK=9\n")
+	cfg, err := reloader.Reload()
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if cfg.LogLevel != "warn" || logLevel.Load() != logWarn {
+		t.Errorf("log level not applied: %q / %d", cfg.LogLevel, logLevel.Load())
+	}
+	if got := service.UpstreamLimit.Config().PerMinute; got != 30 {
+		t.Errorf("limiter budget = %d, want 30", got)
+	}
+	if cfg.Alerts.LowStock != 3 {
+		t.Errorf("ALERT_LOW_STOCK from the environment overridden by .env: %d", cfg.Alerts.LowStock)
+	}
+
+	// An invalid file is rejected as a whole
+	writeEnv("LOG_LEVEL=error\nCONVERTY_RATE_PER_MINUTE=lots\n")
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("expected the invalid configuration to be rejected")
+	}
+	if current, _ := reloader.Current(); current.LogLevel != "warn" || logLevel.Load() != logWarn {
+		t.Errorf("rejected reload changed the configuration: %+v", current)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"convertyApi/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// reportDeliveryJobType is the job queue type of scheduled report deliveries
+const reportDeliveryJobType = "deliver_report"
+
+// reportScheduleInterval is how often due report schedules are looked up
+var reportScheduleInterval = time.Minute
+
+// defaultReportDays is the period of an orders report whose schedule sets no days filter
+const defaultReportDays = 7
+
+// reportDeliveryJobPayload runs one schedule of one tenant
+type reportDeliveryJobPayload struct {
+	ScheduleID uint `json:"schedule_id"`
+	TenantID   uint `json:"tenant_id"`
+}
+
+// reportDeliverer sends a rendered report to the destination of its schedule
+type reportDeliverer interface {
+	Deliver(schedule service.ReportSchedule, file service.ReportFile) error
+}
+
+// loadReportDelivery reads the SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM
+// settings email deliveries are sent with
+func loadReportDelivery() (*service.ReportDelivery, error) {
+	config := service.SMTPConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     587,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if value := os.Getenv("SMTP_PORT"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid SMTP_PORT %q", value)
+		}
+		config.Port = port
+	}
+	return service.NewReportDelivery(config), nil
+}
+
+// registerReportDeliveryJob registers the handler that renders a scheduled report and delivers it
+func registerReportDeliveryJob(jobService service.JobService, dataService service.DataService, tenantService service.TenantService,
+	categoryService service.CategoryService, mirror service.OrderMirrorService, schedules service.ReportScheduleService, deliverer reportDeliverer) {
+	jobService.RegisterHandler(reportDeliveryJobType, func(payload json.RawMessage) (interface{}, error) {
+		var input reportDeliveryJobPayload
+		if err := json.Unmarshal(payload, &input); err != nil {
+			return nil, fmt.Errorf("invalid payload: %v", err)
+		}
+		schedule, err := schedules.GetSchedule(input.TenantID, input.ScheduleID)
+		if err != nil {
+			return nil, err
+		}
+		tenant, err := tenantByID(tenantService, schedule.TenantID)
+		if err != nil {
+			return nil, err
+		}
+		if schedule.TokenUserID != "" {
+			tenant.TokenUserID = schedule.TokenUserID
+		}
+
+		now := time.Now()
+		file, err := renderScheduledReport(dataService.ForTenant(tenant), categoryService, mirror, schedule, now)
+		if err == nil {
+			err = deliverer.Deliver(schedule, file)
+		}
+		if recordErr := schedules.RecordRun(schedule.ID, now, err); recordErr != nil {
+			log.Printf("Report schedule %d: %v", schedule.ID, recordErr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"file": file.Name, "rows": file.Rows, "destination": schedule.Destination}, nil
+	})
+}
+
+// tenantByID finds a tenant, including the default one, by ID
+func tenantByID(tenantService service.TenantService, id uint) (service.Tenant, error) {
+	tenants, err := jobTenants(tenantService, nil)
+	if err != nil {
+		return service.Tenant{}, err
+	}
+	for _, tenant := range tenants {
+		if tenant.ID == id {
+			return tenant, nil
+		}
+	}
+	return service.Tenant{}, fmt.Errorf("tenant %d not found", id)
+}
+
+// renderScheduledReport builds the report of a schedule as of now
+func renderScheduledReport(dataService service.DataService, categoryService service.CategoryService, mirror service.OrderMirrorService,
+	schedule service.ReportSchedule, now time.Time) (service.ReportFile, error) {
+	var buf bytes.Buffer
+	switch schedule.Report {
+	case service.ScheduledOrdersReport:
+		days := schedule.Filters.Days
+		if days == 0 {
+			days = defaultReportDays
+		}
+		from := now.AddDate(0, 0, -days)
+		query := service.OrderExportQuery{Format: schedule.Format, Status: schedule.Filters.Status, From: &from, To: &now}
+		rows, err := service.ExportOrders(dataService, query, &buf)
+		if err != nil {
+			return service.ReportFile{}, err
+		}
+		return service.ReportFile{
+			Name:        fmt.Sprintf("orders-%s-%s.%s", from.Format("20060102"), now.Format("20060102"), schedule.Format),
+			ContentType: service.ExportContentType(schedule.Format),
+			Body:        buf.Bytes(),
+			Rows:        rows,
+		}, nil
+
+	case service.ScheduledTaxReport:
+		year, quarter := reportQuarter(now, schedule.Filters.CurrentQuarter)
+		from, to, err := service.QuarterRange(year, quarter, now.Location())
+		if err != nil {
+			return service.ReportFile{}, err
+		}
+		var category *service.Category
+		if schedule.Filters.Category != "" {
+			found, err := categoryService.FindCategory(schedule.TenantID, schedule.Filters.Category)
+			if err != nil {
+				return service.ReportFile{}, err
+			}
+			category = &found
+		}
+		orders, _, err := collectTaxOrders(dataService, mirror, schedule.TenantID, from, to, false)
+		if err != nil {
+			return service.ReportFile{}, err
+		}
+		report, err := buildTaxReport(orders, year, quarter, schedule.Filters.Currency, category)
+		if err != nil {
+			return service.ReportFile{}, err
+		}
+		writeTaxReportCSV(&buf, report)
+		return service.ReportFile{Name: taxReportFileName(report), ContentType: "text/csv", Body: buf.Bytes(), Rows: len(report.Invoices)}, nil
+
+	default:
+		return service.ReportFile{}, fmt.Errorf("unknown report %q", schedule.Report)
+	}
+}
+
+// reportQuarter returns the quarter containing now, or the one before it
+func reportQuarter(now time.Time, current bool) (year, quarter int) {
+	year, quarter = now.Year(), (int(now.Month())-1)/3+1
+	if current {
+		return year, quarter
+	}
+	if quarter == 1 {
+		return year - 1, 4
+	}
+	return year, quarter - 1
+}
+
+// scheduleReportDeliveries enqueues a delivery for each schedule that comes due; claiming the run
+// first keeps several instances from sending the same report twice
+func scheduleReportDeliveries(jobService service.JobService, schedules service.ReportScheduleService) {
+	if reportScheduleInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(reportScheduleInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			enqueueDueReports(jobService, schedules, time.Now())
+		}
+	}()
+	log.Printf("Report schedules checked every %v", reportScheduleInterval)
+}
+
+// enqueueDueReports enqueues the deliveries due at now
+func enqueueDueReports(jobService service.JobService, schedules service.ReportScheduleService, now time.Time) {
+	due, err := schedules.DueSchedules(now)
+	if err != nil {
+		log.Printf("Report scheduling failed: %v", err)
+		return
+	}
+	for _, schedule := range due {
+		claimed, err := schedules.ClaimRun(schedule, now)
+		if err != nil {
+			log.Printf("Report scheduling failed: %v", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		payload := reportDeliveryJobPayload{ScheduleID: schedule.ID, TenantID: schedule.TenantID}
+		if _, err := jobService.Enqueue(schedule.TenantID, reportDeliveryJobType, payload); err != nil {
+			log.Printf("Failed to schedule report %d: %v", schedule.ID, err)
+		}
+	}
+}
+
+// registerReportScheduleAdminRoutes mounts the management of the request tenant's report schedules
+func registerReportScheduleAdminRoutes(r chi.Router, jobService service.JobService, schedules service.ReportScheduleService) {
+	r.Get("/report-schedules", func(w http.ResponseWriter, r *http.Request) {
+		list, err := schedules.ListSchedules(tenantFrom(r).ID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, list)
+	})
+
+	r.Post("/report-schedules", func(w http.ResponseWriter, r *http.Request) {
+		var input service.ReportSchedule
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		input.TenantID = tenantFrom(r).ID
+		schedule, err := schedules.CreateSchedule(input, adminActor(r))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, schedule)
+	})
+
+	r.Get("/report-schedules/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		schedule, err := schedules.GetSchedule(tenantFrom(r).ID, uint(id))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, schedule)
+	})
+
+	r.Patch("/report-schedules/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		var input service.ReportScheduleUpdate
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		schedule, err := schedules.UpdateSchedule(tenantFrom(r).ID, uint(id), input)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, schedule)
+	})
+
+	r.Delete("/report-schedules/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		if err := schedules.DeleteSchedule(tenantFrom(r).ID, uint(id)); err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Delivers a report right away, e.g. to check its destination; the schedule keeps its next run
+	r.Post("/report-schedules/{id}/run", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		schedule, err := schedules.GetSchedule(tenantFrom(r).ID, uint(id))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		job, err := jobService.Enqueue(tenantFrom(r).ID, reportDeliveryJobType, reportDeliveryJobPayload{ScheduleID: schedule.ID, TenantID: schedule.TenantID})
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/jobs/%d", job.ID))
+		writeJSON(w, r, http.StatusAccepted, job)
+	})
+}
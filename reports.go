@@ -0,0 +1,180 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// taxCalculator applies the configured VAT rate to invoices and tax reports
+var taxCalculator = service.TaxCalculator{Rate: 0.19, PricesIncludeVAT: true}
+
+// loadTaxConfig reads VAT_RATE (e.g. 0.19) and PRICES_INCLUDE_VAT from the environment
+func loadTaxConfig() error {
+	if value := os.Getenv("VAT_RATE"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate >= 1 {
+			return fmt.Errorf("invalid VAT_RATE %q, expected a fraction such as 0.19", value)
+		}
+		taxCalculator.Rate = rate
+	}
+	if value := os.Getenv("PRICES_INCLUDE_VAT"); value != "" {
+		include, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid PRICES_INCLUDE_VAT %q", value)
+		}
+		taxCalculator.PricesIncludeVAT = include
+	}
+	return nil
+}
+
+// taxReportMaxPages is how many pages of 100 orders a tax report reads from Converty before it turns to the mirror
+const taxReportMaxPages = 50
+
+// collectTaxOrders reads the orders placed in [from, to) for a tax report. Converty is read until the
+// listing ends; when it is longer than taxReportMaxPages pages, or preferMirror is set, the orders
+// come from the mirror, which reads the whole range at once. It reports whether the mirror answered.
+func collectTaxOrders(dataService service.DataService, mirror service.OrderMirrorService, tenantID uint, from, to time.Time, preferMirror bool) ([]service.Order, bool, error) {
+	if preferMirror {
+		orders, err := mirror.OrdersBetween(tenantID, from, to)
+		return orders, true, err
+	}
+	orders, err := service.CollectOrders(dataService, service.CustomerOrderQuery{Limit: 100}, from, to, taxReportMaxPages)
+	if !errors.Is(err, service.ErrOrdersTruncated) {
+		return orders, false, err
+	}
+	mirrored, mirrorErr := mirror.OrdersBetween(tenantID, from, to)
+	if mirrorErr != nil {
+		log.Printf("Tax report mirror fallback failed: %v", mirrorErr)
+		return nil, false, err
+	}
+	log.Printf("Tax report served from the mirror: %v", err)
+	return mirrored, true, nil
+}
+
+// registerReportRoutes mounts invoice and reporting endpoints
+func registerReportRoutes(r chi.Router, dataService service.DataService, mirror service.OrderMirrorService, categoryService service.CategoryService) {
+	r.Get("/api/v1/orders/{id}/invoice", func(w http.ResponseWriter, r *http.Request) {
+		order, err := tenantData(r, dataService).GetOrder(chi.URLParam(r, "id"))
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		if order.Currency == "" {
+			order.Currency = currencyConverter.StoreCurrency
+		}
+		writeJSON(w, r, http.StatusOK, taxCalculator.BuildInvoice(order))
+	})
+
+	// Quarterly tax report: /api/v1/reports/tax?year=2025&quarter=2&currency=TND&category=electronics&format=csv;
+	// mirror=true reads the order mirror instead of Converty
+	r.Get("/api/v1/reports/tax", func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		now := time.Now()
+		year, quarter := now.Year(), (int(now.Month())-1)/3+1
+		if value := params.Get("year"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				writeError(w, "Invalid year", http.StatusBadRequest)
+				return
+			}
+			year = parsed
+		}
+		if value := params.Get("quarter"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				writeError(w, "Invalid quarter", http.StatusBadRequest)
+				return
+			}
+			quarter = parsed
+		}
+		from, to, err := service.QuarterRange(year, quarter, time.Local)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		category, ok := requestCategory(w, r, categoryService)
+		if !ok {
+			return
+		}
+
+		orders, fromMirror, err := collectTaxOrders(tenantData(r, dataService), mirror, tenantFrom(r).ID, from, to, params.Get("mirror") == "true")
+		if errors.Is(err, service.ErrOrdersTruncated) {
+			writeError(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		if fromMirror {
+			w.Header().Set(orderSourceHeader, "mirror")
+		}
+		report, err := buildTaxReport(orders, year, quarter, params.Get("currency"), category)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if params.Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", "attachment; filename="+taxReportFileName(report))
+			writeTaxReportCSV(w, report)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, report)
+	})
+}
+
+// buildTaxReport filters the collected orders by category, converts them to currency (empty uses
+// the reporting currency) and totals them
+func buildTaxReport(orders []service.Order, year, quarter int, currency string, category *service.Category) (service.TaxReport, error) {
+	if category != nil {
+		orders = service.FilterOrdersByCategory(orders, *category)
+	}
+	if currency == "" {
+		currency = currencyConverter.ReportingCurrency
+	}
+	if err := currencyConverter.ConvertOrders(orders, currency); err != nil {
+		return service.TaxReport{}, err
+	}
+	return taxCalculator.BuildTaxReport(year, quarter, strings.ToUpper(currency), orders)
+}
+
+// taxReportFileName names the CSV file of a tax report
+func taxReportFileName(report service.TaxReport) string {
+	return fmt.Sprintf("tax-report-%d-Q%d.csv", report.Year, report.Quarter)
+}
+
+// writeTaxReportCSV writes one line per invoice followed by a totals line
+func writeTaxReportCSV(w io.Writer, report service.TaxReport) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"invoice", "order_id", "date", "customer", "status", "currency", "net", "vat_rate", "vat", "gross"})
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	for _, invoice := range report.Invoices {
+		cw.Write([]string{
+			invoice.Number,
+			invoice.OrderID,
+			invoice.IssuedAt.Format("2006-01-02"),
+			invoice.Customer.Name,
+			invoice.Status,
+			invoice.Currency,
+			money(invoice.Net),
+			strconv.FormatFloat(invoice.VATRate, 'f', -1, 64),
+			money(invoice.VAT),
+			money(invoice.Gross),
+		})
+	}
+	cw.Write([]string{"TOTAL", "", "", "", "", report.Currency, money(report.Net), strconv.FormatFloat(report.VATRate, 'f', -1, 64), money(report.VAT), money(report.Gross)})
+	cw.Flush()
+}
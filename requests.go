@@ -0,0 +1,389 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// validate checks the `validate` tags of request bodies; fields are reported by their JSON name
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	return v
+}
+
+// FieldError is one rejected field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationErrorResponse is the 422 body listing every rejected field
+type ValidationErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
+// defaultMaxBodyBytes bounds request bodies unless MAX_BODY_BYTES says otherwise
+const defaultMaxBodyBytes = 1 << 20
+
+// loadDetailsLimits reads DETAILS_MAX_BYTES, DETAILS_MAX_DEPTH and DETAILS_MAX_FIELDS, the bounds on the
+// details of the records clients store
+func loadDetailsLimits() service.DetailsLimits {
+	defaults := service.DefaultDetailsLimits
+	return service.DetailsLimits{
+		MaxBytes:  intEnv("DETAILS_MAX_BYTES", defaults.MaxBytes),
+		MaxDepth:  intEnv("DETAILS_MAX_DEPTH", defaults.MaxDepth),
+		MaxFields: intEnv("DETAILS_MAX_FIELDS", defaults.MaxFields),
+	}
+}
+
+// acceptedBodyType reports whether the API reads request bodies of a media type: JSON, including
+// the +json types such as merge patches, and multipart uploads
+func acceptedBodyType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || mediaType == "multipart/form-data"
+}
+
+// limitBodies answers 415 to a request body of a media type the API does not read, and caps the
+// other bodies at maxBytes: a declared length past it is answered 413 at once, a longer body fails
+// when read. A body without a Content-Type is taken for JSON. Multipart uploads are capped by the
+// route that takes them.
+func limitBodies(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var mediaType string
+			if contentType := r.Header.Get("Content-Type"); contentType != "" {
+				parsed, _, err := mime.ParseMediaType(contentType)
+				if err != nil || !acceptedBodyType(parsed) {
+					writeError(w, fmt.Sprintf("Unsupported Content-Type %q, send application/json", contentType), http.StatusUnsupportedMediaType)
+					return
+				}
+				mediaType = parsed
+			}
+			if mediaType == "multipart/form-data" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > maxBytes {
+				writeError(w, fmt.Sprintf("Request body exceeds %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bodyErrorStatus is 413 when reading the body failed on its size cap, else 400
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// bindJSON decodes the request body into dst and validates it. Malformed JSON is answered with
+// 400, a body past the size cap with 413 and failed validation with 422 listing the fields; ok is
+// false when a response was written.
+func bindJSON(w http.ResponseWriter, r *http.Request, dst interface{}) (ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		writeError(w, fmt.Sprintf("Invalid request body: %v", err), bodyErrorStatus(err))
+		return false
+	}
+	fields := requestErrors(dst)
+	if len(fields) == 0 {
+		return true
+	}
+	writeJSON(w, r, http.StatusUnprocessableEntity, ValidationErrorResponse{Error: "validation failed", Fields: fields})
+	return false
+}
+
+// requestErrors validates a struct or a slice of structs and describes each failed rule
+func requestErrors(dst interface{}) []FieldError {
+	value := reflect.Indirect(reflect.ValueOf(dst))
+	var err error
+	switch value.Kind() {
+	case reflect.Struct:
+		err = validate.Struct(value.Interface())
+	case reflect.Slice, reflect.Array:
+		err = validate.Var(value.Interface(), "dive")
+	default:
+		return nil
+	}
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return nil
+	}
+	fields := make([]FieldError, 0, len(invalid))
+	for _, fieldErr := range invalid {
+		fields = append(fields, FieldError{Field: fieldPath(fieldErr), Rule: fieldErr.Tag(), Message: fieldMessage(fieldErr)})
+	}
+	return fields
+}
+
+// fieldPath drops the Go type name the validator puts in front of the JSON path
+func fieldPath(fieldErr validator.FieldError) string {
+	path := fieldErr.Namespace()
+	if _, rest, found := strings.Cut(path, "."); found {
+		return rest
+	}
+	return path
+}
+
+// fieldMessage describes a failed rule in words
+func fieldMessage(fieldErr validator.FieldError) string {
+	kind := fieldErr.Kind()
+	unit := ""
+	if kind == reflect.String {
+		unit = " characters"
+	} else if kind == reflect.Slice || kind == reflect.Map {
+		unit = " items"
+	}
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		return fmt.Sprintf("must be at most %s%s", fieldErr.Param(), unit)
+	case "min":
+		return fmt.Sprintf("must be at least %s%s", fieldErr.Param(), unit)
+	case "len":
+		return fmt.Sprintf("must be exactly %s%s", fieldErr.Param(), unit)
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fieldErr.Param(), " ", ", ")
+	case "gt":
+		return "must be greater than " + fieldErr.Param()
+	case "gte":
+		return "must be at least " + fieldErr.Param()
+	case "e164":
+		return "must be a phone number in international format"
+	default:
+		return fmt.Sprintf("failed the %s rule", fieldErr.Tag())
+	}
+}
+
+// batchLookupQuery is the ?ids= list of the batch lookups of GET /api/v1/records and GET /api/v1/orders;
+// the cap is service.MaxBatchLookupIDs
+type batchLookupQuery struct {
+	IDs []string `json:"ids" validate:"required,min=1,max=100,dive,max=64"`
+}
+
+// bindIDList reads the comma-separated ?ids= of a batch lookup and validates it. An empty, oversized
+// or malformed list is answered with 400 listing the fields; ok is false when a response was written.
+func bindIDList(w http.ResponseWriter, r *http.Request) (query batchLookupQuery, ok bool) {
+	query.IDs = splitList(r.URL.Query().Get("ids"))
+	if fields := requestErrors(&query); len(fields) > 0 {
+		writeJSON(w, r, http.StatusBadRequest, ValidationErrorResponse{Error: "invalid ids", Fields: fields})
+		return query, false
+	}
+	return query, true
+}
+
+// createRecordRequest is the body of POST /api/v1/records
+type createRecordRequest struct {
+	UserID  uint                   `json:"user_id"`
+	Type    string                 `json:"type" validate:"required,max=64"`
+	Details map[string]interface{} `json:"details"`
+	Status  string                 `json:"status" validate:"omitempty,oneof=pending in_progress completed cancelled"`
+}
+
+// recordStatusRequest is the body of PUT /api/v1/records/{id}/status
+type recordStatusRequest struct {
+	Status string `json:"status" validate:"required,oneof=pending in_progress completed cancelled"`
+	Actor  string `json:"actor" validate:"max=128"`
+}
+
+// bulkIssueStatusRequest is the body of POST /api/v1/issues/bulk-status
+type bulkIssueStatusRequest struct {
+	IDs    []uint `json:"ids" validate:"required,min=1,max=500,dive,required"`
+	Status string `json:"status" validate:"required,oneof=pending in_progress completed cancelled"`
+	Actor  string `json:"actor" validate:"max=128"`
+}
+
+// legalHoldRequest is the body of POST /api/v1/admin/legal-holds
+type legalHoldRequest struct {
+	UserID    uint   `json:"user_id" validate:"required"`
+	Reason    string `json:"reason" validate:"required,max=500"`
+	Reference string `json:"reference" validate:"max=128"`
+}
+
+// cacheInvalidateRequest is the optional body of POST /api/v1/cache/invalidate
+type cacheInvalidateRequest struct {
+	Resource string `json:"resource" validate:"max=64"`
+	Pattern  string `json:"pattern" validate:"max=256"`
+}
+
+// categoryAssigneeRequest is the body of PUT /api/v1/categories/{id}/assignee; an empty assignee clears it
+type categoryAssigneeRequest struct {
+	Assignee string `json:"assignee" validate:"max=128"`
+}
+
+// customerMergeRequest is the body of POST /api/v1/customers/merge
+type customerMergeRequest struct {
+	Primary   service.CustomerIdentity `json:"primary"`
+	Secondary service.CustomerIdentity `json:"secondary"`
+	Reason    string                   `json:"reason" validate:"max=500"`
+}
+
+// loyaltyRedeemRequest is the body of POST /api/v1/loyalty/{phone}/redeem
+type loyaltyRedeemRequest struct {
+	Points int `json:"points" validate:"required,gt=0"`
+}
+
+// paymentLinkRequest is the body of POST /api/v1/orders/{id}/payment-links
+type paymentLinkRequest struct {
+	Provider string `json:"provider" validate:"required,max=32"`
+}
+
+// recordSchemaRequest is the body of POST /api/v1/admin/record-schemas; activate defaults to true
+type recordSchemaRequest struct {
+	Type        string          `json:"type" validate:"required,max=64"`
+	Schema      json.RawMessage `json:"schema" validate:"required"`
+	Description string          `json:"description" validate:"max=500"`
+	Activate    *bool           `json:"activate"`
+}
+
+// serviceAccountRequest is the body of POST /api/v1/admin/service-accounts; the tenant defaults to the default tenant
+type serviceAccountRequest struct {
+	Tenant      string   `json:"tenant" validate:"max=64"`
+	Name        string   `json:"name" validate:"required,max=64"`
+	Description string   `json:"description" validate:"max=500"`
+	Permissions []string `json:"permissions" validate:"dive,required,max=64"`
+}
+
+// tenantRequest is the body of POST /api/v1/admin/tenants
+type tenantRequest struct {
+	Slug string `json:"slug" validate:"required,max=64"`
+	Name string `json:"name" validate:"required,max=128"`
+}
+
+// twoFactorCodeRequest is the body of the 2FA confirm and verify routes
+type twoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+// walletCreditRequest is the body of POST /api/v1/wallets/{phone}/credits
+type walletCreditRequest struct {
+	Amount  float64 `json:"amount" validate:"required,gt=0"`
+	OrderID string  `json:"order_id" validate:"max=64"`
+	Reason  string  `json:"reason" validate:"max=500"`
+}
+
+// applyCreditRequest is the body of POST /api/v1/orders/{id}/apply-credit; a zero max_amount applies the whole balance
+// of the order customer's wallet
+type applyCreditRequest struct {
+	MaxAmount float64 `json:"max_amount" validate:"gte=0"`
+}
+
+// webhookRequest is the body of POST /api/v1/webhooks
+type webhookRequest struct {
+	URL    string   `json:"url" validate:"required,url,max=2048"`
+	Secret string   `json:"secret" validate:"omitempty,min=16,max=256"`
+	Events []string `json:"events" validate:"dive,oneof=issue.created issue.resolved order.status_changed"`
+}
+
+// tagRequest is the body of POST /api/v1/records/{id}/tags
+type tagRequest struct {
+	Tags []string `json:"tags" validate:"required,min=1,max=20,dive,min=1,max=40"`
+}
+
+// savedFilterRequest is the body of PUT /api/v1/records/filters/{name}
+type savedFilterRequest struct {
+	Query string `json:"query" validate:"max=1024"`
+}
+
+// addressRequest is the body of POST /api/v1/customers/{phone}/addresses
+type addressRequest struct {
+	Label       string   `json:"label" validate:"max=64"`
+	Recipient   string   `json:"recipient" validate:"max=128"`
+	Line1       string   `json:"line1" validate:"required,max=256"`
+	Line2       string   `json:"line2" validate:"max=256"`
+	City        string   `json:"city" validate:"required,max=128"`
+	Governorate string   `json:"governorate" validate:"max=64"`
+	PostalCode  string   `json:"postal_code" validate:"max=16"`
+	Country     string   `json:"country" validate:"omitempty,len=2"`
+	Latitude    *float64 `json:"latitude" validate:"omitempty,min=-90,max=90"`
+	Longitude   *float64 `json:"longitude" validate:"omitempty,min=-180,max=180"`
+	Default     bool     `json:"default"`
+}
+
+// defaultAddressRequest is the body of PUT /api/v1/customers/{phone}/addresses/default
+type defaultAddressRequest struct {
+	AddressID uint `json:"address_id" validate:"required"`
+}
+
+// reservationRequest is the body of POST /api/v1/reservations
+type reservationRequest struct {
+	ProductID string `json:"product_id" validate:"required,max=128"`
+	Quantity  int    `json:"quantity" validate:"required,min=1,max=1000"`
+	Phone     string `json:"phone" validate:"required,max=32"`
+	UserID    uint   `json:"user_id"`
+	TTL       string `json:"ttl" validate:"max=16"`
+}
+
+// loginRequest is the body of POST /api/v1/auth/login
+type loginRequest struct {
+	Username string `json:"username" validate:"required,max=64"`
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+// refreshRequest is the body of POST /api/v1/auth/refresh
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=1024"`
+}
+
+// userRequest is the body of POST /api/v1/admin/users; the tenant defaults to the default tenant
+type userRequest struct {
+	Username string `json:"username" validate:"required,max=64"`
+	Email    string `json:"email" validate:"omitempty,email,max=254"`
+	Password string `json:"password" validate:"required,max=72"`
+	Role     string `json:"role" validate:"required,max=64"`
+	Tenant   string `json:"tenant" validate:"max=64"`
+}
+
+// userUpdateRequest is the body of PATCH /api/v1/admin/users/{id}; absent fields are kept
+type userUpdateRequest struct {
+	Email    *string `json:"email" validate:"omitempty,max=254"`
+	Role     *string `json:"role" validate:"omitempty,max=64"`
+	Password *string `json:"password" validate:"omitempty,max=72"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// featureFlagRequest is the body of PUT /api/v1/admin/flags/{name}; an empty tenant sets the value
+// for every tenant
+type featureFlagRequest struct {
+	Enabled     *bool  `json:"enabled" validate:"required"`
+	Tenant      string `json:"tenant" validate:"max=64"`
+	Description string `json:"description" validate:"max=256"`
+}
+
+// consentRequest is the body of PUT /api/v1/customers/{phone}/consent
+type consentRequest struct {
+	Status string `json:"status" validate:"required,oneof=granted opted_out"`
+	Source string `json:"source" validate:"max=64"`
+	Note   string `json:"note" validate:"max=512"`
+}
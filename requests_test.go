@@ -0,0 +1,121 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBindJSON(t *testing.T) {
+	bind := func(body string, dst interface{}) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		bindJSON(recorder, httptest.NewRequest("POST", "/", strings.NewReader(body)), dst)
+		return recorder
+	}
+
+	var status recordStatusRequest
+	if recorder := bind(`{"status": "in_progress", "actor": "agent"}`, &status); recorder.Code != http.StatusOK || status.Status != service.StatusInProgress {
+		t.Fatalf("valid body rejected: %d %s", recorder.Code, recorder.Body)
+	}
+	if recorder := bind(`{"status": `, &status); recorder.Code != http.StatusBadRequest {
+		t.Errorf("malformed JSON: expected 400, got %d", recorder.Code)
+	}
+
+	recorder := bind(`{"status": "done", "actor": "`+strings.Repeat("a", 129)+`"}`, &recordStatusRequest{})
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", recorder.Code)
+	}
+	var response ValidationErrorResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]FieldError{}
+	for _, field := range response.Fields {
+		fields[field.Field] = field
+	}
+	if fields["status"].Rule != "oneof" || !strings.Contains(fields["status"].Message, "in_progress") || fields["actor"].Rule != "max" {
+		t.Errorf("unexpected field errors: %+v", response.Fields)
+	}
+
+	if recorder := bind(`{"permissions": ["records:read", ""]}`, &serviceAccountRequest{}); recorder.Code != http.StatusUnprocessableEntity ||
+		!strings.Contains(recorder.Body.String(), `"name"`) || !strings.Contains(recorder.Body.String(), `"permissions[1]"`) {
+		t.Errorf("expected name and permissions[1] errors, got %s", recorder.Body)
+	}
+	var rules []service.ClassificationRule
+	if recorder := bind(`[{"name": "late"}]`, &rules); recorder.Code != http.StatusOK {
+		t.Errorf("untagged bodies pass through, got %d %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestLimitBodies(t *testing.T) {
+	handler := limitBodies(64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input createRecordRequest
+		if bindJSON(w, r, &input) {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	send := func(contentType, body string, chunked bool) int {
+		req := httptest.NewRequest("POST", "/api/v1/records", strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if chunked {
+			req.ContentLength = -1
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	small := `{"type": "issue", "details": {"note": "late"}}`
+	large := `{"type": "issue", "details": {"note": "` + strings.Repeat("x", 100) + `"}}`
+	for _, c := range []struct {
+		name, contentType, body string
+		chunked                 bool
+		want                    int
+	}{
+		{"json", "application/json; charset=utf-8", small, false, http.StatusCreated},
+		{"no content type", "", small, false, http.StatusCreated},
+		{"json suffix", "application/merge-patch+json", small, false, http.StatusCreated},
+		{"declared too large", "application/json", large, false, http.StatusRequestEntityTooLarge},
+		{"streamed too large", "application/json", large, true, http.StatusRequestEntityTooLarge},
+		{"form", "application/x-www-form-urlencoded", "type=issue", false, http.StatusUnsupportedMediaType},
+		{"xml", "text/xml", "<issue/>", false, http.StatusUnsupportedMediaType},
+		{"malformed", "application/json;;", small, false, http.StatusUnsupportedMediaType},
+	} {
+		if got := send(c.contentType, c.body, c.chunked); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestBindIDList(t *testing.T) {
+	bind := func(ids string) (batchLookupQuery, *httptest.ResponseRecorder) {
+		recorder := httptest.NewRecorder()
+		query, _ := bindIDList(recorder, httptest.NewRequest("GET", "/api/v1/orders?ids="+ids, nil))
+		return query, recorder
+	}
+	if query, recorder := bind("a,%20b,,c"); recorder.Code != http.StatusOK || len(query.IDs) != 3 {
+		t.Fatalf("valid list rejected: %d %v", recorder.Code, query.IDs)
+	}
+	if _, recorder := bind(","); recorder.Code != http.StatusBadRequest {
+		t.Errorf("empty list: expected 400, got %d", recorder.Code)
+	}
+	ids := make([]string, service.MaxBatchLookupIDs+1)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i + 1)
+	}
+	if _, recorder := bind(strings.Join(ids[:service.MaxBatchLookupIDs], ",")); recorder.Code != http.StatusOK {
+		t.Errorf("%d IDs: expected 200, got %d", service.MaxBatchLookupIDs, recorder.Code)
+	}
+	_, recorder := bind(strings.Join(ids, ","))
+	var response ValidationErrorResponse
+	if recorder.Code != http.StatusBadRequest || json.Unmarshal(recorder.Body.Bytes(), &response) != nil ||
+		len(response.Fields) != 1 || response.Fields[0].Field != "ids" || response.Fields[0].Rule != "max" {
+		t.Errorf("oversized list: got %d %s", recorder.Code, recorder.Body)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultReservationTTL is how long a reservation holds stock without RESERVATION_TTL or a ttl in the request
+var defaultReservationTTL = 15 * time.Minute
+
+// reservationStatus maps the errors of the reservation service to a status code
+func reservationStatus(err error) int {
+	var insufficient *service.InsufficientStockError
+	switch {
+	case errors.Is(err, service.ErrReservationNotFound), errors.Is(err, service.ErrStockNotSynced):
+		return http.StatusNotFound
+	case errors.As(err, &insufficient), errors.Is(err, service.ErrReservationClosed):
+		return http.StatusConflict
+	}
+	return serviceStatus(err, http.StatusInternalServerError)
+}
+
+// writeReservationError answers a shortage with the units still available, other errors with their status
+func writeReservationError(w http.ResponseWriter, r *http.Request, err error) {
+	var insufficient *service.InsufficientStockError
+	if errors.As(err, &insufficient) {
+		writeJSON(w, r, http.StatusConflict, map[string]interface{}{
+			"error":      "insufficient stock",
+			"message":    err.Error(),
+			"product_id": insufficient.ProductID,
+			"available":  insufficient.Available,
+		})
+		return
+	}
+	writeError(w, err.Error(), reservationStatus(err))
+}
+
+// registerReservationRoutes mounts the stock reservations the chatbot takes when an order intent starts
+func registerReservationRoutes(r chi.Router, reservationService service.ReservationService) {
+	// {"product_id": "p1", "quantity": 1, "phone": "+21698111222", "ttl": "10m"}; 409 when the
+	// synced stock minus the other reservations does not cover the quantity
+	r.Post("/api/v1/reservations", func(w http.ResponseWriter, r *http.Request) {
+		var input reservationRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		var ttl time.Duration
+		if input.TTL != "" {
+			parsed, err := time.ParseDuration(input.TTL)
+			if err != nil || parsed <= 0 {
+				writeError(w, "Invalid ttl, expected a duration such as 10m", http.StatusBadRequest)
+				return
+			}
+			ttl = parsed
+		}
+		reservation, err := reservationService.Reserve(tenantFrom(r).ID, service.ReservationRequest{
+			ProductID: input.ProductID,
+			Quantity:  input.Quantity,
+			Phone:     input.Phone,
+			UserID:    input.UserID,
+			TTL:       ttl,
+		})
+		if err != nil {
+			writeReservationError(w, r, err)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/reservations/"+strconv.FormatUint(uint64(reservation.ID), 10))
+		writeJSON(w, r, http.StatusCreated, reservation)
+	})
+
+	// ?phone= lists the live reservations of one customer
+	r.Get("/api/v1/reservations", func(w http.ResponseWriter, r *http.Request) {
+		reservations, err := reservationService.ListReservations(tenantFrom(r).ID, r.URL.Query().Get("phone"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, reservations)
+	})
+
+	r.Get("/api/v1/reservations/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		reservation, err := reservationService.GetReservation(tenantFrom(r).ID, uint(id))
+		if err != nil {
+			writeError(w, err.Error(), reservationStatus(err))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, reservation)
+	})
+
+	// The chat ended without an order: the units go back to the other sessions
+	r.Delete("/api/v1/reservations/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		reservation, err := reservationService.Release(tenantFrom(r).ID, uint(id))
+		if err != nil {
+			writeError(w, err.Error(), reservationStatus(err))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, reservation)
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// purgeJobType is the job queue type of the retention run
+const purgeJobType = "purge_records"
+
+// retentionPolicy is how long interactions keep their personal data and how long they are kept;
+// a zero policy disables the retention job
+var retentionPolicy service.RetentionPolicy
+
+// loadRetentionPolicy reads RECORD_ANONYMIZE_AFTER and RECORD_RETENTION (durations such as 2160h) and the
+// detail paths RETENTION_HASH_FIELDS and RETENTION_DROP_FIELDS
+func loadRetentionPolicy() error {
+	policy := service.RetentionPolicy{
+		AnonymizeAfter: durationEnv("RECORD_ANONYMIZE_AFTER", 0),
+		DeleteAfter:    durationEnv("RECORD_RETENTION", 0),
+		HashFields:     service.DefaultAnonymizeHashFields,
+		DropFields:     service.DefaultAnonymizeDropFields,
+	}
+	if value := os.Getenv("RETENTION_HASH_FIELDS"); value != "" {
+		policy.HashFields = splitList(value)
+	}
+	if value := os.Getenv("RETENTION_DROP_FIELDS"); value != "" {
+		policy.DropFields = splitList(value)
+	}
+	if policy.AnonymizeAfter > 0 && policy.DeleteAfter > 0 && policy.AnonymizeAfter >= policy.DeleteAfter {
+		return fmt.Errorf("RECORD_ANONYMIZE_AFTER (%v) must be shorter than RECORD_RETENTION (%v)", policy.AnonymizeAfter, policy.DeleteAfter)
+	}
+	retentionPolicy = policy
+	return nil
+}
+
+// applyRetention runs the policy for every customer not under legal hold
+func applyRetention(retentionService service.RetentionService, legalHoldService service.LegalHoldService, dryRun bool) (service.RetentionReport, error) {
+	if !retentionPolicy.Enabled() {
+		return service.RetentionReport{}, fmt.Errorf("record retention is not configured")
+	}
+	held, err := legalHoldService.HeldUserIDs()
+	if err != nil {
+		return service.RetentionReport{}, err
+	}
+	return retentionService.Apply(retentionPolicy, held, dryRun)
+}
+
+// registerPurgeJob registers the retention handler; customers under legal hold are skipped
+func registerPurgeJob(jobService service.JobService, retentionService service.RetentionService, legalHoldService service.LegalHoldService) {
+	jobService.RegisterHandler(purgeJobType, func(payload json.RawMessage) (interface{}, error) {
+		report, err := applyRetention(retentionService, legalHoldService, false)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Retention run anonymized %d and deleted %d records", report.Anonymized, report.Deleted)
+		return report, nil
+	})
+}
+
+// schedulePurge enqueues the retention run every interval while retention is configured
+func schedulePurge(jobService service.JobService, interval time.Duration) {
+	if !retentionPolicy.Enabled() {
+		return
+	}
+	scheduleJob(jobService, purgeJobType, interval)
+}
+
+// registerRetentionAdminRoutes mounts the retention report and the manual retention run
+func registerRetentionAdminRoutes(r chi.Router, jobService service.JobService, retentionService service.RetentionService, legalHoldService service.LegalHoldService) {
+	// Dry run: how many records the next run would anonymize and delete
+	r.Get("/retention", func(w http.ResponseWriter, r *http.Request) {
+		if !retentionPolicy.Enabled() {
+			writeError(w, "RECORD_RETENTION and RECORD_ANONYMIZE_AFTER are not configured", http.StatusConflict)
+			return
+		}
+		report, err := applyRetention(retentionService, legalHoldService, true)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, report)
+	})
+
+	// Runs the retention job now instead of waiting for the schedule
+	r.Post("/purge", func(w http.ResponseWriter, r *http.Request) {
+		if !retentionPolicy.Enabled() {
+			writeError(w, "RECORD_RETENTION and RECORD_ANONYMIZE_AFTER are not configured", http.StatusConflict)
+			return
+		}
+		job, err := jobService.Enqueue(tenantFrom(r).ID, purgeJobType, nil)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusAccepted, job)
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubLegalHolds reports a fixed set of held customers
+type stubLegalHolds struct {
+	service.LegalHoldService
+	held []uint
+	err  error
+}
+
+func (s stubLegalHolds) HeldUserIDs() ([]uint, error) {
+	return s.held, s.err
+}
+
+// recordingRetention records the customers a retention run was told to skip
+type recordingRetention struct {
+	skipped []uint
+	dryRun  bool
+	calls   int
+}
+
+func (s *recordingRetention) Apply(policy service.RetentionPolicy, skipUserIDs []uint, dryRun bool) (service.RetentionReport, error) {
+	s.calls++
+	s.skipped, s.dryRun = skipUserIDs, dryRun
+	return service.RetentionReport{DryRun: dryRun, SkippedUsers: skipUserIDs}, nil
+}
+
+func TestApplyRetentionSkipsHeldCustomers(t *testing.T) {
+	saved := retentionPolicy
+	defer func() { retentionPolicy = saved }()
+
+	for _, c := range []struct {
+		name    string
+		policy  service.RetentionPolicy
+		holds   stubLegalHolds
+		wantErr string
+		want    []uint
+	}{
+		{"disabled policy", service.RetentionPolicy{}, stubLegalHolds{held: []uint{7}}, "not configured", nil},
+		{"hold lookup fails", service.RetentionPolicy{DeleteAfter: time.Hour}, stubLegalHolds{err: errors.New("db down")}, "db down", nil},
+		{"held customers skipped", service.RetentionPolicy{DeleteAfter: time.Hour}, stubLegalHolds{held: []uint{7, 9}}, "", []uint{7, 9}},
+		{"no holds", service.RetentionPolicy{AnonymizeAfter: time.Hour}, stubLegalHolds{}, "", nil},
+	} {
+		retentionPolicy = c.policy
+		retention := &recordingRetention{}
+		_, err := applyRetention(retention, c.holds, true)
+		if c.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) || retention.calls != 0 {
+				t.Errorf("%s: got %v after %d runs, want %q and no run", c.name, err, retention.calls, c.wantErr)
+			}
+			continue
+		}
+		if err != nil || retention.calls != 1 || !retention.dryRun || !reflect.DeepEqual(retention.skipped, c.want) {
+			t.Errorf("%s: err %v, skipped %v (dry run %v), want %v", c.name, err, retention.skipped, retention.dryRun, c.want)
+		}
+	}
+}
+
+func TestLegalHoldRequestValidation(t *testing.T) {
+	for _, c := range []struct {
+		body string
+		want int
+	}{
+		{`{"user_id": 7, "reason": "litigation", "reference": "case-12"}`, http.StatusOK},
+		{`{"reason": "litigation"}`, http.StatusUnprocessableEntity},
+		{`{"user_id": 7}`, http.StatusUnprocessableEntity},
+		{`{"user_id": 7, "reason": "` + strings.Repeat("a", 501) + `"}`, http.StatusUnprocessableEntity},
+		{`{"user_id": 7, "reason": "litigation", "reference": "` + strings.Repeat("r", 129) + `"}`, http.StatusUnprocessableEntity},
+	} {
+		recorder := httptest.NewRecorder()
+		bindJSON(recorder, httptest.NewRequest("POST", "/", strings.NewReader(c.body)), &legalHoldRequest{})
+		if recorder.Code != c.want {
+			t.Errorf("%.60s: got %d, want %d", c.body, recorder.Code, c.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// reclassifyJobType is the job queue type that applies the classification rules to stored records
+const reclassifyJobType = "reclassify_records"
+
+// reclassifyJobPayload selects the tenant and, optionally, the record type to reclassify
+type reclassifyJobPayload struct {
+	tenantJobPayload
+	Type string `json:"type,omitempty"`
+}
+
+// registerReclassifyJob registers the handler that reapplies the rules of each tenant
+func registerReclassifyJob(jobService service.JobService, tenantService service.TenantService, ruleService service.RuleService) {
+	jobService.RegisterHandler(reclassifyJobType, func(payload json.RawMessage) (interface{}, error) {
+		tenants, err := jobTenants(tenantService, payload)
+		if err != nil {
+			return nil, err
+		}
+		var input reclassifyJobPayload
+		if len(payload) > 0 && string(payload) != "null" {
+			json.Unmarshal(payload, &input)
+		}
+		changed := make(map[string]int)
+		for _, tenant := range tenants {
+			count, err := ruleService.Reclassify(tenant.ID, input.Type)
+			if err != nil {
+				log.Printf("Reclassification for tenant %s failed: %v", tenant.Slug, err)
+				continue
+			}
+			changed[tenant.Slug] = count
+		}
+		return changed, nil
+	})
+}
+
+// enqueueReclassify starts the reclassification of the request tenant's records
+func enqueueReclassify(w http.ResponseWriter, r *http.Request, jobService service.JobService) {
+	job, err := jobService.Enqueue(tenantFrom(r).ID, reclassifyJobType, reclassifyJobPayload{
+		tenantJobPayload: tenantJobPayload{Tenant: tenantFrom(r).Slug},
+		Type:             r.URL.Query().Get("type"),
+	})
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, http.StatusAccepted, job)
+}
+
+// registerRuleRoutes mounts the classification rules applied to new records
+func registerRuleRoutes(r chi.Router, jobService service.JobService, ruleService service.RuleService) {
+	r.Get("/api/v1/rules", func(w http.ResponseWriter, r *http.Request) {
+		rules, err := ruleService.ListRules(tenantFrom(r).ID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, rules)
+	})
+
+	// Replaces the whole rule set; rules apply in the order given.
+	// PUT /api/v1/rules?reclassify=true also reapplies them to the stored records.
+	r.With(adminOnly).Put("/api/v1/rules", func(w http.ResponseWriter, r *http.Request) {
+		var input []service.ClassificationRule
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		rules, err := ruleService.ReplaceRules(tenantFrom(r).ID, input)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Classification rules of tenant %s replaced by %s (%d rules)", tenantFrom(r).Slug, adminActor(r), len(rules))
+		if r.URL.Query().Get("reclassify") == "true" {
+			enqueueReclassify(w, r, jobService)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, rules)
+	})
+
+	// POST /api/v1/rules/reclassify?type=issue
+	r.With(adminOnly).Post("/api/v1/rules/reclassify", func(w http.ResponseWriter, r *http.Request) {
+		enqueueReclassify(w, r, jobService)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"convertyApi/service"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// defaultOAuthScopes are requested from Converty when OAUTH_SCOPES is unset
+const defaultOAuthScopes = "read-products create-orders update-orders read-orders"
+
+// oauthScopes is the base set of scopes every authorization requests
+var oauthScopes = strings.Fields(defaultOAuthScopes)
+
+// validScope matches the scope tokens allowed by RFC 6749
+var validScope = regexp.MustCompile(`^[\x21\x23-\x5B\x5D-\x7E]+$`)
+
+// loadOAuthScopes reads OAUTH_SCOPES (space or comma separated)
+func loadOAuthScopes() error {
+	value := os.Getenv("OAUTH_SCOPES")
+	if value == "" {
+		return nil
+	}
+	scopes, err := parseScopes(value)
+	if err != nil {
+		return err
+	}
+	if len(scopes) == 0 {
+		return fmt.Errorf("OAUTH_SCOPES is empty")
+	}
+	oauthScopes = scopes
+	return nil
+}
+
+// parseScopes splits a space or comma separated scope list
+func parseScopes(value string) ([]string, error) {
+	scopes := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	for _, scope := range scopes {
+		if !validScope.MatchString(scope) {
+			return nil, fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	return scopes, nil
+}
+
+// requestedScopes is what a login asks for: the base scopes, those the user's token already has
+// and the extra ones of /login?scopes=..., so granting a scope never drops another
+func requestedScopes(r *http.Request, userID string) ([]string, error) {
+	extra, err := parseScopes(r.URL.Query().Get("scopes"))
+	if err != nil {
+		return nil, err
+	}
+	var existing TokenInfo
+	db.Select("scopes").Where("user_id = ?", userID).Limit(1).Find(&existing)
+	return service.MergeScopes(oauthScopes, strings.Fields(existing.Scopes), extra), nil
+}
+
+// grantedScopes returns the scopes of a new token: the provider's answer when it reports one,
+// otherwise the scopes that were requested
+func grantedScopes(tokenResp TokenResponse, requested []string) []string {
+	if tokenResp.Scope != "" {
+		return strings.Fields(tokenResp.Scope)
+	}
+	if len(requested) > 0 {
+		return requested
+	}
+	return oauthScopes
+}
+
+// clearMissingScopes forgets the refused scopes a new authorization has granted
+func clearMissingScopes(userID string, granted []string) error {
+	var tokenInfo TokenInfo
+	if err := db.Select("missing_scopes").Where("user_id = ?", userID).Take(&tokenInfo).Error; err != nil {
+		return err
+	}
+	missing := service.RemoveScopes(strings.Fields(tokenInfo.MissingScopes), granted)
+	defer service.Caches.TokenChanged(userID)
+	return db.Model(&TokenInfo{}).Where("user_id = ?", userID).Update("missing_scopes", strings.Join(missing, " ")).Error
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// managedSecrets are the variables a secret manager supplies when SECRETS_PROVIDER is set
+var managedSecrets = []string{"CLIENT_SECRET", "DB_PASSWORD", "PII_ENCRYPTION_KEY", "PII_PREVIOUS_ENCRYPTION_KEYS"}
+
+// secretCache holds the secrets read from the secret manager; nil when they come from the
+// environment and .env
+var secretCache *service.SecretCache
+
+// clientSecretMu guards clientSecret, which a secret rotation replaces while requests read it
+var clientSecretMu sync.RWMutex
+
+// oauthClientSecret returns the Converty client secret in force
+func oauthClientSecret() string {
+	clientSecretMu.RLock()
+	defer clientSecretMu.RUnlock()
+	return clientSecret
+}
+
+// secretProvider builds the secret manager of SECRETS_PROVIDER: "vault" reads VAULT_ADDR, VAULT_TOKEN,
+// VAULT_SECRET_PATH and VAULT_NAMESPACE, "aws" reads AWS_REGION, AWS_SECRET_ID, AWS_SECRETS_ENDPOINT and
+// the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN credentials. It is nil for "env" or
+// an empty setting.
+func secretProvider(getenv func(string) string) (service.SecretProvider, error) {
+	switch name := getenv("SECRETS_PROVIDER"); name {
+	case "", "env":
+		return nil, nil
+	case "vault":
+		provider := &service.VaultSecretProvider{
+			Addr:      getenv("VAULT_ADDR"),
+			Token:     getenv("VAULT_TOKEN"),
+			Path:      getenv("VAULT_SECRET_PATH"),
+			Namespace: getenv("VAULT_NAMESPACE"),
+		}
+		if provider.Addr == "" || provider.Token == "" || provider.Path == "" {
+			return nil, fmt.Errorf("SECRETS_PROVIDER=vault needs VAULT_ADDR, VAULT_TOKEN and VAULT_SECRET_PATH")
+		}
+		return provider, nil
+	case "aws":
+		region := getenv("AWS_REGION")
+		if region == "" {
+			region = getenv("AWS_DEFAULT_REGION")
+		}
+		provider := &service.AWSSecretProvider{
+			Region:       region,
+			SecretID:     getenv("AWS_SECRET_ID"),
+			AccessKey:    getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: getenv("AWS_SESSION_TOKEN"),
+			Endpoint:     getenv("AWS_SECRETS_ENDPOINT"),
+		}
+		if provider.Region == "" || provider.SecretID == "" || provider.AccessKey == "" || provider.SecretKey == "" {
+			return nil, fmt.Errorf("SECRETS_PROVIDER=aws needs AWS_REGION, AWS_SECRET_ID, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("unknown SECRETS_PROVIDER %q, use env, vault or aws", name)
+	}
+}
+
+// loadSecrets reads the managed secrets from the secret manager into the environment, where they
+// replace the values of .env; secrets the manager does not hold keep them
+func loadSecrets() error {
+	provider, err := secretProvider(os.Getenv)
+	if err != nil || provider == nil {
+		return err
+	}
+	cache := service.NewSecretCache(provider)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if _, err := cache.Refresh(ctx); err != nil {
+		return err
+	}
+	var loaded []string
+	for _, name := range managedSecrets {
+		if value, ok := cache.Get(name); ok {
+			os.Setenv(name, value)
+			loaded = append(loaded, name)
+		}
+	}
+	if len(loaded) == 0 {
+		return fmt.Errorf("%s holds none of %s", provider.Name(), strings.Join(managedSecrets, ", "))
+	}
+	log.Printf("Loaded %s from %s", strings.Join(loaded, ", "), provider.Name())
+	secretCache = cache
+	service.Caches.Register(service.CacheSecrets, cache)
+	return nil
+}
+
+// watchSecrets reads the secrets again every SECRETS_REFRESH_INTERVAL (15m by default), and right
+// away when the secrets cache is invalidated, applying the rotated ones
+func watchSecrets(jobService service.JobService) {
+	if secretCache == nil {
+		return
+	}
+	secretCache.OnChange(func(name, value string) { applyRotatedSecret(name, value, jobService) })
+	go secretCache.Watch(context.Background(), durationEnv("SECRETS_REFRESH_INTERVAL", 15*time.Minute))
+}
+
+// applyRotatedSecret puts a rotated secret in force: the next Converty token exchange uses a new
+// CLIENT_SECRET and the next database connection a new DB_PASSWORD, while open connections stay
+// up. A new PII_ENCRYPTION_KEY seals new values right away and a job re-encrypts the stored ones;
+// each value names its key, so the previous key opens the others until the job is done.
+func applyRotatedSecret(name, value string, jobService service.JobService) {
+	switch name {
+	case "CLIENT_SECRET":
+		os.Setenv(name, value)
+		clientSecretMu.Lock()
+		clientSecret = value
+		clientSecretMu.Unlock()
+	case "DB_PASSWORD":
+		os.Setenv(name, value)
+	case "PII_ENCRYPTION_KEY":
+		if err := rotatePIIKey(value, jobService); err != nil {
+			log.Printf("PII_ENCRYPTION_KEY changed in %s; keeping the current key: %v", secretCache.Provider(), err)
+			return
+		}
+		os.Setenv(name, value)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// compactKeys maps full field names to the abbreviated names used in compact mode.
+// Fields not listed keep their original name. No two fields share an abbreviation, and no
+// abbreviation is itself a listed field, so a compact body can be expanded back.
+var compactKeys = map[string]string{
+	"address":          "adr",
+	"archived":         "arc",
+	"city":             "cty",
+	"created_at":       "ca",
+	"customer":         "cu",
+	"data":             "d",
+	"description":      "dsc",
+	"details":          "dt",
+	"email":            "em",
+	"message":          "msg",
+	"name":             "n",
+	"note":             "nt",
+	"phone":            "ph",
+	"phone_number":     "phn",
+	"price":            "pr",
+	"product":          "prd",
+	"quantity":         "qty",
+	"status":           "st",
+	"success":          "ok",
+	"title":            "ttl",
+	"type":             "t",
+	"updated_at":       "ua",
+	"user_id":          "uid",
+	"deliveryCompany":  "dlc",
+	"delivery_company": "dc",
+}
+
+// isCompact reports whether the client asked for the low-bandwidth representation
+func isCompact(r *http.Request) bool {
+	return r.URL.Query().Get("compact") == "true"
+}
+
+// writeJSON encodes v as the response body, applying compact mode when requested
+func writeJSON(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}) {
+	_, span := tracer().Start(r.Context(), "json.marshal")
+	body, err := json.Marshal(v)
+	span.SetAttributes(attribute.Int("response.size", len(body)))
+	span.End()
+	if err != nil {
+		writeError(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeRawJSON(w, r, statusCode, body)
+}
+
+// writeRawJSON writes an already-encoded JSON body, applying field selection and compact mode when requested
+func writeRawJSON(w http.ResponseWriter, r *http.Request, statusCode int, body []byte) {
+	_, span := tracer().Start(r.Context(), "json.write")
+	defer span.End()
+	if paths := selectedFields(r); len(paths) > 0 && statusCode < 300 {
+		if projected, err := projectJSON(body, paths); err == nil {
+			body = projected
+		} else {
+			log.Printf("Failed to select response fields, sending full payload: %v", err)
+		}
+	}
+	if isCompact(r) {
+		if compacted, err := compactJSON(body); err == nil {
+			body = compacted
+		} else {
+			log.Printf("Failed to compact response, sending full payload: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// compactJSON rewrites a JSON document with abbreviated keys and without null or empty-string values
+func compactJSON(body []byte) ([]byte, error) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(compactValue(value))
+}
+
+func compactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if item == nil {
+				continue
+			}
+			if s, ok := item.(string); ok && s == "" {
+				continue
+			}
+			// A field keeps its name when its abbreviation is already a field of the object
+			if short, ok := compactKeys[key]; ok {
+				if _, taken := v[short]; !taken {
+					key = short
+				}
+			}
+			out[key] = compactValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = compactValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// selectedFields parses ?fields=id,status,details.customer.phone into the dotted paths to keep
+func selectedFields(r *http.Request) [][]string {
+	var paths [][]string
+	for _, field := range splitList(r.URL.Query().Get("fields")) {
+		paths = append(paths, strings.Split(field, "."))
+	}
+	return paths
+}
+
+// projectJSON keeps only the selected paths of the resources of a response: the document itself, each
+// element of an array, or the "data" member of page and Converty envelopes, whose other members stay
+func projectJSON(body []byte, paths [][]string) ([]byte, error) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, err
+	}
+	if envelope, ok := value.(map[string]interface{}); ok {
+		if data, ok := envelope["data"]; ok {
+			envelope["data"], _ = projectValue(data, paths)
+			return json.Marshal(envelope)
+		}
+	}
+	projected, _ := projectValue(value, paths)
+	return json.Marshal(projected)
+}
+
+// projectValue keeps the paths of an object and of each object of an array. Paths missing from the
+// document are skipped; false is returned when nothing of value was selected.
+func projectValue(value interface{}, paths [][]string) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		nested := map[string][][]string{}
+		whole := map[string]bool{}
+		for _, path := range paths {
+			if len(path) == 1 {
+				whole[path[0]] = true
+			} else {
+				nested[path[0]] = append(nested[path[0]], path[1:])
+			}
+		}
+		out := map[string]interface{}{}
+		for key, item := range v {
+			if whole[key] {
+				out[key] = item
+			} else if rest, ok := nested[key]; ok {
+				if projected, ok := projectValue(item, rest); ok {
+					out[key] = projected
+				}
+			}
+		}
+		return out, len(out) > 0
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i], _ = projectValue(item, paths)
+		}
+		return out, true
+	default:
+		// a path going below a scalar selects nothing
+		return nil, false
+	}
+}
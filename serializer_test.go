@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestCompactJSON(t *testing.T) {
+	in := []byte(`[{"id":1,"user_id":7,"type":"issue","status":"","details":{"name":"Amine","note":null}}]`)
+	out, err := compactJSON(in)
+	if err != nil {
+		t.Fatalf("compactJSON failed: %v", err)
+	}
+	want := `[{"dt":{"n":"Amine"},"id":1,"t":"issue","uid":7}]`
+	if string(out) != want {
+		t.Fatalf("unexpected compact output:\n got  %s\n want %s", out, want)
+	}
+}
+
+func TestCompactKeysAreInjective(t *testing.T) {
+	owners := map[string]string{}
+	for full, short := range compactKeys {
+		if other, ok := owners[short]; ok {
+			t.Errorf("%q and %q are both abbreviated %q", full, other, short)
+		}
+		owners[short] = full
+		if _, ok := compactKeys[short]; ok {
+			t.Errorf("the abbreviation %q of %q is itself an abbreviated field", short, full)
+		}
+	}
+
+	in := []byte(`{"phone":"1","phone_number":"2","deliveryCompany":"a","delivery_company":"b","ph":"3"}`)
+	out, err := compactJSON(in)
+	if err != nil {
+		t.Fatalf("compactJSON failed: %v", err)
+	}
+	want := `{"dc":"b","dlc":"a","ph":"3","phn":"2","phone":"1"}`
+	if string(out) != want {
+		t.Fatalf("unexpected compact output:\n got  %s\n want %s", out, want)
+	}
+}
+
+func TestProjectJSON(t *testing.T) {
+	paths := [][]string{{"id"}, {"status"}, {"details", "customer", "phone"}, {"items", "sku"}, {"missing"}}
+	cases := []struct{ in, want string }{
+		{
+			`{"id":1,"status":"new","type":"issue","details":{"customer":{"name":"Amine","phone":"216"},"note":"x"}}`,
+			`{"details":{"customer":{"phone":"216"}},"id":1,"status":"new"}`,
+		},
+		{
+			`[{"id":1,"status":"new","items":[{"sku":"A","qty":2}]},{"id":2,"details":"plain"}]`,
+			`[{"id":1,"items":[{"sku":"A"}],"status":"new"},{"id":2}]`,
+		},
+		{
+			`{"data":[{"id":1,"type":"issue"}],"meta":{"page":1},"links":{"next":null}}`,
+			`{"data":[{"id":1}],"links":{"next":null},"meta":{"page":1}}`,
+		},
+	}
+	for _, c := range cases {
+		out, err := projectJSON([]byte(c.in), paths)
+		if err != nil {
+			t.Fatalf("projectJSON(%s) failed: %v", c.in, err)
+		}
+		if string(out) != c.want {
+			t.Errorf("projectJSON(%s):\n got  %s\n want %s", c.in, out, c.want)
+		}
+	}
+}
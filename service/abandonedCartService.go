@@ -0,0 +1,256 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Abandoned cart statuses
+const (
+	CartOpen      = "open"
+	CartContacted = "contacted"
+	CartRecovered = "recovered"
+	CartLost      = "lost"
+	// CartSuppressed carts are not followed up: the customer has not consented to messages
+	CartSuppressed = "suppressed"
+)
+
+// Follow-up outcomes reported by the chatbot
+const (
+	FollowUpSent      = "sent"
+	FollowUpReplied   = "replied"
+	FollowUpRecovered = "recovered"
+	FollowUpDeclined  = "declined"
+)
+
+// AbandonedCart is an abandoned Converty order waiting for a recovery follow-up
+type AbandonedCart struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	TenantID       uint       `gorm:"not null;default:0;uniqueIndex:idx_abandoned_carts_tenant_order" json:"tenant_id"`
+	OrderID        string     `gorm:"not null;uniqueIndex:idx_abandoned_carts_tenant_order" json:"order_id"`
+	Customer       Customer   `gorm:"embedded;embeddedPrefix:customer_" json:"customer"`
+	Total          float64    `json:"total"`
+	Currency       string     `json:"currency"`
+	OrderCreatedAt time.Time  `json:"order_created_at"`
+	Status         string     `gorm:"not null;index" json:"status"`
+	FollowUps      int        `json:"follow_ups"`
+	LastOutcome    string     `json:"last_outcome,omitempty"`
+	LastFollowUpAt *time.Time `json:"last_follow_up_at,omitempty"`
+	FirstSeenAt    time.Time  `json:"first_seen_at"`
+	LastSeenAt     time.Time  `json:"last_seen_at"`
+}
+
+// TableName specifies the table name for AbandonedCart
+func (AbandonedCart) TableName() string {
+	return "chatbot.abandoned_carts"
+}
+
+// EnsureAbandonedCartIndex drops the unique index on the order ID alone, which predates tenants and
+// would keep two tenants from tracking the same order number
+func EnsureAbandonedCartIndex(db *gorm.DB) error {
+	if err := db.Exec("DROP INDEX IF EXISTS chatbot.idx_chatbot_abandoned_carts_order_id").Error; err != nil {
+		return fmt.Errorf("failed to drop the legacy abandoned cart index: %v", err)
+	}
+	return nil
+}
+
+// FollowUp is the chatbot's report of a recovery message
+type FollowUp struct {
+	UserID  uint   `json:"user_id"`
+	Channel string `json:"channel"`
+	Outcome string `json:"outcome"`
+	Message string `json:"message"`
+}
+
+// AbandonedCartService defines the interface for abandoned-cart recovery. The data services passed in
+// are scoped to tenantID.
+type AbandonedCartService interface {
+	Sync(tenantID uint, dataService DataService, maxPages int) (int, error)
+	ListCarts(tenantID uint, status string) ([]AbandonedCart, error)
+	GetCart(tenantID uint, orderID string) (AbandonedCart, error)
+	RecordFollowUp(tenantID uint, dataService DataService, orderID string, followUp FollowUp) (AbandonedCart, Data, error)
+}
+
+// GormAbandonedCartService implements AbandonedCartService using GORM
+type GormAbandonedCartService struct {
+	db *gorm.DB
+	// notifier tells the chatbot about newly abandoned carts so it can start a follow-up
+	notifier Notifier
+}
+
+// NewGormAbandonedCartService creates a new GormAbandonedCartService
+func NewGormAbandonedCartService(db *gorm.DB, notifier Notifier) AbandonedCartService {
+	return &GormAbandonedCartService{db: db, notifier: notifier}
+}
+
+// Sync pulls abandoned orders from Converty and stores the ones not seen before; it returns how many are new
+func (s *GormAbandonedCartService) Sync(tenantID uint, dataService DataService, maxPages int) (int, error) {
+	abandoned := true
+	query := CustomerOrderQuery{Limit: 50, Abandoned: &abandoned}
+	now := time.Now()
+	created := 0
+	for page := 1; page <= maxPages; page++ {
+		query.Page = page
+		orders, err := dataService.ListOrders(query)
+		if err != nil {
+			return created, fmt.Errorf("failed to fetch abandoned orders: %v", err)
+		}
+		for _, order := range orders {
+			isNew, err := s.upsert(tenantID, order, now)
+			if err != nil {
+				return created, err
+			}
+			if isNew {
+				created++
+				s.announce(tenantID, order)
+			}
+		}
+		if len(orders) < query.Limit {
+			break
+		}
+	}
+	return created, nil
+}
+
+// upsert stores or refreshes a cart and reports whether it was new
+func (s *GormAbandonedCartService) upsert(tenantID uint, order Order, seenAt time.Time) (bool, error) {
+	cart := AbandonedCart{
+		TenantID:       tenantID,
+		OrderID:        order.ID,
+		Customer:       order.Customer,
+		Total:          order.Total,
+		Currency:       order.Currency,
+		OrderCreatedAt: order.CreatedAt,
+		Status:         CartOpen,
+		FirstSeenAt:    seenAt,
+		LastSeenAt:     seenAt,
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true, Columns: []clause.Column{{Name: "tenant_id"}, {Name: "order_id"}}}).Create(&cart)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to save abandoned cart %s: %v", order.ID, result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+	// Already known: keep the follow-up state, refresh what Converty reports
+	if err := s.db.Model(&AbandonedCart{}).Where("tenant_id = ? AND order_id = ?", tenantID, order.ID).Updates(map[string]interface{}{
+		"total":        order.Total,
+		"currency":     order.Currency,
+		"last_seen_at": seenAt,
+	}).Error; err != nil {
+		return false, fmt.Errorf("failed to update abandoned cart %s: %v", order.ID, err)
+	}
+	return false, nil
+}
+
+// announce asks the chatbot to follow the cart up; a customer without consent gets the cart suppressed
+func (s *GormAbandonedCartService) announce(tenantID uint, order Order) {
+	err := s.notifier.Notify(Notification{
+		Event:   "abandoned_cart",
+		Message: fmt.Sprintf("Order %s was abandoned by %s", order.ID, order.Customer.Name),
+		Data: map[string]interface{}{
+			"tenant_id": tenantID,
+			"order_id":  order.ID,
+			"customer":  order.Customer,
+			"total":     order.Total,
+			"currency":  order.Currency,
+		},
+		CreatedAt: time.Now(),
+	})
+	if errors.Is(err, ErrNoConsent) {
+		if err := s.db.Model(&AbandonedCart{}).Where("tenant_id = ? AND order_id = ?", tenantID, order.ID).Update("status", CartSuppressed).Error; err != nil {
+			log.Printf("Failed to suppress abandoned cart %s: %v", order.ID, err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to announce abandoned cart %s: %v", order.ID, err)
+	}
+}
+
+// ListCarts fetches the tenant's stored carts, optionally filtered by status
+func (s *GormAbandonedCartService) ListCarts(tenantID uint, status string) ([]AbandonedCart, error) {
+	var carts []AbandonedCart
+	query := s.db.Where("tenant_id = ?", tenantID).Order("order_created_at desc")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Find(&carts).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch abandoned carts: %v", err)
+	}
+	return carts, nil
+}
+
+// GetCart fetches the cart of an order of the tenant
+func (s *GormAbandonedCartService) GetCart(tenantID uint, orderID string) (AbandonedCart, error) {
+	var cart AbandonedCart
+	if err := s.db.Where("tenant_id = ? AND order_id = ?", tenantID, orderID).First(&cart).Error; err != nil {
+		return AbandonedCart{}, fmt.Errorf("abandoned cart for order %s not found: %v", orderID, err)
+	}
+	return cart, nil
+}
+
+// cartStatusFor maps a follow-up outcome to the resulting cart status
+func cartStatusFor(outcome string) (string, error) {
+	switch outcome {
+	case FollowUpSent, FollowUpReplied:
+		return CartContacted, nil
+	case FollowUpRecovered:
+		return CartRecovered, nil
+	case FollowUpDeclined:
+		return CartLost, nil
+	default:
+		return "", fmt.Errorf("unknown follow-up outcome %q", outcome)
+	}
+}
+
+// RecordFollowUp stores the outcome of a chatbot follow-up on the cart and as an interaction record
+func (s *GormAbandonedCartService) RecordFollowUp(tenantID uint, dataService DataService, orderID string, followUp FollowUp) (AbandonedCart, Data, error) {
+	cartStatus, err := cartStatusFor(followUp.Outcome)
+	if err != nil {
+		return AbandonedCart{}, Data{}, err
+	}
+	cart, err := s.GetCart(tenantID, orderID)
+	if err != nil {
+		return AbandonedCart{}, Data{}, err
+	}
+
+	recordStatus := StatusInProgress
+	switch cartStatus {
+	case CartRecovered:
+		recordStatus = StatusCompleted
+	case CartLost:
+		recordStatus = StatusCancelled
+	}
+	record, err := dataService.InsertRecord(followUp.UserID, "abandoned_cart", map[string]interface{}{
+		"order_id": orderID,
+		"channel":  followUp.Channel,
+		"outcome":  followUp.Outcome,
+		"message":  followUp.Message,
+		"total":    cart.Total,
+		"currency": cart.Currency,
+	}, recordStatus)
+	if err != nil {
+		return AbandonedCart{}, Data{}, err
+	}
+
+	now := time.Now()
+	if err := s.db.Model(&cart).Updates(map[string]interface{}{
+		"status":            cartStatus,
+		"follow_ups":        gorm.Expr("follow_ups + 1"),
+		"last_outcome":      followUp.Outcome,
+		"last_follow_up_at": now,
+	}).Error; err != nil {
+		return AbandonedCart{}, Data{}, fmt.Errorf("failed to update abandoned cart: %v", err)
+	}
+	cart.Status = cartStatus
+	cart.FollowUps++
+	cart.LastOutcome = followUp.Outcome
+	cart.LastFollowUpAt = &now
+	return cart, record, nil
+}
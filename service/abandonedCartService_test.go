@@ -0,0 +1,24 @@
+package service
+
+import "testing"
+
+func TestCartStatusFor(t *testing.T) {
+	cases := []struct {
+		outcome string
+		want    string
+	}{
+		{FollowUpSent, CartContacted},
+		{FollowUpReplied, CartContacted},
+		{FollowUpRecovered, CartRecovered},
+		{FollowUpDeclined, CartLost},
+	}
+	for _, c := range cases {
+		got, err := cartStatusFor(c.outcome)
+		if err != nil || got != c.want {
+			t.Errorf("cartStatusFor(%q) = %q, %v, want %q", c.outcome, got, err, c.want)
+		}
+	}
+	if _, err := cartStatusFor("ignored"); err == nil {
+		t.Error("expected an error for an unknown outcome")
+	}
+}
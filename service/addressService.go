@@ -0,0 +1,415 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AddressRecordType is the record type the chatbot stores a delivery address under
+const AddressRecordType = "address"
+
+// ErrAddressNotFound is returned when a customer has no such address, or no default one
+var ErrAddressNotFound = errors.New("address not found")
+
+// tunisianPostalCode is the four-digit code of the Tunisian post
+var tunisianPostalCode = regexp.MustCompile(`^[0-9]{4}$`)
+
+// governorates maps the accepted spellings of the 24 Tunisian governorates to their name
+var governorates = map[string]string{
+	"ariana": "Ariana", "beja": "Beja", "ben arous": "Ben Arous", "bizerte": "Bizerte", "gabes": "Gabes",
+	"gafsa": "Gafsa", "jendouba": "Jendouba", "kairouan": "Kairouan", "kasserine": "Kasserine",
+	"kebili": "Kebili", "kef": "Kef", "le kef": "Kef", "mahdia": "Mahdia", "manouba": "Manouba",
+	"la manouba": "Manouba", "medenine": "Medenine", "monastir": "Monastir", "nabeul": "Nabeul",
+	"sfax": "Sfax", "sidi bouzid": "Sidi Bouzid", "siliana": "Siliana", "sousse": "Sousse",
+	"tataouine": "Tataouine", "tozeur": "Tozeur", "tunis": "Tunis", "zaghouan": "Zaghouan",
+}
+
+// unaccented folds the accents of governorate names as customers type them: Béja, Gabès, Médenine
+var unaccented = strings.NewReplacer("é", "e", "è", "e", "ê", "e", "É", "e", "È", "e")
+
+// Address is a delivery address in a customer's address book; customers are identified by phone
+type Address struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	TenantID    uint   `gorm:"not null;default:0;index:idx_addresses_customer" json:"tenant_id"`
+	Phone       string `gorm:"not null;index:idx_addresses_customer" json:"phone"`
+	Label       string `json:"label,omitempty"`
+	Recipient   string `json:"recipient,omitempty"`
+	Line1       string `gorm:"not null" json:"line1"`
+	Line2       string `json:"line2,omitempty"`
+	City        string `gorm:"not null" json:"city"`
+	Governorate string `json:"governorate,omitempty"`
+	PostalCode  string `json:"postal_code,omitempty"`
+	// Country is an ISO 3166 code; Tunisian addresses get their governorate and postal code checked
+	Country   string   `gorm:"not null;default:TN" json:"country"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	IsDefault bool     `gorm:"not null;default:false" json:"is_default"`
+	// RecordID is the type=address record the address was taken from
+	RecordID  *uint     `json:"record_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for Address
+func (Address) TableName() string {
+	return "chatbot.addresses"
+}
+
+// Formatted is the single address line Converty orders carry next to the city
+func (a Address) Formatted() string {
+	parts := []string{a.Line1}
+	if a.Line2 != "" {
+		parts = append(parts, a.Line2)
+	}
+	area := a.PostalCode
+	if a.Governorate != "" && !strings.EqualFold(a.Governorate, a.City) {
+		area = strings.TrimSpace(area + " " + a.Governorate)
+	}
+	if area != "" {
+		parts = append(parts, area)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// normalize trims the fields and checks the address, canonicalizing Tunisian governorates
+func (a *Address) normalize() error {
+	a.Phone = NormalizePhone(a.Phone)
+	for _, field := range []*string{&a.Label, &a.Recipient, &a.Line1, &a.Line2, &a.City, &a.Governorate, &a.PostalCode} {
+		*field = strings.TrimSpace(*field)
+	}
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	if a.Country == "" {
+		a.Country = "TN"
+	}
+	switch {
+	case a.Phone == "":
+		return fmt.Errorf("%w: an address needs the customer phone", ErrValidation)
+	case a.Line1 == "" || a.City == "":
+		return fmt.Errorf("%w: an address needs line1 and city", ErrValidation)
+	case (a.Latitude == nil) != (a.Longitude == nil):
+		return fmt.Errorf("%w: latitude and longitude go together", ErrValidation)
+	case a.Latitude != nil && (*a.Latitude < -90 || *a.Latitude > 90 || *a.Longitude < -180 || *a.Longitude > 180):
+		return fmt.Errorf("%w: coordinates out of range", ErrValidation)
+	}
+	if a.Country != "TN" {
+		return nil
+	}
+	if a.PostalCode != "" && !tunisianPostalCode.MatchString(a.PostalCode) {
+		return fmt.Errorf("%w: postal code %q must be 4 digits", ErrValidation, a.PostalCode)
+	}
+	if a.Governorate != "" {
+		name, ok := governorates[strings.ToLower(unaccented.Replace(a.Governorate))]
+		if !ok {
+			return fmt.Errorf("%w: unknown governorate %q", ErrValidation, a.Governorate)
+		}
+		a.Governorate = name
+	}
+	return nil
+}
+
+// AddressFromDetails reads the address of a type=address record. The address is a string or an
+// object with line1 (or street), line2, city, governorate (or state), postal_code (or zip), country
+// and latitude/longitude; phone, name, label and city may also sit next to it.
+func AddressFromDetails(details map[string]interface{}) Address {
+	text := func(doc map[string]interface{}, keys ...string) string {
+		for _, key := range keys {
+			switch value := doc[key].(type) {
+			case string:
+				if value != "" {
+					return value
+				}
+			case float64:
+				return strconv.FormatFloat(value, 'f', -1, 64)
+			}
+		}
+		return ""
+	}
+	address := Address{
+		Phone:     text(details, "phone", "phone_number"),
+		Recipient: text(details, "name", "recipient"),
+		Label:     text(details, "label"),
+		City:      text(details, "city"),
+	}
+	if customer, ok := details["customer"].(map[string]interface{}); ok && address.Phone == "" {
+		address.Phone = text(customer, "phone", "phone_number")
+	}
+	switch value := details["address"].(type) {
+	case string:
+		address.Line1 = value
+	case map[string]interface{}:
+		address.Line1 = text(value, "line1", "street")
+		address.Line2 = text(value, "line2")
+		if city := text(value, "city"); city != "" {
+			address.City = city
+		}
+		address.Governorate = text(value, "governorate", "state")
+		address.PostalCode = text(value, "postal_code", "zip")
+		address.Country = text(value, "country")
+		lat, latOK := value["latitude"].(float64)
+		lng, lngOK := value["longitude"].(float64)
+		if latOK && lngOK {
+			address.Latitude, address.Longitude = &lat, &lng
+		}
+	}
+	if address.Governorate == "" {
+		address.Governorate = text(details, "governorate")
+	}
+	return address
+}
+
+// Geocoder finds the coordinates of an address
+type Geocoder interface {
+	Geocode(address Address) (lat, lng float64, err error)
+}
+
+// NominatimGeocoder geocodes with the search API of Nominatim (OpenStreetMap) or a compatible server
+type NominatimGeocoder struct {
+	URL    string
+	client *http.Client
+}
+
+// NewNominatimGeocoder creates a geocoder for the Nominatim server at baseURL
+func NewNominatimGeocoder(baseURL string) *NominatimGeocoder {
+	return &NominatimGeocoder{URL: strings.TrimRight(baseURL, "/"), client: NewHTTPClient(5 * time.Second)}
+}
+
+// Geocode returns the coordinates of the best match for the address
+func (g *NominatimGeocoder) Geocode(address Address) (float64, float64, error) {
+	q := url.Values{}
+	q.Set("format", "json")
+	q.Set("limit", "1")
+	q.Set("street", address.Line1)
+	q.Set("city", address.City)
+	q.Set("country", address.Country)
+	if address.Governorate != "" {
+		q.Set("state", address.Governorate)
+	}
+	if address.PostalCode != "" {
+		q.Set("postalcode", address.PostalCode)
+	}
+	req, err := http.NewRequest("GET", g.URL+"/search?"+q.Encode(), nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create geocoding request: %v", err)
+	}
+	// Nominatim's usage policy requires an identifying user agent
+	req.Header.Set("User-Agent", "convertyApi")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to geocode address: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("geocoder answered %d", resp.StatusCode)
+	}
+	var matches []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&matches); err != nil {
+		return 0, 0, fmt.Errorf("failed to decode geocoder response: %v", err)
+	}
+	if len(matches) == 0 {
+		return 0, 0, fmt.Errorf("no match for %s, %s", address.Line1, address.City)
+	}
+	lat, err := strconv.ParseFloat(matches[0].Lat, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude %q", matches[0].Lat)
+	}
+	lng, err := strconv.ParseFloat(matches[0].Lon, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude %q", matches[0].Lon)
+	}
+	return lat, lng, nil
+}
+
+// AddressService defines the interface for customer address books
+type AddressService interface {
+	// SaveAddress adds an address to the book of its customer; the first one becomes the default
+	SaveAddress(address Address) (Address, error)
+	// SaveFromRecord adds the address of a type=address record to the customer's book
+	SaveFromRecord(record Data, details map[string]interface{}) (Address, error)
+	// ListAddresses lists the addresses of a customer, the default first
+	ListAddresses(tenantID uint, phone string) ([]Address, error)
+	DefaultAddress(tenantID uint, phone string) (Address, error)
+	SetDefault(tenantID uint, phone string, id uint) (Address, error)
+	DeleteAddress(tenantID uint, phone string, id uint) error
+	// CompleteOrder fills the missing address of an order from the customer's default address,
+	// reporting whether it did
+	CompleteOrder(tenantID uint, order *NewOrder) (bool, error)
+}
+
+// GormAddressService implements AddressService using GORM
+type GormAddressService struct {
+	db *gorm.DB
+	// geocoder fills the coordinates of new addresses; nil leaves them empty
+	geocoder Geocoder
+}
+
+// NewGormAddressService creates a new GormAddressService
+func NewGormAddressService(db *gorm.DB, geocoder Geocoder) AddressService {
+	return &GormAddressService{db: db, geocoder: geocoder}
+}
+
+// addressesOf restricts a query to the addresses of one customer
+func addressesOf(tenantID uint, phone string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ? AND phone = ?", tenantID, NormalizePhone(phone))
+	}
+}
+
+// SaveAddress validates and stores the address, geocoding it when it has no coordinates. A failed
+// geocoding is logged and the address is stored without them.
+func (s *GormAddressService) SaveAddress(address Address) (Address, error) {
+	address.ID = 0
+	if err := address.normalize(); err != nil {
+		return Address{}, err
+	}
+	if address.Latitude == nil && s.geocoder != nil {
+		if lat, lng, err := s.geocoder.Geocode(address); err != nil {
+			log.Printf("Storing address of %s without coordinates: %v", address.Phone, err)
+		} else {
+			address.Latitude, address.Longitude = &lat, &lng
+		}
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&Address{}).Scopes(addressesOf(address.TenantID, address.Phone)).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count addresses: %v", err)
+		}
+		if count == 0 {
+			address.IsDefault = true
+		}
+		if address.IsDefault && count > 0 {
+			if err := tx.Model(&Address{}).Scopes(addressesOf(address.TenantID, address.Phone)).Update("is_default", false).Error; err != nil {
+				return fmt.Errorf("failed to clear default address: %v", err)
+			}
+		}
+		if err := tx.Create(&address).Error; err != nil {
+			return fmt.Errorf("failed to save address: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Address{}, err
+	}
+	return address, nil
+}
+
+// SaveFromRecord adds the address of a type=address record; details are the plain details as sent,
+// since the stored ones may be encrypted
+func (s *GormAddressService) SaveFromRecord(record Data, details map[string]interface{}) (Address, error) {
+	address := AddressFromDetails(details)
+	address.TenantID = record.TenantID
+	address.RecordID = &record.ID
+	return s.SaveAddress(address)
+}
+
+// ListAddresses lists the addresses of a customer, the default first, then the newest
+func (s *GormAddressService) ListAddresses(tenantID uint, phone string) ([]Address, error) {
+	addresses := []Address{}
+	err := s.db.Scopes(addressesOf(tenantID, phone)).Order("is_default DESC, created_at DESC, id DESC").Find(&addresses).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses: %v", err)
+	}
+	return addresses, nil
+}
+
+// DefaultAddress fetches the default address of a customer
+func (s *GormAddressService) DefaultAddress(tenantID uint, phone string) (Address, error) {
+	var address Address
+	err := s.db.Scopes(addressesOf(tenantID, phone)).Where("is_default").First(&address).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Address{}, fmt.Errorf("%w: %s has no default address", ErrAddressNotFound, phone)
+	}
+	if err != nil {
+		return Address{}, fmt.Errorf("failed to fetch default address: %v", err)
+	}
+	return address, nil
+}
+
+// SetDefault makes address id the default of the customer
+func (s *GormAddressService) SetDefault(tenantID uint, phone string, id uint) (Address, error) {
+	var address Address
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Scopes(addressesOf(tenantID, phone)).Where("id = ?", id).First(&address).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %d", ErrAddressNotFound, id)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch address %d: %v", id, err)
+		}
+		if err := tx.Model(&Address{}).Scopes(addressesOf(tenantID, phone)).Where("id <> ?", id).Update("is_default", false).Error; err != nil {
+			return fmt.Errorf("failed to clear default address: %v", err)
+		}
+		if err := tx.Model(&address).Update("is_default", true).Error; err != nil {
+			return fmt.Errorf("failed to set default address: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Address{}, err
+	}
+	return address, nil
+}
+
+// DeleteAddress removes an address; when it was the default, the newest remaining one takes over
+func (s *GormAddressService) DeleteAddress(tenantID uint, phone string, id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var address Address
+		err := tx.Scopes(addressesOf(tenantID, phone)).Where("id = ?", id).First(&address).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %d", ErrAddressNotFound, id)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch address %d: %v", id, err)
+		}
+		if err := tx.Delete(&address).Error; err != nil {
+			return fmt.Errorf("failed to delete address %d: %v", id, err)
+		}
+		if !address.IsDefault {
+			return nil
+		}
+		var next Address
+		err = tx.Scopes(addressesOf(tenantID, phone)).Order("created_at DESC, id DESC").First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to pick the next default address: %v", err)
+		}
+		return tx.Model(&next).Update("is_default", true).Error
+	})
+}
+
+// CompleteOrder fills the address, city and name of an order without an address from the default
+// address of the customer; orders of customers without one are left as they are
+func (s *GormAddressService) CompleteOrder(tenantID uint, order *NewOrder) (bool, error) {
+	if order.Customer.Address != "" || order.Customer.Phone == "" {
+		return false, nil
+	}
+	address, err := s.DefaultAddress(tenantID, order.Customer.Phone)
+	if errors.Is(err, ErrAddressNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	order.Customer.Address = address.Formatted()
+	if order.Customer.City == "" {
+		order.Customer.City = address.City
+	}
+	if order.Customer.Name == "" {
+		order.Customer.Name = address.Recipient
+	}
+	return true, nil
+}
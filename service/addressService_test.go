@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddressNormalize(t *testing.T) {
+	address := Address{Phone: "+216 98 111 222", Line1: " 5 rue de Marseille ", City: "Tunis", Governorate: "Béja", PostalCode: "9000"}
+	if err := address.normalize(); err != nil {
+		t.Fatalf("valid address rejected: %v", err)
+	}
+	if address.Phone != "+21698111222" || address.Line1 != "5 rue de Marseille" || address.Governorate != "Beja" || address.Country != "TN" {
+		t.Errorf("unexpected normalized address: %+v", address)
+	}
+
+	lat := 36.8
+	for name, bad := range map[string]Address{
+		"no phone":          {Line1: "x", City: "Tunis"},
+		"no city":           {Phone: "1", Line1: "x"},
+		"governorate":       {Phone: "1", Line1: "x", City: "Tunis", Governorate: "Atlantis"},
+		"postal code":       {Phone: "1", Line1: "x", City: "Tunis", PostalCode: "10001"},
+		"half a coordinate": {Phone: "1", Line1: "x", City: "Tunis", Latitude: &lat},
+	} {
+		if err := bad.normalize(); !errors.Is(err, ErrValidation) {
+			t.Errorf("%s: got %v, want ErrValidation", name, err)
+		}
+	}
+
+	abroad := Address{Phone: "1", Line1: "10 Downing St", City: "London", Country: "gb", PostalCode: "SW1A 2AA", Governorate: "Greater London"}
+	if err := abroad.normalize(); err != nil {
+		t.Errorf("foreign address rejected: %v", err)
+	}
+}
+
+func TestAddressFormatted(t *testing.T) {
+	cases := []struct {
+		address Address
+		want    string
+	}{
+		{Address{Line1: "5 rue de Marseille", City: "Tunis", Governorate: "Tunis", PostalCode: "1001"}, "5 rue de Marseille, 1001"},
+		{Address{Line1: "Route de Gabes km 4", Line2: "Bloc B", City: "Sakiet Ezzit", Governorate: "Sfax"}, "Route de Gabes km 4, Bloc B, Sfax"},
+		{Address{Line1: "Cite El Amal", City: "Gafsa"}, "Cite El Amal"},
+	}
+	for _, c := range cases {
+		if got := c.address.Formatted(); got != c.want {
+			t.Errorf("Formatted() = %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestAddressFromDetails(t *testing.T) {
+	address := AddressFromDetails(map[string]interface{}{
+		"customer": map[string]interface{}{"phone": "+216 98 111 222"},
+		"name":     "Salma",
+		"address": map[string]interface{}{
+			"street": "5 rue de Marseille", "city": "Tunis", "zip": float64(1001), "latitude": 36.8, "longitude": 10.18,
+		},
+	})
+	if address.Phone != "+216 98 111 222" || address.Recipient != "Salma" || address.Line1 != "5 rue de Marseille" ||
+		address.City != "Tunis" || address.PostalCode != "1001" || address.Latitude == nil || *address.Longitude != 10.18 {
+		t.Errorf("unexpected address: %+v", address)
+	}
+
+	flat := AddressFromDetails(map[string]interface{}{"phone": "1", "address": "Cite El Amal", "city": "Gafsa"})
+	if flat.Line1 != "Cite El Amal" || flat.City != "Gafsa" {
+		t.Errorf("unexpected address from a plain string: %+v", flat)
+	}
+}
+
+func TestNominatimGeocoder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search" || r.URL.Query().Get("city") != "Tunis" || r.Header.Get("User-Agent") == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("street") == "nowhere" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`[{"lat": "36.8008", "lon": "10.1800"}]`))
+	}))
+	defer server.Close()
+
+	geocoder := NewNominatimGeocoder(server.URL + "/")
+	lat, lng, err := geocoder.Geocode(Address{Line1: "5 rue de Marseille", City: "Tunis", Country: "TN"})
+	if err != nil || lat != 36.8008 || lng != 10.18 {
+		t.Fatalf("Geocode = %v, %v, %v", lat, lng, err)
+	}
+	if _, _, err := geocoder.Geocode(Address{Line1: "nowhere", City: "Tunis", Country: "TN"}); err == nil {
+		t.Error("expected an error without a match")
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAttachmentRejected is returned for uploads that are too large or of a type that is not accepted
+var ErrAttachmentRejected = errors.New("attachment rejected")
+
+// attachmentTypes are the accepted content types, detected from the file contents
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// Attachment is a file, usually a photo sent through the chatbot, linked to a record
+type Attachment struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	TenantID    uint   `gorm:"not null;default:0;index" json:"tenant_id"`
+	RecordID    uint   `gorm:"not null;index" json:"record_id"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `gorm:"column:sha256" json:"sha256"`
+	// Backend and StorageKey locate the contents in a BlobStore
+	Backend    string    `gorm:"not null" json:"-"`
+	StorageKey string    `gorm:"not null" json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for Attachment
+func (Attachment) TableName() string {
+	return "chatbot.attachments"
+}
+
+// AttachmentService defines the interface for record attachments
+type AttachmentService interface {
+	Create(ctx context.Context, tenantID, recordID uint, fileName string, body io.Reader) (Attachment, error)
+	List(tenantID, recordID uint) ([]Attachment, error)
+	Get(tenantID, id uint) (Attachment, error)
+	Open(ctx context.Context, attachment Attachment) (io.ReadCloser, error)
+	// Delete removes an attachment of the record
+	Delete(ctx context.Context, tenantID, recordID, id uint) error
+	// PurgeRecords removes the attachments of deleted or anonymized records, whatever their tenant
+	PurgeRecords(ctx context.Context, recordIDs []uint) (int64, error)
+}
+
+// GormAttachmentService implements AttachmentService using GORM for metadata and a BlobStore for contents
+type GormAttachmentService struct {
+	db      *gorm.DB
+	store   BlobStore
+	maxSize int64
+}
+
+// NewGormAttachmentService creates a new GormAttachmentService accepting files up to maxSize bytes
+func NewGormAttachmentService(db *gorm.DB, store BlobStore, maxSize int64) AttachmentService {
+	return &GormAttachmentService{db: db, store: store, maxSize: maxSize}
+}
+
+// Create stores the upload and links it to the record. The content type is sniffed from the
+// data; the name sent by the client is only kept for display.
+func (s *GormAttachmentService) Create(ctx context.Context, tenantID, recordID uint, fileName string, body io.Reader) (Attachment, error) {
+	data, err := io.ReadAll(io.LimitReader(body, s.maxSize+1))
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to read upload: %v", err)
+	}
+	if int64(len(data)) > s.maxSize {
+		return Attachment{}, fmt.Errorf("%w: larger than %d bytes", ErrAttachmentRejected, s.maxSize)
+	}
+	if len(data) == 0 {
+		return Attachment{}, fmt.Errorf("%w: empty file", ErrAttachmentRejected)
+	}
+	contentType := http.DetectContentType(data)
+	if !attachmentTypes[contentType] {
+		return Attachment{}, fmt.Errorf("%w: unsupported content type %s", ErrAttachmentRejected, contentType)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Attachment{}, fmt.Errorf("failed to generate storage key: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	attachment := Attachment{
+		TenantID:    tenantID,
+		RecordID:    recordID,
+		FileName:    cleanFileName(fileName),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		Backend:     s.store.Name(),
+		StorageKey:  fmt.Sprintf("attachments/%d/%d/%s", tenantID, recordID, hex.EncodeToString(b)),
+	}
+	if err := s.store.Put(ctx, attachment.StorageKey, contentType, data); err != nil {
+		return Attachment{}, err
+	}
+	if err := s.db.Create(&attachment).Error; err != nil {
+		s.store.Delete(ctx, attachment.StorageKey)
+		return Attachment{}, fmt.Errorf("failed to create attachment: %v", err)
+	}
+	return attachment, nil
+}
+
+// List fetches the attachments of a record, oldest first
+func (s *GormAttachmentService) List(tenantID, recordID uint) ([]Attachment, error) {
+	var attachments []Attachment
+	if err := s.db.Where("tenant_id = ? AND record_id = ?", tenantID, recordID).Order("id").Find(&attachments).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch attachments: %v", err)
+	}
+	return attachments, nil
+}
+
+// Get fetches one attachment of the tenant
+func (s *GormAttachmentService) Get(tenantID, id uint) (Attachment, error) {
+	var attachment Attachment
+	if err := s.db.Where("tenant_id = ? AND id = ?", tenantID, id).First(&attachment).Error; err != nil {
+		return Attachment{}, fmt.Errorf("attachment %d not found: %v", id, err)
+	}
+	return attachment, nil
+}
+
+// Open streams the contents of an attachment
+func (s *GormAttachmentService) Open(ctx context.Context, attachment Attachment) (io.ReadCloser, error) {
+	if attachment.Backend != s.store.Name() {
+		return nil, fmt.Errorf("attachment %d is stored in %s, but the %s backend is configured", attachment.ID, attachment.Backend, s.store.Name())
+	}
+	return s.store.Open(ctx, attachment.StorageKey)
+}
+
+// Delete removes the attachment and its contents
+func (s *GormAttachmentService) Delete(ctx context.Context, tenantID, recordID, id uint) error {
+	var attachment Attachment
+	if err := s.db.Where("tenant_id = ? AND record_id = ? AND id = ?", tenantID, recordID, id).First(&attachment).Error; err != nil {
+		return fmt.Errorf("attachment %d of record %d not found: %v", id, recordID, err)
+	}
+	return s.remove(ctx, attachment)
+}
+
+// PurgeRecords removes the attachments of recordIDs; record IDs are unique across tenants. An
+// attachment whose contents cannot be deleted is kept and reported, so it can be purged again.
+func (s *GormAttachmentService) PurgeRecords(ctx context.Context, recordIDs []uint) (int64, error) {
+	var purged int64
+	var failed []string
+	for start := 0; start < len(recordIDs); start += 500 {
+		end := start + 500
+		if end > len(recordIDs) {
+			end = len(recordIDs)
+		}
+		var attachments []Attachment
+		if err := s.db.Where("record_id IN ?", recordIDs[start:end]).Find(&attachments).Error; err != nil {
+			return purged, fmt.Errorf("failed to fetch attachments: %v", err)
+		}
+		for _, attachment := range attachments {
+			if err := s.remove(ctx, attachment); err != nil {
+				failed = append(failed, err.Error())
+				continue
+			}
+			purged++
+		}
+	}
+	if len(failed) > 0 {
+		return purged, fmt.Errorf("failed to purge %d attachments: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return purged, nil
+}
+
+// remove deletes the contents of an attachment, then its row so a failed deletion can be retried
+func (s *GormAttachmentService) remove(ctx context.Context, attachment Attachment) error {
+	if attachment.Backend == s.store.Name() {
+		if err := s.store.Delete(ctx, attachment.StorageKey); err != nil {
+			return fmt.Errorf("attachment %d: %v", attachment.ID, err)
+		}
+	}
+	if err := s.db.Delete(&attachment).Error; err != nil {
+		return fmt.Errorf("failed to delete attachment %d: %v", attachment.ID, err)
+	}
+	return nil
+}
+
+// cleanFileName keeps the base name of an uploaded file without control characters or quotes
+func cleanFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || name == "" {
+		return "attachment"
+	}
+	return name
+}
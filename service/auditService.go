@@ -0,0 +1,94 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Audited actions
+const (
+	AuditOrderDryRun = "order.create.dry_run"
+)
+
+// AuditEntry records an operation worth reviewing later, with what it did or would have done
+type AuditEntry struct {
+	ID       uint      `gorm:"primaryKey" json:"id"`
+	Time     time.Time `gorm:"not null;index" json:"time"`
+	TenantID uint      `gorm:"index" json:"tenant_id"`
+	Actor    string    `json:"actor"`
+	Action   string    `gorm:"not null;index" json:"action"`
+	// Detail is the redacted payload of the action
+	Detail datatypes.JSON `json:"detail,omitempty"`
+}
+
+// TableName specifies the table name for AuditEntry
+func (AuditEntry) TableName() string {
+	return "public.audit_entries"
+}
+
+// AuditFilter narrows ListEntries; zero fields match everything
+type AuditFilter struct {
+	TenantID uint
+	Action   string
+	Since    time.Time
+	Limit    int
+}
+
+// AuditService defines the interface for the audit trail
+type AuditService interface {
+	Record(tenantID uint, actor, action string, detail interface{}) (AuditEntry, error)
+	ListEntries(filter AuditFilter) ([]AuditEntry, error)
+}
+
+// GormAuditService implements AuditService using GORM
+type GormAuditService struct {
+	db *gorm.DB
+}
+
+// NewGormAuditService creates a new GormAuditService
+func NewGormAuditService(db *gorm.DB) AuditService {
+	return &GormAuditService{db: db}
+}
+
+// Record appends an entry to the audit trail
+func (s *GormAuditService) Record(tenantID uint, actor, action string, detail interface{}) (AuditEntry, error) {
+	entry := AuditEntry{Time: time.Now(), TenantID: tenantID, Actor: actor, Action: action}
+	if detail != nil {
+		body, err := json.Marshal(detail)
+		if err != nil {
+			return AuditEntry{}, fmt.Errorf("failed to marshal audit detail: %v", err)
+		}
+		entry.Detail = datatypes.JSON(body)
+	}
+	if err := s.db.Create(&entry).Error; err != nil {
+		return AuditEntry{}, fmt.Errorf("failed to record audit entry: %v", err)
+	}
+	return entry, nil
+}
+
+// ListEntries returns matching entries, newest first, 100 by default
+func (s *GormAuditService) ListEntries(filter AuditFilter) ([]AuditEntry, error) {
+	query := s.db.Order("time desc, id desc")
+	if filter.TenantID != 0 {
+		query = query.Where("tenant_id = ?", filter.TenantID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("time >= ?", filter.Since)
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	var entries []AuditEntry
+	if err := query.Limit(limit).Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %v", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	store := &LocalBlobStore{Dir: t.TempDir()}
+	ctx := context.Background()
+	if err := store.Put(ctx, "attachments/1/2/abc", "image/png", []byte("png")); err != nil {
+		t.Fatal(err)
+	}
+	body, err := store.Open(ctx, "attachments/1/2/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "png" {
+		t.Errorf("read %q", data)
+	}
+	if err := store.Delete(ctx, "attachments/1/2/abc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open(ctx, "attachments/1/2/abc"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("deleted object: got %v", err)
+	}
+	if err := store.Put(ctx, "../outside", "", nil); err == nil {
+		t.Error("a key escaping the directory was accepted")
+	}
+}
+
+func TestS3BlobStoreSignsRequests(t *testing.T) {
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/s3/aws4_request") ||
+			r.Header.Get("X-Amz-Date") == "" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	store := &S3BlobStore{Endpoint: server.URL, Region: "eu-west-1", Bucket: "shop", AccessKey: "AKID", SecretKey: "secret", PathStyle: true}
+	ctx := context.Background()
+	if err := store.Put(ctx, "attachments/1/2/abc", "image/jpeg", []byte("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := objects["/shop/attachments/1/2/abc"]; !ok {
+		t.Fatalf("object not stored path-style: %v", objects)
+	}
+	body, err := store.Open(ctx, "attachments/1/2/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "jpeg" {
+		t.Errorf("read %q", data)
+	}
+	if err := store.Delete(ctx, "attachments/1/2/abc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open(ctx, "attachments/1/2/abc"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("deleted object: got %v", err)
+	}
+}
+
+func TestCleanFileName(t *testing.T) {
+	for in, want := range map[string]string{
+		"photo.jpg":              "photo.jpg",
+		`C:\Users\me\broken.png`: "broken.png",
+		"../../etc/passwd":       "passwd",
+		"a\"b\n.jpg":             "ab.jpg",
+		"":                       "attachment",
+	} {
+		if got := cleanFileName(in); got != want {
+			t.Errorf("cleanFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
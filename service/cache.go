@@ -0,0 +1,343 @@
+package service
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache resources that can be invalidated through Caches. Keys of per-store caches start with
+// the resource and the token user, e.g. "orders:user1:page=1".
+const (
+	CacheOrders   = "orders"
+	CacheProducts = "products"
+	CacheRules    = "rules"
+	CacheSchemas  = "schemas"
+	// CacheUpstream holds the last good Converty responses served while the API is down, keyed
+	// "<tenant ID> <URL>"
+	CacheUpstream = "upstream"
+	// CacheSecrets holds the secrets read from Vault or AWS Secrets Manager, by variable name;
+	// invalidating them reads them again
+	CacheSecrets = "secrets"
+	// CacheFlags holds the stored feature flags
+	CacheFlags = "flags"
+	// CacheTokens holds the stored Converty tokens, keyed "tokens:<token user>"
+	CacheTokens = "tokens"
+)
+
+// ttlCache is a small in-memory cache whose entries expire after a fixed TTL
+type ttlCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// newTTLCache creates a cache; a zero or negative TTL disables caching
+func newTTLCache(ttl time.Duration) *ttlCache {
+	return &ttlCache{ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+// Get returns the cached value for key if it has not expired
+func (c *ttlCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Set stores value under key for the cache TTL
+func (c *ttlCache) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	c.entries[key] = cacheEntry{value: value, expiresAt: time.Now().Add(c.ttl)}
+}
+
+// SetTTL changes the TTL of new entries; entries already cached keep their expiry, and disabling
+// the cache drops them
+func (c *ttlCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	if ttl <= 0 {
+		c.entries = make(map[string]cacheEntry)
+	}
+}
+
+// DeletePrefix removes every entry whose key starts with prefix and returns how many were removed
+func (c *ttlCache) DeletePrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Invalidate removes the entries whose key matches pattern, where "*" matches any run of
+// characters and an empty pattern matches every key, and returns how many were removed
+func (c *ttlCache) Invalidate(pattern string) int {
+	match := keyMatcher(pattern)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key := range c.entries {
+		if match(key) {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// lruCache is an in-memory cache holding at most capacity entries, each for at most ttl; the least
+// recently used entry makes room for a new one
+type lruCache struct {
+	capacity int
+	ttl      time.Duration
+	mu       sync.Mutex
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type lruEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// newLRUCache creates a cache of capacity entries expiring after ttl
+func newLRUCache(capacity int, ttl time.Duration) *lruCache {
+	return &lruCache{capacity: capacity, ttl: ttl, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get returns the value of key if it has not expired and marks it as recently used
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// Set stores value under key, evicting the least recently used entries beyond the capacity
+func (c *lruCache) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capacity <= 0 || c.ttl <= 0 {
+		return
+	}
+	expiresAt := time.Now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Len returns the number of entries held, expired ones included until they are evicted
+func (c *lruCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Invalidate removes the entries whose key matches pattern and returns how many were removed
+func (c *lruCache) Invalidate(pattern string) int {
+	match := keyMatcher(pattern)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key, element := range c.entries {
+		if match(key) {
+			c.order.Remove(element)
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// keyMatcher compiles a key pattern
+func keyMatcher(pattern string) func(string) bool {
+	if pattern == "" || pattern == "*" {
+		return func(string) bool { return true }
+	}
+	expr := regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+	return expr.MatchString
+}
+
+// Invalidator is a cache whose entries can be dropped by key pattern
+type Invalidator interface {
+	Invalidate(pattern string) int
+}
+
+// CacheRegistry names the caches of this process so they can be invalidated together; it does
+// not reach other instances, whose entries expire with their TTL
+type CacheRegistry struct {
+	mu     sync.RWMutex
+	caches map[string][]Invalidator
+}
+
+// NewCacheRegistry creates an empty registry
+func NewCacheRegistry() *CacheRegistry {
+	return &CacheRegistry{caches: make(map[string][]Invalidator)}
+}
+
+// Caches is the process-wide cache registry
+var Caches = NewCacheRegistry()
+
+// Register adds a cache under a resource name; a resource may have several caches
+func (r *CacheRegistry) Register(resource string, cache Invalidator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.caches[resource] = append(r.caches[resource], cache)
+}
+
+// Resources lists the registered resource names
+func (r *CacheRegistry) Resources() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	resources := make([]string, 0, len(r.caches))
+	for resource := range r.caches {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+// Invalidate drops the entries matching pattern from the caches of resource, or of every
+// resource when it is empty, and returns the count removed per resource
+func (r *CacheRegistry) Invalidate(resource, pattern string) (map[string]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if resource != "" {
+		if _, ok := r.caches[resource]; !ok {
+			known := make([]string, 0, len(r.caches))
+			for name := range r.caches {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown cache resource %q, expected one of %s", resource, strings.Join(known, ", "))
+		}
+	}
+	removed := make(map[string]int)
+	for name, caches := range r.caches {
+		if resource != "" && name != resource {
+			continue
+		}
+		for _, cache := range caches {
+			removed[name] += cache.Invalidate(pattern)
+		}
+	}
+	return removed, nil
+}
+
+// SetTTL changes the TTL of the caches of resource and returns how many were changed
+func (r *CacheRegistry) SetTTL(resource string, ttl time.Duration) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	changed := 0
+	for _, cache := range r.caches[resource] {
+		if tunable, ok := cache.(interface{ SetTTL(time.Duration) }); ok {
+			tunable.SetTTL(ttl)
+			changed++
+		}
+	}
+	return changed
+}
+
+// TokenChanged drops the cached Converty token of a token user, after it was refreshed, replaced,
+// revoked or marked invalid
+func (r *CacheRegistry) TokenChanged(tokenUserID string) {
+	r.Invalidate(CacheTokens, CacheTokens+":"+tokenUserID)
+}
+
+// OrdersChanged drops what an order mutation makes stale for a Converty store: its order
+// listings and, since orders move stock, its products
+func (r *CacheRegistry) OrdersChanged(tokenUserID, storeID string) {
+	r.Invalidate(CacheOrders, CacheOrders+":"+tokenUserID+":*")
+	r.Invalidate(CacheUpstream, "*/api/v1/orders*store_id="+storeID+"*")
+	r.ProductsChanged(tokenUserID, storeID)
+}
+
+// ProductsChanged drops the cached products and stock levels of a Converty store; an empty
+// token user drops the products of every store but keeps the upstream fallback
+func (r *CacheRegistry) ProductsChanged(tokenUserID, storeID string) {
+	if tokenUserID == "" {
+		r.Invalidate(CacheProducts, "")
+		return
+	}
+	r.Invalidate(CacheProducts, CacheProducts+":"+tokenUserID+":*")
+	r.Invalidate(CacheUpstream, "*/api/v1/products*store_id="+storeID+"*")
+}
+
+// DefaultTokenCacheTTL is how long a stored Converty token is served from memory. A token another
+// instance refreshed meanwhile is still valid until it expires, and a 401 reads the stored one again.
+const DefaultTokenCacheTTL = 10 * time.Second
+
+// TokenCache keeps the stored Converty token of each token user in memory for a short TTL, so the
+// upstream routes and calls do not read public.token_infos on every request. The values are the
+// token rows of the caller, e.g. the server's TokenInfo; every TokenCache is registered under
+// CacheTokens, so TokenChanged reaches them all.
+type TokenCache struct {
+	entries *ttlCache
+}
+
+// NewTokenCache creates and registers a token cache
+func NewTokenCache(ttl time.Duration) *TokenCache {
+	entries := newTTLCache(ttl)
+	Caches.Register(CacheTokens, entries)
+	return &TokenCache{entries: entries}
+}
+
+// Get returns the cached token of a token user
+func (c *TokenCache) Get(tokenUserID string) (interface{}, bool) {
+	return c.entries.Get(CacheTokens + ":" + tokenUserID)
+}
+
+// Set caches the token of a token user
+func (c *TokenCache) Set(tokenUserID string, token interface{}) {
+	c.entries.Set(CacheTokens+":"+tokenUserID, token)
+}
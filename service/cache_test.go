@@ -0,0 +1,153 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCacheExpiresEntries(t *testing.T) {
+	cache := newTTLCache(20 * time.Millisecond)
+	cache.Set("orders:page=1", []Order{{ID: "a"}})
+
+	if _, ok := cache.Get("orders:page=1"); !ok {
+		t.Fatal("expected cached entry before TTL")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := cache.Get("orders:page=1"); ok {
+		t.Fatal("expected entry to expire after TTL")
+	}
+}
+
+func TestTTLCacheDisabled(t *testing.T) {
+	cache := newTTLCache(0)
+	cache.Set("k", 1)
+	if _, ok := cache.Get("k"); ok {
+		t.Fatal("zero TTL must disable caching")
+	}
+}
+
+func TestCacheRegistryInvalidate(t *testing.T) {
+	registry := NewCacheRegistry()
+	orders, products := newTTLCache(time.Minute), newTTLCache(time.Minute)
+	registry.Register(CacheOrders, orders)
+	registry.Register(CacheProducts, products)
+	orders.Set("orders:user1:page=1", 1)
+	orders.Set("orders:user1:page=2", 1)
+	orders.Set("orders:user2:page=1", 1)
+	products.Set("products:user1:page=1", 1)
+
+	removed, err := registry.Invalidate(CacheOrders, "orders:*:page=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed[CacheOrders] != 2 {
+		t.Errorf("removed %v, want 2 orders", removed)
+	}
+	if _, ok := orders.Get("orders:user1:page=2"); !ok {
+		t.Error("non-matching entry was removed")
+	}
+	if _, err := registry.Invalidate("stock", ""); err == nil {
+		t.Error("unknown resource must be rejected")
+	}
+
+	// An order mutation makes the store's orders and products stale, not other stores'
+	orders.Set("orders:user2:page=2", 1)
+	registry.OrdersChanged("user1", "store-1")
+	if _, ok := orders.Get("orders:user1:page=2"); ok {
+		t.Error("orders of the mutated store are still cached")
+	}
+	if _, ok := products.Get("products:user1:page=1"); ok {
+		t.Error("products of the mutated store are still cached")
+	}
+	if _, ok := orders.Get("orders:user2:page=2"); !ok {
+		t.Error("orders of another store were dropped")
+	}
+
+	removed, _ = registry.Invalidate("", "")
+	if removed[CacheOrders] != 1 || removed[CacheProducts] != 0 {
+		t.Errorf("invalidating everything removed %v", removed)
+	}
+}
+
+func TestUpstreamFallbackInvalidate(t *testing.T) {
+	RememberResponse(1, "https://api.converty.shop/api/v1/products?limit=50&page=1&store_id=s1", []byte("[]"))
+	RememberResponse(2, "https://api.converty.shop/api/v1/products?limit=50&page=1&store_id=s2", []byte("[]"))
+	if removed := (upstreamFallback{}).Invalidate("*/api/v1/products*store_id=s1*"); removed != 1 {
+		t.Errorf("removed %d fallback responses, want 1", removed)
+	}
+	if _, ok := CachedResponse(2, "https://api.converty.shop/api/v1/products?limit=50&page=1&store_id=s2"); !ok {
+		t.Error("fallback of another store was dropped")
+	}
+}
+
+func TestUpstreamFallbackIsPerTenant(t *testing.T) {
+	url := "https://api.converty.shop/api/v1/orders?limit=50&page=1"
+	RememberResponse(1, url, []byte(`[{"id":"1"}]`))
+	if _, ok := CachedResponse(2, url); ok {
+		t.Fatal("a tenant was served another tenant's response")
+	}
+	if body, ok := CachedResponse(1, url); !ok || string(body) != `[{"id":"1"}]` {
+		t.Fatalf("tenant response not cached: %s", body)
+	}
+}
+
+func TestLRUCacheBounds(t *testing.T) {
+	cache := newLRUCache(2, time.Minute)
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Get("a")
+	cache.Set("c", 3)
+	if _, ok := cache.Get("b"); ok {
+		t.Error("the least recently used entry was kept")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("a recently used entry was evicted")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("cache holds %d entries, want 2", cache.Len())
+	}
+
+	expiring := newLRUCache(2, time.Nanosecond)
+	expiring.Set("a", 1)
+	time.Sleep(time.Millisecond)
+	if _, ok := expiring.Get("a"); ok || expiring.Len() != 0 {
+		t.Error("an expired entry was served")
+	}
+}
+
+func TestCacheRegistrySetTTL(t *testing.T) {
+	registry := NewCacheRegistry()
+	cache := newTTLCache(0)
+	registry.Register(CacheOrders, cache)
+	registry.Register(CacheUpstream, upstreamFallback{})
+
+	if changed := registry.SetTTL(CacheOrders, time.Minute); changed != 1 {
+		t.Fatalf("SetTTL changed %d caches, want 1", changed)
+	}
+	cache.Set("k", 1)
+	if _, ok := cache.Get("k"); !ok {
+		t.Fatal("enabling the TTL should cache new entries")
+	}
+	registry.SetTTL(CacheOrders, 0)
+	if _, ok := cache.Get("k"); ok {
+		t.Fatal("disabling the cache should drop its entries")
+	}
+}
+
+func TestTokenCacheTokenChanged(t *testing.T) {
+	server, data := NewTokenCache(time.Minute), NewTokenCache(time.Minute)
+	server.Set("user1", "server row")
+	data.Set("user1", "data row")
+	data.Set("user10", "other row")
+
+	Caches.TokenChanged("user1")
+	if _, ok := server.Get("user1"); ok {
+		t.Error("the server's token of user1 is still cached")
+	}
+	if _, ok := data.Get("user1"); ok {
+		t.Error("the data service's token of user1 is still cached")
+	}
+	if token, ok := data.Get("user10"); !ok || token != "other row" {
+		t.Error("the token of another user was dropped")
+	}
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AramexCarrier tracks shipments with the Aramex Shipments Tracking API
+type AramexCarrier struct {
+	Username           string
+	Password           string
+	AccountNumber      string
+	AccountPin         string
+	AccountEntity      string
+	AccountCountryCode string
+	BaseURL            string
+}
+
+// Name returns the carrier name
+func (c *AramexCarrier) Name() string { return "aramex" }
+
+// Handles matches Aramex delivery companies
+func (c *AramexCarrier) Handles(deliveryCompany string) bool {
+	return carrierMatches(deliveryCompany, "aramex")
+}
+
+// aramexStatuses maps Aramex update codes to normalized statuses
+var aramexStatuses = map[string]string{
+	"SH014": TrackingCreated,
+	"SH012": TrackingPickedUp,
+	"SH047": TrackingPickedUp,
+	"SH001": TrackingInTransit,
+	"SH004": TrackingInTransit,
+	"SH022": TrackingInTransit,
+	"SH003": TrackingOutForDelivery,
+	"SH005": TrackingDelivered,
+	"SH006": TrackingDelivered,
+	"SH007": TrackingFailedAttempt,
+	"SH033": TrackingFailedAttempt,
+	"SH069": TrackingReturned,
+	"SH076": TrackingReturned,
+}
+
+// Track fetches every update of an Aramex waybill
+func (c *AramexCarrier) Track(trackingNumber string) ([]TrackingEvent, error) {
+	var resp struct {
+		HasErrors     bool `json:"HasErrors"`
+		Notifications []struct {
+			Code    string `json:"Code"`
+			Message string `json:"Message"`
+		} `json:"Notifications"`
+		TrackingResults []struct {
+			Key   string `json:"Key"`
+			Value []struct {
+				UpdateCode        string `json:"UpdateCode"`
+				UpdateDescription string `json:"UpdateDescription"`
+				UpdateDateTime    string `json:"UpdateDateTime"`
+				UpdateLocation    string `json:"UpdateLocation"`
+			} `json:"Value"`
+		} `json:"TrackingResults"`
+	}
+	err := postJSON(c.BaseURL+"/TrackShipments", map[string]string{"Accept": "application/json"}, map[string]interface{}{
+		"ClientInfo": map[string]interface{}{
+			"UserName":           c.Username,
+			"Password":           c.Password,
+			"Version":            "v1.0",
+			"AccountNumber":      c.AccountNumber,
+			"AccountPin":         c.AccountPin,
+			"AccountEntity":      c.AccountEntity,
+			"AccountCountryCode": c.AccountCountryCode,
+		},
+		"Shipments":                 []string{trackingNumber},
+		"GetLastTrackingUpdateOnly": false,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.HasErrors {
+		var messages []string
+		for _, notification := range resp.Notifications {
+			messages = append(messages, notification.Code+": "+notification.Message)
+		}
+		return nil, fmt.Errorf("aramex error: %s", strings.Join(messages, "; "))
+	}
+
+	events := []TrackingEvent{}
+	for _, result := range resp.TrackingResults {
+		if result.Key != trackingNumber {
+			continue
+		}
+		for _, update := range result.Value {
+			status, ok := aramexStatuses[update.UpdateCode]
+			if !ok {
+				status = TrackingInTransit
+			}
+			events = append(events, TrackingEvent{
+				Time:        parseAramexDate(update.UpdateDateTime),
+				Status:      status,
+				Description: update.UpdateDescription,
+				Location:    update.UpdateLocation,
+			})
+		}
+	}
+	return events, nil
+}
+
+// aramexDate matches the WCF JSON date format "/Date(1717236000000+0100)/"
+var aramexDate = regexp.MustCompile(`/Date\((-?\d+)([+-]\d{4})?\)/`)
+
+// parseAramexDate converts a WCF JSON date; unparseable dates become the zero time
+func parseAramexDate(value string) time.Time {
+	match := aramexDate.FindStringSubmatch(value)
+	if match == nil {
+		parsed, _ := time.Parse(time.RFC3339, value)
+		return parsed
+	}
+	millis, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis).UTC()
+}
+
+// FirstDeliveryCarrier tracks parcels with the First Delivery Group API (Tunisia)
+type FirstDeliveryCarrier struct {
+	Token   string
+	BaseURL string
+}
+
+// Name returns the carrier name
+func (c *FirstDeliveryCarrier) Name() string { return "first_delivery" }
+
+// Handles matches First Delivery companies
+func (c *FirstDeliveryCarrier) Handles(deliveryCompany string) bool {
+	return carrierMatches(deliveryCompany, "first delivery", "first delivery group", "fdg")
+}
+
+// firstDeliveryStatuses maps First Delivery states (French) to normalized statuses
+var firstDeliveryStatuses = map[string]string{
+	"en attente":            TrackingCreated,
+	"a enlever":             TrackingCreated,
+	"enlevé":                TrackingPickedUp,
+	"au magasin":            TrackingInTransit,
+	"au dépôt":              TrackingInTransit,
+	"en cours":              TrackingOutForDelivery,
+	"en cours de livraison": TrackingOutForDelivery,
+	"livré":                 TrackingDelivered,
+	"livré payé":            TrackingDelivered,
+	"reporté":               TrackingFailedAttempt,
+	"injoignable":           TrackingFailedAttempt,
+	"retour":                TrackingReturned,
+	"retour dépôt":          TrackingReturned,
+	"retour expéditeur":     TrackingReturned,
+	"annulé":                TrackingReturned,
+}
+
+// Track fetches the state history of a First Delivery barcode
+func (c *FirstDeliveryCarrier) Track(trackingNumber string) ([]TrackingEvent, error) {
+	var resp struct {
+		IsError bool   `json:"isError"`
+		Message string `json:"message"`
+		Result  struct {
+			State   string `json:"state"`
+			History []struct {
+				State string `json:"state"`
+				Date  string `json:"date"`
+				Place string `json:"place"`
+			} `json:"history"`
+		} `json:"result"`
+	}
+	err := postJSON(c.BaseURL+"/etat", map[string]string{"Authorization": "Bearer " + c.Token}, map[string]string{
+		"barCode": trackingNumber,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError {
+		return nil, fmt.Errorf("first delivery error: %s", resp.Message)
+	}
+
+	events := []TrackingEvent{}
+	for _, step := range resp.Result.History {
+		date, _ := time.ParseInLocation("2006-01-02 15:04:05", step.Date, tunisLocation)
+		events = append(events, TrackingEvent{
+			Time:        date,
+			Status:      firstDeliveryStatus(step.State),
+			Description: step.State,
+			Location:    step.Place,
+		})
+	}
+	// Older accounts only return the current state
+	if len(events) == 0 && resp.Result.State != "" {
+		events = append(events, TrackingEvent{
+			Time:        time.Now(),
+			Status:      firstDeliveryStatus(resp.Result.State),
+			Description: resp.Result.State,
+		})
+	}
+	return events, nil
+}
+
+// firstDeliveryStatus normalizes a First Delivery state
+func firstDeliveryStatus(state string) string {
+	if status, ok := firstDeliveryStatuses[strings.ToLower(strings.TrimSpace(state))]; ok {
+		return status
+	}
+	return TrackingUnknown
+}
+
+// tunisLocation is the time zone First Delivery reports dates in
+var tunisLocation = func() *time.Location {
+	location, err := time.LoadLocation("Africa/Tunis")
+	if err != nil {
+		return time.FixedZone("CET", 3600)
+	}
+	return location
+}()
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Category is a Converty product category mirrored locally
+type Category struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	TenantID   uint   `gorm:"not null;default:0;uniqueIndex:idx_category_tenant_external" json:"tenant_id"`
+	ExternalID string `gorm:"not null;uniqueIndex:idx_category_tenant_external" json:"external_id"`
+	Name       string `gorm:"not null" json:"name"`
+	Slug       string `gorm:"index" json:"slug"`
+	ParentID   string `json:"parent_id,omitempty"`
+	// Assignee receives issues about this category, e.g. electronics complaints go to Sami
+	Assignee string    `json:"assignee,omitempty"`
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// TableName specifies the table name for Category
+func (Category) TableName() string {
+	return "chatbot.categories"
+}
+
+// Matches reports whether ref names this category by ID, external ID, slug or name
+func (c Category) Matches(ref string) bool {
+	ref = strings.TrimSpace(ref)
+	return ref != "" && (ref == c.ExternalID || ref == strconv.FormatUint(uint64(c.ID), 10) ||
+		strings.EqualFold(ref, c.Slug) || strings.EqualFold(ref, c.Name))
+}
+
+// OrderInCategory reports whether any line of the order belongs to the category
+func OrderInCategory(order Order, category Category) bool {
+	for _, line := range order.Items {
+		if category.Matches(line.Category) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterOrdersByCategory keeps the orders with at least one line in the category
+func FilterOrdersByCategory(orders []Order, category Category) []Order {
+	filtered := make([]Order, 0, len(orders))
+	for _, order := range orders {
+		if OrderInCategory(order, category) {
+			filtered = append(filtered, order)
+		}
+	}
+	return filtered
+}
+
+// categoryItem is the upstream JSON shape of a category
+type categoryItem struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID string `json:"parent_id"`
+}
+
+// FetchCategories lists the product categories of the Converty store
+func (s *GormDataService) FetchCategories() ([]Category, error) {
+	tokenInfo, err := s.loadToken()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", s.converty.URL("categories"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	q := url.Values{}
+	q.Add("store_id", tokenInfo.storeID())
+	req.URL.RawQuery = q.Encode()
+
+	body, err := s.doConvertyRequest(req, tokenInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch categories: %w", err)
+	}
+	categories, err := s.converty.DecodeCategories(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch categories: %v", err)
+	}
+	return categories, nil
+}
+
+// CategoryService defines the interface for the local category taxonomy
+type CategoryService interface {
+	Sync(tenantID uint, dataService DataService) (int, error)
+	ListCategories(tenantID uint) ([]Category, error)
+	FindCategory(tenantID uint, ref string) (Category, error)
+	SetAssignee(tenantID, id uint, assignee string) (Category, error)
+}
+
+// GormCategoryService implements CategoryService using GORM
+type GormCategoryService struct {
+	db *gorm.DB
+}
+
+// NewGormCategoryService creates a new GormCategoryService
+func NewGormCategoryService(db *gorm.DB) CategoryService {
+	return &GormCategoryService{db: db}
+}
+
+// Sync fetches the tenant's categories from Converty and upserts them, keeping local assignees
+func (s *GormCategoryService) Sync(tenantID uint, dataService DataService) (int, error) {
+	categories, err := dataService.FetchCategories()
+	if err != nil {
+		return 0, err
+	}
+	if len(categories) == 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	for i := range categories {
+		categories[i].TenantID = tenantID
+		categories[i].SyncedAt = now
+	}
+	err = s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "external_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "slug", "parent_id", "synced_at"}),
+	}).Create(&categories).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to save categories: %v", err)
+	}
+	return len(categories), nil
+}
+
+// ListCategories fetches the tenant's categories by name
+func (s *GormCategoryService) ListCategories(tenantID uint) ([]Category, error) {
+	var categories []Category
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("name").Find(&categories).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch categories: %v", err)
+	}
+	return categories, nil
+}
+
+// FindCategory resolves a category by ID, external ID, slug or name
+func (s *GormCategoryService) FindCategory(tenantID uint, ref string) (Category, error) {
+	categories, err := s.ListCategories(tenantID)
+	if err != nil {
+		return Category{}, err
+	}
+	for _, category := range categories {
+		if category.Matches(ref) {
+			return category, nil
+		}
+	}
+	return Category{}, fmt.Errorf("category %q not found", ref)
+}
+
+// SetAssignee sets who handles issues about a category
+func (s *GormCategoryService) SetAssignee(tenantID, id uint, assignee string) (Category, error) {
+	var category Category
+	if err := s.db.Where("tenant_id = ?", tenantID).First(&category, id).Error; err != nil {
+		return Category{}, fmt.Errorf("category with ID %d not found: %v", id, err)
+	}
+	if err := s.db.Model(&category).Update("assignee", assignee).Error; err != nil {
+		return Category{}, fmt.Errorf("failed to update category: %v", err)
+	}
+	category.Assignee = assignee
+	return category, nil
+}
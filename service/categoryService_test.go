@@ -0,0 +1,25 @@
+package service
+
+import "testing"
+
+func TestFilterOrdersByCategory(t *testing.T) {
+	electronics := Category{ID: 3, ExternalID: "cat_42", Name: "Electronics", Slug: "electronics"}
+	for _, ref := range []string{"3", "cat_42", "ELECTRONICS", "electronics"} {
+		if !electronics.Matches(ref) {
+			t.Errorf("expected %q to match the category", ref)
+		}
+	}
+	if electronics.Matches("") || electronics.Matches("books") {
+		t.Error("unexpected match")
+	}
+
+	orders := []Order{
+		{ID: "a", Items: []OrderLine{{Category: "Books"}, {Category: "electronics"}}},
+		{ID: "b", Items: []OrderLine{{Category: "books"}}},
+		{ID: "c"},
+	}
+	filtered := FilterOrdersByCategory(orders, electronics)
+	if len(filtered) != 1 || filtered[0].ID != "a" {
+		t.Errorf("unexpected filtered orders %+v", filtered)
+	}
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Consent statuses of a customer for follow-up messages
+const (
+	ConsentGranted  = "granted"
+	ConsentOptedOut = "opted_out"
+)
+
+// ConsentSuppressed is the history action of a message withheld from a customer without consent
+const ConsentSuppressed = "contact_suppressed"
+
+// ErrNoConsent is returned instead of contacting a customer who opted out, or who never opted in
+// when consent is required
+var ErrNoConsent = errors.New("customer has not consented to follow-up messages")
+
+// Consent is the current choice of a customer, identified by phone, about follow-up messages
+type Consent struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	TenantID uint   `gorm:"not null;default:0;uniqueIndex:idx_consents_customer" json:"tenant_id"`
+	Phone    string `gorm:"not null;uniqueIndex:idx_consents_customer" json:"phone"`
+	Status   string `gorm:"not null;index" json:"status"`
+	// Source is where the choice was made, e.g. "whatsapp" or "operator"
+	Source    string    `json:"source,omitempty"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for Consent
+func (Consent) TableName() string {
+	return "chatbot.consents"
+}
+
+// ConsentChange is an entry of the compliance trail: a consent given or withdrawn, or a message
+// withheld because of it. Entries are never updated or deleted.
+type ConsentChange struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	TenantID uint   `gorm:"not null;default:0;index:idx_consent_history_customer" json:"tenant_id"`
+	Phone    string `gorm:"not null;index:idx_consent_history_customer" json:"phone"`
+	// Action is the new status, or ConsentSuppressed
+	Action   string `gorm:"not null" json:"action"`
+	Previous string `json:"previous,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Actor    string `json:"actor,omitempty"`
+	Note     string `json:"note,omitempty"`
+	// Event is the notification withheld, for ConsentSuppressed entries
+	Event     string    `json:"event,omitempty"`
+	CreatedAt time.Time `gorm:"not null;index" json:"created_at"`
+}
+
+// TableName specifies the table name for ConsentChange
+func (ConsentChange) TableName() string {
+	return "chatbot.consent_history"
+}
+
+// ConsentUpdate is a customer's choice as reported by the chatbot or an operator
+type ConsentUpdate struct {
+	Status string
+	Source string
+	Actor  string
+	Note   string
+}
+
+// ConsentService defines the interface for the follow-up consents of customers
+type ConsentService interface {
+	SetConsent(tenantID uint, phone string, update ConsentUpdate) (Consent, error)
+	// GetConsent returns the recorded choice, ErrNotFound when the customer never made one
+	GetConsent(tenantID uint, phone string) (Consent, error)
+	ListConsents(tenantID uint, status string) ([]Consent, error)
+	// History returns the compliance trail of a customer, newest first
+	History(tenantID uint, phone string) ([]ConsentChange, error)
+	// MayContact reports whether a follow-up message may be sent to the customer
+	MayContact(tenantID uint, phone string) (bool, error)
+	// RecordSuppressed adds a withheld message to the trail
+	RecordSuppressed(tenantID uint, phone, event string) error
+}
+
+// GormConsentService implements ConsentService using GORM
+type GormConsentService struct {
+	db *gorm.DB
+	// optIn requires a granted consent; otherwise customers may be contacted until they opt out
+	optIn bool
+}
+
+// NewGormConsentService creates a new GormConsentService; with optIn, customers without a recorded
+// consent are not contacted
+func NewGormConsentService(db *gorm.DB, optIn bool) ConsentService {
+	return &GormConsentService{db: db, optIn: optIn}
+}
+
+// SetConsent records a customer's choice and appends it to the trail
+func (s *GormConsentService) SetConsent(tenantID uint, phone string, update ConsentUpdate) (Consent, error) {
+	phone = NormalizePhone(phone)
+	if phone == "" {
+		return Consent{}, fmt.Errorf("%w: a phone number is required", ErrValidation)
+	}
+	if update.Status != ConsentGranted && update.Status != ConsentOptedOut {
+		return Consent{}, fmt.Errorf("%w: consent status %q must be %s or %s", ErrValidation, update.Status, ConsentGranted, ConsentOptedOut)
+	}
+	var consent Consent
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("tenant_id = ? AND phone = ?", tenantID, phone).Limit(1).Find(&consent)
+		if result.Error != nil {
+			return fmt.Errorf("failed to fetch consent of %s: %v", phone, result.Error)
+		}
+		previous := consent.Status
+		consent.TenantID, consent.Phone, consent.Status = tenantID, phone, update.Status
+		consent.Source, consent.UpdatedBy = update.Source, update.Actor
+		if err := tx.Save(&consent).Error; err != nil {
+			return fmt.Errorf("failed to save consent of %s: %v", phone, err)
+		}
+		change := ConsentChange{
+			TenantID: tenantID, Phone: phone, Action: update.Status, Previous: previous,
+			Source: update.Source, Actor: update.Actor, Note: update.Note, CreatedAt: time.Now(),
+		}
+		if err := tx.Create(&change).Error; err != nil {
+			return fmt.Errorf("failed to record consent change of %s: %v", phone, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Consent{}, err
+	}
+	return consent, nil
+}
+
+// GetConsent fetches a customer's choice
+func (s *GormConsentService) GetConsent(tenantID uint, phone string) (Consent, error) {
+	phone = NormalizePhone(phone)
+	var consent Consent
+	result := s.db.Where("tenant_id = ? AND phone = ?", tenantID, phone).Limit(1).Find(&consent)
+	if result.Error != nil {
+		return Consent{}, fmt.Errorf("failed to fetch consent of %s: %v", phone, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return Consent{}, fmt.Errorf("%w: no consent recorded for %s", ErrNotFound, phone)
+	}
+	return consent, nil
+}
+
+// ListConsents fetches the choices of a tenant's customers, optionally with one status
+func (s *GormConsentService) ListConsents(tenantID uint, status string) ([]Consent, error) {
+	query := s.db.Where("tenant_id = ?", tenantID).Order("updated_at desc")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var consents []Consent
+	if err := query.Find(&consents).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch consents: %v", err)
+	}
+	return consents, nil
+}
+
+// History fetches the trail of a customer
+func (s *GormConsentService) History(tenantID uint, phone string) ([]ConsentChange, error) {
+	var changes []ConsentChange
+	if err := s.db.Where("tenant_id = ? AND phone = ?", tenantID, NormalizePhone(phone)).
+		Order("created_at desc, id desc").Find(&changes).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch consent history: %v", err)
+	}
+	return changes, nil
+}
+
+// MayContact allows customers who granted consent and, unless consent is required, those who
+// never made a choice
+func (s *GormConsentService) MayContact(tenantID uint, phone string) (bool, error) {
+	consent, err := s.GetConsent(tenantID, phone)
+	if errors.Is(err, ErrNotFound) {
+		return !s.optIn, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return consent.Status == ConsentGranted, nil
+}
+
+// RecordSuppressed appends a withheld message to the trail
+func (s *GormConsentService) RecordSuppressed(tenantID uint, phone, event string) error {
+	change := ConsentChange{TenantID: tenantID, Phone: NormalizePhone(phone), Action: ConsentSuppressed, Event: event, CreatedAt: time.Now()}
+	if err := s.db.Create(&change).Error; err != nil {
+		return fmt.Errorf("failed to record suppressed %s message to %s: %v", event, change.Phone, err)
+	}
+	return nil
+}
+
+// ConsentNotifier withholds the notifications addressed to a customer, those carrying a phone or
+// a customer, unless the customer may be contacted. Operator notifications pass through. A
+// consent that cannot be checked withholds the message too.
+type ConsentNotifier struct {
+	Next     Notifier
+	Consents ConsentService
+}
+
+// Notify checks the recipient's consent before passing the notification on; a withheld one
+// returns ErrNoConsent
+func (n ConsentNotifier) Notify(notification Notification) error {
+	tenantID, phone, ok := notificationRecipient(notification)
+	if !ok {
+		return n.Next.Notify(notification)
+	}
+	allowed, err := n.Consents.MayContact(tenantID, phone)
+	if err != nil {
+		return fmt.Errorf("failed to check the consent of %s: %v", phone, err)
+	}
+	if !allowed {
+		if err := n.Consents.RecordSuppressed(tenantID, phone, notification.Event); err != nil {
+			log.Printf("Withheld %s message: %v", notification.Event, err)
+		}
+		return fmt.Errorf("%w: %s message to %s withheld", ErrNoConsent, notification.Event, phone)
+	}
+	return n.Next.Notify(notification)
+}
+
+// notificationRecipient finds the customer a notification is addressed to; ok is false for
+// notifications meant for operators
+func notificationRecipient(notification Notification) (tenantID uint, phone string, ok bool) {
+	tenantID, _ = notification.Data["tenant_id"].(uint)
+	if value, isString := notification.Data["phone"].(string); isString && value != "" {
+		return tenantID, value, true
+	}
+	if customer, isCustomer := notification.Data["customer"].(Customer); isCustomer && customer.Phone != "" {
+		return tenantID, customer.Phone, true
+	}
+	return 0, "", false
+}
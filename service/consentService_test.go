@@ -0,0 +1,61 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+// recordingNotifier keeps the notifications it is given
+type recordingNotifier struct {
+	sent []Notification
+}
+
+func (n *recordingNotifier) Notify(notification Notification) error {
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+// stubConsents allows every phone but those opted out, and records the suppressed messages
+type stubConsents struct {
+	ConsentService
+	optedOut   map[string]bool
+	suppressed []string
+}
+
+func (s *stubConsents) MayContact(tenantID uint, phone string) (bool, error) {
+	return !s.optedOut[NormalizePhone(phone)], nil
+}
+
+func (s *stubConsents) RecordSuppressed(tenantID uint, phone, event string) error {
+	s.suppressed = append(s.suppressed, event+":"+phone)
+	return nil
+}
+
+func TestConsentNotifier(t *testing.T) {
+	next := &recordingNotifier{}
+	consents := &stubConsents{optedOut: map[string]bool{"+21620000000": true}}
+	notifier := ConsentNotifier{Next: next, Consents: consents}
+
+	err := notifier.Notify(Notification{Event: "back_in_stock", Data: map[string]interface{}{"tenant_id": uint(2), "phone": "+216 20 000 000"}})
+	if !errors.Is(err, ErrNoConsent) {
+		t.Errorf("message to an opted-out customer: %v", err)
+	}
+	err = notifier.Notify(Notification{Event: "abandoned_cart", Data: map[string]interface{}{"customer": Customer{Phone: "+21620000000"}}})
+	if !errors.Is(err, ErrNoConsent) {
+		t.Errorf("abandoned cart of an opted-out customer: %v", err)
+	}
+	if len(consents.suppressed) != 2 || consents.suppressed[0] != "back_in_stock:+216 20 000 000" {
+		t.Errorf("suppressed messages not recorded: %v", consents.suppressed)
+	}
+
+	// Other customers and operator notifications go through
+	if err := notifier.Notify(Notification{Event: "back_in_stock", Data: map[string]interface{}{"phone": "+21655000000"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(Notification{Event: "product_alert", Data: map[string]interface{}{"product_id": "p1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.sent) != 2 {
+		t.Errorf("%d notifications delivered, want 2", len(next.sent))
+	}
+}
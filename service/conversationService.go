@@ -0,0 +1,241 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Conversation message roles
+const (
+	RoleUser  = "user"
+	RoleBot   = "bot"
+	RoleAgent = "agent"
+)
+
+// EventConversationMessage is written to the outbox for every stored chatbot turn
+const EventConversationMessage = "conversation.message"
+
+var (
+	// ErrConversationNotFound is returned when a conversation has no stored messages
+	ErrConversationNotFound = errors.New("conversation not found")
+	// ErrInvalidMessage is returned when a chatbot turn cannot be stored
+	ErrInvalidMessage = errors.New("invalid message")
+)
+
+// conversationIDPattern is what the chatbot may use as a conversation ID
+var conversationIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Conversation is a multi-turn dialogue, identified by the chatbot's own session ID
+type Conversation struct {
+	ID             uint      `gorm:"primaryKey" json:"-"`
+	TenantID       uint      `gorm:"not null;default:0;uniqueIndex:idx_conversations_tenant_ext" json:"tenant_id"`
+	ConversationID string    `gorm:"not null;uniqueIndex:idx_conversations_tenant_ext" json:"conversation_id"`
+	UserID         uint      `gorm:"column:user_id;index" json:"user_id"`
+	Channel        string    `json:"channel,omitempty"`
+	MessageCount   int       `json:"message_count"`
+	StartedAt      time.Time `json:"started_at"`
+	LastMessageAt  time.Time `gorm:"index" json:"last_message_at"`
+	// LastIntent is the most recent intent detected in a user turn
+	LastIntent string                `json:"last_intent,omitempty"`
+	Messages   []ConversationMessage `gorm:"-" json:"messages,omitempty"`
+}
+
+// TableName specifies the table name for Conversation
+func (Conversation) TableName() string {
+	return "chatbot.conversations"
+}
+
+// ConversationMessage is one turn of a conversation
+type ConversationMessage struct {
+	ID             uint   `gorm:"primaryKey" json:"id"`
+	TenantID       uint   `gorm:"not null;default:0;index:idx_conversation_messages_thread" json:"-"`
+	ConversationID string `gorm:"not null;index:idx_conversation_messages_thread" json:"conversation_id"`
+	// Seq numbers the turns of a conversation from 1 in arrival order
+	Seq        int            `gorm:"not null;index:idx_conversation_messages_thread" json:"seq"`
+	Role       string         `gorm:"not null" json:"role"`
+	Text       string         `json:"text"`
+	Intent     string         `gorm:"index" json:"intent,omitempty"`
+	Confidence *float64       `json:"confidence,omitempty"`
+	Entities   datatypes.JSON `json:"entities,omitempty"`
+	// RecordID links the turn to the interaction record it produced, e.g. an issue
+	RecordID  *uint     `json:"record_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for ConversationMessage
+func (ConversationMessage) TableName() string {
+	return "chatbot.conversation_messages"
+}
+
+// NewConversationMessage is a chatbot turn as posted by the chatbot
+type NewConversationMessage struct {
+	Role       string                 `json:"role"`
+	Text       string                 `json:"text"`
+	Intent     string                 `json:"intent"`
+	Confidence *float64               `json:"confidence"`
+	Entities   map[string]interface{} `json:"entities"`
+	RecordID   *uint                  `json:"record_id"`
+	UserID     uint                   `json:"user_id"`
+	Channel    string                 `json:"channel"`
+	// SentAt defaults to the time the turn is stored
+	SentAt *time.Time `json:"sent_at"`
+}
+
+// ConversationService defines the interface for conversation threads
+type ConversationService interface {
+	AddMessage(tenantID uint, conversationID string, message NewConversationMessage) (ConversationMessage, error)
+	// GetConversation returns the conversation with its turns in order
+	GetConversation(tenantID uint, conversationID string) (Conversation, error)
+	ListConversations(tenantID uint, userID *uint, intent string) ([]Conversation, error)
+}
+
+// GormConversationService implements ConversationService using GORM
+type GormConversationService struct {
+	db *gorm.DB
+}
+
+// NewGormConversationService creates a new GormConversationService
+func NewGormConversationService(db *gorm.DB) ConversationService {
+	return &GormConversationService{db: db}
+}
+
+// validateMessage checks a turn before it is stored
+func validateMessage(conversationID string, message *NewConversationMessage) error {
+	if !conversationIDPattern.MatchString(conversationID) {
+		return fmt.Errorf("%w: conversation ID must be 1-128 letters, digits or ._:-", ErrInvalidMessage)
+	}
+	if message.Role == "" {
+		message.Role = RoleUser
+	}
+	switch message.Role {
+	case RoleUser, RoleBot, RoleAgent:
+	default:
+		return fmt.Errorf("%w: role must be user, bot or agent", ErrInvalidMessage)
+	}
+	if strings.TrimSpace(message.Text) == "" && message.Intent == "" {
+		return fmt.Errorf("%w: text or intent is required", ErrInvalidMessage)
+	}
+	if message.Confidence != nil && (*message.Confidence < 0 || *message.Confidence > 1) {
+		return fmt.Errorf("%w: confidence must be between 0 and 1", ErrInvalidMessage)
+	}
+	return nil
+}
+
+// AddMessage appends a turn; the conversation row is created by its first turn and numbers the turns
+func (s *GormConversationService) AddMessage(tenantID uint, conversationID string, message NewConversationMessage) (ConversationMessage, error) {
+	if err := validateMessage(conversationID, &message); err != nil {
+		return ConversationMessage{}, err
+	}
+	var entities datatypes.JSON
+	if len(message.Entities) > 0 {
+		raw, err := json.Marshal(message.Entities)
+		if err != nil {
+			return ConversationMessage{}, fmt.Errorf("%w: entities: %v", ErrInvalidMessage, err)
+		}
+		entities = raw
+	}
+	sentAt := time.Now()
+	if message.SentAt != nil {
+		sentAt = *message.SentAt
+	}
+
+	stored := ConversationMessage{
+		TenantID:       tenantID,
+		ConversationID: conversationID,
+		Role:           message.Role,
+		Text:           message.Text,
+		Intent:         message.Intent,
+		Confidence:     message.Confidence,
+		Entities:       entities,
+		RecordID:       message.RecordID,
+		CreatedAt:      sentAt,
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		conversation := Conversation{
+			TenantID:       tenantID,
+			ConversationID: conversationID,
+			UserID:         message.UserID,
+			Channel:        message.Channel,
+			MessageCount:   1,
+			StartedAt:      sentAt,
+			LastMessageAt:  sentAt,
+		}
+		if message.Role == RoleUser {
+			conversation.LastIntent = message.Intent
+		}
+		// The upsert locks the conversation row, so concurrent turns get distinct sequence numbers
+		updates := map[string]interface{}{
+			"message_count":   gorm.Expr("chatbot.conversations.message_count + 1"),
+			"last_message_at": gorm.Expr("greatest(chatbot.conversations.last_message_at, excluded.last_message_at)"),
+		}
+		if conversation.LastIntent != "" {
+			updates["last_intent"] = conversation.LastIntent
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "conversation_id"}},
+			DoUpdates: clause.Assignments(updates),
+		}, clause.Returning{Columns: []clause.Column{{Name: "message_count"}}}).Create(&conversation).Error
+		if err != nil {
+			return fmt.Errorf("failed to update conversation: %v", err)
+		}
+		stored.Seq = conversation.MessageCount
+		if err := tx.Create(&stored).Error; err != nil {
+			return fmt.Errorf("failed to store message: %v", err)
+		}
+		return WriteOutbox(tx, Event{
+			Type:     EventConversationMessage,
+			TenantID: tenantID,
+			Data: map[string]interface{}{
+				"conversation_id": conversationID, "seq": stored.Seq, "role": stored.Role,
+				"intent": stored.Intent, "record_id": stored.RecordID,
+			},
+			Time: sentAt,
+		})
+	})
+	if err != nil {
+		return ConversationMessage{}, err
+	}
+	return stored, nil
+}
+
+// GetConversation reconstructs the thread of a conversation
+func (s *GormConversationService) GetConversation(tenantID uint, conversationID string) (Conversation, error) {
+	var conversation Conversation
+	err := s.db.Where("tenant_id = ? AND conversation_id = ?", tenantID, conversationID).First(&conversation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Conversation{}, fmt.Errorf("%w: %s", ErrConversationNotFound, conversationID)
+	}
+	if err != nil {
+		return Conversation{}, fmt.Errorf("failed to load conversation: %v", err)
+	}
+	err = s.db.Where("tenant_id = ? AND conversation_id = ?", tenantID, conversationID).
+		Order("seq").Find(&conversation.Messages).Error
+	if err != nil {
+		return Conversation{}, fmt.Errorf("failed to load conversation messages: %v", err)
+	}
+	return conversation, nil
+}
+
+// ListConversations returns the tenant's conversations, most recently active first, without their turns
+func (s *GormConversationService) ListConversations(tenantID uint, userID *uint, intent string) ([]Conversation, error) {
+	query := s.db.Where("tenant_id = ?", tenantID)
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+	if intent != "" {
+		query = query.Where("last_intent = ?", intent)
+	}
+	var conversations []Conversation
+	if err := query.Order("last_message_at desc").Find(&conversations).Error; err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %v", err)
+	}
+	return conversations, nil
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateMessage(t *testing.T) {
+	message := NewConversationMessage{Text: "où est ma commande ?", Intent: "track_order"}
+	if err := validateMessage("wa:21674000000-1", &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Role != RoleUser {
+		t.Errorf("role defaults to user, got %q", message.Role)
+	}
+
+	high := 1.5
+	for name, tc := range map[string]struct {
+		id      string
+		message NewConversationMessage
+	}{
+		"bad id":     {"conv 1", NewConversationMessage{Text: "hi"}},
+		"long id":    {strings.Repeat("a", 129), NewConversationMessage{Text: "hi"}},
+		"bad role":   {"c1", NewConversationMessage{Role: "system", Text: "hi"}},
+		"empty":      {"c1", NewConversationMessage{Text: "  "}},
+		"confidence": {"c1", NewConversationMessage{Intent: "greet", Confidence: &high}},
+	} {
+		if err := validateMessage(tc.id, &tc.message); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: expected ErrInvalidMessage, got %v", name, err)
+		}
+	}
+}
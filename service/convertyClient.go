@@ -0,0 +1,414 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultConvertyURL is the host serving the Converty store API
+const DefaultConvertyURL = "https://api.converty.shop"
+
+// ConvertyClient knows the paths and response shapes of one version of the Converty store API.
+// The decoders normalize every version into the same Order, Product, Category and Coupon structs, so
+// moving to a new upstream version only means adding a client here.
+type ConvertyClient interface {
+	// Version is the API version, e.g. v1
+	Version() string
+	// URL returns the absolute URL of a resource path such as "orders" or "orders/42"
+	URL(path string) string
+	// EncodeOrder and EncodeStatus build the bodies of order creation and status updates
+	EncodeOrder(order NewOrder) ([]byte, error)
+	EncodeStatus(status string) ([]byte, error)
+	DecodeOrders(body []byte) ([]Order, error)
+	DecodeOrder(body []byte) (Order, error)
+	DecodeProducts(body []byte) ([]Product, error)
+	DecodeProduct(body []byte) (Product, error)
+	DecodeCategories(body []byte) ([]Category, error)
+	DecodeCoupon(body []byte) (Coupon, error)
+}
+
+// NewConvertyClient returns the client of version ("v1" or "v2") talking to baseURL; an empty
+// baseURL means DefaultConvertyURL
+func NewConvertyClient(version, baseURL string) (ConvertyClient, error) {
+	if baseURL == "" {
+		baseURL = DefaultConvertyURL
+	}
+	parsed, err := url.Parse(baseURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid Converty API URL %q", baseURL)
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+	switch version {
+	case "", "v1":
+		return convertyV1{baseURL: baseURL}, nil
+	case "v2":
+		return convertyV2{baseURL: baseURL}, nil
+	default:
+		return nil, fmt.Errorf("unsupported Converty API version %q, use v1 or v2", version)
+	}
+}
+
+// convertyV1 speaks /api/v1: {"success", "message", "data"} envelopes and snake_case timestamps
+type convertyV1 struct {
+	baseURL string
+}
+
+func (c convertyV1) Version() string { return "v1" }
+
+func (c convertyV1) URL(path string) string { return c.baseURL + "/api/v1/" + path }
+
+func (c convertyV1) EncodeOrder(order NewOrder) ([]byte, error) { return json.Marshal(order) }
+
+func (c convertyV1) EncodeStatus(status string) ([]byte, error) {
+	return json.Marshal(map[string]string{"status": status})
+}
+
+// decodeV1 unwraps the v1 envelope into data, turning "success": false into an error
+func decodeV1[T any](body []byte) (T, error) {
+	var envelope struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+		Data    T      `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return envelope.Data, fmt.Errorf("failed to parse response: %v", err)
+	}
+	if !envelope.Success {
+		return envelope.Data, ParseConvertyError(0, body)
+	}
+	return envelope.Data, nil
+}
+
+func (c convertyV1) DecodeOrders(body []byte) ([]Order, error) {
+	items, err := decodeV1[[]orderItem](body)
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]Order, 0, len(items))
+	for _, item := range items {
+		orders = append(orders, item.toOrder())
+	}
+	return orders, nil
+}
+
+func (c convertyV1) DecodeOrder(body []byte) (Order, error) {
+	item, err := decodeV1[orderItem](body)
+	if err != nil {
+		return Order{}, err
+	}
+	return item.toOrder(), nil
+}
+
+func (c convertyV1) DecodeProducts(body []byte) ([]Product, error) {
+	items, err := decodeV1[[]productItem](body)
+	if err != nil {
+		return nil, err
+	}
+	products := make([]Product, 0, len(items))
+	for _, item := range items {
+		products = append(products, item.toProduct())
+	}
+	return products, nil
+}
+
+func (c convertyV1) DecodeProduct(body []byte) (Product, error) {
+	item, err := decodeV1[productItem](body)
+	if err != nil {
+		return Product{}, err
+	}
+	return item.toProduct(), nil
+}
+
+func (c convertyV1) DecodeCategories(body []byte) ([]Category, error) {
+	items, err := decodeV1[[]categoryItem](body)
+	if err != nil {
+		return nil, err
+	}
+	categories := make([]Category, 0, len(items))
+	for _, item := range items {
+		categories = append(categories, Category{
+			ExternalID: item.ID,
+			Name:       item.Name,
+			Slug:       item.Slug,
+			ParentID:   item.ParentID,
+		})
+	}
+	return categories, nil
+}
+
+func (c convertyV1) DecodeCoupon(body []byte) (Coupon, error) {
+	item, err := decodeV1[couponItem](body)
+	if err != nil {
+		return Coupon{}, err
+	}
+	return item.toCoupon(), nil
+}
+
+// convertyV2 speaks the /api/v2 draft: {"data", "error"} envelopes, camelCase fields,
+// amounts as {"amount", "currency"} and the stock under inventory
+type convertyV2 struct {
+	baseURL string
+}
+
+// v2Money is how v2 represents prices and totals
+type v2Money struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// v2Order is the v2 JSON shape of an order
+type v2Order struct {
+	ID        string  `json:"id"`
+	Status    string  `json:"status"`
+	Customer  v2Party `json:"customer"`
+	Total     v2Money `json:"total"`
+	CreatedAt string  `json:"createdAt"`
+	UpdatedAt string  `json:"updatedAt"`
+	Lines     []struct {
+		ProductID string  `json:"productId"`
+		Name      string  `json:"name"`
+		Category  string  `json:"category"`
+		Quantity  int     `json:"quantity"`
+		UnitPrice v2Money `json:"unitPrice"`
+	} `json:"lines"`
+	Shipment struct {
+		Carrier        string `json:"carrier"`
+		TrackingNumber string `json:"trackingNumber"`
+	} `json:"shipment"`
+}
+
+// v2Party is the v2 JSON shape of a customer
+type v2Party struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Phone   string `json:"phone"`
+	Note    string `json:"note"`
+	Address struct {
+		Line1 string `json:"line1"`
+		City  string `json:"city"`
+	} `json:"address"`
+}
+
+// v2Product is the v2 JSON shape of a product
+type v2Product struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Category  string  `json:"category"`
+	Price     v2Money `json:"price"`
+	Inventory struct {
+		Available int `json:"available"`
+	} `json:"inventory"`
+}
+
+// v2Category is the v2 JSON shape of a category
+type v2Category struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID string `json:"parentId"`
+}
+
+// v2Coupon is the v2 JSON shape of a coupon
+type v2Coupon struct {
+	Code     string `json:"code"`
+	Status   string `json:"status"`
+	Discount struct {
+		Type  string   `json:"type"`
+		Value float64  `json:"value"`
+		Cap   *v2Money `json:"cap"`
+	} `json:"discount"`
+	MinimumOrder *v2Money `json:"minimumOrder"`
+	Validity     struct {
+		From  string `json:"from"`
+		Until string `json:"until"`
+	} `json:"validity"`
+	Usage struct {
+		Limit int `json:"limit"`
+		Used  int `json:"used"`
+	} `json:"usage"`
+	ProductIDs []string `json:"productIds"`
+}
+
+func (item v2Coupon) toCoupon() Coupon {
+	coupon := Coupon{
+		Code:       item.Code,
+		Type:       couponType(item.Discount.Type),
+		Value:      item.Discount.Value,
+		StartsAt:   couponTime(item.Validity.From),
+		ExpiresAt:  couponTime(item.Validity.Until),
+		UsageLimit: item.Usage.Limit,
+		UsedCount:  item.Usage.Used,
+		Active:     item.Status == "active",
+		ProductIDs: item.ProductIDs,
+	}
+	if item.Discount.Cap != nil {
+		coupon.MaxDiscount = item.Discount.Cap.Amount
+		coupon.Currency = strings.ToUpper(item.Discount.Cap.Currency)
+	}
+	if item.MinimumOrder != nil {
+		coupon.MinOrderTotal = item.MinimumOrder.Amount
+		coupon.Currency = strings.ToUpper(item.MinimumOrder.Currency)
+	}
+	return coupon
+}
+
+func (c convertyV2) Version() string { return "v2" }
+
+func (c convertyV2) URL(path string) string { return c.baseURL + "/api/v2/" + path }
+
+func (c convertyV2) EncodeOrder(order NewOrder) ([]byte, error) {
+	lines := make([]map[string]interface{}, 0, len(order.Items))
+	for _, item := range order.Items {
+		lines = append(lines, map[string]interface{}{
+			"productId": item.ProductID,
+			"quantity":  item.Quantity,
+		})
+	}
+	customer := v2Party{Name: order.Customer.Name, Email: order.Customer.Email, Phone: order.Customer.Phone, Note: order.Customer.Note}
+	customer.Address.Line1 = order.Customer.Address
+	customer.Address.City = order.Customer.City
+	return json.Marshal(map[string]interface{}{
+		"customer": customer,
+		"lines":    lines,
+		"note":     order.Note,
+	})
+}
+
+func (c convertyV2) EncodeStatus(status string) ([]byte, error) {
+	return json.Marshal(map[string]string{"status": status})
+}
+
+// decodeV2 unwraps the v2 envelope into data, turning an "error" object into an error
+func decodeV2[T any](body []byte) (T, error) {
+	var envelope struct {
+		Data  T               `json:"data"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return envelope.Data, fmt.Errorf("failed to parse response: %v", err)
+	}
+	if nonEmptyJSON(envelope.Error) != nil {
+		return envelope.Data, ParseConvertyError(0, body)
+	}
+	return envelope.Data, nil
+}
+
+func (item v2Order) toOrder() Order {
+	createdAt, err := time.Parse(time.RFC3339, item.CreatedAt)
+	if err != nil {
+		createdAt = time.Now() // Fallback, as in v1
+	}
+	updatedAt, err := time.Parse(time.RFC3339, item.UpdatedAt)
+	if err != nil {
+		updatedAt = createdAt
+	}
+	lines := make([]OrderLine, 0, len(item.Lines))
+	for _, line := range item.Lines {
+		lines = append(lines, OrderLine{
+			ProductID: line.ProductID,
+			Name:      line.Name,
+			Category:  line.Category,
+			Quantity:  line.Quantity,
+			Price:     line.UnitPrice.Amount,
+		})
+	}
+	return Order{
+		ID: item.ID,
+		Customer: Customer{
+			Name:    item.Customer.Name,
+			Address: item.Customer.Address.Line1,
+			Note:    item.Customer.Note,
+			Email:   item.Customer.Email,
+			Phone:   item.Customer.Phone,
+			City:    item.Customer.Address.City,
+		},
+		Status:    item.Status,
+		Total:     item.Total.Amount,
+		Currency:  strings.ToUpper(item.Total.Currency),
+		CreatedAt: createdAt,
+		UpdatedAt: updatedAt,
+		Items:     lines,
+
+		DeliveryCompany: item.Shipment.Carrier,
+		TrackingNumber:  item.Shipment.TrackingNumber,
+	}
+}
+
+func (item v2Product) toProduct() Product {
+	return Product{
+		ID:       item.ID,
+		Name:     item.Name,
+		Category: item.Category,
+		Price:    item.Price.Amount,
+		Currency: strings.ToUpper(item.Price.Currency),
+		Stock:    item.Inventory.Available,
+	}
+}
+
+func (c convertyV2) DecodeOrders(body []byte) ([]Order, error) {
+	items, err := decodeV2[[]v2Order](body)
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]Order, 0, len(items))
+	for _, item := range items {
+		orders = append(orders, item.toOrder())
+	}
+	return orders, nil
+}
+
+func (c convertyV2) DecodeOrder(body []byte) (Order, error) {
+	item, err := decodeV2[v2Order](body)
+	if err != nil {
+		return Order{}, err
+	}
+	return item.toOrder(), nil
+}
+
+func (c convertyV2) DecodeProducts(body []byte) ([]Product, error) {
+	items, err := decodeV2[[]v2Product](body)
+	if err != nil {
+		return nil, err
+	}
+	products := make([]Product, 0, len(items))
+	for _, item := range items {
+		products = append(products, item.toProduct())
+	}
+	return products, nil
+}
+
+func (c convertyV2) DecodeProduct(body []byte) (Product, error) {
+	item, err := decodeV2[v2Product](body)
+	if err != nil {
+		return Product{}, err
+	}
+	return item.toProduct(), nil
+}
+
+func (c convertyV2) DecodeCategories(body []byte) ([]Category, error) {
+	items, err := decodeV2[[]v2Category](body)
+	if err != nil {
+		return nil, err
+	}
+	categories := make([]Category, 0, len(items))
+	for _, item := range items {
+		categories = append(categories, Category{
+			ExternalID: item.ID,
+			Name:       item.Name,
+			Slug:       item.Slug,
+			ParentID:   item.ParentID,
+		})
+	}
+	return categories, nil
+}
+
+func (c convertyV2) DecodeCoupon(body []byte) (Coupon, error) {
+	item, err := decodeV2[v2Coupon](body)
+	if err != nil {
+		return Coupon{}, err
+	}
+	return item.toCoupon(), nil
+}
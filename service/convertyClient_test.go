@@ -0,0 +1,50 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvertyClientsNormalizeOrders(t *testing.T) {
+	v1, err := NewConvertyClient("v1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := NewConvertyClient("v2", "https://converty.test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v2.URL("orders/42"); got != "https://converty.test/api/v2/orders/42" {
+		t.Errorf("v2 URL = %s", got)
+	}
+
+	fromV1, err := v1.DecodeOrders([]byte(`{"success":true,"data":[{"id":"o-1","status":"shipped","total":59.5,"currency":"tnd",
+		"created_at":"2025-03-01T10:00:00Z","updated_at":"2025-03-02T10:00:00Z",
+		"customer":{"name":"Amira","phone":"+21620000000","address":"5 rue de Marseille","city":"Tunis"},
+		"items":[{"product_id":"p-1","name":"Blender","quantity":1,"price":59.5}],
+		"deliveryCompany":"aramex","trackingNumber":"AR123"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromV2, err := v2.DecodeOrders([]byte(`{"data":[{"id":"o-1","status":"shipped","total":{"amount":59.5,"currency":"tnd"},
+		"createdAt":"2025-03-01T10:00:00Z","updatedAt":"2025-03-02T10:00:00Z",
+		"customer":{"name":"Amira","phone":"+21620000000","address":{"line1":"5 rue de Marseille","city":"Tunis"}},
+		"lines":[{"productId":"p-1","name":"Blender","quantity":1,"unitPrice":{"amount":59.5,"currency":"tnd"}}],
+		"shipment":{"carrier":"aramex","trackingNumber":"AR123"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromV1, fromV2) {
+		t.Errorf("versions disagree:\nv1 %+v\nv2 %+v", fromV1, fromV2)
+	}
+
+	if _, err := v1.DecodeOrder([]byte(`{"success":false,"message":"order not found"}`)); err == nil || err.Error() != "order not found" {
+		t.Errorf("v1 failure = %v", err)
+	}
+	if _, err := v2.DecodeProduct([]byte(`{"error":{"code":"not_found","message":"product not found"}}`)); err == nil {
+		t.Error("v2 error envelope accepted")
+	}
+	if _, err := NewConvertyClient("v3", ""); err == nil {
+		t.Error("unknown version accepted")
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// convertyErrorMessageMax bounds the raw body kept as the message of an error Converty did not describe
+const convertyErrorMessageMax = 500
+
+// ConvertyError is an error reported by the Converty API, decoded from the error body so clients of this
+// API get Converty's own code and message. It unwraps to ErrNotFound, ErrValidation, ErrTokenExpired or
+// ErrUpstreamUnavailable according to the upstream status.
+type ConvertyError struct {
+	// Status is the HTTP status of the Converty response; 0 when a 200 response reported a failure
+	Status int `json:"status,omitempty"`
+	// Code is Converty's machine-readable error code, when it sent one
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+	// Details are the field errors or other details Converty attached, as sent
+	Details json.RawMessage `json:"details,omitempty"`
+	kind    error
+}
+
+func (e *ConvertyError) Error() string {
+	message := e.Message
+	if e.Code != "" {
+		message = fmt.Sprintf("%s (%s)", message, e.Code)
+	}
+	if e.Status == 0 {
+		return message
+	}
+	if e.kind != nil {
+		return fmt.Sprintf("%v: API request failed with status %d: %s", e.kind, e.Status, message)
+	}
+	return fmt.Sprintf("API request failed with status %d: %s", e.Status, message)
+}
+
+func (e *ConvertyError) Unwrap() error {
+	return e.kind
+}
+
+// ParseConvertyError decodes a Converty error body. It understands the v1 envelope
+// {"success": false, "message", "code", "errors"}, the v2 envelope {"error": {"code", "message", "details"}}
+// and OAuth errors {"error", "error_description"}; other bodies become the message as they are.
+func ParseConvertyError(status int, body []byte) *ConvertyError {
+	e := &ConvertyError{Status: status}
+	var envelope struct {
+		Message          string          `json:"message"`
+		Code             json.RawMessage `json:"code"`
+		Errors           json.RawMessage `json:"errors"`
+		Error            json.RawMessage `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		e.Message = rawErrorMessage(body)
+		return e
+	}
+	e.Message, e.Code, e.Details = envelope.Message, rawCode(envelope.Code), nonEmptyJSON(envelope.Errors)
+	var nested struct {
+		Code    json.RawMessage `json:"code"`
+		Message string          `json:"message"`
+		Details json.RawMessage `json:"details"`
+	}
+	var text string
+	switch {
+	case json.Unmarshal(envelope.Error, &nested) == nil:
+		if nested.Message != "" {
+			e.Message = nested.Message
+		}
+		if code := rawCode(nested.Code); code != "" {
+			e.Code = code
+		}
+		if details := nonEmptyJSON(nested.Details); details != nil {
+			e.Details = details
+		}
+	case json.Unmarshal(envelope.Error, &text) == nil && text != "":
+		if e.Code == "" {
+			e.Code = text
+		}
+		if e.Message == "" {
+			e.Message = envelope.ErrorDescription
+		}
+		if e.Message == "" {
+			e.Message = text
+		}
+	}
+	if e.Message == "" {
+		e.Message = rawErrorMessage(body)
+	}
+	return e
+}
+
+// rawCode reads an error code sent as a string or a number
+func rawCode(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var number json.Number
+	if json.Unmarshal(raw, &number) == nil {
+		return number.String()
+	}
+	return ""
+}
+
+// nonEmptyJSON drops absent, null and empty details
+func nonEmptyJSON(raw json.RawMessage) json.RawMessage {
+	trimmed := bytes.TrimSpace(raw)
+	switch string(trimmed) {
+	case "", "null", "{}", "[]", `""`:
+		return nil
+	}
+	return trimmed
+}
+
+// rawErrorMessage keeps the start of a body Converty did not describe as JSON
+func rawErrorMessage(body []byte) string {
+	message := strings.TrimSpace(string(body))
+	if len(message) > convertyErrorMessageMax {
+		message = message[:convertyErrorMessageMax] + "..."
+	}
+	if message == "" {
+		return "no error message"
+	}
+	return message
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseConvertyError(t *testing.T) {
+	tests := []struct {
+		name, body          string
+		code, message, want string
+	}{
+		{"v1", `{"success": false, "message": "Phone is invalid", "code": "INVALID_PHONE", "errors": {"phone": ["invalid"]}}`,
+			"INVALID_PHONE", "Phone is invalid", `{"phone": ["invalid"]}`},
+		{"v1 numeric code", `{"success": false, "message": "Out of stock", "code": 4091}`, "4091", "Out of stock", ""},
+		{"v2", `{"error": {"code": "order_not_found", "message": "No such order", "details": [{"field": "id"}]}}`,
+			"order_not_found", "No such order", `[{"field": "id"}]`},
+		{"oauth", `{"error": "invalid_token", "error_description": "The access token expired"}`, "invalid_token", "The access token expired", ""},
+		{"bare oauth", `{"error": "unauthorized"}`, "unauthorized", "unauthorized", ""},
+		{"html", `<html>Bad Gateway</html>`, "", "<html>Bad Gateway</html>", ""},
+		{"empty", ``, "", "no error message", ""},
+	}
+	for _, tt := range tests {
+		err := ParseConvertyError(http.StatusBadRequest, []byte(tt.body))
+		if err.Code != tt.code || err.Message != tt.message || string(err.Details) != tt.want {
+			t.Errorf("%s: got code %q message %q details %s", tt.name, err.Code, err.Message, err.Details)
+		}
+	}
+}
+
+func TestConvertyErrorClassification(t *testing.T) {
+	err := UpstreamStatusError(http.StatusUnprocessableEntity, []byte(`{"success": false, "message": "Phone is invalid", "code": "INVALID_PHONE"}`))
+	var upstream *ConvertyError
+	if !errors.As(err, &upstream) || !errors.Is(err, ErrValidation) || upstream.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected error %#v", err)
+	}
+	if want := "invalid input: API request failed with status 422: Phone is invalid (INVALID_PHONE)"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+
+	// A failure inside a 200 envelope keeps Converty's message as it is
+	if _, err := (convertyV2{}).DecodeOrder([]byte(`{"error": {"code": "gone", "message": "Order deleted"}}`)); !errors.As(err, &upstream) ||
+		upstream.Status != 0 || err.Error() != "Order deleted (gone)" {
+		t.Errorf("v2 envelope error: %v", err)
+	}
+}
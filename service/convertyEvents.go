@@ -0,0 +1,441 @@
+package service
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Converty webhook event statuses
+const (
+	ConvertyEventReceived  = "received"
+	ConvertyEventProcessed = "processed"
+	ConvertyEventFailed    = "failed"
+	// ConvertyEventIgnored marks events that do not carry an order, kept for the record
+	ConvertyEventIgnored = "ignored"
+)
+
+// Converty webhook event sources
+const (
+	ConvertyEventFromWebhook  = "webhook"
+	ConvertyEventFromBackfill = "backfill"
+)
+
+// ErrConvertyEventNotFound is returned when a stored Converty event does not exist for the tenant
+var ErrConvertyEventNotFound = errors.New("converty event not found")
+
+// ErrConvertyEventID is returned by Receive for a delivery without an event ID, which could not be deduplicated
+var ErrConvertyEventID = errors.New("the event carries no ID")
+
+// Errors of VerifyConvertySignature
+var (
+	ErrConvertySignature = errors.New("invalid signature")
+	ErrConvertyTimestamp = errors.New("timestamp missing or outside the tolerance window")
+)
+
+// ConvertyEvent is one order notification received from Converty, kept with its raw payload so it
+// can be applied again after a failure on our side
+type ConvertyEvent struct {
+	ID       uint `gorm:"primaryKey" json:"id"`
+	TenantID uint `gorm:"not null;default:0;index:idx_converty_events_tenant_order;uniqueIndex:idx_converty_events_tenant_event,where:event_id <> ''" json:"tenant_id"`
+	// EventID is Converty's delivery ID; a retried delivery carries the same one, so it is unique per tenant
+	EventID string `gorm:"uniqueIndex:idx_converty_events_tenant_event" json:"event_id,omitempty"`
+	Type    string `json:"type"`
+	OrderID string `gorm:"index:idx_converty_events_tenant_order" json:"order_id,omitempty"`
+	// OrderUpdatedAt is the order's updated_at in the payload; backfill looks for gaps in it
+	OrderUpdatedAt *time.Time `gorm:"index" json:"order_updated_at,omitempty"`
+	// Payload is the body exactly as received
+	Payload     json.RawMessage `gorm:"type:bytea" json:"payload"`
+	Source      string          `gorm:"not null;default:webhook" json:"source"`
+	Status      string          `gorm:"not null;index" json:"status"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	ReceivedAt  time.Time       `gorm:"not null" json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+}
+
+// TableName specifies the table name for ConvertyEvent
+func (ConvertyEvent) TableName() string {
+	return "chatbot.converty_events"
+}
+
+// ConvertyEventFilter narrows an event listing; zero values are ignored
+type ConvertyEventFilter struct {
+	Status  string
+	OrderID string
+	Source  string
+}
+
+// ConvertyBackfillOptions bounds a backfill run
+type ConvertyBackfillOptions struct {
+	// Since and Until delimit the window checked for gaps
+	Since time.Time
+	Until time.Time
+	// Gap is the shortest silence between two events treated as missed deliveries
+	Gap time.Duration
+	// MaxPages bounds the upstream pages read
+	MaxPages int
+}
+
+// ConvertyEventGap is a stretch of updated_at without any received event
+type ConvertyEventGap struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// ConvertyBackfillResult summarizes one backfill run
+type ConvertyBackfillResult struct {
+	Gaps    []ConvertyEventGap `json:"gaps"`
+	Pages   int                `json:"pages"`
+	Fetched int                `json:"fetched"`
+	Stored  int                `json:"stored"`
+}
+
+// ConvertyEventService stores the Converty order webhooks and applies them to the order mirror
+type ConvertyEventService interface {
+	// Receive stores a payload, then applies it. The event is kept when applying fails, so an error of
+	// ours never loses it; a delivery already processed is returned as is.
+	Receive(tenantID uint, payload []byte) (ConvertyEvent, error)
+	ListEvents(tenantID uint, filter ConvertyEventFilter) ([]ConvertyEvent, error)
+	GetEvent(tenantID, id uint) (ConvertyEvent, error)
+	// Replay applies a stored event again, whatever its status
+	Replay(tenantID, id uint) (ConvertyEvent, error)
+	// Backfill fetches the orders changed inside the gaps between received events and stores each one
+	// not seen yet as a backfill event
+	Backfill(tenantID uint, dataService DataService, opts ConvertyBackfillOptions) (ConvertyBackfillResult, error)
+}
+
+// GormConvertyEventService implements ConvertyEventService using GORM
+type GormConvertyEventService struct {
+	db  *gorm.DB
+	now func() time.Time
+}
+
+// NewGormConvertyEventService creates a new GormConvertyEventService
+func NewGormConvertyEventService(db *gorm.DB) ConvertyEventService {
+	return &GormConvertyEventService{db: db, now: time.Now}
+}
+
+// convertyWebhook is the envelope of a Converty webhook: {"id": "evt_1", "event": "order.updated", "data": {order}}
+type convertyWebhook struct {
+	ID    string          `json:"id"`
+	Event string          `json:"event"`
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// ConvertyWebhookSecret derives the webhook secret of a tenant from the master secret, so a tenant
+// knowing its own secret cannot sign deliveries for another one
+func ConvertyWebhookSecret(masterSecret, tenantSlug string) string {
+	return SignOutboxBody(masterSecret, []byte("converty-webhook:"+tenantSlug))
+}
+
+// SignConvertyWebhook returns the signature of a delivery, the hex HMAC-SHA256 of "<timestamp>.<body>"
+func SignConvertyWebhook(secret, timestamp string, body []byte) string {
+	return SignOutboxBody(secret, append([]byte(timestamp+"."), body...))
+}
+
+// VerifyConvertySignature checks a webhook signature header, optionally prefixed with "sha256=", and
+// that its timestamp header, in Unix seconds, is within tolerance of now so a captured delivery
+// cannot be replayed later
+func VerifyConvertySignature(secret string, body []byte, header, timestamp string, tolerance time.Duration, now time.Time) error {
+	signature := strings.TrimPrefix(strings.TrimSpace(header), "sha256=")
+	timestamp = strings.TrimSpace(timestamp)
+	if signature == "" || !hmac.Equal([]byte(signature), []byte(SignConvertyWebhook(secret, timestamp, body))) {
+		return ErrConvertySignature
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrConvertyTimestamp
+	}
+	if skew := now.Sub(time.Unix(seconds, 0)); skew > tolerance || skew < -tolerance {
+		return ErrConvertyTimestamp
+	}
+	return nil
+}
+
+// decodeConvertyWebhook reads the envelope and the order it carries, if any
+func decodeConvertyWebhook(payload []byte) (convertyWebhook, *Order, error) {
+	var envelope convertyWebhook
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return envelope, nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	if envelope.Event == "" {
+		envelope.Event = envelope.Type
+	}
+	if !strings.HasPrefix(envelope.Event, "order.") {
+		return envelope, nil, nil
+	}
+	var item orderItem
+	if err := json.Unmarshal(envelope.Data, &item); err != nil {
+		return envelope, nil, fmt.Errorf("invalid order: %v", err)
+	}
+	if item.ID == "" {
+		return envelope, nil, errors.New("the event carries no order ID")
+	}
+	order := item.toOrder()
+	return envelope, &order, nil
+}
+
+// Receive stores the payload before anything can fail, then applies it. Deliveries are deduplicated
+// on their event ID, so one without it is refused.
+func (s *GormConvertyEventService) Receive(tenantID uint, payload []byte) (ConvertyEvent, error) {
+	event := ConvertyEvent{
+		TenantID: tenantID, Payload: json.RawMessage(payload), Source: ConvertyEventFromWebhook,
+		Status: ConvertyEventReceived, ReceivedAt: s.now(),
+	}
+	envelope, order, decodeErr := decodeConvertyWebhook(payload)
+	event.EventID, event.Type = envelope.ID, envelope.Event
+	if event.EventID == "" {
+		return event, ErrConvertyEventID
+	}
+	if order != nil {
+		event.OrderID = order.ID
+		event.OrderUpdatedAt = &order.UpdatedAt
+	}
+	// A concurrent redelivery loses the insert on the unique event ID and takes the stored event
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&event)
+	if result.Error != nil {
+		return event, fmt.Errorf("failed to store converty event: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		var existing ConvertyEvent
+		err := s.db.Where("tenant_id = ? AND event_id = ?", tenantID, event.EventID).First(&existing).Error
+		if err != nil {
+			return event, fmt.Errorf("failed to look up converty event: %v", err)
+		}
+		if existing.Status == ConvertyEventProcessed || existing.Status == ConvertyEventIgnored {
+			return existing, nil
+		}
+		return s.apply(existing)
+	}
+	if decodeErr != nil {
+		return event, s.finish(&event, decodeErr)
+	}
+	return s.apply(event)
+}
+
+// apply copies the order of an event into the mirror and records the outcome on the event
+func (s *GormConvertyEventService) apply(event ConvertyEvent) (ConvertyEvent, error) {
+	_, order, err := decodeConvertyWebhook(event.Payload)
+	if err == nil && order != nil {
+		err = s.storeOrder(event.TenantID, *order)
+	}
+	if err == nil && order == nil {
+		event.Status = ConvertyEventIgnored
+	}
+	return event, s.finish(&event, err)
+}
+
+// storeOrder upserts the order unless the mirror already holds a newer version, which a replayed
+// or late event must not overwrite
+func (s *GormConvertyEventService) storeOrder(tenantID uint, order Order) error {
+	var newer int64
+	err := s.db.Model(&OrderRecord{}).
+		Where("tenant_id = ? AND order_id = ? AND upstream_updated_at > ?", tenantID, order.ID, order.UpdatedAt).
+		Count(&newer).Error
+	if err != nil {
+		return fmt.Errorf("failed to read the mirrored order: %v", err)
+	}
+	if newer > 0 {
+		return nil
+	}
+	return storeOrders(s.db, tenantID, []Order{order}, "")
+}
+
+// finish saves the attempt, marking the event failed with applyErr or done, and returns applyErr
+func (s *GormConvertyEventService) finish(event *ConvertyEvent, applyErr error) error {
+	now := s.now()
+	event.Attempts++
+	event.LastError = ""
+	switch {
+	case applyErr != nil:
+		event.Status = ConvertyEventFailed
+		event.LastError = applyErr.Error()
+	case event.Status != ConvertyEventIgnored:
+		event.Status = ConvertyEventProcessed
+		event.ProcessedAt = &now
+	}
+	err := s.db.Model(&ConvertyEvent{ID: event.ID}).Updates(map[string]interface{}{
+		"status": event.Status, "attempts": event.Attempts, "last_error": event.LastError, "processed_at": event.ProcessedAt,
+	}).Error
+	if applyErr != nil {
+		return applyErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update converty event: %v", err)
+	}
+	return nil
+}
+
+// ListEvents returns the tenant's latest events, newest first
+func (s *GormConvertyEventService) ListEvents(tenantID uint, filter ConvertyEventFilter) ([]ConvertyEvent, error) {
+	query := s.db.Where("tenant_id = ?", tenantID)
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.OrderID != "" {
+		query = query.Where("order_id = ?", filter.OrderID)
+	}
+	if filter.Source != "" {
+		query = query.Where("source = ?", filter.Source)
+	}
+	var events []ConvertyEvent
+	if err := query.Order("id desc").Limit(500).Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to list converty events: %v", err)
+	}
+	return events, nil
+}
+
+// GetEvent returns one event of the tenant
+func (s *GormConvertyEventService) GetEvent(tenantID, id uint) (ConvertyEvent, error) {
+	var event ConvertyEvent
+	err := s.db.Where("tenant_id = ? AND id = ?", tenantID, id).First(&event).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return event, ErrConvertyEventNotFound
+	}
+	if err != nil {
+		return event, fmt.Errorf("failed to load converty event: %v", err)
+	}
+	return event, nil
+}
+
+// Replay applies the stored payload again
+func (s *GormConvertyEventService) Replay(tenantID, id uint) (ConvertyEvent, error) {
+	event, err := s.GetEvent(tenantID, id)
+	if err != nil {
+		return event, err
+	}
+	event.Status = ConvertyEventReceived
+	return s.apply(event)
+}
+
+// Backfill reads the updated_at of the events received in the window, then walks the upstream
+// listing, newest first, back to the start of the oldest gap
+func (s *GormConvertyEventService) Backfill(tenantID uint, dataService DataService, opts ConvertyBackfillOptions) (ConvertyBackfillResult, error) {
+	var result ConvertyBackfillResult
+	if opts.Until.IsZero() {
+		opts.Until = s.now()
+	}
+	if opts.MaxPages <= 0 {
+		opts.MaxPages = 10
+	}
+	var seen []time.Time
+	err := s.db.Model(&ConvertyEvent{}).
+		Where("tenant_id = ? AND order_updated_at >= ? AND order_updated_at <= ?", tenantID, opts.Since, opts.Until).
+		Order("order_updated_at").Pluck("order_updated_at", &seen).Error
+	if err != nil {
+		return result, fmt.Errorf("failed to read converty events: %v", err)
+	}
+	result.Gaps = findEventGaps(seen, opts.Since, opts.Until, opts.Gap)
+	if len(result.Gaps) == 0 {
+		return result, nil
+	}
+	oldest := result.Gaps[0].From
+
+	query := CustomerOrderQuery{Limit: 100}
+	for page := 1; page <= opts.MaxPages; page++ {
+		query.Page = page
+		orders, err := dataService.ListOrders(query)
+		if err != nil {
+			return result, fmt.Errorf("failed to fetch orders: %w", err)
+		}
+		result.Pages = page
+		reachedGap := false
+		for _, order := range orders {
+			if order.UpdatedAt.Before(oldest) {
+				continue
+			}
+			reachedGap = true
+			if !inEventGap(result.Gaps, order.UpdatedAt) {
+				continue
+			}
+			result.Fetched++
+			stored, err := s.backfillOrder(tenantID, order)
+			if err != nil {
+				return result, err
+			}
+			if stored {
+				result.Stored++
+			}
+		}
+		if !reachedGap || len(orders) < query.Limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+// backfillOrder stores and applies an order fetched from Converty as a backfill event, unless an
+// event already carries that version of the order
+func (s *GormConvertyEventService) backfillOrder(tenantID uint, order Order) (bool, error) {
+	var known int64
+	err := s.db.Model(&ConvertyEvent{}).
+		Where("tenant_id = ? AND order_id = ? AND order_updated_at >= ?", tenantID, order.ID, order.UpdatedAt).
+		Count(&known).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to look up converty events: %v", err)
+	}
+	if known > 0 {
+		return false, nil
+	}
+	payload, err := json.Marshal(map[string]interface{}{"event": "order.updated", "data": orderPayload(order)})
+	if err != nil {
+		return false, fmt.Errorf("failed to encode order %s: %v", order.ID, err)
+	}
+	updatedAt := order.UpdatedAt
+	event := ConvertyEvent{
+		TenantID: tenantID, Type: "order.updated", OrderID: order.ID, OrderUpdatedAt: &updatedAt, Payload: payload,
+		Source: ConvertyEventFromBackfill, Status: ConvertyEventReceived, ReceivedAt: s.now(),
+	}
+	if err := s.db.Create(&event).Error; err != nil {
+		return false, fmt.Errorf("failed to store converty event: %v", err)
+	}
+	if _, err := s.apply(event); err != nil {
+		return false, fmt.Errorf("failed to apply order %s: %v", order.ID, err)
+	}
+	return true, nil
+}
+
+// orderPayload renders an order in the upstream shape, as a webhook would have carried it
+func orderPayload(order Order) orderItem {
+	return orderItem{
+		ID: order.ID, Customer: order.Customer, Status: order.Status, Total: order.Total, Currency: order.Currency,
+		CreatedAt: order.CreatedAt.Format(time.RFC3339), UpdatedAt: order.UpdatedAt.Format(time.RFC3339), Items: order.Items,
+		DeliveryCompany: order.DeliveryCompany, TrackingNumber: order.TrackingNumber,
+	}
+}
+
+// findEventGaps returns the stretches of [since, until] longer than gap without any of the sorted times
+func findEventGaps(times []time.Time, since, until time.Time, gap time.Duration) []ConvertyEventGap {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	var gaps []ConvertyEventGap
+	previous := since
+	for _, t := range append(times, until) {
+		if t.Sub(previous) > gap {
+			gaps = append(gaps, ConvertyEventGap{From: previous, To: t})
+		}
+		if t.After(previous) {
+			previous = t
+		}
+	}
+	return gaps
+}
+
+// inEventGap reports whether t falls strictly inside one of the gaps
+func inEventGap(gaps []ConvertyEventGap, t time.Time) bool {
+	for _, gap := range gaps {
+		if t.After(gap.From) && t.Before(gap.To) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyConvertySignature(t *testing.T) {
+	body := []byte(`{"id":"evt_1","event":"order.updated"}`)
+	now := time.Unix(1790000000, 0)
+	timestamp := "1790000000"
+	signature := SignConvertyWebhook("s3cret", timestamp, body)
+	for _, header := range []string{signature, "sha256=" + signature, " sha256=" + signature} {
+		if err := VerifyConvertySignature("s3cret", body, header, timestamp, 5*time.Minute, now); err != nil {
+			t.Errorf("valid signature %q rejected: %v", header, err)
+		}
+	}
+	for _, header := range []string{
+		"", "sha256=", SignConvertyWebhook("other", timestamp, body), SignConvertyWebhook("s3cret", timestamp, []byte("{}")),
+		SignConvertyWebhook("s3cret", "1790000001", body), SignOutboxBody("s3cret", body),
+	} {
+		if err := VerifyConvertySignature("s3cret", body, header, timestamp, 5*time.Minute, now); err != ErrConvertySignature {
+			t.Errorf("invalid signature %q: got %v", header, err)
+		}
+	}
+
+	// A delivery signed too long ago, or in the future, is a replay
+	for _, at := range []time.Time{now.Add(-6 * time.Minute), now.Add(6 * time.Minute)} {
+		stale := strconv.FormatInt(at.Unix(), 10)
+		if err := VerifyConvertySignature("s3cret", body, SignConvertyWebhook("s3cret", stale, body), stale, 5*time.Minute, now); err != ErrConvertyTimestamp {
+			t.Errorf("timestamp %s: got %v", stale, err)
+		}
+	}
+}
+
+func TestConvertyWebhookSecret(t *testing.T) {
+	shop, other := ConvertyWebhookSecret("master", "shop"), ConvertyWebhookSecret("master", "other")
+	if shop == other || shop == ConvertyWebhookSecret("rotated", "shop") || shop != ConvertyWebhookSecret("master", "shop") {
+		t.Errorf("tenant secrets are not distinct and stable: %s, %s", shop, other)
+	}
+}
+
+func TestDecodeConvertyWebhook(t *testing.T) {
+	envelope, order, err := decodeConvertyWebhook([]byte(`{"id": "evt_1", "event": "order.updated", "data": {
+		"id": "1001", "status": "shipped", "total": 42.5, "currency": "tnd", "deliveryCompany": "Aramex",
+		"created_at": "2026-10-01T10:00:00Z", "updated_at": "2026-10-02T08:30:00Z"}}`))
+	if err != nil || order == nil {
+		t.Fatalf("decode: %v, %v", order, err)
+	}
+	if envelope.ID != "evt_1" || order.ID != "1001" || order.Currency != "TND" || order.DeliveryCompany != "Aramex" ||
+		!order.UpdatedAt.Equal(time.Date(2026, 10, 2, 8, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected event %+v, order %+v", envelope, order)
+	}
+
+	// The topic may come as "type"; events without an order are kept but not applied
+	envelope, order, err = decodeConvertyWebhook([]byte(`{"type": "store.updated", "data": {"name": "Shop"}}`))
+	if err != nil || order != nil || envelope.Event != "store.updated" {
+		t.Errorf("non-order event: %+v, %v, %v", envelope, order, err)
+	}
+
+	for _, bad := range []string{`not json`, `{"event": "order.created", "data": {"status": "new"}}`, `{"event": "order.created", "data": []}`} {
+		if _, _, err := decodeConvertyWebhook([]byte(bad)); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestFindEventGaps(t *testing.T) {
+	since := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return since.Add(time.Duration(minutes) * time.Minute) }
+	until := at(240)
+
+	gaps := findEventGaps([]time.Time{at(100), at(10), at(20), at(200)}, since, until, 30*time.Minute)
+	want := []ConvertyEventGap{{From: at(20), To: at(100)}, {From: at(100), To: at(200)}, {From: at(200), To: until}}
+	if len(gaps) != len(want) {
+		t.Fatalf("gaps = %v, want %v", gaps, want)
+	}
+	for i := range want {
+		if !gaps[i].From.Equal(want[i].From) || !gaps[i].To.Equal(want[i].To) {
+			t.Errorf("gap %d = %v, want %v", i, gaps[i], want[i])
+		}
+	}
+	if !inEventGap(gaps, at(50)) || inEventGap(gaps, at(15)) || inEventGap(gaps, at(100)) {
+		t.Error("inEventGap misplaced a time")
+	}
+
+	// Without any event the whole window is one gap
+	if gaps := findEventGaps(nil, since, until, time.Hour); len(gaps) != 1 || !gaps[0].From.Equal(since) || !gaps[0].To.Equal(until) {
+		t.Errorf("empty window: %v", gaps)
+	}
+}
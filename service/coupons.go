@@ -0,0 +1,196 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Coupon types
+const (
+	CouponPercentage = "percentage"
+	CouponFixed      = "fixed"
+)
+
+// Reasons a coupon check fails
+const (
+	CouponNotFound     = "not_found"
+	CouponInactive     = "inactive"
+	CouponNotStarted   = "not_started"
+	CouponExpired      = "expired"
+	CouponExhausted    = "exhausted"
+	CouponBelowMinimum = "below_minimum"
+)
+
+// Coupon is a Converty discount code with its constraints
+type Coupon struct {
+	Code string `json:"code"`
+	// Type is percentage or fixed; Value is the percentage or the amount taken off
+	Type     string  `json:"type"`
+	Value    float64 `json:"value"`
+	Currency string  `json:"currency,omitempty"`
+	// MaxDiscount caps percentage discounts; 0 means no cap
+	MaxDiscount   float64    `json:"max_discount,omitempty"`
+	MinOrderTotal float64    `json:"min_order_total,omitempty"`
+	StartsAt      *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	// UsageLimit is how many orders may use the code; 0 means unlimited
+	UsageLimit int  `json:"usage_limit,omitempty"`
+	UsedCount  int  `json:"used_count"`
+	Active     bool `json:"active"`
+	// ProductIDs restricts the code to these products; empty means the whole catalog
+	ProductIDs []string `json:"product_ids,omitempty"`
+}
+
+// CouponCheck tells whether a code can be used now and what it takes off an order
+type CouponCheck struct {
+	Code   string `json:"code"`
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason,omitempty"`
+	// Discount is the amount taken off OrderTotal, when a total was given or the coupon is fixed
+	OrderTotal float64 `json:"order_total,omitempty"`
+	Discount   float64 `json:"discount,omitempty"`
+	Coupon     *Coupon `json:"coupon,omitempty"`
+}
+
+// Check evaluates the coupon at now for an order of orderTotal; 0 means the total is unknown
+// and skips the minimum order check
+func (c Coupon) Check(now time.Time, orderTotal float64) CouponCheck {
+	check := CouponCheck{Code: c.Code, Coupon: &c, OrderTotal: orderTotal}
+	switch {
+	case !c.Active:
+		check.Reason = CouponInactive
+	case c.StartsAt != nil && now.Before(*c.StartsAt):
+		check.Reason = CouponNotStarted
+	case c.ExpiresAt != nil && !now.Before(*c.ExpiresAt):
+		check.Reason = CouponExpired
+	case c.UsageLimit > 0 && c.UsedCount >= c.UsageLimit:
+		check.Reason = CouponExhausted
+	case orderTotal > 0 && orderTotal < c.MinOrderTotal:
+		check.Reason = CouponBelowMinimum
+	default:
+		check.Valid = true
+		check.Discount = c.discount(orderTotal)
+	}
+	return check
+}
+
+// discount computes the amount taken off orderTotal, rounded to millimes
+func (c Coupon) discount(orderTotal float64) float64 {
+	var amount float64
+	switch c.Type {
+	case CouponPercentage:
+		amount = orderTotal * c.Value / 100
+		if c.MaxDiscount > 0 && amount > c.MaxDiscount {
+			amount = c.MaxDiscount
+		}
+	case CouponFixed:
+		amount = c.Value
+		if orderTotal > 0 && amount > orderTotal {
+			amount = orderTotal
+		}
+	}
+	return math.Round(amount*1000) / 1000
+}
+
+// CheckCoupon looks up code in the Converty store and evaluates it for an order of orderTotal.
+// Unknown codes are an invalid check rather than an error.
+func (s *GormDataService) CheckCoupon(code string, orderTotal float64) (CouponCheck, error) {
+	coupon, err := s.GetCoupon(code)
+	if errors.Is(err, ErrNotFound) {
+		return CouponCheck{Code: code, Reason: CouponNotFound, OrderTotal: orderTotal}, nil
+	}
+	if err != nil {
+		return CouponCheck{}, err
+	}
+	return coupon.Check(time.Now(), orderTotal), nil
+}
+
+// GetCoupon fetches a discount code of the store
+func (s *GormDataService) GetCoupon(code string) (Coupon, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return Coupon{}, fmt.Errorf("%w: empty coupon code", ErrValidation)
+	}
+	tokenInfo, err := s.loadToken()
+	if err != nil {
+		return Coupon{}, err
+	}
+	req, err := http.NewRequest("GET", s.converty.URL("coupons/"+url.PathEscape(code)), nil)
+	if err != nil {
+		return Coupon{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	q := url.Values{}
+	q.Add("store_id", tokenInfo.storeID())
+	req.URL.RawQuery = q.Encode()
+
+	body, err := s.doConvertyRequest(req, tokenInfo)
+	if err != nil {
+		return Coupon{}, fmt.Errorf("failed to fetch coupon %s: %w", code, err)
+	}
+	coupon, err := s.converty.DecodeCoupon(body)
+	if err != nil {
+		return Coupon{}, fmt.Errorf("failed to fetch coupon %s: %v", code, err)
+	}
+	return coupon, nil
+}
+
+// couponItem is the v1 JSON shape of a coupon
+type couponItem struct {
+	Code        string   `json:"code"`
+	Type        string   `json:"type"`
+	Value       float64  `json:"value"`
+	Currency    string   `json:"currency"`
+	MaxDiscount float64  `json:"max_discount"`
+	MinOrder    float64  `json:"min_order"`
+	StartsAt    string   `json:"starts_at"`
+	ExpiresAt   string   `json:"expires_at"`
+	UsageLimit  int      `json:"usage_limit"`
+	UsedCount   int      `json:"used_count"`
+	Active      bool     `json:"active"`
+	ProductIDs  []string `json:"product_ids"`
+}
+
+func (item couponItem) toCoupon() Coupon {
+	return Coupon{
+		Code:          item.Code,
+		Type:          couponType(item.Type),
+		Value:         item.Value,
+		Currency:      strings.ToUpper(item.Currency),
+		MaxDiscount:   item.MaxDiscount,
+		MinOrderTotal: item.MinOrder,
+		StartsAt:      couponTime(item.StartsAt),
+		ExpiresAt:     couponTime(item.ExpiresAt),
+		UsageLimit:    item.UsageLimit,
+		UsedCount:     item.UsedCount,
+		Active:        item.Active,
+		ProductIDs:    item.ProductIDs,
+	}
+}
+
+// couponType maps the upstream spellings of the discount kinds to CouponPercentage and CouponFixed
+func couponType(upstream string) string {
+	switch strings.ToLower(upstream) {
+	case "percent", "percentage":
+		return CouponPercentage
+	case "fixed", "amount", "fixed_amount":
+		return CouponFixed
+	}
+	return strings.ToLower(upstream)
+}
+
+// couponTime parses an optional RFC 3339 timestamp
+func couponTime(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCouponCheck(t *testing.T) {
+	now := time.Date(2025, 3, 15, 12, 0, 0, 0, time.UTC)
+	ended := now.Add(-time.Hour)
+	ramadan := Coupon{Code: "RAMADAN20", Type: CouponPercentage, Value: 20, MaxDiscount: 30, MinOrderTotal: 50, Active: true}
+
+	for name, tc := range map[string]struct {
+		coupon       Coupon
+		total        float64
+		wantReason   string
+		wantDiscount float64
+	}{
+		"percentage":    {ramadan, 100, "", 20},
+		"capped":        {ramadan, 400, "", 30},
+		"unknown total": {ramadan, 0, "", 0},
+		"below minimum": {ramadan, 40, CouponBelowMinimum, 0},
+		"expired":       {Coupon{Code: "OLD", Type: CouponFixed, Value: 5, Active: true, ExpiresAt: &ended}, 100, CouponExpired, 0},
+		"exhausted":     {Coupon{Code: "FIRST10", Type: CouponFixed, Value: 10, Active: true, UsageLimit: 10, UsedCount: 10}, 100, CouponExhausted, 0},
+		"inactive":      {Coupon{Code: "OFF", Type: CouponFixed, Value: 10}, 100, CouponInactive, 0},
+		"fixed":         {Coupon{Code: "TEN", Type: CouponFixed, Value: 10, Active: true}, 8, "", 8},
+	} {
+		check := tc.coupon.Check(now, tc.total)
+		if check.Valid != (tc.wantReason == "") || check.Reason != tc.wantReason || check.Discount != tc.wantDiscount {
+			t.Errorf("%s: got valid=%v reason=%q discount=%v", name, check.Valid, check.Reason, check.Discount)
+		}
+	}
+}
+
+func TestDecodeCouponBothVersions(t *testing.T) {
+	v1, _ := NewConvertyClient("v1", "")
+	v2, _ := NewConvertyClient("v2", "")
+	fromV1, err := v1.DecodeCoupon([]byte(`{"success":true,"data":{"code":"RAMADAN20","type":"percent","value":20,
+		"currency":"tnd","min_order":50,"expires_at":"2025-04-01T00:00:00Z","usage_limit":100,"used_count":12,"active":true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromV2, err := v2.DecodeCoupon([]byte(`{"data":{"code":"RAMADAN20","status":"active","discount":{"type":"percentage","value":20},
+		"minimumOrder":{"amount":50,"currency":"TND"},"validity":{"until":"2025-04-01T00:00:00Z"},"usage":{"limit":100,"used":12}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromV1.Type != CouponPercentage || fromV1.Type != fromV2.Type || fromV1.MinOrderTotal != fromV2.MinOrderTotal ||
+		fromV1.Currency != fromV2.Currency || !fromV1.ExpiresAt.Equal(*fromV2.ExpiresAt) || fromV1.UsedCount != fromV2.UsedCount || !fromV2.Active {
+		t.Errorf("versions disagree:\nv1 %+v\nv2 %+v", fromV1, fromV2)
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// Wednesday 2025-01-15 10:30
+	base := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2025, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 8 * * 1", time.Date(2025, 1, 20, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * MON-FRI", time.Time{}},
+		{"30 10 * * *", time.Date(2025, 1, 16, 10, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 9 1,15 * *", time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC)},
+		// Sunday may be written as 7
+		{"0 12 * * 7", time.Date(2025, 1, 19, 12, 0, 0, 0, time.UTC)},
+		// Either restricted day field matches: the 31st or the next Friday
+		{"0 0 31 * 5", time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.expr)
+		if c.want.IsZero() {
+			if err == nil {
+				t.Errorf("%q must be rejected", c.expr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.expr, err)
+			continue
+		}
+		if got := cron.Next(base); !got.Equal(c.want) {
+			t.Errorf("%q: next run %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestParseCronRejectsInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *", "0 0 30 2 *"} {
+		cron, err := ParseCron(expr)
+		if err == nil && !cron.Next(time.Now()).IsZero() {
+			t.Errorf("%q must be rejected", expr)
+		}
+	}
+}
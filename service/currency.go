@@ -0,0 +1,221 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// RateProvider returns the exchange rate to convert one unit of from into to
+type RateProvider interface {
+	Rate(from, to string) (float64, error)
+}
+
+// StaticRateProvider serves rates from configuration
+type StaticRateProvider struct {
+	rates map[string]float64
+}
+
+// ParseStaticRates parses "EUR:TND=3.35,USD:TND=3.12" into a StaticRateProvider.
+// Inverse rates are derived automatically.
+func ParseStaticRates(spec string) (*StaticRateProvider, error) {
+	p := &StaticRateProvider{rates: make(map[string]float64)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pair, value, ok := strings.Cut(entry, "=")
+		from, to, okPair := strings.Cut(pair, ":")
+		if !ok || !okPair {
+			return nil, fmt.Errorf("invalid exchange rate %q, expected FROM:TO=rate", entry)
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid exchange rate value in %q", entry)
+		}
+		from, to = strings.ToUpper(strings.TrimSpace(from)), strings.ToUpper(strings.TrimSpace(to))
+		p.rates[from+":"+to] = rate
+		if _, exists := p.rates[to+":"+from]; !exists {
+			p.rates[to+":"+from] = 1 / rate
+		}
+	}
+	return p, nil
+}
+
+// Rate returns the configured rate
+func (p *StaticRateProvider) Rate(from, to string) (float64, error) {
+	rate, ok := p.rates[strings.ToUpper(from)+":"+strings.ToUpper(to)]
+	if !ok {
+		return 0, fmt.Errorf("no exchange rate configured for %s to %s", from, to)
+	}
+	return rate, nil
+}
+
+// Backoff of the exchange-rate API after failed fetches: the first retry waits rateRetryMin, each
+// further failure doubles the wait up to rateRetryMax
+const (
+	rateRetryMin = 5 * time.Second
+	rateRetryMax = 10 * time.Minute
+)
+
+// HTTPRateProvider fetches rates from an exchange-rate API answering
+// GET {URL}?base=EUR with {"rates": {"TND": 3.35, ...}}; results are cached for TTL
+type HTTPRateProvider struct {
+	URL    string
+	TTL    time.Duration
+	client *http.Client
+	// refreshes lets one caller per base currency fetch a stale table while the others wait for it
+	refreshes singleflight.Group
+
+	// mu guards the cache, never held during a fetch
+	mu      sync.Mutex
+	fetched map[string]time.Time
+	rates   map[string]map[string]float64
+	// failures counts the consecutive failed fetches of a base currency; until retryAt its last error
+	// is answered, or its previous table served, without calling the API
+	failures  map[string]int
+	retryAt   map[string]time.Time
+	lastError map[string]error
+}
+
+// NewHTTPRateProvider creates a new HTTPRateProvider
+func NewHTTPRateProvider(url string, ttl time.Duration) *HTTPRateProvider {
+	return &HTTPRateProvider{
+		URL:       url,
+		TTL:       ttl,
+		client:    NewHTTPClient(10 * time.Second),
+		fetched:   make(map[string]time.Time),
+		rates:     make(map[string]map[string]float64),
+		failures:  make(map[string]int),
+		retryAt:   make(map[string]time.Time),
+		lastError: make(map[string]error),
+	}
+}
+
+// Rate returns the rate from the API, refreshing the cached table for from when stale. A failed
+// refresh keeps serving the previous table, and the API is not called again before the backoff ends.
+func (p *HTTPRateProvider) Rate(from, to string) (float64, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	p.mu.Lock()
+	now := time.Now()
+	stale := now.Sub(p.fetched[from]) > p.TTL && !now.Before(p.retryAt[from])
+	table, cached := p.rates[from]
+	lastError := p.lastError[from]
+	p.mu.Unlock()
+
+	if stale {
+		fresh, err, _ := p.refreshes.Do(from, func() (interface{}, error) {
+			return p.refresh(from)
+		})
+		if err == nil {
+			table, cached = fresh.(map[string]float64), true
+		} else {
+			lastError = err
+		}
+	}
+	if !cached {
+		return 0, lastError
+	}
+	rate, ok := table[to]
+	if !ok {
+		return 0, fmt.Errorf("exchange rate API has no rate for %s to %s", from, to)
+	}
+	return rate, nil
+}
+
+// refresh fetches the table of base and records the outcome, backing off after a failure
+func (p *HTTPRateProvider) refresh(base string) (map[string]float64, error) {
+	rates, err := p.fetch(base)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.failures[base]++
+		backoff := rateRetryMin << min(p.failures[base]-1, 10)
+		p.retryAt[base] = time.Now().Add(min(backoff, rateRetryMax))
+		p.lastError[base] = err
+		return nil, err
+	}
+	p.rates[base] = rates
+	p.fetched[base] = time.Now()
+	delete(p.failures, base)
+	delete(p.retryAt, base)
+	delete(p.lastError, base)
+	return rates, nil
+}
+
+func (p *HTTPRateProvider) fetch(base string) (map[string]float64, error) {
+	resp, err := p.client.Get(p.URL + "?base=" + base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch exchange rates: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exchange rates: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchange rate API returned status %d: %s", resp.StatusCode, string(body))
+	}
+	var payload struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse exchange rates: %v", err)
+	}
+	return payload.Rates, nil
+}
+
+// CurrencyConverter converts order amounts into a reporting currency
+type CurrencyConverter struct {
+	Provider RateProvider
+	// StoreCurrency is assumed for orders that don't report a currency
+	StoreCurrency string
+	// ReportingCurrency is the default target currency
+	ReportingCurrency string
+}
+
+// Convert converts amount from one currency to another, rounded to 3 decimals (millimes)
+func (c *CurrencyConverter) Convert(amount float64, from, to string) (float64, float64, error) {
+	if strings.EqualFold(from, to) {
+		return amount, 1, nil
+	}
+	if c.Provider == nil {
+		return 0, 0, fmt.Errorf("no exchange rate provider configured")
+	}
+	rate, err := c.Provider.Rate(from, to)
+	if err != nil {
+		return 0, 0, err
+	}
+	return math.Round(amount*rate*1000) / 1000, rate, nil
+}
+
+// ConvertOrders fills the converted amount fields of orders for the target currency
+// (the default reporting currency when to is empty)
+func (c *CurrencyConverter) ConvertOrders(orders []Order, to string) error {
+	if to == "" {
+		to = c.ReportingCurrency
+	}
+	to = strings.ToUpper(to)
+	for i := range orders {
+		if orders[i].Currency == "" {
+			orders[i].Currency = c.StoreCurrency
+		}
+		converted, rate, err := c.Convert(orders[i].Total, orders[i].Currency, to)
+		if err != nil {
+			return fmt.Errorf("failed to convert order %s: %v", orders[i].ID, err)
+		}
+		orders[i].ConvertedTotal = &converted
+		orders[i].ConvertedCurrency = to
+		orders[i].ExchangeRate = rate
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaticRatesConvertOrders(t *testing.T) {
+	rates, err := ParseStaticRates("EUR:TND=3.4")
+	if err != nil {
+		t.Fatalf("ParseStaticRates failed: %v", err)
+	}
+	converter := &CurrencyConverter{Provider: rates, StoreCurrency: "TND", ReportingCurrency: "TND"}
+
+	orders := []Order{{ID: "1", Total: 10, Currency: "EUR"}, {ID: "2", Total: 34}}
+	if err := converter.ConvertOrders(orders, ""); err != nil {
+		t.Fatalf("ConvertOrders failed: %v", err)
+	}
+	if *orders[0].ConvertedTotal != 34 {
+		t.Fatalf("expected 34 TND, got %v", *orders[0].ConvertedTotal)
+	}
+	if orders[1].Currency != "TND" || *orders[1].ConvertedTotal != 34 {
+		t.Fatalf("expected store currency to be assumed, got %+v", orders[1])
+	}
+
+	if err := converter.ConvertOrders(orders[1:], "EUR"); err != nil {
+		t.Fatalf("inverse conversion failed: %v", err)
+	}
+	if *orders[1].ConvertedTotal != 10 {
+		t.Fatalf("expected 10 EUR, got %v", *orders[1].ConvertedTotal)
+	}
+}
+
+func TestHTTPRateProviderSharesFetchesAndBacksOff(t *testing.T) {
+	var calls atomic.Int32
+	var failing atomic.Bool
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"rates": {"TND": 3.4}}`))
+	}))
+	defer server.Close()
+	provider := NewHTTPRateProvider(server.URL, time.Hour)
+
+	// Concurrent callers wait for one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rate, err := provider.Rate("eur", "tnd"); err != nil || rate != 3.4 {
+				t.Errorf("rate %v, %v", rate, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatalf("%d fetches for concurrent callers, want 1", calls.Load())
+	}
+
+	// A failed fetch is not retried before its backoff ends
+	failing.Store(true)
+	for i := 0; i < 3; i++ {
+		if _, err := provider.Rate("USD", "TND"); err == nil {
+			t.Fatal("a failed fetch returned a rate")
+		}
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("%d fetches after a failure, want 2", calls.Load())
+	}
+	provider.mu.Lock()
+	wait := time.Until(provider.retryAt["USD"])
+	provider.mu.Unlock()
+	if wait <= 0 || wait > rateRetryMin {
+		t.Fatalf("retry in %v, want within %v", wait, rateRetryMin)
+	}
+
+	// The cached table of a failing base is still served once stale
+	provider.mu.Lock()
+	provider.fetched["EUR"] = time.Now().Add(-2 * time.Hour)
+	provider.mu.Unlock()
+	if rate, err := provider.Rate("EUR", "TND"); err != nil || rate != 3.4 {
+		t.Fatalf("stale table: %v, %v", rate, err)
+	}
+}
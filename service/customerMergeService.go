@@ -0,0 +1,444 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrMergeUndone is returned when a merge that was already undone is undone again
+var ErrMergeUndone = errors.New("merge already undone")
+
+// CustomerIdentity is how a customer is known: the chatbot user ID, the phone number, or both
+type CustomerIdentity struct {
+	Phone  string `json:"phone,omitempty"`
+	UserID uint   `json:"user_id,omitempty"`
+}
+
+// CustomerMerge records that the secondary identity was folded into the primary one
+type CustomerMerge struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	TenantID        uint       `gorm:"not null;default:0;index" json:"tenant_id"`
+	PrimaryPhone    string     `gorm:"index" json:"primary_phone,omitempty"`
+	PrimaryUserID   uint       `json:"primary_user_id,omitempty"`
+	SecondaryPhone  string     `gorm:"index" json:"secondary_phone,omitempty"`
+	SecondaryUserID uint       `json:"secondary_user_id,omitempty"`
+	Reason          string     `json:"reason,omitempty"`
+	MergedBy        string     `json:"merged_by"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UndoneAt        *time.Time `json:"undone_at,omitempty"`
+	UndoneBy        string     `json:"undone_by,omitempty"`
+	// Relinked counts the moved rows per table
+	Relinked map[string]int `gorm:"-" json:"relinked,omitempty"`
+}
+
+// TableName specifies the table name for CustomerMerge
+func (CustomerMerge) TableName() string {
+	return "chatbot.customer_merges"
+}
+
+// CustomerMergeChange is one column a merge rewrote, kept so the merge can be undone
+type CustomerMergeChange struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	MergeID  uint   `gorm:"not null;index" json:"merge_id"`
+	Table    string `gorm:"column:table_name;not null" json:"table"`
+	RowID    uint   `gorm:"not null" json:"row_id"`
+	Column   string `gorm:"column:column_name;not null" json:"column"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+// TableName specifies the table name for CustomerMergeChange
+func (CustomerMergeChange) TableName() string {
+	return "chatbot.customer_merge_changes"
+}
+
+// relinkTarget is a column holding a customer identity
+type relinkTarget struct {
+	table  string
+	column string
+	// byUser targets user ID columns, otherwise phone columns
+	byUser bool
+	// tenantScoped tables only relink rows of the merge tenant
+	tenantScoped bool
+}
+
+// relinkTargets lists every column a merge rewrites. Consent and other records are interactions;
+// orders are known locally through abandoned carts and the loyalty and wallet ledgers.
+var relinkTargets = []relinkTarget{
+	{table: "chatbot.interactions", column: "user_id", byUser: true, tenantScoped: true},
+	{table: "chatbot.interactions", column: "details.phone", tenantScoped: true},
+	{table: "chatbot.waitlists", column: "user_id", byUser: true, tenantScoped: true},
+	{table: "chatbot.waitlists", column: "customer_phone", tenantScoped: true},
+	{table: "chatbot.abandoned_carts", column: "customer_phone", tenantScoped: true},
+	{table: "chatbot.loyalty_entries", column: "customer_phone", tenantScoped: true},
+	{table: "chatbot.wallet_entries", column: "customer_phone", tenantScoped: true},
+	{table: "chatbot.wallet_entries", column: "account", tenantScoped: true},
+}
+
+// CustomerMergeService defines the interface for merging customer identities
+type CustomerMergeService interface {
+	Merge(tenantID uint, primary, secondary CustomerIdentity, reason, actor string) (CustomerMerge, error)
+	Undo(tenantID, id uint, actor string) (CustomerMerge, error)
+	ListMerges(tenantID uint) ([]CustomerMerge, error)
+	Changes(tenantID, id uint) ([]CustomerMergeChange, error)
+}
+
+// GormCustomerMergeService implements CustomerMergeService using GORM
+type GormCustomerMergeService struct {
+	db *gorm.DB
+}
+
+// NewGormCustomerMergeService creates a new GormCustomerMergeService
+func NewGormCustomerMergeService(db *gorm.DB) CustomerMergeService {
+	return &GormCustomerMergeService{db: db}
+}
+
+// identityValues returns the old and new value of target for a merge, or false when the merge does not touch it
+func identityValues(target relinkTarget, primary, secondary CustomerIdentity) (string, string, bool) {
+	if target.byUser {
+		if secondary.UserID == 0 || primary.UserID == 0 {
+			return "", "", false
+		}
+		return strconv.FormatUint(uint64(secondary.UserID), 10), strconv.FormatUint(uint64(primary.UserID), 10), true
+	}
+	if secondary.Phone == "" || primary.Phone == "" {
+		return "", "", false
+	}
+	if target.column == "account" {
+		return customerAccount(secondary.Phone), customerAccount(primary.Phone), true
+	}
+	return secondary.Phone, primary.Phone, true
+}
+
+// validateMerge normalizes the phones and rejects merges that would not move anything
+func validateMerge(primary, secondary *CustomerIdentity) error {
+	primary.Phone, secondary.Phone = NormalizePhone(primary.Phone), NormalizePhone(secondary.Phone)
+	if primary.Phone == "" && primary.UserID == 0 {
+		return fmt.Errorf("primary identity needs a phone or a user_id")
+	}
+	if secondary.Phone == "" && secondary.UserID == 0 {
+		return fmt.Errorf("secondary identity needs a phone or a user_id")
+	}
+	if (secondary.Phone == "" || primary.Phone == "") && (secondary.UserID == 0 || primary.UserID == 0) {
+		return fmt.Errorf("primary and secondary identities share neither a phone nor a user_id to merge")
+	}
+	if *primary == *secondary {
+		return fmt.Errorf("cannot merge an identity into itself")
+	}
+	return nil
+}
+
+// Merge relinks everything recorded under the secondary identity to the primary one.
+// Append-only records keep their details; only their owner is relinked.
+func (s *GormCustomerMergeService) Merge(tenantID uint, primary, secondary CustomerIdentity, reason, actor string) (CustomerMerge, error) {
+	if err := validateMerge(&primary, &secondary); err != nil {
+		return CustomerMerge{}, err
+	}
+	merge := CustomerMerge{
+		TenantID:        tenantID,
+		PrimaryPhone:    primary.Phone,
+		PrimaryUserID:   primary.UserID,
+		SecondaryPhone:  secondary.Phone,
+		SecondaryUserID: secondary.UserID,
+		Reason:          reason,
+		MergedBy:        actor,
+		CreatedAt:       time.Now(),
+		Relinked:        map[string]int{},
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&merge).Error; err != nil {
+			return fmt.Errorf("failed to create merge: %v", err)
+		}
+		for _, target := range relinkTargets {
+			from, to, ok := identityValues(target, primary, secondary)
+			if !ok {
+				continue
+			}
+			rows, err := matchingRows(tx, target, tenantID, from)
+			if err != nil {
+				return fmt.Errorf("failed to find %s rows: %v", target.table, err)
+			}
+			if len(rows) == 0 {
+				continue
+			}
+			ids := make([]uint, 0, len(rows))
+			changes := make([]CustomerMergeChange, 0, len(rows))
+			for _, row := range rows {
+				ids = append(ids, row.ID)
+				changes = append(changes, CustomerMergeChange{
+					MergeID: merge.ID, Table: target.table, RowID: row.ID, Column: target.column, OldValue: row.Value, NewValue: to,
+				})
+			}
+			if err := setIdentity(tx, target, ids, to); err != nil {
+				return fmt.Errorf("failed to relink %s: %v", target.table, err)
+			}
+			if err := tx.CreateInBatches(changes, 500).Error; err != nil {
+				return fmt.Errorf("failed to log merge changes: %v", err)
+			}
+			merge.Relinked[target.table] += len(rows)
+		}
+		return nil
+	})
+	if err != nil {
+		return CustomerMerge{}, err
+	}
+	return merge, nil
+}
+
+// identityRow is a row holding the identity being merged, with the value as stored
+type identityRow struct {
+	ID    uint
+	Value string
+}
+
+// targetValue is the SQL expression reading target's identity column
+func targetValue(target relinkTarget) string {
+	if target.column == "details.phone" {
+		return "details ->> 'phone'"
+	}
+	return target.column + "::text"
+}
+
+// normalizedPhoneSQL is NormalizePhone as an SQL expression over expr
+func normalizedPhoneSQL(expr string) string {
+	return "(CASE WHEN ltrim(" + expr + ") LIKE '+%' THEN '+' ELSE '' END || regexp_replace(" + expr + ", '[^0-9]', '', 'g'))"
+}
+
+// matchingRows returns the target rows holding value; phones match whatever their formatting
+func matchingRows(tx *gorm.DB, target relinkTarget, tenantID uint, value string) ([]identityRow, error) {
+	if target.column == "details.phone" && PII.Protects("phone") {
+		return matchingProtectedPhones(tx, tenantID, value)
+	}
+	query := tx.Table(target.table).Select("id, " + targetValue(target) + " AS value")
+	if target.tenantScoped {
+		query = query.Where("tenant_id = ?", tenantID)
+	}
+	switch {
+	case target.byUser || target.column == "account":
+		query = query.Where(targetValue(target)+" = ?", value)
+	default:
+		query = query.Where(normalizedPhoneSQL(targetValue(target))+" = ?", value)
+	}
+	if target.column == "details.phone" {
+		if immutable := immutableTypeList(); len(immutable) > 0 {
+			query = query.Where("type NOT IN ?", immutable)
+		}
+	}
+	var rows []identityRow
+	err := query.Order("id").Scan(&rows).Error
+	return rows, err
+}
+
+// matchingProtectedPhones finds the records whose phone is value, whether stored in clear or encrypted,
+// and returns the phones in clear
+func matchingProtectedPhones(tx *gorm.DB, tenantID uint, value string) ([]identityRow, error) {
+	query := tx.Unscoped().Where("tenant_id = ?", tenantID).
+		Where("("+normalizedPhoneSQL("details ->> 'phone'")+" = ? OR pii_hashes ->> 'phone' IN ?)", value, PII.LookupHashes("phone", value))
+	if immutable := immutableTypeList(); len(immutable) > 0 {
+		query = query.Where("type NOT IN ?", immutable)
+	}
+	var records []Data
+	if err := query.Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	rows := make([]identityRow, 0, len(records))
+	for _, record := range records {
+		var details struct {
+			Phone string `json:"phone"`
+		}
+		json.Unmarshal(record.Details, &details)
+		rows = append(rows, identityRow{ID: record.ID, Value: details.Phone})
+	}
+	return rows, nil
+}
+
+// setProtectedPhone rewrites the phone of records ids, encrypting it again
+func setProtectedPhone(tx *gorm.DB, ids []uint, value string) error {
+	var records []Data
+	if err := tx.Unscoped().Where("id IN ?", ids).Find(&records).Error; err != nil {
+		return err
+	}
+	for _, record := range records {
+		details, ok := decodeDetails(record.Details)
+		if !ok {
+			continue
+		}
+		details["phone"] = value
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		record.Details = datatypes.JSON(encoded)
+		if err := record.sealPII(); err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(&Data{}).Where("id = ?", record.ID).
+			UpdateColumns(map[string]interface{}{"details": record.Details, "pii_hashes": record.PIIHashes, "updated_at": time.Now()}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rekeyRecords re-encrypts the values records ids sealed for their current chatbot user, which
+// would no longer open once the records move to another user
+func rekeyRecords(tx *gorm.DB, ids []uint) error {
+	// Rows are read as stored, without the Data hooks
+	type storedRecord struct {
+		ID       uint
+		TenantID uint
+		UserID   uint
+		Details  datatypes.JSON
+	}
+	var records []storedRecord
+	err := tx.Table("chatbot.interactions").Select("id, tenant_id, user_id, details").
+		Where("id IN ? AND details::text LIKE ?", ids, "%"+piiLegacyPrefix+"%").Scan(&records).Error
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		rekeyed, changed, err := PII.Rekey(record.TenantID, record.UserID, record.Details)
+		if err != nil {
+			return fmt.Errorf("record %d: %w", record.ID, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := tx.Table("chatbot.interactions").Where("id = ?", record.ID).UpdateColumn("details", datatypes.JSON(rekeyed)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setIdentity writes value into the target column of rows ids
+func setIdentity(tx *gorm.DB, target relinkTarget, ids []uint, value string) error {
+	query := tx.Table(target.table).Where("id IN ?", ids)
+	switch target.column {
+	case "details.phone":
+		if PII.Protects("phone") {
+			return setProtectedPhone(tx, ids, value)
+		}
+		return query.Update("details", gorm.Expr("jsonb_set(details::jsonb, '{phone}', to_jsonb(?::text))", value)).Error
+	case "user_id":
+		userID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		if target.table == "chatbot.interactions" && PII != nil {
+			if err := rekeyRecords(tx, ids); err != nil {
+				return err
+			}
+		}
+		return query.Update("user_id", userID).Error
+	default:
+		return query.Update(target.column, value).Error
+	}
+}
+
+// Undo moves every relinked row back unless it was changed again since the merge
+func (s *GormCustomerMergeService) Undo(tenantID, id uint, actor string) (CustomerMerge, error) {
+	var merge CustomerMerge
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("tenant_id = ?", tenantID).First(&merge, id).Error; err != nil {
+			return fmt.Errorf("merge %d not found: %v", id, err)
+		}
+		if merge.UndoneAt != nil {
+			return fmt.Errorf("%w: merge %d", ErrMergeUndone, id)
+		}
+		var changes []CustomerMergeChange
+		if err := tx.Where("merge_id = ?", merge.ID).Order("id desc").Find(&changes).Error; err != nil {
+			return fmt.Errorf("failed to fetch merge changes: %v", err)
+		}
+		merge.Relinked = map[string]int{}
+		for _, group := range groupChanges(changes) {
+			target := relinkTarget{table: group.table, column: group.column, byUser: group.column == "user_id"}
+			// Only rows still holding the merged value go back
+			ids, err := stillRelinked(tx, target, group.ids, group.newValue)
+			if err != nil {
+				return fmt.Errorf("failed to check %s rows: %v", group.table, err)
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			if err := setIdentity(tx, target, ids, group.oldValue); err != nil {
+				return fmt.Errorf("failed to restore %s: %v", group.table, err)
+			}
+			merge.Relinked[group.table] += len(ids)
+		}
+		now := time.Now()
+		merge.UndoneAt, merge.UndoneBy = &now, actor
+		return tx.Model(&merge).Updates(map[string]interface{}{"undone_at": now, "undone_by": actor}).Error
+	})
+	if err != nil {
+		return CustomerMerge{}, err
+	}
+	return merge, nil
+}
+
+// changeGroup is the set of rows of one table and column a merge rewrote from oldValue to newValue
+type changeGroup struct {
+	table, column      string
+	oldValue, newValue string
+	ids                []uint
+}
+
+// groupChanges groups merge changes by table, column and values, keeping their order
+func groupChanges(changes []CustomerMergeChange) []*changeGroup {
+	var groups []*changeGroup
+	index := map[[4]string]*changeGroup{}
+	for _, change := range changes {
+		key := [4]string{change.Table, change.Column, change.OldValue, change.NewValue}
+		group, ok := index[key]
+		if !ok {
+			group = &changeGroup{table: change.Table, column: change.Column, oldValue: change.OldValue, newValue: change.NewValue}
+			index[key] = group
+			groups = append(groups, group)
+		}
+		group.ids = append(group.ids, change.RowID)
+	}
+	return groups
+}
+
+// stillRelinked returns the rows of ids that still hold value
+func stillRelinked(tx *gorm.DB, target relinkTarget, ids []uint, value string) ([]uint, error) {
+	var matched []uint
+	query := tx.Table(target.table).Where("id IN ?", ids)
+	if target.column == "details.phone" && PII.Protects("phone") {
+		query = query.Where("(details ->> 'phone' = ? OR pii_hashes ->> 'phone' IN ?)", value, PII.LookupHashes("phone", value))
+	} else {
+		query = query.Where(targetValue(target)+" = ?", value)
+	}
+	err := query.Order("id").Pluck("id", &matched).Error
+	return matched, err
+}
+
+// ListMerges fetches the tenant's merges, newest first
+func (s *GormCustomerMergeService) ListMerges(tenantID uint) ([]CustomerMerge, error) {
+	var merges []CustomerMerge
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("created_at desc, id desc").Find(&merges).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch merges: %v", err)
+	}
+	return merges, nil
+}
+
+// Changes fetches the rows a merge rewrote
+func (s *GormCustomerMergeService) Changes(tenantID, id uint) ([]CustomerMergeChange, error) {
+	var merge CustomerMerge
+	if err := s.db.Where("tenant_id = ?", tenantID).First(&merge, id).Error; err != nil {
+		return nil, fmt.Errorf("merge %d not found: %v", id, err)
+	}
+	var changes []CustomerMergeChange
+	if err := s.db.Where("merge_id = ?", id).Order("id").Find(&changes).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch merge changes: %v", err)
+	}
+	return changes, nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateMerge(t *testing.T) {
+	primary := CustomerIdentity{Phone: "+216 74 000 000", UserID: 1}
+	secondary := CustomerIdentity{Phone: "+216 (98) 111-222"}
+	if err := validateMerge(&primary, &secondary); err != nil {
+		t.Fatal(err)
+	}
+	if primary.Phone != "+21674000000" || secondary.Phone != "+21698111222" {
+		t.Fatalf("phones not normalized: %q %q", primary.Phone, secondary.Phone)
+	}
+
+	invalid := []struct{ primary, secondary CustomerIdentity }{
+		{CustomerIdentity{}, CustomerIdentity{Phone: "1"}},
+		{CustomerIdentity{Phone: "1"}, CustomerIdentity{}},
+		{CustomerIdentity{Phone: "1"}, CustomerIdentity{UserID: 2}},
+		{CustomerIdentity{Phone: "+1 2"}, CustomerIdentity{Phone: "+12"}},
+	}
+	for _, c := range invalid {
+		if err := validateMerge(&c.primary, &c.secondary); err == nil {
+			t.Errorf("merge of %+v into %+v must be rejected", c.secondary, c.primary)
+		}
+	}
+}
+
+func TestIdentityValues(t *testing.T) {
+	primary := CustomerIdentity{Phone: "+21674000000", UserID: 1}
+	secondary := CustomerIdentity{Phone: "+21698111222", UserID: 2}
+	got := map[string][2]string{}
+	for _, target := range relinkTargets {
+		if from, to, ok := identityValues(target, primary, secondary); ok {
+			got[target.table+"."+target.column] = [2]string{from, to}
+		}
+	}
+	if got["chatbot.interactions.user_id"] != [2]string{"2", "1"} {
+		t.Errorf("user relink: %v", got["chatbot.interactions.user_id"])
+	}
+	if got["chatbot.loyalty_entries.customer_phone"] != [2]string{"+21698111222", "+21674000000"} {
+		t.Errorf("loyalty relink: %v", got["chatbot.loyalty_entries.customer_phone"])
+	}
+	if got["chatbot.wallet_entries.account"] != [2]string{"customer:+21698111222", "customer:+21674000000"} {
+		t.Errorf("wallet account relink: %v", got["chatbot.wallet_entries.account"])
+	}
+
+	// A phone-only merge leaves user ID columns alone
+	if _, _, ok := identityValues(relinkTargets[0], CustomerIdentity{Phone: "1"}, CustomerIdentity{Phone: "2"}); ok {
+		t.Error("user_id must not be relinked without user IDs")
+	}
+}
+
+func TestGroupChanges(t *testing.T) {
+	changes := []CustomerMergeChange{
+		{Table: "chatbot.wallet_entries", Column: "account", RowID: 9, OldValue: "customer:2", NewValue: "customer:1"},
+		{Table: "chatbot.interactions", Column: "details.phone", RowID: 5, OldValue: "+216 2", NewValue: "1"},
+		{Table: "chatbot.interactions", Column: "details.phone", RowID: 4, OldValue: "2", NewValue: "1"},
+		{Table: "chatbot.interactions", Column: "details.phone", RowID: 3, OldValue: "+216 2", NewValue: "1"},
+	}
+	groups := groupChanges(changes)
+	if len(groups) != 3 {
+		t.Fatalf("got %d groups, want 3", len(groups))
+	}
+	if groups[0].table != "chatbot.wallet_entries" || !reflect.DeepEqual(groups[1].ids, []uint{5, 3}) || groups[1].oldValue != "+216 2" {
+		t.Fatalf("unexpected groups: %+v %+v", groups[0], groups[1])
+	}
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DigestStaleAfter is how long an issue stays unresolved before the daily digest lists it
+const DigestStaleAfter = 48 * time.Hour
+
+// digestStaleListed caps the unresolved issues listed in a digest; all of them are counted
+const digestStaleListed = 20
+
+// DigestDateLayout is the layout of digest dates, e.g. 2026-10-15
+const DigestDateLayout = "2006-01-02"
+
+// Token health in the daily digest, from best to worst
+const (
+	TokenHealthy  = "ok"
+	TokenExpiring = "expiring"
+	TokenExpired  = "expired"
+	TokenInvalid  = "invalid"
+	// TokenMissing is the health of a tenant without any stored token
+	TokenMissing = "missing"
+)
+
+// tokenHealthRank orders the token health values so the digest reports the worst
+var tokenHealthRank = map[string]int{TokenHealthy: 0, TokenExpiring: 1, TokenExpired: 2, TokenMissing: 3, TokenInvalid: 4}
+
+// DigestToken is the health of one stored Converty token of the tenant
+type DigestToken struct {
+	UserID           string    `json:"user_id"`
+	Health           string    `json:"health"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	Reason           string    `json:"reason,omitempty"`
+}
+
+// DigestIssue is an issue left unresolved for longer than DigestStaleAfter
+type DigestIssue struct {
+	ID        uint      `json:"id"`
+	UserID    uint      `json:"user_id"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DailyDigest sums up one day of a tenant for its managers: the orders and issues of the day, the issues
+// still open for more than 48 hours and the health of the Converty tokens when it was compiled
+type DailyDigest struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	TenantID uint   `gorm:"not null;default:0;uniqueIndex:idx_daily_digests_tenant_date" json:"tenant_id"`
+	Date     string `gorm:"size:10;not null;uniqueIndex:idx_daily_digests_tenant_date" json:"date"`
+	// NewOrders counts the orders placed on the day, OrderTotals sums them by currency
+	NewOrders        int                `json:"new_orders"`
+	OrderTotals      map[string]float64 `gorm:"serializer:json" json:"order_totals"`
+	NewIssues        int                `json:"new_issues"`
+	UnresolvedIssues int                `json:"unresolved_issues"`
+	// StaleIssues lists the oldest of the unresolved issues
+	StaleIssues []DigestIssue `gorm:"serializer:json" json:"stale_issues"`
+	Tokens      []DigestToken `gorm:"serializer:json" json:"tokens"`
+	// TokenHealth is the worst health among the tokens
+	TokenHealth string     `json:"token_health"`
+	CompiledAt  *time.Time `json:"compiled_at,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TableName specifies the table name for DailyDigest
+func (DailyDigest) TableName() string {
+	return "chatbot.daily_digests"
+}
+
+// Notification is the operator notification delivering the digest
+func (d DailyDigest) Notification() Notification {
+	return Notification{
+		Event: "daily_digest",
+		Message: fmt.Sprintf("Daily digest %s: %d new orders, %d new issues, %d issues unresolved for over %s, Converty tokens %s",
+			d.Date, d.NewOrders, d.NewIssues, d.UnresolvedIssues, DigestStaleAfter, d.TokenHealth),
+		Data: map[string]interface{}{
+			"tenant_id":         d.TenantID,
+			"date":              d.Date,
+			"new_orders":        d.NewOrders,
+			"order_totals":      d.OrderTotals,
+			"new_issues":        d.NewIssues,
+			"unresolved_issues": d.UnresolvedIssues,
+			"stale_issues":      d.StaleIssues,
+			"tokens":            d.Tokens,
+			"token_health":      d.TokenHealth,
+		},
+		CreatedAt: time.Now(),
+	}
+}
+
+// ClassifyToken rates a token as of now; a refresh token expiring within warn is reported as expiring
+func ClassifyToken(refreshExpiresAt time.Time, invalid bool, now time.Time, warn time.Duration) string {
+	switch {
+	case invalid:
+		return TokenInvalid
+	case !now.Before(refreshExpiresAt):
+		return TokenExpired
+	case refreshExpiresAt.Sub(now) <= warn:
+		return TokenExpiring
+	default:
+		return TokenHealthy
+	}
+}
+
+// worstTokenHealth is the worst health among tokens, TokenMissing without any
+func worstTokenHealth(tokens []DigestToken) string {
+	if len(tokens) == 0 {
+		return TokenMissing
+	}
+	worst := TokenHealthy
+	for _, token := range tokens {
+		if tokenHealthRank[token.Health] > tokenHealthRank[worst] {
+			worst = token.Health
+		}
+	}
+	return worst
+}
+
+// ParseDigestDate parses a digest date, rejecting other layouts with ErrValidation
+func ParseDigestDate(date string, location *time.Location) (time.Time, error) {
+	day, err := time.ParseInLocation(DigestDateLayout, date, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid date %q, use YYYY-MM-DD", ErrValidation, date)
+	}
+	return day, nil
+}
+
+// DailyDigestService compiles and stores the daily digests
+type DailyDigestService interface {
+	// ClaimDigest reserves the digest of a tenant and day; only the first of several instances gets true
+	ClaimDigest(tenantID uint, date string) (bool, error)
+	// CompileDigest computes the digest of a tenant and day as of now and stores it
+	CompileDigest(tenantID uint, date string, tokens []DigestToken, now time.Time) (DailyDigest, error)
+	// RecordDelivery stores when a digest was delivered, or why it was not
+	RecordDelivery(id uint, deliveredAt time.Time, deliveryErr error) error
+	GetDigest(tenantID uint, date string) (DailyDigest, error)
+}
+
+// GormDailyDigestService implements DailyDigestService using GORM
+type GormDailyDigestService struct {
+	db *gorm.DB
+	// location is the zone the days of the digests start and end in
+	location *time.Location
+}
+
+// NewGormDailyDigestService creates a new GormDailyDigestService with days in location
+func NewGormDailyDigestService(db *gorm.DB, location *time.Location) DailyDigestService {
+	if location == nil {
+		location = time.Local
+	}
+	return &GormDailyDigestService{db: db, location: location}
+}
+
+// ClaimDigest inserts the empty digest row; the unique tenant and date decide which instance compiles it
+func (s *GormDailyDigestService) ClaimDigest(tenantID uint, date string) (bool, error) {
+	if _, err := ParseDigestDate(date, s.location); err != nil {
+		return false, err
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&DailyDigest{TenantID: tenantID, Date: date})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim the digest of %s: %v", date, result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// CompileDigest counts the orders of the local order copy and the issue records of the day
+func (s *GormDailyDigestService) CompileDigest(tenantID uint, date string, tokens []DigestToken, now time.Time) (DailyDigest, error) {
+	from, err := ParseDigestDate(date, s.location)
+	if err != nil {
+		return DailyDigest{}, err
+	}
+	to := from.AddDate(0, 0, 1)
+	digest := DailyDigest{
+		TenantID:    tenantID,
+		Date:        date,
+		OrderTotals: map[string]float64{},
+		StaleIssues: []DigestIssue{},
+		Tokens:      tokens,
+		TokenHealth: worstTokenHealth(tokens),
+		CompiledAt:  &now,
+	}
+	if digest.Tokens == nil {
+		digest.Tokens = []DigestToken{}
+	}
+	sortDigestTokens(digest.Tokens)
+
+	var totals []struct {
+		Currency string
+		Orders   int
+		Total    float64
+	}
+	if err := s.db.Model(&OrderRecord{}).Select("currency, COUNT(*) AS orders, COALESCE(SUM(total), 0) AS total").
+		Where("tenant_id = ? AND ordered_at >= ? AND ordered_at < ?", tenantID, from, to).
+		Group("currency").Scan(&totals).Error; err != nil {
+		return DailyDigest{}, fmt.Errorf("failed to count orders: %v", err)
+	}
+	for _, total := range totals {
+		digest.NewOrders += total.Orders
+		digest.OrderTotals[total.Currency] += total.Total
+	}
+
+	issues := func() *gorm.DB {
+		return s.db.Model(&Data{}).Scopes(currentVersions).Where("tenant_id = ? AND type = ? AND archived_at IS NULL", tenantID, "issue")
+	}
+	var newIssues, unresolved int64
+	if err := issues().Where("created_at >= ? AND created_at < ?", from, to).Count(&newIssues).Error; err != nil {
+		return DailyDigest{}, fmt.Errorf("failed to count issues: %v", err)
+	}
+	open := []string{StatusPending, StatusInProgress}
+	staleBefore := now.Add(-DigestStaleAfter)
+	if err := issues().Where("status IN ? AND created_at < ?", open, staleBefore).Count(&unresolved).Error; err != nil {
+		return DailyDigest{}, fmt.Errorf("failed to count unresolved issues: %v", err)
+	}
+	if err := issues().Select("id, user_id, status, created_at").Where("status IN ? AND created_at < ?", open, staleBefore).
+		Order("created_at").Limit(digestStaleListed).Scan(&digest.StaleIssues).Error; err != nil {
+		return DailyDigest{}, fmt.Errorf("failed to fetch unresolved issues: %v", err)
+	}
+	digest.NewIssues, digest.UnresolvedIssues = int(newIssues), int(unresolved)
+
+	// A recompiled digest replaces the figures but keeps its delivery
+	err = s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "tenant_id"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"new_orders", "order_totals", "new_issues", "unresolved_issues",
+			"stale_issues", "tokens", "token_health", "compiled_at"}),
+	}).Create(&digest).Error
+	if err != nil {
+		return DailyDigest{}, fmt.Errorf("failed to store the digest of %s: %v", date, err)
+	}
+	return s.GetDigest(tenantID, date)
+}
+
+// RecordDelivery stores the delivery time, or the error and no time when the delivery failed
+func (s *GormDailyDigestService) RecordDelivery(id uint, deliveredAt time.Time, deliveryErr error) error {
+	changes := map[string]interface{}{"delivered_at": deliveredAt, "last_error": ""}
+	if deliveryErr != nil {
+		changes = map[string]interface{}{"delivered_at": nil, "last_error": deliveryErr.Error()}
+	}
+	if err := s.db.Model(&DailyDigest{}).Where("id = ?", id).Updates(changes).Error; err != nil {
+		return fmt.Errorf("failed to record the digest delivery: %v", err)
+	}
+	return nil
+}
+
+// GetDigest fetches a compiled digest; claimed digests not compiled yet are not found
+func (s *GormDailyDigestService) GetDigest(tenantID uint, date string) (DailyDigest, error) {
+	if _, err := ParseDigestDate(date, s.location); err != nil {
+		return DailyDigest{}, err
+	}
+	var digest DailyDigest
+	err := s.db.Where("tenant_id = ? AND date = ? AND compiled_at IS NOT NULL", tenantID, date).First(&digest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DailyDigest{}, fmt.Errorf("%w: no digest for %s", ErrNotFound, date)
+	}
+	if err != nil {
+		return DailyDigest{}, fmt.Errorf("failed to fetch the digest of %s: %v", date, err)
+	}
+	return digest, nil
+}
+
+// sortDigestTokens orders tokens worst first, then by user
+func sortDigestTokens(tokens []DigestToken) {
+	sort.SliceStable(tokens, func(i, j int) bool {
+		if tokenHealthRank[tokens[i].Health] != tokenHealthRank[tokens[j].Health] {
+			return tokenHealthRank[tokens[i].Health] > tokenHealthRank[tokens[j].Health]
+		}
+		return tokens[i].UserID < tokens[j].UserID
+	})
+}
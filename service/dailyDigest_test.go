@@ -0,0 +1,52 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyToken(t *testing.T) {
+	now := time.Date(2026, 10, 16, 7, 0, 0, 0, time.UTC)
+	cases := []struct {
+		expires time.Time
+		invalid bool
+		want    string
+	}{
+		{now.Add(30 * 24 * time.Hour), false, TokenHealthy},
+		{now.Add(24 * time.Hour), false, TokenExpiring},
+		{now, false, TokenExpired},
+		{now.Add(30 * 24 * time.Hour), true, TokenInvalid},
+	}
+	for _, c := range cases {
+		if got := ClassifyToken(c.expires, c.invalid, now, 72*time.Hour); got != c.want {
+			t.Errorf("ClassifyToken(%v, %v) = %s, want %s", c.expires, c.invalid, got, c.want)
+		}
+	}
+}
+
+func TestDigestTokenHealth(t *testing.T) {
+	if got := worstTokenHealth(nil); got != TokenMissing {
+		t.Errorf("no tokens: %s", got)
+	}
+	tokens := []DigestToken{{UserID: "b", Health: TokenHealthy}, {UserID: "c", Health: TokenExpiring}, {UserID: "a", Health: TokenHealthy}}
+	if got := worstTokenHealth(tokens); got != TokenExpiring {
+		t.Errorf("worst = %s, want expiring", got)
+	}
+	sortDigestTokens(tokens)
+	if tokens[0].UserID != "c" || tokens[1].UserID != "a" || tokens[2].UserID != "b" {
+		t.Errorf("unexpected order %+v", tokens)
+	}
+}
+
+func TestDailyDigestNotification(t *testing.T) {
+	digest := DailyDigest{TenantID: 2, Date: "2026-10-15", NewOrders: 12, NewIssues: 3, UnresolvedIssues: 1, TokenHealth: TokenExpiring}
+	n := digest.Notification()
+	if n.Event != "daily_digest" || !strings.Contains(n.Message, "12 new orders, 3 new issues, 1 issues unresolved") ||
+		!strings.Contains(n.Message, "tokens expiring") || n.Data["date"] != "2026-10-15" {
+		t.Errorf("unexpected notification %+v", n)
+	}
+	if _, err := ParseDigestDate("15/10/2026", time.UTC); err == nil {
+		t.Error("invalid date accepted")
+	}
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DashboardCounters are the live figures shown in the admin UI header
+type DashboardCounters struct {
+	TenantID      uint `json:"tenant_id"`
+	OrdersToday   int  `json:"orders_today"`
+	PendingIssues int  `json:"pending_issues"`
+	// Upstream figures cover the Converty calls of the last error window, across tenants
+	UpstreamCalls     int       `json:"upstream_calls"`
+	UpstreamErrors    int       `json:"upstream_errors"`
+	UpstreamErrorRate float64   `json:"upstream_error_rate"`
+	RefreshedAt       time.Time `json:"refreshed_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// upstreamOutcome is one upstream call in the error rate window
+type upstreamOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+// Dashboard aggregates events into per-tenant counters and signals subscribers when they change.
+// Orders and pending issues come from a periodic baseline that events adjust in between.
+type Dashboard struct {
+	mu          sync.Mutex
+	window      time.Duration
+	tenants     map[uint]*DashboardCounters
+	upstream    []upstreamOutcome
+	subscribers map[chan struct{}]uint
+	now         func() time.Time
+}
+
+// NewDashboard creates a dashboard computing the upstream error rate over window
+func NewDashboard(window time.Duration) *Dashboard {
+	return &Dashboard{
+		window:      window,
+		tenants:     make(map[uint]*DashboardCounters),
+		subscribers: make(map[chan struct{}]uint),
+		now:         time.Now,
+	}
+}
+
+// Run applies events from the bus until it is unsubscribed
+func (d *Dashboard) Run(events *EventBus) {
+	ch, _ := events.Subscribe(256)
+	for event := range ch {
+		d.Apply(event)
+	}
+}
+
+// Apply updates the counters affected by an event
+func (d *Dashboard) Apply(event Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch event.Type {
+	case EventUpstreamCall:
+		failed, _ := event.Data["failed"].(bool)
+		d.upstream = append(d.upstream, upstreamOutcome{at: event.Time, failed: failed})
+		d.pruneUpstream()
+		d.signal(nil)
+	case EventRecordCreated:
+		if event.Data["type"] == "issue" && event.Data["status"] == StatusPending {
+			counters := d.counters(event.TenantID)
+			counters.PendingIssues++
+			counters.UpdatedAt = event.Time
+			d.signal(&event.TenantID)
+		}
+	case EventRecordStatusChanged:
+		if event.Data["type"] != "issue" {
+			return
+		}
+		counters := d.counters(event.TenantID)
+		if event.Data["from"] == StatusPending && counters.PendingIssues > 0 {
+			counters.PendingIssues--
+		}
+		if event.Data["to"] == StatusPending {
+			counters.PendingIssues++
+		}
+		counters.UpdatedAt = event.Time
+		d.signal(&event.TenantID)
+	}
+}
+
+// SetBaseline replaces the counted figures of a tenant with freshly computed ones
+func (d *Dashboard) SetBaseline(tenantID uint, ordersToday, pendingIssues int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	counters := d.counters(tenantID)
+	counters.OrdersToday = ordersToday
+	counters.PendingIssues = pendingIssues
+	counters.RefreshedAt = d.now()
+	counters.UpdatedAt = counters.RefreshedAt
+	d.signal(&tenantID)
+}
+
+// Snapshot returns the current counters of a tenant
+func (d *Dashboard) Snapshot(tenantID uint) DashboardCounters {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pruneUpstream()
+	snapshot := *d.counters(tenantID)
+	snapshot.UpstreamCalls = len(d.upstream)
+	snapshot.UpstreamErrors = 0
+	for _, outcome := range d.upstream {
+		if outcome.failed {
+			snapshot.UpstreamErrors++
+		}
+	}
+	if snapshot.UpstreamCalls > 0 {
+		snapshot.UpstreamErrorRate = math.Round(float64(snapshot.UpstreamErrors)/float64(snapshot.UpstreamCalls)*1000) / 1000
+	}
+	return snapshot
+}
+
+// Subscribe returns a channel signalled whenever the tenant's counters change; signals coalesce
+func (d *Dashboard) Subscribe(tenantID uint) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	d.mu.Lock()
+	d.subscribers[ch] = tenantID
+	d.mu.Unlock()
+	return ch, func() {
+		d.mu.Lock()
+		delete(d.subscribers, ch)
+		d.mu.Unlock()
+	}
+}
+
+// WatchedTenants lists the tenants with at least one subscriber
+func (d *Dashboard) WatchedTenants() []uint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seen := make(map[uint]bool)
+	var tenants []uint
+	for _, tenantID := range d.subscribers {
+		if !seen[tenantID] {
+			seen[tenantID] = true
+			tenants = append(tenants, tenantID)
+		}
+	}
+	return tenants
+}
+
+// counters returns the tenant's counters, creating them on first use; d.mu must be held
+func (d *Dashboard) counters(tenantID uint) *DashboardCounters {
+	counters, ok := d.tenants[tenantID]
+	if !ok {
+		counters = &DashboardCounters{TenantID: tenantID}
+		d.tenants[tenantID] = counters
+	}
+	return counters
+}
+
+// pruneUpstream drops upstream calls older than the window; d.mu must be held
+func (d *Dashboard) pruneUpstream() {
+	cutoff := d.now().Add(-d.window)
+	keep := 0
+	for keep < len(d.upstream) && d.upstream[keep].at.Before(cutoff) {
+		keep++
+	}
+	d.upstream = d.upstream[keep:]
+}
+
+// signal wakes the subscribers of a tenant, or every subscriber when tenantID is nil; d.mu must be held
+func (d *Dashboard) signal(tenantID *uint) {
+	for ch, subscribed := range d.subscribers {
+		if tenantID != nil && subscribed != *tenantID {
+			continue
+		}
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
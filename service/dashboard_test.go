@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDashboardCounters(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	dashboard := NewDashboard(5 * time.Minute)
+	dashboard.now = func() time.Time { return now }
+
+	signals, unsubscribe := dashboard.Subscribe(1)
+	defer unsubscribe()
+
+	dashboard.SetBaseline(1, 12, 3)
+	dashboard.Apply(Event{Type: EventRecordCreated, TenantID: 1, Data: map[string]interface{}{"type": "issue", "status": StatusPending}, Time: now})
+	dashboard.Apply(Event{Type: EventRecordCreated, TenantID: 1, Data: map[string]interface{}{"type": "note", "status": StatusPending}, Time: now})
+	dashboard.Apply(Event{Type: EventRecordStatusChanged, TenantID: 1, Data: map[string]interface{}{"type": "issue", "from": StatusPending, "to": StatusInProgress}, Time: now})
+	// Another tenant's issue does not count
+	dashboard.Apply(Event{Type: EventRecordCreated, TenantID: 2, Data: map[string]interface{}{"type": "issue", "status": StatusPending}, Time: now})
+
+	dashboard.Apply(Event{Type: EventUpstreamCall, Data: map[string]interface{}{"failed": true}, Time: now.Add(-10 * time.Minute)})
+	dashboard.Apply(Event{Type: EventUpstreamCall, Data: map[string]interface{}{"failed": false}, Time: now})
+	dashboard.Apply(Event{Type: EventUpstreamCall, Data: map[string]interface{}{"failed": true}, Time: now})
+
+	select {
+	case <-signals:
+	default:
+		t.Fatal("subscriber was not signalled")
+	}
+
+	snapshot := dashboard.Snapshot(1)
+	if snapshot.OrdersToday != 12 || snapshot.PendingIssues != 3 {
+		t.Errorf("got %d orders and %d pending issues, want 12 and 3", snapshot.OrdersToday, snapshot.PendingIssues)
+	}
+	// The failure older than the window is forgotten
+	if snapshot.UpstreamCalls != 2 || snapshot.UpstreamErrors != 1 || snapshot.UpstreamErrorRate != 0.5 {
+		t.Errorf("unexpected upstream figures %+v", snapshot)
+	}
+	if watched := dashboard.WatchedTenants(); len(watched) != 1 || watched[0] != 1 {
+		t.Errorf("watched tenants %v, want [1]", watched)
+	}
+}
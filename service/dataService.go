@@ -1,11 +1,16 @@
 package service
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"gorm.io/datatypes"
@@ -15,11 +20,36 @@ import (
 // Data represents the structure of the chatbot.interactions table
 type Data struct {
 	ID        uint           `gorm:"primaryKey" json:"id"`
+	TenantID  uint           `gorm:"not null;default:0;index" json:"tenant_id"`
 	UserID    uint           `gorm:"column:user_id" json:"user_id"`
 	Type      string         `json:"type"`
 	Details   datatypes.JSON `json:"details"`
 	Status    string         `json:"status"`
 	CreatedAt time.Time      `json:"created_at"`
+	// UpdatedAt is when the status, the details or the archive state last changed
+	UpdatedAt time.Time `json:"updated_at"`
+	// ArchivedAt hides the record from listings without deleting it
+	ArchivedAt *time.Time     `gorm:"index" json:"archived_at,omitempty"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	// Version numbers the rows of an append-only record; PreviousID links a version to the one it supersedes
+	// and LineageID to the first version
+	Version    int   `gorm:"not null;default:1" json:"version"`
+	PreviousID *uint `gorm:"uniqueIndex" json:"previous_id,omitempty"`
+	LineageID  uint  `gorm:"not null;default:0;index" json:"lineage_id,omitempty"`
+	// SchemaVersion is the version of the record schema the details were validated against; 0 means none
+	SchemaVersion int `gorm:"not null;default:0" json:"schema_version,omitempty"`
+	// PIIHashes holds the lookup hashes of the encrypted detail fields, by path
+	PIIHashes datatypes.JSON `gorm:"column:pii_hashes" json:"-"`
+	// AnonymizedAt is when the retention policy hashed or removed the personal data of the details
+	AnonymizedAt *time.Time `json:"anonymized_at,omitempty"`
+	// Revision counts the detail patches; PATCH /api/v1/records/{id}/details checks it for lost updates
+	Revision int `gorm:"not null;default:1" json:"revision"`
+	// ContentHash identifies the content of the record for the insert dedup
+	ContentHash string `gorm:"size:64;index" json:"-"`
+	// Duplicate is set on the earlier record InsertRecord returns in place of a duplicate
+	Duplicate bool `gorm:"-" json:"duplicate,omitempty"`
+	// Undecryptable is set when the encrypted details could not be opened and are returned sealed
+	Undecryptable bool `gorm:"-" json:"undecryptable,omitempty"`
 }
 
 // TableName specifies the table name for Data
@@ -32,7 +62,30 @@ type Order struct {
 	ID        string    `json:"id"`
 	Customer  Customer  `json:"customer"`
 	Status    string    `json:"status"`
+	Total     float64   `json:"total"`
+	Currency  string    `json:"currency"`
 	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is when Converty last changed the order; it equals CreatedAt when Converty does not say
+	UpdatedAt time.Time   `json:"updated_at"`
+	Items     []OrderLine `json:"items,omitempty"`
+	// DeliveryCompany is the carrier Converty assigned the order to
+	DeliveryCompany string `json:"delivery_company,omitempty"`
+	TrackingNumber  string `json:"tracking_number,omitempty"`
+	// StatusLabel is the status in the customer's language, filled by the order endpoints
+	StatusLabel string `json:"status_label,omitempty"`
+	// Converted amounts are filled when a reporting currency is requested
+	ConvertedTotal    *float64 `json:"converted_total,omitempty"`
+	ConvertedCurrency string   `json:"converted_currency,omitempty"`
+	ExchangeRate      float64  `json:"exchange_rate,omitempty"`
+}
+
+// OrderLine is one product line of an order
+type OrderLine struct {
+	ProductID string  `json:"product_id"`
+	Name      string  `json:"name"`
+	Category  string  `json:"category,omitempty"`
+	Quantity  int     `json:"quantity"`
+	Price     float64 `json:"price"`
 }
 
 // Customer represents the customer details in an order
@@ -56,122 +109,543 @@ type CustomerOrderQuery struct {
 	Search          string
 	Product         string
 	DeliveryCompany string
+	// Category keeps the orders with a line in the category; only the order mirror applies it, as the
+	// Converty API cannot filter by category
+	Category *Category
+}
+
+// RecordFilter narrows a record listing; zero values are ignored
+type RecordFilter struct {
+	Type   string
+	Status string
+	UserID *uint
+	From   *time.Time
+	To     *time.Time
+	// Text searches the Details JSON: "key=value" matches one field, anything else matches any value
+	Text string
+	// IncludeArchived also returns archived records
+	IncludeArchived bool
+	// Category matches the "category" field of the Details JSON
+	Category string
+	// Tags keeps the records carrying every one of these tags
+	Tags []string
 }
 
-// DataService defines the interface for data operations
+// DataService defines the interface for data operations.
+// Errors wrap ErrNotFound, ErrValidation, ErrTokenExpired or ErrUpstreamUnavailable when the cause is one of those.
 type DataService interface {
+	ForTenant(tenant Tenant) DataService
+	WithPriority(priority UpstreamPriority) DataService
+	WithContext(ctx context.Context) DataService
+	RecordRepository
+	ConvertyRepository
+}
+
+// RecordRepository stores the chatbot interactions; GormDataService keeps them in PostgreSQL and
+// MongoDataService in MongoDB
+type RecordRepository interface {
 	ListRecords() ([]Data, error)
+	SearchRecords(filter RecordFilter) ([]Data, error)
+	PageRecords(filter RecordFilter, offset, limit int) ([]Data, int64, error)
+	FullTextSearch(text string, filter RecordFilter, offset, limit int) ([]RecordMatch, int64, error)
 	QueryByID(id uint) (Data, error)
+	QueryByIDs(ids []uint) ([]Data, error)
+	RecordsLastModified() (time.Time, error)
 	InsertRecord(userID uint, dataType string, details map[string]interface{}, status string) (Data, error)
+	UpdateRecordStatus(id uint, newStatus, actor string) (Data, error)
+	BulkUpdateStatus(ids []uint, recordType, newStatus, actor string) ([]BulkStatusResult, error)
+	PatchRecordDetails(id uint, patch []byte, revision int) (Data, error)
+	RecordHistory(id uint) ([]StatusChange, error)
+	RecordVersions(id uint) ([]Data, error)
+	ArchiveRecord(id uint) (Data, error)
+	RestoreRecord(id uint) (Data, error)
+	DeleteRecord(id uint) error
+	PurgeRecords(olderThan time.Time, skipUserIDs []uint) ([]uint, error)
 	ListIssues() ([]Data, error)
+}
+
+// ConvertyRepository reaches the orders, products and coupons of the tenant's Converty store
+type ConvertyRepository interface {
 	ListOrders(query CustomerOrderQuery) ([]Order, error)
+	GetOrder(id string) (Order, error)
+	GetOrdersByIDs(ids []string) ([]Order, error)
+	CreateOrder(order NewOrder) (Order, error)
+	UpdateOrderStatus(id, status string) (Order, error)
+	PreviewOrder(order NewOrder) (UpstreamRequest, error)
+	FetchCategories() ([]Category, error)
+	ListProducts(page, limit int) ([]Product, error)
+	GetProduct(id string) (Product, error)
+	GetCoupon(code string) (Coupon, error)
+	CheckCoupon(code string, orderTotal float64) (CouponCheck, error)
+}
+
+// DataServiceOptions holds tunables for GormDataService
+type DataServiceOptions struct {
+	// OrderCacheTTL is how long identical order-list queries are served from memory; 0 disables the cache
+	OrderCacheTTL time.Duration
+	// Classifier fills details fields of inserted records; nil stores them as sent
+	Classifier RecordClassifier
+	// Validator checks inserted details against the active schema of their type; nil accepts any details
+	Validator RecordValidator
+	// Converty is the upstream API version to talk to; nil means v1 on DefaultConvertyURL
+	Converty ConvertyClient
+	// Dedup rejects or merges inserted records repeating a recent one; the zero value disables it
+	Dedup RecordDedupSettings
+	// Limits bound the details of inserted and patched records; the zero value accepts any size
+	Limits DetailsLimits
 }
 
 // GormDataService implements DataService using GORM
 type GormDataService struct {
-	db *gorm.DB
+	db         *gorm.DB
+	orderCache *ttlCache
+	tokenCache *TokenCache
+	converty   ConvertyClient
+	recordIntake
+	// tenant scopes records and the Converty token; nil means unscoped (background jobs)
+	tenant *Tenant
+	// priority ranks the Converty calls in the shared limiter; jobs default to background
+	priority UpstreamPriority
+	// ctx carries the trace of the caller into statements and Converty calls; nil for background jobs
+	ctx context.Context
 }
 
 // NewGormDataService creates a new GormDataService
-func NewGormDataService(db *gorm.DB) DataService {
-	return &GormDataService{db: db}
+func NewGormDataService(db *gorm.DB, opts DataServiceOptions) DataService {
+	orderCache := newTTLCache(opts.OrderCacheTTL)
+	Caches.Register(CacheOrders, orderCache)
+	converty := opts.Converty
+	if converty == nil {
+		converty = convertyV1{baseURL: DefaultConvertyURL}
+	}
+	return &GormDataService{
+		db:           db,
+		orderCache:   orderCache,
+		tokenCache:   NewTokenCache(DefaultTokenCacheTTL),
+		converty:     converty,
+		recordIntake: newRecordIntake(opts),
+	}
+}
+
+// ForTenant returns a DataService restricted to the tenant's records and Converty store
+func (s *GormDataService) ForTenant(tenant Tenant) DataService {
+	scoped := *s
+	scoped.tenant = &tenant
+	return &scoped
+}
+
+// WithPriority returns a DataService whose Converty calls are ranked with priority
+func (s *GormDataService) WithPriority(priority UpstreamPriority) DataService {
+	ranked := *s
+	ranked.priority = priority
+	return &ranked
+}
+
+// WithContext returns a DataService whose database statements and Converty calls belong to the trace
+// of ctx. Only the values of ctx are kept: a cancelled request does not abort work in flight.
+func (s *GormDataService) WithContext(ctx context.Context) DataService {
+	traced := *s
+	traced.ctx = context.WithoutCancel(ctx)
+	traced.db = s.db.WithContext(traced.ctx)
+	return &traced
+}
+
+// tenantScope restricts a chatbot.interactions query to the service tenant
+func (s *GormDataService) tenantScope(db *gorm.DB) *gorm.DB {
+	if s.tenant == nil {
+		return db
+	}
+	return db.Where("tenant_id = ?", s.tenant.ID)
+}
+
+// tenantID returns the ID of the service tenant, the default one when unscoped
+func (s *GormDataService) tenantID() uint {
+	if s.tenant == nil {
+		return DefaultTenant.ID
+	}
+	return s.tenant.ID
+}
+
+// tokenUserID returns the public.token_infos user holding the Converty authorization
+func (s *GormDataService) tokenUserID() string {
+	if s.tenant == nil {
+		return DefaultTenant.TokenUserID
+	}
+	return s.tenant.TokenUserID
 }
 
-// ListRecords fetches all records from chatbot.interactions
+// ListRecords fetches all non-archived records from chatbot.interactions
 func (s *GormDataService) ListRecords() ([]Data, error) {
 	var records []Data
-	result := s.db.Find(&records)
+	result := s.db.Scopes(s.tenantScope, currentVersions).Where("archived_at IS NULL").Find(&records)
 	if result.Error != nil {
 		return nil, fmt.Errorf("failed to fetch records: %v", result.Error)
 	}
 	return records, nil
 }
 
+// recordQuery applies the filter to a chatbot.interactions query, using JSONB operators for the Details search
+func (s *GormDataService) recordQuery(filter RecordFilter) *gorm.DB {
+	query := s.db.Model(&Data{}).Scopes(s.tenantScope, currentVersions)
+	if !filter.IncludeArchived {
+		query = query.Where("archived_at IS NULL")
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.UserID != nil {
+		query = query.Where("user_id = ?", *filter.UserID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if filter.Category != "" {
+		query = query.Where("details ->> 'category' ILIKE ?", filter.Category)
+	}
+	if len(filter.Tags) > 0 {
+		query = query.Scopes(tagged(filter.Tags))
+	}
+	if filter.Text != "" {
+		if key, value, ok := cutFilterText(filter.Text); ok {
+			if PII.Protects(key) {
+				// Encrypted values only match whole, through their lookup hash
+				query = piiLookup(query, key, value)
+			} else {
+				query = query.Where("details ->> ? ILIKE ?", key, "%"+value+"%")
+			}
+		} else {
+			query = query.Where("EXISTS (SELECT 1 FROM jsonb_each_text(details) AS kv WHERE kv.value ILIKE ?)", "%"+filter.Text+"%")
+		}
+	}
+	return query
+}
+
+// cutFilterText splits a "key=value" search text into its trimmed key and value
+func cutFilterText(text string) (string, string, bool) {
+	key, value, ok := strings.Cut(text, "=")
+	if !ok || key == "" {
+		return "", "", false
+	}
+	return strings.TrimSpace(key), strings.TrimSpace(value), true
+}
+
+// SearchRecords fetches records matching the filter, newest first
+func (s *GormDataService) SearchRecords(filter RecordFilter) ([]Data, error) {
+	var records []Data
+	if err := s.recordQuery(filter).Order("created_at desc").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to search records: %v", err)
+	}
+	return records, nil
+}
+
+// PageRecords fetches one page of the records matching the filter, ordered by ID, and the total match count
+func (s *GormDataService) PageRecords(filter RecordFilter, offset, limit int) ([]Data, int64, error) {
+	var total int64
+	if err := s.recordQuery(filter).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count records: %v", err)
+	}
+	var records []Data
+	if err := s.recordQuery(filter).Order("id").Offset(offset).Limit(limit).Find(&records).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch records: %v", err)
+	}
+	return records, total, nil
+}
+
 // QueryByID fetches a record by ID
 func (s *GormDataService) QueryByID(id uint) (Data, error) {
 	var record Data
-	result := s.db.First(&record, id)
+	result := s.db.Scopes(s.tenantScope).First(&record, id)
 	if result.Error != nil {
-		return Data{}, fmt.Errorf("record with ID %d not found: %v", id, result.Error)
+		return Data{}, recordLookupError(id, result.Error)
 	}
 	return record, nil
 }
 
+// MaxBatchLookupIDs bounds the IDs of one batch lookup of records or orders
+const MaxBatchLookupIDs = 100
+
+// QueryByIDs fetches several records in a single query, ordered by ID
+func (s *GormDataService) QueryByIDs(ids []uint) ([]Data, error) {
+	var records []Data
+	result := s.db.Scopes(s.tenantScope).Where("id IN ?", ids).Order("id").Find(&records)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to fetch records: %v", result.Error)
+	}
+	return records, nil
+}
+
 // InsertRecord inserts a new record
 func (s *GormDataService) InsertRecord(userID uint, dataType string, details map[string]interface{}, status string) (Data, error) {
-	detailsJSON, err := json.Marshal(details)
-	if err != nil {
-		return Data{}, fmt.Errorf("failed to marshal details: %v", err)
+	var tenantID uint
+	if s.tenant != nil {
+		tenantID = s.tenant.ID
 	}
-
-	record := Data{
-		UserID:    userID,
-		Type:      dataType,
-		Details:   detailsJSON,
-		Status:    status,
-		CreatedAt: time.Now(),
+	record, err := s.newRecord(tenantID, userID, dataType, details, status)
+	if err != nil {
+		return Data{}, err
+	}
+
+	var created Event
+	var duplicate *Data
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if record.ContentHash != "" {
+			existing, found, err := findDuplicate(tx, tenantID, record.ContentHash, record.CreatedAt.Add(-s.dedup.Window))
+			if err != nil {
+				return err
+			}
+			if found {
+				duplicate = &existing
+				return nil
+			}
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return fmt.Errorf("failed to insert record: %v", err)
+		}
+		created = recordCreatedEvent(record)
+		return WriteOutbox(tx, created)
+	})
+	if err != nil {
+		return Data{}, err
 	}
-
-	result := s.db.Create(&record)
-	if result.Error != nil {
-		return Data{}, fmt.Errorf("failed to insert record: %v", result.Error)
+	if duplicate != nil {
+		return s.duplicateOf(*duplicate)
 	}
+	Events.Publish(created)
 	return record, nil
 }
 
-// ListIssues fetches records with type=issue from chatbot.interactions
+// ListIssues fetches non-archived records with type=issue from chatbot.interactions
 func (s *GormDataService) ListIssues() ([]Data, error) {
 	var issues []Data
-	result := s.db.Where("type = ?", "issue").Find(&issues)
+	result := s.db.Scopes(s.tenantScope, currentVersions).Where("type = ? AND archived_at IS NULL", "issue").Find(&issues)
 	if result.Error != nil {
 		return nil, fmt.Errorf("failed to fetch issues: %v", result.Error)
 	}
 	return issues, nil
 }
 
-// ListOrders fetches orders from Converty.shop API with query parameters
-func (s *GormDataService) ListOrders(query CustomerOrderQuery) ([]Order, error) {
-	// Fetch token
-	var tokenInfo struct {
-		AccessToken  string    `gorm:"column:access_token"`
-		RefreshToken string    `gorm:"column:refresh_token"`
-		ExpiresAt    time.Time `gorm:"column:expires_at"`
-		StoreID      string    `gorm:"column:store_id"`
-	}
-	result := s.db.Table("public.token_infos").Where("user_id = ?", "user1").First(&tokenInfo)
-	if result.Error != nil {
-		return nil, fmt.Errorf("no token found, please authenticate via /login: %v", result.Error)
+// convertyToken is the subset of public.token_infos needed for upstream calls
+type convertyToken struct {
+	AccessToken  string    `gorm:"column:access_token"`
+	RefreshToken string    `gorm:"column:refresh_token"`
+	ExpiresAt    time.Time `gorm:"column:expires_at"`
+	StoreID      string    `gorm:"column:store_id"`
+}
+
+// orderItem is the upstream JSON shape of an order
+type orderItem struct {
+	ID        string      `json:"id"`
+	Customer  Customer    `json:"customer"`
+	Status    string      `json:"status"`
+	Total     float64     `json:"total"`
+	Currency  string      `json:"currency"`
+	CreatedAt string      `json:"created_at"`
+	UpdatedAt string      `json:"updated_at"`
+	Items     []OrderLine `json:"items"`
+
+	DeliveryCompany string `json:"deliveryCompany"`
+	TrackingNumber  string `json:"trackingNumber"`
+}
+
+// toOrder converts an upstream order into an Order
+func (item orderItem) toOrder() Order {
+	createdAt, err := time.Parse(time.RFC3339, item.CreatedAt)
+	if err != nil {
+		createdAt = time.Now() // Fallback
 	}
+	updatedAt, err := time.Parse(time.RFC3339, item.UpdatedAt)
+	if err != nil {
+		updatedAt = createdAt
+	}
+	return Order{
+		ID:        item.ID,
+		Customer:  item.Customer,
+		Status:    item.Status,
+		Total:     item.Total,
+		Currency:  strings.ToUpper(item.Currency),
+		CreatedAt: createdAt,
+		UpdatedAt: updatedAt,
+		Items:     item.Items,
 
-	// Check if token is expired
-	if time.Now().After(tokenInfo.ExpiresAt) {
-		newToken, err := refreshAccessToken(tokenInfo.RefreshToken)
+		DeliveryCompany: item.DeliveryCompany,
+		TrackingNumber:  item.TrackingNumber,
+	}
+}
+
+// TokenRefreshSkew is how long before its expiry an access token is refreshed ahead of a Converty
+// call, so it cannot expire while the call is in flight
+var TokenRefreshSkew = time.Minute
+
+// loadToken fetches the stored token through the token cache, refreshing it if it expires within
+// TokenRefreshSkew
+func (s *GormDataService) loadToken() (convertyToken, error) {
+	tokenInfo, err := s.storedToken()
+	if err != nil {
+		return convertyToken{}, err
+	}
+
+	if TokenExpiresWithin(tokenInfo.ExpiresAt, UpstreamNow(), TokenRefreshSkew) {
+		newToken, err := s.refreshToken(tokenInfo)
 		if err != nil {
-			return nil, fmt.Errorf("access token expired, refresh failed: %v", err)
+			return convertyToken{}, fmt.Errorf("%w: refresh failed: %v", ErrTokenExpired, err)
 		}
 		tokenInfo.AccessToken = newToken
-		// Update token in database (simplified; adjust based on your schema)
-		result = s.db.Table("public.token_infos").Where("user_id = ?", "user1").Update("access_token", newToken)
-		if result.Error != nil {
-			return nil, fmt.Errorf("failed to update access token: %v", result.Error)
+	}
+	return tokenInfo, nil
+}
+
+// storedToken reads the token of the token user from the cache, or from public.token_infos
+func (s *GormDataService) storedToken() (convertyToken, error) {
+	userID := s.tokenUserID()
+	if cached, ok := s.tokenCache.Get(userID); ok {
+		return cached.(convertyToken), nil
+	}
+	var tokenInfo convertyToken
+	result := s.db.Table("public.token_infos").Where("user_id = ?", userID).First(&tokenInfo)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return convertyToken{}, fmt.Errorf("no token found: %w", ErrTokenExpired)
+	}
+	if result.Error != nil {
+		return convertyToken{}, fmt.Errorf("failed to load token: %v", result.Error)
+	}
+	s.tokenCache.Set(userID, tokenInfo)
+	return tokenInfo, nil
+}
+
+// RefreshToken replaces the stored access token of userID unless it no longer is staleAccessToken,
+// persisting the whole token response including a rotated refresh token, and returns the current
+// access token. main sets it to the refresh shared with /GetAccessToken, so concurrent callers,
+// whatever asks, share one grant per user.
+var RefreshToken func(userID, staleAccessToken string) (string, error)
+
+// refreshToken replaces the access token of stale, the stored token as the caller read it
+func (s *GormDataService) refreshToken(stale convertyToken) (string, error) {
+	if RefreshToken == nil {
+		return "", errors.New("token refresh is not configured")
+	}
+	return RefreshToken(s.tokenUserID(), stale.AccessToken)
+}
+
+// storeID returns the Converty store the token belongs to
+func (t convertyToken) storeID() string {
+	if t.StoreID != "" {
+		return t.StoreID
+	}
+	return "651157ac4a069ab1e26081a9" // Fallback
+}
+
+// doConvertyRequest sends an authorized request, retrying once after a token refresh on 401,
+// and returns the body of a successful response
+func (s *GormDataService) doConvertyRequest(req *http.Request, tokenInfo convertyToken) ([]byte, error) {
+	client := NewUpstreamClient(0)
+	ctx := req.Context()
+	if s.ctx != nil {
+		ctx = s.ctx
+	}
+	req = req.WithContext(WithUpstreamPriority(ctx, s.priority))
+	req.Header.Set("Authorization", "Bearer "+tokenInfo.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := DoUpstream(client, req)
+	if err != nil {
+		// Serve the last good response while the upstream is down
+		if cached, ok := CachedResponse(s.tenantID(), req.URL.String()); ok && req.Method == http.MethodGet {
+			log.Printf("Converty unavailable (%v), serving cached response for %s", err, req.URL.Path)
+			return cached, nil
+		}
+		if errors.Is(err, ErrUpstreamUnavailable) || errors.Is(err, ErrUpstreamBusy) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUpstreamUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Attempt token refresh
+		newToken, err := s.refreshToken(tokenInfo)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 401 unauthorized, refresh failed: %v", ErrTokenExpired, err)
+		}
+		// Retry request, replaying the body of writes
+		req.Header.Set("Authorization", "Bearer "+newToken)
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, fmt.Errorf("failed to replay request body: %v", err)
+			}
+		}
+		resp, err = DoUpstream(client, req)
+		if err != nil {
+			if errors.Is(err, ErrUpstreamUnavailable) || errors.Is(err, ErrUpstreamBusy) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: after refresh: %v", ErrUpstreamUnavailable, err)
 		}
+		defer resp.Body.Close()
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if scopes, ok := InsufficientScope(resp, body); ok {
+		if err := RecordMissingScopes(s.db, s.tokenUserID(), scopes); err != nil {
+			log.Printf("Failed to record missing scopes %v: %v", scopes, err)
+		}
+		return nil, ScopeError(scopes)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, UpstreamStatusError(resp.StatusCode, body)
+	}
+	if req.Method == http.MethodGet {
+		RememberResponse(s.tenantID(), req.URL.String(), body)
+	}
+	return body, nil
+}
+
+// ListOrders fetches orders from Converty.shop API with query parameters
+func (s *GormDataService) ListOrders(query CustomerOrderQuery) ([]Order, error) {
+	tokenInfo, err := s.loadToken()
+	if err != nil {
+		return nil, err
+	}
+
+	q := orderQueryValues(query, tokenInfo.storeID())
+
+	// Identical queries within the TTL are served from memory
+	cacheKey := CacheOrders + ":" + s.tokenUserID() + ":" + q.Encode()
+	if cached, ok := s.orderCache.Get(cacheKey); ok {
+		return append([]Order(nil), cached.([]Order)...), nil
 	}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", "https://api.converty.shop/api/v1/orders", nil)
+	req, err := http.NewRequest("GET", s.converty.URL("orders"), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+tokenInfo.AccessToken)
-	req.Header.Set("Content-Type", "application/json")
+	req.URL.RawQuery = q.Encode()
 
-	// Build query parameters
-	q := url.Values{}
-	if tokenInfo.StoreID != "" {
-		q.Add("store_id", tokenInfo.StoreID) // Use store_id from token
-	} else {
-		q.Add("store_id", "651157ac4a069ab1e26081a9") // Fallback
+	body, err := s.doConvertyRequest(req, tokenInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch orders: %w", err)
+	}
+	orders, err := s.converty.DecodeOrders(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch orders: %v", err)
 	}
+
+	s.orderCache.Set(cacheKey, append([]Order(nil), orders...))
+	return orders, nil
+}
+
+// orderQueryValues builds the upstream query string for an order listing
+func orderQueryValues(query CustomerOrderQuery, storeID string) url.Values {
+	q := url.Values{}
+	q.Add("store_id", storeID)
 	q.Add("page", fmt.Sprintf("%d", query.Page))
 	q.Add("limit", fmt.Sprintf("%d", query.Limit))
 	if query.Status != "" {
@@ -195,117 +669,164 @@ func (s *GormDataService) ListOrders(query CustomerOrderQuery) ([]Order, error)
 	if query.DeliveryCompany != "" {
 		q.Add("deliveryCompany", query.DeliveryCompany)
 	}
-	req.URL.RawQuery = q.Encode()
+	return q
+}
 
-	resp, err := client.Do(req)
+// GetOrder fetches a single order from Converty.shop API
+func (s *GormDataService) GetOrder(id string) (Order, error) {
+	tokenInfo, err := s.loadToken()
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch orders: %v", err)
+		return Order{}, err
 	}
-	defer resp.Body.Close()
+	return s.getOrder(id, tokenInfo)
+}
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		// Attempt token refresh
-		newToken, err := refreshAccessToken(tokenInfo.RefreshToken)
-		if err != nil {
-			return nil, fmt.Errorf("401 unauthorized, refresh failed: %v", err)
-		}
-		// Update token
-		result = s.db.Table("public.token_infos").Where("user_id = ?", "user1").Update("access_token", newToken)
-		if result.Error != nil {
-			return nil, fmt.Errorf("failed to update access token: %v", result.Error)
-		}
-		// Retry request
-		req.Header.Set("Authorization", "Bearer "+newToken)
-		resp, err = client.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch orders after refresh: %v", err)
-		}
-		defer resp.Body.Close()
+func (s *GormDataService) getOrder(id string, tokenInfo convertyToken) (Order, error) {
+	req, err := http.NewRequest("GET", s.converty.URL("orders/"+url.PathEscape(id)), nil)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to create request: %v", err)
 	}
+	q := url.Values{}
+	q.Add("store_id", tokenInfo.storeID())
+	req.URL.RawQuery = q.Encode()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	body, err := s.doConvertyRequest(req, tokenInfo)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to fetch order %s: %w", id, err)
 	}
-
-	// Parse response
-	var apiResponse struct {
-		Success bool   `json:"success"`
-		Message string `json:"message"`
-		Data    []struct {
-			ID        string   `json:"id"`
-			Customer  Customer `json:"customer"`
-			Status    string   `json:"status"`
-			CreatedAt string   `json:"created_at"`
-		} `json:"data"`
+	order, err := s.converty.DecodeOrder(body)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to fetch order %s: %v", id, err)
 	}
-	body, err := io.ReadAll(resp.Body)
+	return order, nil
+}
+
+// NewOrder is an order submitted to Converty, e.g. by the chatbot
+type NewOrder struct {
+	Customer Customer    `json:"customer"`
+	Items    []OrderLine `json:"items"`
+	Note     string      `json:"note,omitempty"`
+}
+
+// CreateOrder submits an order to Converty.shop API
+func (s *GormDataService) CreateOrder(order NewOrder) (Order, error) {
+	tokenInfo, err := s.loadToken()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return Order{}, err
 	}
-	if err := json.Unmarshal(body, &apiResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %v", err)
+	req, _, err := s.newOrderRequest(order, tokenInfo)
+	if err != nil {
+		return Order{}, err
 	}
 
-	if !apiResponse.Success {
-		return nil, fmt.Errorf("failed to fetch orders: %s", apiResponse.Message)
+	body, err := s.doConvertyRequest(req, tokenInfo)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to create order: %w", err)
 	}
-
-	// Convert to Order slice
-	orders := make([]Order, 0, len(apiResponse.Data))
-	for _, item := range apiResponse.Data {
-		createdAt, err := time.Parse(time.RFC3339, item.CreatedAt)
-		if err != nil {
-			createdAt = time.Now() // Fallback
-		}
-		orders = append(orders, Order{
-			ID:        item.ID,
-			Customer:  item.Customer,
-			Status:    item.Status,
-			CreatedAt: createdAt,
-		})
+	created, err := s.converty.DecodeOrder(body)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to create order: %v", err)
 	}
-
-	return orders, nil
+	// Listings and stock levels cached before the order was placed no longer hold
+	Caches.OrdersChanged(s.tokenUserID(), tokenInfo.storeID())
+	return created, nil
 }
 
-// refreshAccessToken calls the /GetAccessToken endpoint to refresh the token
-func refreshAccessToken(refreshToken string) (string, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", "http://localhost:8080/GetAccessToken", nil)
+// UpdateOrderStatus moves an order to a new status through Converty.shop API
+func (s *GormDataService) UpdateOrderStatus(id, status string) (Order, error) {
+	tokenInfo, err := s.loadToken()
 	if err != nil {
-		return "", fmt.Errorf("failed to create refresh request: %v", err)
+		return Order{}, err
+	}
+	payload, err := s.converty.EncodeStatus(status)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to marshal status: %v", err)
+	}
+	req, err := http.NewRequest("PUT", s.converty.URL("orders/"+url.PathEscape(id)), bytes.NewReader(payload))
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to create request: %v", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	// Pass refresh token (adjust based on your endpoint's requirements)
 	q := url.Values{}
-	q.Add("refresh_token", refreshToken)
+	q.Add("store_id", tokenInfo.storeID())
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := client.Do(req)
+	body, err := s.doConvertyRequest(req, tokenInfo)
 	if err != nil {
-		return "", fmt.Errorf("failed to refresh token: %v", err)
+		return Order{}, fmt.Errorf("failed to update order %s: %w", id, err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("refresh token request failed with status %d: %s", resp.StatusCode, string(body))
+	updated, err := s.converty.DecodeOrder(body)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to update order %s: %v", id, err)
 	}
+	Caches.OrdersChanged(s.tokenUserID(), tokenInfo.storeID())
+	return updated, nil
+}
 
-	var tokenResponse struct {
-		AccessToken string `json:"access_token"`
+// PreviewOrder returns the request CreateOrder would send, without sending it
+func (s *GormDataService) PreviewOrder(order NewOrder) (UpstreamRequest, error) {
+	tokenInfo, err := s.loadToken()
+	if err != nil {
+		return UpstreamRequest{}, err
 	}
-	body, err := io.ReadAll(resp.Body)
+	req, payload, err := s.newOrderRequest(order, tokenInfo)
 	if err != nil {
-		return "", fmt.Errorf("failed to read refresh response: %v", err)
+		return UpstreamRequest{}, err
 	}
-	if err := json.Unmarshal(body, &tokenResponse); err != nil {
-		return "", fmt.Errorf("failed to parse refresh response: %v", err)
+	// The headers doConvertyRequest adds; the bearer token is redacted anyway
+	req.Header.Set("Authorization", "Bearer "+tokenInfo.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	return DescribeRequest(req, payload), nil
+}
+
+// newOrderRequest prepares the Converty order creation call and returns its body
+func (s *GormDataService) newOrderRequest(order NewOrder, tokenInfo convertyToken) (*http.Request, []byte, error) {
+	payload, err := s.converty.EncodeOrder(order)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to marshal order: %v", ErrValidation, err)
+	}
+	req, err := http.NewRequest("POST", s.converty.URL("orders"), bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %v", err)
 	}
+	q := url.Values{}
+	q.Add("store_id", tokenInfo.storeID())
+	req.URL.RawQuery = q.Encode()
+	return req, payload, nil
+}
 
-	if tokenResponse.AccessToken == "" {
-		return "", fmt.Errorf("no access token in refresh response")
+// GetOrdersByIDs fetches several orders concurrently, skipping the ones that could not be resolved
+func (s *GormDataService) GetOrdersByIDs(ids []string) ([]Order, error) {
+	tokenInfo, err := s.loadToken()
+	if err != nil {
+		return nil, err
+	}
+
+	const maxConcurrent = 5
+	orders := make([]*Order, len(ids))
+	sem := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			order, err := s.getOrder(id, tokenInfo)
+			if err != nil {
+				log.Printf("Batch order lookup: %v", err)
+				return
+			}
+			orders[i] = &order
+		}(i, id)
+	}
+	wg.Wait()
+
+	// Preserve the requested order
+	result := make([]Order, 0, len(ids))
+	for _, order := range orders {
+		if order != nil {
+			result = append(result, *order)
+		}
 	}
-	return tokenResponse.AccessToken, nil
+	return result, nil
 }
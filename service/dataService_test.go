@@ -0,0 +1,89 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// dryRunDB builds statements without a database, for asserting on the generated SQL
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost dbname=test"}), &gorm.Config{
+		DryRun: true, DisableAutomaticPing: true, Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCutFilterText(t *testing.T) {
+	for _, c := range []struct {
+		text, key, value string
+		ok               bool
+	}{
+		{"city=Sfax", "city", "Sfax", true},
+		{" city = Sfax (centre) ", "city", "Sfax (centre)", true},
+		{"note=a=b", "note", "a=b", true},
+		{"city=", "city", "", true},
+		{"=Sfax", "", "", false},
+		{"blender", "", "", false},
+	} {
+		key, value, ok := cutFilterText(c.text)
+		if key != c.key || value != c.value || ok != c.ok {
+			t.Errorf("cutFilterText(%q) = %q, %q, %v; want %q, %q, %v", c.text, key, value, ok, c.key, c.value, c.ok)
+		}
+	}
+}
+
+func TestRecordQueryFilters(t *testing.T) {
+	service := &GormDataService{db: dryRunDB(t)}
+	user := uint(4)
+	day := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		name    string
+		filter  RecordFilter
+		want    []string
+		notWant []string
+		pattern string
+	}{
+		{"no filter", RecordFilter{}, []string{"archived_at IS NULL"}, []string{"type =", "details"}, ""},
+		{"archived included", RecordFilter{IncludeArchived: true}, nil, []string{"archived_at"}, ""},
+		{"fields", RecordFilter{Type: "issue", Status: "pending", UserID: &user}, []string{"type = $", "status = $", "user_id = $"}, nil, ""},
+		{"date range", RecordFilter{From: &day, To: &day}, []string{"created_at >= $", "created_at < $"}, nil, ""},
+		{"key search", RecordFilter{Text: "city=Sfax"}, []string{"details ->> $", "ILIKE"}, []string{"jsonb_each_text"}, "%Sfax%"},
+		{"value search", RecordFilter{Text: "blender"}, []string{"jsonb_each_text(details)"}, []string{"details ->> $"}, "%blender%"},
+	} {
+		var records []Data
+		stmt := service.recordQuery(c.filter).Find(&records).Statement
+		sql := stmt.SQL.String()
+		for _, want := range c.want {
+			if !strings.Contains(sql, want) {
+				t.Errorf("%s: %q missing from %s", c.name, want, sql)
+			}
+		}
+		for _, notWant := range c.notWant {
+			if strings.Contains(sql, notWant) {
+				t.Errorf("%s: unexpected %q in %s", c.name, notWant, sql)
+			}
+		}
+		if c.pattern != "" && !containsVar(stmt.Vars, c.pattern) {
+			t.Errorf("%s: pattern %q not bound in %v", c.name, c.pattern, stmt.Vars)
+		}
+	}
+}
+
+// containsVar reports whether a statement binds value
+func containsVar(vars []interface{}, value string) bool {
+	for _, v := range vars {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// DetailsLimits bound the free-form details of a record, so a misbehaving client cannot store
+// megabytes of JSON in one interaction; a zero limit is not enforced
+type DetailsLimits struct {
+	// MaxBytes bounds the encoded details
+	MaxBytes int
+	// MaxDepth bounds the nesting of objects and arrays; the details object itself is depth 1
+	MaxDepth int
+	// MaxFields bounds the object fields and array items counted over the whole document
+	MaxFields int
+}
+
+// DefaultDetailsLimits are the limits in force unless DETAILS_MAX_BYTES, DETAILS_MAX_DEPTH or
+// DETAILS_MAX_FIELDS say otherwise
+var DefaultDetailsLimits = DetailsLimits{MaxBytes: 64 << 10, MaxDepth: 8, MaxFields: 500}
+
+// Check returns ErrValidation when the encoded details exceed a limit. The document is walked
+// token by token, so a deeply nested one is refused without being decoded.
+func (l DetailsLimits) Check(details []byte) error {
+	if l.MaxBytes > 0 && len(details) > l.MaxBytes {
+		return fmt.Errorf("%w: details are %d bytes, at most %d are accepted", ErrValidation, len(details), l.MaxBytes)
+	}
+	if l.MaxDepth <= 0 && l.MaxFields <= 0 {
+		return nil
+	}
+	type container struct{ object, wantKey bool }
+	var open []container
+	fields := 0
+	decoder := json.NewDecoder(bytes.NewReader(details))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF && len(open) == 0 {
+			return nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("%w: details are not valid JSON: %v", ErrValidation, err)
+		}
+		closing := token == json.Delim('}') || token == json.Delim(']')
+		if n := len(open); n > 0 && !closing {
+			// A key of an object or an item of an array is one field; an object value is not
+			top := &open[n-1]
+			switch {
+			case top.object && top.wantKey:
+				fields++
+				top.wantKey = false
+			case top.object:
+				top.wantKey = true
+			default:
+				fields++
+			}
+			if l.MaxFields > 0 && fields > l.MaxFields {
+				return fmt.Errorf("%w: details have more than %d fields", ErrValidation, l.MaxFields)
+			}
+		}
+		switch {
+		case token == json.Delim('{') || token == json.Delim('['):
+			open = append(open, container{object: token == json.Delim('{'), wantKey: true})
+			if l.MaxDepth > 0 && len(open) > l.MaxDepth {
+				return fmt.Errorf("%w: details are nested deeper than %d levels", ErrValidation, l.MaxDepth)
+			}
+		case closing:
+			open = open[:len(open)-1]
+		}
+	}
+}
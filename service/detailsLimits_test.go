@@ -0,0 +1,48 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDetailsLimitsCheck(t *testing.T) {
+	limits := DetailsLimits{MaxBytes: 200, MaxDepth: 3, MaxFields: 6}
+	for _, c := range []struct {
+		details string
+		want    string
+	}{
+		{`{"note": "late", "address": {"city": "Sfax", "lines": ["a", "b"]}}`, ""},
+		{`{"a": {"b": {"c": 1}}}`, ""},
+		{`{"a": {"b": {"c": {"d": 1}}}}`, "nested deeper than 3"},
+		{`{"a": [[[1]]]}`, "nested deeper than 3"},
+		{`{"a": 1, "b": 2, "c": 3, "d": [4, 5, 6]}`, "more than 6 fields"},
+		{`{"note": "` + strings.Repeat("x", 200) + `"}`, "at most 200"},
+		{`{"note": `, "not valid JSON"},
+	} {
+		err := limits.Check([]byte(c.details))
+		if c.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", c.details, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want a validation error containing %q", c.details, err, c.want)
+		}
+	}
+	if err := (DetailsLimits{}).Check([]byte(`{"a": [[[[[[1]]]]]]}`)); err != nil {
+		t.Errorf("zero limits should accept anything, got %v", err)
+	}
+}
+
+func TestNewRecordHoldsDetailsToLimits(t *testing.T) {
+	intake := newRecordIntake(DataServiceOptions{Limits: DetailsLimits{MaxDepth: 2}})
+	if _, err := intake.newRecord(1, 1, "issue", map[string]interface{}{"a": map[string]interface{}{"b": 1}}, ""); err != nil {
+		t.Fatalf("details within the limits rejected: %v", err)
+	}
+	deep := map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": 1}}}
+	if _, err := intake.newRecord(1, 1, "issue", deep, ""); !errors.Is(err, ErrValidation) {
+		t.Fatalf("details past the limits: got %v, want ErrValidation", err)
+	}
+}
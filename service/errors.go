@@ -0,0 +1,45 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// Errors of the data service; handlers map them to status codes with errors.Is.
+// ErrUpstreamUnavailable, for a Converty outage, is declared next to the circuit breaker.
+var (
+	// ErrNotFound is returned when a record, or the Converty resource asked for, does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrTokenExpired is returned when there is no usable Converty token and refreshing it failed
+	ErrTokenExpired = errors.New("Converty token is missing or expired, please authenticate via /login")
+	// ErrValidation is returned when the service or Converty rejects the input of a call
+	ErrValidation = errors.New("invalid input")
+)
+
+// recordLookupError wraps ErrNotFound when the record does not exist and keeps other database errors apart
+func recordLookupError(id uint, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("record with ID %d: %w", id, ErrNotFound)
+	}
+	return fmt.Errorf("failed to fetch record %d: %v", id, err)
+}
+
+// UpstreamStatusError decodes a failed Converty response into a ConvertyError classified by status:
+// 404 is ErrNotFound, 400 and 422 are ErrValidation, 401 is ErrTokenExpired and 5xx is ErrUpstreamUnavailable
+func UpstreamStatusError(status int, body []byte) error {
+	err := ParseConvertyError(status, body)
+	switch {
+	case status == http.StatusNotFound:
+		err.kind = ErrNotFound
+	case status == http.StatusBadRequest || status == http.StatusUnprocessableEntity:
+		err.kind = ErrValidation
+	case status == http.StatusUnauthorized:
+		err.kind = ErrTokenExpired
+	case status >= http.StatusInternalServerError:
+		err.kind = ErrUpstreamUnavailable
+	}
+	return err
+}
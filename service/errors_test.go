@@ -0,0 +1,43 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestUpstreamStatusError(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusBadRequest, ErrValidation},
+		{http.StatusUnprocessableEntity, ErrValidation},
+		{http.StatusUnauthorized, ErrTokenExpired},
+		{http.StatusBadGateway, ErrUpstreamUnavailable},
+	}
+	for _, tt := range tests {
+		err := UpstreamStatusError(tt.status, []byte(`{"message":"nope"}`))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("status %d: got %v, want %v", tt.status, err, tt.want)
+		}
+	}
+	err := UpstreamStatusError(http.StatusConflict, nil)
+	for _, sentinel := range []error{ErrNotFound, ErrValidation, ErrTokenExpired, ErrUpstreamUnavailable} {
+		if errors.Is(err, sentinel) {
+			t.Errorf("status 409 should not be %v", sentinel)
+		}
+	}
+}
+
+func TestRecordLookupError(t *testing.T) {
+	if err := recordLookupError(7, gorm.ErrRecordNotFound); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing record: got %v, want ErrNotFound", err)
+	}
+	if err := recordLookupError(7, errors.New("connection refused")); errors.Is(err, ErrNotFound) {
+		t.Errorf("database failure reported as not found: %v", err)
+	}
+}
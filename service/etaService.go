@@ -0,0 +1,363 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ETA estimate bases, from the most to the least specific sample set
+const (
+	ETABasisCompanyZone = "company_zone"
+	ETABasisCompany     = "company"
+	ETABasisZone        = "zone"
+	ETABasisStore       = "store"
+	ETABasisNone        = "none"
+)
+
+// OrderConfirmed is the status of an order the store accepted; delivery durations are measured from it
+const OrderConfirmed = "confirmed"
+
+// etaMinSamples is how many delivered orders a sample set needs before it is trusted
+const etaMinSamples = 5
+
+// etaHistoryWindow bounds how far back delivered orders are used, so estimates follow carrier changes
+const etaHistoryWindow = 180 * 24 * time.Hour
+
+// OrderStatusChange is an order status observed by the tracking job; the time between an order's
+// first confirmed observation, or its creation when it was never seen confirmed, and its first
+// delivered observation is the delivery duration used for ETAs
+type OrderStatusChange struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	TenantID        uint      `gorm:"not null;default:0;index:idx_order_status_tenant_order" json:"tenant_id"`
+	OrderID         string    `gorm:"not null;index:idx_order_status_tenant_order" json:"order_id"`
+	Status          string    `gorm:"not null;index" json:"status"`
+	DeliveryCompany string    `gorm:"index" json:"delivery_company"`
+	Zone            string    `gorm:"index" json:"zone"`
+	OrderedAt       time.Time `json:"ordered_at"`
+	ObservedAt      time.Time `gorm:"not null" json:"observed_at"`
+}
+
+// TableName specifies the table name for OrderStatusChange
+func (OrderStatusChange) TableName() string {
+	return "chatbot.order_status_history"
+}
+
+// DeliveryEstimate is the predicted delivery window of an order
+type DeliveryEstimate struct {
+	OrderID         string     `json:"order_id"`
+	Status          string     `json:"status"`
+	DeliveryCompany string     `json:"delivery_company"`
+	Zone            string     `json:"zone"`
+	Delivered       bool       `json:"delivered"`
+	DeliveredAt     *time.Time `json:"delivered_at,omitempty"`
+	EarliestAt      *time.Time `json:"earliest_at,omitempty"`
+	ExpectedAt      *time.Time `json:"expected_at,omitempty"`
+	LatestAt        *time.Time `json:"latest_at,omitempty"`
+	// Late is set when the order is already past the usual window and the estimate was pushed forward
+	Late bool `json:"late"`
+	// ConfirmedAt is when the order was first seen confirmed; the window counts from it when set
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	// AverageDays is the mean confirmed to delivered time of the sample set, the route average
+	AverageDays float64 `json:"average_days,omitempty"`
+	// Confidence ranges from 0 (no history) to 1 (many tightly grouped deliveries)
+	Confidence float64 `json:"confidence"`
+	Basis      string  `json:"basis"`
+	SampleSize int     `json:"sample_size"`
+	Message    string  `json:"message"`
+}
+
+// ETAService defines the interface for order delivery predictions
+type ETAService interface {
+	// TrackOrders records the status of recent orders and returns how many changes were seen
+	TrackOrders(tenantID uint, dataService DataService, maxPages int) (int, error)
+	Estimate(tenantID uint, order Order) (DeliveryEstimate, error)
+}
+
+// GormETAService implements ETAService using GORM
+type GormETAService struct {
+	db  *gorm.DB
+	now func() time.Time
+}
+
+// NewGormETAService creates a new GormETAService
+func NewGormETAService(db *gorm.DB) ETAService {
+	return &GormETAService{db: db, now: time.Now}
+}
+
+// TrackOrders stores a status change for every order whose status differs from the last one seen,
+// publishing EventOrderStatusChanged for the orders it had seen before
+func (s *GormETAService) TrackOrders(tenantID uint, dataService DataService, maxPages int) (int, error) {
+	const limit = 50
+	changes := 0
+	now := s.now()
+	for page := 1; page <= maxPages; page++ {
+		orders, err := dataService.ListOrders(CustomerOrderQuery{Page: page, Limit: limit})
+		if err != nil {
+			return changes, err
+		}
+		if len(orders) == 0 {
+			break
+		}
+
+		ids := make([]string, len(orders))
+		for i, order := range orders {
+			ids[i] = order.ID
+		}
+		var latest []OrderStatusChange
+		if err := s.db.Raw(`SELECT DISTINCT ON (order_id) order_id, status FROM chatbot.order_status_history
+			WHERE tenant_id = ? AND order_id IN ? ORDER BY order_id, observed_at DESC`, tenantID, ids).
+			Scan(&latest).Error; err != nil {
+			return changes, fmt.Errorf("failed to load order statuses: %v", err)
+		}
+		known := make(map[string]string, len(latest))
+		for _, change := range latest {
+			known[change.OrderID] = change.Status
+		}
+
+		var batch []OrderStatusChange
+		var moved []Event
+		for _, order := range orders {
+			status := strings.ToLower(order.Status)
+			if status == "" || known[order.ID] == status {
+				continue
+			}
+			if previous, seen := known[order.ID]; seen {
+				moved = append(moved, Event{
+					Type:     EventOrderStatusChanged,
+					TenantID: tenantID,
+					Data:     map[string]interface{}{"order_id": order.ID, "from": previous, "to": status},
+				})
+			}
+			batch = append(batch, OrderStatusChange{
+				TenantID:        tenantID,
+				OrderID:         order.ID,
+				Status:          status,
+				DeliveryCompany: etaKey(order.DeliveryCompany),
+				Zone:            etaKey(order.Customer.City),
+				OrderedAt:       order.CreatedAt,
+				ObservedAt:      now,
+			})
+		}
+		if len(batch) > 0 {
+			if err := s.db.Create(&batch).Error; err != nil {
+				return changes, fmt.Errorf("failed to save order statuses: %v", err)
+			}
+			changes += len(batch)
+		}
+		for _, event := range moved {
+			Events.Publish(event)
+		}
+		if len(orders) < limit {
+			break
+		}
+	}
+	return changes, nil
+}
+
+// Estimate predicts the delivery window of an order from the delivery durations of past orders
+// with the same delivery company and zone (the route), falling back to broader sample sets
+func (s *GormETAService) Estimate(tenantID uint, order Order) (DeliveryEstimate, error) {
+	estimate := DeliveryEstimate{
+		OrderID:         order.ID,
+		Status:          order.Status,
+		DeliveryCompany: order.DeliveryCompany,
+		Zone:            order.Customer.City,
+		Basis:           ETABasisNone,
+	}
+
+	if strings.EqualFold(order.Status, OrderDelivered) {
+		estimate.Delivered = true
+		var delivered OrderStatusChange
+		found := s.db.Where("tenant_id = ? AND order_id = ? AND status = ?", tenantID, order.ID, OrderDelivered).
+			Order("observed_at").Limit(1).Find(&delivered)
+		if found.Error != nil {
+			return estimate, fmt.Errorf("failed to load order status history: %v", found.Error)
+		}
+		if found.RowsAffected > 0 {
+			estimate.DeliveredAt = &delivered.ObservedAt
+		}
+		estimate.Confidence = 1
+		estimate.Localize(NewLocalizer())
+		return estimate, nil
+	}
+
+	// Confirmations are looked up further back than deliveries, as they come days earlier
+	var history []OrderStatusChange
+	if err := s.db.Where("tenant_id = ? AND status IN ? AND observed_at > ?", tenantID, []string{OrderConfirmed, OrderDelivered}, s.now().Add(-etaHistoryWindow-30*24*time.Hour)).
+		Order("observed_at").Find(&history).Error; err != nil {
+		return estimate, fmt.Errorf("failed to load delivery history: %v", err)
+	}
+	var deliveries []OrderStatusChange
+	confirmed := map[string]time.Time{}
+	for _, change := range history {
+		if change.Status == OrderDelivered {
+			if change.ObservedAt.After(s.now().Add(-etaHistoryWindow)) {
+				deliveries = append(deliveries, change)
+			}
+		} else if _, seen := confirmed[change.OrderID]; !seen {
+			confirmed[change.OrderID] = change.ObservedAt
+		}
+	}
+
+	samples, basis := etaSamples(deliveries, confirmed, etaKey(order.DeliveryCompany), etaKey(order.Customer.City))
+	estimate.Basis = basis
+	estimate.SampleSize = len(samples)
+	if len(samples) == 0 {
+		estimate.Localize(NewLocalizer())
+		return estimate, nil
+	}
+	estimate.AverageDays = averageDays(samples)
+
+	start := order.CreatedAt
+	if at, ok := confirmed[order.ID]; ok {
+		start = at
+		estimate.ConfirmedAt = &at
+	}
+	window := predictWindow(start, s.now(), samples, basis)
+	estimate.EarliestAt = &window.earliest
+	estimate.ExpectedAt = &window.expected
+	estimate.LatestAt = &window.latest
+	estimate.Late = window.late
+	estimate.Confidence = window.confidence
+	estimate.Localize(NewLocalizer())
+	return estimate, nil
+}
+
+// Localize rewrites the customer-facing message in the localizer's language
+func (e *DeliveryEstimate) Localize(l *Localizer) {
+	switch {
+	case e.Delivered:
+		e.Message = l.T("eta.delivered")
+	case e.EarliestAt == nil || e.LatestAt == nil:
+		e.Message = l.T("eta.no_history")
+	default:
+		e.Message = l.T("eta.window", l.Date(*e.EarliestAt), l.Date(*e.LatestAt))
+	}
+}
+
+// etaKey normalizes delivery companies and zones so "Tunis " and "tunis" share samples
+func etaKey(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// etaSamples picks the delivery durations of the most specific sample set with enough orders. A
+// duration runs from the order's confirmation when it was seen, from its creation otherwise.
+func etaSamples(deliveries []OrderStatusChange, confirmed map[string]time.Time, company, zone string) ([]time.Duration, string) {
+	sets := map[string][]time.Duration{}
+	seen := make(map[string]bool, len(deliveries))
+	for _, delivery := range deliveries {
+		// Only the first delivered observation of an order counts
+		if seen[delivery.OrderID] {
+			continue
+		}
+		seen[delivery.OrderID] = true
+		start := delivery.OrderedAt
+		if at, ok := confirmed[delivery.OrderID]; ok {
+			start = at
+		}
+		duration := delivery.ObservedAt.Sub(start)
+		if duration <= 0 {
+			continue
+		}
+		sameCompany := company != "" && delivery.DeliveryCompany == company
+		sameZone := zone != "" && delivery.Zone == zone
+		if sameCompany && sameZone {
+			sets[ETABasisCompanyZone] = append(sets[ETABasisCompanyZone], duration)
+		}
+		if sameCompany {
+			sets[ETABasisCompany] = append(sets[ETABasisCompany], duration)
+		}
+		if sameZone {
+			sets[ETABasisZone] = append(sets[ETABasisZone], duration)
+		}
+		sets[ETABasisStore] = append(sets[ETABasisStore], duration)
+	}
+	for _, basis := range []string{ETABasisCompanyZone, ETABasisCompany, ETABasisZone, ETABasisStore} {
+		if len(sets[basis]) >= etaMinSamples {
+			return sets[basis], basis
+		}
+	}
+	return nil, ETABasisNone
+}
+
+// averageDays is the mean of the durations in days, to one decimal
+func averageDays(samples []time.Duration) float64 {
+	var total time.Duration
+	for _, sample := range samples {
+		total += sample
+	}
+	days := total.Hours() / 24 / float64(len(samples))
+	return math.Round(days*10) / 10
+}
+
+// etaBasisWeight lowers the confidence of estimates built from broader sample sets
+var etaBasisWeight = map[string]float64{
+	ETABasisCompanyZone: 1,
+	ETABasisCompany:     0.85,
+	ETABasisZone:        0.75,
+	ETABasisStore:       0.6,
+}
+
+// deliveryWindow is a predicted delivery window
+type deliveryWindow struct {
+	earliest, expected, latest time.Time
+	late                       bool
+	confidence                 float64
+}
+
+// predictWindow spans the 20th to 80th percentile of the delivery durations from the order date.
+// Confidence grows with the sample size and shrinks with the spread of the durations.
+func predictWindow(orderedAt, now time.Time, samples []time.Duration, basis string) deliveryWindow {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	low, median, high := percentile(sorted, 0.2), percentile(sorted, 0.5), percentile(sorted, 0.8)
+
+	window := deliveryWindow{
+		earliest: orderedAt.Add(low),
+		expected: orderedAt.Add(median),
+		latest:   orderedAt.Add(high),
+	}
+	sampleFactor := math.Min(1, float64(len(sorted))/30)
+	spread := 0.0
+	if median > 0 {
+		spread = float64(high-low) / float64(median)
+	}
+	window.confidence = sampleFactor * etaBasisWeight[basis] / (1 + spread)
+
+	// The order is already overdue: the usual window has passed, so predict from now
+	if now.After(window.latest) {
+		remaining := high - median
+		if remaining < 24*time.Hour {
+			remaining = 24 * time.Hour
+		}
+		window.earliest = now
+		window.expected = now.Add(remaining / 2)
+		window.latest = now.Add(remaining)
+		window.late = true
+		window.confidence /= 2
+	} else if now.After(window.earliest) {
+		window.earliest = now
+		if now.After(window.expected) {
+			window.expected = now.Add(window.latest.Sub(now) / 2)
+		}
+	}
+	window.confidence = math.Round(window.confidence*100) / 100
+	return window
+}
+
+// percentile interpolates the p-th percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	fraction := rank - float64(lower)
+	return sorted[lower] + time.Duration(fraction*float64(sorted[upper]-sorted[lower]))
+}
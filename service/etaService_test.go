@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestETASamplesFallback(t *testing.T) {
+	ordered := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	var deliveries []OrderStatusChange
+	add := func(company, zone string, days int) {
+		deliveries = append(deliveries, OrderStatusChange{
+			OrderID:         fmt.Sprintf("o%d", len(deliveries)),
+			DeliveryCompany: company,
+			Zone:            zone,
+			OrderedAt:       ordered,
+			ObservedAt:      ordered.Add(time.Duration(days) * 24 * time.Hour),
+		})
+	}
+	for i := 0; i < etaMinSamples; i++ {
+		add("aramex", "tunis", 2)
+		add("aramex", "sfax", 4)
+	}
+	add("first delivery", "tunis", 1)
+	// A repeated delivered observation of the same order is ignored
+	deliveries = append(deliveries, OrderStatusChange{OrderID: "o0", DeliveryCompany: "aramex", Zone: "tunis", OrderedAt: ordered, ObservedAt: ordered.Add(30 * 24 * time.Hour)})
+
+	samples, basis := etaSamples(deliveries, nil, "aramex", "tunis")
+	if basis != ETABasisCompanyZone || len(samples) != etaMinSamples {
+		t.Errorf("aramex/tunis: got %s with %d samples", basis, len(samples))
+	}
+	if _, basis := etaSamples(deliveries, nil, "aramex", "sousse"); basis != ETABasisCompany {
+		t.Errorf("aramex/sousse: got %s, want %s", basis, ETABasisCompany)
+	}
+	if _, basis := etaSamples(deliveries, nil, "first delivery", "tunis"); basis != ETABasisZone {
+		t.Errorf("first delivery/tunis: got %s, want %s", basis, ETABasisZone)
+	}
+	if _, basis := etaSamples(deliveries, nil, "", "bizerte"); basis != ETABasisStore {
+		t.Errorf("unknown: got %s, want %s", basis, ETABasisStore)
+	}
+	if _, basis := etaSamples(deliveries[:2], nil, "aramex", "tunis"); basis != ETABasisNone {
+		t.Errorf("too few deliveries: got %s, want %s", basis, ETABasisNone)
+	}
+}
+
+func TestETASamplesFromConfirmation(t *testing.T) {
+	ordered := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	var deliveries []OrderStatusChange
+	confirmed := map[string]time.Time{}
+	for i := 0; i < etaMinSamples; i++ {
+		id := fmt.Sprintf("o%d", i)
+		deliveries = append(deliveries, OrderStatusChange{OrderID: id, DeliveryCompany: "aramex", Zone: "tunis", OrderedAt: ordered, ObservedAt: ordered.Add(5 * day)})
+		// Orders sat three days before the store confirmed them, except the last one
+		if i < etaMinSamples-1 {
+			confirmed[id] = ordered.Add(3 * day)
+		}
+	}
+	samples, _ := etaSamples(deliveries, confirmed, "aramex", "tunis")
+	if samples[0] != 2*day || samples[etaMinSamples-1] != 5*day {
+		t.Errorf("durations %v do not run from the confirmation", samples)
+	}
+	if average := averageDays(samples); average != 2.6 {
+		t.Errorf("average %.2f days, want 2.6", average)
+	}
+}
+
+func TestPredictWindow(t *testing.T) {
+	day := 24 * time.Hour
+	ordered := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	samples := []time.Duration{2 * day, 2 * day, 3 * day, 3 * day, 4 * day, 5 * day}
+
+	window := predictWindow(ordered, ordered, samples, ETABasisCompanyZone)
+	if window.expected != ordered.Add(3*day) {
+		t.Errorf("expected at %v, want the median of 3 days", window.expected)
+	}
+	if !window.earliest.Before(window.expected) || !window.latest.After(window.expected) {
+		t.Errorf("window %v - %v does not surround %v", window.earliest, window.latest, window.expected)
+	}
+	if window.late || window.confidence <= 0 || window.confidence > 1 {
+		t.Errorf("unexpected window %+v", window)
+	}
+
+	// Broader sample sets are less trusted
+	if broad := predictWindow(ordered, ordered, samples, ETABasisStore); broad.confidence >= window.confidence {
+		t.Errorf("store confidence %.2f should be below %.2f", broad.confidence, window.confidence)
+	}
+
+	now := ordered.Add(10 * day)
+	late := predictWindow(ordered, now, samples, ETABasisCompanyZone)
+	if !late.late || late.earliest != now || !late.latest.After(now) {
+		t.Errorf("overdue order window %+v", late)
+	}
+	if late.confidence >= window.confidence {
+		t.Errorf("overdue confidence %.2f should be below %.2f", late.confidence, window.confidence)
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published on the in-process event bus
+const (
+	EventRecordCreated       = "record.created"
+	EventRecordStatusChanged = "record.status_changed"
+	EventRecordUpdated       = "record.updated"
+	EventRecordArchived      = "record.archived"
+	EventRecordRestored      = "record.restored"
+	EventRecordDeleted       = "record.deleted"
+	EventRecordPurged        = "record.purged"
+	EventUpstreamCall        = "upstream.call"
+)
+
+// Event is something that happened in this process, used to keep live views current
+type Event struct {
+	Type     string                 `json:"type"`
+	TenantID uint                   `json:"tenant_id"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Time     time.Time              `json:"time"`
+}
+
+// EventBus fans events out to in-process subscribers
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[chan Event]struct{}
+}
+
+// NewEventBus creates an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Events is the process-wide event bus
+var Events = NewEventBus()
+
+// Subscribe returns a channel receiving every published event and a function that ends the subscription
+func (b *EventBus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish delivers the event to every subscriber; slow subscribers miss events rather than block publishers
+func (b *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
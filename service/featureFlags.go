@@ -0,0 +1,262 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// flagCacheTTL bounds how long another instance keeps applying a flag that was changed
+const flagCacheTTL = 30 * time.Second
+
+// Route groups that can be switched off per tenant. They are on unless the configuration or a
+// stored flag says otherwise; any other flag name is a behavior that is off until enabled.
+const (
+	FlagOrderCreation = "order_creation"
+	FlagWebhooks      = "webhooks"
+	FlagExports       = "exports"
+)
+
+// RouteFlags describes the route group flags
+var RouteFlags = map[string]string{
+	FlagOrderCreation: "POST /api/v1/orders",
+	FlagWebhooks:      "/api/v1/webhooks",
+	FlagExports:       "/api/v1/orders/export",
+}
+
+// Sources of a flag's value, from the most to the least specific
+const (
+	FlagSourceTenant       = "tenant"
+	FlagSourceGlobal       = "global"
+	FlagSourceTenantConfig = "tenant_config"
+	FlagSourceConfig       = "config"
+	FlagSourceDefault      = "default"
+)
+
+// flagRowsCacheKey caches the stored flags together; there are few of them
+const flagRowsCacheKey = "rows"
+
+var flagNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_.-]{1,63}$`)
+
+// FeatureFlag is a stored flag value, for one tenant or, with an empty tenant, for every tenant
+type FeatureFlag struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"not null;uniqueIndex:idx_feature_flags_name_tenant" json:"name"`
+	Tenant      string    `gorm:"not null;default:'';uniqueIndex:idx_feature_flags_name_tenant" json:"tenant,omitempty"`
+	Enabled     bool      `gorm:"not null" json:"enabled"`
+	Description string    `json:"description,omitempty"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for FeatureFlag
+func (FeatureFlag) TableName() string {
+	return "public.feature_flags"
+}
+
+// FlagConfig holds the flag values of the configuration; stored flags take precedence
+type FlagConfig struct {
+	Values map[string]bool `json:"values,omitempty"`
+	// Tenants overrides Values per tenant slug
+	Tenants map[string]map[string]bool `json:"tenants,omitempty"`
+}
+
+// FlagState is the value of a flag for a tenant and where it comes from
+type FlagState struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"`
+	// Route is the route group the flag switches, for the built-in flags
+	Route string `json:"route,omitempty"`
+}
+
+// FeatureFlagService defines the interface for feature flags
+type FeatureFlagService interface {
+	// Enabled reports whether a flag is on for a tenant slug
+	Enabled(name, tenant string) bool
+	// Flags returns the state of every built-in, configured or stored flag for a tenant
+	Flags(tenant string) ([]FlagState, error)
+	// ListFlags returns the stored flags of every tenant
+	ListFlags() ([]FeatureFlag, error)
+	// SetFlag stores a flag value for a tenant, or for every tenant when tenant is empty
+	SetFlag(name, tenant string, enabled bool, description, actor string) (FeatureFlag, error)
+	// DeleteFlag removes a stored value, falling back to the configuration
+	DeleteFlag(name, tenant string) error
+	// SetConfig replaces the configured values, e.g. on a configuration reload
+	SetConfig(cfg FlagConfig)
+}
+
+// GormFeatureFlagService implements FeatureFlagService using GORM
+type GormFeatureFlagService struct {
+	db    *gorm.DB
+	cache *ttlCache
+	mu    sync.RWMutex
+	cfg   FlagConfig
+}
+
+// NewGormFeatureFlagService creates a new GormFeatureFlagService
+func NewGormFeatureFlagService(db *gorm.DB, cfg FlagConfig) FeatureFlagService {
+	cache := newTTLCache(flagCacheTTL)
+	Caches.Register(CacheFlags, cache)
+	return &GormFeatureFlagService{db: db, cache: cache, cfg: cfg}
+}
+
+// NormalizeFlagName lower-cases a flag name and rejects malformed ones with ErrValidation
+func NormalizeFlagName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !flagNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%w: flag name %q must be 2-64 letters, digits, '.', '_' or '-', starting with a letter", ErrValidation, name)
+	}
+	return name, nil
+}
+
+// ResolveFlag works out a flag for a tenant: a stored tenant value, then a stored global value,
+// then the tenant's and the global configuration, and finally on for route groups, off otherwise
+func ResolveFlag(name, tenant string, stored []FeatureFlag, cfg FlagConfig) FlagState {
+	state := FlagState{Name: name, Route: RouteFlags[name]}
+	var global *FeatureFlag
+	for i, flag := range stored {
+		if flag.Name != name {
+			continue
+		}
+		if flag.Tenant == tenant && tenant != "" {
+			state.Enabled, state.Source = flag.Enabled, FlagSourceTenant
+			return state
+		}
+		if flag.Tenant == "" {
+			global = &stored[i]
+		}
+	}
+	if global != nil {
+		state.Enabled, state.Source = global.Enabled, FlagSourceGlobal
+		return state
+	}
+	if enabled, ok := cfg.Tenants[tenant][name]; ok {
+		state.Enabled, state.Source = enabled, FlagSourceTenantConfig
+		return state
+	}
+	if enabled, ok := cfg.Values[name]; ok {
+		state.Enabled, state.Source = enabled, FlagSourceConfig
+		return state
+	}
+	_, route := RouteFlags[name]
+	state.Enabled, state.Source = route, FlagSourceDefault
+	return state
+}
+
+// Enabled resolves a flag; when the stored flags cannot be read the configuration decides
+func (s *GormFeatureFlagService) Enabled(name, tenant string) bool {
+	stored, err := s.stored()
+	if err != nil {
+		log.Printf("Resolving flag %s from the configuration: %v", name, err)
+	}
+	return ResolveFlag(name, tenant, stored, s.config()).Enabled
+}
+
+// Flags resolves every flag that is built in, configured or stored
+func (s *GormFeatureFlagService) Flags(tenant string) ([]FlagState, error) {
+	stored, err := s.stored()
+	if err != nil {
+		return nil, err
+	}
+	cfg := s.config()
+	names := map[string]bool{}
+	for name := range RouteFlags {
+		names[name] = true
+	}
+	for name := range cfg.Values {
+		names[name] = true
+	}
+	for name := range cfg.Tenants[tenant] {
+		names[name] = true
+	}
+	for _, flag := range stored {
+		if flag.Tenant == "" || flag.Tenant == tenant {
+			names[flag.Name] = true
+		}
+	}
+	states := make([]FlagState, 0, len(names))
+	for name := range names {
+		states = append(states, ResolveFlag(name, tenant, stored, cfg))
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states, nil
+}
+
+// ListFlags fetches the stored flags
+func (s *GormFeatureFlagService) ListFlags() ([]FeatureFlag, error) {
+	var flags []FeatureFlag
+	if err := s.db.Order("name, tenant").Find(&flags).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch feature flags: %v", err)
+	}
+	return flags, nil
+}
+
+// SetFlag creates or updates the stored value of a flag
+func (s *GormFeatureFlagService) SetFlag(name, tenant string, enabled bool, description, actor string) (FeatureFlag, error) {
+	name, err := NormalizeFlagName(name)
+	if err != nil {
+		return FeatureFlag{}, err
+	}
+	var flag FeatureFlag
+	result := s.db.Where("name = ? AND tenant = ?", name, tenant).Limit(1).Find(&flag)
+	if result.Error != nil {
+		return FeatureFlag{}, fmt.Errorf("failed to fetch flag %s: %v", name, result.Error)
+	}
+	if description == "" {
+		description = flag.Description
+	}
+	flag.Name, flag.Tenant, flag.Enabled = name, tenant, enabled
+	flag.Description, flag.UpdatedBy = description, actor
+	if err := s.db.Save(&flag).Error; err != nil {
+		return FeatureFlag{}, fmt.Errorf("failed to save flag %s: %v", name, err)
+	}
+	s.cache.DeletePrefix("")
+	return flag, nil
+}
+
+// DeleteFlag removes the stored value of a flag for a tenant, or the global one
+func (s *GormFeatureFlagService) DeleteFlag(name, tenant string) error {
+	result := s.db.Where("name = ? AND tenant = ?", name, tenant).Delete(&FeatureFlag{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete flag %s: %v", name, result.Error)
+	}
+	s.cache.DeletePrefix("")
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: flag %s has no stored value for tenant %q", ErrNotFound, name, tenant)
+	}
+	return nil
+}
+
+// SetConfig replaces the configured values
+func (s *GormFeatureFlagService) SetConfig(cfg FlagConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+}
+
+func (s *GormFeatureFlagService) config() FlagConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// stored loads every stored flag through the cache
+func (s *GormFeatureFlagService) stored() ([]FeatureFlag, error) {
+	if cached, ok := s.cache.Get(flagRowsCacheKey); ok {
+		return cached.([]FeatureFlag), nil
+	}
+	flags, err := s.ListFlags()
+	if err != nil {
+		return nil, err
+	}
+	s.cache.Set(flagRowsCacheKey, flags)
+	return flags, nil
+}
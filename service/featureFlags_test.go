@@ -0,0 +1,45 @@
+package service
+
+import "testing"
+
+func TestResolveFlag(t *testing.T) {
+	stored := []FeatureFlag{
+		{Name: FlagExports, Tenant: "", Enabled: false},
+		{Name: FlagExports, Tenant: "acme", Enabled: true},
+		{Name: "new_eta", Tenant: "acme", Enabled: true},
+	}
+	cfg := FlagConfig{
+		Values:  map[string]bool{FlagWebhooks: false, "new_eta": false},
+		Tenants: map[string]map[string]bool{"acme": {FlagWebhooks: true}},
+	}
+	cases := []struct {
+		name, tenant string
+		enabled      bool
+		source       string
+	}{
+		{FlagExports, "acme", true, FlagSourceTenant},
+		{FlagExports, "other", false, FlagSourceGlobal},
+		{FlagWebhooks, "acme", true, FlagSourceTenantConfig},
+		{FlagWebhooks, "other", false, FlagSourceConfig},
+		{FlagOrderCreation, "acme", true, FlagSourceDefault},
+		{"new_eta", "acme", true, FlagSourceTenant},
+		{"new_eta", "other", false, FlagSourceConfig},
+		{"unknown", "acme", false, FlagSourceDefault},
+	}
+	for _, c := range cases {
+		state := ResolveFlag(c.name, c.tenant, stored, cfg)
+		if state.Enabled != c.enabled || state.Source != c.source {
+			t.Errorf("%s for %s = %t from %s, want %t from %s", c.name, c.tenant, state.Enabled, state.Source, c.enabled, c.source)
+		}
+	}
+	if state := ResolveFlag(FlagExports, "", stored, cfg); state.Source != FlagSourceGlobal {
+		t.Errorf("a request without tenant matched a tenant value: %+v", state)
+	}
+
+	if _, err := NormalizeFlagName("Order Creation"); err == nil {
+		t.Error("flag name with a space accepted")
+	}
+	if name, err := NormalizeFlagName(" New_ETA "); err != nil || name != "new_eta" {
+		t.Errorf("NormalizeFlagName = %q, %v", name, err)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// DefaultLanguage is used when the customer's language has no catalog
+const DefaultLanguage = "en"
+
+// catalogFiles holds one JSON message catalog per supported language
+//
+//go:embed locales/*.json
+var catalogFiles embed.FS
+
+// catalogs maps a language to its messages; every catalog is keyed like locales/en.json
+var catalogs = loadCatalogs()
+
+// supportedLanguages lists the catalog languages, the default first so the matcher falls back to it
+var supportedLanguages = []language.Tag{language.English, language.Arabic, language.French}
+
+var languageMatcher = language.NewMatcher(supportedLanguages)
+
+func loadCatalogs() map[string]map[string]string {
+	loaded := make(map[string]map[string]string)
+	entries, err := catalogFiles.ReadDir("locales")
+	if err != nil {
+		log.Fatalf("Failed to read message catalogs: %v", err)
+	}
+	for _, entry := range entries {
+		body, err := catalogFiles.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			log.Fatalf("Failed to read message catalog %s: %v", entry.Name(), err)
+		}
+		messages := make(map[string]string)
+		if err := json.Unmarshal(body, &messages); err != nil {
+			log.Fatalf("Invalid message catalog %s: %v", entry.Name(), err)
+		}
+		loaded[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+	return loaded
+}
+
+// Localizer translates customer-facing text into one language
+type Localizer struct {
+	Language string
+	messages map[string]string
+}
+
+// NewLocalizer picks the best supported language from the preferences given in order, each either
+// a language code ("fr") or an Accept-Language header ("ar-TN,ar;q=0.9,fr;q=0.8"); empty ones are skipped
+func NewLocalizer(preferences ...string) *Localizer {
+	var wanted []language.Tag
+	for _, preference := range preferences {
+		tags, _, err := language.ParseAcceptLanguage(preference)
+		if err != nil {
+			continue
+		}
+		wanted = append(wanted, tags...)
+	}
+	lang := DefaultLanguage
+	if len(wanted) > 0 {
+		_, index, confidence := languageMatcher.Match(wanted...)
+		if confidence != language.No {
+			base, _ := supportedLanguages[index].Base()
+			lang = base.String()
+		}
+	}
+	return &Localizer{Language: lang, messages: catalogs[lang]}
+}
+
+// T returns the message with the given ID formatted with args, falling back to English and then to the ID
+func (l *Localizer) T(id string, args ...interface{}) string {
+	message, ok := l.messages[id]
+	if !ok {
+		message, ok = catalogs[DefaultLanguage][id]
+	}
+	if !ok {
+		return id
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// Has reports whether a message exists in any catalog
+func (l *Localizer) Has(id string) bool {
+	if _, ok := l.messages[id]; ok {
+		return true
+	}
+	_, ok := catalogs[DefaultLanguage][id]
+	return ok
+}
+
+// Date formats a day in the language's short date layout
+func (l *Localizer) Date(t time.Time) string {
+	return t.Format(l.T("date.layout"))
+}
+
+// StatusLabel is the customer-facing name of a Converty order status; unknown statuses are returned as is
+func (l *Localizer) StatusLabel(status string) string {
+	id := "order.status." + strings.ToLower(strings.TrimSpace(status))
+	if !l.Has(id) {
+		return status
+	}
+	return l.T(id)
+}
+
+// LocalizeOrders fills the status label of each order
+func (l *Localizer) LocalizeOrders(orders []Order) {
+	for i := range orders {
+		orders[i].StatusLabel = l.StatusLabel(orders[i].Status)
+	}
+}
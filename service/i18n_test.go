@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewLocalizerMatchesLanguage(t *testing.T) {
+	cases := []struct {
+		lang, acceptLanguage, want string
+	}{
+		{"", "", "en"},
+		{"fr", "", "fr"},
+		{"", "ar-TN,ar;q=0.9,fr;q=0.8", "ar"},
+		{"", "de-DE,fr;q=0.5", "fr"},
+		{"fr", "ar", "fr"},
+		{"", "de", "en"},
+		{"not a language!", "", "en"},
+	}
+	for _, c := range cases {
+		if got := NewLocalizer(c.lang, c.acceptLanguage).Language; got != c.want {
+			t.Errorf("NewLocalizer(%q, %q) = %s, want %s", c.lang, c.acceptLanguage, got, c.want)
+		}
+	}
+}
+
+func TestCatalogsHaveEveryMessage(t *testing.T) {
+	for lang, messages := range catalogs {
+		for id := range catalogs[DefaultLanguage] {
+			if _, ok := messages[id]; !ok {
+				t.Errorf("catalog %s is missing %s", lang, id)
+			}
+		}
+	}
+}
+
+func TestLocalizedCustomerText(t *testing.T) {
+	french := NewLocalizer("fr")
+	if got := french.StatusLabel("Delivered"); got != "Livrée" {
+		t.Errorf("StatusLabel = %q", got)
+	}
+	if got := french.StatusLabel("on_hold"); got != "on_hold" {
+		t.Errorf("unknown status should pass through, got %q", got)
+	}
+
+	tracking := Tracking{Status: TrackingInTransit, Events: []TrackingEvent{
+		{Status: TrackingInTransit, Location: "Sfax", Time: time.Date(2025, 3, 4, 9, 30, 0, 0, time.UTC)},
+	}}
+	tracking.Localize(NewLocalizer("", "ar"))
+	if tracking.Message != "طردك في الطريق إليك. (Sfax، 04/03 09:30)" {
+		t.Errorf("tracking message = %q", tracking.Message)
+	}
+
+	earliest := time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)
+	latest := earliest.Add(48 * time.Hour)
+	estimate := DeliveryEstimate{EarliestAt: &earliest, LatestAt: &latest}
+	estimate.Localize(french)
+	if estimate.Message != "Livraison prévue entre le 04/03/2025 et le 06/03/2025." {
+		t.Errorf("estimate message = %q", estimate.Message)
+	}
+	estimate.Localize(NewLocalizer())
+	if estimate.Message != "Expected delivery between Tue 04 Mar and Thu 06 Mar." {
+		t.Errorf("estimate message = %q", estimate.Message)
+	}
+}
@@ -0,0 +1,331 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Job represents a background task stored in public.jobs
+type Job struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	// TenantID is the tenant that queued the job; scheduled runs covering every tenant belong to the default one
+	TenantID    uint           `gorm:"not null;default:0;index" json:"tenant_id"`
+	Type        string         `gorm:"not null;index" json:"type"`
+	Payload     datatypes.JSON `json:"payload"`
+	Status      string         `gorm:"not null;index" json:"status"`
+	Result      datatypes.JSON `json:"result,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	Attempts    int            `json:"attempts"`
+	MaxAttempts int            `json:"max_attempts"`
+	RunAt       time.Time      `gorm:"index" json:"run_at"`
+	// ScheduleKey names the schedule slot a scheduled job was queued for; one job is queued per slot
+	ScheduleKey *string    `gorm:"uniqueIndex;size:160" json:"schedule_key,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	// LockedUntil is when the lease of a running job expires; its worker renews it while the job runs,
+	// so a job still running past it lost its worker and is claimed again
+	LockedUntil *time.Time `gorm:"index" json:"locked_until,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for Job
+func (Job) TableName() string {
+	return "public.jobs"
+}
+
+// JobHandler executes a job payload and returns a JSON-serializable result
+type JobHandler func(payload json.RawMessage) (interface{}, error)
+
+// JobService defines the interface for the background job queue
+type JobService interface {
+	RegisterHandler(jobType string, handler JobHandler)
+	Enqueue(tenantID uint, jobType string, payload interface{}) (Job, error)
+	// EnqueueScheduled queues the job of a schedule slot unless another instance already did;
+	// queued is false when the slot had its job
+	EnqueueScheduled(tenantID uint, jobType string, payload interface{}, slot time.Time) (job Job, queued bool, err error)
+	GetJob(tenantID, id uint) (Job, error)
+	Start(workers int)
+	Stop()
+}
+
+// GormJobService implements JobService using GORM with worker goroutines
+type GormJobService struct {
+	db           *gorm.DB
+	pollInterval time.Duration
+	maxAttempts  int
+	// lease is how long a claimed job stays with its worker between heartbeats
+	lease time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewGormJobService creates a new GormJobService
+func NewGormJobService(db *gorm.DB) JobService {
+	return &GormJobService{
+		db:           db,
+		pollInterval: 2 * time.Second,
+		maxAttempts:  3,
+		lease:        5 * time.Minute,
+		handlers:     make(map[string]JobHandler),
+		stop:         make(chan struct{}),
+	}
+}
+
+// RegisterHandler associates a job type with the function that runs it
+func (s *GormJobService) RegisterHandler(jobType string, handler JobHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[jobType] = handler
+}
+
+// Enqueue stores a new job of the tenant to be picked up by a worker
+func (s *GormJobService) Enqueue(tenantID uint, jobType string, payload interface{}) (Job, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to marshal job payload: %v", err)
+	}
+
+	job := Job{
+		TenantID:    tenantID,
+		Type:        jobType,
+		Payload:     payloadJSON,
+		Status:      JobQueued,
+		MaxAttempts: s.maxAttempts,
+		RunAt:       time.Now(),
+	}
+	if err := s.db.Create(&job).Error; err != nil {
+		return Job{}, fmt.Errorf("failed to enqueue job: %v", err)
+	}
+	return job, nil
+}
+
+// EnqueueScheduled stores the job of a schedule slot once, however many instances tick in it
+func (s *GormJobService) EnqueueScheduled(tenantID uint, jobType string, payload interface{}, slot time.Time) (Job, bool, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, false, fmt.Errorf("failed to marshal job payload: %v", err)
+	}
+
+	key := scheduleKey(tenantID, jobType, slot)
+	job := Job{
+		TenantID:    tenantID,
+		Type:        jobType,
+		Payload:     payloadJSON,
+		Status:      JobQueued,
+		MaxAttempts: s.maxAttempts,
+		RunAt:       time.Now(),
+		ScheduleKey: &key,
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&job)
+	if result.Error != nil {
+		return Job{}, false, fmt.Errorf("failed to enqueue job: %v", result.Error)
+	}
+	return job, result.RowsAffected > 0, nil
+}
+
+// scheduleKey names the slot starting at slot of the jobType schedule of a tenant
+func scheduleKey(tenantID uint, jobType string, slot time.Time) string {
+	return fmt.Sprintf("%s:%d:%d", jobType, tenantID, slot.Unix())
+}
+
+// GetJob fetches a job of the tenant by ID
+func (s *GormJobService) GetJob(tenantID, id uint) (Job, error) {
+	var job Job
+	if err := s.db.Where("tenant_id = ?", tenantID).First(&job, id).Error; err != nil {
+		return Job{}, fmt.Errorf("job with ID %d not found: %v", id, err)
+	}
+	return job, nil
+}
+
+// Start launches the given number of worker goroutines
+func (s *GormJobService) Start(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.work(i)
+	}
+	log.Printf("Job queue started with %d workers", workers)
+}
+
+// Stop signals the workers to exit and waits for running jobs to finish; later calls do nothing
+func (s *GormJobService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.wg.Wait()
+		log.Println("Job queue stopped")
+	})
+}
+
+func (s *GormJobService) work(worker int) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain all due jobs before waiting for the next tick
+		for {
+			job, ok, err := s.claim()
+			if err != nil {
+				log.Printf("Job worker %d: failed to claim job: %v", worker, err)
+				break
+			}
+			if !ok {
+				break
+			}
+			s.run(job)
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// staleRunning matches the running jobs whose lease expired before the first parameter; jobs claimed
+// before leases existed count as stale once they started before the second
+const staleRunning = "status = 'running' AND (locked_until < ? OR (locked_until IS NULL AND started_at < ?))"
+
+// claim locks the oldest due job, or a running job whose worker was lost, and marks it running under
+// a fresh lease. Lost jobs that used up their attempts fail instead of running again.
+func (s *GormJobService) claim() (Job, bool, error) {
+	var job Job
+	found := false
+	now := time.Now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Job{}).Where(staleRunning+" AND attempts >= max_attempts", now, now.Add(-s.lease)).
+			Updates(map[string]interface{}{
+				"status":       JobFailed,
+				"error":        "the worker running the job was lost",
+				"locked_until": nil,
+				"finished_at":  now,
+			}).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(status = ? AND run_at <= ?) OR ("+staleRunning+")", JobQueued, now, now, now.Add(-s.lease)).
+			Order("run_at").
+			Limit(1).
+			Find(&job)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		found = true
+		lockedUntil := now.Add(s.lease)
+		job.Status = JobRunning
+		job.Attempts++
+		job.StartedAt = &now
+		job.LockedUntil = &lockedUntil
+		return tx.Model(&job).Updates(map[string]interface{}{
+			"status":       job.Status,
+			"attempts":     job.Attempts,
+			"started_at":   now,
+			"locked_until": lockedUntil,
+		}).Error
+	})
+	return job, found, err
+}
+
+// heartbeat renews the lease of a running job until done is closed
+func (s *GormJobService) heartbeat(id uint, done <-chan struct{}) {
+	ticker := time.NewTicker(s.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			err := s.db.Model(&Job{}).Where("id = ? AND status = ?", id, JobRunning).
+				Update("locked_until", now.Add(s.lease)).Error
+			if err != nil {
+				log.Printf("Job %d: failed to renew lease: %v", id, err)
+			}
+		}
+	}
+}
+
+func (s *GormJobService) run(job Job) {
+	s.mu.RLock()
+	handler, ok := s.handlers[job.Type]
+	s.mu.RUnlock()
+
+	var result interface{}
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler registered for job type %q", job.Type)
+	} else {
+		done := make(chan struct{})
+		go s.heartbeat(job.ID, done)
+		result, err = safeRun(handler, json.RawMessage(job.Payload))
+		close(done)
+	}
+	if err != nil {
+		log.Printf("Job %d (%s) attempt %d failed: %v", job.ID, job.Type, job.Attempts, err)
+	}
+	if err := s.db.Model(&Job{ID: job.ID}).Updates(jobOutcome(job, ok, result, err, time.Now())).Error; err != nil {
+		log.Printf("Job %d: failed to record outcome: %v", job.ID, err)
+	}
+}
+
+// jobOutcome returns the updates recording how an attempt of job ended. A failed attempt is retried
+// with linear backoff until the job runs out of attempts; a job without a handler fails at once.
+func jobOutcome(job Job, handled bool, result interface{}, err error, now time.Time) map[string]interface{} {
+	updates := map[string]interface{}{"locked_until": nil}
+	if err != nil {
+		updates["error"] = err.Error()
+		if handled && job.Attempts < job.MaxAttempts {
+			updates["status"] = JobQueued
+			updates["run_at"] = now.Add(time.Duration(job.Attempts) * 30 * time.Second)
+		} else {
+			updates["status"] = JobFailed
+			updates["finished_at"] = now
+		}
+		return updates
+	}
+	resultJSON, marshalErr := json.Marshal(result)
+	if marshalErr != nil {
+		resultJSON = nil
+	}
+	updates["status"] = JobCompleted
+	updates["result"] = datatypes.JSON(resultJSON)
+	updates["error"] = ""
+	updates["finished_at"] = now
+	return updates
+}
+
+// safeRun executes a handler, converting panics into errors
+func safeRun(handler JobHandler, payload json.RawMessage) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(payload)
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobOutcome(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	failure := errors.New("upstream timeout")
+	cases := []struct {
+		name     string
+		attempts int
+		handled  bool
+		err      error
+		status   string
+		runAt    time.Time
+	}{
+		{"success", 1, true, nil, JobCompleted, time.Time{}},
+		{"first failure retries after 30s", 1, true, failure, JobQueued, now.Add(30 * time.Second)},
+		{"second failure backs off longer", 2, true, failure, JobQueued, now.Add(time.Minute)},
+		{"last attempt fails the job", 3, true, failure, JobFailed, time.Time{}},
+		{"no handler fails at once", 1, false, failure, JobFailed, time.Time{}},
+	}
+	for _, c := range cases {
+		updates := jobOutcome(Job{Attempts: c.attempts, MaxAttempts: 3}, c.handled, map[string]int{"done": 1}, c.err, now)
+		if updates["status"] != c.status {
+			t.Errorf("%s: status %v, want %s", c.name, updates["status"], c.status)
+		}
+		if runAt, _ := updates["run_at"].(time.Time); !runAt.Equal(c.runAt) {
+			t.Errorf("%s: run_at %v, want %v", c.name, runAt, c.runAt)
+		}
+		if _, finished := updates["finished_at"]; finished != (c.status != JobQueued) {
+			t.Errorf("%s: finished_at set = %v", c.name, finished)
+		}
+		if lease, ok := updates["locked_until"]; !ok || lease != nil {
+			t.Errorf("%s: the lease was not released", c.name)
+		}
+	}
+}
+
+func TestJobServiceStopIsIdempotent(t *testing.T) {
+	jobs := NewGormJobService(nil)
+	jobs.Stop()
+	jobs.Stop()
+}
+
+func TestScheduleKey(t *testing.T) {
+	slot := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	key := scheduleKey(0, "sync_orders", slot)
+	for _, other := range []string{
+		scheduleKey(0, "sync_orders", slot.Add(time.Hour)),
+		scheduleKey(4, "sync_orders", slot),
+		scheduleKey(0, "sync_categories", slot),
+	} {
+		if other == key {
+			t.Errorf("%s names two slots", key)
+		}
+	}
+	if scheduleKey(0, "sync_orders", slot.In(time.FixedZone("Tunis", 3600))) != key {
+		t.Error("the key of a slot depends on the time zone")
+	}
+}
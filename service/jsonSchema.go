@@ -0,0 +1,257 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchemaKeywords are the JSON Schema keywords record schemas may use; others are rejected
+// rather than silently ignored
+var jsonSchemaKeywords = map[string]bool{
+	"$schema": true, "$id": true, "title": true, "description": true, "default": true, "examples": true,
+	"type": true, "enum": true, "const": true,
+	"properties": true, "required": true, "additionalProperties": true,
+	"items": true, "minItems": true, "maxItems": true,
+	"minLength": true, "maxLength": true, "pattern": true,
+	"minimum": true, "maximum": true,
+}
+
+// jsonSchemaTypes are the valid values of "type"
+var jsonSchemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true, "integer": true, "boolean": true, "null": true,
+}
+
+// JSONSchema is a compiled subset of JSON Schema: type, enum, const, properties, required,
+// additionalProperties (boolean), items, minItems/maxItems, minLength/maxLength, pattern and minimum/maximum
+type JSONSchema struct {
+	types                []string
+	enum                 []interface{}
+	constValue           *interface{}
+	properties           map[string]*JSONSchema
+	required             []string
+	additionalProperties *bool
+	items                *JSONSchema
+	minItems, maxItems   *int
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+}
+
+// CompileJSONSchema parses a schema document
+func CompileJSONSchema(document []byte) (*JSONSchema, error) {
+	return compileJSONSchema(document, "#")
+}
+
+func compileJSONSchema(document []byte, path string) (*JSONSchema, error) {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(document, &keywords); err != nil {
+		return nil, fmt.Errorf("%s: a schema must be a JSON object: %v", path, err)
+	}
+	schema := &JSONSchema{}
+	for keyword, raw := range keywords {
+		if !jsonSchemaKeywords[keyword] {
+			return nil, fmt.Errorf("%s: unsupported keyword %q", path, keyword)
+		}
+		var err error
+		switch keyword {
+		case "type":
+			var single string
+			if json.Unmarshal(raw, &single) == nil {
+				schema.types = []string{single}
+			} else if err = json.Unmarshal(raw, &schema.types); err != nil {
+				break
+			}
+			for _, name := range schema.types {
+				if !jsonSchemaTypes[name] {
+					err = fmt.Errorf("unknown type %q", name)
+				}
+			}
+		case "enum":
+			err = json.Unmarshal(raw, &schema.enum)
+		case "const":
+			var value interface{}
+			err = json.Unmarshal(raw, &value)
+			schema.constValue = &value
+		case "properties":
+			var properties map[string]json.RawMessage
+			if err = json.Unmarshal(raw, &properties); err != nil {
+				break
+			}
+			schema.properties = make(map[string]*JSONSchema, len(properties))
+			for name, property := range properties {
+				if schema.properties[name], err = compileJSONSchema(property, path+"/properties/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			err = json.Unmarshal(raw, &schema.required)
+		case "additionalProperties":
+			schema.additionalProperties = new(bool)
+			if json.Unmarshal(raw, schema.additionalProperties) != nil {
+				err = fmt.Errorf("only true or false is supported")
+			}
+		case "items":
+			schema.items, err = compileJSONSchema(raw, path+"/items")
+			if err != nil {
+				return nil, err
+			}
+		case "minItems", "maxItems", "minLength", "maxLength":
+			var bound int
+			if err = json.Unmarshal(raw, &bound); err == nil && bound < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+			target := map[string]**int{"minItems": &schema.minItems, "maxItems": &schema.maxItems,
+				"minLength": &schema.minLength, "maxLength": &schema.maxLength}[keyword]
+			*target = &bound
+		case "pattern":
+			var pattern string
+			if err = json.Unmarshal(raw, &pattern); err == nil {
+				schema.pattern, err = regexp.Compile(pattern)
+			}
+		case "minimum", "maximum":
+			var bound float64
+			err = json.Unmarshal(raw, &bound)
+			if keyword == "minimum" {
+				schema.minimum = &bound
+			} else {
+				schema.maximum = &bound
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid %s: %v", path, keyword, err)
+		}
+	}
+	return schema, nil
+}
+
+// Validate checks a decoded JSON value and returns every violation, or nil
+func (s *JSONSchema) Validate(value interface{}) []string {
+	var violations []string
+	s.validate(value, "", &violations)
+	return violations
+}
+
+func (s *JSONSchema) validate(value interface{}, path string, violations *[]string) {
+	fail := func(format string, args ...interface{}) {
+		location := path
+		if location == "" {
+			location = "(root)"
+		}
+		*violations = append(*violations, location+": "+fmt.Sprintf(format, args...))
+	}
+	if len(s.types) > 0 && !jsonTypeMatches(value, s.types) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), jsonTypeOf(value))
+		return
+	}
+	if s.enum != nil {
+		found := false
+		for _, allowed := range s.enum {
+			if jsonEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %v", s.enum)
+		}
+	}
+	if s.constValue != nil && !jsonEqual(value, *s.constValue) {
+		fail("must equal %v", *s.constValue)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := s.properties[name]; ok {
+				property.validate(v[name], path+"/"+name, violations)
+			} else if s.additionalProperties != nil && !*s.additionalProperties {
+				fail("property %q is not allowed", name)
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, fmt.Sprintf("%s/%d", path, i), violations)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			fail("must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %s", s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("must be at least %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("must be at most %v", *s.maximum)
+		}
+	}
+}
+
+// jsonTypeOf names the JSON type of a value decoded by encoding/json
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// jsonTypeMatches reports whether value has one of types; integers are numbers too
+func jsonTypeMatches(value interface{}, types []string) bool {
+	actual := jsonTypeOf(value)
+	for _, expected := range types {
+		if expected == actual || (expected == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEqual compares decoded JSON values
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const issueSchemaV2 = `{
+	"type": "object",
+	"required": ["description", "order_id"],
+	"additionalProperties": false,
+	"properties": {
+		"description": {"type": "string", "minLength": 3},
+		"order_id": {"type": "string", "pattern": "^o-[0-9]+$"},
+		"priority": {"enum": ["low", "high"]},
+		"quantity": {"type": "integer", "minimum": 1},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+	}
+}`
+
+func TestJSONSchemaValidate(t *testing.T) {
+	schema, err := CompileJSONSchema([]byte(issueSchemaV2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		details string
+		want    []string
+	}{
+		{`{"description": "late parcel", "order_id": "o-12", "quantity": 2, "tags": ["delivery"]}`, nil},
+		{`{"description": "late parcel"}`, []string{`missing required property "order_id"`}},
+		{`{"description": "no", "order_id": "12"}`, []string{"/description: must be at least 3", "/order_id: must match"}},
+		{`{"description": "late", "order_id": "o-1", "quantity": 1.5}`, []string{"/quantity: expected integer, got number"}},
+		{`{"description": "late", "order_id": "o-1", "priority": "urgent"}`, []string{"/priority: must be one of"}},
+		{`{"description": "late", "order_id": "o-1", "tags": ["a", 2, "c"]}`, []string{"/tags: must have at most 2", "/tags/1: expected string"}},
+		{`{"description": "late", "order_id": "o-1", "phone": "1"}`, []string{`property "phone" is not allowed`}},
+		{`null`, []string{"(root): expected object, got null"}},
+	}
+	for _, c := range cases {
+		var value interface{}
+		json.Unmarshal([]byte(c.details), &value)
+		got := schema.Validate(value)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: violations %q, want %d", c.details, got, len(c.want))
+			continue
+		}
+		for i, want := range c.want {
+			if !strings.Contains(got[i], want) {
+				t.Errorf("%s: violation %q, want %q", c.details, got[i], want)
+			}
+		}
+	}
+}
+
+func TestCompileJSONSchemaRejectsUnsupported(t *testing.T) {
+	for _, schema := range []string{
+		`[]`,
+		`{"type": "text"}`,
+		`{"oneOf": [{"type": "string"}]}`,
+		`{"properties": {"a": {"format": "email"}}}`,
+		`{"additionalProperties": {"type": "string"}}`,
+		`{"pattern": "("}`,
+		`{"minLength": -1}`,
+	} {
+		if _, err := CompileJSONSchema([]byte(schema)); err == nil {
+			t.Errorf("%s must be rejected", schema)
+		}
+	}
+}
+
+func TestSchemaViolationError(t *testing.T) {
+	err := error(&SchemaViolationError{Type: "issue", Version: 2, Violations: []string{"(root): missing required property \"order_id\""}})
+	if !errors.Is(err, ErrSchemaViolation) || !strings.Contains(err.Error(), "issue v2") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
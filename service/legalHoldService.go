@@ -0,0 +1,123 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LegalHold exempts a customer's records from retention deletion and erasure
+type LegalHold struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	TenantID   uint       `gorm:"not null;default:0;index" json:"tenant_id"`
+	UserID     uint       `gorm:"not null;index;column:user_id" json:"user_id"`
+	Reason     string     `gorm:"not null" json:"reason"`
+	Reference  string     `json:"reference,omitempty"`
+	PlacedBy   string     `json:"placed_by"`
+	PlacedAt   time.Time  `json:"placed_at"`
+	ReleasedBy string     `json:"released_by,omitempty"`
+	ReleasedAt *time.Time `json:"released_at,omitempty"`
+}
+
+// TableName specifies the table name for LegalHold
+func (LegalHold) TableName() string {
+	return "chatbot.legal_holds"
+}
+
+// Active reports whether the hold is still in force
+func (h LegalHold) Active() bool {
+	return h.ReleasedAt == nil
+}
+
+// LegalHoldService defines the interface for legal hold operations; holds belong to a tenant
+type LegalHoldService interface {
+	PlaceHold(tenantID, userID uint, reason, reference, placedBy string) (LegalHold, error)
+	ReleaseHold(tenantID, id uint, releasedBy string) (LegalHold, error)
+	ListHolds(tenantID uint, activeOnly bool) ([]LegalHold, error)
+	IsOnHold(tenantID, userID uint) (bool, error)
+	// HeldUserIDs lists the held customers of every tenant, for the retention run across tenants
+	HeldUserIDs() ([]uint, error)
+}
+
+// GormLegalHoldService implements LegalHoldService using GORM
+type GormLegalHoldService struct {
+	db *gorm.DB
+}
+
+// NewGormLegalHoldService creates a new GormLegalHoldService
+func NewGormLegalHoldService(db *gorm.DB) LegalHoldService {
+	return &GormLegalHoldService{db: db}
+}
+
+// PlaceHold places a new hold on a customer's records
+func (s *GormLegalHoldService) PlaceHold(tenantID, userID uint, reason, reference, placedBy string) (LegalHold, error) {
+	if userID == 0 {
+		return LegalHold{}, fmt.Errorf("user_id is required")
+	}
+	if reason == "" {
+		return LegalHold{}, fmt.Errorf("reason is required")
+	}
+	hold := LegalHold{
+		TenantID:  tenantID,
+		UserID:    userID,
+		Reason:    reason,
+		Reference: reference,
+		PlacedBy:  placedBy,
+		PlacedAt:  time.Now(),
+	}
+	if err := s.db.Create(&hold).Error; err != nil {
+		return LegalHold{}, fmt.Errorf("failed to place legal hold: %v", err)
+	}
+	return hold, nil
+}
+
+// ReleaseHold lifts an active hold of the tenant
+func (s *GormLegalHoldService) ReleaseHold(tenantID, id uint, releasedBy string) (LegalHold, error) {
+	var hold LegalHold
+	if err := s.db.Where("tenant_id = ?", tenantID).First(&hold, id).Error; err != nil {
+		return LegalHold{}, fmt.Errorf("legal hold with ID %d not found: %v", id, err)
+	}
+	if !hold.Active() {
+		return LegalHold{}, fmt.Errorf("legal hold %d was already released at %v", id, hold.ReleasedAt)
+	}
+	now := time.Now()
+	hold.ReleasedAt = &now
+	hold.ReleasedBy = releasedBy
+	if err := s.db.Model(&hold).Updates(map[string]interface{}{"released_at": now, "released_by": releasedBy}).Error; err != nil {
+		return LegalHold{}, fmt.Errorf("failed to release legal hold: %v", err)
+	}
+	return hold, nil
+}
+
+// ListHolds fetches the tenant's holds, optionally only the active ones
+func (s *GormLegalHoldService) ListHolds(tenantID uint, activeOnly bool) ([]LegalHold, error) {
+	var holds []LegalHold
+	query := s.db.Where("tenant_id = ?", tenantID).Order("placed_at desc")
+	if activeOnly {
+		query = query.Where("released_at IS NULL")
+	}
+	if err := query.Find(&holds).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch legal holds: %v", err)
+	}
+	return holds, nil
+}
+
+// IsOnHold reports whether a customer of the tenant has any active hold
+func (s *GormLegalHoldService) IsOnHold(tenantID, userID uint) (bool, error) {
+	var count int64
+	if err := s.db.Model(&LegalHold{}).Where("tenant_id = ? AND user_id = ? AND released_at IS NULL", tenantID, userID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check legal hold: %v", err)
+	}
+	return count > 0, nil
+}
+
+// HeldUserIDs lists customers with an active hold; retention and erasure jobs must skip them. Retention
+// runs over all tenants at once, so a hold also spares the same user ID in the other tenants.
+func (s *GormLegalHoldService) HeldUserIDs() ([]uint, error) {
+	var ids []uint
+	if err := s.db.Model(&LegalHold{}).Where("released_at IS NULL").Distinct().Pluck("user_id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch held customers: %v", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlaceHoldRequiresCustomerAndReason(t *testing.T) {
+	holds := &GormLegalHoldService{}
+	for _, c := range []struct {
+		userID uint
+		reason string
+		want   string
+	}{
+		{0, "litigation", "user_id is required"},
+		{7, "", "reason is required"},
+	} {
+		if _, err := holds.PlaceHold(1, c.userID, c.reason, "", "admin"); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("PlaceHold(%d, %q) = %v, want %q", c.userID, c.reason, err, c.want)
+		}
+	}
+}
+
+func TestLegalHoldActive(t *testing.T) {
+	released := time.Now()
+	if !(LegalHold{}).Active() {
+		t.Error("a hold without release time is active")
+	}
+	if (LegalHold{ReleasedAt: &released}).Active() {
+		t.Error("a released hold is not active")
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoginFailure counts the failed password logins of an account or a client address. Once Failures
+// reaches the limit of its key within the window, further attempts are refused until LockedUntil.
+type LoginFailure struct {
+	Key         string     `gorm:"primaryKey;size:160" json:"key"`
+	Failures    int        `gorm:"not null" json:"failures"`
+	WindowStart time.Time  `gorm:"not null" json:"window_start"`
+	LockedUntil *time.Time `gorm:"index" json:"locked_until,omitempty"`
+}
+
+// TableName specifies the table name for LoginFailure
+func (LoginFailure) TableName() string {
+	return "public.login_failures"
+}
+
+// LoginLimit is how many failures a throttle key may have within the window
+type LoginLimit struct {
+	Key string
+	Max int
+}
+
+// LoginThrottle refuses password logins after repeated failures, per account and per client address
+type LoginThrottle interface {
+	// Blocked returns how long until the longest locked of keys may try again, zero when none is locked
+	Blocked(keys ...string) (time.Duration, error)
+	// Fail counts a failed attempt against every limit, locking the keys that reach theirs
+	Fail(limits ...LoginLimit) error
+	// Reset forgets the failures of key, e.g. an account that logged in
+	Reset(key string) error
+	// Prune deletes the failures that are neither locked nor in their window at now
+	Prune(now time.Time) (int64, error)
+}
+
+// GormLoginThrottle implements LoginThrottle using GORM, so every instance shares the counts
+type GormLoginThrottle struct {
+	db *gorm.DB
+	// window is how long failures count, and lockout how long a key that reached its limit is refused
+	window, lockout time.Duration
+}
+
+// NewGormLoginThrottle creates a new GormLoginThrottle
+func NewGormLoginThrottle(db *gorm.DB, window, lockout time.Duration) LoginThrottle {
+	return &GormLoginThrottle{db: db, window: window, lockout: lockout}
+}
+
+// Blocked returns how long until the longest locked of keys may try again
+func (s *GormLoginThrottle) Blocked(keys ...string) (time.Duration, error) {
+	now := time.Now()
+	var locked []LoginFailure
+	if err := s.db.Where("key IN ? AND locked_until > ?", keys, now).Find(&locked).Error; err != nil {
+		return 0, fmt.Errorf("failed to check login failures: %v", err)
+	}
+	var wait time.Duration
+	for _, failure := range locked {
+		if until := failure.LockedUntil.Sub(now); until > wait {
+			wait = until
+		}
+	}
+	return wait, nil
+}
+
+// Fail counts a failed attempt in one statement per key, restarting the count when its window passed
+func (s *GormLoginThrottle) Fail(limits ...LoginLimit) error {
+	now := time.Now()
+	expired := now.Add(-s.window)
+	lockedUntil := now.Add(s.lockout)
+	for _, limit := range limits {
+		err := s.db.Exec(`INSERT INTO public.login_failures (key, failures, window_start, locked_until)
+			VALUES (?, 1, ?, CASE WHEN 1 >= ? THEN ?::timestamptz END)
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN login_failures.window_start < ? THEN 1 ELSE login_failures.failures + 1 END,
+				window_start = CASE WHEN login_failures.window_start < ? THEN EXCLUDED.window_start ELSE login_failures.window_start END,
+				locked_until = CASE WHEN (CASE WHEN login_failures.window_start < ? THEN 1 ELSE login_failures.failures + 1 END) >= ?
+					THEN ?::timestamptz ELSE login_failures.locked_until END`,
+			limit.Key, now, limit.Max, lockedUntil, expired, expired, expired, limit.Max, lockedUntil).Error
+		if err != nil {
+			return fmt.Errorf("failed to count login failure: %v", err)
+		}
+	}
+	return nil
+}
+
+// Reset forgets the failures of key
+func (s *GormLoginThrottle) Reset(key string) error {
+	if err := s.db.Where("key = ?", key).Delete(&LoginFailure{}).Error; err != nil {
+		return fmt.Errorf("failed to reset login failures: %v", err)
+	}
+	return nil
+}
+
+// Prune deletes the failures that no longer count
+func (s *GormLoginThrottle) Prune(now time.Time) (int64, error) {
+	result := s.db.Where("window_start < ? AND (locked_until IS NULL OR locked_until <= ?)", now.Add(-s.window), now).Delete(&LoginFailure{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to prune login failures: %v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,396 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Loyalty entry kinds
+const (
+	LoyaltyAccrual    = "accrual"
+	LoyaltyRedemption = "redemption"
+)
+
+// OrderDelivered is the Converty status of a delivered order; only those earn points
+const OrderDelivered = "delivered"
+
+// LoyaltyRules configures how points are earned and redeemed
+type LoyaltyRules struct {
+	// PointsPerUnit is the points earned per currency unit spent
+	PointsPerUnit float64
+	// CategoryRates overrides PointsPerUnit for products of a category
+	CategoryRates map[string]float64
+	// PointValue is the store credit one point is worth
+	PointValue float64
+	// MinOrderTotal is the smallest order that earns points
+	MinOrderTotal float64
+	// MaxPointsPerOrder caps what a single order can earn; 0 means no cap
+	MaxPointsPerOrder int
+	// MaxAccrualsPerDay limits how many orders per customer earn points in 24 hours; 0 means no limit
+	MaxAccrualsPerDay int
+	// MinRedeemPoints is the smallest redemption allowed
+	MinRedeemPoints int
+	// RedeemCooldown is the minimum time between two redemptions of a customer
+	RedeemCooldown time.Duration
+}
+
+// DefaultLoyaltyRules earns one point per unit and values a point at 0.01
+var DefaultLoyaltyRules = LoyaltyRules{
+	PointsPerUnit:     1,
+	CategoryRates:     map[string]float64{},
+	PointValue:        0.01,
+	MinOrderTotal:     10,
+	MaxPointsPerOrder: 1000,
+	MaxAccrualsPerDay: 3,
+	MinRedeemPoints:   100,
+	RedeemCooldown:    24 * time.Hour,
+}
+
+// ParseCategoryRates parses "category=rate,..." into per-category point rates
+func ParseCategoryRates(spec string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		category, value, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(category) == "" {
+			return nil, fmt.Errorf("invalid category rate %q, expected category=rate", entry)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid category rate value in %q", entry)
+		}
+		rates[strings.ToLower(strings.TrimSpace(category))] = rate
+	}
+	return rates, nil
+}
+
+// PointsFor computes the points an order earns under the rules, before anti-abuse checks
+func (r LoyaltyRules) PointsFor(order Order) int {
+	if order.Total < r.MinOrderTotal {
+		return 0
+	}
+	var points float64
+	if len(order.Items) == 0 {
+		points = order.Total * r.PointsPerUnit
+	} else {
+		for _, line := range order.Items {
+			rate, ok := r.CategoryRates[strings.ToLower(line.Category)]
+			if !ok {
+				rate = r.PointsPerUnit
+			}
+			points += line.Price * float64(line.Quantity) * rate
+		}
+	}
+	earned := int(math.Floor(points))
+	if r.MaxPointsPerOrder > 0 && earned > r.MaxPointsPerOrder {
+		earned = r.MaxPointsPerOrder
+	}
+	return earned
+}
+
+// LoyaltyEntry records points earned from an order or spent on a redemption
+type LoyaltyEntry struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	TenantID      uint      `gorm:"not null;default:0;index" json:"tenant_id"`
+	CustomerPhone string    `gorm:"not null;index" json:"customer_phone"`
+	Kind          string    `gorm:"not null" json:"kind"`
+	Points        int       `gorm:"not null" json:"points"`
+	OrderID       string    `gorm:"index" json:"order_id,omitempty"`
+	Credit        float64   `gorm:"type:numeric(14,3)" json:"credit,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for LoyaltyEntry
+func (LoyaltyEntry) TableName() string {
+	return "chatbot.loyalty_entries"
+}
+
+// loyaltyAccrualPageSize is how many delivered orders an accrual run reads per page
+const loyaltyAccrualPageSize = 50
+
+// ErrAccrualCapped is returned for the orders of a customer who reached the daily accrual limit;
+// AccrueDelivered defers them to a later run
+var ErrAccrualCapped = errors.New("daily accrual limit reached")
+
+// LoyaltyScanCursor is the page of delivered orders the next accrual run of a tenant starts from, so
+// successive runs walk the whole listing rather than the same first pages
+type LoyaltyScanCursor struct {
+	TenantID  uint      `gorm:"primaryKey;autoIncrement:false" json:"tenant_id"`
+	Page      int       `gorm:"not null" json:"page"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for LoyaltyScanCursor
+func (LoyaltyScanCursor) TableName() string {
+	return "chatbot.loyalty_scan_cursors"
+}
+
+// LoyaltyDeferral is a delivered order held back by the daily accrual limit, accrued by a later run
+type LoyaltyDeferral struct {
+	TenantID  uint      `gorm:"primaryKey;autoIncrement:false" json:"tenant_id"`
+	OrderID   string    `gorm:"primaryKey" json:"order_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for LoyaltyDeferral
+func (LoyaltyDeferral) TableName() string {
+	return "chatbot.loyalty_deferrals"
+}
+
+// LoyaltyBalance is a customer's points and what they are worth
+type LoyaltyBalance struct {
+	CustomerPhone string         `json:"customer_phone"`
+	Points        int            `json:"points"`
+	Value         float64        `json:"value"`
+	Entries       []LoyaltyEntry `json:"entries,omitempty"`
+}
+
+// LoyaltyService defines the interface for loyalty points, kept per tenant
+type LoyaltyService interface {
+	Rules() LoyaltyRules
+	AccrueOrder(tenantID uint, order Order) (LoyaltyEntry, error)
+	// AccrueDelivered walks the delivered orders of the tenant dataService is scoped to
+	AccrueDelivered(tenantID uint, dataService DataService, maxPages int) (int, error)
+	Balance(tenantID uint, phone string, withEntries bool) (LoyaltyBalance, error)
+	Redeem(tenantID uint, phone string, points int) (LoyaltyBalance, WalletBalance, error)
+}
+
+// GormLoyaltyService implements LoyaltyService using GORM; redemptions become wallet store credit
+type GormLoyaltyService struct {
+	db            *gorm.DB
+	walletService WalletService
+	rules         LoyaltyRules
+}
+
+// NewGormLoyaltyService creates a new GormLoyaltyService
+func NewGormLoyaltyService(db *gorm.DB, walletService WalletService, rules LoyaltyRules) LoyaltyService {
+	return &GormLoyaltyService{db: db, walletService: walletService, rules: rules}
+}
+
+// Rules returns the active loyalty rules
+func (s *GormLoyaltyService) Rules() LoyaltyRules {
+	return s.rules
+}
+
+// AccrueOrder credits the points of a delivered order once; orders failing the anti-abuse checks earn nothing
+func (s *GormLoyaltyService) AccrueOrder(tenantID uint, order Order) (LoyaltyEntry, error) {
+	if !strings.EqualFold(order.Status, OrderDelivered) {
+		return LoyaltyEntry{}, fmt.Errorf("order %s is %s, only delivered orders earn points", order.ID, order.Status)
+	}
+	phone := NormalizePhone(order.Customer.Phone)
+	if phone == "" {
+		return LoyaltyEntry{}, fmt.Errorf("order %s has no customer phone", order.ID)
+	}
+	points := s.rules.PointsFor(order)
+	if points <= 0 {
+		return LoyaltyEntry{}, fmt.Errorf("order %s does not earn points", order.ID)
+	}
+
+	entry := LoyaltyEntry{TenantID: tenantID, CustomerPhone: phone, Kind: LoyaltyAccrual, Points: points, OrderID: order.ID}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockWallet(tx, tenantID, "loyalty:"+phone); err != nil {
+			return err
+		}
+		var existing int64
+		err := tx.Model(&LoyaltyEntry{}).Where("tenant_id = ? AND order_id = ? AND kind = ?", tenantID, order.ID, LoyaltyAccrual).Count(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing > 0 {
+			return fmt.Errorf("order %s already earned points", order.ID)
+		}
+		if s.rules.MaxAccrualsPerDay > 0 {
+			var recent int64
+			if err := tx.Model(&LoyaltyEntry{}).Where("tenant_id = ? AND customer_phone = ? AND kind = ? AND created_at > ?",
+				tenantID, phone, LoyaltyAccrual, time.Now().Add(-24*time.Hour)).Count(&recent).Error; err != nil {
+				return err
+			}
+			if int(recent) >= s.rules.MaxAccrualsPerDay {
+				return fmt.Errorf("%w: %s reached the limit of %d rewarded orders per day", ErrAccrualCapped, phone, s.rules.MaxAccrualsPerDay)
+			}
+		}
+		return tx.Create(&entry).Error
+	})
+	if err != nil {
+		return LoyaltyEntry{}, fmt.Errorf("failed to accrue points: %w", err)
+	}
+	return entry, nil
+}
+
+// AccrueDelivered accrues the orders deferred by the daily limit, then walks up to maxPages pages of
+// delivered Converty orders from where the previous run stopped, wrapping around after the last page.
+// It returns how many orders earned points.
+func (s *GormLoyaltyService) AccrueDelivered(tenantID uint, dataService DataService, maxPages int) (int, error) {
+	accrued, err := s.accrueDeferred(tenantID, dataService)
+	if err != nil {
+		return accrued, err
+	}
+
+	cursor := LoyaltyScanCursor{TenantID: tenantID, Page: 1}
+	if err := s.db.Where("tenant_id = ?", tenantID).Limit(1).Find(&cursor).Error; err != nil {
+		return accrued, fmt.Errorf("failed to read the accrual cursor: %v", err)
+	}
+	query := CustomerOrderQuery{Limit: loyaltyAccrualPageSize, Status: OrderDelivered, Page: max(cursor.Page, 1)}
+	var scanErr error
+	for scanned := 0; scanned < maxPages; scanned++ {
+		orders, err := dataService.ListOrders(query)
+		if err != nil {
+			scanErr = fmt.Errorf("failed to fetch delivered orders: %v", err)
+			break
+		}
+		for _, order := range orders {
+			if s.accrueScanned(tenantID, order) {
+				accrued++
+			}
+		}
+		if len(orders) < query.Limit {
+			query.Page = 1
+			break
+		}
+		query.Page++
+	}
+	cursor.Page = query.Page
+	if err := s.db.Save(&cursor).Error; err != nil && scanErr == nil {
+		scanErr = fmt.Errorf("failed to save the accrual cursor: %v", err)
+	}
+	return accrued, scanErr
+}
+
+// accrueScanned accrues a delivered order met by the scan unless it earned points already, deferring
+// it when its customer reached the daily limit
+func (s *GormLoyaltyService) accrueScanned(tenantID uint, order Order) bool {
+	var existing int64
+	err := s.db.Model(&LoyaltyEntry{}).Where("tenant_id = ? AND order_id = ? AND kind = ?", tenantID, order.ID, LoyaltyAccrual).Count(&existing).Error
+	if err != nil || existing > 0 {
+		return false
+	}
+	_, err = s.AccrueOrder(tenantID, order)
+	if errors.Is(err, ErrAccrualCapped) {
+		deferral := LoyaltyDeferral{TenantID: tenantID, OrderID: order.ID}
+		if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&deferral).Error; err != nil {
+			log.Printf("Loyalty accrual: failed to defer order %s: %v", order.ID, err)
+		}
+	}
+	return err == nil
+}
+
+// accrueDeferred retries the orders deferred by the daily limit; the ones still capped stay deferred
+func (s *GormLoyaltyService) accrueDeferred(tenantID uint, dataService DataService) (int, error) {
+	var deferred []LoyaltyDeferral
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("created_at").Find(&deferred).Error; err != nil {
+		return 0, fmt.Errorf("failed to read deferred accruals: %v", err)
+	}
+	accrued := 0
+	for _, deferral := range deferred {
+		order, err := dataService.GetOrder(deferral.OrderID)
+		if err != nil {
+			log.Printf("Loyalty accrual: deferred order %s: %v", deferral.OrderID, err)
+			continue
+		}
+		_, err = s.AccrueOrder(tenantID, order)
+		if errors.Is(err, ErrAccrualCapped) {
+			continue
+		}
+		if err == nil {
+			accrued++
+		}
+		// Accrued, or no longer eligible: either way it leaves the deferrals
+		if err := s.db.Delete(&deferral).Error; err != nil {
+			return accrued, fmt.Errorf("failed to clear deferred accrual: %v", err)
+		}
+	}
+	return accrued, nil
+}
+
+func pointsOf(tx *gorm.DB, tenantID uint, phone string) (int, error) {
+	var points int
+	err := tx.Model(&LoyaltyEntry{}).Where("tenant_id = ? AND customer_phone = ?", tenantID, phone).Select("COALESCE(SUM(points), 0)").Scan(&points).Error
+	return points, err
+}
+
+// Balance returns a customer's points, optionally with their history
+func (s *GormLoyaltyService) Balance(tenantID uint, phone string, withEntries bool) (LoyaltyBalance, error) {
+	phone = NormalizePhone(phone)
+	points, err := pointsOf(s.db, tenantID, phone)
+	if err != nil {
+		return LoyaltyBalance{}, fmt.Errorf("failed to compute loyalty balance: %v", err)
+	}
+	balance := LoyaltyBalance{CustomerPhone: phone, Points: points, Value: roundMillimes(float64(points) * s.rules.PointValue)}
+	if withEntries {
+		if err := s.db.Where("tenant_id = ? AND customer_phone = ?", tenantID, phone).Order("created_at desc").Find(&balance.Entries).Error; err != nil {
+			return LoyaltyBalance{}, fmt.Errorf("failed to fetch loyalty entries: %v", err)
+		}
+	}
+	return balance, nil
+}
+
+// Redeem converts points into wallet store credit the customer can apply to their next order
+func (s *GormLoyaltyService) Redeem(tenantID uint, phone string, points int) (LoyaltyBalance, WalletBalance, error) {
+	phone = NormalizePhone(phone)
+	if points < s.rules.MinRedeemPoints || points <= 0 {
+		return LoyaltyBalance{}, WalletBalance{}, fmt.Errorf("at least %d points must be redeemed", s.rules.MinRedeemPoints)
+	}
+	credit := roundMillimes(float64(points) * s.rules.PointValue)
+
+	redemption := LoyaltyEntry{TenantID: tenantID, CustomerPhone: phone, Kind: LoyaltyRedemption, Points: -points, Credit: credit}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockWallet(tx, tenantID, "loyalty:"+phone); err != nil {
+			return err
+		}
+		// The balance and cooldown checks and the debit are one statement: it inserts nothing when
+		// the customer lacks the points or redeemed within the cooldown
+		now := time.Now()
+		redemption.CreatedAt = now
+		result := tx.Raw(`INSERT INTO chatbot.loyalty_entries (tenant_id, customer_phone, kind, points, order_id, credit, created_at)
+			SELECT ?, ?, ?, ?, '', ?, ?
+			WHERE (SELECT COALESCE(SUM(points), 0) FROM chatbot.loyalty_entries WHERE tenant_id = ? AND customer_phone = ?) >= ?
+				AND NOT EXISTS (SELECT 1 FROM chatbot.loyalty_entries
+					WHERE tenant_id = ? AND customer_phone = ? AND kind = ? AND created_at > ?)
+			RETURNING id`,
+			tenantID, phone, LoyaltyRedemption, -points, credit, now,
+			tenantID, phone, points,
+			tenantID, phone, LoyaltyRedemption, now.Add(-s.rules.RedeemCooldown)).Scan(&redemption.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if redemption.ID != 0 {
+			return nil
+		}
+		available, err := pointsOf(tx, tenantID, phone)
+		if err != nil {
+			return err
+		}
+		if available < points {
+			return fmt.Errorf("%s has only %d points", phone, available)
+		}
+		return fmt.Errorf("%s already redeemed points in the last %v", phone, s.rules.RedeemCooldown)
+	})
+	if err != nil {
+		return LoyaltyBalance{}, WalletBalance{}, fmt.Errorf("failed to redeem points: %v", err)
+	}
+
+	wallet, err := s.walletService.Credit(tenantID, phone, WalletLoyaltyCredit, credit, "", fmt.Sprintf("Redeemed %d loyalty points", points), "loyalty")
+	if err != nil {
+		// Give the points back so the customer is not charged for credit they never got
+		if undoErr := s.db.Delete(&redemption).Error; undoErr != nil {
+			return LoyaltyBalance{}, WalletBalance{}, fmt.Errorf("%v (and failed to restore points: %v)", err, undoErr)
+		}
+		return LoyaltyBalance{}, WalletBalance{}, err
+	}
+	balance, err := s.Balance(tenantID, phone, false)
+	if err != nil {
+		return LoyaltyBalance{}, WalletBalance{}, err
+	}
+	return balance, wallet, nil
+}
@@ -0,0 +1,43 @@
+package service
+
+import "testing"
+
+func TestPointsFor(t *testing.T) {
+	rules := LoyaltyRules{
+		PointsPerUnit:     1,
+		CategoryRates:     map[string]float64{"electronics": 0.5},
+		MinOrderTotal:     10,
+		MaxPointsPerOrder: 100,
+	}
+	cases := []struct {
+		name  string
+		order Order
+		want  int
+	}{
+		{"below minimum", Order{Total: 9.9}, 0},
+		{"no items uses total", Order{Total: 42.7}, 42},
+		{"category rate", Order{Total: 60, Items: []OrderLine{
+			{Category: "Electronics", Quantity: 1, Price: 40},
+			{Category: "books", Quantity: 2, Price: 10},
+		}}, 40},
+		{"capped", Order{Total: 500}, 100},
+	}
+	for _, c := range cases {
+		if got := rules.PointsFor(c.order); got != c.want {
+			t.Errorf("%s: PointsFor = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseCategoryRates(t *testing.T) {
+	rates, err := ParseCategoryRates("Shoes=2, electronics=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rates["shoes"] != 2 || rates["electronics"] != 0.5 {
+		t.Errorf("unexpected rates %v", rates)
+	}
+	if _, err := ParseCategoryRates("shoes"); err == nil {
+		t.Error("expected an error for a missing rate")
+	}
+}
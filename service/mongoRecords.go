@@ -0,0 +1,851 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// ErrStoreUnsupported is returned when the configured record store cannot answer a call, such as the
+// full-text search of a MongoDB store
+var ErrStoreUnsupported = errors.New("not supported by the record store")
+
+// errMongoStale rolls back the outbox events of a conditional change that another change came before
+var errMongoStale = errors.New("the record changed before the update")
+
+// Collections of the MongoDB record store
+const (
+	mongoRecordsCollection  = "interactions"
+	mongoCountersCollection = "counters"
+)
+
+// mongoRecord is a record as MongoDB stores it: the details are a nested document rather than JSON
+// text, and the status history is kept inside the record
+type mongoRecord struct {
+	ID            uint                `bson:"_id"`
+	TenantID      uint                `bson:"tenant_id"`
+	UserID        uint                `bson:"user_id"`
+	Type          string              `bson:"type"`
+	Details       bson.Raw            `bson:"details,omitempty"`
+	Status        string              `bson:"status"`
+	CreatedAt     time.Time           `bson:"created_at"`
+	UpdatedAt     time.Time           `bson:"updated_at"`
+	ArchivedAt    *time.Time          `bson:"archived_at"`
+	DeletedAt     *time.Time          `bson:"deleted_at"`
+	AnonymizedAt  *time.Time          `bson:"anonymized_at,omitempty"`
+	Revision      int                 `bson:"revision"`
+	SchemaVersion int                 `bson:"schema_version"`
+	ContentHash   string              `bson:"content_hash,omitempty"`
+	PIIHashes     []mongoPIIHash      `bson:"pii_hashes,omitempty"`
+	History       []mongoStatusChange `bson:"history,omitempty"`
+}
+
+// mongoPIIHash is the lookup hash of one encrypted detail field; paths may contain dots, so the
+// hashes are a list rather than a document keyed by path
+type mongoPIIHash struct {
+	Path string `bson:"path"`
+	Hash string `bson:"hash"`
+}
+
+// mongoStatusChange is one entry of the status history embedded in a record
+type mongoStatusChange struct {
+	FromStatus string    `bson:"from_status"`
+	ToStatus   string    `bson:"to_status"`
+	Actor      string    `bson:"actor"`
+	ChangedAt  time.Time `bson:"changed_at"`
+}
+
+// MongoDataService keeps the chatbot interactions in MongoDB and reaches Converty through the
+// DataService it wraps. The retention job anonymizes and deletes them through the store (see
+// mongoRetention.go); features joining the records to other tables in SQL (tags, customer merges,
+// digests and the warehouse export) still read PostgreSQL and do not see them. Record versions are
+// not kept: status changes of append-only types are not supported.
+type MongoDataService struct {
+	ConvertyRepository
+	// upstream is the DataService scoped along with this one, for the Converty calls
+	upstream DataService
+	records  *mongo.Collection
+	counters *mongo.Collection
+	// db receives the outbox events of record changes
+	db *gorm.DB
+	recordIntake
+	tenant *Tenant
+	ctx    context.Context
+}
+
+// NewMongoDataService creates a MongoDataService on database, making sure its indexes exist. upstream
+// answers the Converty calls and db receives the outbox events.
+func NewMongoDataService(database *mongo.Database, upstream DataService, db *gorm.DB, opts DataServiceOptions) (DataService, error) {
+	s := &MongoDataService{
+		ConvertyRepository: upstream,
+		upstream:           upstream,
+		records:            database.Collection(mongoRecordsCollection),
+		counters:           database.Collection(mongoCountersCollection),
+		db:                 db,
+		recordIntake:       newRecordIntake(opts),
+	}
+	_, err := s.records.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "type", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "content_hash", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "pii_hashes.path", Value: 1}, {Key: "pii_hashes.hash", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create record indexes: %v", err)
+	}
+	return s, nil
+}
+
+// ForTenant returns a DataService restricted to the tenant's records and Converty store
+func (s *MongoDataService) ForTenant(tenant Tenant) DataService {
+	scoped := *s
+	scoped.tenant = &tenant
+	scoped.upstream = s.upstream.ForTenant(tenant)
+	scoped.ConvertyRepository = scoped.upstream
+	return &scoped
+}
+
+// WithPriority returns a DataService whose Converty calls are ranked with priority
+func (s *MongoDataService) WithPriority(priority UpstreamPriority) DataService {
+	ranked := *s
+	ranked.upstream = s.upstream.WithPriority(priority)
+	ranked.ConvertyRepository = ranked.upstream
+	return &ranked
+}
+
+// WithContext returns a DataService whose MongoDB commands and Converty calls belong to the trace of
+// ctx; as with GormDataService a cancelled request does not abort work in flight
+func (s *MongoDataService) WithContext(ctx context.Context) DataService {
+	traced := *s
+	traced.ctx = context.WithoutCancel(ctx)
+	traced.db = s.db.WithContext(traced.ctx)
+	traced.upstream = s.upstream.WithContext(ctx)
+	traced.ConvertyRepository = traced.upstream
+	return &traced
+}
+
+// context is the context of the MongoDB commands
+func (s *MongoDataService) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// tenantID is the tenant of inserted records
+func (s *MongoDataService) tenantID() uint {
+	if s.tenant == nil {
+		return 0
+	}
+	return s.tenant.ID
+}
+
+// scope restricts conditions to the service tenant and, unless deleted is set, to records not soft-deleted
+func (s *MongoDataService) scope(conditions bson.A, deleted bool) bson.M {
+	if !deleted {
+		conditions = append(bson.A{bson.M{"deleted_at": nil}}, conditions...)
+	}
+	if s.tenant != nil {
+		conditions = append(bson.A{bson.M{"tenant_id": s.tenant.ID}}, conditions...)
+	}
+	if len(conditions) == 0 {
+		return bson.M{}
+	}
+	return bson.M{"$and": conditions}
+}
+
+// mongoRecordConditions translates a RecordFilter into MongoDB query conditions. Tags live in
+// PostgreSQL, so a filter on them is ErrStoreUnsupported.
+func mongoRecordConditions(filter RecordFilter) (bson.A, error) {
+	var conditions bson.A
+	if len(filter.Tags) > 0 {
+		return nil, fmt.Errorf("tag filters: %w", ErrStoreUnsupported)
+	}
+	if !filter.IncludeArchived {
+		conditions = append(conditions, bson.M{"archived_at": nil})
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, bson.M{"type": filter.Type})
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, bson.M{"status": filter.Status})
+	}
+	if filter.UserID != nil {
+		conditions = append(conditions, bson.M{"user_id": *filter.UserID})
+	}
+	if filter.From != nil {
+		conditions = append(conditions, bson.M{"created_at": bson.M{"$gte": *filter.From}})
+	}
+	if filter.To != nil {
+		conditions = append(conditions, bson.M{"created_at": bson.M{"$lt": *filter.To}})
+	}
+	if filter.Category != "" {
+		conditions = append(conditions, bson.M{"details.category": bson.M{"$regex": "^" + regexp.QuoteMeta(filter.Category) + "$", "$options": "i"}})
+	}
+	if filter.Text != "" {
+		conditions = append(conditions, mongoTextCondition(filter.Text))
+	}
+	return conditions, nil
+}
+
+// mongoTextCondition searches the details like the PostgreSQL store: "key=value" matches one field,
+// through its lookup hash when the field is encrypted, and anything else matches any top-level value
+func mongoTextCondition(text string) bson.M {
+	if key, value, ok := cutFilterText(text); ok {
+		contains := bson.M{"details." + key: bson.M{"$regex": regexp.QuoteMeta(value), "$options": "i"}}
+		if PII.Protects(key) {
+			return bson.M{"$or": bson.A{contains, bson.M{"pii_hashes": bson.M{"$elemMatch": bson.M{"path": key, "hash": bson.M{"$in": PII.LookupHashes(key, value)}}}}}}
+		}
+		return contains
+	}
+	return bson.M{"$expr": bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+		"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$details", bson.M{}}}},
+		"in": bson.M{"$regexMatch": bson.M{
+			"input":   bson.M{"$convert": bson.M{"input": "$$this.v", "to": "string", "onError": "", "onNull": ""}},
+			"regex":   regexp.QuoteMeta(text),
+			"options": "i",
+		}},
+	}}}}}
+}
+
+// find fetches the records matching query, decrypted
+func (s *MongoDataService) find(query bson.M, opts *options.FindOptions) ([]Data, error) {
+	cursor, err := s.records.Find(s.context(), query, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []mongoRecord
+	if err := cursor.All(s.context(), &docs); err != nil {
+		return nil, err
+	}
+	records := make([]Data, 0, len(docs))
+	for _, doc := range docs {
+		record, err := doc.toData()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// findOne fetches the record matching query, wrapping ErrNotFound when there is none
+func (s *MongoDataService) findOne(id uint, query bson.M) (Data, error) {
+	var doc mongoRecord
+	if err := s.records.FindOne(s.context(), query).Decode(&doc); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Data{}, fmt.Errorf("record with ID %d: %w", id, ErrNotFound)
+		}
+		return Data{}, fmt.Errorf("failed to fetch record %d: %v", id, err)
+	}
+	return doc.toData()
+}
+
+// ListRecords fetches all non-archived records
+func (s *MongoDataService) ListRecords() ([]Data, error) {
+	records, err := s.find(s.scope(bson.A{bson.M{"archived_at": nil}}, false), options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch records: %v", err)
+	}
+	return records, nil
+}
+
+// SearchRecords fetches records matching the filter, newest first
+func (s *MongoDataService) SearchRecords(filter RecordFilter) ([]Data, error) {
+	conditions, err := mongoRecordConditions(filter)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.find(s.scope(conditions, false), options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search records: %v", err)
+	}
+	return records, nil
+}
+
+// PageRecords fetches one page of the records matching the filter, ordered by ID, and the total match count
+func (s *MongoDataService) PageRecords(filter RecordFilter, offset, limit int) ([]Data, int64, error) {
+	conditions, err := mongoRecordConditions(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	query := s.scope(conditions, false)
+	total, err := s.records.CountDocuments(s.context(), query)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count records: %v", err)
+	}
+	records, err := s.find(query, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetSkip(int64(offset)).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch records: %v", err)
+	}
+	return records, total, nil
+}
+
+// FullTextSearch relies on the PostgreSQL search vectors and is not supported
+func (s *MongoDataService) FullTextSearch(text string, filter RecordFilter, offset, limit int) ([]RecordMatch, int64, error) {
+	return nil, 0, fmt.Errorf("full-text search: %w", ErrStoreUnsupported)
+}
+
+// QueryByID fetches a record by ID
+func (s *MongoDataService) QueryByID(id uint) (Data, error) {
+	return s.findOne(id, s.scope(bson.A{bson.M{"_id": id}}, false))
+}
+
+// QueryByIDs fetches several records in a single query, ordered by ID
+func (s *MongoDataService) QueryByIDs(ids []uint) ([]Data, error) {
+	records, err := s.find(s.scope(bson.A{bson.M{"_id": bson.M{"$in": ids}}}, false), options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch records: %v", err)
+	}
+	return records, nil
+}
+
+// RecordsLastModified returns when any record of the tenant was last created, changed, archived or
+// deleted; it is zero without records
+func (s *MongoDataService) RecordsLastModified() (time.Time, error) {
+	cursor, err := s.records.Aggregate(s.context(), mongo.Pipeline{
+		{{Key: "$match", Value: s.scope(nil, true)}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      nil,
+			"modified": bson.M{"$max": bson.M{"$max": bson.A{"$created_at", "$updated_at", "$archived_at", "$deleted_at"}}},
+		}}},
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read record modification time: %v", err)
+	}
+	var result []struct {
+		Modified time.Time `bson:"modified"`
+	}
+	if err := cursor.All(s.context(), &result); err != nil {
+		return time.Time{}, fmt.Errorf("failed to read record modification time: %v", err)
+	}
+	if len(result) == 0 {
+		return time.Time{}, nil
+	}
+	return result[0].Modified, nil
+}
+
+// nextID draws the ID of a new record from the counters collection, so IDs stay numeric as in PostgreSQL
+func (s *MongoDataService) nextID() (uint, error) {
+	var counter struct {
+		Seq uint `bson:"seq"`
+	}
+	err := s.counters.FindOneAndUpdate(s.context(), bson.M{"_id": mongoRecordsCollection}, bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&counter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate record ID: %v", err)
+	}
+	return counter.Seq, nil
+}
+
+// InsertRecord inserts a new record. The duplicate check is not atomic with the insert: two identical
+// records arriving at the same instant may both be stored.
+func (s *MongoDataService) InsertRecord(userID uint, dataType string, details map[string]interface{}, status string) (Data, error) {
+	record, err := s.newRecord(s.tenantID(), userID, dataType, details, status)
+	if err != nil {
+		return Data{}, err
+	}
+	// MongoDB keeps milliseconds; the caller gets the time as it is stored
+	record.CreatedAt = record.CreatedAt.Truncate(time.Millisecond)
+	record.UpdatedAt = record.CreatedAt
+
+	if record.ContentHash != "" {
+		query := s.scope(bson.A{bson.M{"tenant_id": record.TenantID, "content_hash": record.ContentHash,
+			"created_at": bson.M{"$gte": record.CreatedAt.Add(-s.dedup.Window)}}}, false)
+		existing, err := s.find(query, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(1))
+		if err != nil {
+			return Data{}, fmt.Errorf("failed to look up duplicates: %v", err)
+		}
+		if len(existing) > 0 {
+			return s.duplicateOf(existing[0])
+		}
+	}
+
+	if record.ID, err = s.nextID(); err != nil {
+		return Data{}, err
+	}
+	doc, err := newMongoRecord(record)
+	if err != nil {
+		return Data{}, err
+	}
+	err = s.apply([]Event{recordCreatedEvent(record)}, func() error {
+		if _, err := s.records.InsertOne(s.context(), doc); err != nil {
+			return fmt.Errorf("failed to insert record: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Data{}, err
+	}
+	return record, nil
+}
+
+// UpdateRecordStatus moves a record to newStatus if the workflow allows it and logs the transition in
+// the record. The update only applies to the status it was checked against, and is checked again
+// when another change came first.
+func (s *MongoDataService) UpdateRecordStatus(id uint, newStatus, actor string) (Data, error) {
+	for {
+		record, err := s.QueryByID(id)
+		if err != nil {
+			return Data{}, err
+		}
+		if IsImmutableType(record.Type) {
+			return Data{}, fmt.Errorf("status changes of %s records: %w", record.Type, ErrStoreUnsupported)
+		}
+		if !CanTransition(record.Status, newStatus) {
+			return Data{}, fmt.Errorf("%w: %q -> %q", ErrInvalidTransition, record.Status, newStatus)
+		}
+		now := time.Now().Truncate(time.Millisecond)
+		change := mongoStatusChange{FromStatus: record.Status, ToStatus: newStatus, Actor: actor, ChangedAt: now}
+		changed := Event{
+			Type:     EventRecordStatusChanged,
+			TenantID: record.TenantID,
+			Data:     map[string]interface{}{"id": record.ID, "type": record.Type, "from": record.Status, "to": newStatus},
+		}
+		err = s.apply([]Event{changed}, func() error {
+			result, err := s.records.UpdateOne(s.context(), bson.M{"_id": id, "status": record.Status, "deleted_at": nil}, bson.M{
+				"$set":  bson.M{"status": newStatus, "updated_at": now},
+				"$push": bson.M{"history": change},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to update status: %v", err)
+			}
+			if result.MatchedCount == 0 {
+				return errMongoStale
+			}
+			return nil
+		})
+		if errors.Is(err, errMongoStale) {
+			continue
+		}
+		if err != nil {
+			return Data{}, err
+		}
+		record.Status, record.UpdatedAt = newStatus, now
+		return record, nil
+	}
+}
+
+// BulkUpdateStatus is ErrStoreUnsupported: without multi-document transactions a failed record could
+// not undo the changes of the others
+func (s *MongoDataService) BulkUpdateStatus(ids []uint, recordType, newStatus, actor string) ([]BulkStatusResult, error) {
+	return nil, fmt.Errorf("bulk status changes: %w", ErrStoreUnsupported)
+}
+
+// PatchRecordDetails applies an RFC 7386 merge patch to the details of a record. A non-zero revision must
+// be the current revision of the record, else ErrRecordModified; the write itself is conditional on the
+// revision read, so concurrent patches cannot overwrite each other.
+func (s *MongoDataService) PatchRecordDetails(id uint, patch []byte, revision int) (Data, error) {
+	doc, ok := decodeDetails(patch)
+	if !ok {
+		return Data{}, fmt.Errorf("%w: a details patch must be a JSON object", ErrValidation)
+	}
+	if err := s.limits.Check(patch); err != nil {
+		return Data{}, err
+	}
+	record, err := s.QueryByID(id)
+	if err != nil {
+		return Data{}, err
+	}
+	if IsImmutableType(record.Type) {
+		return Data{}, fmt.Errorf("%w: record %d is a %s", ErrImmutableRecord, id, record.Type)
+	}
+	if revision != 0 && record.Revision != revision {
+		return Data{}, fmt.Errorf("%w: record %d is at revision %d, not %d", ErrRecordModified, id, record.Revision, revision)
+	}
+
+	current, _ := decodeDetails(record.Details)
+	merged, err := json.Marshal(mergePatch(current, doc))
+	if err != nil {
+		return Data{}, fmt.Errorf("failed to encode patched details: %v", err)
+	}
+	if err := s.limits.Check(merged); err != nil {
+		return Data{}, err
+	}
+	record.Details = datatypes.JSON(merged)
+	if s.validator != nil {
+		if record.SchemaVersion, err = s.validator.ValidateRecord(record.Type, record.Details); err != nil {
+			return Data{}, err
+		}
+	}
+	read := record.Revision
+	record.Revision++
+	record.UpdatedAt = time.Now().Truncate(time.Millisecond)
+
+	stored, err := newMongoRecord(record)
+	if err != nil {
+		return Data{}, err
+	}
+	err = s.apply([]Event{recordUpdatedEvent(record, doc)}, func() error {
+		result, err := s.records.UpdateOne(s.context(), bson.M{"_id": id, "revision": read, "deleted_at": nil}, bson.M{"$set": bson.M{
+			"details":        stored.Details,
+			"pii_hashes":     stored.PIIHashes,
+			"revision":       record.Revision,
+			"schema_version": record.SchemaVersion,
+			"updated_at":     record.UpdatedAt,
+		}})
+		if err != nil {
+			return fmt.Errorf("failed to patch record %d: %v", id, err)
+		}
+		if result.MatchedCount == 0 {
+			return fmt.Errorf("%w: record %d changed while it was patched", ErrRecordModified, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return Data{}, err
+	}
+	return record, nil
+}
+
+// mergePatch applies an RFC 7386 merge patch: objects merge key by key, null removes a key and any
+// other value replaces the target
+func mergePatch(target, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(target)+len(patch))
+	for key, value := range target {
+		merged[key] = value
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		if object, ok := value.(map[string]interface{}); ok {
+			existing, _ := merged[key].(map[string]interface{})
+			merged[key] = mergePatch(existing, object)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// RecordHistory returns the status transitions of a record, oldest first
+func (s *MongoDataService) RecordHistory(id uint) ([]StatusChange, error) {
+	var doc mongoRecord
+	err := s.records.FindOne(s.context(), s.scope(bson.A{bson.M{"_id": id}}, true), options.FindOne().SetProjection(bson.M{"history": 1})).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("record with ID %d: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch status history: %v", err)
+	}
+	history := make([]StatusChange, len(doc.History))
+	for i, change := range doc.History {
+		history[i] = StatusChange{
+			ID:         uint(i + 1),
+			RecordID:   id,
+			FromStatus: change.FromStatus,
+			ToStatus:   change.ToStatus,
+			Actor:      change.Actor,
+			ChangedAt:  change.ChangedAt,
+		}
+	}
+	return history, nil
+}
+
+// RecordVersions returns the record itself: the MongoDB store keeps a single version of every record
+func (s *MongoDataService) RecordVersions(id uint) ([]Data, error) {
+	record, err := s.QueryByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return []Data{record}, nil
+}
+
+// ArchiveRecord hides a record from listings; it can be brought back with RestoreRecord
+func (s *MongoDataService) ArchiveRecord(id uint) (Data, error) {
+	record, err := s.QueryByID(id)
+	if err != nil {
+		return Data{}, err
+	}
+	if IsImmutableType(record.Type) {
+		return Data{}, fmt.Errorf("%w: %s records cannot be archived", ErrImmutableRecord, record.Type)
+	}
+	if record.ArchivedAt != nil {
+		return record, nil
+	}
+	now := time.Now().Truncate(time.Millisecond)
+	err = s.apply([]Event{recordLifecycleEvent(EventRecordArchived, record.ID, record.TenantID, record.Type)}, func() error {
+		if _, err := s.records.UpdateOne(s.context(), bson.M{"_id": id}, bson.M{"$set": bson.M{"archived_at": now, "updated_at": now}}); err != nil {
+			return fmt.Errorf("failed to archive record: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Data{}, err
+	}
+	record.ArchivedAt, record.UpdatedAt = &now, now
+	return record, nil
+}
+
+// RestoreRecord brings back an archived or soft-deleted record
+func (s *MongoDataService) RestoreRecord(id uint) (Data, error) {
+	record, err := s.findOne(id, s.scope(bson.A{bson.M{"_id": id}}, true))
+	if err != nil {
+		return Data{}, err
+	}
+	if IsImmutableType(record.Type) {
+		return Data{}, fmt.Errorf("%w: %s records cannot be restored", ErrImmutableRecord, record.Type)
+	}
+	err = s.apply([]Event{recordLifecycleEvent(EventRecordRestored, record.ID, record.TenantID, record.Type)}, func() error {
+		if _, err := s.records.UpdateOne(s.context(), bson.M{"_id": id}, bson.M{"$set": bson.M{"archived_at": nil, "deleted_at": nil}}); err != nil {
+			return fmt.Errorf("failed to restore record: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Data{}, err
+	}
+	record.ArchivedAt = nil
+	record.DeletedAt.Valid = false
+	return record, nil
+}
+
+// DeleteRecord soft-deletes a record; it stays restorable until the retention purge removes it
+func (s *MongoDataService) DeleteRecord(id uint) error {
+	record, err := s.QueryByID(id)
+	if err != nil {
+		return err
+	}
+	if IsImmutableType(record.Type) {
+		return fmt.Errorf("%w: %s records cannot be deleted", ErrImmutableRecord, record.Type)
+	}
+	return s.apply([]Event{recordLifecycleEvent(EventRecordDeleted, record.ID, record.TenantID, record.Type)}, func() error {
+		result, err := s.records.UpdateOne(s.context(), s.scope(bson.A{bson.M{"_id": id}}, false), bson.M{"$set": bson.M{"deleted_at": time.Now()}})
+		if err != nil {
+			return fmt.Errorf("failed to delete record: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return fmt.Errorf("record with ID %d: %w", id, ErrNotFound)
+		}
+		return nil
+	})
+}
+
+// PurgeRecords permanently removes records created, or soft-deleted, before olderThan together with their status history,
+// and returns their IDs. Records belonging to skipUserIDs (customers under legal hold) are kept.
+func (s *MongoDataService) PurgeRecords(olderThan time.Time, skipUserIDs []uint) ([]uint, error) {
+	query := bson.M{"$or": bson.A{bson.M{"created_at": bson.M{"$lt": olderThan}}, bson.M{"deleted_at": bson.M{"$lt": olderThan}}}}
+	if len(skipUserIDs) > 0 {
+		query["user_id"] = bson.M{"$nin": skipUserIDs}
+	}
+	cursor, err := s.records.Find(s.context(), query, options.Find().SetProjection(bson.M{"_id": 1, "tenant_id": 1, "type": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge records: %v", err)
+	}
+	var found []struct {
+		ID       uint   `bson:"_id"`
+		TenantID uint   `bson:"tenant_id"`
+		Type     string `bson:"type"`
+	}
+	if err := cursor.All(s.context(), &found); err != nil {
+		return nil, fmt.Errorf("failed to purge records: %v", err)
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint, len(found))
+	events := make([]Event, len(found))
+	for i, doc := range found {
+		ids[i] = doc.ID
+		events[i] = recordLifecycleEvent(EventRecordPurged, doc.ID, doc.TenantID, doc.Type)
+	}
+	err = s.apply(events, func() error {
+		if _, err := s.records.DeleteMany(s.context(), bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return fmt.Errorf("failed to purge records: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ListIssues fetches non-archived records with type=issue
+func (s *MongoDataService) ListIssues() ([]Data, error) {
+	issues, err := s.find(s.scope(bson.A{bson.M{"type": "issue", "archived_at": nil}}, false), options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch issues: %v", err)
+	}
+	return issues, nil
+}
+
+// apply makes the MongoDB change write and queues the events announcing it. The events are written to
+// the outbox in a transaction kept open across write and committed only once write succeeded, so a
+// failed change queues nothing. MongoDB and the outbox cannot share that transaction: should its
+// commit fail after write, the change stands without its events, and the failure is logged.
+func (s *MongoDataService) apply(events []Event, write func() error) error {
+	if !OutboxEnabled {
+		if err := write(); err != nil {
+			return err
+		}
+		for _, event := range events {
+			Events.Publish(event)
+		}
+		return nil
+	}
+	applied := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, event := range events {
+			if err := WriteOutbox(tx, event); err != nil {
+				return err
+			}
+		}
+		if err := write(); err != nil {
+			return err
+		}
+		applied = true
+		return nil
+	})
+	if err != nil && !applied {
+		return err
+	}
+	if err != nil {
+		log.Printf("Failed to queue the events of a record change: %v", err)
+	}
+	for _, event := range events {
+		Events.Publish(event)
+	}
+	return nil
+}
+
+// newMongoRecord converts a record for storage, encrypting its protected fields
+func newMongoRecord(record Data) (mongoRecord, error) {
+	if err := record.sealPII(); err != nil {
+		return mongoRecord{}, fmt.Errorf("failed to encrypt record details: %v", err)
+	}
+	details, err := detailsDocument(record.Details)
+	if err != nil {
+		return mongoRecord{}, err
+	}
+	var hashes map[string]string
+	if len(record.PIIHashes) > 0 {
+		if err := json.Unmarshal(record.PIIHashes, &hashes); err != nil {
+			return mongoRecord{}, fmt.Errorf("invalid PII hashes: %v", err)
+		}
+	}
+	piiHashes := make([]mongoPIIHash, 0, len(hashes))
+	for path, hash := range hashes {
+		piiHashes = append(piiHashes, mongoPIIHash{Path: path, Hash: hash})
+	}
+	sort.Slice(piiHashes, func(i, j int) bool { return piiHashes[i].Path < piiHashes[j].Path })
+	doc := mongoRecord{
+		ID:            record.ID,
+		TenantID:      record.TenantID,
+		UserID:        record.UserID,
+		Type:          record.Type,
+		Details:       details,
+		Status:        record.Status,
+		CreatedAt:     record.CreatedAt,
+		UpdatedAt:     record.UpdatedAt,
+		ArchivedAt:    record.ArchivedAt,
+		AnonymizedAt:  record.AnonymizedAt,
+		Revision:      record.Revision,
+		SchemaVersion: record.SchemaVersion,
+		ContentHash:   record.ContentHash,
+		PIIHashes:     piiHashes,
+	}
+	if record.DeletedAt.Valid {
+		doc.DeletedAt = &record.DeletedAt.Time
+	}
+	return doc, nil
+}
+
+// toData converts a stored record back, decrypting its protected fields
+func (m mongoRecord) toData() (Data, error) {
+	details, err := detailsJSON(m.Details)
+	if err != nil {
+		return Data{}, fmt.Errorf("record %d: %v", m.ID, err)
+	}
+	record := Data{
+		ID:            m.ID,
+		TenantID:      m.TenantID,
+		UserID:        m.UserID,
+		Type:          m.Type,
+		Details:       details,
+		Status:        m.Status,
+		CreatedAt:     m.CreatedAt,
+		UpdatedAt:     m.UpdatedAt,
+		ArchivedAt:    m.ArchivedAt,
+		AnonymizedAt:  m.AnonymizedAt,
+		Version:       1,
+		Revision:      m.Revision,
+		SchemaVersion: m.SchemaVersion,
+		ContentHash:   m.ContentHash,
+	}
+	if m.DeletedAt != nil {
+		record.DeletedAt = gorm.DeletedAt{Time: *m.DeletedAt, Valid: true}
+	}
+	if err := record.openPII(); err != nil {
+		return Data{}, err
+	}
+	return record, nil
+}
+
+// detailsDocument converts JSON details into a BSON document; empty or null details store nothing
+func detailsDocument(details []byte) (bson.Raw, error) {
+	if len(details) == 0 || string(details) == "null" {
+		return nil, nil
+	}
+	doc, ok := decodeDetails(details)
+	if !ok {
+		return nil, fmt.Errorf("%w: record details must be a JSON object", ErrValidation)
+	}
+	raw, err := bson.Marshal(bsonValue(doc))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record details: %v", err)
+	}
+	return raw, nil
+}
+
+// bsonValue converts a decoded JSON value into BSON types: integral numbers become int64, the other
+// numbers float64
+func bsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		doc := make(bson.M, len(v))
+		for key, item := range v {
+			doc[key] = bsonValue(item)
+		}
+		return doc
+	case []interface{}:
+		array := make(bson.A, len(v))
+		for i, item := range v {
+			array[i] = bsonValue(item)
+		}
+		return array
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
+
+// detailsJSON converts stored details back into JSON
+func detailsJSON(raw bson.Raw) (datatypes.JSON, error) {
+	if len(raw) == 0 {
+		return datatypes.JSON("null"), nil
+	}
+	details, err := bson.MarshalExtJSON(raw, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode record details: %v", err)
+	}
+	return datatypes.JSON(details), nil
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMergePatch(t *testing.T) {
+	target := map[string]interface{}{
+		"name":    "Sami",
+		"note":    "call back",
+		"address": map[string]interface{}{"city": "Tunis", "zip": "1000"},
+	}
+	patch := map[string]interface{}{
+		"note":    nil,
+		"address": map[string]interface{}{"city": "Sfax"},
+		"tags":    []interface{}{"vip"},
+	}
+	want := map[string]interface{}{
+		"name":    "Sami",
+		"address": map[string]interface{}{"city": "Sfax", "zip": "1000"},
+		"tags":    []interface{}{"vip"},
+	}
+	if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergePatch = %v, want %v", got, want)
+	}
+	if target["note"] != "call back" {
+		t.Error("mergePatch changed its target")
+	}
+	// An object replacing a scalar starts from an empty object
+	if got := mergePatch(map[string]interface{}{"a": "x"}, map[string]interface{}{"a": map[string]interface{}{"b": nil, "c": "1"}}); !reflect.DeepEqual(got, map[string]interface{}{"a": map[string]interface{}{"c": "1"}}) {
+		t.Errorf("object over scalar: %v", got)
+	}
+}
+
+func TestMongoRecordConversion(t *testing.T) {
+	created := time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC)
+	record := Data{
+		ID:        12,
+		TenantID:  3,
+		UserID:    42,
+		Type:      "issue",
+		Details:   []byte(`{"name": "Sami", "quantity": 2, "price": 12.5, "address": {"city": "Sfax"}, "items": ["a", 1]}`),
+		Status:    StatusPending,
+		CreatedAt: created,
+		UpdatedAt: created,
+		Revision:  1,
+	}
+	doc, err := newMongoRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quantity := doc.Details.Lookup("quantity"); quantity.Type != bson.TypeInt64 {
+		t.Errorf("integral number stored as %v", quantity.Type)
+	}
+	if city := doc.Details.Lookup("address", "city").StringValue(); city != "Sfax" {
+		t.Errorf("nested details not stored as a document: %v", doc.Details)
+	}
+
+	back, err := doc.toData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want map[string]interface{}
+	json.Unmarshal(back.Details, &got)
+	json.Unmarshal(record.Details, &want)
+	if !reflect.DeepEqual(got, want) || back.ID != 12 || back.TenantID != 3 || back.Version != 1 || back.DeletedAt.Valid {
+		t.Errorf("round trip changed the record: %+v, details %s", back, back.Details)
+	}
+
+	if doc, err := newMongoRecord(Data{Details: []byte("null")}); err != nil || doc.Details != nil {
+		t.Errorf("null details: %v, %v", doc.Details, err)
+	}
+	if _, err := newMongoRecord(Data{Details: []byte(`[1, 2]`)}); !errors.Is(err, ErrValidation) {
+		t.Errorf("array details: %v", err)
+	}
+}
+
+func TestMongoRecordConditions(t *testing.T) {
+	user := uint(42)
+	conditions, err := mongoRecordConditions(RecordFilter{Type: "issue", UserID: &user, Text: "city=Sfax (centre)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.A{
+		bson.M{"archived_at": nil},
+		bson.M{"type": "issue"},
+		bson.M{"user_id": user},
+		bson.M{"details.city": bson.M{"$regex": `Sfax \(centre\)`, "$options": "i"}},
+	}
+	if !reflect.DeepEqual(conditions, want) {
+		t.Errorf("conditions = %v, want %v", conditions, want)
+	}
+
+	conditions, _ = mongoRecordConditions(RecordFilter{IncludeArchived: true, Text: "blender"})
+	if len(conditions) != 1 {
+		t.Fatalf("any-value search: %v", conditions)
+	}
+	if _, ok := conditions[0].(bson.M)["$expr"]; !ok {
+		t.Errorf("any-value search does not match every value: %v", conditions[0])
+	}
+
+	if _, err := mongoRecordConditions(RecordFilter{Tags: []string{"urgent"}}); !errors.Is(err, ErrStoreUnsupported) {
+		t.Errorf("tag filter: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gorm.io/datatypes"
+)
+
+// retentionStore is implemented by the record stores outside PostgreSQL; GormRetentionService hands
+// them the stages it would otherwise run in SQL
+type retentionStore interface {
+	// countRetained counts the records created or soft-deleted before cutoff, only those not yet
+	// anonymized when pending is set
+	countRetained(cutoff time.Time, skipUserIDs []uint, pending bool) (int64, error)
+	// anonymizeRecords hashes or drops the personal data of the records older than cutoff
+	// and returns their IDs
+	anonymizeRecords(cutoff time.Time, skipUserIDs []uint, policy RetentionPolicy, now time.Time) ([]uint, error)
+}
+
+// retainedQuery selects the records older than cutoff across tenants, soft-deleted ones included
+func retainedQuery(cutoff time.Time, skipUserIDs []uint, pending bool) bson.M {
+	query := bson.M{"$or": bson.A{bson.M{"created_at": bson.M{"$lt": cutoff}}, bson.M{"deleted_at": bson.M{"$lt": cutoff}}}}
+	if len(skipUserIDs) > 0 {
+		query["user_id"] = bson.M{"$nin": skipUserIDs}
+	}
+	if pending {
+		query["anonymized_at"] = nil
+	}
+	return query
+}
+
+func (s *MongoDataService) countRetained(cutoff time.Time, skipUserIDs []uint, pending bool) (int64, error) {
+	return s.records.CountDocuments(s.context(), retainedQuery(cutoff, skipUserIDs, pending))
+}
+
+// anonymizeRecords rewrites the details of the records older than cutoff one by one, encrypting the
+// protected fields the policy keeps again like GormRetentionService does
+func (s *MongoDataService) anonymizeRecords(cutoff time.Time, skipUserIDs []uint, policy RetentionPolicy, now time.Time) ([]uint, error) {
+	cursor, err := s.records.Find(s.context(), retainedQuery(cutoff, skipUserIDs, true), options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(200))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(s.context())
+	var anonymized []uint
+	for cursor.Next(s.context()) {
+		var doc mongoRecord
+		if err := cursor.Decode(&doc); err != nil {
+			return anonymized, err
+		}
+		record, err := doc.toData()
+		if err != nil {
+			return anonymized, err
+		}
+		if record.Undecryptable {
+			log.Printf("Retention: skipping record %d: its personal data cannot be decrypted", record.ID)
+			continue
+		}
+		details, err := AnonymizeDetails(record.Details, policy.HashFields, policy.DropFields)
+		if err != nil {
+			return anonymized, fmt.Errorf("record %d: %v", record.ID, err)
+		}
+		// The old lookup hashes would still find the customer, so only those of the kept values are stored
+		record.Details, record.PIIHashes = datatypes.JSON(details), nil
+		stored, err := newMongoRecord(record)
+		if err != nil {
+			return anonymized, fmt.Errorf("record %d: %v", record.ID, err)
+		}
+		_, err = s.records.UpdateOne(s.context(), bson.M{"_id": record.ID, "anonymized_at": nil}, bson.M{"$set": bson.M{
+			"details":       stored.Details,
+			"pii_hashes":    stored.PIIHashes,
+			"anonymized_at": now,
+			"updated_at":    now,
+		}})
+		if err != nil {
+			return anonymized, fmt.Errorf("failed to update record %d: %v", record.ID, err)
+		}
+		anonymized = append(anonymized, record.ID)
+	}
+	return anonymized, cursor.Err()
+}
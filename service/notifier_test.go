@@ -0,0 +1,45 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewNotifier(t *testing.T) {
+	if _, ok := NewNotifier("").(LogNotifier); !ok {
+		t.Error("no webhook URL should log notifications")
+	}
+	if notifier, ok := NewNotifier("https://hooks.example.com/ops").(*WebhookNotifier); !ok || notifier.URL != "https://hooks.example.com/ops" {
+		t.Errorf("webhook URL not used: %#v", notifier)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	for _, c := range []struct {
+		status  int
+		wantErr string
+	}{
+		{http.StatusOK, ""},
+		{http.StatusNoContent, ""},
+		{http.StatusMovedPermanently, "status 301"},
+		{http.StatusInternalServerError, "status 500"},
+	} {
+		var received Notification
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&received)
+			w.WriteHeader(c.status)
+		}))
+		notifier := &WebhookNotifier{URL: server.URL, client: server.Client()}
+		err := notifier.Notify(Notification{Event: "reauth_required", Data: map[string]interface{}{"user_id": "u1"}})
+		server.Close()
+		if c.wantErr == "" && err != nil || c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+			t.Errorf("status %d: got %v, want %q", c.status, err, c.wantErr)
+		}
+		if received.Event != "reauth_required" || received.Data["user_id"] != "u1" {
+			t.Errorf("status %d: webhook received %+v", c.status, received)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OrderCancelled is the Converty status of a cancelled order
+const OrderCancelled = "cancelled"
+
+// CancellationType is the record type of order cancellations
+const CancellationType = "cancellation"
+
+// ErrOrderNotCancellable rejects the cancellation of an order that is already on its way or closed
+var ErrOrderNotCancellable = errors.New("order cannot be cancelled")
+
+// cancellableStatuses are the order statuses that have not left the store yet
+var cancellableStatuses = map[string]bool{
+	"pending":    true,
+	"new":        true,
+	"confirmed":  true,
+	"processing": true,
+}
+
+// OrderCancellable reports whether an order in status may still be cancelled
+func OrderCancellable(status string) bool {
+	return cancellableStatuses[strings.ToLower(status)]
+}
+
+// CancelOrderRequest is a customer's "cancel my order", usually relayed by the chatbot
+type CancelOrderRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
+	// UserID is the chatbot user the cancellation record belongs to
+	UserID uint   `json:"user_id"`
+	Actor  string `json:"actor" validate:"max=128"`
+	// Notify sends an order_cancelled notification so the chatbot can confirm to the customer
+	Notify bool `json:"notify"`
+}
+
+// OrderCancellation is the outcome of a cancellation
+type OrderCancellation struct {
+	Order          Order  `json:"order"`
+	PreviousStatus string `json:"previous_status"`
+	RecordID       uint   `json:"record_id"`
+	Notified       bool   `json:"notified"`
+}
+
+// CancelOrder cancels an order upstream, records the cancellation as an interaction and optionally notifies.
+// Once Converty accepted the cancellation, later failures are returned alongside the cancelled order.
+func (s *GormOrderService) CancelOrder(dataService DataService, tenantID uint, id string, request CancelOrderRequest) (OrderCancellation, error) {
+	order, err := dataService.GetOrder(id)
+	if err != nil {
+		return OrderCancellation{}, err
+	}
+	if !OrderCancellable(order.Status) {
+		return OrderCancellation{}, fmt.Errorf("%w: order %s is %s", ErrOrderNotCancellable, id, order.Status)
+	}
+	cancelled, err := dataService.UpdateOrderStatus(id, OrderCancelled)
+	if err != nil {
+		return OrderCancellation{}, err
+	}
+	if cancelled.ID == "" {
+		// Some update responses carry no order; the status change is what matters
+		cancelled = order
+		cancelled.Status = OrderCancelled
+	}
+	result := OrderCancellation{Order: cancelled, PreviousStatus: order.Status}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Keep the local mirror in step until the next sync
+		if err := tx.Model(&OrderRecord{}).Where("tenant_id = ? AND order_id = ?", tenantID, id).
+			Updates(map[string]interface{}{"status": cancelled.Status, "synced_at": now}).Error; err != nil {
+			return fmt.Errorf("failed to update mirrored order: %v", err)
+		}
+		return WriteOutbox(tx, Event{
+			Type:     EventOrderCancelled,
+			TenantID: tenantID,
+			Data: map[string]interface{}{
+				"order_id": id, "previous_status": order.Status, "reason": request.Reason,
+			},
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to record cancellation of order %s: %v", id, err)
+	}
+	Events.Publish(Event{
+		Type:     EventOrderStatusChanged,
+		TenantID: tenantID,
+		Data:     map[string]interface{}{"order_id": id, "from": order.Status, "to": cancelled.Status},
+	})
+
+	record, err := dataService.InsertRecord(request.UserID, CancellationType, map[string]interface{}{
+		"order_id":        id,
+		"previous_status": order.Status,
+		"reason":          request.Reason,
+		"actor":           request.Actor,
+		"phone":           order.Customer.Phone,
+	}, StatusCompleted)
+	if err != nil {
+		return result, fmt.Errorf("order %s cancelled but the cancellation could not be recorded: %v", id, err)
+	}
+	result.RecordID = record.ID
+
+	if request.Notify && s.notifier != nil {
+		err := s.notifier.Notify(Notification{
+			Event:   "order_cancelled",
+			Message: fmt.Sprintf("Order %s has been cancelled", id),
+			Data: map[string]interface{}{
+				"tenant_id": tenantID,
+				"order_id":  id,
+				"phone":     order.Customer.Phone,
+				"reason":    request.Reason,
+				"record_id": record.ID,
+			},
+			CreatedAt: now,
+		})
+		if err != nil {
+			log.Printf("Failed to notify cancellation of order %s: %v", id, err)
+		} else {
+			result.Notified = true
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Order export formats
+const (
+	ExportCSV  = "csv"
+	ExportXLSX = "xlsx"
+)
+
+// orderExportPageSize is how many orders each upstream page of an export fetches
+const orderExportPageSize = 100
+
+// OrderExportQuery selects the orders of an export; nil bounds are open
+type OrderExportQuery struct {
+	Format string     `json:"format"`
+	Status string     `json:"status,omitempty"`
+	From   *time.Time `json:"from,omitempty"`
+	To     *time.Time `json:"to,omitempty"`
+	// MaxPages bounds how many upstream pages are walked; zero means no bound
+	MaxPages int `json:"max_pages,omitempty"`
+}
+
+// orderExportColumns is the header row of every export
+var orderExportColumns = []interface{}{
+	"order_id", "created_at", "status", "customer", "phone", "city",
+	"total", "currency", "delivery_company", "tracking_number", "items",
+}
+
+// ValidExportFormat reports whether format is a supported export format
+func ValidExportFormat(format string) bool {
+	return format == ExportCSV || format == ExportXLSX
+}
+
+// ExportContentType is the MIME type of an export format
+func ExportContentType(format string) string {
+	if format == ExportXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv"
+}
+
+// ExportOrders writes the matching orders to w one upstream page at a time and returns the row count.
+// Pages are fetched concurrently within the background share of the upstream limiter, keeping slots
+// for chatbot calls. Converty lists orders newest first, so paging stops once a page ends before query.From.
+func ExportOrders(dataService DataService, query OrderExportQuery, w io.Writer) (int, error) {
+	rows, err := newRowWriter(query.Format, w)
+	if err != nil {
+		return 0, err
+	}
+	if err := rows.WriteRow(orderExportColumns); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	fetch := func(page int) ([]Order, error) {
+		return dataService.ListOrders(CustomerOrderQuery{Page: page, Limit: orderExportPageSize, Status: query.Status})
+	}
+	err = FetchPages(UpstreamLimit.PageWorkers(PriorityBackground), orderExportPageSize, query.MaxPages, fetch, func(page int, orders []Order) (bool, error) {
+		for _, order := range orders {
+			if query.From != nil && order.CreatedAt.Before(*query.From) {
+				continue
+			}
+			if query.To != nil && !order.CreatedAt.Before(*query.To) {
+				continue
+			}
+			if err := rows.WriteRow(orderExportRow(order)); err != nil {
+				return false, err
+			}
+			count++
+		}
+		return len(orders) == 0 || query.From == nil || !orders[len(orders)-1].CreatedAt.Before(*query.From), nil
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, rows.Close()
+}
+
+// orderExportRow flattens an order into export cells
+func orderExportRow(order Order) []interface{} {
+	items := 0
+	for _, line := range order.Items {
+		items += line.Quantity
+	}
+	return []interface{}{
+		order.ID,
+		order.CreatedAt.Format(time.RFC3339),
+		order.Status,
+		order.Customer.Name,
+		order.Customer.Phone,
+		order.Customer.City,
+		order.Total,
+		order.Currency,
+		order.DeliveryCompany,
+		order.TrackingNumber,
+		items,
+	}
+}
+
+// rowWriter writes export rows in one format
+type rowWriter interface {
+	WriteRow(cells []interface{}) error
+	Close() error
+}
+
+func newRowWriter(format string, w io.Writer) (rowWriter, error) {
+	switch format {
+	case ExportCSV:
+		return &csvRowWriter{w: csv.NewWriter(w)}, nil
+	case ExportXLSX:
+		return newXLSXRowWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported export format %q, expected csv or xlsx", format)
+}
+
+// csvRowWriter writes rows as CSV
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvRowWriter) WriteRow(cells []interface{}) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		record[i] = exportCellString(cell)
+	}
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	// Flush each row so the response streams instead of accumulating in the buffer
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvRowWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func exportCellString(cell interface{}) string {
+	switch v := cell.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	}
+	return fmt.Sprint(cell)
+}
+
+// xlsxRowWriter streams a single-sheet workbook: the fixed parts are written up front
+// and the sheet XML is written row by row into the zip entry
+type xlsxRowWriter struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+// xlsxParts are the workbook files besides the sheet
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Orders" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+func newXLSXRowWriter(w io.Writer) (*xlsxRowWriter, error) {
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		f, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return nil, err
+	}
+	return &xlsxRowWriter{zip: archive, sheet: sheet}, nil
+}
+
+func (x *xlsxRowWriter) WriteRow(cells []interface{}) error {
+	x.row++
+	var b strings.Builder
+	fmt.Fprintf(&b, `<row r="%d">`, x.row)
+	for _, cell := range cells {
+		switch v := cell.(type) {
+		case float64, int:
+			fmt.Fprintf(&b, `<c><v>%s</v></c>`, exportCellString(v))
+		default:
+			b.WriteString(`<c t="inlineStr"><is><t>`)
+			xml.EscapeText(&b, []byte(exportCellString(v)))
+			b.WriteString(`</t></is></c>`)
+		}
+	}
+	b.WriteString(`</row>`)
+	_, err := io.WriteString(x.sheet, b.String())
+	return err
+}
+
+func (x *xlsxRowWriter) Close() error {
+	if _, err := io.WriteString(x.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
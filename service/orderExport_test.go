@@ -0,0 +1,77 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pagedOrders serves ListOrders from fixed pages
+type pagedOrders struct {
+	DataService
+	pages   [][]Order
+	fetched int
+}
+
+func (p *pagedOrders) ListOrders(query CustomerOrderQuery) ([]Order, error) {
+	p.fetched++
+	if query.Page > len(p.pages) {
+		return nil, nil
+	}
+	return p.pages[query.Page-1], nil
+}
+
+func TestExportOrdersCSVStopsBeforeRange(t *testing.T) {
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+	full := make([]Order, orderExportPageSize)
+	for i := range full {
+		full[i] = Order{ID: "in", CreatedAt: from.Add(time.Duration(orderExportPageSize-i) * time.Hour), Total: 12.5}
+	}
+	// The last order of the second page predates the range, so the third page is never fetched
+	second := make([]Order, orderExportPageSize)
+	for i := range second {
+		second[i] = Order{ID: "old", CreatedAt: from.Add(-time.Hour)}
+	}
+	second[0] = Order{ID: "edge", CreatedAt: from, Items: []OrderLine{{Quantity: 2}, {Quantity: 1}}}
+	source := &pagedOrders{pages: [][]Order{full, second, {{ID: "never"}}}}
+
+	var out bytes.Buffer
+	rows, err := ExportOrders(source, OrderExportQuery{Format: ExportCSV, From: &from, To: &to}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != orderExportPageSize+1 || source.fetched != 2 {
+		t.Errorf("exported %d rows from %d pages, want %d rows from 2 pages", rows, source.fetched, orderExportPageSize+1)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !strings.HasPrefix(lines[0], "order_id,created_at") || !strings.HasSuffix(lines[len(lines)-1], ",3") {
+		t.Errorf("unexpected csv header %q or last row %q", lines[0], lines[len(lines)-1])
+	}
+}
+
+func TestExportOrdersXLSX(t *testing.T) {
+	source := &pagedOrders{pages: [][]Order{{{ID: "A&1", Total: 9.9, CreatedAt: time.Now()}}}}
+	var out bytes.Buffer
+	if _, err := ExportOrders(source, OrderExportQuery{Format: ExportXLSX}, &out); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatalf("export is not a valid zip: %v", err)
+	}
+	var sheet string
+	for _, file := range archive.File {
+		if file.Name == "xl/worksheets/sheet1.xml" {
+			f, _ := file.Open()
+			body, _ := io.ReadAll(f)
+			sheet = string(body)
+		}
+	}
+	if !strings.Contains(sheet, "<t>A&amp;1</t>") || !strings.Contains(sheet, "<v>9.9</v>") || !strings.HasSuffix(sheet, "</worksheet>") {
+		t.Errorf("unexpected sheet %s", sheet)
+	}
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrOrderNotMirrored is returned when an order has not been copied into the local mirror
+var ErrOrderNotMirrored = errors.New("order not found in the local mirror")
+
+// OrderSyncState is the order sync cursor of one tenant
+type OrderSyncState struct {
+	TenantID uint `gorm:"primaryKey;autoIncrement:false" json:"tenant_id"`
+	// Cursor is the latest Converty updated_at copied so far; orders changed after it are fetched next
+	Cursor       time.Time  `json:"cursor"`
+	LastSyncedAt *time.Time `json:"last_synced_at,omitempty"`
+	LastSynced   int        `json:"last_synced"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// TableName specifies the table name for OrderSyncState
+func (OrderSyncState) TableName() string {
+	return "chatbot.order_sync_states"
+}
+
+// OrderSyncResult summarizes one incremental sync
+type OrderSyncResult struct {
+	Pages  int       `json:"pages"`
+	Synced int       `json:"synced"`
+	Cursor time.Time `json:"cursor"`
+}
+
+// OrderMirrorService keeps a local copy of the Converty orders so listings survive upstream outages
+type OrderMirrorService interface {
+	// Sync copies the orders changed since the tenant's cursor, reading at most maxPages pages
+	Sync(tenantID uint, dataService DataService, maxPages int) (OrderSyncResult, error)
+	SyncState(tenantID uint) (OrderSyncState, error)
+	// ListOrders answers an order listing from the mirror, newest first
+	ListOrders(tenantID uint, query CustomerOrderQuery) ([]Order, error)
+	GetOrder(tenantID uint, id string) (Order, error)
+	// OrdersBetween returns every mirrored order placed in [from, to), oldest first
+	OrdersBetween(tenantID uint, from, to time.Time) ([]Order, error)
+	// ListCustomers lists the customers of the mirrored orders, the latest buyers first
+	ListCustomers(tenantID uint, query CustomerQuery) ([]CustomerSummary, error)
+	// CustomerOrders returns the mirrored orders of several customers at once, newest first and at
+	// most limit per customer, keyed by normalized phone
+	CustomerOrders(tenantID uint, phones []string, limit int) (map[string][]Order, error)
+}
+
+// CustomerQuery narrows a customer listing; zero values are ignored
+type CustomerQuery struct {
+	// Search matches the name or the phone number
+	Search string
+	// Phones keeps the customers with one of these phone numbers, whatever their formatting
+	Phones []string
+	Offset int
+	Limit  int
+}
+
+// CustomerSummary is a customer as the mirrored orders know them, identified by normalized phone.
+// Name, Email and City come from their latest order.
+type CustomerSummary struct {
+	Phone       string    `json:"phone"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email,omitempty"`
+	City        string    `json:"city,omitempty"`
+	Orders      int       `json:"orders"`
+	LastOrderAt time.Time `json:"last_order_at"`
+}
+
+// GormOrderMirrorService implements OrderMirrorService using GORM
+type GormOrderMirrorService struct {
+	db *gorm.DB
+}
+
+// NewGormOrderMirrorService creates a new GormOrderMirrorService
+func NewGormOrderMirrorService(db *gorm.DB) OrderMirrorService {
+	return &GormOrderMirrorService{db: db}
+}
+
+// Sync walks the upstream listing, newest first, and stores the orders changed after the cursor.
+// It stops at the first page without a change, so a sync after a quiet period reads one page.
+func (s *GormOrderMirrorService) Sync(tenantID uint, dataService DataService, maxPages int) (OrderSyncResult, error) {
+	state, err := s.SyncState(tenantID)
+	if err != nil {
+		return OrderSyncResult{}, err
+	}
+	result := OrderSyncResult{Cursor: state.Cursor}
+	query := CustomerOrderQuery{Limit: 100}
+	var changed []Order
+	for page := 1; page <= maxPages; page++ {
+		query.Page = page
+		orders, err := dataService.ListOrders(query)
+		if err != nil {
+			return result, s.saveState(state, result, fmt.Errorf("failed to fetch orders: %w", err))
+		}
+		result.Pages = page
+		pageChanged := false
+		for _, order := range orders {
+			updatedAt := order.UpdatedAt
+			if updatedAt.IsZero() {
+				updatedAt = order.CreatedAt
+			}
+			if !updatedAt.After(state.Cursor) {
+				continue
+			}
+			pageChanged = true
+			changed = append(changed, order)
+			if updatedAt.After(result.Cursor) {
+				result.Cursor = updatedAt
+			}
+		}
+		if !pageChanged || len(orders) < query.Limit {
+			break
+		}
+	}
+	if err := storeOrders(s.db, tenantID, changed, ""); err != nil {
+		result.Cursor = state.Cursor
+		return result, s.saveState(state, result, err)
+	}
+	result.Synced = len(changed)
+	return result, s.saveState(state, result, nil)
+}
+
+// saveState records the outcome of a sync and returns syncErr
+func (s *GormOrderMirrorService) saveState(state OrderSyncState, result OrderSyncResult, syncErr error) error {
+	now := time.Now()
+	state.Cursor = result.Cursor
+	state.LastSyncedAt = &now
+	state.LastSynced = result.Synced
+	state.LastError = ""
+	if syncErr != nil {
+		state.LastError = syncErr.Error()
+	}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"cursor", "last_synced_at", "last_synced", "last_error"}),
+	}).Create(&state).Error
+	if syncErr != nil {
+		return syncErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save order sync state: %v", err)
+	}
+	return nil
+}
+
+// SyncState returns the tenant's cursor, a zero state before the first sync
+func (s *GormOrderMirrorService) SyncState(tenantID uint) (OrderSyncState, error) {
+	state := OrderSyncState{TenantID: tenantID}
+	err := s.db.Where("tenant_id = ?", tenantID).First(&state).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return state, fmt.Errorf("failed to load order sync state: %v", err)
+	}
+	return state, nil
+}
+
+// ListOrders applies the status, search, product and delivery company filters of an upstream listing
+func (s *GormOrderMirrorService) ListOrders(tenantID uint, query CustomerOrderQuery) ([]Order, error) {
+	if query.Limit <= 0 {
+		query.Limit = 10
+	}
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+	q := s.db.Where("tenant_id = ?", tenantID)
+	if query.Status != "" {
+		q = q.Where("lower(status) = ?", strings.ToLower(query.Status))
+	}
+	if query.DeliveryCompany != "" {
+		q = q.Where("lower(delivery_company) = ?", strings.ToLower(query.DeliveryCompany))
+	}
+	if query.Search != "" {
+		pattern := "%" + query.Search + "%"
+		q = q.Where("(order_id ILIKE ? OR customer::jsonb ->> 'name' ILIKE ? OR customer::jsonb ->> 'phone' ILIKE ?)", pattern, pattern, pattern)
+	}
+	if query.Product != "" {
+		q = q.Where("order_id IN (?)", s.db.Model(&OrderItemRecord{}).Select("order_id").
+			Where("tenant_id = ? AND (product_id = ? OR name ILIKE ?)", tenantID, query.Product, query.Product))
+	}
+	if c := query.Category; c != nil {
+		q = q.Where("order_id IN (?)", s.db.Model(&OrderItemRecord{}).Select("order_id").
+			Where("tenant_id = ? AND trim(category) <> '' AND (trim(category) IN ? OR lower(trim(category)) IN ?)", tenantID,
+				[]string{c.ExternalID, strconv.FormatUint(uint64(c.ID), 10)}, []string{strings.ToLower(c.Slug), strings.ToLower(c.Name)}))
+	}
+	var records []OrderRecord
+	err := q.Order("ordered_at desc, id desc").Offset((query.Page - 1) * query.Limit).Limit(query.Limit).Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mirrored orders: %v", err)
+	}
+	return s.withItems(tenantID, records)
+}
+
+// OrdersBetween returns every mirrored order placed in [from, to) in one query
+func (s *GormOrderMirrorService) OrdersBetween(tenantID uint, from, to time.Time) ([]Order, error) {
+	var records []OrderRecord
+	err := s.db.Where("tenant_id = ? AND ordered_at >= ? AND ordered_at < ?", tenantID, from, to).
+		Order("ordered_at, id").Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mirrored orders: %v", err)
+	}
+	return s.withItems(tenantID, records)
+}
+
+// GetOrder returns one mirrored order or ErrOrderNotMirrored
+func (s *GormOrderMirrorService) GetOrder(tenantID uint, id string) (Order, error) {
+	var record OrderRecord
+	err := s.db.Where("tenant_id = ? AND order_id = ?", tenantID, id).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Order{}, fmt.Errorf("%w: %s", ErrOrderNotMirrored, id)
+	}
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to load mirrored order: %v", err)
+	}
+	orders, err := s.withItems(tenantID, []OrderRecord{record})
+	if err != nil {
+		return Order{}, err
+	}
+	return orders[0], nil
+}
+
+// withItems converts records to orders and attaches their lines
+func (s *GormOrderMirrorService) withItems(tenantID uint, records []OrderRecord) ([]Order, error) {
+	orders := make([]Order, 0, len(records))
+	if len(records) == 0 {
+		return orders, nil
+	}
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.OrderID)
+	}
+	// The lines are read 1000 orders at a time, keeping a quarter of orders within the parameter limit
+	var items []OrderItemRecord
+	for start := 0; start < len(ids); start += 1000 {
+		end := min(start+1000, len(ids))
+		var chunk []OrderItemRecord
+		if err := s.db.Where("tenant_id = ? AND order_id IN ?", tenantID, ids[start:end]).Order("id").Find(&chunk).Error; err != nil {
+			return nil, fmt.Errorf("failed to load mirrored order lines: %v", err)
+		}
+		items = append(items, chunk...)
+	}
+	lines := make(map[string][]OrderLine)
+	for _, item := range items {
+		lines[item.OrderID] = append(lines[item.OrderID], OrderLine{
+			ProductID: item.ProductID,
+			Name:      item.Name,
+			Category:  item.Category,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+		})
+	}
+	for _, record := range records {
+		orders = append(orders, Order{
+			ID:              record.OrderID,
+			Customer:        record.Customer,
+			Status:          record.Status,
+			Total:           record.Total,
+			Currency:        record.Currency,
+			CreatedAt:       record.OrderedAt,
+			UpdatedAt:       record.ChangedAt,
+			Items:           lines[record.OrderID],
+			DeliveryCompany: record.DeliveryCompany,
+			TrackingNumber:  record.TrackingNumber,
+		})
+	}
+	return orders, nil
+}
+
+// mirroredPhoneSQL is the normalized phone number of a mirrored order
+var mirroredPhoneSQL = normalizedPhoneSQL("(customer::jsonb ->> 'phone')")
+
+// ListCustomers groups the mirrored orders by normalized phone
+func (s *GormOrderMirrorService) ListCustomers(tenantID uint, query CustomerQuery) ([]CustomerSummary, error) {
+	if query.Limit <= 0 {
+		query.Limit = 20
+	}
+	buyers := s.db.Model(&OrderRecord{}).
+		Select(mirroredPhoneSQL+" AS phone, customer::jsonb AS customer, ordered_at").
+		Where("tenant_id = ? AND coalesce(customer::jsonb ->> 'phone', '') <> ''", tenantID)
+	if query.Search != "" {
+		pattern := "%" + query.Search + "%"
+		buyers = buyers.Where("(customer::jsonb ->> 'name' ILIKE ? OR customer::jsonb ->> 'phone' ILIKE ?)", pattern, pattern)
+	}
+	if len(query.Phones) > 0 {
+		phones := make([]string, 0, len(query.Phones))
+		for _, phone := range query.Phones {
+			phones = append(phones, NormalizePhone(phone))
+		}
+		buyers = buyers.Where(mirroredPhoneSQL+" IN ?", phones)
+	}
+	var customers []CustomerSummary
+	err := s.db.Table("(?) AS buyers", buyers).
+		Select("phone, " +
+			"(array_agg(customer ->> 'name' ORDER BY ordered_at DESC))[1] AS name, " +
+			"(array_agg(customer ->> 'email' ORDER BY ordered_at DESC))[1] AS email, " +
+			"(array_agg(customer ->> 'city' ORDER BY ordered_at DESC))[1] AS city, " +
+			"count(*) AS orders, max(ordered_at) AS last_order_at").
+		Group("phone").Order("last_order_at DESC, phone").
+		Offset(query.Offset).Limit(query.Limit).Scan(&customers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mirrored customers: %v", err)
+	}
+	return customers, nil
+}
+
+// CustomerOrders ranks the orders of each phone in one query and keeps the latest limit ones
+func (s *GormOrderMirrorService) CustomerOrders(tenantID uint, phones []string, limit int) (map[string][]Order, error) {
+	byPhone := make(map[string][]Order, len(phones))
+	if len(phones) == 0 {
+		return byPhone, nil
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	normalized := make([]string, 0, len(phones))
+	for _, phone := range phones {
+		normalized = append(normalized, NormalizePhone(phone))
+	}
+	ranked := s.db.Model(&OrderRecord{}).
+		Select("*, row_number() OVER (PARTITION BY "+mirroredPhoneSQL+" ORDER BY ordered_at DESC, id DESC) AS phone_rank").
+		Where("tenant_id = ? AND "+mirroredPhoneSQL+" IN ?", tenantID, normalized)
+	var records []OrderRecord
+	if err := s.db.Table("(?) AS ranked", ranked).Where("phone_rank <= ?", limit).
+		Order("ordered_at DESC, id DESC").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to load the mirrored orders of %d customers: %v", len(phones), err)
+	}
+	orders, err := s.withItems(tenantID, records)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		phone := NormalizePhone(order.Customer.Phone)
+		byPhone[phone] = append(byPhone[phone], order)
+	}
+	return byPhone, nil
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrderSourceChatbot marks the orders placed through POST /api/v1/orders
+const OrderSourceChatbot = "chatbot"
+
+// Order report groupings
+const (
+	GroupByDay     = "day"
+	GroupByWeek    = "week"
+	GroupByStatus  = "status"
+	GroupByProduct = "product"
+)
+
+// OrderRecord is a Converty order copied into chatbot.orders so reports run in SQL
+type OrderRecord struct {
+	ID              uint     `gorm:"primaryKey" json:"-"`
+	TenantID        uint     `gorm:"not null;default:0;uniqueIndex:idx_orders_tenant_order" json:"tenant_id"`
+	OrderID         string   `gorm:"not null;uniqueIndex:idx_orders_tenant_order" json:"order_id"`
+	Customer        Customer `gorm:"serializer:json" json:"customer"`
+	Status          string   `gorm:"index" json:"status"`
+	Total           float64  `json:"total"`
+	Currency        string   `json:"currency"`
+	DeliveryCompany string   `json:"delivery_company,omitempty"`
+	TrackingNumber  string   `json:"tracking_number,omitempty"`
+	// OrderedAt is when the order was placed in Converty
+	OrderedAt time.Time `gorm:"not null;index" json:"ordered_at"`
+	// ChangedAt is Converty's updated_at; the order sync cursor advances over it
+	ChangedAt time.Time `gorm:"column:upstream_updated_at;index" json:"updated_at"`
+	// Source is OrderSourceChatbot for orders placed through this API, empty otherwise
+	Source   string    `gorm:"index" json:"source,omitempty"`
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// TableName specifies the table name for OrderRecord
+func (OrderRecord) TableName() string {
+	return "chatbot.orders"
+}
+
+// OrderItemRecord is one product line of a copied order
+type OrderItemRecord struct {
+	ID        uint   `gorm:"primaryKey"`
+	TenantID  uint   `gorm:"not null;default:0;index:idx_order_items_tenant_order"`
+	OrderID   string `gorm:"not null;index:idx_order_items_tenant_order"`
+	ProductID string `gorm:"index"`
+	Name      string
+	Category  string
+	Quantity  int
+	Price     float64
+}
+
+// TableName specifies the table name for OrderItemRecord
+func (OrderItemRecord) TableName() string {
+	return "chatbot.order_items"
+}
+
+// OrderReportQuery selects and groups the copied orders; To is exclusive
+type OrderReportQuery struct {
+	GroupBy string
+	From    *time.Time
+	To      *time.Time
+	Status  string
+	Source  string
+}
+
+// OrderReportRow totals the orders of one group in one currency. Gross counts every order,
+// Revenue leaves out cancelled, returned and rejected ones
+type OrderReportRow struct {
+	Group string `json:"group"`
+	// Name is the product name when grouping by product
+	Name     string  `json:"name,omitempty"`
+	Currency string  `json:"currency"`
+	Orders   int64   `json:"orders"`
+	Quantity int64   `json:"quantity,omitempty"`
+	Gross    float64 `json:"gross"`
+	Revenue  float64 `json:"revenue"`
+}
+
+// OrderReport is the grouped rows and the totals per currency
+type OrderReport struct {
+	GroupBy string           `json:"group_by"`
+	From    *time.Time       `json:"from,omitempty"`
+	To      *time.Time       `json:"to,omitempty"`
+	Rows    []OrderReportRow `json:"rows"`
+	Totals  []OrderReportRow `json:"totals"`
+}
+
+// OrderReportService defines the interface for the local order copy and its reports
+type OrderReportService interface {
+	StoreOrders(tenantID uint, orders []Order, source string) error
+	OrderReport(tenantID uint, query OrderReportQuery) (OrderReport, error)
+}
+
+// GormOrderReportService implements OrderReportService using GORM
+type GormOrderReportService struct {
+	db *gorm.DB
+}
+
+// NewGormOrderReportService creates a new GormOrderReportService
+func NewGormOrderReportService(db *gorm.DB) OrderReportService {
+	return &GormOrderReportService{db: db}
+}
+
+// StoreOrders upserts orders and replaces their lines; a source is kept once set
+func (s *GormOrderReportService) StoreOrders(tenantID uint, orders []Order, source string) error {
+	return storeOrders(s.db, tenantID, orders, source)
+}
+
+// storeOrders is shared with the order service, which records the orders the chatbot places
+func storeOrders(db *gorm.DB, tenantID uint, orders []Order, source string) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	now := time.Now()
+	records := make([]OrderRecord, 0, len(orders))
+	ids := make([]string, 0, len(orders))
+	var items []OrderItemRecord
+	for _, order := range orders {
+		orderedAt := order.CreatedAt
+		if orderedAt.IsZero() {
+			orderedAt = now
+		}
+		changedAt := order.UpdatedAt
+		if changedAt.IsZero() {
+			changedAt = orderedAt
+		}
+		records = append(records, OrderRecord{
+			TenantID:        tenantID,
+			OrderID:         order.ID,
+			Customer:        order.Customer,
+			Status:          order.Status,
+			Total:           order.Total,
+			Currency:        strings.ToUpper(order.Currency),
+			DeliveryCompany: order.DeliveryCompany,
+			TrackingNumber:  order.TrackingNumber,
+			OrderedAt:       orderedAt,
+			ChangedAt:       changedAt,
+			Source:          source,
+			SyncedAt:        now,
+		})
+		ids = append(ids, order.ID)
+		for _, item := range order.Items {
+			items = append(items, OrderItemRecord{
+				TenantID:  tenantID,
+				OrderID:   order.ID,
+				ProductID: item.ProductID,
+				Name:      item.Name,
+				Category:  item.Category,
+				Quantity:  item.Quantity,
+				Price:     item.Price,
+			})
+		}
+	}
+	updates := []string{"customer", "status", "total", "currency", "delivery_company", "tracking_number", "ordered_at", "upstream_updated_at", "synced_at"}
+	if source != "" {
+		updates = append(updates, "source")
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "order_id"}},
+			DoUpdates: clause.AssignmentColumns(updates),
+		}).CreateInBatches(&records, 500).Error
+		if err != nil {
+			return fmt.Errorf("failed to store orders: %v", err)
+		}
+		if err := tx.Where("tenant_id = ? AND order_id IN ?", tenantID, ids).Delete(&OrderItemRecord{}).Error; err != nil {
+			return fmt.Errorf("failed to replace order lines: %v", err)
+		}
+		if len(items) > 0 {
+			if err := tx.CreateInBatches(&items, 500).Error; err != nil {
+				return fmt.Errorf("failed to store order lines: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// orderReportGroups maps a grouping to the SQL expression naming each group
+var orderReportGroups = map[string]string{
+	GroupByDay:     "to_char(date_trunc('day', o.ordered_at), 'YYYY-MM-DD')",
+	GroupByWeek:    `to_char(date_trunc('week', o.ordered_at), 'IYYY-"W"IW')`,
+	GroupByStatus:  "lower(o.status)",
+	GroupByProduct: "i.product_id",
+}
+
+// ValidOrderGrouping reports whether an order report can be grouped by groupBy
+func ValidOrderGrouping(groupBy string) bool {
+	_, ok := orderReportGroups[groupBy]
+	return ok
+}
+
+// OrderReport totals the copied orders of the tenant per group and currency
+func (s *GormOrderReportService) OrderReport(tenantID uint, query OrderReportQuery) (OrderReport, error) {
+	group, ok := orderReportGroups[query.GroupBy]
+	if !ok {
+		return OrderReport{}, fmt.Errorf("invalid group_by %q, expected day, week, status or product", query.GroupBy)
+	}
+	report := OrderReport{GroupBy: query.GroupBy, From: query.From, To: query.To}
+
+	excluded := make([]string, 0, len(nonTaxableStatuses))
+	for status := range nonTaxableStatuses {
+		excluded = append(excluded, status)
+	}
+	sort.Strings(excluded)
+
+	rows := s.filteredOrders(tenantID, query)
+	if query.GroupBy == GroupByProduct {
+		rows = rows.Joins("JOIN chatbot.order_items AS i ON i.tenant_id = o.tenant_id AND i.order_id = o.order_id").
+			Select(group+` AS "group", max(i.name) AS name, o.currency, count(DISTINCT o.order_id) AS orders, `+
+				"sum(i.quantity) AS quantity, sum(i.quantity * i.price) AS gross, "+
+				"sum(CASE WHEN lower(o.status) IN ? THEN 0 ELSE i.quantity * i.price END) AS revenue", excluded).
+			Group(group + ", o.currency").Order("revenue desc, 1")
+	} else {
+		rows = rows.Select(group+` AS "group", o.currency, count(*) AS orders, sum(o.total) AS gross, `+
+			"sum(CASE WHEN lower(o.status) IN ? THEN 0 ELSE o.total END) AS revenue", excluded).
+			Group(group + ", o.currency").Order("1, o.currency")
+	}
+	if err := rows.Scan(&report.Rows).Error; err != nil {
+		return OrderReport{}, fmt.Errorf("failed to build order report: %v", err)
+	}
+
+	err := s.filteredOrders(tenantID, query).
+		Select(`'total' AS "group", o.currency, count(*) AS orders, sum(o.total) AS gross, `+
+			"sum(CASE WHEN lower(o.status) IN ? THEN 0 ELSE o.total END) AS revenue", excluded).
+		Group("o.currency").Order("o.currency").Scan(&report.Totals).Error
+	if err != nil {
+		return OrderReport{}, fmt.Errorf("failed to total order report: %v", err)
+	}
+	for _, rows := range [][]OrderReportRow{report.Rows, report.Totals} {
+		for i := range rows {
+			rows[i].Gross = roundMillimes(rows[i].Gross)
+			rows[i].Revenue = roundMillimes(rows[i].Revenue)
+		}
+	}
+	return report, nil
+}
+
+// filteredOrders selects the tenant's copied orders matching the query filters
+func (s *GormOrderReportService) filteredOrders(tenantID uint, query OrderReportQuery) *gorm.DB {
+	orders := s.db.Table("chatbot.orders AS o").Where("o.tenant_id = ?", tenantID)
+	if query.From != nil {
+		orders = orders.Where("o.ordered_at >= ?", *query.From)
+	}
+	if query.To != nil {
+		orders = orders.Where("o.ordered_at < ?", *query.To)
+	}
+	if query.Status != "" {
+		orders = orders.Where("lower(o.status) = ?", strings.ToLower(query.Status))
+	}
+	if query.Source != "" {
+		orders = orders.Where("o.source = ?", query.Source)
+	}
+	return orders
+}
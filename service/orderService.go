@@ -0,0 +1,276 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Duplicate order handling modes
+const (
+	DedupOff    = "off"
+	DedupFlag   = "flag"
+	DedupReject = "reject"
+)
+
+// OrderReviewType is the record type of orders flagged for review
+const OrderReviewType = "order_review"
+
+// ErrDuplicateOrder rejects an order that repeats a recent one
+var ErrDuplicateOrder = errors.New("duplicate_order")
+
+// ErrInvalidOrder rejects an order missing what Converty needs
+var ErrInvalidOrder = errors.New("invalid order")
+
+// dedupMaxPages bounds how many upstream pages are searched for a duplicate
+const dedupMaxPages = 3
+
+// OrderDedupSettings is how a tenant handles orders repeating a recent order of the same phone and product
+type OrderDedupSettings struct {
+	TenantID uint   `gorm:"primaryKey;autoIncrement:false" json:"tenant_id"`
+	Mode     string `gorm:"not null" json:"mode"`
+	// WindowMinutes is how far back recent orders are compared
+	WindowMinutes int       `gorm:"not null" json:"window_minutes"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for OrderDedupSettings
+func (OrderDedupSettings) TableName() string {
+	return "chatbot.order_dedup_settings"
+}
+
+// Window is the dedup window as a duration
+func (s OrderDedupSettings) Window() time.Duration {
+	return time.Duration(s.WindowMinutes) * time.Minute
+}
+
+// validateDedupSettings checks the settings an operator provides
+func validateDedupSettings(settings OrderDedupSettings) error {
+	switch settings.Mode {
+	case DedupOff, DedupFlag, DedupReject:
+	default:
+		return fmt.Errorf("invalid mode %q, expected off, flag or reject", settings.Mode)
+	}
+	if settings.Mode != DedupOff && settings.WindowMinutes <= 0 {
+		return fmt.Errorf("window_minutes must be positive")
+	}
+	return nil
+}
+
+// DuplicateOrderError lists the recent orders a new order repeats
+type DuplicateOrderError struct {
+	Duplicates []string
+}
+
+func (e *DuplicateOrderError) Error() string {
+	return fmt.Sprintf("%v: an order for the same phone and product was placed recently (%s)", ErrDuplicateOrder, strings.Join(e.Duplicates, ", "))
+}
+
+func (e *DuplicateOrderError) Unwrap() error {
+	return ErrDuplicateOrder
+}
+
+// OrderCreation is the outcome of a submitted order
+type OrderCreation struct {
+	Order Order `json:"order"`
+	// Duplicates are the recent orders it repeats; a flagged order was created anyway
+	Duplicates []string `json:"duplicates,omitempty"`
+	Flagged    bool     `json:"flagged"`
+	ReviewID   uint     `json:"review_id,omitempty"`
+}
+
+// OrderPreview is the outcome of a dry run: the checks CreateOrder would make and the Converty call it would send
+type OrderPreview struct {
+	DryRun     bool            `json:"dry_run"`
+	Order      NewOrder        `json:"order"`
+	Request    UpstreamRequest `json:"request"`
+	Duplicates []string        `json:"duplicates,omitempty"`
+	// WouldFlag is set when the order would be created and flagged for review
+	WouldFlag bool `json:"would_flag"`
+}
+
+// OrderService defines the interface for order creation
+type OrderService interface {
+	// CreateOrder submits an order through the tenant-scoped dataService after checking it for duplicates
+	CreateOrder(dataService DataService, tenantID uint, order NewOrder) (OrderCreation, error)
+	// PreviewOrder runs the checks of CreateOrder without creating or flagging anything
+	PreviewOrder(dataService DataService, tenantID uint, order NewOrder) (OrderPreview, error)
+	// CancelOrder cancels a cancellable order through the tenant-scoped dataService
+	CancelOrder(dataService DataService, tenantID uint, id string, request CancelOrderRequest) (OrderCancellation, error)
+	DedupSettings(tenantID uint) (OrderDedupSettings, error)
+	SetDedupSettings(settings OrderDedupSettings) (OrderDedupSettings, error)
+}
+
+// GormOrderService implements OrderService using GORM
+type GormOrderService struct {
+	db *gorm.DB
+	// defaults apply to tenants without stored settings
+	defaults OrderDedupSettings
+	// notifier tells the chatbot about cancellations it asked to be notified of
+	notifier Notifier
+}
+
+// NewGormOrderService creates a new GormOrderService
+func NewGormOrderService(db *gorm.DB, defaults OrderDedupSettings, notifier Notifier) OrderService {
+	return &GormOrderService{db: db, defaults: defaults, notifier: notifier}
+}
+
+// validateNewOrder checks what Converty needs to accept an order
+func validateNewOrder(order *NewOrder) error {
+	order.Customer.Phone = NormalizePhone(order.Customer.Phone)
+	if order.Customer.Phone == "" {
+		return fmt.Errorf("%w: customer.phone is required", ErrInvalidOrder)
+	}
+	if len(order.Items) == 0 {
+		return fmt.Errorf("%w: at least one item is required", ErrInvalidOrder)
+	}
+	for _, item := range order.Items {
+		if item.ProductID == "" || item.Quantity <= 0 {
+			return fmt.Errorf("%w: every item needs a product_id and a positive quantity", ErrInvalidOrder)
+		}
+	}
+	return nil
+}
+
+// CreateOrder rejects or flags an order that repeats a recent one according to the tenant's settings
+func (s *GormOrderService) CreateOrder(dataService DataService, tenantID uint, order NewOrder) (OrderCreation, error) {
+	if err := validateNewOrder(&order); err != nil {
+		return OrderCreation{}, err
+	}
+	duplicates, err := s.checkDuplicates(dataService, tenantID, order)
+	if err != nil {
+		return OrderCreation{}, err
+	}
+
+	created, err := dataService.CreateOrder(order)
+	if err != nil {
+		return OrderCreation{}, err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := storeOrders(tx, tenantID, []Order{created}, OrderSourceChatbot); err != nil {
+			return err
+		}
+		return WriteOutbox(tx, Event{
+			Type:     EventOrderCreated,
+			TenantID: tenantID,
+			Data: map[string]interface{}{
+				"order_id": created.ID, "status": created.Status, "total": created.Total,
+				"currency": created.Currency, "items": len(created.Items), "duplicates": len(duplicates),
+			},
+		})
+	})
+	if err != nil {
+		// Reports miss the order until the next sync; the order itself was created
+		log.Printf("Failed to record chatbot order %s: %v", created.ID, err)
+	}
+	result := OrderCreation{Order: created, Duplicates: duplicates}
+	if len(duplicates) > 0 {
+		review, err := dataService.InsertRecord(0, OrderReviewType, map[string]interface{}{
+			"reason":       ErrDuplicateOrder.Error(),
+			"order_id":     created.ID,
+			"duplicate_of": duplicates,
+			"phone":        order.Customer.Phone,
+		}, StatusPending)
+		if err != nil {
+			// The order exists upstream; failing here would make the chatbot submit it again
+			return result, fmt.Errorf("order %s created but could not be flagged for review: %v", created.ID, err)
+		}
+		result.Flagged = true
+		result.ReviewID = review.ID
+	}
+	return result, nil
+}
+
+// PreviewOrder validates an order and checks it for duplicates, returning the request that would create it
+func (s *GormOrderService) PreviewOrder(dataService DataService, tenantID uint, order NewOrder) (OrderPreview, error) {
+	if err := validateNewOrder(&order); err != nil {
+		return OrderPreview{}, err
+	}
+	duplicates, err := s.checkDuplicates(dataService, tenantID, order)
+	if err != nil {
+		return OrderPreview{}, err
+	}
+	request, err := dataService.PreviewOrder(order)
+	if err != nil {
+		return OrderPreview{}, err
+	}
+	return OrderPreview{DryRun: true, Order: order, Request: request, Duplicates: duplicates, WouldFlag: len(duplicates) > 0}, nil
+}
+
+// checkDuplicates returns the recent orders a validated order repeats, or a DuplicateOrderError when
+// the tenant rejects duplicates
+func (s *GormOrderService) checkDuplicates(dataService DataService, tenantID uint, order NewOrder) ([]string, error) {
+	settings, err := s.DedupSettings(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Mode == DedupOff {
+		return nil, nil
+	}
+	now := time.Now()
+	recent, err := CollectOrders(dataService, CustomerOrderQuery{Limit: 100, Search: order.Customer.Phone},
+		now.Add(-settings.Window()), now.Add(time.Minute), dedupMaxPages)
+	// A customer with more recent orders than the pages hold is checked against those read
+	if err != nil && !errors.Is(err, ErrOrdersTruncated) {
+		return nil, fmt.Errorf("failed to check for duplicate orders: %w", err)
+	}
+	duplicates := findDuplicateOrders(order, recent)
+	if len(duplicates) > 0 && settings.Mode == DedupReject {
+		return nil, &DuplicateOrderError{Duplicates: duplicates}
+	}
+	return duplicates, nil
+}
+
+// findDuplicateOrders returns the IDs of the recent orders with the same phone and a common product,
+// ignoring cancelled ones
+func findDuplicateOrders(order NewOrder, recent []Order) []string {
+	products := make(map[string]bool, len(order.Items))
+	for _, item := range order.Items {
+		products[item.ProductID] = true
+	}
+	var duplicates []string
+	for _, candidate := range recent {
+		if nonTaxableStatuses[strings.ToLower(candidate.Status)] || NormalizePhone(candidate.Customer.Phone) != order.Customer.Phone {
+			continue
+		}
+		for _, item := range candidate.Items {
+			if products[item.ProductID] {
+				duplicates = append(duplicates, candidate.ID)
+				break
+			}
+		}
+	}
+	return duplicates
+}
+
+// DedupSettings returns the tenant's settings or the defaults
+func (s *GormOrderService) DedupSettings(tenantID uint) (OrderDedupSettings, error) {
+	var settings OrderDedupSettings
+	err := s.db.Where("tenant_id = ?", tenantID).First(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		settings = s.defaults
+		settings.TenantID = tenantID
+		return settings, nil
+	}
+	if err != nil {
+		return OrderDedupSettings{}, fmt.Errorf("failed to fetch duplicate order settings: %v", err)
+	}
+	return settings, nil
+}
+
+// SetDedupSettings stores the settings of a tenant
+func (s *GormOrderService) SetDedupSettings(settings OrderDedupSettings) (OrderDedupSettings, error) {
+	if err := validateDedupSettings(settings); err != nil {
+		return OrderDedupSettings{}, err
+	}
+	settings.UpdatedAt = time.Now()
+	if err := s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&settings).Error; err != nil {
+		return OrderDedupSettings{}, fmt.Errorf("failed to save duplicate order settings: %v", err)
+	}
+	return settings, nil
+}
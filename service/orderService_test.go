@@ -0,0 +1,67 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFindDuplicateOrders(t *testing.T) {
+	order := NewOrder{Customer: Customer{Phone: "+21674000000"}, Items: []OrderLine{{ProductID: "p-1", Quantity: 1}, {ProductID: "p-2", Quantity: 2}}}
+	recent := []Order{
+		{ID: "same", Customer: Customer{Phone: "+216 74 000 000"}, Status: "pending", Items: []OrderLine{{ProductID: "p-2"}}},
+		{ID: "other-product", Customer: Customer{Phone: "+21674000000"}, Items: []OrderLine{{ProductID: "p-3"}}},
+		{ID: "other-phone", Customer: Customer{Phone: "+21698111222"}, Items: []OrderLine{{ProductID: "p-1"}}},
+		{ID: "cancelled", Customer: Customer{Phone: "+21674000000"}, Status: "Cancelled", Items: []OrderLine{{ProductID: "p-1"}}},
+		{ID: "both", Customer: Customer{Phone: "+21674000000"}, Items: []OrderLine{{ProductID: "p-1"}, {ProductID: "p-2"}}},
+	}
+	if got := findDuplicateOrders(order, recent); !reflect.DeepEqual(got, []string{"same", "both"}) {
+		t.Errorf("duplicates = %v", got)
+	}
+}
+
+func TestValidateNewOrder(t *testing.T) {
+	order := NewOrder{Customer: Customer{Phone: " +216 (74) 000-000"}, Items: []OrderLine{{ProductID: "p-1", Quantity: 1}}}
+	if err := validateNewOrder(&order); err != nil {
+		t.Fatal(err)
+	}
+	if order.Customer.Phone != "+21674000000" {
+		t.Errorf("phone not normalized: %q", order.Customer.Phone)
+	}
+	invalid := []NewOrder{
+		{Items: []OrderLine{{ProductID: "p-1", Quantity: 1}}},
+		{Customer: Customer{Phone: "1"}},
+		{Customer: Customer{Phone: "1"}, Items: []OrderLine{{ProductID: "p-1"}}},
+		{Customer: Customer{Phone: "1"}, Items: []OrderLine{{Quantity: 1}}},
+	}
+	for _, order := range invalid {
+		if err := validateNewOrder(&order); !errors.Is(err, ErrInvalidOrder) {
+			t.Errorf("%+v: got %v, want ErrInvalidOrder", order, err)
+		}
+	}
+}
+
+func TestDuplicateOrderError(t *testing.T) {
+	var err error = &DuplicateOrderError{Duplicates: []string{"o-1"}}
+	if !errors.Is(err, ErrDuplicateOrder) {
+		t.Error("DuplicateOrderError must match ErrDuplicateOrder")
+	}
+	for _, settings := range []OrderDedupSettings{{Mode: "warn", WindowMinutes: 5}, {Mode: DedupReject}} {
+		if validateDedupSettings(settings) == nil {
+			t.Errorf("%+v must be rejected", settings)
+		}
+	}
+	if validateDedupSettings(OrderDedupSettings{Mode: DedupOff}) != nil {
+		t.Error("off needs no window")
+	}
+}
+
+func TestOrderCancellable(t *testing.T) {
+	for status, want := range map[string]bool{
+		"pending": true, "Confirmed": true, "in_transit": false, "delivered": false, OrderCancelled: false, "returned": false, "": false,
+	} {
+		if got := OrderCancellable(status); got != want {
+			t.Errorf("%q: got %v, want %v", status, got, want)
+		}
+	}
+}
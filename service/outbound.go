@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// OutboundConfig configures the transport of every outbound HTTP call: Converty, carriers,
+// payment providers, webhooks and exchange rates
+type OutboundConfig struct {
+	// ProxyURL is the HTTP(S) proxy for all outbound calls; empty falls back to HTTPS_PROXY/HTTP_PROXY
+	ProxyURL string
+	// NoProxy lists hosts, domains (".example.com") and CIDRs reached directly, like NO_PROXY
+	NoProxy string
+	// CABundle is a PEM file of extra trusted certificate authorities, e.g. the proxy's
+	CABundle string
+	// TLSMinVersion is "1.0", "1.1", "1.2" or "1.3"; empty keeps Go's default
+	TLSMinVersion string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var (
+	outboundMu sync.RWMutex
+	// outbound is the configured transport; nil uses http.DefaultTransport
+	outbound http.RoundTripper
+)
+
+// ConfigureOutbound builds the outbound transport from config; an empty config keeps http.DefaultTransport
+func ConfigureOutbound(config OutboundConfig) error {
+	transport, err := NewOutboundTransport(config)
+	if err != nil {
+		return err
+	}
+	outboundMu.Lock()
+	defer outboundMu.Unlock()
+	outbound = nil
+	if transport != nil {
+		outbound = transport
+	}
+	return nil
+}
+
+// NewOutboundTransport builds a transport applying the proxy and TLS settings, or returns nil when
+// config changes nothing
+func NewOutboundTransport(config OutboundConfig) (*http.Transport, error) {
+	if config == (OutboundConfig{}) {
+		return nil, nil
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       &tls.Config{},
+	}
+
+	if config.ProxyURL != "" || config.NoProxy != "" {
+		proxy := httpproxy.FromEnvironment()
+		if config.ProxyURL != "" {
+			parsed, err := url.Parse(config.ProxyURL)
+			if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https" && parsed.Scheme != "socks5") {
+				return nil, fmt.Errorf("invalid proxy URL %q, expected http://, https:// or socks5://host:port", config.ProxyURL)
+			}
+			proxy.HTTPProxy = config.ProxyURL
+			proxy.HTTPSProxy = config.ProxyURL
+		}
+		if config.NoProxy != "" {
+			proxy.NoProxy = config.NoProxy
+		}
+		proxyFunc := proxy.ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+
+	if config.CABundle != "" {
+		pem, err := os.ReadFile(config.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s holds no PEM certificates", config.CABundle)
+		}
+		transport.TLSClientConfig.RootCAs = roots
+	}
+
+	if config.TLSMinVersion != "" {
+		version, ok := tlsVersions[strings.TrimPrefix(config.TLSMinVersion, "TLS")]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLS minimum version %q, expected 1.0, 1.1, 1.2 or 1.3", config.TLSMinVersion)
+		}
+		transport.TLSClientConfig.MinVersion = version
+	}
+	return transport, nil
+}
+
+// outboundTransport resolves the configured transport on every request, so clients created before
+// ConfigureOutbound (package variables) still use it
+type outboundTransport struct{}
+
+// RoundTrip sends the request through the configured transport or http.DefaultTransport
+func (outboundTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	outboundMu.RLock()
+	transport := outbound
+	outboundMu.RUnlock()
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(req)
+}
+
+// NewHTTPClient creates a client for outbound calls with the configured proxy and TLS settings
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: outboundTransport{}}
+}
+
+// ErrPrivateAddress is returned when a tenant-supplied URL resolves to an address inside the
+// deployment's network
+var ErrPrivateAddress = errors.New("address is not publicly routable")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), not covered by net.IP.IsPrivate
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// PublicAddress reports whether ip may be reached by calls to tenant-supplied URLs: loopback,
+// private, link-local (cloud metadata), unspecified, multicast and CGNAT addresses are refused
+func PublicAddress(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// CheckPublicHost resolves host and fails with ErrPrivateAddress when any of its addresses is not public
+func CheckPublicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", host, err)
+	}
+	for _, addr := range addrs {
+		if !PublicAddress(addr.IP) {
+			return fmt.Errorf("%s resolves to %s: %w", host, addr.IP, ErrPrivateAddress)
+		}
+	}
+	return nil
+}
+
+// publicOnlyControl refuses connections to non-public addresses once the name is resolved, so a
+// host re-pointed after it was checked (DNS rebinding) is still refused
+func publicOnlyControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !PublicAddress(ip) {
+		return fmt.Errorf("refusing to connect to %s: %w", host, ErrPrivateAddress)
+	}
+	return nil
+}
+
+// publicOnlyTransport sends requests through the configured transport with a dialer refusing
+// non-public addresses
+type publicOnlyTransport struct {
+	mu sync.Mutex
+	// base is the transport guarded was cloned from, rebuilt when ConfigureOutbound replaces it
+	base    http.RoundTripper
+	guarded *http.Transport
+}
+
+// RoundTrip sends the request, checking the target up front when it goes through a proxy since
+// the dialer then only sees the proxy's address
+func (t *publicOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.transport()
+	if transport.Proxy != nil {
+		proxy, err := transport.Proxy(req)
+		if err != nil {
+			return nil, err
+		}
+		if proxy != nil {
+			if err := CheckPublicHost(req.Context(), req.URL.Hostname()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return transport.RoundTrip(req)
+}
+
+// transport returns the guarded clone of the configured transport
+func (t *publicOnlyTransport) transport() *http.Transport {
+	outboundMu.RLock()
+	base := outbound
+	outboundMu.RUnlock()
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.guarded == nil || t.base != base {
+		guarded := &http.Transport{Proxy: http.ProxyFromEnvironment}
+		if configured, ok := base.(*http.Transport); ok {
+			guarded = configured.Clone()
+		}
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: publicOnlyControl}
+		guarded.DialContext = dialer.DialContext
+		guarded.DialTLSContext = nil
+		t.base, t.guarded = base, guarded
+	}
+	return t.guarded
+}
+
+// NewPublicHTTPClient creates a client for calls to tenant-supplied URLs, such as webhooks, which
+// may only reach public addresses
+func NewPublicHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: &publicOnlyTransport{}}
+}
@@ -0,0 +1,306 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Event types of orders, written to the outbox only
+const (
+	EventOrderCreated   = "order.created"
+	EventOrderCancelled = "order.cancelled"
+)
+
+// Outbox event delivery states
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxDead      = "dead"
+)
+
+// ErrOutboxEventNotFound is returned when an outbox event does not exist
+var ErrOutboxEventNotFound = errors.New("outbox event not found")
+
+// OutboxEnabled makes data changes write their events to the outbox; it is set when a destination is configured
+var OutboxEnabled bool
+
+// OutboxEvent is an event waiting for, or done with, delivery to the external destinations
+type OutboxEvent struct {
+	ID       uint           `gorm:"primaryKey" json:"id"`
+	TenantID uint           `gorm:"not null;default:0" json:"tenant_id"`
+	Type     string         `gorm:"not null;index" json:"type"`
+	Data     datatypes.JSON `json:"data"`
+	Status   string         `gorm:"not null;index:idx_outbox_due" json:"status"`
+	Attempts int            `json:"attempts"`
+	// NextAttemptAt is when the dispatcher picks the event up again; it also leases events being delivered
+	NextAttemptAt time.Time `gorm:"index:idx_outbox_due" json:"next_attempt_at"`
+	// DeliveredTo lists the destinations that accepted the event, so a retry skips them
+	DeliveredTo string     `json:"delivered_to,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// TableName specifies the table name for OutboxEvent
+func (OutboxEvent) TableName() string {
+	return "public.outbox_events"
+}
+
+// OutboxMessage is the body delivered to every destination; consumers deduplicate on ID
+type OutboxMessage struct {
+	ID       uint            `json:"id"`
+	Type     string          `json:"type"`
+	TenantID uint            `json:"tenant_id"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Time     time.Time       `json:"time"`
+}
+
+// WriteOutbox records event in the transaction tx that makes the data change, when the outbox is enabled
+func WriteOutbox(tx *gorm.DB, event Event) error {
+	if !OutboxEnabled {
+		return nil
+	}
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox event: %v", err)
+	}
+	now := time.Now()
+	if event.Time.IsZero() {
+		event.Time = now
+	}
+	row := OutboxEvent{
+		TenantID:      event.TenantID,
+		Type:          event.Type,
+		Data:          data,
+		Status:        OutboxPending,
+		NextAttemptAt: now,
+		CreatedAt:     event.Time,
+	}
+	if err := tx.Create(&row).Error; err != nil {
+		return fmt.Errorf("failed to write outbox event: %v", err)
+	}
+	return nil
+}
+
+// OutboxFilter narrows an outbox listing; zero values are ignored
+type OutboxFilter struct {
+	Status string
+	Type   string
+	Limit  int
+}
+
+// OutboxDispatchResult counts the outcome of one dispatch round
+type OutboxDispatchResult struct {
+	Delivered int `json:"delivered"`
+	Retried   int `json:"retried"`
+	Dead      int `json:"dead"`
+}
+
+// OutboxService delivers outbox events and lets operators inspect and replay them
+type OutboxService interface {
+	// Dispatch delivers one batch of due events to every destination that has not accepted them yet
+	Dispatch() (OutboxDispatchResult, error)
+	// Run dispatches every interval until the process exits, purging delivered events older than retention
+	Run(interval, retention time.Duration)
+	ListEvents(filter OutboxFilter) ([]OutboxEvent, error)
+	// Retry queues a dead or pending event for immediate delivery with a fresh attempt count
+	Retry(id uint) (OutboxEvent, error)
+}
+
+// GormOutboxService implements OutboxService using GORM
+type GormOutboxService struct {
+	db           *gorm.DB
+	destinations []OutboxDestination
+	maxAttempts  int
+	batchSize    int
+}
+
+// outboxLease is how long a claimed event stays hidden from other dispatchers
+const outboxLease = 2 * time.Minute
+
+// NewGormOutboxService creates a new GormOutboxService; an event is dead-lettered after maxAttempts failed deliveries
+func NewGormOutboxService(db *gorm.DB, destinations []OutboxDestination, maxAttempts int) OutboxService {
+	if maxAttempts <= 0 {
+		maxAttempts = 10
+	}
+	return &GormOutboxService{db: db, destinations: destinations, maxAttempts: maxAttempts, batchSize: 50}
+}
+
+// Dispatch claims due events by leasing them, then delivers them outside the claiming transaction
+func (s *GormOutboxService) Dispatch() (OutboxDispatchResult, error) {
+	var result OutboxDispatchResult
+	if len(s.destinations) == 0 {
+		return result, nil
+	}
+	var events []OutboxEvent
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", OutboxPending, now).
+			Order("id").Limit(s.batchSize).Find(&events).Error
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		ids := make([]uint, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		return tx.Model(&OutboxEvent{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(outboxLease)).Error
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to claim outbox events: %v", err)
+	}
+
+	for _, event := range events {
+		switch s.deliver(&event) {
+		case OutboxDelivered:
+			result.Delivered++
+		case OutboxDead:
+			result.Dead++
+		default:
+			result.Retried++
+		}
+	}
+	return result, nil
+}
+
+// deliver sends event to the destinations still missing it and records the outcome, returning the new status
+func (s *GormOutboxService) deliver(event *OutboxEvent) string {
+	message := OutboxMessage{ID: event.ID, Type: event.Type, TenantID: event.TenantID, Data: json.RawMessage(event.Data), Time: event.CreatedAt}
+	delivered := splitDelivered(event.DeliveredTo)
+	var failures []string
+	for _, destination := range s.destinations {
+		if delivered[destination.Name()] {
+			continue
+		}
+		if err := destination.Deliver(message); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", destination.Name(), err))
+			continue
+		}
+		delivered[destination.Name()] = true
+		event.DeliveredTo = strings.TrimPrefix(event.DeliveredTo+","+destination.Name(), ",")
+	}
+
+	now := time.Now()
+	event.Attempts++
+	updates := map[string]interface{}{"attempts": event.Attempts, "delivered_to": event.DeliveredTo}
+	switch {
+	case len(failures) == 0:
+		event.Status = OutboxDelivered
+		updates["delivered_at"] = now
+		updates["last_error"] = ""
+	case event.Attempts >= s.maxAttempts:
+		event.Status = OutboxDead
+		updates["last_error"] = strings.Join(failures, "; ")
+		log.Printf("Outbox event %d (%s) dead-lettered after %d attempts: %s", event.ID, event.Type, event.Attempts, updates["last_error"])
+	default:
+		event.Status = OutboxPending
+		updates["last_error"] = strings.Join(failures, "; ")
+		updates["next_attempt_at"] = now.Add(outboxBackoff(event.Attempts))
+	}
+	updates["status"] = event.Status
+	if err := s.db.Model(&OutboxEvent{ID: event.ID}).Updates(updates).Error; err != nil {
+		log.Printf("Outbox event %d: failed to record delivery: %v", event.ID, err)
+	}
+	return event.Status
+}
+
+// outboxBackoff doubles the wait after every failed attempt, from 10 seconds up to an hour
+func outboxBackoff(attempts int) time.Duration {
+	wait := 10 * time.Second
+	for i := 1; i < attempts && wait < time.Hour; i++ {
+		wait *= 2
+	}
+	if wait > time.Hour {
+		wait = time.Hour
+	}
+	return wait
+}
+
+// splitDelivered parses the DeliveredTo list
+func splitDelivered(deliveredTo string) map[string]bool {
+	delivered := make(map[string]bool)
+	for _, name := range strings.Split(deliveredTo, ",") {
+		if name != "" {
+			delivered[name] = true
+		}
+	}
+	return delivered
+}
+
+// Run dispatches until the batch is drained, then waits for the next interval
+func (s *GormOutboxService) Run(interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastPurge := time.Time{}
+	for range ticker.C {
+		for {
+			result, err := s.Dispatch()
+			if err != nil {
+				log.Printf("Outbox dispatch failed: %v", err)
+				break
+			}
+			if result.Delivered+result.Retried+result.Dead < s.batchSize {
+				break
+			}
+		}
+		if retention > 0 && time.Since(lastPurge) > time.Hour {
+			lastPurge = time.Now()
+			err := s.db.Where("status = ? AND delivered_at < ?", OutboxDelivered, lastPurge.Add(-retention)).Delete(&OutboxEvent{}).Error
+			if err != nil {
+				log.Printf("Failed to purge delivered outbox events: %v", err)
+			}
+		}
+	}
+}
+
+// ListEvents returns outbox events, newest first
+func (s *GormOutboxService) ListEvents(filter OutboxFilter) ([]OutboxEvent, error) {
+	query := s.db.Model(&OutboxEvent{})
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+	var events []OutboxEvent
+	if err := query.Order("id desc").Limit(filter.Limit).Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to list outbox events: %v", err)
+	}
+	return events, nil
+}
+
+// Retry requeues an undelivered event
+func (s *GormOutboxService) Retry(id uint) (OutboxEvent, error) {
+	var event OutboxEvent
+	if err := s.db.First(&event, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return OutboxEvent{}, fmt.Errorf("%w: %d", ErrOutboxEventNotFound, id)
+		}
+		return OutboxEvent{}, fmt.Errorf("failed to load outbox event: %v", err)
+	}
+	if event.Status == OutboxDelivered {
+		return event, nil
+	}
+	event.Status = OutboxPending
+	event.Attempts = 0
+	event.NextAttemptAt = time.Now()
+	err := s.db.Model(&event).Updates(map[string]interface{}{
+		"status": event.Status, "attempts": 0, "next_attempt_at": event.NextAttemptAt,
+	}).Error
+	if err != nil {
+		return OutboxEvent{}, fmt.Errorf("failed to requeue outbox event: %v", err)
+	}
+	return event, nil
+}
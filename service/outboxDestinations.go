@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OutboxDestination is an external system receiving outbox events
+type OutboxDestination interface {
+	// Name identifies the destination in DeliveredTo; it must not change between restarts
+	Name() string
+	Deliver(message OutboxMessage) error
+}
+
+// WebhookDestination posts each event as JSON, signed with HMAC-SHA256 when a secret is set
+type WebhookDestination struct {
+	URL    string
+	Secret string
+	client *http.Client
+}
+
+// NewWebhookDestination creates a WebhookDestination
+func NewWebhookDestination(url, secret string) *WebhookDestination {
+	return &WebhookDestination{URL: url, Secret: secret, client: NewHTTPClient(10 * time.Second)}
+}
+
+// Name implements OutboxDestination
+func (d *WebhookDestination) Name() string { return "webhook" }
+
+// Deliver implements OutboxDestination; any 2xx response accepts the event
+func (d *WebhookDestination) Deliver(message OutboxMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Outbox-Event", strconv.FormatUint(uint64(message.ID), 10))
+	req.Header.Set("X-Outbox-Event-Type", message.Type)
+	if d.Secret != "" {
+		req.Header.Set("X-Outbox-Signature", "sha256="+SignOutboxBody(d.Secret, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post event: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignOutboxBody returns the hex HMAC-SHA256 of body that receivers compare with X-Outbox-Signature
+func SignOutboxBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// KafkaDestination produces each event to a topic through a Kafka REST Proxy (v2 API), keyed by event ID
+type KafkaDestination struct {
+	// URL is the proxy's topic endpoint, e.g. http://rest-proxy:8082/topics/interactions
+	URL    string
+	client *http.Client
+}
+
+// NewKafkaDestination creates a KafkaDestination for the REST proxy at baseURL and topic
+func NewKafkaDestination(baseURL, topic string) *KafkaDestination {
+	return &KafkaDestination{
+		URL:    strings.TrimRight(baseURL, "/") + "/topics/" + url.PathEscape(topic),
+		client: NewHTTPClient(10 * time.Second),
+	}
+}
+
+// Name implements OutboxDestination
+func (d *KafkaDestination) Name() string { return "kafka" }
+
+// Deliver implements OutboxDestination; the event counts as produced when the proxy reports no per-record error
+func (d *KafkaDestination) Deliver(message OutboxMessage) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{"key": strconv.FormatUint(uint64(message.ID), 10), "value": message}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Kafka REST proxy: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kafka REST proxy returned status %d: %s", resp.StatusCode, respBody)
+	}
+	var produced struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(respBody, &produced); err != nil {
+		return fmt.Errorf("failed to parse Kafka REST proxy response: %v", err)
+	}
+	for _, offset := range produced.Offsets {
+		if offset.ErrorCode != nil || offset.Error != "" {
+			return fmt.Errorf("Kafka rejected the event: %s", offset.Error)
+		}
+	}
+	return nil
+}
+
+// NATSDestination publishes each event to a NATS subject over the core NATS text protocol.
+// A PING after the PUB makes the server confirm, or reject, the publish before Deliver returns.
+type NATSDestination struct {
+	// Address is host:port of the server
+	Address string
+	Subject string
+	// User and Password, or Token alone, authenticate the connection when set
+	User     string
+	Password string
+	Token    string
+	Timeout  time.Duration
+}
+
+// NewNATSDestination parses a nats://[user:password@|token@]host[:port] URL
+func NewNATSDestination(rawURL, subject string) (*NATSDestination, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "nats" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q, expected nats://host:port", rawURL)
+	}
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid NATS subject %q", subject)
+	}
+	d := &NATSDestination{Address: parsed.Host, Subject: subject, Timeout: 10 * time.Second}
+	if parsed.Port() == "" {
+		d.Address = net.JoinHostPort(parsed.Hostname(), "4222")
+	}
+	if parsed.User != nil {
+		if password, ok := parsed.User.Password(); ok {
+			d.User, d.Password = parsed.User.Username(), password
+		} else {
+			d.Token = parsed.User.Username()
+		}
+	}
+	return d, nil
+}
+
+// Name implements OutboxDestination
+func (d *NATSDestination) Name() string { return "nats" }
+
+// Deliver implements OutboxDestination with one short-lived connection per event
+func (d *NATSDestination) Deliver(message OutboxMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	conn, err := net.DialTimeout("tcp", d.Address, d.Timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(d.Timeout))
+	reader := bufio.NewReader(conn)
+
+	// The server greets with INFO before accepting CONNECT
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read NATS greeting: %v", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(line))
+	}
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "convertyApi", "lang": "go", "version": "1"}
+	if d.Token != "" {
+		options["auth_token"] = d.Token
+	}
+	if d.User != "" {
+		options["user"], options["pass"] = d.User, d.Password
+	}
+	connect, _ := json.Marshal(options)
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "CONNECT %s\r\nPUB %s %d\r\n", connect, d.Subject, len(payload))
+	out.Write(payload)
+	out.WriteString("\r\nPING\r\n")
+	if _, err := conn.Write(out.Bytes()); err != nil {
+		return fmt.Errorf("failed to publish to NATS: %v", err)
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("NATS did not confirm the publish: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS rejected the publish: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case line == "PING":
+			conn.Write([]byte("PONG\r\n"))
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 4: 80 * time.Second, 20: time.Hour} {
+		if got := outboxBackoff(attempts); got != want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestWebhookDestinationSigns(t *testing.T) {
+	var signature, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		body, signature = string(raw), r.Header.Get("X-Outbox-Signature")
+		if r.Header.Get("X-Outbox-Event") != "7" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	message := OutboxMessage{ID: 7, Type: EventRecordCreated, Data: json.RawMessage(`{"id":1}`)}
+	if err := NewWebhookDestination(server.URL, "s3cret").Deliver(message); err != nil {
+		t.Fatal(err)
+	}
+	if signature != "sha256="+SignOutboxBody("s3cret", []byte(body)) || !strings.Contains(body, `"type":"record.created"`) {
+		t.Fatalf("unexpected delivery %q signed %q", body, signature)
+	}
+}
+
+func TestKafkaDestination(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/interactions" || r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var produce struct {
+			Records []struct {
+				Key string `json:"key"`
+			} `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&produce)
+		if len(produce.Records) != 1 || produce.Records[0].Key != "3" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if fail {
+			w.Write([]byte(`{"offsets":[{"partition":null,"offset":null,"error_code":50003,"error":"leader not available"}]}`))
+			return
+		}
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":42,"error_code":null,"error":null}]}`))
+	}))
+	defer server.Close()
+
+	destination := NewKafkaDestination(server.URL+"/", "interactions")
+	if err := destination.Deliver(OutboxMessage{ID: 3}); err != nil {
+		t.Fatal(err)
+	}
+	fail = true
+	if err := destination.Deliver(OutboxMessage{ID: 3}); err == nil || !strings.Contains(err.Error(), "leader not available") {
+		t.Fatalf("expected the record error, got %v", err)
+	}
+}
+
+// fakeNATS accepts one connection and answers like a NATS server, rejecting publishes when reject is set
+func fakeNATS(t *testing.T, reject bool) (string, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"fake\"}\r\n"))
+		reader := bufio.NewReader(conn)
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines = append(lines, strings.TrimSpace(line))
+			if strings.TrimSpace(line) == "PING" {
+				break
+			}
+		}
+		received <- strings.Join(lines, "\n")
+		if reject {
+			conn.Write([]byte("-ERR 'Permissions Violation for Publish'\r\n"))
+			return
+		}
+		conn.Write([]byte("PONG\r\n"))
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestNATSDestination(t *testing.T) {
+	if _, err := NewNATSDestination("http://localhost:4222", "events"); err == nil {
+		t.Error("expected a scheme error")
+	}
+	if d, err := NewNATSDestination("nats://token@localhost", "events"); err != nil || d.Address != "localhost:4222" || d.Token != "token" {
+		t.Fatalf("unexpected destination %+v, %v", d, err)
+	}
+
+	addr, received := fakeNATS(t, false)
+	destination, err := NewNATSDestination("nats://bot:pw@"+addr, "convertyapi.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := destination.Deliver(OutboxMessage{ID: 5, Type: EventOrderCreated}); err != nil {
+		t.Fatal(err)
+	}
+	session := <-received
+	if !strings.Contains(session, `"user":"bot"`) || !strings.Contains(session, "PUB convertyapi.events ") || !strings.Contains(session, `"type":"order.created"`) {
+		t.Fatalf("unexpected session:\n%s", session)
+	}
+
+	addr, _ = fakeNATS(t, true)
+	destination, _ = NewNATSDestination("nats://"+addr, "convertyapi.events")
+	if err := destination.Deliver(OutboxMessage{ID: 6}); err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Fatalf("expected a rejection, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+// pageResult is the outcome of one page request
+type pageResult[T any] struct {
+	items []T
+	err   error
+}
+
+// FetchPages reads the pages of an upstream listing with up to workers requests in flight and hands
+// them to visit one at a time, in page order. Paging stops after a page shorter than pageSize, after
+// maxPages pages (0 for no bound), when visit returns false or at the first error. Pages requested
+// ahead of the stop are waited for and discarded, so fetch is never running once FetchPages
+// returns; every request still passes the shared upstream limiter, so the rate budget holds however
+// many workers there are.
+func FetchPages[T any](workers, pageSize, maxPages int, fetch func(page int) ([]T, error), visit func(page int, items []T) (bool, error)) error {
+	if workers < 1 {
+		workers = 1
+	}
+	next := 1
+	var pending []chan pageResult[T]
+	launch := func() {
+		if maxPages > 0 && next > maxPages {
+			return
+		}
+		page := next
+		next++
+		done := make(chan pageResult[T], 1)
+		go func() {
+			items, err := fetch(page)
+			done <- pageResult[T]{items: items, err: err}
+		}()
+		pending = append(pending, done)
+	}
+	defer func() {
+		for _, done := range pending {
+			<-done
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		launch()
+	}
+	for page := 1; len(pending) > 0; page++ {
+		result := <-pending[0]
+		pending = pending[1:]
+		if result.err != nil {
+			return result.err
+		}
+		more, err := visit(page, result.items)
+		if err != nil {
+			return err
+		}
+		if !more || len(result.items) < pageSize {
+			return nil
+		}
+		launch()
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchPagesKeepsOrder(t *testing.T) {
+	const pageSize, lastPage = 3, 7
+	var inFlight, peak atomic.Int32
+	var mu sync.Mutex
+	requested := map[int]bool{}
+	fetch := func(page int) ([]int, error) {
+		mu.Lock()
+		requested[page] = true
+		mu.Unlock()
+		if n := inFlight.Add(1); n > peak.Load() {
+			peak.Store(n)
+		}
+		defer inFlight.Add(-1)
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		if page == lastPage {
+			return []int{page * 10}, nil
+		}
+		return []int{page * 10, page*10 + 1, page*10 + 2}, nil
+	}
+
+	var pages []int
+	err := FetchPages(3, pageSize, 0, fetch, func(page int, items []int) (bool, error) {
+		if items[0] != page*10 {
+			t.Errorf("page %d got the items of another page: %v", page, items)
+		}
+		pages = append(pages, page)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != lastPage || pages[0] != 1 || pages[lastPage-1] != lastPage {
+		t.Errorf("visited pages %v, want 1 to %d in order", pages, lastPage)
+	}
+	if peak.Load() > 3 {
+		t.Errorf("%d requests in flight with 3 workers", peak.Load())
+	}
+	// Reading ahead never goes further than the workers past the short page
+	for page := range requested {
+		if page > lastPage+2 {
+			t.Errorf("page %d requested after the last page", page)
+		}
+	}
+}
+
+func TestFetchPagesStops(t *testing.T) {
+	full := func(page int) ([]int, error) { return []int{page, page}, nil }
+
+	var visited []int
+	FetchPages(4, 2, 5, full, func(page int, items []int) (bool, error) {
+		visited = append(visited, page)
+		return true, nil
+	})
+	if len(visited) != 5 {
+		t.Errorf("maxPages 5 visited %v", visited)
+	}
+
+	visited = nil
+	FetchPages(4, 2, 0, full, func(page int, items []int) (bool, error) {
+		visited = append(visited, page)
+		return page < 2, nil
+	})
+	if len(visited) != 2 {
+		t.Errorf("visit stop at page 2 visited %v", visited)
+	}
+
+	failing := errors.New("upstream down")
+	err := FetchPages(2, 2, 0, func(page int) ([]int, error) {
+		if page == 3 {
+			return nil, failing
+		}
+		return full(page)
+	}, func(page int, items []int) (bool, error) {
+		if page >= 3 {
+			t.Errorf("page %d visited after the failed page", page)
+		}
+		return true, nil
+	})
+	if !errors.Is(err, failing) {
+		t.Errorf("error %v, want the failed page's", err)
+	}
+}
+
+func TestUpstreamLimiterPageWorkers(t *testing.T) {
+	limiter := NewUpstreamLimiter(LimiterConfig{MaxConcurrent: 6, BackgroundShare: 0.5, PageWorkers: 4})
+	if n := limiter.PageWorkers(PriorityBackground); n != 3 {
+		t.Errorf("background page workers = %d, want the 3 background slots", n)
+	}
+	if n := limiter.PageWorkers(PriorityInteractive); n != 4 {
+		t.Errorf("interactive page workers = %d, want 4", n)
+	}
+	if n := NewUpstreamLimiter(LimiterConfig{}).PageWorkers(PriorityBackground); n != 1 {
+		t.Errorf("default page workers = %d, want sequential fetching", n)
+	}
+}
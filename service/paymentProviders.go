@@ -0,0 +1,306 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var paymentHTTPClient = NewHTTPClient(15 * time.Second)
+
+// postJSON sends a JSON body and decodes the JSON answer into out
+func postJSON(endpoint string, headers map[string]string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return doJSON(req, out)
+}
+
+func doJSON(req *http.Request, out interface{}) error {
+	resp, err := paymentHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+	return nil
+}
+
+// millimes converts a TND amount to the integer millimes expected by Tunisian gateways
+func millimes(amount float64) int64 {
+	return int64(math.Round(amount * 1000))
+}
+
+// KonnectProvider generates payment links with Konnect (konnect.network)
+type KonnectProvider struct {
+	APIKey   string
+	WalletID string
+	BaseURL  string
+}
+
+// Name returns the provider name
+func (p *KonnectProvider) Name() string { return "konnect" }
+
+// CreatePayment initiates a Konnect payment
+func (p *KonnectProvider) CreatePayment(req PaymentRequest) (CreatedPayment, error) {
+	var resp struct {
+		PayURL     string `json:"payUrl"`
+		PaymentRef string `json:"paymentRef"`
+	}
+	err := postJSON(p.BaseURL+"/payments/init-payment", map[string]string{"x-api-key": p.APIKey}, map[string]interface{}{
+		"receiverWalletId": p.WalletID,
+		"token":            "TND",
+		"amount":           millimes(req.Amount),
+		"type":             "immediate",
+		"description":      req.Description,
+		"orderId":          req.OrderID,
+		"webhook":          req.WebhookURL,
+		"firstName":        req.Customer.Name,
+		"phoneNumber":      req.Customer.Phone,
+		"email":            req.Customer.Email,
+	}, &resp)
+	if err != nil {
+		return CreatedPayment{}, err
+	}
+	return CreatedPayment{ProviderRef: resp.PaymentRef, URL: resp.PayURL}, nil
+}
+
+// ParseWebhook handles Konnect's GET ?payment_ref=... notification by querying the payment status
+func (p *KonnectProvider) ParseWebhook(r *http.Request) (PaymentEvent, error) {
+	ref := r.URL.Query().Get("payment_ref")
+	if ref == "" {
+		return PaymentEvent{}, fmt.Errorf("missing payment_ref")
+	}
+	req, err := http.NewRequest("GET", p.BaseURL+"/payments/"+url.PathEscape(ref), nil)
+	if err != nil {
+		return PaymentEvent{}, err
+	}
+	req.Header.Set("x-api-key", p.APIKey)
+	var resp struct {
+		Payment struct {
+			Status string `json:"status"`
+		} `json:"payment"`
+	}
+	if err := doJSON(req, &resp); err != nil {
+		return PaymentEvent{}, err
+	}
+	status := PaymentPending
+	switch resp.Payment.Status {
+	case "completed":
+		status = PaymentPaid
+	case "failed", "expired":
+		status = PaymentFailed
+	}
+	return PaymentEvent{ProviderRef: ref, Status: status}, nil
+}
+
+// FlouciProvider generates payment links with Flouci
+type FlouciProvider struct {
+	AppToken  string
+	AppSecret string
+	BaseURL   string
+	// ReturnURL is where customers land after paying
+	ReturnURL string
+}
+
+// Name returns the provider name
+func (p *FlouciProvider) Name() string { return "flouci" }
+
+// CreatePayment generates a Flouci payment
+func (p *FlouciProvider) CreatePayment(req PaymentRequest) (CreatedPayment, error) {
+	var resp struct {
+		Result struct {
+			Success   bool   `json:"success"`
+			PaymentID string `json:"payment_id"`
+			Link      string `json:"link"`
+		} `json:"result"`
+	}
+	returnURL := p.ReturnURL
+	if returnURL == "" {
+		returnURL = req.WebhookURL
+	}
+	err := postJSON(p.BaseURL+"/generate_payment", nil, map[string]interface{}{
+		"app_token":             p.AppToken,
+		"app_secret":            p.AppSecret,
+		"amount":                strconv.FormatInt(millimes(req.Amount), 10),
+		"accept_card":           "true",
+		"session_timeout_secs":  1200,
+		"success_link":          returnURL,
+		"fail_link":             returnURL,
+		"developer_tracking_id": req.OrderID,
+	}, &resp)
+	if err != nil {
+		return CreatedPayment{}, err
+	}
+	if !resp.Result.Success {
+		return CreatedPayment{}, fmt.Errorf("flouci refused the payment request")
+	}
+	return CreatedPayment{ProviderRef: resp.Result.PaymentID, URL: resp.Result.Link}, nil
+}
+
+// ParseWebhook verifies a Flouci notification (?payment_id=...) against the verify endpoint
+func (p *FlouciProvider) ParseWebhook(r *http.Request) (PaymentEvent, error) {
+	ref := r.URL.Query().Get("payment_id")
+	if ref == "" {
+		return PaymentEvent{}, fmt.Errorf("missing payment_id")
+	}
+	req, err := http.NewRequest("GET", p.BaseURL+"/verify_payment/"+url.PathEscape(ref), nil)
+	if err != nil {
+		return PaymentEvent{}, err
+	}
+	req.Header.Set("apppublic", p.AppToken)
+	req.Header.Set("appsecret", p.AppSecret)
+	var resp struct {
+		Result struct {
+			Status string `json:"status"`
+		} `json:"result"`
+	}
+	if err := doJSON(req, &resp); err != nil {
+		return PaymentEvent{}, err
+	}
+	status := PaymentPending
+	switch resp.Result.Status {
+	case "SUCCESS":
+		status = PaymentPaid
+	case "FAILURE", "EXPIRED":
+		status = PaymentFailed
+	}
+	return PaymentEvent{ProviderRef: ref, Status: status}, nil
+}
+
+// StripeProvider generates Stripe Checkout links
+type StripeProvider struct {
+	SecretKey     string
+	WebhookSecret string
+	BaseURL       string
+	ReturnURL     string
+}
+
+// Name returns the provider name
+func (p *StripeProvider) Name() string { return "stripe" }
+
+// CreatePayment creates a Checkout Session for the order amount
+func (p *StripeProvider) CreatePayment(req PaymentRequest) (CreatedPayment, error) {
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("client_reference_id", req.OrderID)
+	form.Set("metadata[order_id]", req.OrderID)
+	form.Set("success_url", p.ReturnURL)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", strings.ToLower(req.Currency))
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(int64(math.Round(req.Amount*100)), 10))
+	form.Set("line_items[0][price_data][product_data][name]", req.Description)
+	if req.Customer.Email != "" {
+		form.Set("customer_email", req.Customer.Email)
+	}
+
+	httpReq, err := http.NewRequest("POST", p.BaseURL+"/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return CreatedPayment{}, err
+	}
+	httpReq.SetBasicAuth(p.SecretKey, "")
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var resp struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := doJSON(httpReq, &resp); err != nil {
+		return CreatedPayment{}, err
+	}
+	return CreatedPayment{ProviderRef: resp.ID, URL: resp.URL}, nil
+}
+
+// ParseWebhook verifies the Stripe-Signature header and maps checkout session events
+func (p *StripeProvider) ParseWebhook(r *http.Request) (PaymentEvent, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return PaymentEvent{}, fmt.Errorf("failed to read body: %v", err)
+	}
+	if err := verifyStripeSignature(r.Header.Get("Stripe-Signature"), body, p.WebhookSecret, time.Now()); err != nil {
+		return PaymentEvent{}, err
+	}
+
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID string `json:"id"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return PaymentEvent{}, fmt.Errorf("failed to parse event: %v", err)
+	}
+	status := PaymentPending
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		status = PaymentPaid
+	case "checkout.session.expired", "checkout.session.async_payment_failed":
+		status = PaymentFailed
+	}
+	return PaymentEvent{ProviderRef: event.Data.Object.ID, Status: status}, nil
+}
+
+// verifyStripeSignature checks a "t=...,v1=..." header against HMAC-SHA256(secret, "t.body")
+func verifyStripeSignature(header string, body []byte, secret string, now time.Time) error {
+	if secret == "" {
+		return fmt.Errorf("STRIPE_WEBHOOK_SECRET is not set")
+	}
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("malformed Stripe-Signature header")
+	}
+	if now.Sub(time.Unix(ts, 0)).Abs() > 5*time.Minute {
+		return fmt.Errorf("webhook timestamp outside tolerance")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature mismatch")
+}
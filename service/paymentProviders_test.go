@@ -0,0 +1,130 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stripeSignature signs body the way Stripe does at t
+func stripeSignature(secret string, t time.Time, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", t.Unix(), body)
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestVerifyStripeSignature(t *testing.T) {
+	now := time.Unix(1746100000, 0)
+	body := []byte(`{"type": "checkout.session.completed"}`)
+	for _, c := range []struct {
+		name, header, secret, wantErr string
+	}{
+		{"valid", stripeSignature("whsec", now, string(body)), "whsec", ""},
+		{"second signature valid", "t=1746100000,v1=00," + strings.TrimPrefix(stripeSignature("whsec", now, string(body)), "t=1746100000,"), "whsec", ""},
+		{"no secret", stripeSignature("whsec", now, string(body)), "", "STRIPE_WEBHOOK_SECRET"},
+		{"no header", "", "whsec", "malformed"},
+		{"no signature", "t=1746100000", "whsec", "malformed"},
+		{"stale", stripeSignature("whsec", now.Add(-6*time.Minute), string(body)), "whsec", "tolerance"},
+		{"future", stripeSignature("whsec", now.Add(6*time.Minute), string(body)), "whsec", "tolerance"},
+		{"wrong secret", stripeSignature("other", now, string(body)), "whsec", "signature"},
+		{"tampered body", stripeSignature("whsec", now, `{"type": "checkout.session.expired"}`), "whsec", "signature"},
+	} {
+		err := verifyStripeSignature(c.header, body, c.secret, now)
+		if c.wantErr == "" && err != nil || c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+			t.Errorf("%s: got %v, want %q", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestStripeWebhookStatus(t *testing.T) {
+	provider := &StripeProvider{WebhookSecret: "whsec"}
+	for eventType, want := range map[string]string{
+		"checkout.session.completed":               PaymentPaid,
+		"checkout.session.async_payment_succeeded": PaymentPaid,
+		"checkout.session.expired":                 PaymentFailed,
+		"checkout.session.async_payment_failed":    PaymentFailed,
+		"checkout.session.created":                 PaymentPending,
+	} {
+		body := `{"type": "` + eventType + `", "data": {"object": {"id": "cs_1"}}}`
+		req := httptest.NewRequest("POST", "/api/v1/payments/webhooks/stripe", strings.NewReader(body))
+		req.Header.Set("Stripe-Signature", stripeSignature("whsec", time.Now(), body))
+		event, err := provider.ParseWebhook(req)
+		if err != nil || event.ProviderRef != "cs_1" || event.Status != want {
+			t.Errorf("%s: got %+v, %v; want %s", eventType, event, err, want)
+		}
+	}
+}
+
+func TestGatewayWebhookStatus(t *testing.T) {
+	gatewayStatus := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/payments/ref1" && r.Header.Get("x-api-key") == "key":
+			fmt.Fprintf(w, `{"payment": {"status": %q}}`, gatewayStatus)
+		case r.URL.Path == "/verify_payment/ref1" && r.Header.Get("appsecret") == "secret":
+			fmt.Fprintf(w, `{"result": {"status": %q}}`, gatewayStatus)
+		default:
+			http.Error(w, `{"error": "unknown payment"}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	konnect := &KonnectProvider{APIKey: "key", BaseURL: server.URL}
+	flouci := &FlouciProvider{AppToken: "token", AppSecret: "secret", BaseURL: server.URL}
+
+	for _, c := range []struct {
+		provider PaymentProvider
+		query    string
+		status   string
+		want     string
+		wantErr  bool
+	}{
+		{konnect, "payment_ref=ref1", "completed", PaymentPaid, false},
+		{konnect, "payment_ref=ref1", "expired", PaymentFailed, false},
+		{konnect, "payment_ref=ref1", "pending", PaymentPending, false},
+		{konnect, "payment_ref=ref2", "completed", "", true},
+		{konnect, "", "completed", "", true},
+		{flouci, "payment_id=ref1", "SUCCESS", PaymentPaid, false},
+		{flouci, "payment_id=ref1", "FAILURE", PaymentFailed, false},
+		{flouci, "payment_id=ref1", "PENDING", PaymentPending, false},
+		{flouci, "payment_ref=ref1", "SUCCESS", "", true},
+	} {
+		gatewayStatus = c.status
+		event, err := c.provider.ParseWebhook(httptest.NewRequest("GET", "/webhook?"+c.query, nil))
+		if (err != nil) != c.wantErr || event.Status != c.want {
+			t.Errorf("%s %q (%s): got %+v, %v; want %q", c.provider.Name(), c.query, c.status, event, err, c.want)
+		}
+	}
+}
+
+func TestCreateLinkRefusesBeforeCallingTheProvider(t *testing.T) {
+	payments := NewGormPaymentService(nil, nil, nil)
+	payments.RegisterProvider(&KonnectProvider{BaseURL: "http://127.0.0.1:1"})
+	for _, c := range []struct {
+		provider string
+		total    float64
+		want     string
+	}{
+		{"paypal", 10, "not configured"},
+		{"konnect", 0, "no amount"},
+		{"konnect", -5, "no amount"},
+	} {
+		_, err := payments.CreateLink(1, c.provider, Order{ID: "o1", Total: c.total}, "")
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s with total %v: got %v, want %q", c.provider, c.total, err, c.want)
+		}
+	}
+}
+
+func TestMillimes(t *testing.T) {
+	for amount, want := range map[float64]int64{0: 0, 12.5: 12500, 19.999: 19999, 0.0015: 2, 1.1: 1100} {
+		if got := millimes(amount); got != want {
+			t.Errorf("millimes(%v) = %d, want %d", amount, got, want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Payment link statuses
+const (
+	PaymentPending = "pending"
+	PaymentPaid    = "paid"
+	PaymentFailed  = "failed"
+)
+
+// PaymentLink tracks a prepayment link generated for an order
+type PaymentLink struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	TenantID    uint       `gorm:"not null;default:0;index" json:"tenant_id"`
+	OrderID     string     `gorm:"not null;index" json:"order_id"`
+	Provider    string     `gorm:"not null" json:"provider"`
+	ProviderRef string     `gorm:"index" json:"provider_ref"`
+	URL         string     `json:"url"`
+	Amount      float64    `json:"amount"`
+	Currency    string     `json:"currency"`
+	Status      string     `gorm:"not null;index" json:"status"`
+	PaidAt      *time.Time `json:"paid_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for PaymentLink
+func (PaymentLink) TableName() string {
+	return "chatbot.payment_links"
+}
+
+// PaymentRequest describes what a provider should charge for
+type PaymentRequest struct {
+	OrderID     string
+	Amount      float64
+	Currency    string
+	Description string
+	Customer    Customer
+	// WebhookURL receives the provider's payment notifications
+	WebhookURL string
+}
+
+// CreatedPayment is a provider's answer to a payment link request
+type CreatedPayment struct {
+	ProviderRef string
+	URL         string
+}
+
+// PaymentEvent is a provider webhook resolved into a payment outcome
+type PaymentEvent struct {
+	ProviderRef string
+	Status      string
+}
+
+// PaymentProvider is implemented by each payment gateway (Konnect, Flouci, Stripe)
+type PaymentProvider interface {
+	Name() string
+	CreatePayment(req PaymentRequest) (CreatedPayment, error)
+	// ParseWebhook verifies a webhook request and resolves the payment it refers to
+	ParseWebhook(r *http.Request) (PaymentEvent, error)
+}
+
+// PaymentService defines the interface for payment link operations
+type PaymentService interface {
+	RegisterProvider(provider PaymentProvider)
+	Providers() []string
+	CreateLink(tenantID uint, provider string, order Order, webhookURL string) (PaymentLink, error)
+	ListLinks(tenantID uint, orderID string) ([]PaymentLink, error)
+	// HandleWebhook applies a provider notification to the link it refers to, in the link's tenant
+	HandleWebhook(provider string, r *http.Request) (PaymentLink, error)
+}
+
+// GormPaymentService implements PaymentService using GORM
+type GormPaymentService struct {
+	db          *gorm.DB
+	dataService DataService
+	notifier    Notifier
+
+	mu        sync.RWMutex
+	providers map[string]PaymentProvider
+}
+
+// NewGormPaymentService creates a new GormPaymentService
+func NewGormPaymentService(db *gorm.DB, dataService DataService, notifier Notifier) PaymentService {
+	return &GormPaymentService{
+		db:          db,
+		dataService: dataService,
+		notifier:    notifier,
+		providers:   make(map[string]PaymentProvider),
+	}
+}
+
+// RegisterProvider makes a payment provider available
+func (s *GormPaymentService) RegisterProvider(provider PaymentProvider) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.providers[provider.Name()] = provider
+}
+
+// Providers lists the registered provider names
+func (s *GormPaymentService) Providers() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (s *GormPaymentService) provider(name string) (PaymentProvider, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("payment provider %q is not configured", name)
+	}
+	return provider, nil
+}
+
+// CreateLink asks the provider for a payment link for the order total and stores it
+func (s *GormPaymentService) CreateLink(tenantID uint, providerName string, order Order, webhookURL string) (PaymentLink, error) {
+	provider, err := s.provider(providerName)
+	if err != nil {
+		return PaymentLink{}, err
+	}
+	if order.Total <= 0 {
+		return PaymentLink{}, fmt.Errorf("order %s has no amount to pay", order.ID)
+	}
+
+	created, err := provider.CreatePayment(PaymentRequest{
+		OrderID:     order.ID,
+		Amount:      order.Total,
+		Currency:    order.Currency,
+		Description: fmt.Sprintf("Order %s", order.ID),
+		Customer:    order.Customer,
+		WebhookURL:  webhookURL,
+	})
+	if err != nil {
+		return PaymentLink{}, fmt.Errorf("failed to create %s payment: %v", providerName, err)
+	}
+
+	link := PaymentLink{
+		TenantID:    tenantID,
+		OrderID:     order.ID,
+		Provider:    providerName,
+		ProviderRef: created.ProviderRef,
+		URL:         created.URL,
+		Amount:      order.Total,
+		Currency:    order.Currency,
+		Status:      PaymentPending,
+	}
+	if err := s.db.Create(&link).Error; err != nil {
+		return PaymentLink{}, fmt.Errorf("failed to save payment link: %v", err)
+	}
+	return link, nil
+}
+
+// ListLinks fetches the payment links of an order of the tenant
+func (s *GormPaymentService) ListLinks(tenantID uint, orderID string) ([]PaymentLink, error) {
+	var links []PaymentLink
+	if err := s.db.Where("tenant_id = ? AND order_id = ?", tenantID, orderID).Order("created_at desc").Find(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch payment links: %v", err)
+	}
+	return links, nil
+}
+
+// HandleWebhook applies a provider notification: the link is updated, a payment interaction
+// is recorded for the order and the agent is notified
+func (s *GormPaymentService) HandleWebhook(providerName string, r *http.Request) (PaymentLink, error) {
+	provider, err := s.provider(providerName)
+	if err != nil {
+		return PaymentLink{}, err
+	}
+	event, err := provider.ParseWebhook(r)
+	if err != nil {
+		return PaymentLink{}, fmt.Errorf("invalid %s webhook: %v", providerName, err)
+	}
+
+	var link PaymentLink
+	if err := s.db.Where("provider = ? AND provider_ref = ?", providerName, event.ProviderRef).First(&link).Error; err != nil {
+		return PaymentLink{}, fmt.Errorf("payment %s not found: %v", event.ProviderRef, err)
+	}
+	// Providers retry webhooks; only the first transition counts
+	if link.Status == event.Status || link.Status == PaymentPaid {
+		return link, nil
+	}
+
+	updates := map[string]interface{}{"status": event.Status}
+	if event.Status == PaymentPaid {
+		now := time.Now()
+		updates["paid_at"] = now
+		link.PaidAt = &now
+	}
+	if err := s.db.Model(&link).Updates(updates).Error; err != nil {
+		return PaymentLink{}, fmt.Errorf("failed to update payment link: %v", err)
+	}
+	link.Status = event.Status
+
+	if _, err := s.dataService.ForTenant(Tenant{ID: link.TenantID}).InsertRecord(0, "payment", map[string]interface{}{
+		"order_id":     link.OrderID,
+		"provider":     link.Provider,
+		"provider_ref": link.ProviderRef,
+		"amount":       link.Amount,
+		"currency":     link.Currency,
+		"status":       link.Status,
+	}, StatusCompleted); err != nil {
+		log.Printf("Failed to record payment interaction for order %s: %v", link.OrderID, err)
+	}
+
+	if s.notifier != nil {
+		if err := s.notifier.Notify(Notification{
+			Event:     "payment_" + link.Status,
+			Message:   fmt.Sprintf("Order %s payment via %s is %s (%.3f %s)", link.OrderID, link.Provider, link.Status, link.Amount, link.Currency),
+			Data:      map[string]interface{}{"tenant_id": link.TenantID, "order_id": link.OrderID, "payment_link_id": link.ID},
+			CreatedAt: time.Now(),
+		}); err != nil {
+			log.Printf("Failed to notify agent about payment: %v", err)
+		}
+	}
+	return link, nil
+}
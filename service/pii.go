@@ -0,0 +1,620 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// piiPrefix marks a detail value encrypted with a tenant key. It is followed by the id of the master
+// key and a colon, so the value still opens after the key is rotated.
+const piiPrefix = "pii:v2:"
+
+// piiLegacyPrefix marks a value encrypted with the key of a tenant and chatbot user, which made the
+// record unreadable once a customer merge moved it to another user, and naming no master key. Such
+// values are still opened, trying every key.
+const piiLegacyPrefix = "pii:v1:"
+
+// piiMarker starts every encrypted value, whatever its version
+const piiMarker = "pii:v"
+
+// DefaultPIIFields are the detail paths encrypted when PII_FIELDS is not set
+var DefaultPIIFields = []string{"name", "phone", "phone_number", "address"}
+
+// ErrPIIDecrypt is returned when an encrypted value cannot be opened, usually because its key is gone
+var ErrPIIDecrypt = errors.New("failed to decrypt personal data")
+
+// piiKey holds the keys derived from one master key
+type piiKey struct {
+	id      string
+	key     []byte
+	hashKey []byte
+}
+
+func newPIIKey(masterKey []byte) (piiKey, error) {
+	if len(masterKey) != 32 {
+		return piiKey{}, fmt.Errorf("PII key must be 32 bytes, got %d", len(masterKey))
+	}
+	return piiKey{
+		id:      hex.EncodeToString(deriveKey(masterKey, "pii-key-id")[:4]),
+		key:     deriveKey(masterKey, "pii-encryption"),
+		hashKey: deriveKey(masterKey, "pii-lookup"),
+	}, nil
+}
+
+// PIIProtector encrypts configured detail fields with a key derived per tenant, and
+// keeps a keyed hash of each value so records can still be looked up by phone or name
+type PIIProtector struct {
+	mu sync.RWMutex
+	// keys are the master keys: the first seals new values, the others still open the values they sealed
+	keys []piiKey
+	// fields are the protected paths, split on dots ("customer.phone")
+	fields [][]string
+}
+
+// PII protects the details of chatbot.interactions; nil stores them in clear
+var PII *PIIProtector
+
+// NewPIIProtector derives the encryption and hash keys from a 32-byte master key; previousKeys are the
+// master keys used before a rotation, kept to open the values they sealed
+func NewPIIProtector(masterKey []byte, fields []string, previousKeys ...[]byte) (*PIIProtector, error) {
+	current, err := newPIIKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	p := &PIIProtector{keys: []piiKey{current}}
+	for _, previous := range previousKeys {
+		key, err := newPIIKey(previous)
+		if err != nil {
+			return nil, fmt.Errorf("previous %v", err)
+		}
+		p.addKey(key)
+	}
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field != "" {
+			p.fields = append(p.fields, strings.Split(field, "."))
+		}
+	}
+	if len(p.fields) == 0 {
+		return nil, fmt.Errorf("no PII fields configured")
+	}
+	return p, nil
+}
+
+// addKey appends a key that only opens values, unless the keyring already has it
+func (p *PIIProtector) addKey(key piiKey) {
+	for _, known := range p.keys {
+		if known.id == key.id {
+			return
+		}
+	}
+	p.keys = append(p.keys, key)
+}
+
+// Rotate makes masterKey the key sealing new values; the current key is kept to open the values it
+// sealed until ReencryptRecords has moved them to the new key
+func (p *PIIProtector) Rotate(masterKey []byte) error {
+	key, err := newPIIKey(masterKey)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := []piiKey{key}
+	for _, known := range p.keys {
+		if known.id != key.id {
+			keys = append(keys, known)
+		}
+	}
+	p.keys = keys
+	return nil
+}
+
+// KeyID identifies the master key sealing new values
+func (p *PIIProtector) KeyID() string {
+	return p.current().id
+}
+
+func (p *PIIProtector) current() piiKey {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.keys[0]
+}
+
+// keyring returns every key, the current one first
+func (p *PIIProtector) keyring() []piiKey {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.keys
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Protects reports whether a dotted detail path is encrypted
+func (p *PIIProtector) Protects(path string) bool {
+	if p == nil {
+		return false
+	}
+	for _, field := range p.fields {
+		if strings.Join(field, ".") == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash is the lookup hash of a detail value with the current key; phone fields are normalized first
+// so "+216 74 000 000" and "+21674000000" hash alike
+func (p *PIIProtector) Hash(path, value string) string {
+	return hashValue(p.current(), path, value)
+}
+
+// LookupHashes are the hashes of a detail value under every key, matching the records whose hashes
+// were not recomputed since a rotation
+func (p *PIIProtector) LookupHashes(path, value string) []string {
+	keys := p.keyring()
+	hashes := make([]string, 0, len(keys))
+	for _, key := range keys {
+		hashes = append(hashes, hashValue(key, path, value))
+	}
+	return hashes
+}
+
+func hashValue(key piiKey, path, value string) string {
+	if strings.Contains(path, "phone") {
+		value = NormalizePhone(value)
+	} else {
+		value = strings.ToLower(strings.Join(strings.Fields(value), " "))
+	}
+	mac := hmac.New(sha256.New, key.hashKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// recordCipher is the AEAD of a tenant's records under key. It does not depend on the chatbot user,
+// so customer merges can move records between users.
+func recordCipher(key piiKey, tenantID uint) (cipher.AEAD, error) {
+	return newGCM(deriveKey(key.key, fmt.Sprintf("record:%d", tenantID)))
+}
+
+// legacyCipher is the AEAD of the values sealed per chatbot user before recordCipher
+func legacyCipher(key piiKey, tenantID, userID uint) (cipher.AEAD, error) {
+	return newGCM(deriveKey(key.key, fmt.Sprintf("record:%d:%d", tenantID, userID)))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts the protected fields of details in place of their values and returns the lookup hashes
+// of the string and number values; values that are already encrypted are kept
+func (p *PIIProtector) Seal(tenantID uint, details []byte) ([]byte, []byte, error) {
+	doc, ok := decodeDetails(details)
+	if !ok {
+		return details, nil, nil
+	}
+	key := p.current()
+	aead, err := recordCipher(key, tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make(map[string]string)
+	changed := false
+	for _, field := range p.fields {
+		parent, name, value, found := lookupPath(doc, field)
+		if !found || value == nil {
+			continue
+		}
+		path := strings.Join(field, ".")
+		if sealed, ok := value.(string); ok && strings.HasPrefix(sealed, piiMarker) {
+			// Already encrypted; keep the hash stored alongside it
+			continue
+		}
+		if hash, ok := scalarHash(key, path, value); ok {
+			hashes[path] = hash
+		}
+		plain, err := json.Marshal(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode %s: %v", path, err)
+		}
+		if parent[name], err = sealValue(aead, key.id, plain, path); err != nil {
+			return nil, nil, err
+		}
+		changed = true
+	}
+	if !changed {
+		return details, nil, nil
+	}
+	sealed, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode details: %v", err)
+	}
+	encodedHashes, err := json.Marshal(hashes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sealed, encodedHashes, nil
+}
+
+// scalarHash is the lookup hash of a string or number value; objects have none
+func scalarHash(key piiKey, path string, value interface{}) (string, bool) {
+	switch scalar := value.(type) {
+	case string:
+		return hashValue(key, path, scalar), true
+	case json.Number:
+		return hashValue(key, path, scalar.String()), true
+	}
+	return "", false
+}
+
+// sealValue encrypts plain with the key keyID names, bound to the detail path it is stored at
+func sealValue(aead cipher.AEAD, keyID string, plain []byte, path string) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return piiPrefix + keyID + ":" + base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, []byte(path))), nil
+}
+
+// openValue decrypts the payload of a sealed value stored at path
+func openValue(aead cipher.AEAD, payload, path string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed %s", ErrPIIDecrypt, path)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(path))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrPIIDecrypt, path)
+	}
+	return plain, nil
+}
+
+// sealedKeyID returns the id of the master key that sealed value, or "" for a legacy value
+func sealedKeyID(value string) string {
+	rest, ok := strings.CutPrefix(value, piiPrefix)
+	if !ok {
+		return ""
+	}
+	keyID, _, _ := strings.Cut(rest, ":")
+	return keyID
+}
+
+// openSealed decrypts a sealed value of either version: the key it names opens a current value, and
+// every key is tried on a legacy one
+func (p *PIIProtector) openSealed(tenantID, userID uint, value, path string) ([]byte, error) {
+	keys := p.keyring()
+	if payload, ok := strings.CutPrefix(value, piiLegacyPrefix); ok {
+		for _, key := range keys {
+			aead, err := legacyCipher(key, tenantID, userID)
+			if err != nil {
+				return nil, err
+			}
+			if plain, err := openValue(aead, payload, path); err == nil {
+				return plain, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrPIIDecrypt, path)
+	}
+	rest, ok := strings.CutPrefix(value, piiPrefix)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown scheme for %s", ErrPIIDecrypt, path)
+	}
+	keyID, payload, _ := strings.Cut(rest, ":")
+	for _, key := range keys {
+		if key.id != keyID {
+			continue
+		}
+		aead, err := recordCipher(key, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		return openValue(aead, payload, path)
+	}
+	return nil, fmt.Errorf("%w: %s was sealed with unknown key %s", ErrPIIDecrypt, path, keyID)
+}
+
+// Open decrypts every encrypted value of details, whichever fields are configured now; userID opens
+// the values sealed per chatbot user
+func (p *PIIProtector) Open(tenantID, userID uint, details []byte) ([]byte, error) {
+	if !bytes.Contains(details, []byte(piiMarker)) {
+		return details, nil
+	}
+	doc, ok := decodeDetails(details)
+	if !ok {
+		return details, nil
+	}
+	err := walkSealed(doc, nil, func(value, path string) (interface{}, error) {
+		plain, err := p.openSealed(tenantID, userID, value, path)
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(plain))
+		decoder.UseNumber()
+		var opened interface{}
+		if err := decoder.Decode(&opened); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrPIIDecrypt, path, err)
+		}
+		return opened, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// Rekey re-encrypts with the current tenant key every value sealed otherwise: per chatbot user, which
+// would no longer open once the record moves to another user, or with a rotated master key. It
+// reports whether anything changed.
+func (p *PIIProtector) Rekey(tenantID, userID uint, details []byte) ([]byte, bool, error) {
+	if !bytes.Contains(details, []byte(piiMarker)) {
+		return details, false, nil
+	}
+	doc, ok := decodeDetails(details)
+	if !ok {
+		return details, false, nil
+	}
+	key := p.current()
+	aead, err := recordCipher(key, tenantID)
+	if err != nil {
+		return nil, false, err
+	}
+	changed := false
+	err = walkSealed(doc, nil, func(value, path string) (interface{}, error) {
+		if sealedKeyID(value) == key.id {
+			return value, nil
+		}
+		plain, err := p.openSealed(tenantID, userID, value, path)
+		if err != nil {
+			return nil, err
+		}
+		changed = true
+		return sealValue(aead, key.id, plain, path)
+	})
+	if err != nil || !changed {
+		return details, false, err
+	}
+	rekeyed, err := json.Marshal(doc)
+	return rekeyed, true, err
+}
+
+// lookupHashes are the current lookup hashes of the protected fields of opened details
+func (p *PIIProtector) lookupHashes(details []byte) map[string]string {
+	doc, ok := decodeDetails(details)
+	if !ok {
+		return nil
+	}
+	key := p.current()
+	hashes := make(map[string]string)
+	for _, field := range p.fields {
+		if _, _, value, found := lookupPath(doc, field); found {
+			path := strings.Join(field, ".")
+			if hash, ok := scalarHash(key, path, value); ok {
+				hashes[path] = hash
+			}
+		}
+	}
+	return hashes
+}
+
+// walkSealed replaces every encrypted string of a decoded document with what fn returns for it
+func walkSealed(doc map[string]interface{}, path []string, fn func(value, path string) (interface{}, error)) error {
+	for name, value := range doc {
+		fieldPath := append(path[:len(path):len(path)], name)
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			if err := walkSealed(typed, fieldPath, fn); err != nil {
+				return err
+			}
+		case string:
+			if !strings.HasPrefix(typed, piiMarker) {
+				continue
+			}
+			replaced, err := fn(typed, strings.Join(fieldPath, "."))
+			if err != nil {
+				return err
+			}
+			doc[name] = replaced
+		}
+	}
+	return nil
+}
+
+// decodeDetails parses a details object keeping numbers exact; other JSON values are not protected
+func decodeDetails(details []byte) (map[string]interface{}, bool) {
+	if len(details) == 0 {
+		return nil, false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(details))
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil || doc == nil {
+		return nil, false
+	}
+	return doc, true
+}
+
+// lookupPath finds the value at path and the object holding it
+func lookupPath(doc map[string]interface{}, path []string) (map[string]interface{}, string, interface{}, bool) {
+	parent := doc
+	for _, name := range path[:len(path)-1] {
+		child, ok := parent[name].(map[string]interface{})
+		if !ok {
+			return nil, "", nil, false
+		}
+		parent = child
+	}
+	value, ok := parent[path[len(path)-1]]
+	return parent, path[len(path)-1], value, ok
+}
+
+// sealPII encrypts the record's protected fields before it is written
+func (d *Data) sealPII() error {
+	if PII == nil {
+		return nil
+	}
+	sealed, hashes, err := PII.Seal(d.TenantID, d.Details)
+	if err != nil {
+		return err
+	}
+	d.Details = datatypes.JSON(sealed)
+	if hashes != nil {
+		d.PIIHashes = datatypes.JSON(hashes)
+	}
+	return nil
+}
+
+// openPII decrypts the record's details for the caller; records written before encryption are returned as is.
+// A record that cannot be decrypted keeps its sealed details and is flagged, so it does not fail the listing it is in.
+func (d *Data) openPII() error {
+	if PII == nil {
+		return nil
+	}
+	opened, err := PII.Open(d.TenantID, d.UserID, d.Details)
+	if errors.Is(err, ErrPIIDecrypt) {
+		log.Printf("Record %d: %v", d.ID, err)
+		d.Undecryptable = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("record %d: %w", d.ID, err)
+	}
+	d.Details = datatypes.JSON(opened)
+	return nil
+}
+
+// BeforeSave encrypts the protected detail fields
+func (d *Data) BeforeSave(tx *gorm.DB) error {
+	return d.sealPII()
+}
+
+// AfterSave gives the caller back the clear details it saved
+func (d *Data) AfterSave(tx *gorm.DB) error {
+	return d.openPII()
+}
+
+// AfterFind decrypts the protected detail fields
+func (d *Data) AfterFind(tx *gorm.DB) error {
+	return d.openPII()
+}
+
+// piiLookup matches the records whose detail at key contains value in clear, or equals it through its lookup hash
+func piiLookup(query *gorm.DB, key, value string) *gorm.DB {
+	return query.Where("(details ->> ? ILIKE ? OR pii_hashes ->> ? IN ?)", key, "%"+value+"%", key, PII.LookupHashes(key, value))
+}
+
+// EncryptExistingRecords encrypts the protected fields of records written in clear, batchSize rows at a time,
+// and returns how many were rewritten; soft-deleted and superseded versions are included
+func EncryptExistingRecords(db *gorm.DB, batchSize int) (int, error) {
+	if PII == nil {
+		return 0, fmt.Errorf("PII encryption is not configured")
+	}
+	// Rows are read as stored, without the Data hooks, so values already encrypted are left alone
+	type storedRecord struct {
+		ID       uint
+		TenantID uint
+		UserID   uint
+		Details  datatypes.JSON
+	}
+	encrypted := 0
+	var batch []storedRecord
+	err := db.Table("chatbot.interactions").Select("id, tenant_id, user_id, details").Order("id").
+		FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+			for _, record := range batch {
+				sealed, hashes, err := PII.Seal(record.TenantID, record.Details)
+				if err != nil {
+					return fmt.Errorf("failed to encrypt record %d: %v", record.ID, err)
+				}
+				if hashes == nil {
+					continue
+				}
+				// Newly protected fields add their hashes to those of the fields encrypted before
+				err = db.Table("chatbot.interactions").Where("id = ?", record.ID).UpdateColumns(map[string]interface{}{
+					"details":    datatypes.JSON(sealed),
+					"pii_hashes": gorm.Expr("coalesce(pii_hashes, '{}'::jsonb) || ?::jsonb", string(hashes)),
+				}).Error
+				if err != nil {
+					return fmt.Errorf("failed to update record %d: %v", record.ID, err)
+				}
+				encrypted++
+			}
+			return nil
+		}).Error
+	return encrypted, err
+}
+
+// ReencryptRecords moves the values sealed with a rotated master key, or per chatbot user, to the
+// current key batchSize rows at a time, recomputing their lookup hashes; it returns how many records
+// were rewritten. The previous keys can be dropped once it has run to completion.
+func ReencryptRecords(db *gorm.DB, batchSize int) (int, error) {
+	if PII == nil {
+		return 0, fmt.Errorf("PII encryption is not configured")
+	}
+	type storedRecord struct {
+		ID       uint
+		TenantID uint
+		UserID   uint
+		Details  datatypes.JSON
+	}
+	current := piiPrefix + PII.KeyID() + ":"
+	reencrypted := 0
+	var batch []storedRecord
+	// A record still holds an old value when it has a marker that is not the current key's
+	err := db.Table("chatbot.interactions").Select("id, tenant_id, user_id, details").
+		Where("replace(details::text, ?, '') LIKE ?", current, "%"+piiMarker+"%").Order("id").
+		FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+			for _, record := range batch {
+				rekeyed, changed, err := PII.Rekey(record.TenantID, record.UserID, record.Details)
+				if errors.Is(err, ErrPIIDecrypt) {
+					// Its key is already gone; the other records still move
+					log.Printf("Skipping record %d: %v", record.ID, err)
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("failed to re-encrypt record %d: %w", record.ID, err)
+				}
+				if !changed {
+					continue
+				}
+				opened, err := PII.Open(record.TenantID, record.UserID, rekeyed)
+				if err != nil {
+					return fmt.Errorf("failed to re-encrypt record %d: %w", record.ID, err)
+				}
+				hashes, err := json.Marshal(PII.lookupHashes(opened))
+				if err != nil {
+					return err
+				}
+				err = db.Table("chatbot.interactions").Where("id = ?", record.ID).UpdateColumns(map[string]interface{}{
+					"details":    datatypes.JSON(rekeyed),
+					"pii_hashes": gorm.Expr("coalesce(pii_hashes, '{}'::jsonb) || ?::jsonb", string(hashes)),
+				}).Error
+				if err != nil {
+					return fmt.Errorf("failed to update record %d: %v", record.ID, err)
+				}
+				reencrypted++
+			}
+			return nil
+		}).Error
+	return reencrypted, err
+}
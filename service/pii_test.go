@@ -0,0 +1,186 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testPIIProtector(t *testing.T) *PIIProtector {
+	t.Helper()
+	protector, err := NewPIIProtector(bytes.Repeat([]byte{7}, 32), []string{"name", "phone", "customer.address"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return protector
+}
+
+func TestPIISealAndOpen(t *testing.T) {
+	p := testPIIProtector(t)
+	details := []byte(`{"name": "Amira Ben Ali", "phone": "+216 74 000 000", "order_id": 12, "customer": {"address": {"city": "Sfax"}}}`)
+
+	sealed, hashes, err := p.Seal(1, details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("Amira")) || bytes.Contains(sealed, []byte("74 000")) || bytes.Contains(sealed, []byte("Sfax")) {
+		t.Fatalf("personal data left in clear: %s", sealed)
+	}
+	if !bytes.Contains(sealed, []byte(`"order_id":12`)) {
+		t.Errorf("unprotected field changed: %s", sealed)
+	}
+	var lookup map[string]string
+	json.Unmarshal(hashes, &lookup)
+	if lookup["phone"] != p.Hash("phone", "+21674000000") || lookup["name"] != p.Hash("name", "amira  ben ali") {
+		t.Errorf("unexpected lookup hashes %v", lookup)
+	}
+	if _, ok := lookup["customer.address"]; ok {
+		t.Errorf("objects have no lookup hash: %v", lookup)
+	}
+
+	resealed, again, err := p.Seal(1, sealed)
+	if err != nil || !bytes.Equal(resealed, sealed) || again != nil {
+		t.Errorf("sealing twice changed the record: %s %s %v", resealed, again, err)
+	}
+
+	opened, err := p.Open(1, 42, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	json.Unmarshal(opened, &doc)
+	if doc["name"] != "Amira Ben Ali" || doc["phone"] != "+216 74 000 000" || doc["order_id"] != 12.0 {
+		t.Errorf("unexpected opened details %s", opened)
+	}
+	if city := doc["customer"].(map[string]interface{})["address"].(map[string]interface{})["city"]; city != "Sfax" {
+		t.Errorf("nested value not restored: %s", opened)
+	}
+
+	if _, err := p.Open(1, 43, sealed); err != nil {
+		t.Errorf("the record no longer opens once moved to another user: %v", err)
+	}
+	if _, err := p.Open(2, 42, sealed); !errors.Is(err, ErrPIIDecrypt) {
+		t.Errorf("another tenant's key opened the record: %v", err)
+	}
+	if clear, err := p.Open(1, 42, []byte(`{"phone": "+216"}`)); err != nil || string(clear) != `{"phone": "+216"}` {
+		t.Errorf("clear records should pass through: %s %v", clear, err)
+	}
+}
+
+func TestDataHooksProtectDetails(t *testing.T) {
+	PII = testPIIProtector(t)
+	defer func() { PII = nil }()
+
+	record := Data{TenantID: 1, UserID: 5, Details: []byte(`{"phone": "+21674000000", "note": "late"}`)}
+	if err := record.BeforeSave(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(record.Details), piiPrefix) || len(record.PIIHashes) == 0 {
+		t.Fatalf("record not sealed: %s", record.Details)
+	}
+	if err := record.AfterFind(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(record.Details), `"phone":"+21674000000"`) {
+		t.Errorf("record not opened: %s", record.Details)
+	}
+}
+
+func TestPIIRekeyLegacyValues(t *testing.T) {
+	p := testPIIProtector(t)
+	legacy, err := legacyCipher(p.current(), 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := sealValue(legacy, "", []byte(`"+21674000000"`), "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	details := []byte(`{"phone": "` + piiLegacyPrefix + strings.TrimPrefix(value, piiPrefix+":") + `"}`)
+	if opened, err := p.Open(1, 42, details); err != nil || !strings.Contains(string(opened), "+21674000000") {
+		t.Fatalf("legacy value not opened: %s %v", opened, err)
+	}
+
+	rekeyed, changed, err := p.Rekey(1, 42, details)
+	if err != nil || !changed || strings.Contains(string(rekeyed), piiLegacyPrefix) {
+		t.Fatalf("legacy value not rekeyed: %s %v %v", rekeyed, changed, err)
+	}
+	if opened, err := p.Open(1, 43, rekeyed); err != nil || !strings.Contains(string(opened), "+21674000000") {
+		t.Errorf("rekeyed value does not open for the merged user: %s %v", opened, err)
+	}
+	if _, changed, err := p.Rekey(1, 43, rekeyed); changed || err != nil {
+		t.Errorf("rekeying twice changed the record: %v %v", changed, err)
+	}
+}
+
+func TestPIIKeyRotation(t *testing.T) {
+	p := testPIIProtector(t)
+	oldKey := p.KeyID()
+	sealed, _, err := p.Seal(1, []byte(`{"phone": "+21674000000"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(sealed), piiPrefix+oldKey+":") {
+		t.Fatalf("value does not name its key: %s", sealed)
+	}
+
+	if err := p.Rotate(bytes.Repeat([]byte{8}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if p.KeyID() == oldKey {
+		t.Fatal("key not rotated")
+	}
+	if opened, err := p.Open(1, 5, sealed); err != nil || !strings.Contains(string(opened), "+21674000000") {
+		t.Fatalf("value sealed before the rotation no longer opens: %s %v", opened, err)
+	}
+	if hashes := p.LookupHashes("phone", "+21674000000"); len(hashes) != 2 || hashes[0] != p.Hash("phone", "+21674000000") {
+		t.Errorf("lookup does not cover the previous key: %v", hashes)
+	}
+	rekeyed, changed, err := p.Rekey(1, 5, sealed)
+	if err != nil || !changed || !strings.Contains(string(rekeyed), piiPrefix+p.KeyID()+":") {
+		t.Fatalf("value not moved to the new key: %s %v %v", rekeyed, changed, err)
+	}
+
+	// After a restart without the previous key, only the re-encrypted value opens
+	restarted, err := NewPIIProtector(bytes.Repeat([]byte{8}, 32), []string{"phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Open(1, 5, rekeyed); err != nil {
+		t.Errorf("re-encrypted value does not open: %v", err)
+	}
+	if _, err := restarted.Open(1, 5, sealed); !errors.Is(err, ErrPIIDecrypt) {
+		t.Errorf("value of a dropped key opened: %v", err)
+	}
+	withPrevious, err := NewPIIProtector(bytes.Repeat([]byte{8}, 32), []string{"phone"}, bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := withPrevious.Open(1, 5, sealed); err != nil {
+		t.Errorf("previous key does not open its values: %v", err)
+	}
+}
+
+func TestUndecryptableRecordIsFlagged(t *testing.T) {
+	PII = testPIIProtector(t)
+	defer func() { PII = nil }()
+
+	record := Data{TenantID: 1, UserID: 5, Details: []byte(`{"phone": "` + piiPrefix + `AAAA"}`)}
+	if err := record.AfterFind(nil); err != nil {
+		t.Fatalf("one undecryptable record fails the query: %v", err)
+	}
+	if !record.Undecryptable || !strings.Contains(string(record.Details), piiPrefix) {
+		t.Errorf("record not flagged: %+v", record)
+	}
+}
+
+func TestNewPIIProtectorRejectsBadKeys(t *testing.T) {
+	if _, err := NewPIIProtector([]byte("short"), DefaultPIIFields); err == nil {
+		t.Error("short key accepted")
+	}
+	if _, err := NewPIIProtector(bytes.Repeat([]byte{1}, 32), []string{" "}); err == nil {
+		t.Error("empty field list accepted")
+	}
+}
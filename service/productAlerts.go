@@ -0,0 +1,212 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Product alert kinds
+const (
+	AlertOutOfStock  = "out_of_stock"
+	AlertLowStock    = "low_stock"
+	AlertBackInStock = "back_in_stock"
+	AlertPriceChange = "price_change"
+)
+
+// ErrAlertNotFound is returned when an alert does not exist for the tenant
+var ErrAlertNotFound = errors.New("alert not found")
+
+// AlertThresholds decide which snapshot differences raise alerts
+type AlertThresholds struct {
+	// LowStock raises a low_stock alert when the stock falls to or below it; zero disables the alert
+	LowStock int `json:"low_stock"`
+	// PriceChangePercent raises a price_change alert for a change of at least this percentage; zero disables it
+	PriceChangePercent float64 `json:"price_change_percent"`
+}
+
+// ProductAlert is a price or stock change the chatbot and operators should know about
+type ProductAlert struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	TenantID       uint       `gorm:"not null;default:0;index" json:"tenant_id"`
+	ProductID      string     `gorm:"not null;index" json:"product_id"`
+	ProductName    string     `json:"product_name"`
+	Kind           string     `gorm:"not null;index" json:"kind"`
+	Message        string     `json:"message"`
+	OldStock       int        `json:"old_stock"`
+	NewStock       int        `json:"new_stock"`
+	OldPrice       float64    `json:"old_price"`
+	NewPrice       float64    `json:"new_price"`
+	Currency       string     `json:"currency,omitempty"`
+	CreatedAt      time.Time  `gorm:"index" json:"created_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+}
+
+// TableName specifies the table name for ProductAlert
+func (ProductAlert) TableName() string {
+	return "chatbot.product_alerts"
+}
+
+// AlertFilter narrows the alert feed; zero values are ignored
+type AlertFilter struct {
+	Kind           string
+	ProductID      string
+	Since          *time.Time
+	Unacknowledged bool
+}
+
+// DetectProductAlerts compares the previous snapshot of a product with its current state.
+// A threshold raises an alert only when it is crossed, so a product staying out of stock alerts once.
+func DetectProductAlerts(previous ProductStock, current Product, thresholds AlertThresholds) []ProductAlert {
+	base := ProductAlert{
+		ProductID:   current.ID,
+		ProductName: current.Name,
+		OldStock:    previous.Stock,
+		NewStock:    current.Stock,
+		OldPrice:    previous.Price,
+		NewPrice:    current.Price,
+		Currency:    current.Currency,
+	}
+	var alerts []ProductAlert
+	add := func(kind, message string) {
+		alert := base
+		alert.Kind, alert.Message = kind, message
+		alerts = append(alerts, alert)
+	}
+
+	switch {
+	case previous.Stock > 0 && current.Stock <= 0:
+		add(AlertOutOfStock, fmt.Sprintf("%s is out of stock", current.Name))
+	case previous.Stock <= 0 && current.Stock > 0:
+		add(AlertBackInStock, fmt.Sprintf("%s is back in stock (%d)", current.Name, current.Stock))
+	case thresholds.LowStock > 0 && previous.Stock > thresholds.LowStock && current.Stock <= thresholds.LowStock:
+		add(AlertLowStock, fmt.Sprintf("%s is running low: %d left", current.Name, current.Stock))
+	}
+
+	// Snapshots taken before prices were recorded have no price to compare with
+	if thresholds.PriceChangePercent > 0 && previous.Price > 0 && current.Price != previous.Price {
+		change := (current.Price - previous.Price) / previous.Price * 100
+		if math.Abs(change) >= thresholds.PriceChangePercent {
+			add(AlertPriceChange, fmt.Sprintf("%s price changed from %.3f to %.3f (%+.1f%%)", current.Name, previous.Price, current.Price, change))
+		}
+	}
+	return alerts
+}
+
+// ProductAlertService defines the interface for product alerts
+type ProductAlertService interface {
+	// Observe raises the alerts of a product whose previous snapshot was previous, storing and notifying them
+	Observe(tenantID uint, previous ProductStock, current Product) ([]ProductAlert, error)
+	ListAlerts(tenantID uint, filter AlertFilter) ([]ProductAlert, error)
+	Acknowledge(tenantID, id uint, actor string) (ProductAlert, error)
+	// SetThresholds replaces the thresholds used by the next observations
+	SetThresholds(thresholds AlertThresholds)
+}
+
+// GormProductAlertService implements ProductAlertService using GORM
+type GormProductAlertService struct {
+	db         *gorm.DB
+	notifier   Notifier
+	mu         sync.RWMutex
+	thresholds AlertThresholds
+}
+
+// NewGormProductAlertService creates a new GormProductAlertService
+func NewGormProductAlertService(db *gorm.DB, notifier Notifier, thresholds AlertThresholds) ProductAlertService {
+	return &GormProductAlertService{db: db, notifier: notifier, thresholds: thresholds}
+}
+
+// SetThresholds replaces the thresholds used by the next observations
+func (s *GormProductAlertService) SetThresholds(thresholds AlertThresholds) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.thresholds = thresholds
+}
+
+// Observe stores the detected alerts and notifies each one; a failed notification is logged, the alert stays in the feed
+func (s *GormProductAlertService) Observe(tenantID uint, previous ProductStock, current Product) ([]ProductAlert, error) {
+	s.mu.RLock()
+	thresholds := s.thresholds
+	s.mu.RUnlock()
+	alerts := DetectProductAlerts(previous, current, thresholds)
+	if len(alerts) == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	for i := range alerts {
+		alerts[i].TenantID = tenantID
+		alerts[i].CreatedAt = now
+	}
+	if err := s.db.Create(&alerts).Error; err != nil {
+		return nil, fmt.Errorf("failed to store alerts of %s: %v", current.ID, err)
+	}
+	for _, alert := range alerts {
+		err := s.notifier.Notify(Notification{
+			Event:   "product_alert",
+			Message: alert.Message,
+			Data: map[string]interface{}{
+				"tenant_id":  tenantID,
+				"alert_id":   alert.ID,
+				"kind":       alert.Kind,
+				"product_id": alert.ProductID,
+				"product":    alert.ProductName,
+				"stock":      alert.NewStock,
+				"price":      alert.NewPrice,
+			},
+			CreatedAt: now,
+		})
+		if err != nil {
+			log.Printf("Failed to notify %s alert of %s: %v", alert.Kind, alert.ProductID, err)
+		}
+	}
+	return alerts, nil
+}
+
+// ListAlerts returns the tenant's alerts, newest first
+func (s *GormProductAlertService) ListAlerts(tenantID uint, filter AlertFilter) ([]ProductAlert, error) {
+	query := s.db.Where("tenant_id = ?", tenantID)
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	if filter.ProductID != "" {
+		query = query.Where("product_id = ?", filter.ProductID)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Unacknowledged {
+		query = query.Where("acknowledged_at IS NULL")
+	}
+	var alerts []ProductAlert
+	if err := query.Order("created_at desc, id desc").Find(&alerts).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch alerts: %v", err)
+	}
+	return alerts, nil
+}
+
+// Acknowledge marks an alert as handled; acknowledging twice keeps the first acknowledgement
+func (s *GormProductAlertService) Acknowledge(tenantID, id uint, actor string) (ProductAlert, error) {
+	var alert ProductAlert
+	err := s.db.Where("tenant_id = ? AND id = ?", tenantID, id).First(&alert).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ProductAlert{}, fmt.Errorf("%w: %d", ErrAlertNotFound, id)
+	}
+	if err != nil {
+		return ProductAlert{}, fmt.Errorf("failed to load alert: %v", err)
+	}
+	if alert.AcknowledgedAt != nil {
+		return alert, nil
+	}
+	now := time.Now()
+	alert.AcknowledgedAt, alert.AcknowledgedBy = &now, actor
+	if err := s.db.Model(&alert).Updates(map[string]interface{}{"acknowledged_at": now, "acknowledged_by": actor}).Error; err != nil {
+		return ProductAlert{}, fmt.Errorf("failed to acknowledge alert: %v", err)
+	}
+	return alert, nil
+}
@@ -0,0 +1,38 @@
+package service
+
+import "testing"
+
+func TestDetectProductAlerts(t *testing.T) {
+	thresholds := AlertThresholds{LowStock: 5, PriceChangePercent: 10}
+	for name, tc := range map[string]struct {
+		previous ProductStock
+		current  Product
+		kinds    []string
+	}{
+		"unchanged":         {ProductStock{Stock: 10, Price: 20}, Product{Stock: 10, Price: 20}, nil},
+		"out of stock":      {ProductStock{Stock: 3, Price: 20}, Product{Stock: 0, Price: 20}, []string{AlertOutOfStock}},
+		"stays out":         {ProductStock{Stock: 0, Price: 20}, Product{Stock: 0, Price: 20}, nil},
+		"back in stock":     {ProductStock{Stock: 0, Price: 20}, Product{Stock: 8, Price: 20}, []string{AlertBackInStock}},
+		"crosses low":       {ProductStock{Stock: 9, Price: 20}, Product{Stock: 5, Price: 20}, []string{AlertLowStock}},
+		"already low":       {ProductStock{Stock: 4, Price: 20}, Product{Stock: 2, Price: 20}, nil},
+		"small price move":  {ProductStock{Stock: 10, Price: 20}, Product{Stock: 10, Price: 21}, nil},
+		"price drop":        {ProductStock{Stock: 10, Price: 20}, Product{Stock: 10, Price: 15}, []string{AlertPriceChange}},
+		"no previous price": {ProductStock{Stock: 10}, Product{Stock: 10, Price: 15}, nil},
+		"sold out and up":   {ProductStock{Stock: 1, Price: 20}, Product{Stock: 0, Price: 30}, []string{AlertOutOfStock, AlertPriceChange}},
+	} {
+		alerts := DetectProductAlerts(tc.previous, tc.current, thresholds)
+		if len(alerts) != len(tc.kinds) {
+			t.Errorf("%s: expected %v, got %+v", name, tc.kinds, alerts)
+			continue
+		}
+		for i, kind := range tc.kinds {
+			if alerts[i].Kind != kind || alerts[i].OldStock != tc.previous.Stock || alerts[i].NewPrice != tc.current.Price {
+				t.Errorf("%s: unexpected alert %+v", name, alerts[i])
+			}
+		}
+	}
+
+	if alerts := DetectProductAlerts(ProductStock{Stock: 9, Price: 20}, Product{Stock: 1, Price: 40}, AlertThresholds{}); len(alerts) != 0 {
+		t.Errorf("zero thresholds raise no low stock or price alerts, got %+v", alerts)
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Product is a Converty product with its current stock
+type Product struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Category string  `json:"category,omitempty"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency,omitempty"`
+	Stock    int     `json:"stock"`
+}
+
+// InStock reports whether the product can be ordered
+func (p Product) InStock() bool {
+	return p.Stock > 0
+}
+
+// productItem is the upstream JSON shape of a product
+type productItem struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Category string  `json:"category"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency"`
+	Quantity int     `json:"quantity"`
+}
+
+func (item productItem) toProduct() Product {
+	return Product{
+		ID:       item.ID,
+		Name:     item.Name,
+		Category: item.Category,
+		Price:    item.Price,
+		Currency: strings.ToUpper(item.Currency),
+		Stock:    item.Quantity,
+	}
+}
+
+// ListProducts fetches one page of the store's products
+func (s *GormDataService) ListProducts(page, limit int) ([]Product, error) {
+	tokenInfo, err := s.loadToken()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", s.converty.URL("products"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	q := url.Values{}
+	q.Add("store_id", tokenInfo.storeID())
+	q.Add("page", fmt.Sprintf("%d", page))
+	q.Add("limit", fmt.Sprintf("%d", limit))
+	req.URL.RawQuery = q.Encode()
+
+	body, err := s.doConvertyRequest(req, tokenInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch products: %w", err)
+	}
+	products, err := s.converty.DecodeProducts(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch products: %v", err)
+	}
+	return products, nil
+}
+
+// GetProduct fetches a single product
+func (s *GormDataService) GetProduct(id string) (Product, error) {
+	tokenInfo, err := s.loadToken()
+	if err != nil {
+		return Product{}, err
+	}
+	req, err := http.NewRequest("GET", s.converty.URL("products/"+url.PathEscape(id)), nil)
+	if err != nil {
+		return Product{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	q := url.Values{}
+	q.Add("store_id", tokenInfo.storeID())
+	req.URL.RawQuery = q.Encode()
+
+	body, err := s.doConvertyRequest(req, tokenInfo)
+	if err != nil {
+		return Product{}, fmt.Errorf("failed to fetch product %s: %w", id, err)
+	}
+	product, err := s.converty.DecodeProduct(body)
+	if err != nil {
+		return Product{}, fmt.Errorf("failed to fetch product %s: %v", id, err)
+	}
+	return product, nil
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// queryStartKey is where the plugin keeps the start time of a statement
+const queryStartKey = "query_metrics:start"
+
+// SlowQuery is one statement that ran longer than the threshold. SQL keeps the placeholders,
+// never the bound values, so personal data stays out of the log.
+type SlowQuery struct {
+	Time       time.Time `json:"time"`
+	Operation  string    `json:"operation"`
+	Table      string    `json:"table,omitempty"`
+	SQL        string    `json:"sql"`
+	DurationMS int64     `json:"duration_ms"`
+	Rows       int64     `json:"rows"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// QueryStats are the query metrics shown to operators, per operation (create, query, update, delete, row, raw)
+type QueryStats struct {
+	ThresholdMS int64            `json:"slow_threshold_ms"`
+	Queries     map[string]int64 `json:"queries"`
+	Slow        map[string]int64 `json:"slow"`
+	Errors      map[string]int64 `json:"errors"`
+	MaxMS       map[string]int64 `json:"max_ms"`
+}
+
+// QueryMetrics counts statements and keeps the last slow ones in a ring buffer
+type QueryMetrics struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	queries   map[string]int64
+	slowCount map[string]int64
+	errors    map[string]int64
+	max       map[string]time.Duration
+	slow      []SlowQuery
+	next      int
+	full      bool
+}
+
+// NewQueryMetrics creates metrics keeping up to size slow queries
+func NewQueryMetrics(size int) *QueryMetrics {
+	if size <= 0 {
+		size = 1
+	}
+	return &QueryMetrics{
+		queries:   map[string]int64{},
+		slowCount: map[string]int64{},
+		errors:    map[string]int64{},
+		max:       map[string]time.Duration{},
+		slow:      make([]SlowQuery, size),
+	}
+}
+
+// DBQueries are the query metrics of this process
+var DBQueries = NewQueryMetrics(100)
+
+// Count counts one statement of operation and reports whether it reached the slow threshold
+func (m *QueryMetrics) Count(operation string, elapsed time.Duration, failed bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries[operation]++
+	if failed {
+		m.errors[operation]++
+	}
+	if elapsed > m.max[operation] {
+		m.max[operation] = elapsed
+	}
+	if m.threshold <= 0 || elapsed < m.threshold {
+		return false
+	}
+	m.slowCount[operation]++
+	return true
+}
+
+// RecordSlow stores a slow query, overwriting the oldest one when the buffer is full
+func (m *QueryMetrics) RecordSlow(query SlowQuery) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.slow[m.next] = query
+	m.next = (m.next + 1) % len(m.slow)
+	if m.next == 0 {
+		m.full = true
+	}
+}
+
+// SlowQueries returns the recorded slow queries, newest first
+func (m *QueryMetrics) SlowQueries() []SlowQuery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := m.next
+	if m.full {
+		count = len(m.slow)
+	}
+	recent := make([]SlowQuery, 0, count)
+	for i := 1; i <= count; i++ {
+		recent = append(recent, m.slow[(m.next-i+len(m.slow))%len(m.slow)])
+	}
+	return recent
+}
+
+// Stats returns a snapshot of the counters
+func (m *QueryMetrics) Stats() QueryStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := QueryStats{
+		ThresholdMS: m.threshold.Milliseconds(),
+		Queries:     map[string]int64{},
+		Slow:        map[string]int64{},
+		Errors:      map[string]int64{},
+		MaxMS:       map[string]int64{},
+	}
+	for operation, count := range m.queries {
+		stats.Queries[operation] = count
+		stats.Slow[operation] = m.slowCount[operation]
+		stats.Errors[operation] = m.errors[operation]
+		stats.MaxMS[operation] = m.max[operation].Milliseconds()
+	}
+	return stats
+}
+
+// QueryMetricsPlugin is a GORM plugin timing every statement into QueryMetrics and logging the slow ones
+type QueryMetricsPlugin struct {
+	Metrics *QueryMetrics
+	// Threshold is the duration from which a statement is slow; 0 only counts statements
+	Threshold time.Duration
+}
+
+// Name implements gorm.Plugin
+func (p *QueryMetricsPlugin) Name() string { return "query_metrics" }
+
+// Initialize implements gorm.Plugin by wrapping each callback chain with a timer
+func (p *QueryMetricsPlugin) Initialize(db *gorm.DB) error {
+	p.Metrics.mu.Lock()
+	p.Metrics.threshold = p.Threshold
+	p.Metrics.mu.Unlock()
+
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("query_metrics:before_create", startQueryTimer),
+		callbacks.Create().After("gorm:create").Register("query_metrics:after_create", p.observe("create")),
+		callbacks.Query().Before("gorm:query").Register("query_metrics:before_query", startQueryTimer),
+		callbacks.Query().After("gorm:query").Register("query_metrics:after_query", p.observe("query")),
+		callbacks.Update().Before("gorm:update").Register("query_metrics:before_update", startQueryTimer),
+		callbacks.Update().After("gorm:update").Register("query_metrics:after_update", p.observe("update")),
+		callbacks.Delete().Before("gorm:delete").Register("query_metrics:before_delete", startQueryTimer),
+		callbacks.Delete().After("gorm:delete").Register("query_metrics:after_delete", p.observe("delete")),
+		callbacks.Row().Before("gorm:row").Register("query_metrics:before_row", startQueryTimer),
+		callbacks.Row().After("gorm:row").Register("query_metrics:after_row", p.observe("row")),
+		callbacks.Raw().Before("gorm:raw").Register("query_metrics:before_raw", startQueryTimer),
+		callbacks.Raw().After("gorm:raw").Register("query_metrics:after_raw", p.observe("raw")),
+	)
+}
+
+// startQueryTimer stores when the statement started
+func startQueryTimer(db *gorm.DB) {
+	db.InstanceSet(queryStartKey, time.Now())
+}
+
+// observe returns the callback measuring a statement of operation
+func (p *QueryMetricsPlugin) observe(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(queryStartKey)
+		if !ok {
+			return
+		}
+		start, ok := value.(time.Time)
+		if !ok {
+			return
+		}
+		elapsed := time.Since(start)
+		failed := db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound)
+		if !p.Metrics.Count(operation, elapsed, failed) {
+			return
+		}
+		query := SlowQuery{
+			Time:       start,
+			Operation:  operation,
+			Table:      db.Statement.Table,
+			SQL:        db.Statement.SQL.String(),
+			DurationMS: elapsed.Milliseconds(),
+			Rows:       db.Statement.RowsAffected,
+		}
+		if failed {
+			query.Error = db.Error.Error()
+		}
+		p.Metrics.RecordSlow(query)
+		log.Printf("Slow query (%s, %dms, %d rows): %s", operation, query.DurationMS, query.Rows, query.SQL)
+	}
+}
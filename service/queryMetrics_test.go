@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryMetricsKeepsNewestSlowQueries(t *testing.T) {
+	metrics := NewQueryMetrics(2)
+	metrics.threshold = 100 * time.Millisecond
+	if metrics.Count("query", 10*time.Millisecond, false) {
+		t.Error("fast statement counted as slow")
+	}
+	for _, sql := range []string{"first", "second", "third"} {
+		if !metrics.Count("query", 150*time.Millisecond, sql == "third") {
+			t.Fatalf("%s not slow", sql)
+		}
+		metrics.RecordSlow(SlowQuery{Operation: "query", SQL: sql})
+	}
+
+	slow := metrics.SlowQueries()
+	if len(slow) != 2 || slow[0].SQL != "third" || slow[1].SQL != "second" {
+		t.Errorf("unexpected slow queries %+v", slow)
+	}
+	stats := metrics.Stats()
+	if stats.Queries["query"] != 4 || stats.Slow["query"] != 3 || stats.Errors["query"] != 1 || stats.MaxMS["query"] != 150 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
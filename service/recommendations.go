@@ -0,0 +1,309 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scoring strategies of the recommendations
+const (
+	// StrategyCategoryAffinity favors the categories the customer buys from, then the store's best sellers
+	StrategyCategoryAffinity = "category_affinity"
+	// StrategyPopular ranks by the store's recent best sellers, whatever the customer bought
+	StrategyPopular = "popular"
+)
+
+// Reasons given with a recommendation
+const (
+	ReasonFavoriteCategory = "favorite_category"
+	ReasonBestSeller       = "best_seller"
+	ReasonBoughtBefore     = "bought_before"
+)
+
+// popularityWindow is how far back the store's sales count towards popularity
+const popularityWindow = 90 * 24 * time.Hour
+
+// unsoldStatuses are the mirrored order statuses that say nothing about the customer's tastes
+var unsoldStatuses = []string{"cancelled", "canceled", "rejected", "returned"}
+
+// CustomerProfile is what the mirrored orders tell about a customer and the store
+type CustomerProfile struct {
+	Phone  string `json:"phone"`
+	Orders int    `json:"orders"`
+	// Categories is the share of the units the customer bought in each category, by category key
+	Categories map[string]float64 `json:"categories"`
+	// Purchased counts the units the customer bought of each product
+	Purchased map[string]int `json:"-"`
+	// Popularity counts the units of each product the store sold recently, all customers together
+	Popularity map[string]int `json:"-"`
+}
+
+// popularity scales the store's sales of a product to 0-1 against its best seller
+func (p CustomerProfile) popularity(productID string) float64 {
+	best := 0
+	for _, units := range p.Popularity {
+		best = max(best, units)
+	}
+	if best == 0 {
+		return 0
+	}
+	return float64(p.Popularity[productID]) / float64(best)
+}
+
+// Recommendation is a product suggested to a customer, best first
+type Recommendation struct {
+	Product Product  `json:"product"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// ScoringStrategy ranks a product for a customer; the product's Category is already a category key.
+// Products scoring zero or less are not recommended.
+type ScoringStrategy interface {
+	Score(product Product, profile CustomerProfile) (float64, []string)
+}
+
+// ScoringFunc adapts a function to ScoringStrategy
+type ScoringFunc func(product Product, profile CustomerProfile) (float64, []string)
+
+func (f ScoringFunc) Score(product Product, profile CustomerProfile) (float64, []string) {
+	return f(product, profile)
+}
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = map[string]ScoringStrategy{
+		StrategyCategoryAffinity: ScoringFunc(scoreCategoryAffinity),
+		StrategyPopular:          ScoringFunc(scorePopular),
+	}
+)
+
+// RegisterScoringStrategy makes a strategy selectable by name, replacing one of the same name
+func RegisterScoringStrategy(name string, strategy ScoringStrategy) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	strategies[name] = strategy
+}
+
+// ScoringStrategyNames lists the registered strategies
+func ScoringStrategyNames() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scoringStrategy finds a strategy by name; empty is StrategyCategoryAffinity
+func scoringStrategy(name string) (ScoringStrategy, error) {
+	if name == "" {
+		name = StrategyCategoryAffinity
+	}
+	strategiesMu.RLock()
+	strategy, ok := strategies[name]
+	strategiesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown recommendation strategy %q, expected one of %s", ErrValidation, name, strings.Join(ScoringStrategyNames(), ", "))
+	}
+	return strategy, nil
+}
+
+// scoreCategoryAffinity weighs the customer's share of purchases in the product's category against
+// the product's sales; products the customer already bought keep a quarter of their score
+func scoreCategoryAffinity(product Product, profile CustomerProfile) (float64, []string) {
+	var reasons []string
+	affinity := profile.Categories[product.Category]
+	if affinity > 0 {
+		reasons = append(reasons, ReasonFavoriteCategory)
+	}
+	popularity := profile.popularity(product.ID)
+	if popularity >= 0.5 {
+		reasons = append(reasons, ReasonBestSeller)
+	}
+	score := 0.7*affinity + 0.3*popularity
+	if profile.Purchased[product.ID] > 0 {
+		score *= 0.25
+		reasons = append(reasons, ReasonBoughtBefore)
+	}
+	return score, reasons
+}
+
+// scorePopular ranks the store's best sellers the customer has not bought yet
+func scorePopular(product Product, profile CustomerProfile) (float64, []string) {
+	if profile.Purchased[product.ID] > 0 {
+		return 0, nil
+	}
+	popularity := profile.popularity(product.ID)
+	if popularity <= 0 {
+		return 0, nil
+	}
+	return popularity, []string{ReasonBestSeller}
+}
+
+// RankProducts scores the products in stock of a catalogue with strategy and returns the best limit,
+// ties going to the cheaper product. categoryKey maps the category of a product or order line to a
+// key, so that a category named by ID and by name is the same one.
+func RankProducts(catalogue []Product, profile CustomerProfile, strategy ScoringStrategy, categoryKey func(string) string, limit int) []Recommendation {
+	ranked := []Recommendation{}
+	for _, product := range catalogue {
+		if !product.InStock() {
+			continue
+		}
+		scored := product
+		scored.Category = categoryKey(product.Category)
+		score, reasons := strategy.Score(scored, profile)
+		if score <= 0 {
+			continue
+		}
+		ranked = append(ranked, Recommendation{Product: product, Score: float64(int(score*1000+0.5)) / 1000, Reasons: reasons})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Product.Price < ranked[j].Product.Price
+	})
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+// RecommendationService suggests products to a customer from their mirrored orders
+type RecommendationService interface {
+	// Profile reads the customer's mirrored orders and the store's recent sales
+	Profile(tenantID uint, phone string) (CustomerProfile, error)
+	// Recommend ranks the catalogue for the customer with the named strategy, empty for the default
+	Recommend(tenantID uint, phone string, catalogue []Product, strategy string, limit int) ([]Recommendation, CustomerProfile, error)
+}
+
+// GormRecommendationService implements RecommendationService over the order mirror and the mirrored
+// categories
+type GormRecommendationService struct {
+	db         *gorm.DB
+	categories CategoryService
+}
+
+// NewGormRecommendationService creates a new GormRecommendationService; without categories, category
+// references are compared by name
+func NewGormRecommendationService(db *gorm.DB, categories CategoryService) RecommendationService {
+	return &GormRecommendationService{db: db, categories: categories}
+}
+
+// Profile gathers the customer's purchases, outside cancelled and returned orders, and the store's
+// sales of the popularity window
+func (s *GormRecommendationService) Profile(tenantID uint, phone string) (CustomerProfile, error) {
+	categoryKey, err := s.categoryKeys(tenantID)
+	if err != nil {
+		return CustomerProfile{}, err
+	}
+	return s.profile(tenantID, phone, categoryKey)
+}
+
+func (s *GormRecommendationService) profile(tenantID uint, phone string, categoryKey func(string) string) (CustomerProfile, error) {
+	phone = NormalizePhone(phone)
+	if phone == "" {
+		return CustomerProfile{}, fmt.Errorf("%w: a phone number is required", ErrValidation)
+	}
+	profile := CustomerProfile{Phone: phone, Categories: map[string]float64{}, Purchased: map[string]int{}, Popularity: map[string]int{}}
+
+	var orderIDs []string
+	if err := s.db.Model(&OrderRecord{}).
+		Where("tenant_id = ? AND lower(status) NOT IN ?", tenantID, unsoldStatuses).
+		Where(normalizedPhoneSQL("customer::jsonb ->> 'phone'")+" = ?", phone).
+		Pluck("order_id", &orderIDs).Error; err != nil {
+		return CustomerProfile{}, fmt.Errorf("failed to fetch the orders of %s: %v", phone, err)
+	}
+	profile.Orders = len(orderIDs)
+	if len(orderIDs) > 0 {
+		var lines []OrderItemRecord
+		if err := s.db.Where("tenant_id = ? AND order_id IN ?", tenantID, orderIDs).Find(&lines).Error; err != nil {
+			return CustomerProfile{}, fmt.Errorf("failed to fetch the order lines of %s: %v", phone, err)
+		}
+		units := 0
+		for _, line := range lines {
+			quantity := max(line.Quantity, 1)
+			profile.Purchased[line.ProductID] += quantity
+			if category := categoryKey(line.Category); category != "" {
+				profile.Categories[category] += float64(quantity)
+			}
+			units += quantity
+		}
+		for category, bought := range profile.Categories {
+			profile.Categories[category] = bought / float64(units)
+		}
+	}
+
+	var sales []struct {
+		ProductID string
+		Units     int
+	}
+	if err := s.db.Model(&OrderItemRecord{}).
+		Select("chatbot.order_items.product_id, SUM(GREATEST(chatbot.order_items.quantity, 1)) AS units").
+		Joins("JOIN chatbot.orders ON chatbot.orders.tenant_id = chatbot.order_items.tenant_id AND chatbot.orders.order_id = chatbot.order_items.order_id").
+		Where("chatbot.order_items.tenant_id = ? AND chatbot.orders.ordered_at >= ? AND lower(chatbot.orders.status) NOT IN ?", tenantID, time.Now().Add(-popularityWindow), unsoldStatuses).
+		Group("chatbot.order_items.product_id").
+		Scan(&sales).Error; err != nil {
+		return CustomerProfile{}, fmt.Errorf("failed to fetch the store's sales: %v", err)
+	}
+	for _, sale := range sales {
+		profile.Popularity[sale.ProductID] = sale.Units
+	}
+	return profile, nil
+}
+
+// Recommend profiles the customer and ranks the catalogue
+func (s *GormRecommendationService) Recommend(tenantID uint, phone string, catalogue []Product, strategy string, limit int) ([]Recommendation, CustomerProfile, error) {
+	scoring, err := scoringStrategy(strategy)
+	if err != nil {
+		return nil, CustomerProfile{}, err
+	}
+	categoryKey, err := s.categoryKeys(tenantID)
+	if err != nil {
+		return nil, CustomerProfile{}, err
+	}
+	profile, err := s.profile(tenantID, phone, categoryKey)
+	if err != nil {
+		return nil, CustomerProfile{}, err
+	}
+	return RankProducts(catalogue, profile, scoring, categoryKey, limit), profile, nil
+}
+
+// categoryKeys maps a category reference to the external ID of the mirrored category it names, or to
+// the lower-cased reference when none does
+func (s *GormRecommendationService) categoryKeys(tenantID uint) (func(string) string, error) {
+	var categories []Category
+	if s.categories != nil {
+		var err error
+		if categories, err = s.categories.ListCategories(tenantID); err != nil {
+			return nil, err
+		}
+	}
+	return func(ref string) string {
+		for _, category := range categories {
+			if category.Matches(ref) {
+				return category.ExternalID
+			}
+		}
+		return strings.ToLower(strings.TrimSpace(ref))
+	}, nil
+}
+
+// ListCatalogue reads up to maxPages pages of the store's products, pageSize at a time
+func ListCatalogue(dataService DataService, priority UpstreamPriority, pageSize, maxPages int) ([]Product, error) {
+	var catalogue []Product
+	fetch := func(page int) ([]Product, error) { return dataService.ListProducts(page, pageSize) }
+	err := FetchPages(UpstreamLimit.PageWorkers(priority), pageSize, maxPages, fetch, func(page int, products []Product) (bool, error) {
+		catalogue = append(catalogue, products...)
+		return true, nil
+	})
+	return catalogue, err
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func recommendationCatalogue() []Product {
+	return []Product{
+		{ID: "blender", Name: "Blender", Category: "12", Price: 89, Stock: 4},
+		{ID: "mixer", Name: "Mixer", Category: "Kitchen", Price: 120, Stock: 2},
+		{ID: "kettle", Name: "Kettle", Category: "kitchen", Price: 45, Stock: 0},
+		{ID: "lamp", Name: "Lamp", Category: "Lighting", Price: 30, Stock: 9},
+		{ID: "bulb", Name: "Bulb", Category: "lighting", Price: 5, Stock: 50},
+	}
+}
+
+// kitchenKey treats the category with external ID 12 and name Kitchen as one
+func kitchenKey(ref string) string {
+	if ref == "12" || strings.EqualFold(ref, "kitchen") {
+		return "12"
+	}
+	return strings.ToLower(ref)
+}
+
+func TestRankProductsCategoryAffinity(t *testing.T) {
+	profile := CustomerProfile{
+		Orders:     2,
+		Categories: map[string]float64{"12": 1},
+		Purchased:  map[string]int{"blender": 1},
+		Popularity: map[string]int{"bulb": 40, "lamp": 10, "blender": 20},
+	}
+	ranked := RankProducts(recommendationCatalogue(), profile, ScoringFunc(scoreCategoryAffinity), kitchenKey, 10)
+
+	var ids []string
+	for _, recommendation := range ranked {
+		ids = append(ids, recommendation.Product.ID)
+	}
+	// The kettle is out of stock; the blender was bought already and falls behind the best seller
+	if got := strings.Join(ids, ","); got != "mixer,bulb,blender,lamp" {
+		t.Fatalf("ranking = %s", got)
+	}
+	if ranked[0].Score != 0.7 || len(ranked[0].Reasons) != 1 || ranked[0].Reasons[0] != ReasonFavoriteCategory {
+		t.Errorf("mixer = %+v", ranked[0])
+	}
+	if ranked[0].Product.Category != "Kitchen" {
+		t.Errorf("the product keeps its own category, got %q", ranked[0].Product.Category)
+	}
+	if len(RankProducts(recommendationCatalogue(), profile, ScoringFunc(scoreCategoryAffinity), kitchenKey, 2)) != 2 {
+		t.Error("limit not applied")
+	}
+}
+
+func TestRankProductsPopular(t *testing.T) {
+	profile := CustomerProfile{Purchased: map[string]int{"bulb": 3}, Popularity: map[string]int{"bulb": 40, "lamp": 10, "mixer": 10}}
+	ranked := RankProducts(recommendationCatalogue(), profile, ScoringFunc(scorePopular), kitchenKey, 10)
+	// Equal sales go to the cheaper product; products never sold are left out
+	if len(ranked) != 2 || ranked[0].Product.ID != "lamp" || ranked[1].Product.ID != "mixer" {
+		t.Errorf("ranking = %+v", ranked)
+	}
+}
+
+func TestScoringStrategies(t *testing.T) {
+	if _, err := scoringStrategy("margin"); !errors.Is(err, ErrValidation) {
+		t.Errorf("unknown strategy: %v", err)
+	}
+	RegisterScoringStrategy("cheapest", ScoringFunc(func(product Product, profile CustomerProfile) (float64, []string) {
+		return 1 / product.Price, nil
+	}))
+	defer func() {
+		strategiesMu.Lock()
+		delete(strategies, "cheapest")
+		strategiesMu.Unlock()
+	}()
+	strategy, err := scoringStrategy("cheapest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranked := RankProducts(recommendationCatalogue(), CustomerProfile{}, strategy, kitchenKey, 1)
+	if len(ranked) != 1 || ranked[0].Product.ID != "bulb" {
+		t.Errorf("custom strategy ranking = %+v", ranked)
+	}
+	if names := strings.Join(ScoringStrategyNames(), ","); names != "category_affinity,cheapest,popular" {
+		t.Errorf("strategies = %s", names)
+	}
+}
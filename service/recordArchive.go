@@ -0,0 +1,149 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ArchiveRecord hides a record from listings; it can be brought back with RestoreRecord
+func (s *GormDataService) ArchiveRecord(id uint) (Data, error) {
+	record, err := s.QueryByID(id)
+	if err != nil {
+		return Data{}, err
+	}
+	if IsImmutableType(record.Type) {
+		return Data{}, fmt.Errorf("%w: %s records cannot be archived", ErrImmutableRecord, record.Type)
+	}
+	if record.ArchivedAt != nil {
+		return record, nil
+	}
+	now := time.Now()
+	archived := recordLifecycleEvent(EventRecordArchived, record.ID, record.TenantID, record.Type)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&record).Update("archived_at", now).Error; err != nil {
+			return err
+		}
+		return WriteOutbox(tx, archived)
+	})
+	if err != nil {
+		return Data{}, fmt.Errorf("failed to archive record: %v", err)
+	}
+	Events.Publish(archived)
+	record.ArchivedAt = &now
+	return record, nil
+}
+
+// RestoreRecord brings back an archived or soft-deleted record
+func (s *GormDataService) RestoreRecord(id uint) (Data, error) {
+	var record Data
+	if err := s.db.Unscoped().Scopes(s.tenantScope).First(&record, id).Error; err != nil {
+		return Data{}, recordLookupError(id, err)
+	}
+	if IsImmutableType(record.Type) {
+		return Data{}, fmt.Errorf("%w: %s records cannot be restored", ErrImmutableRecord, record.Type)
+	}
+	restored := recordLifecycleEvent(EventRecordRestored, record.ID, record.TenantID, record.Type)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&record).Updates(map[string]interface{}{
+			"archived_at": nil,
+			"deleted_at":  nil,
+		}).Error; err != nil {
+			return err
+		}
+		return WriteOutbox(tx, restored)
+	})
+	if err != nil {
+		return Data{}, fmt.Errorf("failed to restore record: %v", err)
+	}
+	Events.Publish(restored)
+	record.ArchivedAt = nil
+	record.DeletedAt.Valid = false
+	return record, nil
+}
+
+// DeleteRecord soft-deletes a record; it stays restorable until the retention purge removes it
+func (s *GormDataService) DeleteRecord(id uint) error {
+	record, err := s.QueryByID(id)
+	if err != nil {
+		return err
+	}
+	if IsImmutableType(record.Type) {
+		return fmt.Errorf("%w: %s records cannot be deleted", ErrImmutableRecord, record.Type)
+	}
+	deleted := recordLifecycleEvent(EventRecordDeleted, record.ID, record.TenantID, record.Type)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Scopes(s.tenantScope).Delete(&Data{}, id)
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete record: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("record with ID %d: %w", id, ErrNotFound)
+		}
+		return WriteOutbox(tx, deleted)
+	})
+	if err != nil {
+		return err
+	}
+	Events.Publish(deleted)
+	return nil
+}
+
+// PurgeRecords permanently removes records created, or soft-deleted, before olderThan together with their status history,
+// and returns their IDs. Records belonging to skipUserIDs (customers under legal hold) are kept.
+func (s *GormDataService) PurgeRecords(olderThan time.Time, skipUserIDs []uint) ([]uint, error) {
+	var purged []uint
+	var events []Event
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Unscoped().Model(&Data{}).Where("(created_at < ? OR deleted_at < ?)", olderThan, olderThan)
+		if len(skipUserIDs) > 0 {
+			query = query.Where("user_id NOT IN ?", skipUserIDs)
+		}
+		var rows []struct {
+			ID       uint
+			TenantID uint
+			Type     string
+		}
+		if err := query.Select("id", "tenant_id", "type").Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(rows))
+		events = make([]Event, len(rows))
+		for i, row := range rows {
+			ids[i] = row.ID
+			events[i] = recordLifecycleEvent(EventRecordPurged, row.ID, row.TenantID, row.Type)
+			if err := WriteOutbox(tx, events[i]); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("record_id IN ?", ids).Delete(&StatusChange{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("id IN ?", ids).Delete(&Data{}).Error; err != nil {
+			return err
+		}
+		purged = ids
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge records: %v", err)
+	}
+	for _, event := range events {
+		Events.Publish(event)
+	}
+	return purged, nil
+}
+
+// recordLifecycleEvent announces that a record was archived, restored, deleted or purged
+func recordLifecycleEvent(eventType string, id, tenantID uint, recordType string) Event {
+	return Event{
+		Type:     eventType,
+		TenantID: tenantID,
+		Data:     map[string]interface{}{"id": id, "type": recordType},
+		Time:     time.Now(),
+	}
+}
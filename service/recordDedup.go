@@ -0,0 +1,140 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Record dedup modes: what InsertRecord does with a record identical to one inserted within the window
+const (
+	RecordDedupOff = "off"
+	// RecordDedupReject fails the insert with a DuplicateRecordError naming the earlier record
+	RecordDedupReject = "reject"
+	// RecordDedupMerge returns the earlier record, flagged Duplicate, instead of inserting another
+	RecordDedupMerge = "merge"
+)
+
+// ErrDuplicateRecord is returned when a record repeats one inserted within the dedup window
+var ErrDuplicateRecord = errors.New("duplicate record")
+
+// RecordDedupSettings configure the dedup of inserted records. Records are identical when their type,
+// chatbot user and details match, ignoring key order, letter case and extra whitespace in the values.
+type RecordDedupSettings struct {
+	Mode   string
+	Window time.Duration
+	// Types limits the dedup to these record types; empty applies it to every type
+	Types []string
+}
+
+// Validate checks the mode and the window
+func (s RecordDedupSettings) Validate() error {
+	switch s.Mode {
+	case "", RecordDedupOff:
+		return nil
+	case RecordDedupReject, RecordDedupMerge:
+	default:
+		return fmt.Errorf("invalid record dedup mode %q, expected off, reject or merge", s.Mode)
+	}
+	if s.Window <= 0 {
+		return fmt.Errorf("the record dedup window must be positive")
+	}
+	return nil
+}
+
+// applies reports whether records of dataType are deduplicated
+func (s RecordDedupSettings) applies(dataType string) bool {
+	if s.Mode == "" || s.Mode == RecordDedupOff {
+		return false
+	}
+	if len(s.Types) == 0 {
+		return true
+	}
+	for _, t := range s.Types {
+		if t == dataType {
+			return true
+		}
+	}
+	return false
+}
+
+// DuplicateRecordError names the record an insert repeats
+type DuplicateRecordError struct {
+	Existing Data
+}
+
+func (e *DuplicateRecordError) Error() string {
+	return fmt.Sprintf("%v: record %d with the same content was inserted at %s", ErrDuplicateRecord, e.Existing.ID, e.Existing.CreatedAt.Format(time.RFC3339))
+}
+
+func (e *DuplicateRecordError) Unwrap() error {
+	return ErrDuplicateRecord
+}
+
+// recordContentHash hashes the normalized type, user and details of a record. With PII protection
+// the hash is keyed like the lookup hashes, so it reveals nothing about the details.
+func recordContentHash(userID uint, dataType string, details []byte) (string, error) {
+	var doc interface{}
+	if len(details) > 0 {
+		if err := json.Unmarshal(details, &doc); err != nil {
+			return "", fmt.Errorf("failed to parse details: %v", err)
+		}
+	}
+	// Maps marshal with sorted keys, so the encoding does not depend on the order the chatbot sent
+	canonical, err := json.Marshal(map[string]interface{}{
+		"type":    strings.ToLower(strings.TrimSpace(dataType)),
+		"user_id": userID,
+		"details": normalizeContent(doc),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode details: %v", err)
+	}
+	if PII != nil {
+		return PII.Hash("content", string(canonical)), nil
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// normalizeContent lower-cases strings and collapses their whitespace throughout a JSON document
+func normalizeContent(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return strings.ToLower(strings.Join(strings.Fields(v), " "))
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			normalized[key] = normalizeContent(item)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeContent(item)
+		}
+		return normalized
+	default:
+		return v
+	}
+}
+
+// findDuplicate returns the newest live record of the tenant with the content hash inserted since
+// since. It serializes inserts of the same content so concurrent retries cannot both get through.
+func findDuplicate(tx *gorm.DB, tenantID uint, hash string, since time.Time) (Data, bool, error) {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "record:"+hash).Error; err != nil {
+		return Data{}, false, fmt.Errorf("failed to lock record content: %v", err)
+	}
+	var existing Data
+	result := tx.Where("tenant_id = ? AND content_hash = ? AND created_at >= ?", tenantID, hash, since).
+		Order("created_at desc").Limit(1).Find(&existing)
+	if result.Error != nil {
+		return Data{}, false, fmt.Errorf("failed to look up duplicates: %v", result.Error)
+	}
+	return existing, result.RowsAffected > 0, nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecordContentHash(t *testing.T) {
+	hash, err := recordContentHash(3, "delivery", []byte(`{"name": "Sami", "items": ["Blender", "Kettle"], "phone": "+216 98 111 222"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Key order, letter case and whitespace do not make a record different
+	same, _ := recordContentHash(3, "Delivery ", []byte(`{"phone": "+216  98 111 222", "items": ["blender", " KETTLE"], "name": "sami"}`))
+	if same != hash {
+		t.Errorf("normalized content hashed differently: %s != %s", same, hash)
+	}
+	for name, details := range map[string]string{
+		"value":      `{"name": "Sami", "items": ["Blender", "Kettle"], "phone": "+216 98 111 223"}`,
+		"item order": `{"name": "Sami", "items": ["Kettle", "Blender"], "phone": "+216 98 111 222"}`,
+		"extra key":  `{"name": "Sami", "items": ["Blender", "Kettle"], "phone": "+216 98 111 222", "note": ""}`,
+	} {
+		if other, _ := recordContentHash(3, "delivery", []byte(details)); other == hash {
+			t.Errorf("%s change not detected", name)
+		}
+	}
+	if other, _ := recordContentHash(4, "delivery", []byte(`{"name": "Sami", "items": ["Blender", "Kettle"], "phone": "+216 98 111 222"}`)); other == hash {
+		t.Error("another user's record hashed the same")
+	}
+	if _, err := recordContentHash(3, "delivery", []byte(`not json`)); err == nil {
+		t.Error("expected an error for invalid details")
+	}
+}
+
+func TestRecordDedupSettings(t *testing.T) {
+	for _, settings := range []RecordDedupSettings{{}, {Mode: RecordDedupOff}, {Mode: RecordDedupMerge, Window: time.Minute}} {
+		if err := settings.Validate(); err != nil {
+			t.Errorf("%+v rejected: %v", settings, err)
+		}
+	}
+	for _, settings := range []RecordDedupSettings{{Mode: "flag", Window: time.Minute}, {Mode: RecordDedupReject}} {
+		if settings.Validate() == nil {
+			t.Errorf("%+v accepted", settings)
+		}
+	}
+
+	scoped := RecordDedupSettings{Mode: RecordDedupReject, Window: time.Minute, Types: []string{"delivery"}}
+	if !scoped.applies("delivery") || scoped.applies("address") || (RecordDedupSettings{}).applies("delivery") {
+		t.Error("dedup applied to the wrong types")
+	}
+
+	err := error(&DuplicateRecordError{Existing: Data{ID: 9}})
+	if !errors.Is(err, ErrDuplicateRecord) {
+		t.Errorf("%v does not wrap ErrDuplicateRecord", err)
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// recordIntake classifies, validates and fingerprints the records a RecordRepository inserts, so
+// every store accepts the same records
+type recordIntake struct {
+	classifier RecordClassifier
+	validator  RecordValidator
+	dedup      RecordDedupSettings
+	limits     DetailsLimits
+}
+
+func newRecordIntake(opts DataServiceOptions) recordIntake {
+	return recordIntake{classifier: opts.Classifier, validator: opts.Validator, dedup: opts.Dedup, limits: opts.Limits}
+}
+
+// newRecord builds the unsaved record of an insert: the status checked against the workflow (pending
+// when empty), the details classified by the tenant's rules, held to the details limits and checked
+// against the schema of their type, and the content hash set when the type is deduplicated
+func (in recordIntake) newRecord(tenantID, userID uint, dataType string, details map[string]interface{}, status string) (Data, error) {
+	if status == "" {
+		status = StatusPending
+	}
+	if !IsWorkflowStatus(status) {
+		return Data{}, fmt.Errorf("%w: %q is not a record status", ErrValidation, status)
+	}
+	if in.classifier != nil && details != nil {
+		in.classifier.Classify(tenantID, dataType, details)
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return Data{}, fmt.Errorf("%w: failed to marshal details: %v", ErrValidation, err)
+	}
+	if err := in.limits.Check(detailsJSON); err != nil {
+		return Data{}, err
+	}
+	var schemaVersion int
+	if in.validator != nil {
+		if schemaVersion, err = in.validator.ValidateRecord(dataType, detailsJSON); err != nil {
+			return Data{}, err
+		}
+	}
+
+	var contentHash string
+	if in.dedup.applies(dataType) {
+		if contentHash, err = recordContentHash(userID, dataType, detailsJSON); err != nil {
+			return Data{}, err
+		}
+	}
+
+	return Data{
+		UserID:    userID,
+		Type:      dataType,
+		Details:   detailsJSON,
+		Status:    status,
+		CreatedAt: time.Now(),
+		TenantID:  tenantID,
+		Version:   1,
+		Revision:  1,
+
+		SchemaVersion: schemaVersion,
+		ContentHash:   contentHash,
+	}, nil
+}
+
+// duplicateOf answers an insert repeating existing: the DuplicateRecordError in reject mode, the
+// earlier record flagged Duplicate in merge mode
+func (in recordIntake) duplicateOf(existing Data) (Data, error) {
+	if in.dedup.Mode == RecordDedupReject {
+		return Data{}, &DuplicateRecordError{Existing: existing}
+	}
+	existing.Duplicate = true
+	return existing, nil
+}
+
+// recordCreatedEvent announces an inserted record
+func recordCreatedEvent(record Data) Event {
+	return Event{
+		Type:     EventRecordCreated,
+		TenantID: record.TenantID,
+		Data:     map[string]interface{}{"id": record.ID, "type": record.Type, "status": record.Status},
+		Time:     record.CreatedAt,
+	}
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EnsureRecordUpdatedAt fills updated_at of the records stored before it existed with their creation time
+func EnsureRecordUpdatedAt(db *gorm.DB) error {
+	if err := db.Exec("UPDATE chatbot.interactions SET updated_at = created_at WHERE updated_at IS NULL").Error; err != nil {
+		return fmt.Errorf("failed to backfill record updated_at: %v", err)
+	}
+	return nil
+}
+
+// LastModified is when the record last changed, for Last-Modified headers
+func (d Data) LastModified() time.Time {
+	if d.UpdatedAt.After(d.CreatedAt) {
+		return d.UpdatedAt
+	}
+	return d.CreatedAt
+}
+
+// RecordsLastModified returns when any record of the tenant was last created, changed, archived or
+// deleted, so record lists can answer If-Modified-Since without loading a page. It is zero without records.
+func (s *GormDataService) RecordsLastModified() (time.Time, error) {
+	var modified *time.Time
+	err := s.db.Unscoped().Model(&Data{}).Scopes(s.tenantScope).
+		Select("GREATEST(MAX(created_at), MAX(updated_at), MAX(archived_at), MAX(deleted_at))").Scan(&modified).Error
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read record modification time: %v", err)
+	}
+	if modified == nil {
+		return time.Time{}, nil
+	}
+	return *modified, nil
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrRecordModified is returned when a details patch names a revision the record no longer has
+var ErrRecordModified = errors.New("record was modified since the given revision")
+
+// mergePatchFunction implements RFC 7386 on JSONB: objects merge key by key, null removes a key and
+// any other value replaces the target
+const mergePatchFunction = `CREATE OR REPLACE FUNCTION chatbot.jsonb_merge_patch(target jsonb, patch jsonb) RETURNS jsonb
+LANGUAGE plpgsql IMMUTABLE AS $$
+DECLARE
+	entry record;
+BEGIN
+	IF patch IS NULL OR jsonb_typeof(patch) <> 'object' THEN
+		RETURN patch;
+	END IF;
+	IF target IS NULL OR jsonb_typeof(target) <> 'object' THEN
+		target := '{}'::jsonb;
+	END IF;
+	FOR entry IN SELECT key, value FROM jsonb_each(patch) LOOP
+		IF jsonb_typeof(entry.value) = 'null' THEN
+			target := target - entry.key;
+		ELSE
+			target := jsonb_set(target, ARRAY[entry.key], chatbot.jsonb_merge_patch(target -> entry.key, entry.value));
+		END IF;
+	END LOOP;
+	RETURN target;
+END
+$$`
+
+// EnsureMergePatchFunction creates the chatbot.jsonb_merge_patch function PatchRecordDetails relies on
+func EnsureMergePatchFunction(db *gorm.DB) error {
+	if err := db.Exec(mergePatchFunction).Error; err != nil {
+		return fmt.Errorf("failed to create merge patch function: %v", err)
+	}
+	return nil
+}
+
+// PatchRecordDetails applies an RFC 7386 merge patch to the details of a record in the database, without
+// reading and rewriting the whole document. A non-zero revision must be the current revision of the record,
+// else ErrRecordModified. Protected fields of the patch are encrypted first and the merged details must
+// still fit the details limits and match the schema of the record type.
+func (s *GormDataService) PatchRecordDetails(id uint, patch []byte, revision int) (Data, error) {
+	doc, ok := decodeDetails(patch)
+	if !ok {
+		return Data{}, fmt.Errorf("%w: a details patch must be a JSON object", ErrValidation)
+	}
+	if err := s.limits.Check(patch); err != nil {
+		return Data{}, err
+	}
+
+	var record Data
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		locked, err := s.lockRecord(tx, id)
+		if err != nil {
+			return err
+		}
+		if IsImmutableType(locked.Type) {
+			return fmt.Errorf("%w: record %d is a %s", ErrImmutableRecord, id, locked.Type)
+		}
+		if revision != 0 && locked.Revision != revision {
+			return fmt.Errorf("%w: record %d is at revision %d, not %d", ErrRecordModified, id, locked.Revision, revision)
+		}
+
+		hashes := "{}"
+		if PII != nil {
+			sealed, sealedHashes, err := PII.Seal(locked.TenantID, patch)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt details patch: %v", err)
+			}
+			patch = sealed
+			if sealedHashes != nil {
+				hashes = string(sealedHashes)
+			}
+		}
+		piiHashes := "COALESCE(pii_hashes, '{}'::jsonb) || ?::jsonb"
+		args := []interface{}{hashes}
+		for _, path := range removedPIIPaths(doc) {
+			piiHashes += " - ?::text"
+			args = append(args, path)
+		}
+		err = tx.Model(&Data{}).Where("id = ?", locked.ID).UpdateColumns(map[string]interface{}{
+			"details":    gorm.Expr("chatbot.jsonb_merge_patch(details, ?::jsonb)", string(patch)),
+			"pii_hashes": gorm.Expr(piiHashes, args...),
+			"revision":   gorm.Expr("revision + 1"),
+			"updated_at": time.Now(),
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to patch record %d: %v", id, err)
+		}
+
+		if err := tx.First(&record, locked.ID).Error; err != nil {
+			return fmt.Errorf("failed to reload record %d: %v", id, err)
+		}
+		// The patch may be small and still grow the details past the limits
+		if err := s.limits.Check(record.Details); err != nil {
+			return err
+		}
+		if s.validator != nil {
+			schemaVersion, err := s.validator.ValidateRecord(record.Type, record.Details)
+			if err != nil {
+				return err
+			}
+			if schemaVersion != record.SchemaVersion {
+				if err := tx.Model(&Data{}).Where("id = ?", record.ID).UpdateColumn("schema_version", schemaVersion).Error; err != nil {
+					return fmt.Errorf("failed to update schema version: %v", err)
+				}
+				record.SchemaVersion = schemaVersion
+			}
+		}
+		return WriteOutbox(tx, recordUpdatedEvent(record, doc))
+	})
+	if err != nil {
+		return Data{}, err
+	}
+	Events.Publish(recordUpdatedEvent(record, doc))
+	return record, nil
+}
+
+// removedPIIPaths lists the protected fields a patch sets to null, whose lookup hashes must go too
+func removedPIIPaths(doc map[string]interface{}) []string {
+	if PII == nil {
+		return nil
+	}
+	var removed []string
+	for _, field := range PII.fields {
+		if _, _, value, found := lookupPath(doc, field); found && value == nil {
+			removed = append(removed, strings.Join(field, "."))
+		}
+	}
+	return removed
+}
+
+// recordUpdatedEvent names the top-level fields a patch touched, never their values
+func recordUpdatedEvent(record Data, doc map[string]interface{}) Event {
+	fields := make([]string, 0, len(doc))
+	for key := range doc {
+		fields = append(fields, key)
+	}
+	sort.Strings(fields)
+	return Event{
+		Type:     EventRecordUpdated,
+		TenantID: record.TenantID,
+		Data:     map[string]interface{}{"id": record.ID, "type": record.Type, "revision": record.Revision, "fields": fields},
+		Time:     time.Now(),
+	}
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRemovedPIIPaths(t *testing.T) {
+	previous := PII
+	PII = testPIIProtector(t)
+	defer func() { PII = previous }()
+
+	doc, _ := decodeDetails([]byte(`{"phone": null, "name": "Amira", "customer": {"address": null}, "note": null}`))
+	if got, want := removedPIIPaths(doc), []string{"phone", "customer.address"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRecordUpdatedEventOmitsValues(t *testing.T) {
+	doc, _ := decodeDetails([]byte(`{"phone": "+21674000000", "address": {"city": "Sfax"}}`))
+	event := recordUpdatedEvent(Data{ID: 4, Type: "address", Revision: 3}, doc)
+	if event.Type != EventRecordUpdated || !reflect.DeepEqual(event.Data["fields"], []string{"address", "phone"}) || event.Data["revision"] != 3 {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// schemaCacheTTL bounds how long another instance may validate against a replaced active schema
+const schemaCacheTTL = time.Minute
+
+// ErrSchemaViolation rejects record details that do not match the active schema of their type
+var ErrSchemaViolation = errors.New("record does not match its schema")
+
+// RecordSchema is one version of the JSON Schema that details of a record type must match
+type RecordSchema struct {
+	ID          uint           `gorm:"primaryKey" json:"id"`
+	Type        string         `gorm:"not null;uniqueIndex:idx_record_schemas_type_version" json:"type"`
+	Version     int            `gorm:"not null;uniqueIndex:idx_record_schemas_type_version" json:"version"`
+	Schema      datatypes.JSON `gorm:"not null" json:"schema"`
+	Description string         `json:"description,omitempty"`
+	// Active marks the version new records are validated against; at most one per type
+	Active      bool       `gorm:"not null;default:false" json:"active"`
+	CreatedBy   string     `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`
+}
+
+// TableName specifies the table name for RecordSchema
+func (RecordSchema) TableName() string {
+	return "chatbot.record_schemas"
+}
+
+// SchemaViolationError lists why record details failed validation
+type SchemaViolationError struct {
+	Type       string   `json:"type"`
+	Version    int      `json:"schema_version"`
+	Violations []string `json:"violations"`
+}
+
+func (e *SchemaViolationError) Error() string {
+	return fmt.Sprintf("%v: %s v%d: %s", ErrSchemaViolation, e.Type, e.Version, strings.Join(e.Violations, "; "))
+}
+
+func (e *SchemaViolationError) Unwrap() error {
+	return ErrSchemaViolation
+}
+
+// RecordValidator checks the marshalled details of a new record and returns the schema
+// version they were validated against, 0 when the type has no active schema
+type RecordValidator interface {
+	ValidateRecord(recordType string, details []byte) (int, error)
+}
+
+// RecordSchemaService defines the interface for the record schema registry
+type RecordSchemaService interface {
+	RecordValidator
+	// RegisterSchema stores the next version of a type's schema, optionally making it the active one
+	RegisterSchema(recordType string, schema json.RawMessage, description, actor string, activate bool) (RecordSchema, error)
+	ActivateSchema(recordType string, version int) (RecordSchema, error)
+	// DeactivateSchema stops validating new records of a type
+	DeactivateSchema(recordType string) error
+	GetSchema(recordType string, version int) (RecordSchema, error)
+	// ActiveSchema returns the active version of a type, or an error when there is none
+	ActiveSchema(recordType string) (RecordSchema, error)
+	ListSchemas(recordType string) ([]RecordSchema, error)
+}
+
+// GormRecordSchemaService implements RecordSchemaService using GORM
+type GormRecordSchemaService struct {
+	db    *gorm.DB
+	cache *ttlCache
+}
+
+// NewGormRecordSchemaService creates a new GormRecordSchemaService
+func NewGormRecordSchemaService(db *gorm.DB) RecordSchemaService {
+	cache := newTTLCache(schemaCacheTTL)
+	Caches.Register(CacheSchemas, cache)
+	return &GormRecordSchemaService{db: db, cache: cache}
+}
+
+// activeSchema is the cached validator of a type; a nil schema means no validation
+type activeSchema struct {
+	version int
+	schema  *JSONSchema
+}
+
+// ValidateRecord validates details against the active schema of recordType
+func (s *GormRecordSchemaService) ValidateRecord(recordType string, details []byte) (int, error) {
+	active, err := s.active(recordType)
+	if err != nil || active.schema == nil {
+		return 0, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(details, &value); err != nil {
+		return 0, fmt.Errorf("failed to read details: %v", err)
+	}
+	if violations := active.schema.Validate(value); len(violations) > 0 {
+		return 0, &SchemaViolationError{Type: recordType, Version: active.version, Violations: violations}
+	}
+	return active.version, nil
+}
+
+// active loads the compiled active schema of a type through the cache
+func (s *GormRecordSchemaService) active(recordType string) (activeSchema, error) {
+	if cached, ok := s.cache.Get(recordType); ok {
+		return cached.(activeSchema), nil
+	}
+	var loaded activeSchema
+	record, err := s.ActiveSchema(recordType)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+	case err != nil:
+		return activeSchema{}, err
+	default:
+		compiled, err := CompileJSONSchema(record.Schema)
+		if err != nil {
+			return activeSchema{}, fmt.Errorf("active schema %s v%d is invalid: %v", recordType, record.Version, err)
+		}
+		loaded = activeSchema{version: record.Version, schema: compiled}
+	}
+	s.cache.Set(recordType, loaded)
+	return loaded, nil
+}
+
+// RegisterSchema stores a schema as the next version of its type
+func (s *GormRecordSchemaService) RegisterSchema(recordType string, schema json.RawMessage, description, actor string, activate bool) (RecordSchema, error) {
+	if strings.TrimSpace(recordType) == "" {
+		return RecordSchema{}, fmt.Errorf("type is required")
+	}
+	if _, err := CompileJSONSchema(schema); err != nil {
+		return RecordSchema{}, fmt.Errorf("invalid schema: %v", err)
+	}
+	record := RecordSchema{Type: recordType, Schema: datatypes.JSON(schema), Description: description, CreatedBy: actor}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var latest int
+		if err := tx.Model(&RecordSchema{}).Where("type = ?", recordType).
+			Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+			return err
+		}
+		record.Version = latest + 1
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		if activate {
+			return activateSchema(tx, &record)
+		}
+		return nil
+	})
+	if err != nil {
+		return RecordSchema{}, fmt.Errorf("failed to register schema: %v", err)
+	}
+	s.cache.DeletePrefix(recordType)
+	return record, nil
+}
+
+// activateSchema makes record the only active version of its type
+func activateSchema(tx *gorm.DB, record *RecordSchema) error {
+	if err := tx.Model(&RecordSchema{}).Where("type = ? AND active", record.Type).Update("active", false).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := tx.Model(record).Updates(map[string]interface{}{"active": true, "activated_at": now}).Error; err != nil {
+		return err
+	}
+	record.Active = true
+	record.ActivatedAt = &now
+	return nil
+}
+
+// ActivateSchema switches validation of a type to version, e.g. to roll back
+func (s *GormRecordSchemaService) ActivateSchema(recordType string, version int) (RecordSchema, error) {
+	record, err := s.GetSchema(recordType, version)
+	if err != nil {
+		return RecordSchema{}, err
+	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error { return activateSchema(tx, &record) }); err != nil {
+		return RecordSchema{}, fmt.Errorf("failed to activate schema: %v", err)
+	}
+	s.cache.DeletePrefix(recordType)
+	return record, nil
+}
+
+// DeactivateSchema leaves a type without an active schema
+func (s *GormRecordSchemaService) DeactivateSchema(recordType string) error {
+	if err := s.db.Model(&RecordSchema{}).Where("type = ? AND active", recordType).Update("active", false).Error; err != nil {
+		return fmt.Errorf("failed to deactivate schema: %v", err)
+	}
+	s.cache.DeletePrefix(recordType)
+	return nil
+}
+
+// GetSchema fetches one version of a type's schema
+func (s *GormRecordSchemaService) GetSchema(recordType string, version int) (RecordSchema, error) {
+	var record RecordSchema
+	if err := s.db.Where("type = ? AND version = ?", recordType, version).First(&record).Error; err != nil {
+		return RecordSchema{}, fmt.Errorf("schema %s v%d not found: %w", recordType, version, err)
+	}
+	return record, nil
+}
+
+// ActiveSchema fetches the active version of a type's schema
+func (s *GormRecordSchemaService) ActiveSchema(recordType string) (RecordSchema, error) {
+	var record RecordSchema
+	if err := s.db.Where("type = ? AND active", recordType).First(&record).Error; err != nil {
+		return RecordSchema{}, fmt.Errorf("no active schema for %s: %w", recordType, err)
+	}
+	return record, nil
+}
+
+// ListSchemas fetches every version, of one type when recordType is set, ordered by type and version
+func (s *GormRecordSchemaService) ListSchemas(recordType string) ([]RecordSchema, error) {
+	query := s.db.Order("type, version")
+	if recordType != "" {
+		query = query.Where("type = ?", recordType)
+	}
+	var records []RecordSchema
+	if err := query.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch schemas: %v", err)
+	}
+	return records, nil
+}
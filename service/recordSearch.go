@@ -0,0 +1,131 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// ErrEmptySearch is returned for search queries without a searchable word
+var ErrEmptySearch = errors.New("search query has no searchable words")
+
+// recordSearchVector is the text search document of a record: its type, status and every string and
+// number in the details. The "simple" configuration does no stemming, since records mix Arabic, French
+// and English; prefix matching in the query covers plurals and conjugations instead. The expression
+// must stay identical to the one of the index created by EnsureRecordSearchIndex.
+const recordSearchVector = `(to_tsvector('simple', coalesce(type, '') || ' ' || coalesce(status, '')) || ` +
+	`jsonb_to_tsvector('simple', coalesce(details, '{}'::jsonb), '["string", "numeric"]'))`
+
+// recordSearchText is the text highlighted in results: the top-level detail values
+const recordSearchText = `coalesce(CASE WHEN jsonb_typeof(details) = 'object' THEN ` +
+	`(SELECT string_agg(kv.value, ' … ') FROM jsonb_each_text(details) AS kv) END, '')`
+
+// recordHeadlineOptions marks matches with <mark> and keeps a couple of short fragments
+const recordHeadlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5, FragmentDelimiter=\" … \""
+
+// RecordMatch is a record found by a full-text search
+type RecordMatch struct {
+	Data
+	Rank float64 `json:"rank"`
+	// Highlight is an excerpt of the details with the matched words wrapped in <mark>
+	Highlight string `json:"highlight"`
+}
+
+// EnsureRecordSearchIndex creates the GIN index the full-text search relies on
+func EnsureRecordSearchIndex(db *gorm.DB) error {
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS interactions_search_idx ON chatbot.interactions USING GIN (" + recordSearchVector + ")").Error; err != nil {
+		return fmt.Errorf("failed to create record search index: %v", err)
+	}
+	return nil
+}
+
+// FullTextSearch ranks the records matching the filter by how well they match text, best first
+func (s *GormDataService) FullTextSearch(text string, filter RecordFilter, offset, limit int) ([]RecordMatch, int64, error) {
+	tsquery := searchTSQuery(text)
+	if tsquery == "" {
+		return nil, 0, ErrEmptySearch
+	}
+	filter.Text = ""
+	match := recordSearchVector + " @@ to_tsquery('simple', ?)"
+
+	var total int64
+	if err := s.recordQuery(filter).Where(match, tsquery).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count search results: %v", err)
+	}
+	var matches []RecordMatch
+	err := s.recordQuery(filter).Where(match, tsquery).
+		Select("interactions.*, ts_rank_cd("+recordSearchVector+", to_tsquery('simple', ?), 32) AS rank, "+
+			"ts_headline('simple', "+recordSearchText+", to_tsquery('simple', ?), ?) AS highlight", tsquery, tsquery, recordHeadlineOptions).
+		Order("rank desc, created_at desc").Offset(offset).Limit(limit).Scan(&matches).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search records: %v", err)
+	}
+	// Scan skips the Data hooks; encrypted values never match nor show in highlights
+	for i := range matches {
+		if err := matches[i].openPII(); err != nil {
+			return nil, 0, err
+		}
+	}
+	return matches, total, nil
+}
+
+// searchTSQuery turns free text into a tsquery: any word may match, as a prefix, so records matching
+// more of the words rank first; "quoted phrases" must match in order and -word excludes a word
+func searchTSQuery(text string) string {
+	var any, excluded []string
+	seen := make(map[string]bool)
+	add := func(term string, exclude bool) {
+		if term == "" || seen[term] {
+			return
+		}
+		seen[term] = true
+		if exclude {
+			excluded = append(excluded, "!"+term)
+		} else {
+			any = append(any, term)
+		}
+	}
+
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			// Inside quotes: a phrase of exact words
+			words := searchWords(part)
+			switch len(words) {
+			case 0:
+			case 1:
+				add(words[0], false)
+			default:
+				add("("+strings.Join(words, " <-> ")+")", false)
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			exclude := strings.HasPrefix(field, "-")
+			for _, word := range searchWords(field) {
+				// A one-letter prefix matches nearly everything
+				if utf8.RuneCountInString(word) > 1 {
+					add(word+":*", exclude)
+				}
+			}
+		}
+	}
+	if len(any) == 0 {
+		return ""
+	}
+	query := strings.Join(any, " | ")
+	if len(excluded) > 0 {
+		query = "(" + query + ") & " + strings.Join(excluded, " & ")
+	}
+	return query
+}
+
+// searchWords splits text into lower-case words, dropping the punctuation tsquery would parse as operators
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
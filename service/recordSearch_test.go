@@ -0,0 +1,24 @@
+package service
+
+import "testing"
+
+func TestSearchTSQuery(t *testing.T) {
+	cases := []struct {
+		text, want string
+	}{
+		{"red blender", "red:* | blender:*"},
+		{"  Red, BLENDER! red ", "red:* | blender:*"},
+		{`complaint "red blender"`, "complaint:* | (red <-> blender)"},
+		{"blender -refund", "(blender:*) & !refund:*"},
+		{"a b", ""},
+		{"-refund", ""},
+		{"it's & | ! :*", "it:*"},
+		{"خلاط أحمر", "خلاط:* | أحمر:*"},
+		{`"" ""`, ""},
+	}
+	for _, c := range cases {
+		if got := searchTSQuery(c.text); got != c.want {
+			t.Errorf("searchTSQuery(%q) = %q, want %q", c.text, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Record statuses
+const (
+	StatusPending    = "pending"
+	StatusInProgress = "in_progress"
+	StatusCompleted  = "completed"
+	StatusCancelled  = "cancelled"
+)
+
+// ErrInvalidTransition is returned when a status change is not allowed by the workflow
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// statusTransitions lists the statuses reachable from each status
+var statusTransitions = map[string][]string{
+	StatusPending:    {StatusInProgress, StatusCancelled},
+	StatusInProgress: {StatusCompleted, StatusCancelled},
+	StatusCompleted:  {},
+	StatusCancelled:  {},
+}
+
+// IsWorkflowStatus reports whether status is one of the statuses of the workflow
+func IsWorkflowStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether a record may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, allowed := range statusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Outcomes of the records of a bulk status change
+const (
+	BulkStatusUpdated = "updated"
+	BulkStatusFailed  = "failed"
+	// BulkStatusRolledBack is a record that could move but did not, because another one failed
+	BulkStatusRolledBack = "rolled_back"
+)
+
+// MaxBulkStatusRecords bounds the records of one bulk status change
+const MaxBulkStatusRecords = 500
+
+// ErrBulkRolledBack is returned by a bulk status change in which a record failed; no record was changed
+var ErrBulkRolledBack = errors.New("bulk status change rolled back")
+
+// BulkStatusResult is the outcome of one record of a bulk status change
+type BulkStatusResult struct {
+	ID     uint   `json:"id"`
+	Result string `json:"result"`
+	// From is the status the record moved from
+	From  string `json:"from,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Record is the record as changed, or its new version for append-only records
+	Record *Data `json:"record,omitempty"`
+}
+
+// StatusChange records one transition of a record's status
+type StatusChange struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	RecordID   uint      `gorm:"not null;index;column:record_id" json:"record_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Actor      string    `json:"actor"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+// TableName specifies the table name for StatusChange
+func (StatusChange) TableName() string {
+	return "chatbot.record_status_history"
+}
+
+// UpdateRecordStatus moves a record to newStatus if the workflow allows it and logs the transition.
+// Append-only records keep the current row and return a new version carrying newStatus.
+func (s *GormDataService) UpdateRecordStatus(id uint, newStatus, actor string) (Data, error) {
+	var record Data
+	var changed Event
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		record, changed, err = s.changeStatus(tx, id, "", newStatus, actor)
+		return err
+	})
+	if err != nil {
+		return Data{}, err
+	}
+	Events.Publish(changed)
+	return record, nil
+}
+
+// changeStatus applies one status change within tx, returning the record as changed and the event to
+// publish once tx commits. A non-empty recordType rejects records of another type with ErrValidation.
+func (s *GormDataService) changeStatus(tx *gorm.DB, id uint, recordType, newStatus, actor string) (Data, Event, error) {
+	record, err := s.lockRecord(tx, id)
+	if err != nil {
+		return Data{}, Event{}, err
+	}
+	if recordType != "" && record.Type != recordType {
+		return Data{}, Event{}, fmt.Errorf("%w: record %d is of type %s, not %s", ErrValidation, id, record.Type, recordType)
+	}
+	if !CanTransition(record.Status, newStatus) {
+		return Data{}, Event{}, fmt.Errorf("%w: %q -> %q", ErrInvalidTransition, record.Status, newStatus)
+	}
+	changed := Event{
+		Type:     EventRecordStatusChanged,
+		TenantID: record.TenantID,
+		Data:     map[string]interface{}{"id": record.ID, "type": record.Type, "from": record.Status, "to": newStatus},
+	}
+	if IsImmutableType(record.Type) {
+		if record, err = s.appendStatusVersion(tx, record, newStatus, actor); err != nil {
+			return Data{}, Event{}, err
+		}
+		changed.Data["id"] = record.ID
+		return record, changed, WriteOutbox(tx, changed)
+	}
+
+	change := StatusChange{
+		RecordID:   record.ID,
+		FromStatus: record.Status,
+		ToStatus:   newStatus,
+		Actor:      actor,
+		ChangedAt:  time.Now(),
+	}
+	if err := tx.Model(&record).Update("status", newStatus).Error; err != nil {
+		return Data{}, Event{}, fmt.Errorf("failed to update status: %v", err)
+	}
+	if err := tx.Create(&change).Error; err != nil {
+		return Data{}, Event{}, fmt.Errorf("failed to record status history: %v", err)
+	}
+	return record, changed, WriteOutbox(tx, changed)
+}
+
+// BulkUpdateStatus moves every record of ids to newStatus in one transaction. When a record cannot
+// move, because it is missing, of another type than a non-empty recordType or the workflow forbids
+// it, none does: the results tell which failed and the error wraps ErrBulkRolledBack.
+func (s *GormDataService) BulkUpdateStatus(ids []uint, recordType, newStatus, actor string) ([]BulkStatusResult, error) {
+	ids, err := bulkStatusIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BulkStatusResult, len(ids))
+	position := make(map[uint]int, len(ids))
+	for i, id := range ids {
+		results[i] = BulkStatusResult{ID: id}
+		position[id] = i
+	}
+	// Records are locked in ID order so that overlapping batches cannot deadlock
+	ordered := append([]uint(nil), ids...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i] < ordered[j] })
+
+	var changed []Event
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		failed := 0
+		for _, id := range ordered {
+			result := &results[position[id]]
+			record, event, err := s.changeStatus(tx, id, recordType, newStatus, actor)
+			if err != nil {
+				if !isBulkItemError(err) {
+					return err
+				}
+				result.Result, result.Error = BulkStatusFailed, err.Error()
+				failed++
+				continue
+			}
+			result.Result, result.From, result.Record = BulkStatusUpdated, event.Data["from"].(string), &record
+			changed = append(changed, event)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%w: %d of %d records cannot move to %s", ErrBulkRolledBack, failed, len(ids), newStatus)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrBulkRolledBack) {
+		for i := range results {
+			if results[i].Result == BulkStatusUpdated {
+				results[i].Result, results[i].Record = BulkStatusRolledBack, nil
+			}
+		}
+		return results, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range changed {
+		Events.Publish(event)
+	}
+	return results, nil
+}
+
+// bulkStatusIDs drops repeated IDs, keeping the first, and bounds the size of a batch
+func bulkStatusIDs(ids []uint) ([]uint, error) {
+	unique := make([]uint, 0, len(ids))
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) == 0 || len(unique) > MaxBulkStatusRecords {
+		return nil, fmt.Errorf("%w: a bulk status change takes 1 to %d records, got %d", ErrValidation, MaxBulkStatusRecords, len(unique))
+	}
+	return unique, nil
+}
+
+// isBulkItemError reports whether err concerns one record of a batch rather than the database
+func isBulkItemError(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrValidation) ||
+		errors.Is(err, ErrInvalidTransition) || errors.Is(err, ErrRecordSuperseded)
+}
+
+// RecordHistory returns the status transitions of a record, oldest first
+func (s *GormDataService) RecordHistory(id uint) ([]StatusChange, error) {
+	if s.tenant != nil {
+		if _, err := s.QueryByID(id); err != nil {
+			return nil, err
+		}
+	}
+	var history []StatusChange
+	if err := s.db.Where("record_id = ?", id).Order("changed_at, id").Find(&history).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch status history: %v", err)
+	}
+	return history, nil
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     bool
+	}{
+		{StatusPending, StatusInProgress, true},
+		{StatusPending, StatusCancelled, true},
+		{StatusInProgress, StatusCompleted, true},
+		{StatusInProgress, StatusCancelled, true},
+		{StatusPending, StatusCompleted, false},
+		{StatusCompleted, StatusPending, false},
+		{StatusCancelled, StatusInProgress, false},
+		{"unknown", StatusPending, false},
+	}
+	for _, c := range cases {
+		if got := CanTransition(c.from, c.to); got != c.want {
+			t.Errorf("CanTransition(%q, %q) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestBulkStatusIDs(t *testing.T) {
+	ids, err := bulkStatusIDs([]uint{18, 12, 18, 15, 12})
+	if err != nil || !reflect.DeepEqual(ids, []uint{18, 12, 15}) {
+		t.Errorf("bulkStatusIDs = %v, %v", ids, err)
+	}
+	if _, err := bulkStatusIDs(nil); !errors.Is(err, ErrValidation) {
+		t.Errorf("empty batch: %v", err)
+	}
+	if _, err := bulkStatusIDs(make([]uint, MaxBulkStatusRecords+1)); err != nil {
+		t.Errorf("repeated IDs count once: %v", err)
+	}
+	large := make([]uint, MaxBulkStatusRecords+1)
+	for i := range large {
+		large[i] = uint(i + 1)
+	}
+	if _, err := bulkStatusIDs(large); !errors.Is(err, ErrValidation) {
+		t.Errorf("oversized batch: %v", err)
+	}
+}
+
+func TestIsBulkItemError(t *testing.T) {
+	for _, err := range []error{recordLookupError(4, gorm.ErrRecordNotFound), ErrInvalidTransition, ErrRecordSuperseded, ErrValidation} {
+		if !isBulkItemError(err) {
+			t.Errorf("%v should fail only its record", err)
+		}
+	}
+	if isBulkItemError(errors.New("connection reset")) {
+		t.Error("a database error should abort the batch")
+	}
+}
+
+func TestNewRecordStartsInTheWorkflow(t *testing.T) {
+	intake := newRecordIntake(DataServiceOptions{})
+	record, err := intake.newRecord(1, 1, "issue", nil, "")
+	if err != nil || record.Status != StatusPending {
+		t.Errorf("no status: got %q, %v, want %q", record.Status, err, StatusPending)
+	}
+	if record, err := intake.newRecord(1, 1, "issue", nil, StatusInProgress); err != nil || record.Status != StatusInProgress {
+		t.Errorf("workflow status: got %q, %v", record.Status, err)
+	}
+	if _, err := intake.newRecord(1, 1, "issue", nil, "bogus"); !errors.Is(err, ErrValidation) {
+		t.Errorf("unknown status: got %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrImmutableRecord is returned when an append-only record would be archived, restored or deleted
+var ErrImmutableRecord = errors.New("record type is append-only")
+
+// ErrRecordSuperseded is returned when a change targets a version that is no longer the current one
+var ErrRecordSuperseded = errors.New("record has a newer version")
+
+// immutableTypes holds the append-only record types (consent, erasure requests...)
+var immutableTypes = struct {
+	sync.RWMutex
+	set map[string]bool
+}{set: map[string]bool{}}
+
+// SetImmutableTypes replaces the append-only record types; updates of such records create new versions
+func SetImmutableTypes(types []string) {
+	set := map[string]bool{}
+	for _, t := range types {
+		if t = strings.TrimSpace(t); t != "" {
+			set[t] = true
+		}
+	}
+	immutableTypes.Lock()
+	immutableTypes.set = set
+	immutableTypes.Unlock()
+}
+
+// IsImmutableType reports whether records of dataType are append-only
+func IsImmutableType(dataType string) bool {
+	immutableTypes.RLock()
+	defer immutableTypes.RUnlock()
+	return immutableTypes.set[dataType]
+}
+
+// immutableTypeList returns the append-only record types
+func immutableTypeList() []string {
+	immutableTypes.RLock()
+	defer immutableTypes.RUnlock()
+	types := make([]string, 0, len(immutableTypes.set))
+	for t := range immutableTypes.set {
+		types = append(types, t)
+	}
+	return types
+}
+
+// currentVersions hides records that a newer version supersedes
+func currentVersions(db *gorm.DB) *gorm.DB {
+	return db.Where("NOT EXISTS (SELECT 1 FROM chatbot.interactions AS newer WHERE newer.previous_id = interactions.id)")
+}
+
+// lineage returns the ID of the first version of record
+func (record Data) lineage() uint {
+	if record.LineageID != 0 {
+		return record.LineageID
+	}
+	return record.ID
+}
+
+// nextVersion copies record into a new, unsaved version with the given status
+func (record Data) nextVersion(status string) Data {
+	next := record
+	next.ID = 0
+	next.Status = status
+	next.Version = record.Version + 1
+	if record.Version == 0 {
+		next.Version = 2
+	}
+	next.PreviousID = &record.ID
+	next.LineageID = record.lineage()
+	next.CreatedAt = time.Now()
+	next.ArchivedAt = nil
+	next.DeletedAt = gorm.DeletedAt{}
+	return next
+}
+
+// appendStatusVersion records a status change of an append-only record as a new version; the caller validated the transition
+func (s *GormDataService) appendStatusVersion(tx *gorm.DB, record Data, newStatus, actor string) (Data, error) {
+	var newer int64
+	if err := tx.Model(&Data{}).Where("previous_id = ?", record.ID).Count(&newer).Error; err != nil {
+		return Data{}, fmt.Errorf("failed to check record versions: %v", err)
+	}
+	if newer > 0 {
+		return Data{}, fmt.Errorf("%w: record %d", ErrRecordSuperseded, record.ID)
+	}
+	next := record.nextVersion(newStatus)
+	if err := tx.Create(&next).Error; err != nil {
+		// The unique previous_id index rejects a concurrent second successor
+		return Data{}, fmt.Errorf("failed to create record version: %v", err)
+	}
+	change := StatusChange{
+		RecordID:   next.ID,
+		FromStatus: record.Status,
+		ToStatus:   newStatus,
+		Actor:      actor,
+		ChangedAt:  next.CreatedAt,
+	}
+	if err := tx.Create(&change).Error; err != nil {
+		return Data{}, fmt.Errorf("failed to record status history: %v", err)
+	}
+	return next, nil
+}
+
+// RecordVersions returns every version of the record id belongs to, oldest first
+func (s *GormDataService) RecordVersions(id uint) ([]Data, error) {
+	record, err := s.QueryByID(id)
+	if err != nil {
+		return nil, err
+	}
+	root := record.lineage()
+	var versions []Data
+	if err := s.db.Scopes(s.tenantScope).Where("id = ? OR lineage_id = ?", root, root).
+		Order("version, id").Find(&versions).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch record versions: %v", err)
+	}
+	return versions, nil
+}
+
+// lockRecord loads a record for update within tx
+func (s *GormDataService) lockRecord(tx *gorm.DB, id uint) (Data, error) {
+	var record Data
+	if err := tx.Scopes(s.tenantScope).Clauses(clause.Locking{Strength: "UPDATE"}).First(&record, id).Error; err != nil {
+		return Data{}, recordLookupError(id, err)
+	}
+	return record, nil
+}
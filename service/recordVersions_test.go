@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+func TestImmutableTypes(t *testing.T) {
+	SetImmutableTypes([]string{"consent", " erasure_request ", ""})
+	defer SetImmutableTypes(nil)
+
+	if !IsImmutableType("consent") || !IsImmutableType("erasure_request") {
+		t.Fatal("configured types must be append-only")
+	}
+	if IsImmutableType("issue") || IsImmutableType("") {
+		t.Fatal("other types must stay mutable")
+	}
+	SetImmutableTypes(nil)
+	if IsImmutableType("consent") {
+		t.Fatal("clearing the list must make every type mutable")
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	archived := time.Now()
+	first := Data{ID: 7, TenantID: 2, UserID: 9, Type: "consent", Details: datatypes.JSON(`{"marketing":true}`),
+		Status: StatusPending, Version: 1, ArchivedAt: &archived}
+
+	second := first.nextVersion(StatusInProgress)
+	if second.ID != 0 || second.Version != 2 || second.LineageID != 7 || second.PreviousID == nil || *second.PreviousID != 7 {
+		t.Fatalf("unexpected second version: %+v", second)
+	}
+	if second.Status != StatusInProgress || first.Status != StatusPending {
+		t.Fatal("only the new version carries the new status")
+	}
+	if second.TenantID != 2 || second.UserID != 9 || string(second.Details) != `{"marketing":true}` || second.ArchivedAt != nil {
+		t.Fatalf("new version must copy the record contents: %+v", second)
+	}
+
+	second.ID = 8
+	third := second.nextVersion(StatusCompleted)
+	if third.Version != 3 || third.LineageID != 7 || *third.PreviousID != 8 {
+		t.Fatalf("unexpected third version: %+v", third)
+	}
+
+	// Rows created before versioning have no version number yet
+	if legacy := (Data{ID: 3}).nextVersion(StatusCancelled); legacy.Version != 2 || legacy.LineageID != 3 {
+		t.Fatalf("unexpected version of a legacy record: %+v", legacy)
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportFile is a rendered report ready to be delivered
+type ReportFile struct {
+	Name        string
+	ContentType string
+	Body        []byte
+	Rows        int
+}
+
+// SMTPConfig is the mail server scheduled reports are sent through
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// slackPreviewSize bounds the CSV excerpt posted to Slack, which takes no file uploads through webhooks
+const slackPreviewSize = 2500
+
+// ReportDelivery sends rendered reports to the destination of their schedule
+type ReportDelivery struct {
+	SMTP   SMTPConfig
+	client *http.Client
+}
+
+// NewReportDelivery creates a new ReportDelivery
+func NewReportDelivery(config SMTPConfig) *ReportDelivery {
+	return &ReportDelivery{SMTP: config, client: NewHTTPClient(30 * time.Second)}
+}
+
+// Deliver sends file to the schedule's destination
+func (d *ReportDelivery) Deliver(schedule ReportSchedule, file ReportFile) error {
+	switch schedule.Destination {
+	case DeliverByEmail:
+		return d.email(schedule, file)
+	case DeliverToSlack:
+		return d.postJSON(schedule.Target, slackReportMessage(schedule, file))
+	case DeliverToSheet:
+		rows, err := csv.NewReader(bytes.NewReader(file.Body)).ReadAll()
+		if err != nil {
+			return fmt.Errorf("failed to read report rows: %v", err)
+		}
+		return d.postJSON(schedule.Target, map[string]interface{}{
+			"schedule": schedule.Name,
+			"report":   schedule.Report,
+			"file":     file.Name,
+			"rows":     rows,
+		})
+	default:
+		return fmt.Errorf("unknown destination %q", schedule.Destination)
+	}
+}
+
+// slackReportMessage summarizes a report in a Slack incoming webhook message, with the start of CSV reports inline
+func slackReportMessage(schedule ReportSchedule, file ReportFile) map[string]string {
+	text := fmt.Sprintf("*%s*: %s (%d rows)", schedule.Name, file.Name, file.Rows)
+	if strings.HasPrefix(file.ContentType, "text/csv") {
+		preview := string(file.Body)
+		if len(preview) > slackPreviewSize {
+			cut := strings.LastIndexByte(preview[:slackPreviewSize], '\n')
+			if cut < 0 {
+				cut = slackPreviewSize
+			}
+			preview = preview[:cut] + "\n…"
+		}
+		text += "\n```\n" + preview + "\n```"
+	}
+	return map[string]string{"text": text}
+}
+
+// postJSON posts body to a webhook
+func (d *ReportDelivery) postJSON(target string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %v", err)
+	}
+	resp, err := d.client.Post(target, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to post report: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("report webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// email sends the report as an attachment to the comma-separated target addresses
+func (d *ReportDelivery) email(schedule ReportSchedule, file ReportFile) error {
+	if d.SMTP.Host == "" || d.SMTP.From == "" {
+		return fmt.Errorf("email delivery requires SMTP_HOST and SMTP_FROM")
+	}
+	var recipients []string
+	for _, address := range strings.Split(schedule.Target, ",") {
+		recipients = append(recipients, strings.TrimSpace(address))
+	}
+	message, err := reportEmail(d.SMTP.From, recipients, schedule, file)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if d.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", d.SMTP.Username, d.SMTP.Password, d.SMTP.Host)
+	}
+	addr := net.JoinHostPort(d.SMTP.Host, strconv.Itoa(d.SMTP.Port))
+	if err := smtp.SendMail(addr, auth, d.SMTP.From, recipients, message); err != nil {
+		return fmt.Errorf("failed to send report email: %v", err)
+	}
+	return nil
+}
+
+// reportEmail builds a multipart message with a short text body and the report attached
+func reportEmail(from string, to []string, schedule ReportSchedule, file ReportFile) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", schedule.Name))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	text, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(text, "%s: %d rows, see the attached %s.\r\n", schedule.Name, file.Rows, file.Name)
+
+	attachment, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {file.ContentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": file.Name})},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(file.Body)
+	for len(encoded) > 76 {
+		fmt.Fprintf(attachment, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(attachment, "%s\r\n", encoded)
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
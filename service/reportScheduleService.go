@@ -0,0 +1,303 @@
+package service
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Reports a schedule can deliver
+const (
+	ScheduledOrdersReport = "orders"
+	ScheduledTaxReport    = "tax"
+)
+
+// Destinations of scheduled reports
+const (
+	DeliverByEmail = "email"
+	DeliverToSlack = "slack"
+	DeliverToSheet = "sheet"
+)
+
+// ReportFilters narrows a scheduled report
+type ReportFilters struct {
+	// Status keeps orders in this status (orders report)
+	Status string `json:"status,omitempty"`
+	// Days is how many days before the run the orders report covers; zero means 7
+	Days int `json:"days,omitempty"`
+	// Category keeps invoices with items of this category (tax report)
+	Category string `json:"category,omitempty"`
+	// Currency is the reporting currency (tax report); empty uses REPORTING_CURRENCY
+	Currency string `json:"currency,omitempty"`
+	// CurrentQuarter reports the running quarter instead of the last completed one (tax report)
+	CurrentQuarter bool `json:"current_quarter,omitempty"`
+}
+
+// ReportSchedule is an operator-defined report delivered on a cron schedule
+type ReportSchedule struct {
+	ID       uint          `gorm:"primaryKey" json:"id"`
+	TenantID uint          `gorm:"index;not null" json:"tenant_id"`
+	Name     string        `gorm:"not null" json:"name"`
+	Report   string        `gorm:"not null" json:"report"`
+	Filters  ReportFilters `gorm:"serializer:json" json:"filters"`
+	Format   string        `gorm:"not null" json:"format"`
+	// Destination is email, slack or sheet; Target is the address or webhook URL
+	Destination string `gorm:"not null" json:"destination"`
+	Target      string `gorm:"not null" json:"target"`
+	Cron        string `gorm:"not null" json:"cron"`
+	// Timezone is the IANA zone the cron expression is read in; empty means the server zone
+	Timezone string `json:"timezone,omitempty"`
+	// TokenUserID picks the Converty token reports are fetched with; empty uses the tenant's
+	TokenUserID string     `json:"token_user_id,omitempty"`
+	Disabled    bool       `json:"disabled"`
+	NextRunAt   *time.Time `gorm:"index" json:"next_run_at,omitempty"`
+	LastRunAt   *time.Time `json:"last_run_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedBy   string     `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for ReportSchedule
+func (ReportSchedule) TableName() string {
+	return "chatbot.report_schedules"
+}
+
+// NextRun returns when the schedule fires after t; it assumes a validated schedule
+func (s ReportSchedule) NextRun(t time.Time) (time.Time, error) {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	location := time.Local
+	if s.Timezone != "" {
+		if location, err = time.LoadLocation(s.Timezone); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timezone %q: %v", s.Timezone, err)
+		}
+	}
+	next := cron.Next(t.In(location))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never fires", s.Cron)
+	}
+	return next, nil
+}
+
+// ReportScheduleUpdate changes the fields of a schedule that are set
+type ReportScheduleUpdate struct {
+	Name        *string        `json:"name"`
+	Filters     *ReportFilters `json:"filters"`
+	Format      *string        `json:"format"`
+	Destination *string        `json:"destination"`
+	Target      *string        `json:"target"`
+	Cron        *string        `json:"cron"`
+	Timezone    *string        `json:"timezone"`
+	TokenUserID *string        `json:"token_user_id"`
+	Disabled    *bool          `json:"disabled"`
+}
+
+// ReportScheduleService defines the interface for scheduled report deliveries
+type ReportScheduleService interface {
+	CreateSchedule(schedule ReportSchedule, actor string) (ReportSchedule, error)
+	UpdateSchedule(tenantID, id uint, update ReportScheduleUpdate) (ReportSchedule, error)
+	DeleteSchedule(tenantID, id uint) error
+	GetSchedule(tenantID, id uint) (ReportSchedule, error)
+	ListSchedules(tenantID uint) ([]ReportSchedule, error)
+	// DueSchedules returns the enabled schedules of all tenants whose next run is at or before now
+	DueSchedules(now time.Time) ([]ReportSchedule, error)
+	// ClaimRun advances the next run of a due schedule; it reports false when another
+	// instance claimed the run first
+	ClaimRun(schedule ReportSchedule, now time.Time) (bool, error)
+	// RecordRun stores the outcome of a delivery
+	RecordRun(id uint, ranAt time.Time, runErr error) error
+}
+
+// GormReportScheduleService implements ReportScheduleService using GORM
+type GormReportScheduleService struct {
+	db *gorm.DB
+}
+
+// NewGormReportScheduleService creates a new GormReportScheduleService
+func NewGormReportScheduleService(db *gorm.DB) ReportScheduleService {
+	return &GormReportScheduleService{db: db}
+}
+
+// ValidateReportSchedule checks the fields an operator provides
+func ValidateReportSchedule(schedule ReportSchedule) error {
+	if strings.TrimSpace(schedule.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch schedule.Report {
+	case ScheduledOrdersReport:
+		if !ValidExportFormat(schedule.Format) {
+			return fmt.Errorf("unsupported format %q, expected csv or xlsx", schedule.Format)
+		}
+	case ScheduledTaxReport:
+		if schedule.Format != ExportCSV {
+			return fmt.Errorf("the tax report is only available as csv")
+		}
+	default:
+		return fmt.Errorf("unknown report %q, expected orders or tax", schedule.Report)
+	}
+	if schedule.Filters.Days < 0 {
+		return fmt.Errorf("filters.days must not be negative")
+	}
+	switch schedule.Destination {
+	case DeliverByEmail:
+		for _, address := range strings.Split(schedule.Target, ",") {
+			if _, err := mail.ParseAddress(strings.TrimSpace(address)); err != nil {
+				return fmt.Errorf("invalid email target %q: %v", address, err)
+			}
+		}
+	case DeliverToSlack, DeliverToSheet:
+		target, err := url.Parse(schedule.Target)
+		if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+			return fmt.Errorf("the %s target must be a webhook URL", schedule.Destination)
+		}
+		if schedule.Destination == DeliverToSheet && schedule.Format != ExportCSV {
+			return fmt.Errorf("sheet destinations take csv reports")
+		}
+	default:
+		return fmt.Errorf("unknown destination %q, expected email, slack or sheet", schedule.Destination)
+	}
+	_, err := schedule.NextRun(time.Now())
+	return err
+}
+
+// CreateSchedule stores a new schedule and computes its first run
+func (s *GormReportScheduleService) CreateSchedule(schedule ReportSchedule, actor string) (ReportSchedule, error) {
+	if schedule.Format == "" {
+		schedule.Format = ExportCSV
+	}
+	if err := ValidateReportSchedule(schedule); err != nil {
+		return ReportSchedule{}, err
+	}
+	schedule.ID = 0
+	schedule.CreatedBy = actor
+	schedule.LastRunAt = nil
+	schedule.LastError = ""
+	next, _ := schedule.NextRun(time.Now())
+	schedule.NextRunAt = &next
+	if err := s.db.Create(&schedule).Error; err != nil {
+		return ReportSchedule{}, fmt.Errorf("failed to create report schedule: %v", err)
+	}
+	return schedule, nil
+}
+
+// UpdateSchedule edits a schedule; its next run is recomputed from now
+func (s *GormReportScheduleService) UpdateSchedule(tenantID, id uint, update ReportScheduleUpdate) (ReportSchedule, error) {
+	schedule, err := s.GetSchedule(tenantID, id)
+	if err != nil {
+		return ReportSchedule{}, err
+	}
+	if update.Name != nil {
+		schedule.Name = *update.Name
+	}
+	if update.Filters != nil {
+		schedule.Filters = *update.Filters
+	}
+	if update.Format != nil {
+		schedule.Format = *update.Format
+	}
+	if update.Destination != nil {
+		schedule.Destination = *update.Destination
+	}
+	if update.Target != nil {
+		schedule.Target = *update.Target
+	}
+	if update.Cron != nil {
+		schedule.Cron = *update.Cron
+	}
+	if update.Timezone != nil {
+		schedule.Timezone = *update.Timezone
+	}
+	if update.TokenUserID != nil {
+		schedule.TokenUserID = *update.TokenUserID
+	}
+	if update.Disabled != nil {
+		schedule.Disabled = *update.Disabled
+	}
+	if err := ValidateReportSchedule(schedule); err != nil {
+		return ReportSchedule{}, err
+	}
+	next, _ := schedule.NextRun(time.Now())
+	schedule.NextRunAt = &next
+	if err := s.db.Save(&schedule).Error; err != nil {
+		return ReportSchedule{}, fmt.Errorf("failed to update report schedule: %v", err)
+	}
+	return schedule, nil
+}
+
+// DeleteSchedule removes a schedule
+func (s *GormReportScheduleService) DeleteSchedule(tenantID, id uint) error {
+	result := s.db.Where("tenant_id = ?", tenantID).Delete(&ReportSchedule{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete report schedule: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("report schedule %d not found", id)
+	}
+	return nil
+}
+
+// GetSchedule fetches a schedule of a tenant
+func (s *GormReportScheduleService) GetSchedule(tenantID, id uint) (ReportSchedule, error) {
+	var schedule ReportSchedule
+	if err := s.db.Where("tenant_id = ?", tenantID).First(&schedule, id).Error; err != nil {
+		return ReportSchedule{}, fmt.Errorf("report schedule %d not found: %v", id, err)
+	}
+	return schedule, nil
+}
+
+// ListSchedules fetches the schedules of a tenant ordered by name
+func (s *GormReportScheduleService) ListSchedules(tenantID uint) ([]ReportSchedule, error) {
+	var schedules []ReportSchedule
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("name, id").Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch report schedules: %v", err)
+	}
+	return schedules, nil
+}
+
+// DueSchedules returns the enabled schedules whose next run is at or before now
+func (s *GormReportScheduleService) DueSchedules(now time.Time) ([]ReportSchedule, error) {
+	var schedules []ReportSchedule
+	if err := s.db.Where("NOT disabled AND next_run_at <= ?", now).Order("next_run_at").Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch due report schedules: %v", err)
+	}
+	return schedules, nil
+}
+
+// ClaimRun moves next_run_at past now only if it still holds the value that was read,
+// so a run is claimed by exactly one instance; missed runs collapse into one
+func (s *GormReportScheduleService) ClaimRun(schedule ReportSchedule, now time.Time) (bool, error) {
+	if schedule.NextRunAt == nil {
+		return false, nil
+	}
+	next, err := schedule.NextRun(now)
+	if err != nil {
+		return false, err
+	}
+	result := s.db.Model(&ReportSchedule{}).
+		Where("id = ? AND next_run_at = ?", schedule.ID, *schedule.NextRunAt).
+		Update("next_run_at", next)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim report schedule %d: %v", schedule.ID, result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RecordRun stores when a schedule last ran and why it failed, if it did
+func (s *GormReportScheduleService) RecordRun(id uint, ranAt time.Time, runErr error) error {
+	lastError := ""
+	if runErr != nil {
+		lastError = runErr.Error()
+	}
+	if err := s.db.Model(&ReportSchedule{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"last_run_at": ranAt, "last_error": lastError}).Error; err != nil {
+		return fmt.Errorf("failed to record report run: %v", err)
+	}
+	return nil
+}
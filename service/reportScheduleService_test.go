@@ -0,0 +1,96 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateReportSchedule(t *testing.T) {
+	valid := ReportSchedule{Name: "Weekly orders", Report: ScheduledOrdersReport, Format: ExportXLSX,
+		Destination: DeliverByEmail, Target: "ops@example.com, owner@example.com", Cron: "0 8 * * 1"}
+	if err := ValidateReportSchedule(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := map[string]func(*ReportSchedule){
+		"name":          func(s *ReportSchedule) { s.Name = " " },
+		"report":        func(s *ReportSchedule) { s.Report = "sales" },
+		"format":        func(s *ReportSchedule) { s.Format = "pdf" },
+		"tax xlsx":      func(s *ReportSchedule) { s.Report = ScheduledTaxReport },
+		"email":         func(s *ReportSchedule) { s.Target = "ops@example.com, nobody" },
+		"slack url":     func(s *ReportSchedule) { s.Destination, s.Target = DeliverToSlack, "#ops" },
+		"sheet xlsx":    func(s *ReportSchedule) { s.Destination, s.Target = DeliverToSheet, "https://script.example.com/exec" },
+		"destination":   func(s *ReportSchedule) { s.Destination = "fax" },
+		"cron":          func(s *ReportSchedule) { s.Cron = "every monday" },
+		"timezone":      func(s *ReportSchedule) { s.Timezone = "Mars/Olympus" },
+		"negative days": func(s *ReportSchedule) { s.Filters.Days = -1 },
+	}
+	for name, change := range invalid {
+		schedule := valid
+		change(&schedule)
+		if err := ValidateReportSchedule(schedule); err == nil {
+			t.Errorf("%s: schedule must be rejected", name)
+		}
+	}
+}
+
+func TestReportScheduleNextRunTimezone(t *testing.T) {
+	schedule := ReportSchedule{Cron: "0 8 * * 1", Timezone: "Africa/Tunis"}
+	// Sunday 2025-01-19 23:00 UTC is Monday 00:00 in Tunis
+	next, err := schedule.NextRun(time.Date(2025, 1, 19, 23, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 1, 20, 7, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("next run %v, want %v", next.UTC(), want)
+	}
+}
+
+func TestReportDeliveryWebhooks(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	delivery := NewReportDelivery(SMTPConfig{})
+	file := ReportFile{Name: "orders.csv", ContentType: "text/csv", Body: []byte("id,total\n1,10\n2,20\n"), Rows: 2}
+
+	sheet := ReportSchedule{Name: "Orders", Report: ScheduledOrdersReport, Destination: DeliverToSheet, Target: server.URL}
+	if err := delivery.Deliver(sheet, file); err != nil {
+		t.Fatal(err)
+	}
+	rows, _ := received["rows"].([]interface{})
+	if len(rows) != 3 || rows[0].([]interface{})[1] != "total" {
+		t.Errorf("sheet rows = %v", received["rows"])
+	}
+
+	slack := ReportSchedule{Name: "Orders", Destination: DeliverToSlack, Target: server.URL}
+	if err := delivery.Deliver(slack, file); err != nil {
+		t.Fatal(err)
+	}
+	if text, _ := received["text"].(string); !strings.Contains(text, "2 rows") || !strings.Contains(text, "2,20") {
+		t.Errorf("slack text = %q", text)
+	}
+
+	if err := delivery.Deliver(ReportSchedule{Destination: DeliverByEmail, Target: "ops@example.com"}, file); err == nil {
+		t.Error("email delivery without SMTP settings must fail")
+	}
+}
+
+func TestReportEmail(t *testing.T) {
+	file := ReportFile{Name: "tax-report-2025-Q1.csv", ContentType: "text/csv", Body: []byte("invoice\n"), Rows: 1}
+	message, err := reportEmail("reports@example.com", []string{"ops@example.com"}, ReportSchedule{Name: "Quarterly tax"}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: ops@example.com", "Subject: Quarterly tax", "multipart/mixed", `filename=tax-report-2025-Q1.csv`, "aW52b2ljZQo="} {
+		if !strings.Contains(string(message), want) {
+			t.Errorf("message lacks %q", want)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reservation statuses. A held reservation past its expiry no longer counts and is reported as expired.
+const (
+	ReservationHeld     = "held"
+	ReservationConsumed = "consumed"
+	ReservationReleased = "released"
+	ReservationExpired  = "expired"
+)
+
+// MaxReservationTTL bounds how long a chat session may hold stock
+const MaxReservationTTL = 2 * time.Hour
+
+var (
+	// ErrReservationNotFound is returned when a tenant has no such reservation
+	ErrReservationNotFound = errors.New("reservation not found")
+	// ErrStockNotSynced is returned when a product has no synced stock to reserve against
+	ErrStockNotSynced = errors.New("no synced stock for this product")
+	// ErrReservationClosed is returned when releasing a reservation that was consumed, released or expired
+	ErrReservationClosed = errors.New("reservation is no longer held")
+)
+
+// InsufficientStockError reports the units still free when a reservation or an order asks for more
+type InsufficientStockError struct {
+	ProductID string
+	Requested int
+	Available int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("only %d unit(s) of product %s available, %d requested", e.Available, e.ProductID, e.Requested)
+}
+
+// Reservation holds a quantity of a product for a customer while the chatbot collects the order
+type Reservation struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	TenantID  uint   `gorm:"not null;default:0;index:idx_reservations_product" json:"tenant_id"`
+	ProductID string `gorm:"not null;index:idx_reservations_product" json:"product_id"`
+	Quantity  int    `gorm:"not null" json:"quantity"`
+	// Phone identifies the customer whose order consumes the reservation
+	Phone     string    `gorm:"not null;index" json:"phone"`
+	UserID    uint      `gorm:"column:user_id" json:"user_id,omitempty"`
+	Status    string    `gorm:"not null;index" json:"status"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+	// OrderID is the Converty order that consumed the reservation
+	OrderID   string    `json:"order_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for Reservation
+func (Reservation) TableName() string {
+	return "chatbot.stock_reservations"
+}
+
+// settle reports a held reservation past its expiry as expired
+func (r *Reservation) settle(now time.Time) {
+	if r.Status == ReservationHeld && !now.Before(r.ExpiresAt) {
+		r.Status = ReservationExpired
+	}
+}
+
+// ReservationRequest asks to hold Quantity units of a product for TTL (the service default when zero)
+type ReservationRequest struct {
+	ProductID string
+	Quantity  int
+	Phone     string
+	UserID    uint
+	TTL       time.Duration
+}
+
+// StockClaim is the stock taken by an order before it is sent to Converty. Settling it with the
+// created order confirms it; settling it without one puts the stock and reservations back.
+type StockClaim struct {
+	TenantID uint `json:"tenant_id"`
+	// Taken is the quantity taken from the synced stock per product
+	Taken map[string]int `json:"taken,omitempty"`
+	// Reservations are the held reservations of the customer the order consumed
+	Reservations []uint `json:"reservations,omitempty"`
+}
+
+// ReservationService holds synced stock for chat sessions so concurrent orders cannot oversell it
+type ReservationService interface {
+	Reserve(tenantID uint, req ReservationRequest) (Reservation, error)
+	GetReservation(tenantID, id uint) (Reservation, error)
+	ListReservations(tenantID uint, phone string) ([]Reservation, error)
+	Release(tenantID, id uint) (Reservation, error)
+	// ClaimStock takes the order lines from the stock, using the customer's reservations first;
+	// products without synced stock are left to Converty
+	ClaimStock(tenantID uint, order NewOrder) (StockClaim, error)
+	// SettleClaim records the order that consumed the claim, or restores the claim when orderID is empty
+	SettleClaim(claim StockClaim, orderID string) error
+}
+
+// GormReservationService implements ReservationService using GORM
+type GormReservationService struct {
+	db         *gorm.DB
+	defaultTTL time.Duration
+	now        func() time.Time
+}
+
+// NewGormReservationService creates a reservation service holding stock for defaultTTL unless asked otherwise
+func NewGormReservationService(db *gorm.DB, defaultTTL time.Duration) ReservationService {
+	return &GormReservationService{db: db, defaultTTL: defaultTTL, now: time.Now}
+}
+
+// lockStock locks the synced stock row of a product for the rest of the transaction
+func lockStock(tx *gorm.DB, tenantID uint, productID string) (ProductStock, bool, error) {
+	var stock ProductStock
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("tenant_id = ? AND product_id = ?", tenantID, productID).Limit(1).Find(&stock)
+	if result.Error != nil {
+		return stock, false, fmt.Errorf("failed to lock stock of %s: %v", productID, result.Error)
+	}
+	return stock, result.RowsAffected > 0, nil
+}
+
+// heldQuantity sums the live reservations of a product, optionally only those of one customer
+func heldQuantity(tx *gorm.DB, tenantID uint, productID, phone string, now time.Time) (int, error) {
+	query := tx.Model(&Reservation{}).
+		Where("tenant_id = ? AND product_id = ? AND status = ? AND expires_at > ?", tenantID, productID, ReservationHeld, now)
+	if phone != "" {
+		query = query.Where("phone = ?", phone)
+	}
+	var held int
+	if err := query.Select("COALESCE(SUM(quantity), 0)").Scan(&held).Error; err != nil {
+		return 0, fmt.Errorf("failed to sum reservations of %s: %v", productID, err)
+	}
+	return held, nil
+}
+
+// Reserve holds the quantity if the synced stock minus the live reservations covers it
+func (s *GormReservationService) Reserve(tenantID uint, req ReservationRequest) (Reservation, error) {
+	req.Phone = NormalizePhone(req.Phone)
+	switch {
+	case req.ProductID == "":
+		return Reservation{}, fmt.Errorf("%w: product_id is required", ErrValidation)
+	case req.Quantity <= 0:
+		return Reservation{}, fmt.Errorf("%w: quantity must be positive", ErrValidation)
+	case req.Phone == "":
+		return Reservation{}, fmt.Errorf("%w: phone is required", ErrValidation)
+	case req.TTL < 0 || req.TTL > MaxReservationTTL:
+		return Reservation{}, fmt.Errorf("%w: ttl must be at most %v", ErrValidation, MaxReservationTTL)
+	}
+	if req.TTL == 0 {
+		req.TTL = s.defaultTTL
+	}
+	now := s.now()
+	reservation := Reservation{
+		TenantID: tenantID, ProductID: req.ProductID, Quantity: req.Quantity, Phone: req.Phone,
+		UserID: req.UserID, Status: ReservationHeld, ExpiresAt: now.Add(req.TTL),
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		stock, found, err := lockStock(tx, tenantID, req.ProductID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: %s", ErrStockNotSynced, req.ProductID)
+		}
+		if err := tx.Model(&Reservation{}).
+			Where("tenant_id = ? AND product_id = ? AND status = ? AND expires_at <= ?", tenantID, req.ProductID, ReservationHeld, now).
+			Update("status", ReservationExpired).Error; err != nil {
+			return fmt.Errorf("failed to expire reservations: %v", err)
+		}
+		held, err := heldQuantity(tx, tenantID, req.ProductID, "", now)
+		if err != nil {
+			return err
+		}
+		if available := stock.Stock - held; req.Quantity > available {
+			return &InsufficientStockError{ProductID: req.ProductID, Requested: req.Quantity, Available: max(available, 0)}
+		}
+		if err := tx.Create(&reservation).Error; err != nil {
+			return fmt.Errorf("failed to create reservation: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Reservation{}, err
+	}
+	return reservation, nil
+}
+
+// GetReservation returns one reservation of the tenant
+func (s *GormReservationService) GetReservation(tenantID, id uint) (Reservation, error) {
+	var reservation Reservation
+	result := s.db.Where("tenant_id = ?", tenantID).Limit(1).Find(&reservation, id)
+	if result.Error != nil {
+		return reservation, fmt.Errorf("failed to fetch reservation: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return reservation, ErrReservationNotFound
+	}
+	reservation.settle(s.now())
+	return reservation, nil
+}
+
+// ListReservations returns the live reservations of the tenant, or of one customer when phone is set
+func (s *GormReservationService) ListReservations(tenantID uint, phone string) ([]Reservation, error) {
+	query := s.db.Where("tenant_id = ? AND status = ? AND expires_at > ?", tenantID, ReservationHeld, s.now())
+	if phone != "" {
+		query = query.Where("phone = ?", NormalizePhone(phone))
+	}
+	var reservations []Reservation
+	if err := query.Order("expires_at").Find(&reservations).Error; err != nil {
+		return nil, fmt.Errorf("failed to list reservations: %v", err)
+	}
+	return reservations, nil
+}
+
+// Release gives a held reservation back, when the customer leaves the chat without ordering
+func (s *GormReservationService) Release(tenantID, id uint) (Reservation, error) {
+	reservation, err := s.GetReservation(tenantID, id)
+	if err != nil {
+		return reservation, err
+	}
+	if reservation.Status != ReservationHeld {
+		return reservation, fmt.Errorf("%w: %s", ErrReservationClosed, reservation.Status)
+	}
+	result := s.db.Model(&reservation).Where("status = ?", ReservationHeld).Update("status", ReservationReleased)
+	if result.Error != nil {
+		return reservation, fmt.Errorf("failed to release reservation: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		// Consumed by an order in the meantime
+		return s.GetReservation(tenantID, id)
+	}
+	return reservation, nil
+}
+
+// ClaimStock takes the ordered quantities from the synced stock under the row lock. The customer's own
+// reservations count towards the order; beyond them only stock nobody holds may be taken.
+func (s *GormReservationService) ClaimStock(tenantID uint, order NewOrder) (StockClaim, error) {
+	claim := StockClaim{TenantID: tenantID, Taken: map[string]int{}}
+	phone := NormalizePhone(order.Customer.Phone)
+	quantities := map[string]int{}
+	var products []string
+	for _, line := range order.Items {
+		if line.ProductID == "" || line.Quantity <= 0 {
+			continue
+		}
+		if quantities[line.ProductID] == 0 {
+			products = append(products, line.ProductID)
+		}
+		quantities[line.ProductID] += line.Quantity
+	}
+	now := s.now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Products are locked in order line order; concurrent orders of the same products in another
+		// order may deadlock, which postgres resolves by failing one of them
+		for _, productID := range products {
+			stock, found, err := lockStock(tx, tenantID, productID)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			held, err := heldQuantity(tx, tenantID, productID, "", now)
+			if err != nil {
+				return err
+			}
+			own := 0
+			if phone != "" {
+				if own, err = heldQuantity(tx, tenantID, productID, phone, now); err != nil {
+					return err
+				}
+			}
+			wanted := quantities[productID]
+			if available := own + stock.Stock - held; wanted > available {
+				return &InsufficientStockError{ProductID: productID, Requested: wanted, Available: max(available, 0)}
+			}
+			if err := tx.Model(&ProductStock{}).Where("id = ?", stock.ID).
+				Update("stock", gorm.Expr("stock - ?", wanted)).Error; err != nil {
+				return fmt.Errorf("failed to take stock of %s: %v", productID, err)
+			}
+			claim.Taken[productID] = wanted
+			if own == 0 {
+				continue
+			}
+			var consumed []uint
+			if err := tx.Model(&Reservation{}).
+				Where("tenant_id = ? AND product_id = ? AND phone = ? AND status = ? AND expires_at > ?", tenantID, productID, phone, ReservationHeld, now).
+				Pluck("id", &consumed).Error; err != nil {
+				return fmt.Errorf("failed to find reservations of %s: %v", productID, err)
+			}
+			if err := tx.Model(&Reservation{}).Where("id IN ?", consumed).Update("status", ReservationConsumed).Error; err != nil {
+				return fmt.Errorf("failed to consume reservations of %s: %v", productID, err)
+			}
+			claim.Reservations = append(claim.Reservations, consumed...)
+		}
+		return nil
+	})
+	if err != nil {
+		return StockClaim{}, err
+	}
+	return claim, nil
+}
+
+// SettleClaim links the consumed reservations to the order, or gives the stock and reservations back
+// when the order was not created. Restored reservations keep their original expiry.
+func (s *GormReservationService) SettleClaim(claim StockClaim, orderID string) error {
+	if orderID != "" {
+		if len(claim.Reservations) == 0 {
+			return nil
+		}
+		if err := s.db.Model(&Reservation{}).Where("id IN ?", claim.Reservations).Update("order_id", orderID).Error; err != nil {
+			return fmt.Errorf("failed to link reservations to order %s: %v", orderID, err)
+		}
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for productID, quantity := range claim.Taken {
+			if err := tx.Model(&ProductStock{}).Where("tenant_id = ? AND product_id = ?", claim.TenantID, productID).
+				Update("stock", gorm.Expr("stock + ?", quantity)).Error; err != nil {
+				return fmt.Errorf("failed to restore stock of %s: %v", productID, err)
+			}
+		}
+		if len(claim.Reservations) > 0 {
+			if err := tx.Model(&Reservation{}).Where("id IN ? AND status = ?", claim.Reservations, ReservationConsumed).
+				Update("status", ReservationHeld).Error; err != nil {
+				return fmt.Errorf("failed to restore reservations: %v", err)
+			}
+		}
+		return nil
+	})
+}
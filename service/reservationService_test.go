@@ -0,0 +1,34 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReserveValidation(t *testing.T) {
+	reservations := NewGormReservationService(nil, 15*time.Minute)
+	for name, req := range map[string]ReservationRequest{
+		"no product":  {Quantity: 1, Phone: "+21698111222"},
+		"no quantity": {ProductID: "p1", Phone: "+21698111222"},
+		"no phone":    {ProductID: "p1", Quantity: 1},
+		"long ttl":    {ProductID: "p1", Quantity: 1, Phone: "+21698111222", TTL: 3 * time.Hour},
+	} {
+		if _, err := reservations.Reserve(1, req); !errors.Is(err, ErrValidation) {
+			t.Errorf("%s: got %v, want ErrValidation", name, err)
+		}
+	}
+}
+
+func TestReservationSettle(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	live := Reservation{Status: ReservationHeld, ExpiresAt: now.Add(time.Minute)}
+	live.settle(now)
+	stale := Reservation{Status: ReservationHeld, ExpiresAt: now}
+	stale.settle(now)
+	consumed := Reservation{Status: ReservationConsumed, ExpiresAt: now.Add(-time.Hour)}
+	consumed.settle(now)
+	if live.Status != ReservationHeld || stale.Status != ReservationExpired || consumed.Status != ReservationConsumed {
+		t.Errorf("unexpected statuses: %s, %s, %s", live.Status, stale.Status, consumed.Status)
+	}
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// anonymizedPrefix marks a detail value replaced by its hash
+const anonymizedPrefix = "anon:"
+
+// DefaultAnonymizeHashFields are the detail paths replaced by a hash when RETENTION_HASH_FIELDS is unset;
+// the hash still groups a customer's old records without revealing the number
+var DefaultAnonymizeHashFields = []string{"phone", "phone_number", "customer.phone"}
+
+// DefaultAnonymizeDropFields are the detail paths removed when RETENTION_DROP_FIELDS is unset
+var DefaultAnonymizeDropFields = []string{"name", "address", "customer.name", "customer.address"}
+
+// RetentionPolicy is how long interactions keep their personal data and how long they are kept at all
+type RetentionPolicy struct {
+	// AnonymizeAfter is the age at which the PII fields of a record are hashed or dropped; 0 disables it
+	AnonymizeAfter time.Duration
+	// DeleteAfter is the age at which records are deleted; 0 disables it
+	DeleteAfter time.Duration
+	HashFields  []string
+	DropFields  []string
+}
+
+// Enabled reports whether the policy does anything
+func (p RetentionPolicy) Enabled() bool {
+	return p.AnonymizeAfter > 0 || p.DeleteAfter > 0
+}
+
+// RetentionReport is the outcome of applying a policy, or what applying it would do on a dry run
+type RetentionReport struct {
+	DryRun          bool       `json:"dry_run"`
+	AnonymizeCutoff *time.Time `json:"anonymize_cutoff,omitempty"`
+	DeleteCutoff    *time.Time `json:"delete_cutoff,omitempty"`
+	Anonymized      int64      `json:"anonymized"`
+	Deleted         int64      `json:"deleted"`
+	// AttachmentsPurged counts the files removed with the anonymized and deleted records
+	AttachmentsPurged int64  `json:"attachments_purged"`
+	SkippedUsers      []uint `json:"skipped_users,omitempty"`
+}
+
+// RetentionService applies the retention policy to chatbot.interactions
+type RetentionService interface {
+	// Apply anonymizes then deletes the old records of every user not in skipUserIDs; a dry run only counts them
+	Apply(policy RetentionPolicy, skipUserIDs []uint, dryRun bool) (RetentionReport, error)
+}
+
+// GormRetentionService implements RetentionService using GORM
+type GormRetentionService struct {
+	db *gorm.DB
+	// data deletes the records so their status history goes with them
+	data DataService
+	// attachments purges the files of anonymized and deleted records; customers send photos of
+	// themselves and their address labels, so the files go with the personal data
+	attachments AttachmentService
+}
+
+// NewGormRetentionService creates a new GormRetentionService; attachments may be nil
+func NewGormRetentionService(db *gorm.DB, data DataService, attachments AttachmentService) RetentionService {
+	return &GormRetentionService{db: db, data: data, attachments: attachments}
+}
+
+// Apply runs the anonymization stage before the deletion stage
+func (s *GormRetentionService) Apply(policy RetentionPolicy, skipUserIDs []uint, dryRun bool) (RetentionReport, error) {
+	now := time.Now()
+	report := RetentionReport{DryRun: dryRun, SkippedUsers: skipUserIDs}
+	// A record store outside PostgreSQL counts and anonymizes its own records
+	store, external := s.data.(retentionStore)
+	if policy.AnonymizeAfter > 0 {
+		cutoff := now.Add(-policy.AnonymizeAfter)
+		report.AnonymizeCutoff = &cutoff
+		var anonymized []uint
+		var err error
+		switch {
+		case external && dryRun:
+			report.Anonymized, err = store.countRetained(cutoff, skipUserIDs, true)
+		case external:
+			anonymized, err = store.anonymizeRecords(cutoff, skipUserIDs, policy, now)
+		case dryRun:
+			err = s.retained(cutoff, skipUserIDs).Where("anonymized_at IS NULL").Count(&report.Anonymized).Error
+		default:
+			anonymized, err = s.anonymize(cutoff, skipUserIDs, policy, now)
+		}
+		if !dryRun {
+			report.Anonymized = int64(len(anonymized))
+		}
+		if purgeErr := s.purgeAttachments(&report, anonymized); err == nil {
+			err = purgeErr
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to anonymize records: %v", err)
+		}
+	}
+	if policy.DeleteAfter > 0 {
+		cutoff := now.Add(-policy.DeleteAfter)
+		report.DeleteCutoff = &cutoff
+		if dryRun {
+			var err error
+			if external {
+				report.Deleted, err = store.countRetained(cutoff, skipUserIDs, false)
+			} else {
+				err = s.retained(cutoff, skipUserIDs).Count(&report.Deleted).Error
+			}
+			if err != nil {
+				return report, fmt.Errorf("failed to count records: %v", err)
+			}
+		} else {
+			deleted, err := s.data.PurgeRecords(cutoff, skipUserIDs)
+			if err != nil {
+				return report, err
+			}
+			report.Deleted = int64(len(deleted))
+			if err := s.purgeAttachments(&report, deleted); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// purgeAttachments removes the attachments of recordIDs and counts them in report
+func (s *GormRetentionService) purgeAttachments(report *RetentionReport, recordIDs []uint) error {
+	if s.attachments == nil || len(recordIDs) == 0 {
+		return nil
+	}
+	purged, err := s.attachments.PurgeRecords(context.Background(), recordIDs)
+	report.AttachmentsPurged += purged
+	return err
+}
+
+// retained selects the records PurgeRecords considers older than cutoff, soft-deleted ones included
+func (s *GormRetentionService) retained(cutoff time.Time, skipUserIDs []uint) *gorm.DB {
+	query := s.db.Table("chatbot.interactions").Where("(created_at < ? OR deleted_at < ?)", cutoff, cutoff)
+	if len(skipUserIDs) > 0 {
+		query = query.Where("user_id NOT IN ?", skipUserIDs)
+	}
+	return query
+}
+
+// anonymize rewrites the details of the records older than cutoff, batch by batch
+func (s *GormRetentionService) anonymize(cutoff time.Time, skipUserIDs []uint, policy RetentionPolicy, now time.Time) ([]uint, error) {
+	// Rows are read as stored, without the Data hooks, and opened here so encrypted fields can be hashed
+	type storedRecord struct {
+		ID       uint
+		TenantID uint
+		UserID   uint
+		Details  datatypes.JSON
+	}
+	var anonymized []uint
+	var batch []storedRecord
+	err := s.retained(cutoff, skipUserIDs).Where("anonymized_at IS NULL").Select("id, tenant_id, user_id, details").Order("id").
+		FindInBatches(&batch, 200, func(tx *gorm.DB, _ int) error {
+			for _, record := range batch {
+				details := []byte(record.Details)
+				if PII != nil {
+					opened, err := PII.Open(record.TenantID, record.UserID, details)
+					if errors.Is(err, ErrPIIDecrypt) {
+						// Nobody can read the record anymore; the next batches are still anonymized
+						log.Printf("Retention: skipping record %d: %v", record.ID, err)
+						continue
+					}
+					if err != nil {
+						return fmt.Errorf("record %d: %w", record.ID, err)
+					}
+					details = opened
+				}
+				details, err := AnonymizeDetails(details, policy.HashFields, policy.DropFields)
+				if err != nil {
+					return fmt.Errorf("record %d: %v", record.ID, err)
+				}
+				// Protected fields the policy keeps are encrypted again; the old lookup hashes would
+				// still find the customer by name or phone, so only those of the new values are stored
+				var hashes interface{}
+				if PII != nil {
+					sealed, sealedHashes, err := PII.Seal(record.TenantID, details)
+					if err != nil {
+						return fmt.Errorf("failed to encrypt record %d: %v", record.ID, err)
+					}
+					details = sealed
+					if sealedHashes != nil {
+						hashes = datatypes.JSON(sealedHashes)
+					}
+				}
+				err = s.db.Table("chatbot.interactions").Where("id = ?", record.ID).UpdateColumns(map[string]interface{}{
+					"details":       datatypes.JSON(details),
+					"pii_hashes":    hashes,
+					"anonymized_at": now,
+					"updated_at":    now,
+				}).Error
+				if err != nil {
+					return fmt.Errorf("failed to update record %d: %v", record.ID, err)
+				}
+				anonymized = append(anonymized, record.ID)
+			}
+			return nil
+		}).Error
+	return anonymized, err
+}
+
+// AnonymizeDetails replaces the hashFields of a details object with a hash of their value and removes
+// the dropFields; details that are not a JSON object are returned unchanged
+func AnonymizeDetails(details []byte, hashFields, dropFields []string) ([]byte, error) {
+	doc, ok := decodeDetails(details)
+	if !ok {
+		return details, nil
+	}
+	for _, field := range hashFields {
+		parent, name, value, found := lookupPath(doc, strings.Split(field, "."))
+		if !found || value == nil {
+			continue
+		}
+		if text, ok := value.(string); ok && strings.HasPrefix(text, anonymizedPrefix) {
+			continue
+		}
+		parent[name] = anonymizeValue(field, fmt.Sprint(value))
+	}
+	for _, field := range dropFields {
+		if parent, name, _, found := lookupPath(doc, strings.Split(field, ".")); found {
+			delete(parent, name)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// anonymizeValue hashes a value the way PII lookups normalize it, so one customer keeps one hash
+func anonymizeValue(path, value string) string {
+	if strings.Contains(path, "phone") {
+		value = NormalizePhone(value)
+	} else {
+		value = strings.ToLower(strings.Join(strings.Fields(value), " "))
+	}
+	sum := sha256.Sum256([]byte(value))
+	return anonymizedPrefix + hex.EncodeToString(sum[:])
+}
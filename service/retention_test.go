@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnonymizeDetails(t *testing.T) {
+	details := []byte(`{"name": "Amira", "phone": "+216 74 000 000", "note": "late", "customer": {"phone": "+21674000000", "address": "Sfax"}}`)
+	anonymized, err := AnonymizeDetails(details, DefaultAnonymizeHashFields, DefaultAnonymizeDropFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(anonymized, &doc); err != nil {
+		t.Fatal(err)
+	}
+	customer := doc["customer"].(map[string]interface{})
+	if _, kept := doc["name"]; kept || customer["address"] != nil || doc["note"] != "late" {
+		t.Fatalf("unexpected details: %s", anonymized)
+	}
+	phone, _ := doc["phone"].(string)
+	if !strings.HasPrefix(phone, anonymizedPrefix) || customer["phone"] != phone {
+		t.Errorf("one phone must keep one hash: %s", anonymized)
+	}
+
+	again, err := AnonymizeDetails(anonymized, DefaultAnonymizeHashFields, DefaultAnonymizeDropFields)
+	if err != nil || string(again) != string(anonymized) {
+		t.Errorf("anonymizing twice changed the details: %s", again)
+	}
+	if out, err := AnonymizeDetails([]byte(`"text"`), DefaultAnonymizeHashFields, nil); err != nil || string(out) != `"text"` {
+		t.Errorf("non-object details must be kept, got %s %v", out, err)
+	}
+}
+
+// stubRetentionStore is a record store applying the retention stages itself
+type stubRetentionStore struct {
+	DataService
+	counted, anonymized, purged int
+}
+
+func (s *stubRetentionStore) countRetained(cutoff time.Time, skipUserIDs []uint, pending bool) (int64, error) {
+	s.counted++
+	return 4, nil
+}
+
+func (s *stubRetentionStore) anonymizeRecords(cutoff time.Time, skipUserIDs []uint, policy RetentionPolicy, now time.Time) ([]uint, error) {
+	s.anonymized++
+	return []uint{1, 2, 3}, nil
+}
+
+func (s *stubRetentionStore) PurgeRecords(olderThan time.Time, skipUserIDs []uint) ([]uint, error) {
+	s.purged++
+	return []uint{4, 5}, nil
+}
+
+// stubAttachments records the records whose attachments were purged
+type stubAttachments struct {
+	AttachmentService
+	purged []uint
+}
+
+func (s *stubAttachments) PurgeRecords(ctx context.Context, recordIDs []uint) (int64, error) {
+	s.purged = append(s.purged, recordIDs...)
+	return int64(len(recordIDs)), nil
+}
+
+func TestRetentionUsesExternalStore(t *testing.T) {
+	store := &stubRetentionStore{}
+	attachments := &stubAttachments{}
+	// No database: every stage must go through the store
+	retention := NewGormRetentionService(nil, store, attachments)
+	policy := RetentionPolicy{AnonymizeAfter: time.Hour, DeleteAfter: 2 * time.Hour}
+
+	report, err := retention.Apply(policy, nil, true)
+	if err != nil || report.Anonymized != 4 || report.Deleted != 4 || store.counted != 2 || len(attachments.purged) != 0 {
+		t.Fatalf("dry run: %+v %v", report, err)
+	}
+	report, err = retention.Apply(policy, nil, false)
+	if err != nil || report.Anonymized != 3 || report.Deleted != 2 || store.anonymized != 1 || store.purged != 1 {
+		t.Fatalf("run: %+v %v", report, err)
+	}
+	// The files of anonymized and deleted records go with them
+	if report.AttachmentsPurged != 5 || len(attachments.purged) != 5 {
+		t.Fatalf("purged attachments of %v, report %+v", attachments.purged, report)
+	}
+}
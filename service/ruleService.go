@@ -0,0 +1,358 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Rule operators compare a details field with the rule value, ignoring case
+const (
+	RuleEquals   = "equals"
+	RuleContains = "contains"
+	// RuleKeywords matches when the field contains any of the comma-separated keywords
+	RuleKeywords = "keywords"
+	RulePrefix   = "prefix"
+	RuleRegex    = "regex"
+)
+
+// classifiedFieldsKey lists the details fields set by rules, so reclassification can redo them
+const classifiedFieldsKey = "classified_fields"
+
+// classifiedByKey lists the names of the rules that matched a record
+const classifiedByKey = "classified_by"
+
+// ruleCacheTTL bounds how long another instance may apply a rule set that was replaced
+const ruleCacheTTL = time.Minute
+
+// ClassificationRule sets details fields of new records that match a condition, for example
+// description keywords → issue_type and priority, or phone prefix → region
+type ClassificationRule struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	TenantID uint   `gorm:"not null;default:0;index" json:"tenant_id"`
+	Position int    `gorm:"not null" json:"position"`
+	Name     string `gorm:"not null" json:"name"`
+	// RecordType limits the rule to records of one type; empty matches every type
+	RecordType string `json:"record_type,omitempty"`
+	Field      string `gorm:"not null" json:"field"`
+	Operator   string `gorm:"not null" json:"operator"`
+	Value      string `gorm:"not null" json:"value"`
+	// Set holds the details fields to fill; fields set by the client or by an earlier rule are kept.
+	// A "tags" list is merged into the record's tags instead.
+	Set datatypes.JSON `gorm:"not null" json:"set"`
+	// Stop skips the rules after this one when it matches
+	Stop      bool      `gorm:"not null;default:false" json:"stop,omitempty"`
+	Disabled  bool      `gorm:"not null;default:false" json:"disabled,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for ClassificationRule
+func (ClassificationRule) TableName() string {
+	return "chatbot.classification_rules"
+}
+
+// RecordClassifier fills details fields of a record before it is stored and returns the names
+// of the matching rules
+type RecordClassifier interface {
+	Classify(tenantID uint, recordType string, details map[string]interface{}) []string
+}
+
+// RuleService defines the interface for classification rules
+type RuleService interface {
+	RecordClassifier
+	ListRules(tenantID uint) ([]ClassificationRule, error)
+	// ReplaceRules stores rules as the tenant's complete, ordered rule set
+	ReplaceRules(tenantID uint, rules []ClassificationRule) ([]ClassificationRule, error)
+	// Reclassify applies the current rules to the tenant's existing records, optionally of one
+	// type, and returns how many records changed
+	Reclassify(tenantID uint, recordType string) (int, error)
+}
+
+// GormRuleService implements RuleService using GORM
+type GormRuleService struct {
+	db    *gorm.DB
+	cache *ttlCache
+}
+
+// NewGormRuleService creates a new GormRuleService
+func NewGormRuleService(db *gorm.DB) RuleService {
+	cache := newTTLCache(ruleCacheTTL)
+	Caches.Register(CacheRules, cache)
+	return &GormRuleService{db: db, cache: cache}
+}
+
+// compiledRule is a rule with its parsed value and fields
+type compiledRule struct {
+	ClassificationRule
+	keywords []string
+	pattern  *regexp.Regexp
+	set      map[string]interface{}
+}
+
+// compileRule validates a rule
+func compileRule(rule ClassificationRule) (compiledRule, error) {
+	compiled := compiledRule{ClassificationRule: rule}
+	if rule.Name == "" || rule.Field == "" {
+		return compiled, fmt.Errorf("rule name and field are required")
+	}
+	switch rule.Operator {
+	case RuleEquals, RuleContains, RulePrefix:
+	case RuleKeywords:
+		for _, keyword := range strings.Split(rule.Value, ",") {
+			if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+				compiled.keywords = append(compiled.keywords, keyword)
+			}
+		}
+	case RuleRegex:
+		pattern, err := regexp.Compile("(?i)" + rule.Value)
+		if err != nil {
+			return compiled, fmt.Errorf("rule %s: invalid regex: %v", rule.Name, err)
+		}
+		compiled.pattern = pattern
+	default:
+		return compiled, fmt.Errorf("rule %s: unknown operator %q", rule.Name, rule.Operator)
+	}
+	if err := json.Unmarshal(rule.Set, &compiled.set); err != nil || len(compiled.set) == 0 {
+		return compiled, fmt.Errorf("rule %s: set must be a non-empty object", rule.Name)
+	}
+	for field := range compiled.set {
+		if field == classifiedFieldsKey || field == classifiedByKey || field == rule.Field {
+			return compiled, fmt.Errorf("rule %s: cannot set %s", rule.Name, field)
+		}
+	}
+	return compiled, nil
+}
+
+// matches reports whether the rule's condition holds for details
+func (r compiledRule) matches(recordType string, details map[string]interface{}) bool {
+	if r.Disabled || (r.RecordType != "" && r.RecordType != recordType) {
+		return false
+	}
+	raw, ok := details[r.Field]
+	if !ok || raw == nil {
+		return false
+	}
+	value := strings.ToLower(strings.TrimSpace(fmt.Sprint(raw)))
+	expected := strings.ToLower(r.Value)
+	switch r.Operator {
+	case RuleEquals:
+		return value == expected
+	case RuleContains:
+		return strings.Contains(value, expected)
+	case RulePrefix:
+		return strings.HasPrefix(value, expected)
+	case RuleKeywords:
+		for _, keyword := range r.keywords {
+			if strings.Contains(value, keyword) {
+				return true
+			}
+		}
+	case RuleRegex:
+		return r.pattern.MatchString(value)
+	}
+	return false
+}
+
+// applyRules fills details from the matching rules and records what they set
+func applyRules(rules []compiledRule, recordType string, details map[string]interface{}) []string {
+	var matched, classified []string
+	for _, rule := range rules {
+		if !rule.matches(recordType, details) {
+			continue
+		}
+		matched = append(matched, rule.Name)
+		for field, value := range rule.set {
+			if field == "tags" {
+				if mergeTags(details, value) && !containsString(classified, "tags") {
+					classified = append(classified, "tags")
+				}
+				continue
+			}
+			if _, exists := details[field]; exists {
+				continue
+			}
+			details[field] = value
+			classified = append(classified, field)
+		}
+		if rule.Stop {
+			break
+		}
+	}
+	if len(matched) > 0 {
+		details[classifiedByKey] = matched
+	}
+	if len(classified) > 0 {
+		sort.Strings(classified)
+		details[classifiedFieldsKey] = classified
+	}
+	return matched
+}
+
+// mergeTags adds the rule's tags to the record's tag list and reports whether any were new
+func mergeTags(details map[string]interface{}, value interface{}) bool {
+	var tags []string
+	if existing, ok := details["tags"].([]interface{}); ok {
+		for _, tag := range existing {
+			tags = append(tags, fmt.Sprint(tag))
+		}
+	} else if existing, ok := details["tags"].([]string); ok {
+		tags = append(tags, existing...)
+	}
+	added := false
+	switch v := value.(type) {
+	case []interface{}:
+		for _, tag := range v {
+			if name := fmt.Sprint(tag); !containsString(tags, name) {
+				tags, added = append(tags, name), true
+			}
+		}
+	case string:
+		if !containsString(tags, v) {
+			tags, added = append(tags, v), true
+		}
+	}
+	if added {
+		details["tags"] = tags
+	}
+	return added
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// unclassify removes the fields rules set earlier so the current rules can be applied afresh.
+// Tags are left alone since they may have been edited since.
+func unclassify(details map[string]interface{}) {
+	if fields, ok := details[classifiedFieldsKey].([]interface{}); ok {
+		for _, field := range fields {
+			if name := fmt.Sprint(field); name != "tags" {
+				delete(details, name)
+			}
+		}
+	}
+	delete(details, classifiedFieldsKey)
+	delete(details, classifiedByKey)
+}
+
+// tenantRules returns the compiled rules of a tenant; broken rules are skipped
+func (s *GormRuleService) tenantRules(tenantID uint) ([]compiledRule, error) {
+	key := fmt.Sprint(tenantID)
+	if cached, ok := s.cache.Get(key); ok {
+		return cached.([]compiledRule), nil
+	}
+	rules, err := s.ListRules(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		if c, err := compileRule(rule); err == nil {
+			compiled = append(compiled, c)
+		}
+	}
+	s.cache.Set(key, compiled)
+	return compiled, nil
+}
+
+// Classify applies the tenant's rules; a failure to load them leaves the record unclassified
+func (s *GormRuleService) Classify(tenantID uint, recordType string, details map[string]interface{}) []string {
+	rules, err := s.tenantRules(tenantID)
+	if err != nil || len(rules) == 0 {
+		return nil
+	}
+	return applyRules(rules, recordType, details)
+}
+
+// ListRules fetches the tenant's rules in evaluation order
+func (s *GormRuleService) ListRules(tenantID uint) ([]ClassificationRule, error) {
+	var rules []ClassificationRule
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("position, id").Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch rules: %v", err)
+	}
+	return rules, nil
+}
+
+// ReplaceRules validates the rule set and swaps it in one transaction
+func (s *GormRuleService) ReplaceRules(tenantID uint, rules []ClassificationRule) ([]ClassificationRule, error) {
+	for i := range rules {
+		if _, err := compileRule(rules[i]); err != nil {
+			return nil, err
+		}
+		rules[i].ID = 0
+		rules[i].TenantID = tenantID
+		rules[i].Position = i
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tenant_id = ?", tenantID).Delete(&ClassificationRule{}).Error; err != nil {
+			return fmt.Errorf("failed to remove rules: %v", err)
+		}
+		if len(rules) == 0 {
+			return nil
+		}
+		if err := tx.Create(&rules).Error; err != nil {
+			return fmt.Errorf("failed to store rules: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.cache.DeletePrefix(fmt.Sprint(tenantID))
+	return rules, nil
+}
+
+// Reclassify walks the tenant's records in batches and rewrites the details that change; append-only records keep theirs
+func (s *GormRuleService) Reclassify(tenantID uint, recordType string) (int, error) {
+	s.cache.DeletePrefix(fmt.Sprint(tenantID))
+	rules, err := s.tenantRules(tenantID)
+	if err != nil {
+		return 0, err
+	}
+	query := s.db.Model(&Data{}).Where("tenant_id = ?", tenantID)
+	if recordType != "" {
+		query = query.Where("type = ?", recordType)
+	}
+	if immutable := immutableTypeList(); len(immutable) > 0 {
+		query = query.Where("type NOT IN ?", immutable)
+	}
+	changed := 0
+	var batch []Data
+	err = query.FindInBatches(&batch, 200, func(tx *gorm.DB, _ int) error {
+		for _, record := range batch {
+			var details map[string]interface{}
+			if len(record.Details) == 0 || json.Unmarshal(record.Details, &details) != nil || details == nil {
+				continue
+			}
+			before, _ := json.Marshal(details)
+			unclassify(details)
+			applyRules(rules, record.Type, details)
+			after, err := json.Marshal(details)
+			if err != nil || string(before) == string(after) {
+				continue
+			}
+			record.Details = datatypes.JSON(after)
+			if err := record.sealPII(); err != nil {
+				return fmt.Errorf("failed to encrypt record %d: %v", record.ID, err)
+			}
+			if err := s.db.Model(&Data{}).Where("id = ?", record.ID).
+				UpdateColumns(map[string]interface{}{"details": record.Details, "pii_hashes": record.PIIHashes, "updated_at": time.Now()}).Error; err != nil {
+				return fmt.Errorf("failed to update record %d: %v", record.ID, err)
+			}
+			changed++
+		}
+		return nil
+	}).Error
+	return changed, err
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func mustCompile(t *testing.T, rule ClassificationRule) compiledRule {
+	t.Helper()
+	compiled, err := compileRule(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compiled
+}
+
+func TestApplyRules(t *testing.T) {
+	rules := []compiledRule{
+		mustCompile(t, ClassificationRule{Name: "broken", RecordType: "issue", Field: "description", Operator: RuleKeywords,
+			Value: "cassé, broken, defect", Set: datatypes.JSON(`{"issue_type":"defective_product","priority":"high"}`)}),
+		mustCompile(t, ClassificationRule{Name: "late", RecordType: "issue", Field: "description", Operator: RuleContains,
+			Value: "retard", Set: datatypes.JSON(`{"issue_type":"late_delivery","priority":"normal"}`)}),
+		mustCompile(t, ClassificationRule{Name: "sfax", Field: "phone", Operator: RuleRegex,
+			Value: `^(\+216)?74`, Set: datatypes.JSON(`{"region":"sfax","tags":["south"]}`), Stop: true}),
+		mustCompile(t, ClassificationRule{Name: "never", Field: "phone", Operator: RulePrefix,
+			Value: "+216", Set: datatypes.JSON(`{"region":"other"}`)}),
+	}
+
+	details := map[string]interface{}{"description": "Colis en retard et produit CASSÉ", "phone": "+21674123456", "priority": "low"}
+	matched := applyRules(rules, "issue", details)
+	if !reflect.DeepEqual(matched, []string{"broken", "late", "sfax"}) {
+		t.Errorf("matched %v", matched)
+	}
+	// The first matching rule wins and fields sent by the client are kept
+	if details["issue_type"] != "defective_product" || details["priority"] != "low" || details["region"] != "sfax" {
+		t.Errorf("unexpected details %v", details)
+	}
+	if !reflect.DeepEqual(details["tags"], []string{"south"}) {
+		t.Errorf("tags = %v", details["tags"])
+	}
+
+	// Reclassification works on stored JSON and only redoes the fields rules set
+	stored, _ := json.Marshal(details)
+	var reloaded map[string]interface{}
+	json.Unmarshal(stored, &reloaded)
+	unclassify(reloaded)
+	for _, field := range []string{"issue_type", "region", classifiedByKey, classifiedFieldsKey} {
+		if _, ok := reloaded[field]; ok {
+			t.Errorf("%s survived unclassify", field)
+		}
+	}
+	if reloaded["priority"] != "low" {
+		t.Error("a client field was removed")
+	}
+	if matched := applyRules(rules[1:2], "order", reloaded); matched != nil {
+		t.Errorf("rule for issues matched an order: %v", matched)
+	}
+}
+
+func TestCompileRuleValidation(t *testing.T) {
+	for _, rule := range []ClassificationRule{
+		{Name: "op", Field: "description", Operator: "like", Value: "x", Set: datatypes.JSON(`{"a":1}`)},
+		{Name: "re", Field: "description", Operator: RuleRegex, Value: "(", Set: datatypes.JSON(`{"a":1}`)},
+		{Name: "set", Field: "description", Operator: RuleEquals, Value: "x", Set: datatypes.JSON(`{}`)},
+		{Name: "self", Field: "description", Operator: RuleEquals, Value: "x", Set: datatypes.JSON(`{"description":"y"}`)},
+		{Field: "description", Operator: RuleEquals, Value: "x", Set: datatypes.JSON(`{"a":1}`)},
+	} {
+		if _, err := compileRule(rule); err == nil {
+			t.Errorf("rule %+v was accepted", rule)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrInsufficientScope is returned when Converty refuses a call the stored token has no scope for
+var ErrInsufficientScope = errors.New("Converty token lacks a required scope")
+
+// scopeParam extracts the scope a 403 response names, from WWW-Authenticate or a JSON body
+var scopeParam = regexp.MustCompile(`"?scope"?\s*[=:]\s*"([^"]+)"`)
+
+// InsufficientScope reports whether a 403 response means the token lacks a scope, and which ones
+func InsufficientScope(resp *http.Response, body []byte) ([]string, bool) {
+	if resp.StatusCode != http.StatusForbidden {
+		return nil, false
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	text := strings.ToLower(challenge + " " + string(body))
+	if !strings.Contains(text, "insufficient_scope") && !strings.Contains(text, "insufficient scope") {
+		return nil, false
+	}
+	for _, source := range []string{challenge, string(body)} {
+		if match := scopeParam.FindStringSubmatch(source); match != nil {
+			return strings.Fields(match[1]), true
+		}
+	}
+	return nil, true
+}
+
+// ScopeError wraps ErrInsufficientScope with the missing scopes and how to grant them
+func ScopeError(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w, grant it via /login?scopes=...", ErrInsufficientScope)
+	}
+	return fmt.Errorf("%w: %s, grant it via /login?scopes=%s", ErrInsufficientScope,
+		strings.Join(scopes, " "), strings.Join(scopes, ","))
+}
+
+// RecordMissingScopes notes on the stored token that Converty refused it the given scopes,
+// dropping them from the granted scopes that were assumed
+func RecordMissingScopes(db *gorm.DB, userID string, scopes []string) error {
+	if len(scopes) == 0 {
+		return nil
+	}
+	var token struct {
+		Scopes        string
+		MissingScopes string
+	}
+	if err := db.Table("public.token_infos").Select("scopes, missing_scopes").Where("user_id = ?", userID).Take(&token).Error; err != nil {
+		return fmt.Errorf("failed to load token scopes: %v", err)
+	}
+	missing := MergeScopes(strings.Fields(token.MissingScopes), scopes)
+	granted := RemoveScopes(strings.Fields(token.Scopes), scopes)
+	if err := db.Table("public.token_infos").Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"scopes":         strings.Join(granted, " "),
+		"missing_scopes": strings.Join(missing, " "),
+	}).Error; err != nil {
+		return fmt.Errorf("failed to record missing scopes: %v", err)
+	}
+	Caches.TokenChanged(userID)
+	return nil
+}
+
+// MergeScopes joins scope lists, keeping the first occurrence of each scope in order
+func MergeScopes(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, list := range lists {
+		for _, scope := range list {
+			if scope != "" && !seen[scope] {
+				seen[scope] = true
+				merged = append(merged, scope)
+			}
+		}
+	}
+	return merged
+}
+
+// RemoveScopes returns scopes without the removed ones
+func RemoveScopes(scopes, removed []string) []string {
+	drop := make(map[string]bool, len(removed))
+	for _, scope := range removed {
+		drop[scope] = true
+	}
+	var kept []string
+	for _, scope := range scopes {
+		if !drop[scope] {
+			kept = append(kept, scope)
+		}
+	}
+	return kept
+}
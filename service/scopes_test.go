@@ -0,0 +1,39 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestInsufficientScope(t *testing.T) {
+	challenge := &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}
+	challenge.Header.Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="read-customers read-coupons"`)
+	if scopes, ok := InsufficientScope(challenge, nil); !ok || strings.Join(scopes, " ") != "read-customers read-coupons" {
+		t.Errorf("challenge: got %v %v", scopes, ok)
+	}
+
+	body := &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}
+	if scopes, ok := InsufficientScope(body, []byte(`{"error":"insufficient_scope","scope":"read-customers"}`)); !ok || len(scopes) != 1 {
+		t.Errorf("json body: got %v %v", scopes, ok)
+	}
+	if _, ok := InsufficientScope(body, []byte(`{"error":"store suspended"}`)); ok {
+		t.Error("a plain 403 was reported as a missing scope")
+	}
+
+	err := ScopeError([]string{"read-customers"})
+	if !errors.Is(err, ErrInsufficientScope) || !strings.Contains(err.Error(), "/login?scopes=read-customers") {
+		t.Errorf("unexpected scope error %v", err)
+	}
+}
+
+func TestMergeScopes(t *testing.T) {
+	merged := MergeScopes([]string{"read-orders", "read-products"}, []string{"read-products", "read-customers"}, nil)
+	if strings.Join(merged, " ") != "read-orders read-products read-customers" {
+		t.Errorf("got %v", merged)
+	}
+	if kept := RemoveScopes(merged, []string{"read-products"}); strings.Join(kept, " ") != "read-orders read-customers" {
+		t.Errorf("got %v", kept)
+	}
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretProvider reads a bundle of named secrets, such as CLIENT_SECRET and DB_PASSWORD, from a
+// secret manager
+type SecretProvider interface {
+	Name() string
+	// Fetch returns every secret of the bundle by name
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+// VaultSecretProvider reads a HashiCorp Vault KV secret over the HTTP API. Path is the API path of
+// the secret without /v1, e.g. "secret/data/convertyapi" for a KV v2 mount; v1 mounts work too.
+type VaultSecretProvider struct {
+	Addr  string
+	Token string
+	Path  string
+	// Namespace is the Vault Enterprise namespace, if any
+	Namespace string
+	Client    *http.Client
+}
+
+func (p *VaultSecretProvider) Name() string { return "vault" }
+
+func (p *VaultSecretProvider) Fetch(ctx context.Context) (map[string]string, error) {
+	endpoint := strings.TrimRight(p.Addr, "/") + "/v1/" + strings.TrimLeft(p.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+	body, err := fetchSecretBody(p.Client, req, "Vault")
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid Vault response: %v", err)
+	}
+	data := response.Data
+	// KV v2 nests the values under data.data next to data.metadata
+	if nested, ok := data["data"]; ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nil
+			if err := json.Unmarshal(nested, &data); err != nil {
+				return nil, fmt.Errorf("invalid Vault secret data: %v", err)
+			}
+		}
+	}
+	return secretValues(data)
+}
+
+// AWSSecretProvider reads an AWS Secrets Manager secret whose SecretString is a JSON object of
+// key/value pairs, the shape the console creates
+type AWSSecretProvider struct {
+	Region       string
+	SecretID     string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// Endpoint overrides https://secretsmanager.<region>.amazonaws.com, e.g. for a VPC endpoint
+	Endpoint string
+	Client   *http.Client
+}
+
+func (p *AWSSecretProvider) Name() string { return "aws" }
+
+func (p *AWSSecretProvider) Fetch(ctx context.Context) (map[string]string, error) {
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", p.Region)
+	}
+	payload, _ := json.Marshal(map[string]string{"SecretId": p.SecretID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, payload, time.Now().UTC(), awsCredentials{AccessKey: p.AccessKey, SecretKey: p.SecretKey, SessionToken: p.SessionToken}, p.Region, "secretsmanager")
+	body, err := fetchSecretBody(p.Client, req, "AWS Secrets Manager")
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid AWS Secrets Manager response: %v", err)
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(response.SecretString), &data); err != nil {
+		return nil, fmt.Errorf("secret %s must be a JSON object of key/value pairs", p.SecretID)
+	}
+	return secretValues(data)
+}
+
+// fetchSecretBody sends a secret manager request and returns the body of a successful response
+func fetchSecretBody(client *http.Client, req *http.Request, manager string) ([]byte, error) {
+	if client == nil {
+		client = NewHTTPClient(10 * time.Second)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s request failed: %v", manager, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %v", manager, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Error bodies name the secret but never carry its values
+		return nil, fmt.Errorf("%s answered with status %d: %s", manager, resp.StatusCode, strings.TrimSpace(string(body[:min(len(body), 512)])))
+	}
+	return body, nil
+}
+
+// secretValues keeps strings as they are and other JSON values as their JSON text
+func secretValues(data map[string]json.RawMessage) (map[string]string, error) {
+	values := make(map[string]string, len(data))
+	for name, raw := range data {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// SecretCache keeps the secrets of a provider in memory and refreshes them periodically, telling
+// the subscribers which ones were rotated. The last values read stay in use while the provider
+// cannot be reached.
+type SecretCache struct {
+	provider SecretProvider
+	mu       sync.Mutex
+	values   map[string]string
+	// fetchedAt is when the values were last read; zero once they are invalidated
+	fetchedAt time.Time
+	onChange  []func(name, value string)
+	// stale wakes Watch up when the cache is invalidated
+	stale chan struct{}
+}
+
+// NewSecretCache creates an empty cache of provider's secrets
+func NewSecretCache(provider SecretProvider) *SecretCache {
+	return &SecretCache{provider: provider, values: map[string]string{}, stale: make(chan struct{}, 1)}
+}
+
+// Provider names the secret manager the cache reads
+func (c *SecretCache) Provider() string {
+	return c.provider.Name()
+}
+
+// Get returns a cached secret
+func (c *SecretCache) Get(name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.values[name]
+	return value, ok
+}
+
+// OnChange subscribes fn to the secrets whose value changes on a refresh
+func (c *SecretCache) OnChange(fn func(name, value string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onChange = append(c.onChange, fn)
+}
+
+// Refresh reads the secrets again and returns the names whose value changed, in order. Subscribers are
+// called for them after the first read; secrets the provider no longer returns keep their last value.
+func (c *SecretCache) Refresh(ctx context.Context) ([]string, error) {
+	values, err := c.provider.Fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets from %s: %v", c.provider.Name(), err)
+	}
+	c.mu.Lock()
+	first := c.fetchedAt.IsZero() && len(c.values) == 0
+	var changed []string
+	for name, value := range values {
+		if current, ok := c.values[name]; !ok || current != value {
+			c.values[name] = value
+			changed = append(changed, name)
+		}
+	}
+	c.fetchedAt = time.Now()
+	subscribers := append([]func(string, string){}, c.onChange...)
+	c.mu.Unlock()
+
+	sort.Strings(changed)
+	if !first {
+		for _, name := range changed {
+			for _, fn := range subscribers {
+				fn(name, values[name])
+			}
+		}
+	}
+	return changed, nil
+}
+
+// Invalidate marks the secrets matching pattern stale, making Watch read them again right away, and
+// returns how many there are
+func (c *SecretCache) Invalidate(pattern string) int {
+	match := keyMatcher(pattern)
+	c.mu.Lock()
+	matched := 0
+	for name := range c.values {
+		if match(name) {
+			matched++
+		}
+	}
+	if matched > 0 {
+		c.fetchedAt = time.Time{}
+	}
+	c.mu.Unlock()
+	if matched > 0 {
+		select {
+		case c.stale <- struct{}{}:
+		default:
+		}
+	}
+	return matched
+}
+
+// Watch refreshes the secrets every interval, and as soon as they are invalidated, until ctx is done
+func (c *SecretCache) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.stale:
+		}
+		changed, err := c.Refresh(ctx)
+		if err != nil {
+			log.Printf("Keeping the current secrets: %v", err)
+			continue
+		}
+		if len(changed) > 0 {
+			log.Printf("Rotated secrets from %s: %s", c.provider.Name(), strings.Join(changed, ", "))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVaultSecretProvider(t *testing.T) {
+	for path, body := range map[string]string{
+		"/v1/secret/data/convertyapi": `{"data": {"data": {"CLIENT_SECRET": "s1", "DB_PASSWORD": "p1", "PORT": 5432}, "metadata": {"version": 3}}}`,
+		"/v1/kv/convertyapi":          `{"data": {"CLIENT_SECRET": "s1", "DB_PASSWORD": "p1", "PORT": 5432}}`,
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Vault-Token") != "tok" || r.Header.Get("X-Vault-Namespace") != "shop" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if r.URL.Path != path {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(body))
+		}))
+		provider := &VaultSecretProvider{Addr: server.URL + "/", Token: "tok", Path: strings.TrimPrefix(path, "/v1/"), Namespace: "shop"}
+		values, err := provider.Fetch(context.Background())
+		server.Close()
+		want := map[string]string{"CLIENT_SECRET": "s1", "DB_PASSWORD": "p1", "PORT": "5432"}
+		if err != nil || !reflect.DeepEqual(values, want) {
+			t.Errorf("%s: %v, %v", path, values, err)
+		}
+	}
+}
+
+func TestAWSSecretProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]string
+		json.NewDecoder(r.Body).Decode(&input)
+		auth := r.Header.Get("Authorization")
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" || input["SecretId"] != "prod/convertyapi" ||
+			!strings.Contains(auth, "/eu-west-1/secretsmanager/aws4_request") || !strings.Contains(auth, "x-amz-security-token") ||
+			r.Header.Get("X-Amz-Security-Token") != "session" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "ValidationException"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"SecretString": `{"DB_PASSWORD": "p2"}`})
+	}))
+	defer server.Close()
+
+	provider := &AWSSecretProvider{Region: "eu-west-1", SecretID: "prod/convertyapi", AccessKey: "AKID", SecretKey: "secret", SessionToken: "session", Endpoint: server.URL}
+	values, err := provider.Fetch(context.Background())
+	if err != nil || values["DB_PASSWORD"] != "p2" {
+		t.Errorf("fetch: %v, %v", values, err)
+	}
+	provider.SecretID = "other"
+	if _, err := provider.Fetch(context.Background()); err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Errorf("rejected request: %v", err)
+	}
+}
+
+// staticSecrets is a provider whose values the test changes between refreshes
+type staticSecrets struct {
+	values map[string]string
+	err    error
+}
+
+func (p *staticSecrets) Name() string { return "static" }
+
+func (p *staticSecrets) Fetch(ctx context.Context) (map[string]string, error) {
+	values := map[string]string{}
+	for name, value := range p.values {
+		values[name] = value
+	}
+	return values, p.err
+}
+
+func TestSecretCacheRotation(t *testing.T) {
+	provider := &staticSecrets{values: map[string]string{"CLIENT_SECRET": "a", "DB_PASSWORD": "b"}}
+	cache := NewSecretCache(provider)
+	var rotated []string
+	cache.OnChange(func(name, value string) { rotated = append(rotated, name+"="+value) })
+
+	// The first read is the initial load, not a rotation
+	if changed, err := cache.Refresh(context.Background()); err != nil || len(changed) != 2 || len(rotated) != 0 {
+		t.Fatalf("first refresh: %v, %v, rotated %v", changed, err, rotated)
+	}
+	provider.values["DB_PASSWORD"] = "c"
+	if changed, _ := cache.Refresh(context.Background()); !reflect.DeepEqual(changed, []string{"DB_PASSWORD"}) || !reflect.DeepEqual(rotated, []string{"DB_PASSWORD=c"}) {
+		t.Errorf("rotation: changed %v, rotated %v", changed, rotated)
+	}
+
+	// A failing provider keeps the last values
+	provider.err = errors.New("sealed")
+	if _, err := cache.Refresh(context.Background()); err == nil {
+		t.Error("provider error not reported")
+	}
+	if value, ok := cache.Get("DB_PASSWORD"); !ok || value != "c" {
+		t.Errorf("value after a failed refresh: %q", value)
+	}
+
+	if n := cache.Invalidate("DB_*"); n != 1 {
+		t.Errorf("invalidated %d secrets", n)
+	}
+	select {
+	case <-cache.stale:
+	default:
+		t.Error("invalidation did not wake the watcher")
+	}
+}
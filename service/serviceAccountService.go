@@ -0,0 +1,307 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Service account permissions; each grants a narrow slice of the tenant API
+const (
+	PermRecordsRead    = "records:read"
+	PermRecordsWrite   = "records:write"
+	PermOrdersRead     = "orders:read"
+	PermOrdersWrite    = "orders:write"
+	PermCatalogRead    = "catalog:read"
+	PermCatalogWrite   = "catalog:write"
+	PermCustomersRead  = "customers:read"
+	PermCustomersWrite = "customers:write"
+	PermReportsRead    = "reports:read"
+)
+
+// ServiceAccountKeyPrefix marks service account keys so they can be told apart from tenant keys
+const ServiceAccountKeyPrefix = "sa_"
+
+// keyTouchInterval throttles last-used updates to one write per key and interval
+const keyTouchInterval = time.Minute
+
+// ErrServiceAccountKeyInvalid is returned for unknown, expired or revoked keys and disabled accounts
+var ErrServiceAccountKeyInvalid = errors.New("invalid service account key")
+
+var knownPermissions = map[string]bool{
+	PermRecordsRead: true, PermRecordsWrite: true, PermOrdersRead: true, PermOrdersWrite: true,
+	PermCatalogRead: true, PermCatalogWrite: true, PermCustomersRead: true, PermCustomersWrite: true,
+	PermReportsRead: true,
+}
+
+// PermissionPresets name the permission sets of the usual integrations
+var PermissionPresets = map[string][]string{
+	// capture lets a chatbot file records and nothing else
+	"capture": {PermRecordsWrite},
+	// reports fits analytics jobs and exports
+	"reports": {PermReportsRead, PermOrdersRead},
+	// chatbot answers customers about their orders and the catalog, and files records
+	"chatbot": {PermRecordsWrite, PermOrdersRead, PermCatalogRead, PermCustomersRead},
+}
+
+// ServiceAccount is a non-human client of a tenant, such as the chatbot or an automation platform
+type ServiceAccount struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Tenant      string     `gorm:"not null;uniqueIndex:idx_service_accounts_name" json:"tenant"`
+	Name        string     `gorm:"not null;uniqueIndex:idx_service_accounts_name" json:"name"`
+	Description string     `json:"description,omitempty"`
+	Permissions string     `gorm:"not null" json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DisabledAt  *time.Time `json:"disabled_at,omitempty"`
+}
+
+// TableName specifies the table name for ServiceAccount
+func (ServiceAccount) TableName() string {
+	return "public.service_accounts"
+}
+
+// PermissionList returns the account's permissions
+func (a ServiceAccount) PermissionList() []string {
+	return strings.Fields(a.Permissions)
+}
+
+// Allows reports whether the account holds permission
+func (a ServiceAccount) Allows(permission string) bool {
+	for _, granted := range a.PermissionList() {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceAccountKey is one credential of a service account. Keys are stored hashed; rotation
+// lets the previous key keep working until ExpiresAt so clients can switch without downtime.
+type ServiceAccountKey struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	AccountID uint   `gorm:"not null;index" json:"account_id"`
+	KeyHash   string `gorm:"not null;uniqueIndex" json:"-"`
+	// Prefix is the start of the key, shown so operators can tell keys apart
+	Prefix     string     `gorm:"not null" json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// TableName specifies the table name for ServiceAccountKey
+func (ServiceAccountKey) TableName() string {
+	return "public.service_account_keys"
+}
+
+// Active reports whether the key may be used at now
+func (k ServiceAccountKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// ServiceAccountService defines the interface for service accounts and their keys
+type ServiceAccountService interface {
+	// CreateAccount registers an account and returns its first key, which is only shown once
+	CreateAccount(tenant, name, description string, permissions []string) (ServiceAccount, string, error)
+	ListAccounts(tenant string) ([]ServiceAccount, error)
+	ListKeys(accountID uint) ([]ServiceAccountKey, error)
+	// RotateKey issues a new key; the current keys keep working for grace
+	RotateKey(accountID uint, grace time.Duration) (string, error)
+	RevokeKey(accountID, keyID uint) error
+	// DisableAccount stops the account and revokes its keys
+	DisableAccount(accountID uint) error
+	ResolveKey(key string) (ServiceAccount, error)
+}
+
+// GormServiceAccountService implements ServiceAccountService using GORM
+type GormServiceAccountService struct {
+	db *gorm.DB
+}
+
+// NewGormServiceAccountService creates a new GormServiceAccountService
+func NewGormServiceAccountService(db *gorm.DB) ServiceAccountService {
+	return &GormServiceAccountService{db: db}
+}
+
+// ExpandPermissions resolves preset names and validates the result, removing duplicates
+func ExpandPermissions(requested []string) ([]string, error) {
+	set := map[string]bool{}
+	for _, permission := range requested {
+		if preset, ok := PermissionPresets[permission]; ok {
+			for _, p := range preset {
+				set[p] = true
+			}
+			continue
+		}
+		if !knownPermissions[permission] {
+			return nil, fmt.Errorf("unknown permission %q", permission)
+		}
+		set[permission] = true
+	}
+	if len(set) == 0 {
+		return nil, fmt.Errorf("at least one permission is required")
+	}
+	expanded := make([]string, 0, len(set))
+	for permission := range set {
+		expanded = append(expanded, permission)
+	}
+	sort.Strings(expanded)
+	return expanded, nil
+}
+
+// newServiceAccountKey generates a key and its stored record
+func newServiceAccountKey(accountID uint) (string, ServiceAccountKey, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", ServiceAccountKey{}, fmt.Errorf("failed to generate key: %v", err)
+	}
+	key := ServiceAccountKeyPrefix + hex.EncodeToString(b)
+	return key, ServiceAccountKey{AccountID: accountID, KeyHash: hashAPIKey(key), Prefix: key[:11]}, nil
+}
+
+// CreateAccount registers a service account of a tenant with its first key
+func (s *GormServiceAccountService) CreateAccount(tenant, name, description string, permissions []string) (ServiceAccount, string, error) {
+	if tenant == "" || name == "" {
+		return ServiceAccount{}, "", fmt.Errorf("tenant and name are required")
+	}
+	expanded, err := ExpandPermissions(permissions)
+	if err != nil {
+		return ServiceAccount{}, "", err
+	}
+	account := ServiceAccount{Tenant: tenant, Name: name, Description: description, Permissions: strings.Join(expanded, " ")}
+	var key string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&account).Error; err != nil {
+			return fmt.Errorf("failed to create service account: %v", err)
+		}
+		var record ServiceAccountKey
+		var err error
+		if key, record, err = newServiceAccountKey(account.ID); err != nil {
+			return err
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return fmt.Errorf("failed to store key: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ServiceAccount{}, "", err
+	}
+	return account, key, nil
+}
+
+// ListAccounts fetches the service accounts of a tenant, or of every tenant when tenant is empty
+func (s *GormServiceAccountService) ListAccounts(tenant string) ([]ServiceAccount, error) {
+	query := s.db.Order("tenant, name")
+	if tenant != "" {
+		query = query.Where("tenant = ?", tenant)
+	}
+	var accounts []ServiceAccount
+	if err := query.Find(&accounts).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch service accounts: %v", err)
+	}
+	return accounts, nil
+}
+
+// ListKeys fetches the keys of an account, newest first
+func (s *GormServiceAccountService) ListKeys(accountID uint) ([]ServiceAccountKey, error) {
+	var keys []ServiceAccountKey
+	if err := s.db.Where("account_id = ?", accountID).Order("id desc").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch keys: %v", err)
+	}
+	return keys, nil
+}
+
+// getAccount loads an account that has not been disabled
+func (s *GormServiceAccountService) getAccount(accountID uint) (ServiceAccount, error) {
+	var account ServiceAccount
+	if err := s.db.Where("id = ? AND disabled_at IS NULL", accountID).First(&account).Error; err != nil {
+		return ServiceAccount{}, fmt.Errorf("service account %d not found: %v", accountID, err)
+	}
+	return account, nil
+}
+
+// RotateKey adds a key and makes the active keys expire after grace
+func (s *GormServiceAccountService) RotateKey(accountID uint, grace time.Duration) (string, error) {
+	if _, err := s.getAccount(accountID); err != nil {
+		return "", err
+	}
+	key, record, err := newServiceAccountKey(accountID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ServiceAccountKey{}).
+			Where("account_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", accountID, now.Add(grace)).
+			Update("expires_at", now.Add(grace)).Error; err != nil {
+			return fmt.Errorf("failed to expire previous keys: %v", err)
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return fmt.Errorf("failed to store key: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// RevokeKey ends one key immediately
+func (s *GormServiceAccountService) RevokeKey(accountID, keyID uint) error {
+	result := s.db.Model(&ServiceAccountKey{}).Where("id = ? AND account_id = ? AND revoked_at IS NULL", keyID, accountID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke key: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("key %d of service account %d not found", keyID, accountID)
+	}
+	return nil
+}
+
+// DisableAccount marks the account disabled and revokes all of its keys
+func (s *GormServiceAccountService) DisableAccount(accountID uint) error {
+	if _, err := s.getAccount(accountID); err != nil {
+		return err
+	}
+	now := time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ServiceAccount{}).Where("id = ?", accountID).Update("disabled_at", now).Error; err != nil {
+			return fmt.Errorf("failed to disable service account: %v", err)
+		}
+		if err := tx.Model(&ServiceAccountKey{}).Where("account_id = ? AND revoked_at IS NULL", accountID).
+			Update("revoked_at", now).Error; err != nil {
+			return fmt.Errorf("failed to revoke keys: %v", err)
+		}
+		return nil
+	})
+}
+
+// ResolveKey returns the enabled account an active key belongs to
+func (s *GormServiceAccountService) ResolveKey(key string) (ServiceAccount, error) {
+	var record ServiceAccountKey
+	result := s.db.Where("key_hash = ?", hashAPIKey(key)).Limit(1).Find(&record)
+	if result.Error != nil {
+		return ServiceAccount{}, fmt.Errorf("failed to look up key: %v", result.Error)
+	}
+	now := time.Now()
+	if result.RowsAffected == 0 || !record.Active(now) {
+		return ServiceAccount{}, ErrServiceAccountKeyInvalid
+	}
+	account, err := s.getAccount(record.AccountID)
+	if err != nil {
+		return ServiceAccount{}, ErrServiceAccountKeyInvalid
+	}
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) > keyTouchInterval {
+		s.db.Model(&record).Update("last_used_at", now)
+	}
+	return account, nil
+}
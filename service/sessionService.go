@@ -0,0 +1,225 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Session kinds
+const (
+	SessionStore = "store"
+	SessionAdmin = "admin"
+	// SessionUser backs the refresh token of an operator logged in with a password
+	SessionUser = "user"
+)
+
+// ErrSessionInactive is returned for unknown, expired or revoked sessions
+var ErrSessionInactive = errors.New("session is no longer active")
+
+// sessionTouchInterval throttles last-seen updates to one write per session and interval
+const sessionTouchInterval = time.Minute
+
+// UserSession is a server-side browser session of a store user or a staff member.
+// The signed session cookie carries its ID, so revoking the row ends the session.
+type UserSession struct {
+	ID       string `gorm:"primaryKey;size:64" json:"id"`
+	Kind     string `gorm:"not null;index:idx_sessions_subject" json:"kind"`
+	Subject  string `gorm:"not null;index:idx_sessions_subject" json:"subject"`
+	Tenant   string `json:"tenant,omitempty"`
+	StoreID  string `json:"store_id,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Remember bool   `gorm:"not null;default:false" json:"remember"`
+	// SecondFactor is set once the staff member behind an admin session entered a TOTP code
+	SecondFactor bool       `gorm:"not null;default:false" json:"second_factor"`
+	UserAgent    string     `json:"user_agent,omitempty"`
+	IPAddress    string     `json:"ip_address,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastSeenAt   time.Time  `json:"last_seen_at"`
+	ExpiresAt    time.Time  `gorm:"not null;index" json:"expires_at"`
+	RevokedAt    *time.Time `gorm:"index" json:"revoked_at,omitempty"`
+	RevokedBy    string     `json:"revoked_by,omitempty"`
+}
+
+// TableName specifies the table name for UserSession
+func (UserSession) TableName() string {
+	return "public.user_sessions"
+}
+
+// Active reports whether the session may still be used at now
+func (s UserSession) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// SessionService defines the interface for server-side sessions
+type SessionService interface {
+	// Create stores a new session; when the subject then has more than maxPerSubject active
+	// sessions of the kind, the oldest ones are revoked (zero means no limit)
+	Create(session UserSession, maxPerSubject int) (UserSession, error)
+	// Validate returns the active session with this ID and records that it was seen
+	Validate(id string) (UserSession, error)
+	ListActive(kind, subject string) ([]UserSession, error)
+	Revoke(id, actor string) (UserSession, error)
+	// Consume revokes an active session and returns it in one statement, so that of a refresh token
+	// is used once even when the token is presented twice at the same time
+	Consume(id, actor string) (UserSession, error)
+	RevokeSubject(kind, subject, actor string) (int64, error)
+	// MarkSecondFactor records that the session passed two-factor verification
+	MarkSecondFactor(id string) error
+}
+
+// GormSessionService implements SessionService using GORM
+type GormSessionService struct {
+	db *gorm.DB
+}
+
+// NewGormSessionService creates a new GormSessionService
+func NewGormSessionService(db *gorm.DB) SessionService {
+	return &GormSessionService{db: db}
+}
+
+// newSessionID returns a random session identifier
+func newSessionID() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Create stores a new session and enforces the per-subject limit
+func (s *GormSessionService) Create(session UserSession, maxPerSubject int) (UserSession, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return UserSession{}, fmt.Errorf("failed to generate session ID: %v", err)
+	}
+	now := time.Now()
+	session.ID = id
+	session.CreatedAt = now
+	session.LastSeenAt = now
+	if err := s.db.Create(&session).Error; err != nil {
+		return UserSession{}, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	if maxPerSubject > 0 {
+		var active []UserSession
+		if err := s.activeScope(session.Kind, session.Subject, now).Order("created_at desc").Find(&active).Error; err != nil {
+			return session, fmt.Errorf("failed to count sessions: %v", err)
+		}
+		if len(active) > maxPerSubject {
+			var oldest []string
+			for _, old := range active[maxPerSubject:] {
+				oldest = append(oldest, old.ID)
+			}
+			if err := s.db.Model(&UserSession{}).Where("id IN ?", oldest).
+				Updates(map[string]interface{}{"revoked_at": now, "revoked_by": "session_limit"}).Error; err != nil {
+				return session, fmt.Errorf("failed to revoke old sessions: %v", err)
+			}
+		}
+	}
+	return session, nil
+}
+
+// Validate returns the session if it is neither expired nor revoked
+func (s *GormSessionService) Validate(id string) (UserSession, error) {
+	var session UserSession
+	result := s.db.Where("id = ?", id).Limit(1).Find(&session)
+	if result.Error != nil {
+		return UserSession{}, fmt.Errorf("failed to load session: %v", result.Error)
+	}
+	now := time.Now()
+	if result.RowsAffected == 0 || !session.Active(now) {
+		return UserSession{}, ErrSessionInactive
+	}
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		s.db.Model(&session).Update("last_seen_at", now)
+		session.LastSeenAt = now
+	}
+	return session, nil
+}
+
+// activeScope selects the active sessions of a kind and, when given, a subject
+func (s *GormSessionService) activeScope(kind, subject string, now time.Time) *gorm.DB {
+	query := s.db.Model(&UserSession{}).Where("revoked_at IS NULL AND expires_at > ?", now)
+	if kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if subject != "" {
+		query = query.Where("subject = ?", subject)
+	}
+	return query
+}
+
+// ListActive fetches active sessions, most recently seen first
+func (s *GormSessionService) ListActive(kind, subject string) ([]UserSession, error) {
+	var sessions []UserSession
+	if err := s.activeScope(kind, subject, time.Now()).Order("last_seen_at desc").Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch sessions: %v", err)
+	}
+	return sessions, nil
+}
+
+// Revoke terminates one session
+func (s *GormSessionService) Revoke(id, actor string) (UserSession, error) {
+	var session UserSession
+	if err := s.db.Where("id = ?", id).First(&session).Error; err != nil {
+		return UserSession{}, fmt.Errorf("session %s not found: %v", id, err)
+	}
+	if session.RevokedAt != nil {
+		return session, nil
+	}
+	now := time.Now()
+	if err := s.db.Model(&session).Updates(map[string]interface{}{"revoked_at": now, "revoked_by": actor}).Error; err != nil {
+		return UserSession{}, fmt.Errorf("failed to revoke session: %v", err)
+	}
+	session.RevokedAt = &now
+	session.RevokedBy = actor
+	return session, nil
+}
+
+// Consume revokes an active session with a conditional UPDATE ... RETURNING
+func (s *GormSessionService) Consume(id, actor string) (UserSession, error) {
+	var session UserSession
+	now := time.Now()
+	result := s.db.Model(&session).Clauses(clause.Returning{}).
+		Where("id = ? AND revoked_at IS NULL AND expires_at > ?", id, now).
+		Updates(map[string]interface{}{"revoked_at": now, "revoked_by": actor})
+	if result.Error != nil {
+		return UserSession{}, fmt.Errorf("failed to consume session: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return UserSession{}, ErrSessionInactive
+	}
+	return session, nil
+}
+
+// RevokeSubject terminates every active session of a subject and returns how many were ended
+func (s *GormSessionService) RevokeSubject(kind, subject, actor string) (int64, error) {
+	if subject == "" {
+		return 0, fmt.Errorf("subject is required")
+	}
+	now := time.Now()
+	result := s.activeScope(kind, subject, now).Updates(map[string]interface{}{"revoked_at": now, "revoked_by": actor})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// MarkSecondFactor flags an active session as verified by a second factor
+func (s *GormSessionService) MarkSecondFactor(id string) error {
+	result := s.activeScope("", "", time.Now()).Where("id = ?", id).Update("second_factor", true)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update session: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionInactive
+	}
+	return nil
+}
@@ -0,0 +1,416 @@
+package service
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+	"gorm.io/gorm"
+)
+
+// StateArchiveFormat names the archives ExportState writes
+const StateArchiveFormat = "convertyapi-state"
+
+// stateArchiveVersion changes when the layout of the archive does
+const stateArchiveVersion = 1
+
+// stateBatchSize is how many rows are read or inserted at a time
+const stateBatchSize = 1000
+
+// ErrStatePassphrase is returned when an encrypted archive cannot be opened
+var ErrStatePassphrase = errors.New("the archive is encrypted and the passphrase is missing or wrong")
+
+// StateTable is a table copied by a state archive
+type StateTable struct {
+	// Name identifies the table in the archive
+	Name string
+	// Model is the GORM model of a row, e.g. Data{}
+	Model interface{}
+	// Order sorts the rows on export; "id" also resets the id sequence on import
+	Order string
+}
+
+// StateTables are the tables an environment clone copies, in import order. Tenants come first: the
+// other rows refer to them and are moved to the tenant of the same slug in the target.
+func StateTables() []StateTable {
+	return []StateTable{
+		{Name: "tenants", Model: Tenant{}, Order: "id"},
+		{Name: "record_schemas", Model: RecordSchema{}, Order: "id"},
+		{Name: "classification_rules", Model: ClassificationRule{}, Order: "id"},
+		{Name: "tags", Model: Tag{}, Order: "id"},
+		{Name: "interactions", Model: Data{}, Order: "id"},
+		{Name: "status_history", Model: StatusChange{}, Order: "id"},
+		{Name: "record_tags", Model: RecordTag{}, Order: "record_id, tag_id"},
+	}
+}
+
+// StateManifest heads a state archive
+type StateManifest struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Tables lists the tables of the archive, empty ones included, in import order
+	Tables []string `json:"tables"`
+	// Salt derives the key of the rows from the passphrase; empty when the archive is in clear
+	Salt []byte `json:"salt,omitempty"`
+}
+
+// stateLine is one row of a state archive, in clear or sealed
+type stateLine struct {
+	Table  string          `json:"table"`
+	Row    json.RawMessage `json:"row,omitempty"`
+	Sealed []byte          `json:"sealed,omitempty"`
+}
+
+// StateImport is the outcome of ImportState
+type StateImport struct {
+	// Rows counts the restored rows by table
+	Rows map[string]int `json:"rows"`
+	// TenantKeys are the API keys of the tenants the import created, by slug; the keys of the
+	// source environment are never archived
+	TenantKeys map[string]string `json:"tenant_keys,omitempty"`
+}
+
+// stateKey derives the row encryption key from a passphrase
+func stateKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive the archive key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ExportState writes the rows of tables to w as a gzipped archive of JSON lines: the manifest, then
+// every row in table order. Every row is encrypted with passphrase when one is given. Records are
+// written with their personal data decrypted, so the target encrypts it with its own key. The tables
+// are read in one repeatable-read transaction, so the archive is a consistent snapshot. It returns
+// the rows written by table.
+func ExportState(db *gorm.DB, w io.Writer, tables []StateTable, passphrase string) (map[string]int, error) {
+	manifest := StateManifest{Format: StateArchiveFormat, Version: stateArchiveVersion, CreatedAt: time.Now().UTC()}
+	for _, table := range tables {
+		manifest.Tables = append(manifest.Tables, table.Name)
+	}
+	var aead cipher.AEAD
+	if passphrase != "" {
+		manifest.Salt = make([]byte, 16)
+		if _, err := rand.Read(manifest.Salt); err != nil {
+			return nil, fmt.Errorf("failed to generate the archive salt: %v", err)
+		}
+		var err error
+		if aead, err = stateKey(passphrase, manifest.Salt); err != nil {
+			return nil, err
+		}
+	}
+
+	zw := gzip.NewWriter(w)
+	encoder := json.NewEncoder(zw)
+	if err := encoder.Encode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to write the archive: %v", err)
+	}
+	counts := map[string]int{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range tables {
+			if err := exportStateTable(tx, encoder, aead, table, counts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write the archive: %v", err)
+	}
+	return counts, nil
+}
+
+// exportStateTable writes the rows of a table, sealed when aead is set, and counts them
+func exportStateTable(tx *gorm.DB, encoder *json.Encoder, aead cipher.AEAD, table StateTable, counts map[string]int) error {
+	rowType := reflect.TypeOf(table.Model)
+	for offset := 0; ; offset += stateBatchSize {
+		batch := reflect.New(reflect.SliceOf(rowType))
+		if err := tx.Unscoped().Order(table.Order).Limit(stateBatchSize).Offset(offset).Find(batch.Interface()).Error; err != nil {
+			return fmt.Errorf("failed to read %s: %v", table.Name, err)
+		}
+		rows := batch.Elem()
+		for i := 0; i < rows.Len(); i++ {
+			row, err := json.Marshal(rows.Index(i).Interface())
+			if err != nil {
+				return fmt.Errorf("failed to encode a row of %s: %v", table.Name, err)
+			}
+			line := stateLine{Table: table.Name, Row: row}
+			if aead != nil {
+				if line.Sealed, err = sealStateRow(aead, row); err != nil {
+					return err
+				}
+				line.Row = nil
+			}
+			if err := encoder.Encode(line); err != nil {
+				return fmt.Errorf("failed to write the archive: %v", err)
+			}
+		}
+		counts[table.Name] += rows.Len()
+		if rows.Len() < stateBatchSize {
+			return nil
+		}
+	}
+}
+
+// sealStateRow encrypts a row, the nonce in front
+func sealStateRow(aead cipher.AEAD, row []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate a nonce: %v", err)
+	}
+	return aead.Seal(nonce, nonce, row, nil), nil
+}
+
+// openStateRow decrypts a row sealed by sealStateRow
+func openStateRow(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if aead == nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrStatePassphrase
+	}
+	row, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrStatePassphrase
+	}
+	return row, nil
+}
+
+// ImportState restores an archive written by ExportState, all or nothing. Tables of the archive that
+// tables does not list are refused. The target tables must be empty unless replace is set, in which
+// case their rows are deleted first; tenants are matched by slug instead, and the missing ones
+// created with a new API key. Rows keep their ids, so the links between records, their versions,
+// tags and status history hold.
+func ImportState(db *gorm.DB, r io.Reader, tables []StateTable, passphrase string, replace bool) (StateImport, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return StateImport{}, fmt.Errorf("%w: not a state archive: %v", ErrValidation, err)
+	}
+	decoder := json.NewDecoder(bufio.NewReader(zr))
+	var manifest StateManifest
+	if err := decoder.Decode(&manifest); err != nil || manifest.Format != StateArchiveFormat {
+		return StateImport{}, fmt.Errorf("%w: not a state archive", ErrValidation)
+	}
+	if manifest.Version != stateArchiveVersion {
+		return StateImport{}, fmt.Errorf("%w: archive version %d is not supported", ErrValidation, manifest.Version)
+	}
+	known := map[string]StateTable{}
+	for _, table := range tables {
+		known[table.Name] = table
+	}
+	for _, name := range manifest.Tables {
+		if _, ok := known[name]; !ok {
+			return StateImport{}, fmt.Errorf("%w: the archive has table %q, which this import does not restore", ErrValidation, name)
+		}
+	}
+	var aead cipher.AEAD
+	if len(manifest.Salt) > 0 {
+		if passphrase == "" {
+			return StateImport{}, ErrStatePassphrase
+		}
+		if aead, err = stateKey(passphrase, manifest.Salt); err != nil {
+			return StateImport{}, err
+		}
+	}
+
+	result := StateImport{Rows: map[string]int{}, TenantKeys: map[string]string{}}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, name := range manifest.Tables {
+			if name == "tenants" {
+				continue
+			}
+			if err := prepareStateTable(tx, known[name], replace); err != nil {
+				return err
+			}
+		}
+		restore := stateRestore{tx: tx, tenants: map[uint]uint{}, result: &result}
+		for {
+			var line stateLine
+			if err := decoder.Decode(&line); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("%w: corrupt archive: %v", ErrValidation, err)
+			}
+			table, ok := known[line.Table]
+			if !ok {
+				return fmt.Errorf("%w: the archive has table %q, which this import does not restore", ErrValidation, line.Table)
+			}
+			row := []byte(line.Row)
+			if aead != nil || line.Sealed != nil {
+				if row, err = openStateRow(aead, line.Sealed); err != nil {
+					return err
+				}
+			}
+			if err := restore.add(table, row); err != nil {
+				return err
+			}
+		}
+		if err := restore.flush(); err != nil {
+			return err
+		}
+		for _, name := range manifest.Tables {
+			if table := known[name]; table.Order == "id" && name != "tenants" {
+				if err := resetStateSequence(tx, table); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return StateImport{}, err
+	}
+	return result, nil
+}
+
+// prepareStateTable checks that a table is empty, or empties it when replace is set
+func prepareStateTable(tx *gorm.DB, table StateTable, replace bool) error {
+	model := reflect.New(reflect.TypeOf(table.Model)).Interface()
+	if replace {
+		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(model).Error; err != nil {
+			return fmt.Errorf("failed to empty %s: %v", table.Name, err)
+		}
+		return nil
+	}
+	var count int64
+	if err := tx.Unscoped().Model(model).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to count %s: %v", table.Name, err)
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %s already has %d rows, import with replace to overwrite them", ErrValidation, table.Name, count)
+	}
+	return nil
+}
+
+// resetStateSequence moves the id sequence of a table past the imported ids
+func resetStateSequence(tx *gorm.DB, table StateTable) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(reflect.New(reflect.TypeOf(table.Model)).Interface()); err != nil {
+		return err
+	}
+	name := stmt.Schema.Table
+	query := fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE((SELECT MAX(id) FROM %s), 0) + 1, false)", name, name)
+	if err := tx.Exec(query).Error; err != nil {
+		return fmt.Errorf("failed to reset the id sequence of %s: %v", table.Name, err)
+	}
+	return nil
+}
+
+// stateRestore inserts the rows of an archive in batches, moving them to the target's tenants
+type stateRestore struct {
+	tx *gorm.DB
+	// tenants maps the tenant ids of the archive to those of the target
+	tenants map[uint]uint
+	result  *StateImport
+	table   StateTable
+	pending reflect.Value
+}
+
+// stateRestored is implemented by the rows whose derived columns are left out of the archive and
+// recomputed on import, like the content hash of a record, keyed by the PII key of the environment
+type stateRestored interface {
+	restoreState() error
+}
+
+// restoreState recomputes the content hash of a record with the PII key of the target; the lookup
+// hashes are recomputed by BeforeSave when the record is inserted
+func (d *Data) restoreState() error {
+	hash, err := recordContentHash(d.UserID, d.Type, d.Details)
+	if err != nil {
+		return err
+	}
+	d.ContentHash = hash
+	return nil
+}
+
+// add queues a row, inserting the queue when it is full or the table changes
+func (s *stateRestore) add(table StateTable, row []byte) error {
+	if table.Name == "tenants" {
+		return s.tenant(row)
+	}
+	if s.pending.IsValid() && (s.table.Name != table.Name || s.pending.Len() >= stateBatchSize) {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	if !s.pending.IsValid() {
+		s.table = table
+		s.pending = reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(table.Model)), 0, stateBatchSize)
+	}
+	value := reflect.New(reflect.TypeOf(table.Model))
+	if err := json.Unmarshal(row, value.Interface()); err != nil {
+		return fmt.Errorf("%w: corrupt row in %s: %v", ErrValidation, table.Name, err)
+	}
+	if restored, ok := value.Interface().(stateRestored); ok {
+		if err := restored.restoreState(); err != nil {
+			return fmt.Errorf("%w: corrupt row in %s: %v", ErrValidation, table.Name, err)
+		}
+	}
+	if field := value.Elem().FieldByName("TenantID"); field.IsValid() && field.Kind() == reflect.Uint {
+		target, ok := s.tenants[uint(field.Uint())]
+		if !ok && field.Uint() != uint64(DefaultTenant.ID) {
+			return fmt.Errorf("%w: a row of %s belongs to tenant %d, which the archive does not have", ErrValidation, table.Name, field.Uint())
+		}
+		field.SetUint(uint64(target))
+	}
+	s.pending = reflect.Append(s.pending, value.Elem())
+	return nil
+}
+
+// flush inserts the queued rows
+func (s *stateRestore) flush() error {
+	if !s.pending.IsValid() || s.pending.Len() == 0 {
+		return nil
+	}
+	rows := reflect.New(s.pending.Type())
+	rows.Elem().Set(s.pending)
+	if err := s.tx.Create(rows.Interface()).Error; err != nil {
+		return fmt.Errorf("failed to restore %s: %v", s.table.Name, err)
+	}
+	s.result.Rows[s.table.Name] += s.pending.Len()
+	s.pending = reflect.Value{}
+	return nil
+}
+
+// tenant maps an archived tenant to the target's tenant of the same slug, creating it when missing
+func (s *stateRestore) tenant(row []byte) error {
+	var archived Tenant
+	if err := json.Unmarshal(row, &archived); err != nil {
+		return fmt.Errorf("%w: corrupt row in tenants: %v", ErrValidation, err)
+	}
+	var existing Tenant
+	err := s.tx.Where("slug = ?", archived.Slug).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var apiKey string
+		if existing, apiKey, err = NewGormTenantService(s.tx).CreateTenant(archived.Slug, archived.Name); err != nil {
+			return err
+		}
+		if archived.TokenUserID != "" && archived.TokenUserID != existing.TokenUserID {
+			if err := s.tx.Model(&existing).Update("token_user_id", archived.TokenUserID).Error; err != nil {
+				return fmt.Errorf("failed to restore tenant %s: %v", archived.Slug, err)
+			}
+		}
+		s.result.TenantKeys[archived.Slug] = apiKey
+	} else if err != nil {
+		return fmt.Errorf("failed to look up tenant %s: %v", archived.Slug, err)
+	}
+	s.tenants[archived.ID] = existing.ID
+	s.result.Rows["tenants"]++
+	return nil
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// gzipLines builds an archive body from raw JSON lines
+func gzipLines(t *testing.T, lines ...string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestImportStateRejectsForeignArchives(t *testing.T) {
+	manifest := func(version int, tables ...string) string {
+		body, _ := json.Marshal(StateManifest{Format: StateArchiveFormat, Version: version, Tables: tables})
+		return string(body)
+	}
+	for _, c := range []struct {
+		name    string
+		archive *bytes.Buffer
+		want    string
+	}{
+		{"not gzip", bytes.NewBufferString(`{"format": "convertyapi-state"}`), "not a state archive"},
+		{"other format", gzipLines(t, `{"format": "pg_dump", "version": 1}`), "not a state archive"},
+		{"newer version", gzipLines(t, manifest(stateArchiveVersion+1, "tags")), "version 2 is not supported"},
+		{"unknown table", gzipLines(t, manifest(stateArchiveVersion, "tags", "payments")), `table "payments"`},
+	} {
+		// The archive is refused before the database is touched
+		_, err := ImportState(nil, c.archive, StateTables(), "", false)
+		if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want a validation error containing %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestStateRowSealing(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	aead, err := stateKey("correct horse", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := []byte(`{"AccessToken": "secret-access"}`)
+	sealed, err := sealStateRow(aead, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("secret-access")) {
+		t.Fatal("the sealed row shows the token")
+	}
+	if opened, err := openStateRow(aead, sealed); err != nil || !bytes.Equal(opened, row) {
+		t.Fatalf("opened %s, %v", opened, err)
+	}
+
+	wrong, err := stateKey("battery staple", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openStateRow(wrong, sealed); !errors.Is(err, ErrStatePassphrase) {
+		t.Errorf("wrong passphrase: got %v, want ErrStatePassphrase", err)
+	}
+	if _, err := openStateRow(nil, sealed); !errors.Is(err, ErrStatePassphrase) {
+		t.Errorf("missing passphrase: got %v, want ErrStatePassphrase", err)
+	}
+}
+
+func TestRestoreStateRecomputesContentHash(t *testing.T) {
+	record := Data{UserID: 7, Type: "issue", Details: []byte(`{"description": "Parcel  lost"}`)}
+	if err := record.restoreState(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := recordContentHash(7, "issue", []byte(`{"description": "parcel lost"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.ContentHash != want {
+		t.Errorf("restored hash %q, want %q", record.ContentHash, want)
+	}
+}
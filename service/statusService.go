@@ -0,0 +1,154 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Incident severities, from least to most severe
+const (
+	IncidentMaintenance = "maintenance"
+	IncidentMinor       = "minor"
+	IncidentMajor       = "major"
+	IncidentCritical    = "critical"
+)
+
+// incidentSeverities ranks the valid severities
+var incidentSeverities = map[string]int{
+	IncidentMaintenance: 1,
+	IncidentMinor:       2,
+	IncidentMajor:       3,
+	IncidentCritical:    4,
+}
+
+// IncidentRank orders severities; unknown ones rank 0
+func IncidentRank(severity string) int {
+	return incidentSeverities[severity]
+}
+
+// StatusIncident is an operator note shown on the public status page
+type StatusIncident struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Title    string `gorm:"not null" json:"title"`
+	Message  string `json:"message,omitempty"`
+	Severity string `gorm:"not null" json:"severity"`
+	// Component names the affected status page component; empty affects the whole service
+	Component  string     `json:"component,omitempty"`
+	CreatedBy  string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ResolvedAt *time.Time `gorm:"index" json:"resolved_at,omitempty"`
+}
+
+// TableName specifies the table name for StatusIncident
+func (StatusIncident) TableName() string {
+	return "public.status_incidents"
+}
+
+// IncidentUpdate changes the fields of an incident that are set
+type IncidentUpdate struct {
+	Title     *string `json:"title"`
+	Message   *string `json:"message"`
+	Severity  *string `json:"severity"`
+	Component *string `json:"component"`
+}
+
+// StatusService defines the interface for status page incidents
+type StatusService interface {
+	CreateIncident(incident StatusIncident, actor string) (StatusIncident, error)
+	UpdateIncident(id uint, update IncidentUpdate) (StatusIncident, error)
+	ResolveIncident(id uint) (StatusIncident, error)
+	ListIncidents(resolvedSince time.Time) ([]StatusIncident, error)
+}
+
+// GormStatusService implements StatusService using GORM
+type GormStatusService struct {
+	db *gorm.DB
+}
+
+// NewGormStatusService creates a new GormStatusService
+func NewGormStatusService(db *gorm.DB) StatusService {
+	return &GormStatusService{db: db}
+}
+
+// validateIncident checks the fields an operator provides
+func validateIncident(incident StatusIncident) error {
+	if strings.TrimSpace(incident.Title) == "" {
+		return fmt.Errorf("title is required")
+	}
+	if IncidentRank(incident.Severity) == 0 {
+		return fmt.Errorf("invalid severity %q, expected maintenance, minor, major or critical", incident.Severity)
+	}
+	return nil
+}
+
+// CreateIncident posts a new incident note
+func (s *GormStatusService) CreateIncident(incident StatusIncident, actor string) (StatusIncident, error) {
+	if err := validateIncident(incident); err != nil {
+		return StatusIncident{}, err
+	}
+	incident.ID = 0
+	incident.ResolvedAt = nil
+	incident.CreatedBy = actor
+	if err := s.db.Create(&incident).Error; err != nil {
+		return StatusIncident{}, fmt.Errorf("failed to create incident: %v", err)
+	}
+	return incident, nil
+}
+
+// UpdateIncident edits an incident, for example to post progress or change its severity
+func (s *GormStatusService) UpdateIncident(id uint, update IncidentUpdate) (StatusIncident, error) {
+	var incident StatusIncident
+	if err := s.db.First(&incident, id).Error; err != nil {
+		return StatusIncident{}, fmt.Errorf("incident %d not found: %v", id, err)
+	}
+	if update.Title != nil {
+		incident.Title = *update.Title
+	}
+	if update.Message != nil {
+		incident.Message = *update.Message
+	}
+	if update.Severity != nil {
+		incident.Severity = *update.Severity
+	}
+	if update.Component != nil {
+		incident.Component = *update.Component
+	}
+	if err := validateIncident(incident); err != nil {
+		return StatusIncident{}, err
+	}
+	if err := s.db.Save(&incident).Error; err != nil {
+		return StatusIncident{}, fmt.Errorf("failed to update incident: %v", err)
+	}
+	return incident, nil
+}
+
+// ResolveIncident closes an incident; it stays listed as resolved for a while
+func (s *GormStatusService) ResolveIncident(id uint) (StatusIncident, error) {
+	var incident StatusIncident
+	if err := s.db.First(&incident, id).Error; err != nil {
+		return StatusIncident{}, fmt.Errorf("incident %d not found: %v", id, err)
+	}
+	if incident.ResolvedAt != nil {
+		return incident, nil
+	}
+	now := time.Now()
+	if err := s.db.Model(&incident).Update("resolved_at", now).Error; err != nil {
+		return StatusIncident{}, fmt.Errorf("failed to resolve incident: %v", err)
+	}
+	incident.ResolvedAt = &now
+	return incident, nil
+}
+
+// ListIncidents fetches open incidents and those resolved after resolvedSince, newest first
+func (s *GormStatusService) ListIncidents(resolvedSince time.Time) ([]StatusIncident, error) {
+	var incidents []StatusIncident
+	if err := s.db.Where("resolved_at IS NULL OR resolved_at >= ?", resolvedSince).
+		Order("created_at desc, id desc").Find(&incidents).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch incidents: %v", err)
+	}
+	return incidents, nil
+}
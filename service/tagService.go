@@ -0,0 +1,266 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrSavedFilterNotFound is returned for an unknown saved filter name
+var ErrSavedFilterNotFound = errors.New("saved filter not found")
+
+// tagName is the accepted shape of a tag once lower-cased: "urgent", "refund", "vip-2025"
+var tagName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,39}$`)
+
+// SavedFilterParams are the query parameters a saved filter may hold
+var SavedFilterParams = map[string]bool{
+	"type": true, "status": true, "category": true, "tag": true, "from": true, "to": true, "include_archived": true,
+}
+
+// Tag is a triage label of a tenant, e.g. urgent or refund
+type Tag struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TenantID  uint      `gorm:"not null;default:0;uniqueIndex:idx_tags_tenant_name" json:"tenant_id"`
+	Name      string    `gorm:"not null;uniqueIndex:idx_tags_tenant_name" json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for Tag
+func (Tag) TableName() string {
+	return "chatbot.tags"
+}
+
+// RecordTag links a tag to a record. RecordID is the lineage of the record, so its new versions keep their tags.
+type RecordTag struct {
+	RecordID  uint   `gorm:"primaryKey;autoIncrement:false"`
+	TagID     uint   `gorm:"primaryKey;autoIncrement:false;index"`
+	TaggedBy  string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// TableName specifies the table name for RecordTag
+func (RecordTag) TableName() string {
+	return "chatbot.record_tags"
+}
+
+// TagCount is a tag with the number of records carrying it
+type TagCount struct {
+	Name    string `json:"name"`
+	Records int64  `json:"records"`
+}
+
+// SavedFilter is a named record listing query of one API client, e.g. "refunds" for tag=refund&status=pending
+type SavedFilter struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	TenantID uint   `gorm:"not null;default:0;uniqueIndex:idx_saved_filters_owner_name" json:"tenant_id"`
+	Owner    string `gorm:"not null;uniqueIndex:idx_saved_filters_owner_name" json:"owner"`
+	Name     string `gorm:"not null;uniqueIndex:idx_saved_filters_owner_name" json:"name"`
+	// Query is the URL query string applied to the record listing
+	Query     string    `gorm:"not null" json:"query"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for SavedFilter
+func (SavedFilter) TableName() string {
+	return "chatbot.saved_filters"
+}
+
+// Values parses the saved query
+func (f SavedFilter) Values() url.Values {
+	values, _ := url.ParseQuery(f.Query)
+	return values
+}
+
+// TagService defines the interface for record tags and saved filters
+type TagService interface {
+	// TagRecord adds tags to record and returns all its tags
+	TagRecord(record Data, tags []string, actor string) ([]string, error)
+	UntagRecord(record Data, tag string) error
+	RecordTags(record Data) ([]string, error)
+	ListTags(tenantID uint) ([]TagCount, error)
+	SaveFilter(tenantID uint, owner, name, query string) (SavedFilter, error)
+	GetFilter(tenantID uint, owner, name string) (SavedFilter, error)
+	ListFilters(tenantID uint, owner string) ([]SavedFilter, error)
+	DeleteFilter(tenantID uint, owner, name string) error
+}
+
+// GormTagService implements TagService using GORM
+type GormTagService struct {
+	db *gorm.DB
+}
+
+// NewGormTagService creates a new GormTagService
+func NewGormTagService(db *gorm.DB) TagService {
+	return &GormTagService{db: db}
+}
+
+// NormalizeTags lower-cases and deduplicates tags, rejecting malformed ones with ErrValidation
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagName.MatchString(tag) {
+			return nil, fmt.Errorf("%w: tag %q must be 1-40 letters, digits, - or _", ErrValidation, tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// tagged restricts a chatbot.interactions query to the records carrying every tag
+func tagged(tags []string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, tag := range tags {
+			db = db.Where(`EXISTS (SELECT 1 FROM chatbot.record_tags AS rt JOIN chatbot.tags AS t ON t.id = rt.tag_id
+				WHERE rt.record_id = COALESCE(NULLIF(interactions.lineage_id, 0), interactions.id)
+				AND t.tenant_id = interactions.tenant_id AND t.name = ?)`, strings.ToLower(tag))
+		}
+		return db
+	}
+}
+
+// TagRecord creates the missing tags of the record tenant and links them to the record
+func (s *GormTagService) TagRecord(record Data, tags []string, actor string) ([]string, error) {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("%w: no tags given", ErrValidation)
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for _, name := range tags {
+			tag := Tag{TenantID: record.TenantID, Name: name}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tag).Error; err != nil {
+				return fmt.Errorf("failed to create tag %s: %v", name, err)
+			}
+			if err := tx.Where("tenant_id = ? AND name = ?", record.TenantID, name).First(&tag).Error; err != nil {
+				return fmt.Errorf("failed to load tag %s: %v", name, err)
+			}
+			link := RecordTag{RecordID: record.lineage(), TagID: tag.ID, TaggedBy: actor}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&link).Error; err != nil {
+				return fmt.Errorf("failed to tag record %d: %v", record.ID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.RecordTags(record)
+}
+
+// UntagRecord removes a tag from the record; removing a tag it does not carry is not an error
+func (s *GormTagService) UntagRecord(record Data, tag string) error {
+	err := s.db.Where("record_id = ? AND tag_id IN (?)", record.lineage(),
+		s.db.Model(&Tag{}).Select("id").Where("tenant_id = ? AND name = ?", record.TenantID, strings.ToLower(tag)),
+	).Delete(&RecordTag{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to untag record %d: %v", record.ID, err)
+	}
+	return nil
+}
+
+// RecordTags lists the tags of the record, alphabetically
+func (s *GormTagService) RecordTags(record Data) ([]string, error) {
+	tags := []string{}
+	err := s.db.Model(&Tag{}).
+		Joins("JOIN chatbot.record_tags AS rt ON rt.tag_id = tags.id").
+		Where("rt.record_id = ?", record.lineage()).
+		Order("tags.name").Pluck("tags.name", &tags).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tags of record %d: %v", record.ID, err)
+	}
+	return tags, nil
+}
+
+// ListTags lists the tenant tags with their record counts, most used first
+func (s *GormTagService) ListTags(tenantID uint) ([]TagCount, error) {
+	counts := []TagCount{}
+	err := s.db.Model(&Tag{}).
+		Select("tags.name AS name, COUNT(rt.record_id) AS records").
+		Joins("LEFT JOIN chatbot.record_tags AS rt ON rt.tag_id = tags.id").
+		Where("tags.tenant_id = ?", tenantID).
+		Group("tags.name").Order("records DESC, tags.name").Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %v", err)
+	}
+	return counts, nil
+}
+
+// SaveFilter creates or replaces a named filter of owner; query keeps only SavedFilterParams
+func (s *GormTagService) SaveFilter(tenantID uint, owner, name, query string) (SavedFilter, error) {
+	values, err := url.ParseQuery(strings.TrimPrefix(query, "?"))
+	if err != nil {
+		return SavedFilter{}, fmt.Errorf("%w: invalid filter query: %v", ErrValidation, err)
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !SavedFilterParams[key] {
+			return SavedFilter{}, fmt.Errorf("%w: filters cannot hold %q", ErrValidation, key)
+		}
+	}
+	if _, err := NormalizeTags(values["tag"]); err != nil {
+		return SavedFilter{}, err
+	}
+
+	filter := SavedFilter{TenantID: tenantID, Owner: owner, Name: name, Query: values.Encode()}
+	err = s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "owner"}, {Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"query", "updated_at"}),
+	}).Create(&filter).Error
+	if err != nil {
+		return SavedFilter{}, fmt.Errorf("failed to save filter %s: %v", name, err)
+	}
+	return s.GetFilter(tenantID, owner, name)
+}
+
+// GetFilter fetches a saved filter of owner by name
+func (s *GormTagService) GetFilter(tenantID uint, owner, name string) (SavedFilter, error) {
+	var filter SavedFilter
+	err := s.db.Where("tenant_id = ? AND owner = ? AND name = ?", tenantID, owner, name).First(&filter).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return SavedFilter{}, fmt.Errorf("%w: %s", ErrSavedFilterNotFound, name)
+	}
+	if err != nil {
+		return SavedFilter{}, fmt.Errorf("failed to fetch filter %s: %v", name, err)
+	}
+	return filter, nil
+}
+
+// ListFilters lists the saved filters of owner by name
+func (s *GormTagService) ListFilters(tenantID uint, owner string) ([]SavedFilter, error) {
+	filters := []SavedFilter{}
+	if err := s.db.Where("tenant_id = ? AND owner = ?", tenantID, owner).Order("name").Find(&filters).Error; err != nil {
+		return nil, fmt.Errorf("failed to list filters: %v", err)
+	}
+	return filters, nil
+}
+
+// DeleteFilter removes a saved filter of owner
+func (s *GormTagService) DeleteFilter(tenantID uint, owner, name string) error {
+	result := s.db.Where("tenant_id = ? AND owner = ? AND name = ?", tenantID, owner, name).Delete(&SavedFilter{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete filter %s: %v", name, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrSavedFilterNotFound, name)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := NormalizeTags([]string{" Urgent", "refund", "urgent", "vip-2025"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"urgent", "refund", "vip-2025"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("got %v, want %v", tags, want)
+	}
+	for _, bad := range []string{"", "two words", "-leading", "émoji"} {
+		if _, err := NormalizeTags([]string{bad}); !errors.Is(err, ErrValidation) {
+			t.Errorf("%q: got %v, want ErrValidation", bad, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrOrdersTruncated is returned when an order listing has more pages than CollectOrders may read
+var ErrOrdersTruncated = errors.New("the order listing has more pages than were read")
+
+// nonTaxableStatuses are order statuses that never produce taxable revenue
+var nonTaxableStatuses = map[string]bool{
+	"cancelled": true,
+	"canceled":  true,
+	"returned":  true,
+	"rejected":  true,
+	"abandoned": true,
+}
+
+// TaxCalculator applies VAT to order amounts
+type TaxCalculator struct {
+	// Rate is the VAT rate, e.g. 0.19 for 19%
+	Rate float64
+	// PricesIncludeVAT means order totals are gross amounts
+	PricesIncludeVAT bool
+}
+
+// Split returns the net, VAT and gross parts of an amount, rounded to 3 decimals
+func (c TaxCalculator) Split(amount float64) (net, vat, gross float64) {
+	if c.PricesIncludeVAT {
+		gross = amount
+		net = amount / (1 + c.Rate)
+	} else {
+		net = amount
+		gross = amount * (1 + c.Rate)
+	}
+	net = roundMillimes(net)
+	gross = roundMillimes(gross)
+	return net, roundMillimes(gross - net), gross
+}
+
+// Invoice is the tax breakdown of a single order
+type Invoice struct {
+	Number   string    `json:"number"`
+	OrderID  string    `json:"order_id"`
+	Customer Customer  `json:"customer"`
+	Status   string    `json:"status"`
+	Currency string    `json:"currency"`
+	Net      float64   `json:"net"`
+	VATRate  float64   `json:"vat_rate"`
+	VAT      float64   `json:"vat"`
+	Gross    float64   `json:"gross"`
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+// BuildInvoice computes the invoice for an order
+func (c TaxCalculator) BuildInvoice(order Order) Invoice {
+	net, vat, gross := c.Split(order.Total)
+	return Invoice{
+		Number:   fmt.Sprintf("INV-%s-%s", order.CreatedAt.Format("200601"), order.ID),
+		OrderID:  order.ID,
+		Customer: order.Customer,
+		Status:   order.Status,
+		Currency: order.Currency,
+		Net:      net,
+		VATRate:  c.Rate,
+		VAT:      vat,
+		Gross:    gross,
+		IssuedAt: order.CreatedAt,
+	}
+}
+
+// TaxReport summarizes taxable revenue for a quarter
+type TaxReport struct {
+	Year     int       `json:"year"`
+	Quarter  int       `json:"quarter"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Currency string    `json:"currency"`
+	VATRate  float64   `json:"vat_rate"`
+	Orders   int       `json:"orders"`
+	Net      float64   `json:"net"`
+	VAT      float64   `json:"vat"`
+	Gross    float64   `json:"gross"`
+	Invoices []Invoice `json:"invoices"`
+}
+
+// QuarterRange returns the [from, to) range of a calendar quarter
+func QuarterRange(year, quarter int, loc *time.Location) (time.Time, time.Time, error) {
+	if quarter < 1 || quarter > 4 {
+		return time.Time{}, time.Time{}, fmt.Errorf("quarter must be between 1 and 4")
+	}
+	from := time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, loc)
+	return from, from.AddDate(0, 3, 0), nil
+}
+
+// BuildTaxReport aggregates taxable orders created within the quarter.
+// Orders must already carry converted amounts when a reporting currency is used.
+func (c TaxCalculator) BuildTaxReport(year, quarter int, currency string, orders []Order) (TaxReport, error) {
+	from, to, err := QuarterRange(year, quarter, time.Local)
+	if err != nil {
+		return TaxReport{}, err
+	}
+	report := TaxReport{Year: year, Quarter: quarter, From: from, To: to, Currency: currency, VATRate: c.Rate, Invoices: []Invoice{}}
+	for _, order := range orders {
+		if order.CreatedAt.Before(from) || !order.CreatedAt.Before(to) {
+			continue
+		}
+		if nonTaxableStatuses[strings.ToLower(order.Status)] {
+			continue
+		}
+		if order.ConvertedTotal != nil {
+			order.Total = *order.ConvertedTotal
+			order.Currency = order.ConvertedCurrency
+		}
+		invoice := c.BuildInvoice(order)
+		report.Invoices = append(report.Invoices, invoice)
+		report.Orders++
+		report.Net += invoice.Net
+		report.VAT += invoice.VAT
+		report.Gross += invoice.Gross
+	}
+	report.Net = roundMillimes(report.Net)
+	report.VAT = roundMillimes(report.VAT)
+	report.Gross = roundMillimes(report.Gross)
+	return report, nil
+}
+
+// CollectOrders pages through the upstream order listing and keeps orders created in [from, to),
+// until a short page signals the end of the listing. When maxPages pages were read without reaching
+// it, the orders collected so far are returned with ErrOrdersTruncated.
+func CollectOrders(dataService DataService, query CustomerOrderQuery, from, to time.Time, maxPages int) ([]Order, error) {
+	if query.Limit <= 0 {
+		query.Limit = 100
+	}
+	var collected []Order
+	for page := 1; page <= maxPages; page++ {
+		query.Page = page
+		orders, err := dataService.ListOrders(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, order := range orders {
+			if !order.CreatedAt.Before(from) && order.CreatedAt.Before(to) {
+				collected = append(collected, order)
+			}
+		}
+		if len(orders) < query.Limit {
+			return collected, nil
+		}
+	}
+	return collected, fmt.Errorf("%w: stopped after %d pages of %d orders", ErrOrdersTruncated, maxPages, query.Limit)
+}
+
+func roundMillimes(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTaxReportSkipsNonTaxableAndOutOfRange(t *testing.T) {
+	calc := TaxCalculator{Rate: 0.19, PricesIncludeVAT: true}
+	inQuarter := time.Date(2025, 5, 10, 12, 0, 0, 0, time.Local)
+	orders := []Order{
+		{ID: "1", Total: 119, Currency: "TND", Status: "delivered", CreatedAt: inQuarter},
+		{ID: "2", Total: 50, Currency: "TND", Status: "cancelled", CreatedAt: inQuarter},
+		{ID: "3", Total: 80, Currency: "TND", Status: "delivered", CreatedAt: time.Date(2025, 7, 1, 0, 0, 0, 0, time.Local)},
+	}
+
+	report, err := calc.BuildTaxReport(2025, 2, "TND", orders)
+	if err != nil {
+		t.Fatalf("BuildTaxReport failed: %v", err)
+	}
+	if report.Orders != 1 || report.Net != 100 || report.VAT != 19 || report.Gross != 119 {
+		t.Fatalf("unexpected report totals: %+v", report)
+	}
+}
+
+func TestCollectOrdersReportsTruncation(t *testing.T) {
+	from := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	page := func(n int) []Order {
+		orders := make([]Order, n)
+		for i := range orders {
+			orders[i] = Order{ID: "o", CreatedAt: from.Add(time.Hour)}
+		}
+		return orders
+	}
+	source := &pagedOrders{pages: [][]Order{page(2), page(2), page(1)}}
+	orders, err := CollectOrders(source, CustomerOrderQuery{Limit: 2}, from, from.AddDate(0, 3, 0), 3)
+	if err != nil || len(orders) != 5 {
+		t.Fatalf("a listing ending within the pages: %d orders, %v", len(orders), err)
+	}
+
+	source = &pagedOrders{pages: [][]Order{page(2), page(2), page(1)}}
+	orders, err = CollectOrders(source, CustomerOrderQuery{Limit: 2}, from, from.AddDate(0, 3, 0), 2)
+	if !errors.Is(err, ErrOrdersTruncated) || len(orders) != 4 {
+		t.Fatalf("a listing longer than the pages: %d orders, %v", len(orders), err)
+	}
+}
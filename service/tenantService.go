@@ -0,0 +1,110 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultTenant owns the data of requests without an API key; it keeps the original single-shop token
+var DefaultTenant = Tenant{ID: 0, Slug: "default", Name: "Default", TokenUserID: "user1"}
+
+// tenantSlugPattern restricts slugs to URL- and state-safe characters
+var tenantSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+// Tenant is an independent shop/chatbot served by this deployment
+type Tenant struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	Slug       string `gorm:"not null;uniqueIndex" json:"slug"`
+	Name       string `json:"name"`
+	APIKeyHash string `gorm:"not null;uniqueIndex" json:"-"`
+	// TokenUserID is the public.token_infos user_id holding the tenant's Converty authorization
+	TokenUserID string    `gorm:"not null;uniqueIndex" json:"token_user_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for Tenant
+func (Tenant) TableName() string {
+	return "public.tenants"
+}
+
+// TenantService defines the interface for tenant operations
+type TenantService interface {
+	CreateTenant(slug, name string) (Tenant, string, error)
+	ResolveAPIKey(apiKey string) (Tenant, error)
+	GetTenant(slug string) (Tenant, error)
+	ListTenants() ([]Tenant, error)
+}
+
+// GormTenantService implements TenantService using GORM
+type GormTenantService struct {
+	db *gorm.DB
+}
+
+// NewGormTenantService creates a new GormTenantService
+func NewGormTenantService(db *gorm.DB) TenantService {
+	return &GormTenantService{db: db}
+}
+
+// hashAPIKey returns the stored form of an API key; keys themselves are never persisted
+func hashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateTenant registers a tenant and returns its API key, which is only shown once
+func (s *GormTenantService) CreateTenant(slug, name string) (Tenant, string, error) {
+	if !tenantSlugPattern.MatchString(slug) || slug == DefaultTenant.Slug {
+		return Tenant{}, "", fmt.Errorf("invalid tenant slug %q", slug)
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return Tenant{}, "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+	apiKey := "tk_" + hex.EncodeToString(b)
+	tenant := Tenant{
+		Slug:        slug,
+		Name:        name,
+		APIKeyHash:  hashAPIKey(apiKey),
+		TokenUserID: "tenant:" + slug,
+	}
+	if err := s.db.Create(&tenant).Error; err != nil {
+		return Tenant{}, "", fmt.Errorf("failed to create tenant: %v", err)
+	}
+	return tenant, apiKey, nil
+}
+
+// ResolveAPIKey finds the tenant an API key belongs to
+func (s *GormTenantService) ResolveAPIKey(apiKey string) (Tenant, error) {
+	var tenant Tenant
+	if err := s.db.Where("api_key_hash = ?", hashAPIKey(apiKey)).First(&tenant).Error; err != nil {
+		return Tenant{}, fmt.Errorf("unknown API key")
+	}
+	return tenant, nil
+}
+
+// GetTenant fetches a tenant by slug; "default" resolves to DefaultTenant
+func (s *GormTenantService) GetTenant(slug string) (Tenant, error) {
+	if slug == DefaultTenant.Slug {
+		return DefaultTenant, nil
+	}
+	var tenant Tenant
+	if err := s.db.Where("slug = ?", slug).First(&tenant).Error; err != nil {
+		return Tenant{}, fmt.Errorf("tenant %q not found: %v", slug, err)
+	}
+	return tenant, nil
+}
+
+// ListTenants fetches every registered tenant
+func (s *GormTenantService) ListTenants() ([]Tenant, error) {
+	var tenants []Tenant
+	if err := s.db.Order("slug").Find(&tenants).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch tenants: %v", err)
+	}
+	return tenants, nil
+}
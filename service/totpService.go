@@ -0,0 +1,278 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TOTP parameters (RFC 6238 defaults understood by every authenticator app)
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	// totpSkew accepts codes of the neighbouring time steps to tolerate clock drift
+	totpSkew = 1
+	// backupCodeCount backup codes are issued when an enrollment is confirmed
+	backupCodeCount = 10
+	// totpMaxFailures wrong codes in a row lock verification for totpLockout
+	totpMaxFailures = 5
+	totpLockout     = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidTOTP is returned for wrong, reused or malformed codes
+	ErrInvalidTOTP = errors.New("invalid two-factor code")
+	// ErrTOTPNotEnrolled is returned when the staff member has no confirmed second factor
+	ErrTOTPNotEnrolled = errors.New("two-factor authentication is not set up")
+	// ErrTOTPLocked is returned while verification is locked after repeated failures
+	ErrTOTPLocked = errors.New("too many invalid two-factor codes, try again later")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// AdminTOTP is the second factor of a staff member. The secret has to stay readable to check
+// codes; backup codes are kept as SHA-256 hashes and removed once used.
+type AdminTOTP struct {
+	Subject     string     `gorm:"primaryKey;size:255" json:"subject"`
+	Secret      string     `gorm:"not null" json:"-"`
+	BackupCodes string     `json:"-"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	// LastStep is the time step of the last accepted code, so a code cannot be replayed
+	LastStep       int64      `gorm:"not null;default:0" json:"-"`
+	FailedAttempts int        `gorm:"not null;default:0" json:"-"`
+	LockedUntil    *time.Time `json:"-"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for AdminTOTP
+func (AdminTOTP) TableName() string {
+	return "public.admin_totps"
+}
+
+// TOTPService defines the interface for staff two-factor authentication
+type TOTPService interface {
+	// Enroll starts (or restarts) an unconfirmed enrollment and returns the new secret
+	Enroll(subject string) (string, error)
+	// Confirm activates the enrollment with a first code and returns the plain backup codes
+	Confirm(subject, code string) ([]string, error)
+	// Verify checks a TOTP code or consumes a backup code
+	Verify(subject, code string) error
+	Enrolled(subject string) (bool, error)
+	// Reset removes the second factor so the staff member can enroll again
+	Reset(subject string) error
+}
+
+// GormTOTPService implements TOTPService using GORM
+type GormTOTPService struct {
+	db *gorm.DB
+}
+
+// NewGormTOTPService creates a new GormTOTPService
+func NewGormTOTPService(db *gorm.DB) TOTPService {
+	return &GormTOTPService{db: db}
+}
+
+// Enroll generates a secret; a confirmed second factor has to be reset first
+func (s *GormTOTPService) Enroll(subject string) (string, error) {
+	if subject == "" {
+		return "", fmt.Errorf("subject is required")
+	}
+	existing, found, err := s.find(subject)
+	if err != nil {
+		return "", err
+	}
+	if found && existing.ConfirmedAt != nil {
+		return "", fmt.Errorf("two-factor authentication is already set up for %s", subject)
+	}
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %v", err)
+	}
+	enrollment := AdminTOTP{Subject: subject, Secret: totpEncoding.EncodeToString(b)}
+	if err := s.db.Save(&enrollment).Error; err != nil {
+		return "", fmt.Errorf("failed to store TOTP enrollment: %v", err)
+	}
+	return enrollment.Secret, nil
+}
+
+// Confirm checks the first code of a pending enrollment and issues the backup codes
+func (s *GormTOTPService) Confirm(subject, code string) ([]string, error) {
+	enrollment, found, err := s.find(subject)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if enrollment.ConfirmedAt != nil {
+		return nil, fmt.Errorf("two-factor authentication is already set up for %s", subject)
+	}
+	step, ok := matchTOTP(enrollment.Secret, normalizeCode(code), time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidTOTP
+	}
+	codes := make([]string, backupCodeCount)
+	hashes := make([]string, backupCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate backup codes: %v", err)
+		}
+		plain := hex.EncodeToString(b)
+		codes[i] = plain[:5] + "-" + plain[5:]
+		hashes[i] = hashAPIKey(plain)
+	}
+	now := time.Now()
+	if err := s.db.Model(&enrollment).Updates(map[string]interface{}{
+		"confirmed_at": now,
+		"last_step":    step,
+		"backup_codes": strings.Join(hashes, ","),
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to confirm TOTP enrollment: %v", err)
+	}
+	return codes, nil
+}
+
+// Verify accepts a current TOTP code or an unused backup code
+func (s *GormTOTPService) Verify(subject, code string) error {
+	enrollment, found, err := s.find(subject)
+	if err != nil {
+		return err
+	}
+	if !found || enrollment.ConfirmedAt == nil {
+		return ErrTOTPNotEnrolled
+	}
+	now := time.Now()
+	if enrollment.LockedUntil != nil && now.Before(*enrollment.LockedUntil) {
+		return ErrTOTPLocked
+	}
+
+	code = normalizeCode(code)
+	updates := map[string]interface{}{"failed_attempts": 0, "locked_until": nil}
+	if step, ok := matchTOTP(enrollment.Secret, code, now, enrollment.LastStep); ok {
+		updates["last_step"] = step
+	} else if remaining, ok := consumeBackupCode(enrollment.BackupCodes, code); ok {
+		updates["backup_codes"] = remaining
+	} else {
+		failures := enrollment.FailedAttempts + 1
+		updates = map[string]interface{}{"failed_attempts": failures}
+		if failures >= totpMaxFailures {
+			updates["failed_attempts"] = 0
+			updates["locked_until"] = now.Add(totpLockout)
+		}
+		if err := s.db.Model(&enrollment).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to record invalid code: %v", err)
+		}
+		return ErrInvalidTOTP
+	}
+	if err := s.db.Model(&enrollment).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to record verification: %v", err)
+	}
+	return nil
+}
+
+// Enrolled reports whether the subject has a confirmed second factor
+func (s *GormTOTPService) Enrolled(subject string) (bool, error) {
+	enrollment, found, err := s.find(subject)
+	if err != nil {
+		return false, err
+	}
+	return found && enrollment.ConfirmedAt != nil, nil
+}
+
+// Reset deletes the enrollment of a subject
+func (s *GormTOTPService) Reset(subject string) error {
+	result := s.db.Where("subject = ?", subject).Delete(&AdminTOTP{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to reset two-factor authentication: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrTOTPNotEnrolled
+	}
+	return nil
+}
+
+// find loads the enrollment of a subject
+func (s *GormTOTPService) find(subject string) (AdminTOTP, bool, error) {
+	var enrollment AdminTOTP
+	result := s.db.Where("subject = ?", subject).Limit(1).Find(&enrollment)
+	if result.Error != nil {
+		return AdminTOTP{}, false, fmt.Errorf("failed to load TOTP enrollment: %v", result.Error)
+	}
+	return enrollment, result.RowsAffected > 0, nil
+}
+
+// TOTPURL returns the otpauth:// URL authenticator apps import, usually shown as a QR code
+func TOTPURL(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + params.Encode()
+}
+
+// totpCode computes the code of a time step (RFC 4226 dynamic truncation of HMAC-SHA1)
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %v", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// matchTOTP returns the time step a code belongs to; steps up to lastStep were already used
+func matchTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// consumeBackupCode removes the hash of code from the stored list
+func consumeBackupCode(stored, code string) (string, bool) {
+	if stored == "" || code == "" {
+		return stored, false
+	}
+	hashed := hashAPIKey(code)
+	hashes := strings.Split(stored, ",")
+	for i, hash := range hashes {
+		if hmac.Equal([]byte(hash), []byte(hashed)) {
+			return strings.Join(append(hashes[:i:i], hashes[i+1:]...), ","), true
+		}
+	}
+	return stored, false
+}
+
+// normalizeCode strips the spaces and dashes people type into codes
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
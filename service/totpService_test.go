@@ -0,0 +1,55 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B vectors for the SHA-1 key "12345678901234567890", truncated to 6 digits
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924"} {
+		got, err := totpCode(secret, unix/30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("code at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+	step := now.Unix() / 30
+	previous, _ := totpCode(secret, step-1)
+
+	got, ok := matchTOTP(secret, previous, now, 0)
+	if !ok || got != step-1 {
+		t.Fatalf("code of the previous step not accepted within the skew (step %d, ok %v)", got, ok)
+	}
+	if _, ok := matchTOTP(secret, previous, now, step-1); ok {
+		t.Error("a used code was accepted again")
+	}
+	stale, _ := totpCode(secret, step-3)
+	if _, ok := matchTOTP(secret, stale, now, 0); ok {
+		t.Error("a code outside the skew was accepted")
+	}
+	if _, ok := matchTOTP(secret, "12345", now, 0); ok {
+		t.Error("a short code was accepted")
+	}
+}
+
+func TestConsumeBackupCode(t *testing.T) {
+	stored := strings.Join([]string{hashAPIKey("aaaaabbbbb"), hashAPIKey("cccccddddd")}, ",")
+
+	remaining, ok := consumeBackupCode(stored, normalizeCode("CCCCC-DDDDD"))
+	if !ok || remaining != hashAPIKey("aaaaabbbbb") {
+		t.Fatalf("backup code not consumed: %v %q", ok, remaining)
+	}
+	if _, ok := consumeBackupCode(remaining, "cccccddddd"); ok {
+		t.Error("a consumed backup code was accepted again")
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingTransportForwardsTraceparent(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(previousProvider)
+	defer otel.SetTextMapPropagator(previousPropagator)
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "list orders")
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/v1/orders?access_token=secret", nil)
+	client := &http.Client{Transport: &TracingTransport{Base: http.DefaultTransport, Peer: "converty"}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	if !strings.Contains(traceparent, parent.SpanContext().TraceID().String()) {
+		t.Errorf("traceparent %q does not carry trace %s", traceparent, parent.SpanContext().TraceID())
+	}
+	if req.Header.Get("traceparent") != "" {
+		t.Error("the caller's request was modified")
+	}
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "converty GET /api/v1/orders" || spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("unexpected spans: %+v", spans)
+	}
+	for _, attr := range spans[0].Attributes() {
+		if attr.Key == "url.full" && strings.Contains(attr.Value.AsString(), "secret") {
+			t.Errorf("url.full not redacted: %s", attr.Value.AsString())
+		}
+	}
+	if spans[0].Status().Code.String() != "Error" {
+		t.Errorf("a 502 should mark the span as failed, got %v", spans[0].Status())
+	}
+}
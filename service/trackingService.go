@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Normalized tracking statuses, shared by every carrier
+const (
+	TrackingCreated        = "created"
+	TrackingPickedUp       = "picked_up"
+	TrackingInTransit      = "in_transit"
+	TrackingOutForDelivery = "out_for_delivery"
+	TrackingDelivered      = "delivered"
+	TrackingFailedAttempt  = "failed_attempt"
+	TrackingReturned       = "returned"
+	TrackingUnknown        = "unknown"
+)
+
+// ErrNoTrackingNumber is returned for orders the carrier has not registered yet
+var ErrNoTrackingNumber = errors.New("order has no tracking number yet")
+
+// ErrCarrierNotSupported is returned when no adapter handles the order's delivery company
+var ErrCarrierNotSupported = errors.New("delivery company is not supported for tracking")
+
+// TrackingEvent is one normalized step of a shipment
+type TrackingEvent struct {
+	Time        time.Time `json:"time"`
+	Status      string    `json:"status"`
+	Description string    `json:"description"`
+	Location    string    `json:"location,omitempty"`
+}
+
+// Tracking is the normalized tracking history of an order, newest event first
+type Tracking struct {
+	OrderID        string          `json:"order_id"`
+	Carrier        string          `json:"carrier"`
+	TrackingNumber string          `json:"tracking_number"`
+	Status         string          `json:"status"`
+	Events         []TrackingEvent `json:"events"`
+	// Message is a customer-facing summary of the latest event
+	Message string `json:"message"`
+}
+
+// Carrier is implemented by each delivery company adapter (Aramex, First Delivery)
+type Carrier interface {
+	Name() string
+	// Handles reports whether the carrier serves a Converty deliveryCompany value
+	Handles(deliveryCompany string) bool
+	Track(trackingNumber string) ([]TrackingEvent, error)
+}
+
+// TrackingService defines the interface for shipment tracking
+type TrackingService interface {
+	RegisterCarrier(carrier Carrier)
+	Carriers() []string
+	Track(order Order) (Tracking, error)
+}
+
+// CarrierTrackingService implements TrackingService by dispatching to registered carriers
+type CarrierTrackingService struct {
+	mu       sync.RWMutex
+	carriers []Carrier
+}
+
+// NewTrackingService creates a tracking service without carriers
+func NewTrackingService() TrackingService {
+	return &CarrierTrackingService{}
+}
+
+// RegisterCarrier makes a carrier adapter available
+func (s *CarrierTrackingService) RegisterCarrier(carrier Carrier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.carriers = append(s.carriers, carrier)
+}
+
+// Carriers lists the registered carrier names
+func (s *CarrierTrackingService) Carriers() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, len(s.carriers))
+	for i, carrier := range s.carriers {
+		names[i] = carrier.Name()
+	}
+	return names
+}
+
+func (s *CarrierTrackingService) carrierFor(deliveryCompany string) (Carrier, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, carrier := range s.carriers {
+		if carrier.Handles(deliveryCompany) {
+			return carrier, true
+		}
+	}
+	return nil, false
+}
+
+// Track fetches the order's shipment events from its delivery company
+func (s *CarrierTrackingService) Track(order Order) (Tracking, error) {
+	if strings.TrimSpace(order.TrackingNumber) == "" {
+		return Tracking{}, ErrNoTrackingNumber
+	}
+	carrier, ok := s.carrierFor(order.DeliveryCompany)
+	if !ok {
+		return Tracking{}, fmt.Errorf("%w: %q", ErrCarrierNotSupported, order.DeliveryCompany)
+	}
+	events, err := carrier.Track(order.TrackingNumber)
+	if err != nil {
+		return Tracking{}, fmt.Errorf("failed to track %s shipment %s: %v", carrier.Name(), order.TrackingNumber, err)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.After(events[j].Time) })
+
+	tracking := Tracking{
+		OrderID:        order.ID,
+		Carrier:        carrier.Name(),
+		TrackingNumber: order.TrackingNumber,
+		Status:         TrackingUnknown,
+		Events:         events,
+	}
+	if len(events) > 0 {
+		tracking.Status = events[0].Status
+	}
+	tracking.Localize(NewLocalizer())
+	return tracking, nil
+}
+
+// Localize rewrites the customer-facing summary in the localizer's language
+func (t *Tracking) Localize(l *Localizer) {
+	if len(t.Events) == 0 {
+		t.Message = l.T("tracking." + TrackingCreated)
+		return
+	}
+	t.Message = trackingMessage(l, t.Events[0])
+}
+
+// trackingMessage summarizes an event for the customer, using the carrier's description for unknown statuses
+func trackingMessage(l *Localizer, event TrackingEvent) string {
+	message := event.Description
+	if id := "tracking." + event.Status; event.Status != TrackingUnknown && l.Has(id) {
+		message = l.T(id)
+	}
+	if event.Location != "" {
+		message = l.T("tracking.at", message, event.Location, event.Time.Format("02/01 15:04"))
+	}
+	return message
+}
+
+// carrierMatches compares a Converty deliveryCompany value against a carrier's known names
+func carrierMatches(deliveryCompany string, names ...string) bool {
+	company := strings.ToLower(strings.Join(strings.Fields(deliveryCompany), " "))
+	for _, name := range names {
+		if company == name || strings.ReplaceAll(company, " ", "") == strings.ReplaceAll(name, " ", "") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrackAramexShipment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Shipments []string
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/TrackShipments" || len(body.Shipments) != 1 || body.Shipments[0] != "4411" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, body.Shipments)
+		}
+		w.Write([]byte(`{"HasErrors":false,"TrackingResults":[{"Key":"4411","Value":[
+			{"UpdateCode":"SH014","UpdateDescription":"Record created","UpdateDateTime":"/Date(1717236000000+0100)/","UpdateLocation":"Tunis"},
+			{"UpdateCode":"SH003","UpdateDescription":"Out for delivery","UpdateDateTime":"/Date(1717322400000+0100)/","UpdateLocation":"Sfax"}]}]}`))
+	}))
+	defer server.Close()
+
+	tracking := NewTrackingService()
+	tracking.RegisterCarrier(&FirstDeliveryCarrier{BaseURL: server.URL})
+	tracking.RegisterCarrier(&AramexCarrier{BaseURL: server.URL})
+
+	result, err := tracking.Track(Order{ID: "o1", DeliveryCompany: " ARAMEX ", TrackingNumber: "4411"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Carrier != "aramex" || result.Status != TrackingOutForDelivery || len(result.Events) != 2 {
+		t.Fatalf("unexpected tracking %+v", result)
+	}
+	if want := time.UnixMilli(1717322400000).UTC(); !result.Events[0].Time.Equal(want) {
+		t.Errorf("newest event at %v, want %v", result.Events[0].Time, want)
+	}
+
+	if _, err := tracking.Track(Order{DeliveryCompany: "Aramex"}); !errors.Is(err, ErrNoTrackingNumber) {
+		t.Errorf("missing tracking number: got %v", err)
+	}
+	if _, err := tracking.Track(Order{DeliveryCompany: "Rapid Poste", TrackingNumber: "1"}); !errors.Is(err, ErrCarrierNotSupported) {
+		t.Errorf("unknown carrier: got %v", err)
+	}
+}
+
+func TestFirstDeliveryStatus(t *testing.T) {
+	for state, want := range map[string]string{"Livré": TrackingDelivered, " EN COURS ": TrackingOutForDelivery, "Retour dépôt": TrackingReturned, "???": TrackingUnknown} {
+		if got := firstDeliveryStatus(state); got != want {
+			t.Errorf("%q: got %s, want %s", state, got, want)
+		}
+	}
+	if !(&FirstDeliveryCarrier{}).Handles("First Delivery") || !(&FirstDeliveryCarrier{}).Handles("FirstDelivery") {
+		t.Error("First Delivery spellings are not matched")
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// ErrUpstreamUnavailable is returned when the Converty circuit breaker is open
+var ErrUpstreamUnavailable = errors.New("Converty API is unavailable, please retry later")
+
+// convertyBreakerSettings trips the breaker after five consecutive failures and probes again after 30s
+var convertyBreakerSettings = gobreaker.Settings{
+	Name:        "converty",
+	MaxRequests: 1,
+	Interval:    time.Minute,
+	Timeout:     30 * time.Second,
+	ReadyToTrip: func(counts gobreaker.Counts) bool {
+		return counts.ConsecutiveFailures >= 5
+	},
+	OnStateChange: func(name string, from, to gobreaker.State) {
+		log.Printf("Circuit breaker %s: %s -> %s", name, from, to)
+	},
+	// A caller giving up is not Converty failing
+	IsSuccessful: func(err error) bool {
+		return err == nil || errors.Is(err, context.Canceled)
+	},
+}
+
+// convertyBreaker guards every outbound Converty call so an outage fails fast
+var convertyBreaker = gobreaker.NewCircuitBreaker(convertyBreakerSettings)
+
+// upstreamFallbackCapacity bounds the last good responses kept, and upstreamFallbackTTL how stale a
+// response may be served
+const (
+	upstreamFallbackCapacity = 2000
+	upstreamFallbackTTL      = 24 * time.Hour
+)
+
+// lastGoodResponses caches the last successful body per tenant and URL for fallback while the breaker
+// is open. URLs of different stores may look alike, so a tenant is never served another's response.
+var lastGoodResponses = newLRUCache(upstreamFallbackCapacity, upstreamFallbackTTL)
+
+// upstreamServerError marks 5xx responses as breaker failures while still returning the response
+type upstreamServerError struct {
+	resp *http.Response
+}
+
+func (e *upstreamServerError) Error() string {
+	return "upstream server error: " + e.resp.Status
+}
+
+// DoUpstream executes req through the shared limiter and circuit breaker.
+// Network errors and 5xx responses count as failures; ErrUpstreamUnavailable is returned while the breaker is open
+// and ErrUpstreamBusy when no slot freed up in time. The limiter slot is held until the response body is closed.
+func DoUpstream(client *http.Client, req *http.Request) (*http.Response, error) {
+	// The wait for a slot gets its own span, so queueing shows apart from Converty's latency
+	_, wait := Tracer().Start(req.Context(), "converty.limiter")
+	release, err := UpstreamLimit.Acquire(req.Context(), upstreamPriority(req.Context()))
+	if err != nil {
+		wait.RecordError(err)
+		wait.SetStatus(codes.Error, err.Error())
+		wait.End()
+		return nil, err
+	}
+	wait.End()
+	resp, err := doBreaker(client, req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// doBreaker executes req through the circuit breaker
+func doBreaker(client *http.Client, req *http.Request) (*http.Response, error) {
+	result, err := convertyBreaker.Execute(func() (interface{}, error) {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, &upstreamServerError{resp: resp}
+		}
+		return resp, nil
+	})
+	if err != nil {
+		var serverErr *upstreamServerError
+		if errors.As(err, &serverErr) {
+			return serverErr.resp, nil
+		}
+		if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+			return nil, ErrUpstreamUnavailable
+		}
+		return nil, err
+	}
+	return result.(*http.Response), nil
+}
+
+// UpstreamState reports the circuit breaker state (closed, half-open or open)
+func UpstreamState() string {
+	return convertyBreaker.State().String()
+}
+
+// upstreamFallback exposes the last good responses to the cache registry
+type upstreamFallback struct{}
+
+func init() {
+	Caches.Register(CacheUpstream, upstreamFallback{})
+}
+
+// Invalidate forgets the fallback responses whose key, "<tenant ID> <URL>", matches pattern
+func (upstreamFallback) Invalidate(pattern string) int {
+	return lastGoodResponses.Invalidate(pattern)
+}
+
+// fallbackKey is the cache key of the last good response of a tenant for url
+func fallbackKey(tenantID uint, url string) string {
+	return strconv.FormatUint(uint64(tenantID), 10) + " " + url
+}
+
+// RememberResponse stores a successful upstream body of a tenant for fallback
+func RememberResponse(tenantID uint, url string, body []byte) {
+	lastGoodResponses.Set(fallbackKey(tenantID, url), body)
+}
+
+// CachedResponse returns the tenant's last successful upstream body for url, if any
+func CachedResponse(tenantID uint, url string) ([]byte, bool) {
+	value, ok := lastGoodResponses.Get(fallbackKey(tenantID, url))
+	if !ok {
+		return nil, false
+	}
+	return value.([]byte), true
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// TokenExpiryLeeway is taken off the lifetime of every Converty token: a token is treated as expired
+// that long before its recorded expiry, so a clock that drifted since the last Date header was seen
+// does not keep using a token Converty already refuses
+var TokenExpiryLeeway = 15 * time.Second
+
+// upstreamOffset is Converty's clock minus ours in nanoseconds, as of the last response with a Date header
+var upstreamOffset atomic.Int64
+
+// ObserveUpstreamDate records how far Converty's clock is from ours, reading the Date header of a
+// response received at receivedAt. It returns Converty's time, and false when the header is missing
+// or unreadable, in which case the last offset stays in force.
+func ObserveUpstreamDate(header http.Header, receivedAt time.Time) (time.Time, bool) {
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	upstreamOffset.Store(int64(date.Sub(receivedAt)))
+	return date, true
+}
+
+// UpstreamClockOffset is how far ahead of ours Converty's clock was last seen; negative when behind
+func UpstreamClockOffset() time.Duration {
+	return time.Duration(upstreamOffset.Load())
+}
+
+// UpstreamNow is the current time on Converty's clock: ours corrected by the last offset seen, ours
+// alone until a Converty response carried a Date header. Token expiries are compared against it.
+func UpstreamNow() time.Time {
+	return time.Now().Add(UpstreamClockOffset())
+}
+
+// UpstreamIssuedAt is when Converty issued the token of a token endpoint response with header: its
+// Date header when present, UpstreamNow otherwise. Expiries counted from it are on Converty's clock.
+func UpstreamIssuedAt(header http.Header) time.Time {
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		return date
+	}
+	return UpstreamNow()
+}
+
+// TokenExpiresWithin reports whether a token expiring at expiresAt has expired at now, or will
+// within the given window, once TokenExpiryLeeway is taken off its lifetime
+func TokenExpiresWithin(expiresAt, now time.Time, within time.Duration) bool {
+	return !now.Add(within + TokenExpiryLeeway).Before(expiresAt)
+}
+
+// clockTransport feeds the Date header of every Converty response to ObserveUpstreamDate
+type clockTransport struct {
+	Base http.RoundTripper
+}
+
+func (t clockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Base.RoundTrip(req)
+	if err == nil {
+		ObserveUpstreamDate(resp.Header, time.Now())
+	}
+	return resp, err
+}
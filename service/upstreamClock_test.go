@@ -0,0 +1,69 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUpstreamClockFollowsDateHeader(t *testing.T) {
+	defer upstreamOffset.Store(upstreamOffset.Load())
+	upstreamOffset.Store(0)
+
+	// Converty's clock runs five minutes ahead of ours
+	ahead := 5 * time.Minute
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(ahead).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+
+	resp, err := NewUpstreamClient(time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if offset := UpstreamClockOffset(); offset < ahead-2*time.Second || offset > ahead+time.Second {
+		t.Fatalf("offset = %v, want about %v", offset, ahead)
+	}
+	if drift := UpstreamNow().Sub(time.Now().Add(ahead)); drift < -2*time.Second || drift > time.Second {
+		t.Fatalf("UpstreamNow is %v off Converty's clock", drift)
+	}
+
+	issued := UpstreamIssuedAt(resp.Header)
+	if want, _ := http.ParseTime(resp.Header.Get("Date")); !issued.Equal(want) {
+		t.Fatalf("issued at %v, want the Date header %v", issued, want)
+	}
+	// Without a Date header the last offset stays and issuance falls back to UpstreamNow
+	if _, ok := ObserveUpstreamDate(http.Header{}, time.Now()); ok {
+		t.Fatal("a missing Date header should not be observed")
+	}
+	if offset := UpstreamClockOffset(); offset < ahead-2*time.Second {
+		t.Fatalf("offset reset to %v by a response without Date", offset)
+	}
+	if fallback := UpstreamIssuedAt(http.Header{}); fallback.Sub(time.Now()) < ahead-2*time.Second {
+		t.Fatalf("fallback issue time %v is not on Converty's clock", fallback)
+	}
+}
+
+func TestTokenExpiresWithin(t *testing.T) {
+	defer func(leeway time.Duration) { TokenExpiryLeeway = leeway }(TokenExpiryLeeway)
+	TokenExpiryLeeway = 10 * time.Second
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, c := range []struct {
+		expiresIn, within time.Duration
+		want              bool
+	}{
+		{time.Minute, 0, false},
+		{11 * time.Second, 0, false},
+		{10 * time.Second, 0, true},
+		{time.Minute, 50 * time.Second, true},
+		{time.Minute, 49 * time.Second, false},
+		{-time.Second, 0, true},
+	} {
+		if got := TokenExpiresWithin(now.Add(c.expiresIn), now, c.within); got != c.want {
+			t.Errorf("expiring in %v, within %v: got %v, want %v", c.expiresIn, c.within, got, c.want)
+		}
+	}
+}
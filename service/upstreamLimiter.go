@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrUpstreamBusy is returned when a Converty call waited too long for a concurrency slot or budget
+var ErrUpstreamBusy = errors.New("Converty request budget exhausted, please retry later")
+
+// UpstreamPriority orders outbound calls: interactive ones serve a waiting user or chatbot,
+// background ones come from sync jobs and may only use part of the capacity
+type UpstreamPriority int
+
+const (
+	PriorityBackground UpstreamPriority = iota
+	PriorityInteractive
+)
+
+func (p UpstreamPriority) String() string {
+	if p == PriorityInteractive {
+		return "interactive"
+	}
+	return "background"
+}
+
+type upstreamPriorityKey struct{}
+
+// WithUpstreamPriority marks the Converty calls made with ctx
+func WithUpstreamPriority(ctx context.Context, priority UpstreamPriority) context.Context {
+	return context.WithValue(ctx, upstreamPriorityKey{}, priority)
+}
+
+// upstreamPriority returns the priority of ctx; unmarked calls count as background
+func upstreamPriority(ctx context.Context) UpstreamPriority {
+	if priority, ok := ctx.Value(upstreamPriorityKey{}).(UpstreamPriority); ok {
+		return priority
+	}
+	return PriorityBackground
+}
+
+// LimiterConfig bounds the outbound Converty traffic of the whole process
+type LimiterConfig struct {
+	// MaxConcurrent caps calls in flight; 0 disables the cap
+	MaxConcurrent int
+	// PerMinute is the request budget, refilled continuously; 0 disables the budget
+	PerMinute int
+	// Burst is how many unused requests of the budget can be saved up
+	Burst int
+	// BackgroundShare is the fraction of the concurrency slots and of the burst background calls
+	// may use, keeping the rest for interactive calls
+	BackgroundShare float64
+	// MaxWait is how long a call may queue before ErrUpstreamBusy
+	MaxWait time.Duration
+	// PageWorkers is how many pages of a long listing, such as an export, are fetched at once
+	PageWorkers int
+}
+
+// LimiterStats are the limiter metrics shown to operators
+type LimiterStats struct {
+	InFlight   map[string]int `json:"in_flight"`
+	Queued     map[string]int `json:"queued"`
+	Admitted   map[string]int `json:"admitted"`
+	Rejected   map[string]int `json:"rejected"`
+	WaitedMS   map[string]int `json:"waited_ms"`
+	MaxWaitMS  int64          `json:"max_wait_ms"`
+	Tokens     float64        `json:"tokens"`
+	PerMinute  int            `json:"per_minute"`
+	Concurrent int            `json:"max_concurrent"`
+}
+
+// UpstreamLimiter combines a semaphore with a token bucket. Queued interactive calls go first,
+// and background calls cannot take the slots and tokens reserved for interactive ones.
+type UpstreamLimiter struct {
+	mu       sync.Mutex
+	cfg      LimiterConfig
+	tokens   float64
+	refilled time.Time
+	inFlight [2]int
+	queued   [2]int
+	admitted [2]int
+	rejected [2]int
+	waited   [2]time.Duration
+	maxWait  time.Duration
+	// wake is closed and replaced whenever capacity is released
+	wake chan struct{}
+}
+
+// NewUpstreamLimiter creates a limiter; zero fields of cfg get defaults
+func NewUpstreamLimiter(cfg LimiterConfig) *UpstreamLimiter {
+	cfg = cfg.withDefaults()
+	return &UpstreamLimiter{cfg: cfg, tokens: float64(cfg.Burst), refilled: time.Now(), wake: make(chan struct{})}
+}
+
+// withDefaults fills the zero fields of cfg
+func (cfg LimiterConfig) withDefaults() LimiterConfig {
+	if cfg.Burst <= 0 && cfg.PerMinute > 0 {
+		cfg.Burst = int(math.Max(1, float64(cfg.PerMinute)/4))
+	}
+	if cfg.BackgroundShare <= 0 || cfg.BackgroundShare > 1 {
+		cfg.BackgroundShare = 0.5
+	}
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = 10 * time.Second
+	}
+	if cfg.PageWorkers <= 0 {
+		cfg.PageWorkers = 1
+	}
+	return cfg
+}
+
+// Config returns the limits in force
+func (l *UpstreamLimiter) Config() LimiterConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg
+}
+
+// Reconfigure swaps the limits without dropping the calls in flight or queued. The saved budget
+// is capped to the new burst, and queued calls are woken to retry under the new limits.
+func (l *UpstreamLimiter) Reconfigure(cfg LimiterConfig) {
+	cfg = cfg.withDefaults()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.cfg = cfg
+	l.tokens = math.Min(l.tokens, float64(cfg.Burst))
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// PageWorkers is how many pages calls of priority may fetch at once: PageWorkers, but no more than the
+// concurrency slots open to the priority, so a paged fetch never queues behind itself
+func (l *UpstreamLimiter) PageWorkers(priority UpstreamPriority) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	workers := l.cfg.PageWorkers
+	if max := l.cfg.MaxConcurrent; max > 0 {
+		slots := max
+		if priority == PriorityBackground {
+			slots = int(math.Max(1, math.Floor(float64(max)*l.cfg.BackgroundShare)))
+		}
+		workers = min(workers, slots)
+	}
+	return workers
+}
+
+// UpstreamLimit is shared by every outbound Converty call of the process
+var UpstreamLimit = NewUpstreamLimiter(LimiterConfig{})
+
+// refill adds the budget earned since the last refill; the caller holds mu
+func (l *UpstreamLimiter) refill(now time.Time) {
+	if l.cfg.PerMinute <= 0 {
+		return
+	}
+	l.tokens = math.Min(float64(l.cfg.Burst), l.tokens+now.Sub(l.refilled).Minutes()*float64(l.cfg.PerMinute))
+	l.refilled = now
+}
+
+// available reports whether a call of priority may start now; the caller holds mu
+func (l *UpstreamLimiter) available(priority UpstreamPriority) bool {
+	background := priority == PriorityBackground
+	if background && l.queued[PriorityInteractive] > 0 {
+		return false
+	}
+	if max := l.cfg.MaxConcurrent; max > 0 {
+		if l.inFlight[0]+l.inFlight[1] >= max {
+			return false
+		}
+		if background && l.inFlight[PriorityBackground] >= int(math.Max(1, math.Floor(float64(max)*l.cfg.BackgroundShare))) {
+			return false
+		}
+	}
+	if l.cfg.PerMinute > 0 {
+		reserve := 0.0
+		if background {
+			reserve = math.Floor(float64(l.cfg.Burst) * (1 - l.cfg.BackgroundShare))
+		}
+		if l.tokens < 1+reserve {
+			return false
+		}
+	}
+	return true
+}
+
+// nextToken estimates when the bucket gains its next request; the caller holds mu
+func (l *UpstreamLimiter) nextToken() time.Duration {
+	if l.cfg.PerMinute <= 0 {
+		return time.Second
+	}
+	return time.Duration(float64(time.Minute) / float64(l.cfg.PerMinute))
+}
+
+// Acquire waits for capacity and returns the function that gives it back
+func (l *UpstreamLimiter) Acquire(ctx context.Context, priority UpstreamPriority) (func(), error) {
+	start := time.Now()
+	l.mu.Lock()
+	deadline := time.NewTimer(l.cfg.MaxWait)
+	defer deadline.Stop()
+	queued := false
+	for {
+		now := time.Now()
+		l.refill(now)
+		if queued {
+			// Our own queued entry must not block us
+			l.queued[priority]--
+		}
+		if l.available(priority) {
+			l.inFlight[priority]++
+			if l.cfg.PerMinute > 0 {
+				l.tokens--
+			}
+			l.admitted[priority]++
+			waited := now.Sub(start)
+			l.waited[priority] += waited
+			if waited > l.maxWait {
+				l.maxWait = waited
+			}
+			l.mu.Unlock()
+			return l.releaser(priority), nil
+		}
+		l.queued[priority]++
+		queued = true
+		wake, retry := l.wake, time.NewTimer(l.nextToken())
+		l.mu.Unlock()
+
+		var err error
+		select {
+		case <-wake:
+		case <-retry.C:
+		case <-deadline.C:
+			err = ErrUpstreamBusy
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		retry.Stop()
+		l.mu.Lock()
+		if err != nil {
+			l.queued[priority]--
+			l.rejected[priority]++
+			l.mu.Unlock()
+			return nil, err
+		}
+	}
+}
+
+// releaser frees the slot of one call exactly once
+func (l *UpstreamLimiter) releaser(priority UpstreamPriority) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.inFlight[priority]--
+			close(l.wake)
+			l.wake = make(chan struct{})
+			l.mu.Unlock()
+		})
+	}
+}
+
+// Stats returns a snapshot of the limiter metrics
+func (l *UpstreamLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	stats := LimiterStats{
+		InFlight:   map[string]int{},
+		Queued:     map[string]int{},
+		Admitted:   map[string]int{},
+		Rejected:   map[string]int{},
+		WaitedMS:   map[string]int{},
+		MaxWaitMS:  l.maxWait.Milliseconds(),
+		Tokens:     math.Floor(l.tokens*10) / 10,
+		PerMinute:  l.cfg.PerMinute,
+		Concurrent: l.cfg.MaxConcurrent,
+	}
+	for _, priority := range []UpstreamPriority{PriorityBackground, PriorityInteractive} {
+		name := priority.String()
+		stats.InFlight[name] = l.inFlight[priority]
+		stats.Queued[name] = l.queued[priority]
+		stats.Admitted[name] = l.admitted[priority]
+		stats.Rejected[name] = l.rejected[priority]
+		stats.WaitedMS[name] = int(l.waited[priority].Milliseconds())
+	}
+	return stats
+}
+
+// releasingBody gives the limiter slot back once the response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
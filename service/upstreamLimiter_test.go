@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUpstreamLimiterReservesSlotsForInteractive(t *testing.T) {
+	limiter := NewUpstreamLimiter(LimiterConfig{MaxConcurrent: 2, BackgroundShare: 0.5, MaxWait: 20 * time.Millisecond})
+	ctx := context.Background()
+
+	release, err := limiter.Acquire(ctx, PriorityBackground)
+	if err != nil {
+		t.Fatalf("first background call: %v", err)
+	}
+	if _, err := limiter.Acquire(ctx, PriorityBackground); !errors.Is(err, ErrUpstreamBusy) {
+		t.Fatalf("background over its share: got %v, want ErrUpstreamBusy", err)
+	}
+	interactive, err := limiter.Acquire(ctx, PriorityInteractive)
+	if err != nil {
+		t.Fatalf("interactive call must use the reserved slot: %v", err)
+	}
+	interactive()
+	release()
+	release() // releasing twice must not free a second slot
+
+	stats := limiter.Stats()
+	if stats.InFlight["background"] != 0 || stats.InFlight["interactive"] != 0 {
+		t.Fatalf("in flight after release: %v", stats.InFlight)
+	}
+	if stats.Rejected["background"] != 1 || stats.Admitted["interactive"] != 1 {
+		t.Fatalf("unexpected counters: %+v", stats)
+	}
+}
+
+func TestUpstreamLimiterBudget(t *testing.T) {
+	limiter := NewUpstreamLimiter(LimiterConfig{PerMinute: 60, Burst: 4, BackgroundShare: 0.5, MaxWait: 10 * time.Millisecond})
+	ctx := context.Background()
+
+	// Background calls stop at the half of the burst kept for interactive ones
+	admitted := 0
+	for i := 0; i < 4; i++ {
+		if _, err := limiter.Acquire(ctx, PriorityBackground); err == nil {
+			admitted++
+		}
+	}
+	if admitted != 2 {
+		t.Fatalf("background admitted %d of a burst of 4, want 2", admitted)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Acquire(ctx, PriorityInteractive); err != nil {
+			t.Fatalf("interactive call %d: %v", i, err)
+		}
+	}
+	if _, err := limiter.Acquire(ctx, PriorityInteractive); !errors.Is(err, ErrUpstreamBusy) {
+		t.Fatalf("exhausted budget: got %v, want ErrUpstreamBusy", err)
+	}
+}
+
+func TestUpstreamLimiterQueuedInteractiveGoesFirst(t *testing.T) {
+	limiter := NewUpstreamLimiter(LimiterConfig{MaxConcurrent: 1, MaxWait: time.Second})
+	ctx := context.Background()
+
+	release, err := limiter.Acquire(ctx, PriorityInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := make(chan UpstreamPriority, 2)
+	acquire := func(priority UpstreamPriority) {
+		done, err := limiter.Acquire(ctx, priority)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		order <- priority
+		done()
+	}
+	go acquire(PriorityBackground)
+	time.Sleep(10 * time.Millisecond)
+	go acquire(PriorityInteractive)
+	for limiter.Stats().Queued["interactive"] == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	release()
+
+	if first := <-order; first != PriorityInteractive {
+		t.Fatalf("first admitted after release: %v, want interactive", first)
+	}
+	<-order
+}
+
+func TestUpstreamLimiterHonoursContext(t *testing.T) {
+	limiter := NewUpstreamLimiter(LimiterConfig{MaxConcurrent: 1, MaxWait: time.Second})
+	if _, err := limiter.Acquire(context.Background(), PriorityInteractive); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Acquire(ctx, PriorityInteractive); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context deadline", err)
+	}
+	if queued := limiter.Stats().Queued["interactive"]; queued != 0 {
+		t.Fatalf("cancelled call left %d queued", queued)
+	}
+}
+
+func TestUpstreamLimiterReconfigure(t *testing.T) {
+	limiter := NewUpstreamLimiter(LimiterConfig{MaxConcurrent: 1, MaxWait: time.Second})
+	ctx := context.Background()
+	release, err := limiter.Acquire(ctx, PriorityInteractive)
+	if err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+
+	// A queued call is admitted as soon as the limit is raised, without waiting for a release
+	admitted := make(chan error, 1)
+	go func() {
+		_, err := limiter.Acquire(ctx, PriorityInteractive)
+		admitted <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	limiter.Reconfigure(LimiterConfig{MaxConcurrent: 2, MaxWait: time.Second})
+	select {
+	case err := <-admitted:
+		if err != nil {
+			t.Fatalf("queued call after raising the limit: %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("queued call was not woken by Reconfigure")
+	}
+	release()
+	if got := limiter.Config().MaxConcurrent; got != 2 {
+		t.Fatalf("MaxConcurrent = %d after Reconfigure, want 2", got)
+	}
+}
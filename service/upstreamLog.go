@@ -0,0 +1,239 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upstreamBodySnippet is how much of a response body an exchange keeps for debugging
+const upstreamBodySnippet = 2048
+
+// redacted replaces secrets in logged exchanges
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are never logged in clear
+var sensitiveHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"Set-Cookie":    true,
+	"X-Api-Key":     true,
+	"X-Admin-Key":   true,
+}
+
+// sensitiveParams are query parameters carrying credentials
+var sensitiveParams = []string{"access_token", "refresh_token", "token", "code", "client_secret", "api_key"}
+
+// sensitiveBodyFields matches JSON and form fields carrying credentials
+var sensitiveBodyFields = regexp.MustCompile(`(?i)("(?:access_?token|refresh_?token|id_?token|client_?secret|token)"\s*:\s*)"[^"]*"|((?:access_token|refresh_token|client_secret)=)[^&\s]*`)
+
+// UpstreamExchange is one logged call to the Converty API
+type UpstreamExchange struct {
+	Time           time.Time         `json:"time"`
+	Method         string            `json:"method"`
+	URL            string            `json:"url"`
+	Status         int               `json:"status"`
+	LatencyMS      int64             `json:"latency_ms"`
+	Error          string            `json:"error,omitempty"`
+	RequestHeader  map[string]string `json:"request_headers,omitempty"`
+	ResponseHeader map[string]string `json:"response_headers,omitempty"`
+	// ResponseBody is the redacted start of the body, kept for 4xx and 5xx responses
+	ResponseBody string `json:"response_body,omitempty"`
+}
+
+// UpstreamRequest is a prepared Converty call that was not sent, as a dry run reports it
+type UpstreamRequest struct {
+	Method string            `json:"method"`
+	URL    string            `json:"url"`
+	Header map[string]string `json:"headers,omitempty"`
+	Body   json.RawMessage   `json:"body,omitempty"`
+}
+
+// DescribeRequest redacts a prepared request and its JSON body
+func DescribeRequest(req *http.Request, body []byte) UpstreamRequest {
+	described := UpstreamRequest{
+		Method: req.Method,
+		URL:    RedactURL(req.URL),
+		Header: redactHeader(req.Header),
+	}
+	if len(body) > 0 {
+		described.Body = json.RawMessage(RedactSecrets(string(body)))
+	}
+	return described
+}
+
+// UpstreamLog keeps the last exchanges in a fixed-size ring buffer
+type UpstreamLog struct {
+	mu        sync.Mutex
+	exchanges []UpstreamExchange
+	next      int
+	full      bool
+}
+
+// NewUpstreamLog creates a ring buffer holding up to size exchanges
+func NewUpstreamLog(size int) *UpstreamLog {
+	if size <= 0 {
+		size = 1
+	}
+	return &UpstreamLog{exchanges: make([]UpstreamExchange, size)}
+}
+
+// Record stores an exchange, overwriting the oldest one when the buffer is full
+func (l *UpstreamLog) Record(exchange UpstreamExchange) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.exchanges[l.next] = exchange
+	l.next = (l.next + 1) % len(l.exchanges)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Recent returns the logged exchanges, newest first
+func (l *UpstreamLog) Recent() []UpstreamExchange {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.exchanges)
+	}
+	recent := make([]UpstreamExchange, 0, count)
+	for i := 1; i <= count; i++ {
+		recent = append(recent, l.exchanges[(l.next-i+len(l.exchanges))%len(l.exchanges)])
+	}
+	return recent
+}
+
+// UpstreamExchanges is the log of the Converty calls made by this process
+var UpstreamExchanges = NewUpstreamLog(200)
+
+// LoggingTransport logs every request it carries and records it in an UpstreamLog
+type LoggingTransport struct {
+	Base http.RoundTripper
+	Log  *UpstreamLog
+	// Events, when set, receives an upstream.call event per request
+	Events *EventBus
+}
+
+// RoundTrip sends the request through the base transport and records the redacted exchange
+func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = outboundTransport{}
+	}
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+
+	exchange := UpstreamExchange{
+		Time:          start,
+		Method:        req.Method,
+		URL:           RedactURL(req.URL),
+		LatencyMS:     time.Since(start).Milliseconds(),
+		RequestHeader: redactHeader(req.Header),
+	}
+	if err != nil {
+		exchange.Error = RedactSecrets(err.Error())
+		log.Printf("Upstream %s %s failed after %dms: %s", exchange.Method, exchange.URL, exchange.LatencyMS, exchange.Error)
+	} else {
+		exchange.Status = resp.StatusCode
+		exchange.ResponseHeader = redactHeader(resp.Header)
+		if resp.StatusCode >= http.StatusBadRequest {
+			exchange.ResponseBody = peekBody(resp)
+		}
+		log.Printf("Upstream %s %s -> %d (%dms)", exchange.Method, exchange.URL, exchange.Status, exchange.LatencyMS)
+	}
+	if t.Log != nil {
+		t.Log.Record(exchange)
+	}
+	if t.Events != nil {
+		t.Events.Publish(Event{
+			Type: EventUpstreamCall,
+			Data: map[string]interface{}{"status": exchange.Status, "failed": err != nil || exchange.Status >= http.StatusInternalServerError},
+			Time: start,
+		})
+	}
+	return resp, err
+}
+
+// NewUpstreamClient creates an HTTP client for Converty whose calls are traced and logged in UpstreamExchanges,
+// and whose responses keep UpstreamNow in step with Converty's clock
+func NewUpstreamClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &TracingTransport{
+			Base: clockTransport{Base: &LoggingTransport{Base: outboundTransport{}, Log: UpstreamExchanges, Events: Events}},
+			Peer: "converty",
+		},
+	}
+}
+
+// peekBody reads the start of the response body for the log and puts it back for the caller
+func peekBody(resp *http.Response) string {
+	if resp.Body == nil {
+		return ""
+	}
+	snippet, err := io.ReadAll(io.LimitReader(resp.Body, upstreamBodySnippet))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(snippet), resp.Body), resp.Body}
+	if err != nil {
+		return ""
+	}
+	return RedactSecrets(string(snippet))
+}
+
+// redactHeader flattens a header, hiding credentials
+func redactHeader(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	flat := make(map[string]string, len(header))
+	for name, values := range header {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			flat[name] = redacted
+			continue
+		}
+		flat[name] = strings.Join(values, ", ")
+	}
+	return flat
+}
+
+// RedactURL hides credentials passed as query parameters
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	clean := *u
+	clean.User = nil
+	query := clean.Query()
+	changed := false
+	for _, name := range sensitiveParams {
+		if query.Has(name) {
+			query.Set(name, redacted)
+			changed = true
+		}
+	}
+	if changed {
+		clean.RawQuery = query.Encode()
+	}
+	return clean.String()
+}
+
+// RedactSecrets hides token fields in JSON or form encoded text
+func RedactSecrets(text string) string {
+	return sensitiveBodyFields.ReplaceAllStringFunc(text, func(match string) string {
+		parts := sensitiveBodyFields.FindStringSubmatch(match)
+		if parts[1] != "" {
+			return parts[1] + `"` + redacted + `"`
+		}
+		return parts[2] + redacted
+	})
+}
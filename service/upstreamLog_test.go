@@ -0,0 +1,77 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpstreamLogRing(t *testing.T) {
+	log := NewUpstreamLog(3)
+	for _, method := range []string{"A", "B", "C", "D"} {
+		log.Record(UpstreamExchange{Method: method})
+	}
+	recent := log.Recent()
+	var got []string
+	for _, exchange := range recent {
+		got = append(got, exchange.Method)
+	}
+	if strings.Join(got, "") != "DCB" {
+		t.Errorf("got %v, want newest first without the overwritten A", got)
+	}
+}
+
+func TestLoggingTransportRedacts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":"invalid_grant","refresh_token":"r-secret"}`)
+	}))
+	defer server.Close()
+
+	log := NewUpstreamLog(5)
+	client := &http.Client{Transport: &LoggingTransport{Log: log}}
+	req, _ := http.NewRequest("GET", server.URL+"/api/v1/orders?page=2&access_token=a-secret", nil)
+	req.Header.Set("Authorization", "Bearer a-secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "r-secret") {
+		t.Errorf("caller lost the response body: %s", body)
+	}
+
+	recent := log.Recent()
+	if len(recent) != 1 {
+		t.Fatalf("got %d exchanges, want 1", len(recent))
+	}
+	exchange := recent[0]
+	if exchange.Status != http.StatusBadRequest || exchange.Method != "GET" {
+		t.Errorf("unexpected exchange %+v", exchange)
+	}
+	logged := exchange.URL + exchange.ResponseBody + exchange.RequestHeader["Authorization"]
+	if strings.Contains(logged, "secret") {
+		t.Errorf("secrets leaked into the log: %s", logged)
+	}
+	if !strings.Contains(exchange.URL, "page=2") || !strings.Contains(exchange.ResponseBody, "invalid_grant") {
+		t.Errorf("redaction removed too much: %s %s", exchange.URL, exchange.ResponseBody)
+	}
+}
+
+func TestDescribeRequestRedacts(t *testing.T) {
+	body := []byte(`{"note":"gift","token":"t-secret"}`)
+	req := httptest.NewRequest("POST", "https://api.converty.shop/api/v1/orders?store_id=s1&access_token=a-secret", nil)
+	req.Header.Set("Authorization", "Bearer b-secret")
+
+	described := DescribeRequest(req, body)
+	logged := described.URL + described.Header["Authorization"] + string(described.Body)
+	if strings.Contains(logged, "secret") {
+		t.Errorf("secrets leaked into the preview: %s", logged)
+	}
+	if described.Method != "POST" || !strings.Contains(described.URL, "store_id=s1") || !strings.Contains(string(described.Body), `"note":"gift"`) {
+		t.Errorf("unexpected preview %+v", described)
+	}
+}
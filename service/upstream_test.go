@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestBreakerIgnoresCancelledCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:1/api/v1/orders", nil)
+		if _, err := doBreaker(http.DefaultClient, req); err == nil {
+			t.Fatal("cancelled call succeeded")
+		}
+	}
+	if state := convertyBreaker.State(); state != gobreaker.StateClosed {
+		t.Fatalf("callers giving up tripped the breaker: %s", state)
+	}
+}
+
+func TestBreakerTripsOnServerErrors(t *testing.T) {
+	saved := convertyBreaker
+	convertyBreaker = gobreaker.NewCircuitBreaker(convertyBreakerSettings)
+	defer func() { convertyBreaker = saved }()
+
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	call := func() (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/orders", nil)
+		resp, err := doBreaker(server.Client(), req)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	// Client errors are Converty answering, not failing
+	for i := 0; i < 10; i++ {
+		if resp, err := call(); err != nil || resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("404 call %d: %v", i, err)
+		}
+	}
+	if state := convertyBreaker.State(); state != gobreaker.StateClosed {
+		t.Fatalf("client errors tripped the breaker: %s", state)
+	}
+
+	status = http.StatusBadGateway
+	for i := 0; i < 5; i++ {
+		// The caller still sees the 5xx response while the breaker counts it
+		if resp, err := call(); err != nil || resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("502 call %d: %v", i, err)
+		}
+	}
+	if state := convertyBreaker.State(); state != gobreaker.StateOpen {
+		t.Fatalf("five server errors left the breaker %s", state)
+	}
+	if _, err := call(); !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Fatalf("open breaker returned %v, want ErrUpstreamUnavailable", err)
+	}
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// MinPasswordLength is the shortest password an operator may choose
+const MinPasswordLength = 8
+
+// Errors of the user service
+var (
+	// ErrUserNotFound is returned when no operator has the ID
+	ErrUserNotFound = errors.New("user not found")
+	// ErrInvalidCredentials is returned for an unknown username, a wrong password or a disabled user;
+	// the cases are not told apart so logins cannot probe for usernames
+	ErrInvalidCredentials = errors.New("invalid username or password")
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,63}$`)
+
+// User is an operator of the local API, such as a support agent using the console or the dashboard.
+// Passwords are stored as bcrypt hashes; the role is one of the access policy's staff roles.
+type User struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Username     string     `gorm:"not null;uniqueIndex" json:"username"`
+	Email        string     `json:"email,omitempty"`
+	PasswordHash string     `gorm:"not null" json:"-"`
+	Role         string     `gorm:"not null" json:"role"`
+	Tenant       string     `gorm:"not null;index" json:"tenant"`
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+	DisabledAt   *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for User
+func (User) TableName() string {
+	return "public.users"
+}
+
+// UserUpdate changes the fields that are set
+type UserUpdate struct {
+	Email    *string
+	Role     *string
+	Password *string
+	Disabled *bool
+}
+
+// UserService defines the interface for operator accounts
+type UserService interface {
+	CreateUser(user User, password string) (User, error)
+	ListUsers(tenant string) ([]User, error)
+	GetUser(id uint) (User, error)
+	GetUserByUsername(username string) (User, error)
+	UpdateUser(id uint, update UserUpdate) (User, error)
+	DeleteUser(id uint) error
+	// Authenticate checks a password and records the login
+	Authenticate(username, password string) (User, error)
+}
+
+// GormUserService implements UserService using GORM
+type GormUserService struct {
+	db *gorm.DB
+	// cost is the bcrypt cost of new hashes
+	cost int
+}
+
+// NewGormUserService creates a new GormUserService
+func NewGormUserService(db *gorm.DB) UserService {
+	return &GormUserService{db: db, cost: bcrypt.DefaultCost}
+}
+
+// NormalizeUsername lower-cases a username and rejects malformed ones with ErrValidation
+func NormalizeUsername(username string) (string, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if !usernamePattern.MatchString(username) {
+		return "", fmt.Errorf("%w: username %q must be 2-64 letters, digits, '.', '_' or '-'", ErrValidation, username)
+	}
+	return username, nil
+}
+
+// HashPassword checks the password rules and returns the bcrypt hash of password
+func HashPassword(password string, cost int) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("%w: the password must be at least %d characters", ErrValidation, MinPasswordLength)
+	}
+	// bcrypt ignores everything after 72 bytes, so longer passwords would be silently truncated
+	if len(password) > 72 {
+		return "", fmt.Errorf("%w: the password must be at most 72 bytes", ErrValidation)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
+
+// CreateUser stores a new operator with a hashed password
+func (s *GormUserService) CreateUser(user User, password string) (User, error) {
+	username, err := NormalizeUsername(user.Username)
+	if err != nil {
+		return User{}, err
+	}
+	if user.Role == "" || user.Tenant == "" {
+		return User{}, fmt.Errorf("%w: a user needs a role and a tenant", ErrValidation)
+	}
+	hash, err := HashPassword(password, s.cost)
+	if err != nil {
+		return User{}, err
+	}
+	var existing int64
+	if err := s.db.Model(&User{}).Where("username = ?", username).Count(&existing).Error; err != nil {
+		return User{}, fmt.Errorf("failed to check username: %v", err)
+	}
+	if existing > 0 {
+		return User{}, fmt.Errorf("%w: username %q is taken", ErrValidation, username)
+	}
+	user.ID = 0
+	user.Username = username
+	user.PasswordHash = hash
+	user.LastLoginAt, user.DisabledAt = nil, nil
+	if err := s.db.Create(&user).Error; err != nil {
+		return User{}, fmt.Errorf("failed to create user: %v", err)
+	}
+	return user, nil
+}
+
+// ListUsers fetches the operators of a tenant, or of every tenant when tenant is empty
+func (s *GormUserService) ListUsers(tenant string) ([]User, error) {
+	query := s.db.Order("username")
+	if tenant != "" {
+		query = query.Where("tenant = ?", tenant)
+	}
+	var users []User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch users: %v", err)
+	}
+	return users, nil
+}
+
+// GetUser fetches one operator
+func (s *GormUserService) GetUser(id uint) (User, error) {
+	var user User
+	if err := s.db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return User{}, fmt.Errorf("%w: %d", ErrUserNotFound, id)
+		}
+		return User{}, fmt.Errorf("failed to fetch user %d: %v", id, err)
+	}
+	return user, nil
+}
+
+// GetUserByUsername fetches one operator by login name
+func (s *GormUserService) GetUserByUsername(username string) (User, error) {
+	var user User
+	result := s.db.Where("username = ?", strings.ToLower(strings.TrimSpace(username))).Limit(1).Find(&user)
+	if result.Error != nil {
+		return User{}, fmt.Errorf("failed to fetch user %s: %v", username, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return User{}, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	return user, nil
+}
+
+// UpdateUser changes the email, role, password or disabled state of an operator
+func (s *GormUserService) UpdateUser(id uint, update UserUpdate) (User, error) {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return User{}, err
+	}
+	changes := map[string]interface{}{}
+	if update.Email != nil {
+		changes["email"] = strings.TrimSpace(*update.Email)
+	}
+	if update.Role != nil {
+		if *update.Role == "" {
+			return User{}, fmt.Errorf("%w: the role cannot be empty", ErrValidation)
+		}
+		changes["role"] = *update.Role
+	}
+	if update.Password != nil {
+		hash, err := HashPassword(*update.Password, s.cost)
+		if err != nil {
+			return User{}, err
+		}
+		changes["password_hash"] = hash
+	}
+	if update.Disabled != nil {
+		if *update.Disabled && user.DisabledAt == nil {
+			changes["disabled_at"] = time.Now()
+		} else if !*update.Disabled {
+			changes["disabled_at"] = nil
+		}
+	}
+	if len(changes) == 0 {
+		return user, nil
+	}
+	if err := s.db.Model(&user).Updates(changes).Error; err != nil {
+		return User{}, fmt.Errorf("failed to update user %d: %v", id, err)
+	}
+	return s.GetUser(id)
+}
+
+// DeleteUser removes an operator
+func (s *GormUserService) DeleteUser(id uint) error {
+	result := s.db.Delete(&User{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete user %d: %v", id, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %d", ErrUserNotFound, id)
+	}
+	return nil
+}
+
+// Authenticate returns the enabled operator with this username and password
+func (s *GormUserService) Authenticate(username, password string) (User, error) {
+	user, err := s.GetUserByUsername(username)
+	if errors.Is(err, ErrUserNotFound) {
+		// Spend the time of a comparison anyway so unknown usernames do not answer faster
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil || user.DisabledAt != nil {
+		return User{}, ErrInvalidCredentials
+	}
+	now := time.Now()
+	if err := s.db.Model(&user).Update("last_login_at", now).Error; err != nil {
+		return User{}, fmt.Errorf("failed to record login: %v", err)
+	}
+	user.LastLoginAt = &now
+	return user, nil
+}
+
+// dummyPasswordHash is compared against for unknown usernames; it is computed on first use
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("convertyapi-no-such-user"), bcrypt.DefaultCost)
+	return hash
+})
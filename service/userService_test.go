@@ -0,0 +1,37 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	for input, want := range map[string]string{"Amira": "amira", " sami.b ": "sami.b", "ops_2": "ops_2"} {
+		if got, err := NormalizeUsername(input); err != nil || got != want {
+			t.Errorf("NormalizeUsername(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "a", "-amira", "amira sami", strings.Repeat("a", 65)} {
+		if _, err := NormalizeUsername(bad); !errors.Is(err, ErrValidation) {
+			t.Errorf("NormalizeUsername(%q) accepted: %v", bad, err)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte("correct horse")) != nil || strings.Contains(hash, "horse") {
+		t.Errorf("unexpected hash %q", hash)
+	}
+	for _, bad := range []string{"short", strings.Repeat("x", 73)} {
+		if _, err := HashPassword(bad, bcrypt.MinCost); !errors.Is(err, ErrValidation) {
+			t.Errorf("password of %d characters accepted: %v", len(bad), err)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Waitlist entry statuses
+const (
+	WaitlistWaiting   = "waiting"
+	WaitlistNotified  = "notified"
+	WaitlistConverted = "converted"
+	// WaitlistSuppressed entries are not notified: the customer has not consented to messages
+	WaitlistSuppressed = "suppressed"
+)
+
+// ProductStock is the last stock level seen for a product, used to detect replenishment
+type ProductStock struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	TenantID      uint       `gorm:"not null;default:0;uniqueIndex:idx_stock_tenant_product" json:"tenant_id"`
+	ProductID     string     `gorm:"not null;uniqueIndex:idx_stock_tenant_product" json:"product_id"`
+	Name          string     `json:"name"`
+	Stock         int        `json:"stock"`
+	Price         float64    `json:"price"`
+	Currency      string     `json:"currency,omitempty"`
+	ReplenishedAt *time.Time `json:"replenished_at,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for ProductStock
+func (ProductStock) TableName() string {
+	return "chatbot.product_stock"
+}
+
+// WaitlistEntry is a customer waiting for an out-of-stock product
+type WaitlistEntry struct {
+	ID               uint       `gorm:"primaryKey" json:"id"`
+	TenantID         uint       `gorm:"not null;default:0;index" json:"tenant_id"`
+	ProductID        string     `gorm:"not null;index" json:"product_id"`
+	ProductName      string     `json:"product_name"`
+	CustomerPhone    string     `gorm:"not null;index" json:"customer_phone"`
+	UserID           uint       `gorm:"column:user_id" json:"user_id"`
+	Channel          string     `json:"channel,omitempty"`
+	Status           string     `gorm:"not null;index" json:"status"`
+	CreatedAt        time.Time  `json:"created_at"`
+	NotifiedAt       *time.Time `json:"notified_at,omitempty"`
+	ConvertedAt      *time.Time `json:"converted_at,omitempty"`
+	ConvertedOrderID string     `json:"converted_order_id,omitempty"`
+}
+
+// TableName specifies the table name for WaitlistEntry
+func (WaitlistEntry) TableName() string {
+	return "chatbot.waitlists"
+}
+
+// WaitlistRequest is the chatbot's question about a product for a customer
+type WaitlistRequest struct {
+	CustomerPhone string `json:"phone"`
+	UserID        uint   `json:"user_id"`
+	Channel       string `json:"channel"`
+}
+
+// Availability is the auto-response to a product request
+type Availability struct {
+	Product    Product        `json:"product"`
+	InStock    bool           `json:"in_stock"`
+	Waitlisted bool           `json:"waitlisted"`
+	Entry      *WaitlistEntry `json:"entry,omitempty"`
+	Message    string         `json:"message"`
+}
+
+// StockSyncResult summarizes a stock sync run
+type StockSyncResult struct {
+	Products    int      `json:"products"`
+	Replenished []string `json:"replenished"`
+	Notified    int      `json:"notified"`
+	Converted   int      `json:"converted"`
+	Alerts      int      `json:"alerts"`
+}
+
+// WaitlistConversion is the waitlist funnel of one product
+type WaitlistConversion struct {
+	ProductID   string  `json:"product_id"`
+	ProductName string  `json:"product_name"`
+	Waiting     int     `json:"waiting"`
+	Notified    int     `json:"notified"`
+	Converted   int     `json:"converted"`
+	Rate        float64 `json:"conversion_rate"`
+}
+
+// WaitlistService defines the interface for the backorder waitlist
+type WaitlistService interface {
+	CheckAvailability(tenantID uint, dataService DataService, productID string, req WaitlistRequest) (Availability, error)
+	ListEntries(tenantID uint, status, productID string) ([]WaitlistEntry, error)
+	SyncStock(tenantID uint, dataService DataService, maxPages int) (StockSyncResult, error)
+	ConversionReport(tenantID uint) ([]WaitlistConversion, error)
+}
+
+// GormWaitlistService implements WaitlistService using GORM
+type GormWaitlistService struct {
+	db *gorm.DB
+	// notifier delivers back-in-stock messages to the chatbot
+	notifier Notifier
+	// alerts, when set, raises price and stock alerts from the snapshots taken by SyncStock
+	alerts ProductAlertService
+}
+
+// NewGormWaitlistService creates a new GormWaitlistService; alerts may be nil
+func NewGormWaitlistService(db *gorm.DB, notifier Notifier, alerts ProductAlertService) WaitlistService {
+	return &GormWaitlistService{db: db, notifier: notifier, alerts: alerts}
+}
+
+// CheckAvailability answers a product request, adding the customer to the waitlist when it is out of stock
+func (s *GormWaitlistService) CheckAvailability(tenantID uint, dataService DataService, productID string, req WaitlistRequest) (Availability, error) {
+	product, err := dataService.GetProduct(productID)
+	if err != nil {
+		return Availability{}, err
+	}
+	if product.InStock() {
+		return Availability{
+			Product: product,
+			InStock: true,
+			Message: fmt.Sprintf("%s is available.", product.Name),
+		}, nil
+	}
+
+	phone := NormalizePhone(req.CustomerPhone)
+	if phone == "" {
+		return Availability{
+			Product: product,
+			Message: fmt.Sprintf("%s is out of stock.", product.Name),
+		}, nil
+	}
+
+	// One waiting entry per customer and product
+	var entry WaitlistEntry
+	result := s.db.Where("tenant_id = ? AND product_id = ? AND customer_phone = ? AND status = ?",
+		tenantID, productID, phone, WaitlistWaiting).Limit(1).Find(&entry)
+	if result.Error != nil {
+		return Availability{}, fmt.Errorf("failed to check waitlist: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		entry = WaitlistEntry{
+			TenantID:      tenantID,
+			ProductID:     productID,
+			ProductName:   product.Name,
+			CustomerPhone: phone,
+			UserID:        req.UserID,
+			Channel:       req.Channel,
+			Status:        WaitlistWaiting,
+		}
+		if err := s.db.Create(&entry).Error; err != nil {
+			return Availability{}, fmt.Errorf("failed to add to waitlist: %v", err)
+		}
+	}
+	return Availability{
+		Product:    product,
+		Waitlisted: true,
+		Entry:      &entry,
+		Message:    fmt.Sprintf("%s is out of stock. We will let you know as soon as it is back.", product.Name),
+	}, nil
+}
+
+// ListEntries fetches waitlist entries, optionally filtered by status and product
+func (s *GormWaitlistService) ListEntries(tenantID uint, status, productID string) ([]WaitlistEntry, error) {
+	var entries []WaitlistEntry
+	query := s.db.Where("tenant_id = ?", tenantID).Order("created_at desc")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+	if err := query.Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch waitlist: %v", err)
+	}
+	return entries, nil
+}
+
+// SyncStock records the stock and price of every product, notifies the waitlist of replenished products,
+// raises alerts for crossed thresholds and marks notified customers who have since ordered the product as converted
+func (s *GormWaitlistService) SyncStock(tenantID uint, dataService DataService, maxPages int) (StockSyncResult, error) {
+	const limit = 50
+	result := StockSyncResult{Replenished: []string{}}
+	now := time.Now()
+	stockChanged := false
+	fetch := func(page int) ([]Product, error) { return dataService.ListProducts(page, limit) }
+	err := FetchPages(UpstreamLimit.PageWorkers(PriorityBackground), limit, maxPages, fetch, func(page int, products []Product) (bool, error) {
+		for _, product := range products {
+			previous, found, err := s.recordStock(tenantID, product, now)
+			if err != nil {
+				return false, err
+			}
+			replenished := found && previous.Stock <= 0 && product.InStock()
+			stockChanged = stockChanged || (found && (previous.Stock != product.Stock || previous.Price != product.Price))
+			result.Products++
+			if found && s.alerts != nil {
+				alerts, err := s.alerts.Observe(tenantID, previous, product)
+				if err != nil {
+					log.Printf("Failed to raise alerts for %s: %v", product.ID, err)
+				}
+				result.Alerts += len(alerts)
+			}
+			if replenished {
+				result.Replenished = append(result.Replenished, product.ID)
+				result.Notified += s.notifyWaitlist(tenantID, product, now)
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return result, err
+	}
+	if stockChanged {
+		Caches.ProductsChanged("", "")
+	}
+
+	converted, err := s.detectConversions(tenantID, dataService)
+	if err != nil {
+		return result, err
+	}
+	result.Converted = converted
+	return result, nil
+}
+
+// recordStock stores the product snapshot and returns the one it replaced; found is false for a new product
+func (s *GormWaitlistService) recordStock(tenantID uint, product Product, now time.Time) (previous ProductStock, found bool, err error) {
+	result := s.db.Where("tenant_id = ? AND product_id = ?", tenantID, product.ID).Limit(1).Find(&previous)
+	if result.Error != nil {
+		return previous, false, fmt.Errorf("failed to read stock of %s: %v", product.ID, result.Error)
+	}
+	found = result.RowsAffected > 0
+
+	stock := ProductStock{
+		TenantID: tenantID, ProductID: product.ID, Name: product.Name,
+		Stock: product.Stock, Price: product.Price, Currency: product.Currency, UpdatedAt: now,
+	}
+	columns := []string{"name", "stock", "price", "currency", "updated_at"}
+	if found && previous.Stock <= 0 && product.InStock() {
+		stock.ReplenishedAt = &now
+		columns = append(columns, "replenished_at")
+	}
+	if err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "product_id"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(&stock).Error; err != nil {
+		return previous, found, fmt.Errorf("failed to save stock of %s: %v", product.ID, err)
+	}
+	return previous, found, nil
+}
+
+// notifyWaitlist tells the chatbot to message every waiting customer and returns how many were notified
+func (s *GormWaitlistService) notifyWaitlist(tenantID uint, product Product, now time.Time) int {
+	var entries []WaitlistEntry
+	if err := s.db.Where("tenant_id = ? AND product_id = ? AND status = ?", tenantID, product.ID, WaitlistWaiting).Find(&entries).Error; err != nil {
+		log.Printf("Failed to load waitlist of %s: %v", product.ID, err)
+		return 0
+	}
+	notified := 0
+	for _, entry := range entries {
+		err := s.notifier.Notify(Notification{
+			Event:   "back_in_stock",
+			Message: fmt.Sprintf("%s is back in stock", product.Name),
+			Data: map[string]interface{}{
+				"tenant_id":  tenantID,
+				"product_id": product.ID,
+				"product":    product.Name,
+				"phone":      entry.CustomerPhone,
+				"user_id":    entry.UserID,
+				"channel":    entry.Channel,
+			},
+			CreatedAt: now,
+		})
+		if errors.Is(err, ErrNoConsent) {
+			if err := s.db.Model(&entry).Update("status", WaitlistSuppressed).Error; err != nil {
+				log.Printf("Failed to suppress waitlist entry %d: %v", entry.ID, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to notify %s about %s: %v", entry.CustomerPhone, product.ID, err)
+			continue
+		}
+		if err := s.db.Model(&entry).Updates(map[string]interface{}{"status": WaitlistNotified, "notified_at": now}).Error; err != nil {
+			log.Printf("Failed to mark waitlist entry %d notified: %v", entry.ID, err)
+			continue
+		}
+		notified++
+	}
+	return notified
+}
+
+// detectConversions matches notified customers against recent orders containing the product
+func (s *GormWaitlistService) detectConversions(tenantID uint, dataService DataService) (int, error) {
+	var entries []WaitlistEntry
+	if err := s.db.Where("tenant_id = ? AND status = ?", tenantID, WaitlistNotified).Find(&entries).Error; err != nil {
+		return 0, fmt.Errorf("failed to load notified waitlist: %v", err)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	orders, err := dataService.ListOrders(CustomerOrderQuery{Page: 1, Limit: 100})
+	if err != nil {
+		return 0, err
+	}
+	converted := 0
+	for _, entry := range entries {
+		for _, order := range orders {
+			if !waitlistOrderMatches(entry, order) {
+				continue
+			}
+			if err := s.db.Model(&entry).Updates(map[string]interface{}{
+				"status":             WaitlistConverted,
+				"converted_at":       time.Now(),
+				"converted_order_id": order.ID,
+			}).Error; err != nil {
+				return converted, fmt.Errorf("failed to mark waitlist entry converted: %v", err)
+			}
+			converted++
+			break
+		}
+	}
+	return converted, nil
+}
+
+// waitlistOrderMatches reports whether the order was placed by the waiting customer for the product after the notification
+func waitlistOrderMatches(entry WaitlistEntry, order Order) bool {
+	if NormalizePhone(order.Customer.Phone) != entry.CustomerPhone {
+		return false
+	}
+	if entry.NotifiedAt != nil && order.CreatedAt.Before(*entry.NotifiedAt) {
+		return false
+	}
+	for _, line := range order.Items {
+		if line.ProductID == entry.ProductID {
+			return true
+		}
+	}
+	return false
+}
+
+// ConversionReport summarizes the waitlist funnel per product
+func (s *GormWaitlistService) ConversionReport(tenantID uint) ([]WaitlistConversion, error) {
+	var rows []struct {
+		ProductID   string
+		ProductName string
+		Status      string
+		Count       int
+	}
+	if err := s.db.Model(&WaitlistEntry{}).
+		Select("product_id, MAX(product_name) AS product_name, status, COUNT(*) AS count").
+		Where("tenant_id = ?", tenantID).
+		Group("product_id, status").Order("product_id").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to build waitlist report: %v", err)
+	}
+
+	var report []WaitlistConversion
+	index := make(map[string]int)
+	for _, row := range rows {
+		i, ok := index[row.ProductID]
+		if !ok {
+			report = append(report, WaitlistConversion{ProductID: row.ProductID, ProductName: row.ProductName})
+			i = len(report) - 1
+			index[row.ProductID] = i
+		}
+		switch row.Status {
+		case WaitlistWaiting:
+			report[i].Waiting += row.Count
+		case WaitlistNotified:
+			report[i].Notified += row.Count
+		case WaitlistConverted:
+			report[i].Converted += row.Count
+		}
+	}
+	for i := range report {
+		// Converted customers were notified first
+		if reached := report[i].Notified + report[i].Converted; reached > 0 {
+			report[i].Rate = roundMillimes(float64(report[i].Converted) / float64(reached))
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitlistOrderMatches(t *testing.T) {
+	notifiedAt := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	entry := WaitlistEntry{ProductID: "p1", CustomerPhone: "+21698765432", NotifiedAt: &notifiedAt}
+	order := Order{
+		Customer:  Customer{Phone: "+216 98 765 432"},
+		CreatedAt: notifiedAt.Add(time.Hour),
+		Items:     []OrderLine{{ProductID: "p2"}, {ProductID: "p1"}},
+	}
+	if !waitlistOrderMatches(entry, order) {
+		t.Fatal("expected the order to convert the waitlist entry")
+	}
+
+	earlier := order
+	earlier.CreatedAt = notifiedAt.Add(-time.Hour)
+	otherCustomer := order
+	otherCustomer.Customer.Phone = "+21611111111"
+	otherProduct := order
+	otherProduct.Items = []OrderLine{{ProductID: "p2"}}
+	for name, o := range map[string]Order{"before notification": earlier, "other customer": otherCustomer, "other product": otherProduct} {
+		if waitlistOrderMatches(entry, o) {
+			t.Errorf("%s: unexpected match", name)
+		}
+	}
+}
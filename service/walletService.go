@@ -0,0 +1,270 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Wallet entry kinds
+const (
+	WalletRefundCredit  = "refund_credit"
+	WalletLoyaltyCredit = "loyalty_credit"
+	WalletOrderDebit    = "order_debit"
+)
+
+// Store-side ledger accounts balancing the customer accounts
+const (
+	accountStoreRefunds = "store:refunds"
+	accountStoreLoyalty = "store:loyalty"
+	accountStoreOrders  = "store:orders"
+)
+
+// creditSources maps each credit kind to the store account funding it
+var creditSources = map[string]string{
+	WalletRefundCredit:  accountStoreRefunds,
+	WalletLoyaltyCredit: accountStoreLoyalty,
+}
+
+// WalletEntry is one leg of a double-entry wallet transaction; the legs of a transaction sum to zero
+type WalletEntry struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	TenantID      uint      `gorm:"not null;default:0;index" json:"tenant_id"`
+	TransactionID string    `gorm:"not null;index" json:"transaction_id"`
+	Account       string    `gorm:"not null;index" json:"account"`
+	CustomerPhone string    `gorm:"not null;index" json:"customer_phone"`
+	Kind          string    `gorm:"not null" json:"kind"`
+	Amount        float64   `gorm:"type:numeric(14,3);not null" json:"amount"`
+	Currency      string    `gorm:"not null" json:"currency"`
+	OrderID       string    `gorm:"index" json:"order_id,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	CreatedBy     string    `json:"created_by,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for WalletEntry
+func (WalletEntry) TableName() string {
+	return "chatbot.wallet_entries"
+}
+
+// WalletBalance is a customer's store credit
+type WalletBalance struct {
+	CustomerPhone string        `json:"customer_phone"`
+	Balance       float64       `json:"balance"`
+	Currency      string        `json:"currency"`
+	Entries       []WalletEntry `json:"entries,omitempty"`
+}
+
+// AppliedCredit is the result of paying part of an order with store credit
+type AppliedCredit struct {
+	OrderID       string  `json:"order_id"`
+	OrderTotal    float64 `json:"order_total"`
+	Applied       float64 `json:"applied"`
+	AmountDue     float64 `json:"amount_due"`
+	Balance       float64 `json:"balance"`
+	Currency      string  `json:"currency"`
+	TransactionID string  `json:"transaction_id"`
+}
+
+// WalletService defines the interface for the customer store-credit ledger; each tenant keeps its own
+type WalletService interface {
+	Credit(tenantID uint, phone, kind string, amount float64, orderID, reason, actor string) (WalletBalance, error)
+	Balance(tenantID uint, phone string, withEntries bool) (WalletBalance, error)
+	ApplyToOrder(tenantID uint, order Order, maxAmount float64, actor string) (AppliedCredit, error)
+	UnbalancedTransactions(tenantID uint) ([]string, error)
+}
+
+// GormWalletService implements WalletService using GORM
+type GormWalletService struct {
+	db       *gorm.DB
+	currency string
+}
+
+// NewGormWalletService creates a new GormWalletService keeping balances in currency
+func NewGormWalletService(db *gorm.DB, currency string) WalletService {
+	return &GormWalletService{db: db, currency: strings.ToUpper(currency)}
+}
+
+// NormalizePhone strips formatting so the same customer always maps to one wallet
+func NormalizePhone(phone string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		if (r >= '0' && r <= '9') || (r == '+' && i == 0) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func customerAccount(phone string) string {
+	return "customer:" + phone
+}
+
+func newTransactionID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// lockWallet serializes balance changes of one customer of a tenant for the rest of the transaction
+func lockWallet(tx *gorm.DB, tenantID uint, phone string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", fmt.Sprintf("%d:%s", tenantID, customerAccount(phone))).Error
+}
+
+func balanceOf(tx *gorm.DB, tenantID uint, phone string) (float64, error) {
+	var balance float64
+	err := tx.Model(&WalletEntry{}).Where("tenant_id = ? AND account = ?", tenantID, customerAccount(phone)).
+		Select("COALESCE(SUM(amount), 0)").Scan(&balance).Error
+	return roundMillimes(balance), err
+}
+
+// postTransaction writes the two balanced legs of a transfer from one account to another
+func postTransaction(tx *gorm.DB, template WalletEntry, from, to string, amount float64) (string, error) {
+	transactionID, err := newTransactionID()
+	if err != nil {
+		return "", err
+	}
+	debit, credit := template, template
+	debit.TransactionID, credit.TransactionID = transactionID, transactionID
+	debit.Account, debit.Amount = from, -amount
+	credit.Account, credit.Amount = to, amount
+	if err := tx.Create(&[]WalletEntry{debit, credit}).Error; err != nil {
+		return "", err
+	}
+	return transactionID, nil
+}
+
+// Credit adds store credit of the given kind (refund or loyalty) to a customer's wallet
+func (s *GormWalletService) Credit(tenantID uint, phone, kind string, amount float64, orderID, reason, actor string) (WalletBalance, error) {
+	phone = NormalizePhone(phone)
+	if phone == "" {
+		return WalletBalance{}, fmt.Errorf("customer phone is required")
+	}
+	source, ok := creditSources[kind]
+	if !ok {
+		return WalletBalance{}, fmt.Errorf("unknown credit kind %q", kind)
+	}
+	amount = roundMillimes(amount)
+	if amount <= 0 {
+		return WalletBalance{}, fmt.Errorf("credit amount must be positive")
+	}
+
+	var balance float64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockWallet(tx, tenantID, phone); err != nil {
+			return err
+		}
+		template := WalletEntry{
+			TenantID:      tenantID,
+			CustomerPhone: phone,
+			Kind:          kind,
+			Currency:      s.currency,
+			OrderID:       orderID,
+			Reason:        reason,
+			CreatedBy:     actor,
+		}
+		if _, err := postTransaction(tx, template, source, customerAccount(phone), amount); err != nil {
+			return err
+		}
+		var err error
+		balance, err = balanceOf(tx, tenantID, phone)
+		return err
+	})
+	if err != nil {
+		return WalletBalance{}, fmt.Errorf("failed to credit wallet: %v", err)
+	}
+	return WalletBalance{CustomerPhone: phone, Balance: balance, Currency: s.currency}, nil
+}
+
+// Balance returns a customer's current store credit, optionally with the entries of their account
+func (s *GormWalletService) Balance(tenantID uint, phone string, withEntries bool) (WalletBalance, error) {
+	phone = NormalizePhone(phone)
+	balance, err := balanceOf(s.db, tenantID, phone)
+	if err != nil {
+		return WalletBalance{}, fmt.Errorf("failed to compute wallet balance: %v", err)
+	}
+	result := WalletBalance{CustomerPhone: phone, Balance: balance, Currency: s.currency}
+	if withEntries {
+		if err := s.db.Where("tenant_id = ? AND account = ?", tenantID, customerAccount(phone)).Order("created_at desc").Find(&result.Entries).Error; err != nil {
+			return WalletBalance{}, fmt.Errorf("failed to fetch wallet entries: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// ApplyToOrder pays up to maxAmount (0 means as much as possible) of an order's total from the wallet
+// of the order's customer. Credit can be applied to an order only once.
+func (s *GormWalletService) ApplyToOrder(tenantID uint, order Order, maxAmount float64, actor string) (AppliedCredit, error) {
+	phone := NormalizePhone(order.Customer.Phone)
+	if phone == "" {
+		return AppliedCredit{}, fmt.Errorf("order %s has no customer phone", order.ID)
+	}
+	if order.Currency != "" && !strings.EqualFold(order.Currency, s.currency) {
+		return AppliedCredit{}, fmt.Errorf("order %s is in %s but wallets are kept in %s", order.ID, order.Currency, s.currency)
+	}
+
+	result := AppliedCredit{OrderID: order.ID, OrderTotal: order.Total, Currency: s.currency}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockWallet(tx, tenantID, phone); err != nil {
+			return err
+		}
+		var applied int64
+		err := tx.Model(&WalletEntry{}).Where("tenant_id = ? AND order_id = ? AND kind = ?", tenantID, order.ID, WalletOrderDebit).Count(&applied).Error
+		if err != nil {
+			return err
+		}
+		if applied > 0 {
+			return fmt.Errorf("store credit was already applied to order %s", order.ID)
+		}
+		balance, err := balanceOf(tx, tenantID, phone)
+		if err != nil {
+			return err
+		}
+		amount := roundMillimes(min(balance, order.Total))
+		if maxAmount > 0 {
+			amount = min(amount, roundMillimes(maxAmount))
+		}
+		if amount <= 0 {
+			return fmt.Errorf("no store credit available for %s", phone)
+		}
+		template := WalletEntry{
+			TenantID:      tenantID,
+			CustomerPhone: phone,
+			Kind:          WalletOrderDebit,
+			Currency:      s.currency,
+			OrderID:       order.ID,
+			Reason:        fmt.Sprintf("Applied to order %s", order.ID),
+			CreatedBy:     actor,
+		}
+		transactionID, err := postTransaction(tx, template, customerAccount(phone), accountStoreOrders, amount)
+		if err != nil {
+			return err
+		}
+		result.Applied = amount
+		result.AmountDue = roundMillimes(order.Total - amount)
+		result.Balance = roundMillimes(balance - amount)
+		result.TransactionID = transactionID
+		return nil
+	})
+	if err != nil {
+		return AppliedCredit{}, fmt.Errorf("failed to apply store credit: %v", err)
+	}
+	return result, nil
+}
+
+// UnbalancedTransactions lists the tenant's transactions whose legs do not sum to zero; it should always be empty
+func (s *GormWalletService) UnbalancedTransactions(tenantID uint) ([]string, error) {
+	var ids []string
+	err := s.db.Model(&WalletEntry{}).Where("tenant_id = ?", tenantID).Select("transaction_id").Group("transaction_id").
+		Having("SUM(amount) <> 0").Pluck("transaction_id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to audit wallet ledger: %v", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,18 @@
+package service
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	cases := map[string]string{
+		"+216 98 765 432": "+21698765432",
+		"98-765-432":      "98765432",
+		" (71) 123 456 ":  "71123456",
+		"216+98":          "21698",
+		"":                "",
+	}
+	for in, want := range cases {
+		if got := NormalizePhone(in); got != want {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
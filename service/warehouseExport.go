@@ -0,0 +1,238 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WarehouseFormatNDJSON is gzip-compressed newline-delimited JSON, one interaction per line
+const WarehouseFormatNDJSON = "ndjson"
+
+// warehouseStateID is the single row of public.warehouse_export_state
+const warehouseStateID = 1
+
+// WarehouseExportState is the cursor of the interactions export: rows after Cursor are exported next
+type WarehouseExportState struct {
+	ID             uint       `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	Cursor         uint       `gorm:"not null;default:0" json:"cursor"`
+	LastExportedAt *time.Time `json:"last_exported_at,omitempty"`
+	LastExported   int        `json:"last_exported"`
+	LastError      string     `json:"last_error,omitempty"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for WarehouseExportState
+func (WarehouseExportState) TableName() string {
+	return "public.warehouse_export_state"
+}
+
+// WarehouseExportResult summarizes one export run
+type WarehouseExportResult struct {
+	Rows    int      `json:"rows"`
+	Objects []string `json:"objects"`
+	Cursor  uint     `json:"cursor"`
+}
+
+// WarehouseRow is one interaction as the warehouse receives it. With PII encryption on, the protected
+// detail fields are left out and only their lookup hashes are exported, so analysts can still count
+// and join customers without seeing their data.
+type WarehouseRow struct {
+	ID            uint            `json:"id"`
+	TenantID      uint            `json:"tenant_id"`
+	UserID        uint            `json:"user_id"`
+	Type          string          `json:"type"`
+	Status        string          `json:"status"`
+	Details       json.RawMessage `json:"details"`
+	PIIHashes     json.RawMessage `json:"pii_hashes,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ArchivedAt    *time.Time      `json:"archived_at,omitempty"`
+	AnonymizedAt  *time.Time      `json:"anonymized_at,omitempty"`
+	Version       int             `json:"version"`
+	PreviousID    *uint           `json:"previous_id,omitempty"`
+	LineageID     uint            `json:"lineage_id"`
+	SchemaVersion int             `json:"schema_version"`
+	Revision      int             `json:"revision"`
+	ExportedAt    time.Time       `json:"exported_at"`
+}
+
+// NewWarehouseRow converts a record, leaving out the fields protected by PII encryption
+func NewWarehouseRow(record Data, exportedAt time.Time) WarehouseRow {
+	row := WarehouseRow{
+		ID: record.ID, TenantID: record.TenantID, UserID: record.UserID, Type: record.Type, Status: record.Status,
+		Details: json.RawMessage(record.Details), CreatedAt: record.CreatedAt, ArchivedAt: record.ArchivedAt,
+		AnonymizedAt: record.AnonymizedAt, Version: record.Version, PreviousID: record.PreviousID,
+		LineageID: record.LineageID, SchemaVersion: record.SchemaVersion, Revision: record.Revision, ExportedAt: exportedAt,
+	}
+	if len(row.Details) == 0 {
+		row.Details = json.RawMessage("null")
+	}
+	var hashes map[string]string
+	if len(record.PIIHashes) == 0 || json.Unmarshal(record.PIIHashes, &hashes) != nil || len(hashes) == 0 {
+		return row
+	}
+	row.PIIHashes = json.RawMessage(record.PIIHashes)
+	doc, ok := decodeDetails(record.Details)
+	if !ok {
+		return row
+	}
+	for field := range hashes {
+		if parent, key, _, found := lookupPath(doc, strings.Split(field, ".")); found {
+			delete(parent, key)
+		}
+	}
+	if stripped, err := json.Marshal(doc); err == nil {
+		row.Details = stripped
+	}
+	return row
+}
+
+// WarehouseExportOptions configures the export
+type WarehouseExportOptions struct {
+	Store BlobStore
+	// Prefix is the key prefix of the exported objects, "interactions" by default
+	Prefix string
+	// BatchSize is the number of rows read per batch, 5000 by default
+	BatchSize int
+}
+
+// WarehouseExportService exports chatbot.interactions incrementally to object storage for the data warehouse
+type WarehouseExportService interface {
+	// Export writes up to maxBatches batches of the rows added since the last run; 0 exports everything
+	Export(ctx context.Context, maxBatches int) (WarehouseExportResult, error)
+	State() (WarehouseExportState, error)
+}
+
+// GormWarehouseExportService implements WarehouseExportService using GORM
+type GormWarehouseExportService struct {
+	db   *gorm.DB
+	opts WarehouseExportOptions
+	now  func() time.Time
+}
+
+// NewGormWarehouseExportService creates the exporter writing to opts.Store
+func NewGormWarehouseExportService(db *gorm.DB, opts WarehouseExportOptions) WarehouseExportService {
+	if opts.Prefix == "" {
+		opts.Prefix = "interactions"
+	}
+	opts.Prefix = strings.Trim(opts.Prefix, "/")
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 5000
+	}
+	return &GormWarehouseExportService{db: db, opts: opts, now: time.Now}
+}
+
+// State returns the export cursor
+func (s *GormWarehouseExportService) State() (WarehouseExportState, error) {
+	state := WarehouseExportState{ID: warehouseStateID}
+	if err := s.db.Where(WarehouseExportState{ID: warehouseStateID}).FirstOrInit(&state).Error; err != nil {
+		return state, fmt.Errorf("failed to read the warehouse export state: %v", err)
+	}
+	return state, nil
+}
+
+// Export writes the new rows batch by batch. Each batch is written and its cursor advanced in one
+// transaction holding the state row, so concurrent runs wait for each other and a failed upload
+// leaves the cursor where it was: a batch may be written twice, never skipped.
+func (s *GormWarehouseExportService) Export(ctx context.Context, maxBatches int) (WarehouseExportResult, error) {
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&WarehouseExportState{ID: warehouseStateID}).Error; err != nil {
+		return WarehouseExportResult{}, fmt.Errorf("failed to create the warehouse export state: %v", err)
+	}
+	var result WarehouseExportResult
+	for batch := 0; maxBatches <= 0 || batch < maxBatches; batch++ {
+		var written []string
+		var rows int
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			var state WarehouseExportState
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&state, warehouseStateID).Error; err != nil {
+				return fmt.Errorf("failed to lock the warehouse export state: %v", err)
+			}
+			result.Cursor = state.Cursor
+			var records []Data
+			if err := tx.Where("id > ?", state.Cursor).Order("id").Limit(s.opts.BatchSize).Find(&records).Error; err != nil {
+				return fmt.Errorf("failed to read interactions: %v", err)
+			}
+			if len(records) == 0 {
+				return nil
+			}
+			now := s.now()
+			objects, err := s.writeBatch(ctx, records, now)
+			if err != nil {
+				return err
+			}
+			cursor := records[len(records)-1].ID
+			if err := tx.Model(&state).Updates(map[string]interface{}{
+				"cursor": cursor, "last_exported_at": now, "last_exported": len(records), "last_error": "",
+			}).Error; err != nil {
+				return fmt.Errorf("failed to advance the warehouse export cursor: %v", err)
+			}
+			written, rows, result.Cursor = objects, len(records), cursor
+			return nil
+		})
+		if err != nil {
+			s.db.Model(&WarehouseExportState{ID: warehouseStateID}).Update("last_error", err.Error())
+			return result, err
+		}
+		if rows == 0 {
+			break
+		}
+		result.Rows += rows
+		result.Objects = append(result.Objects, written...)
+		if rows < s.opts.BatchSize {
+			break
+		}
+	}
+	return result, nil
+}
+
+// writeBatch stores the rows as one object per creation date, partitioned as <prefix>/dt=YYYY-MM-DD/
+func (s *GormWarehouseExportService) writeBatch(ctx context.Context, records []Data, now time.Time) ([]string, error) {
+	partitions := map[string][]Data{}
+	var dates []string
+	for _, record := range records {
+		date := record.CreatedAt.UTC().Format("2006-01-02")
+		if _, ok := partitions[date]; !ok {
+			dates = append(dates, date)
+		}
+		partitions[date] = append(partitions[date], record)
+	}
+	var objects []string
+	for _, date := range dates {
+		rows := partitions[date]
+		body, err := encodeWarehouseRows(rows, now)
+		if err != nil {
+			return nil, err
+		}
+		// The ID range names the object, so a batch exported twice overwrites itself
+		key := path.Join(s.opts.Prefix, "dt="+date, fmt.Sprintf("part-%012d-%012d.ndjson.gz", rows[0].ID, rows[len(rows)-1].ID))
+		if err := s.opts.Store.Put(ctx, key, "application/gzip", body); err != nil {
+			return nil, fmt.Errorf("failed to upload %s: %v", key, err)
+		}
+		objects = append(objects, key)
+	}
+	return objects, nil
+}
+
+// encodeWarehouseRows writes the records as gzip-compressed newline-delimited JSON
+func encodeWarehouseRows(records []Data, exportedAt time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(zw)
+	for _, record := range records {
+		if err := encoder.Encode(NewWarehouseRow(record, exportedAt)); err != nil {
+			return nil, fmt.Errorf("failed to encode interaction %d: %v", record.ID, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress the export: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewWarehouseRowLeavesOutPII(t *testing.T) {
+	PII = testPIIProtector(t)
+	defer func() { PII = nil }()
+
+	record := Data{ID: 7, TenantID: 1, UserID: 5, Type: "issue", Details: []byte(`{"phone": "+21674000000", "note": "late"}`)}
+	if err := record.BeforeSave(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := record.AfterFind(nil); err != nil {
+		t.Fatal(err)
+	}
+	row := NewWarehouseRow(record, time.Now())
+	if strings.Contains(string(row.Details), "74000000") || !strings.Contains(string(row.Details), "late") {
+		t.Errorf("unexpected exported details: %s", row.Details)
+	}
+	var hashes map[string]string
+	if err := json.Unmarshal(row.PIIHashes, &hashes); err != nil || hashes["phone"] == "" {
+		t.Errorf("phone hash not exported: %s", row.PIIHashes)
+	}
+
+	clear := NewWarehouseRow(Data{ID: 8, Details: []byte(`{"note": "late"}`)}, time.Now())
+	if string(clear.Details) != `{"note": "late"}` || clear.PIIHashes != nil {
+		t.Errorf("records without PII should pass through: %s %s", clear.Details, clear.PIIHashes)
+	}
+}
+
+func TestEncodeWarehouseRows(t *testing.T) {
+	records := []Data{{ID: 1, Type: "issue"}, {ID: 2, Type: "order", Details: []byte(`{"total": 12}`)}}
+	body, err := encodeWarehouseRows(records, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(zr)
+	var ids []uint
+	for scanner.Scan() {
+		var row WarehouseRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		ids = append(ids, row.ID)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("unexpected rows: %v", ids)
+	}
+}
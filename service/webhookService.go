@@ -0,0 +1,473 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Webhook events sent to the chatbot platform
+const (
+	WebhookIssueCreated       = "issue.created"
+	WebhookIssueResolved      = "issue.resolved"
+	WebhookOrderStatusChanged = "order.status_changed"
+)
+
+// WebhookEvents lists the events an endpoint can subscribe to
+var WebhookEvents = []string{WebhookIssueCreated, WebhookIssueResolved, WebhookOrderStatusChanged}
+
+// EventOrderStatusChanged is published when the tracking job sees an order move to another status,
+// or when an order is cancelled through the API
+const EventOrderStatusChanged = "order.status_changed"
+
+// Webhook delivery states
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// ErrWebhookNotFound is returned when a webhook endpoint does not exist for the tenant
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// WebhookEndpoint is a callback URL of the chatbot platform receiving a tenant's events
+type WebhookEndpoint struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	TenantID uint   `gorm:"not null;default:0;index" json:"tenant_id"`
+	URL      string `gorm:"not null" json:"url"`
+	// Secret signs every payload; it is only shown when the endpoint is created
+	Secret string `gorm:"not null" json:"-"`
+	// Events is the space-separated list of subscribed events; empty subscribes to all
+	Events    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for WebhookEndpoint
+func (WebhookEndpoint) TableName() string {
+	return "chatbot.webhook_endpoints"
+}
+
+// EventList returns the subscribed events
+func (e WebhookEndpoint) EventList() []string {
+	if e.Events == "" {
+		return WebhookEvents
+	}
+	return strings.Fields(e.Events)
+}
+
+// Subscribed reports whether the endpoint receives event
+func (e WebhookEndpoint) Subscribed(event string) bool {
+	for _, subscribed := range e.EventList() {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON adds the subscribed events to the endpoint
+func (e WebhookEndpoint) MarshalJSON() ([]byte, error) {
+	type plain WebhookEndpoint
+	return json.Marshal(struct {
+		plain
+		Events []string `json:"events"`
+	}{plain(e), e.EventList()})
+}
+
+// WebhookDelivery is one event sent, or to be sent, to an endpoint; it doubles as the delivery log
+type WebhookDelivery struct {
+	ID         uint           `gorm:"primaryKey" json:"id"`
+	TenantID   uint           `gorm:"not null;default:0;index" json:"tenant_id"`
+	EndpointID uint           `gorm:"not null;index" json:"endpoint_id"`
+	Event      string         `gorm:"not null;index" json:"event"`
+	Payload    datatypes.JSON `json:"payload"`
+	Status     string         `gorm:"not null;index:idx_webhook_due" json:"status"`
+	Attempts   int            `json:"attempts"`
+	// NextAttemptAt is when the dispatcher sends the delivery again; it also leases deliveries being sent
+	NextAttemptAt time.Time `gorm:"index:idx_webhook_due" json:"next_attempt_at"`
+	// ResponseStatus is the HTTP status of the last attempt, 0 when the endpoint could not be reached
+	ResponseStatus int        `json:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+// TableName specifies the table name for WebhookDelivery
+func (WebhookDelivery) TableName() string {
+	return "chatbot.webhook_deliveries"
+}
+
+// WebhookPayload is the signed body posted to an endpoint; receivers deduplicate on ID
+type WebhookPayload struct {
+	ID       uint                   `json:"id"`
+	Event    string                 `json:"event"`
+	TenantID uint                   `json:"tenant_id"`
+	Data     map[string]interface{} `json:"data"`
+	Time     time.Time              `json:"time"`
+}
+
+// WebhookDeliveryFilter narrows a delivery log listing; zero values are ignored
+type WebhookDeliveryFilter struct {
+	EndpointID uint
+	Event      string
+	Status     string
+}
+
+// WebhookDispatchResult counts the outcome of one dispatch round
+type WebhookDispatchResult struct {
+	Delivered int `json:"delivered"`
+	Retried   int `json:"retried"`
+	Failed    int `json:"failed"`
+}
+
+// WebhookService notifies the chatbot platform of issue and order changes through signed webhooks
+type WebhookService interface {
+	// CreateEndpoint registers url for events, all of them when empty; a secret is generated when none is given
+	CreateEndpoint(tenantID uint, url, secret string, events []string) (WebhookEndpoint, error)
+	ListEndpoints(tenantID uint) ([]WebhookEndpoint, error)
+	DeleteEndpoint(tenantID, id uint) error
+	// Enqueue queues event for every endpoint of the tenant subscribed to it and returns how many were queued
+	Enqueue(tenantID uint, event string, data map[string]interface{}) (int, error)
+	// Listen queues the webhooks of the events published on the bus until the process exits
+	Listen(events *EventBus)
+	// Dispatch sends one batch of due deliveries
+	Dispatch() (WebhookDispatchResult, error)
+	// Run dispatches every interval, purging delivered entries of the log older than retention
+	Run(interval, retention time.Duration)
+	// ListDeliveries returns the delivery log of the tenant, newest first
+	ListDeliveries(tenantID uint, filter WebhookDeliveryFilter) ([]WebhookDelivery, error)
+}
+
+// GormWebhookService implements WebhookService using GORM
+type GormWebhookService struct {
+	db          *gorm.DB
+	client      *http.Client
+	maxAttempts int
+	batchSize   int
+	// allowPrivate lets endpoints point at loopback and private addresses, for tests and
+	// single-host deployments
+	allowPrivate bool
+}
+
+// webhookLease is how long a claimed delivery stays hidden from other dispatchers
+const webhookLease = 2 * time.Minute
+
+// NewGormWebhookService creates a new GormWebhookService; a delivery fails for good after maxAttempts
+// attempts. Endpoints may only reach public addresses unless allowPrivate is set.
+func NewGormWebhookService(db *gorm.DB, maxAttempts int, allowPrivate bool) WebhookService {
+	if maxAttempts <= 0 {
+		maxAttempts = 8
+	}
+	client := NewPublicHTTPClient(10 * time.Second)
+	if allowPrivate {
+		client = NewHTTPClient(10 * time.Second)
+	}
+	return &GormWebhookService{db: db, client: client, maxAttempts: maxAttempts, batchSize: 50, allowPrivate: allowPrivate}
+}
+
+// CreateEndpoint validates and stores an endpoint
+func (s *GormWebhookService) CreateEndpoint(tenantID uint, rawURL, secret string, events []string) (WebhookEndpoint, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return WebhookEndpoint{}, fmt.Errorf("%w: webhook URL must be an absolute http(s) URL", ErrValidation)
+	}
+	if !s.allowPrivate {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := CheckPublicHost(ctx, parsed.Hostname())
+		cancel()
+		if err != nil {
+			return WebhookEndpoint{}, fmt.Errorf("%w: webhook URL must point at a public host: %v", ErrValidation, err)
+		}
+	}
+	for _, event := range events {
+		if !knownWebhookEvent(event) {
+			return WebhookEndpoint{}, fmt.Errorf("%w: unknown webhook event %q", ErrValidation, event)
+		}
+	}
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return WebhookEndpoint{}, fmt.Errorf("failed to generate webhook secret: %v", err)
+		}
+		secret = hex.EncodeToString(b)
+	}
+	endpoint := WebhookEndpoint{TenantID: tenantID, URL: rawURL, Secret: secret, Events: strings.Join(events, " ")}
+	if err := s.db.Create(&endpoint).Error; err != nil {
+		return WebhookEndpoint{}, fmt.Errorf("failed to create webhook: %v", err)
+	}
+	return endpoint, nil
+}
+
+// knownWebhookEvent reports whether event is one of WebhookEvents
+func knownWebhookEvent(event string) bool {
+	for _, known := range WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ListEndpoints returns the tenant's endpoints, oldest first
+func (s *GormWebhookService) ListEndpoints(tenantID uint) ([]WebhookEndpoint, error) {
+	var endpoints []WebhookEndpoint
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("id").Find(&endpoints).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %v", err)
+	}
+	return endpoints, nil
+}
+
+// DeleteEndpoint removes an endpoint; its pending deliveries fail on their next attempt
+func (s *GormWebhookService) DeleteEndpoint(tenantID, id uint) error {
+	result := s.db.Where("tenant_id = ?", tenantID).Delete(&WebhookEndpoint{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete webhook: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %d", ErrWebhookNotFound, id)
+	}
+	return nil
+}
+
+// Enqueue stores one pending delivery per subscribed endpoint
+func (s *GormWebhookService) Enqueue(tenantID uint, event string, data map[string]interface{}) (int, error) {
+	endpoints, err := s.ListEndpoints(tenantID)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	var deliveries []WebhookDelivery
+	for _, endpoint := range endpoints {
+		if !endpoint.Subscribed(event) {
+			continue
+		}
+		deliveries = append(deliveries, WebhookDelivery{
+			TenantID:      tenantID,
+			EndpointID:    endpoint.ID,
+			Event:         event,
+			Status:        WebhookPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&deliveries).Error; err != nil {
+			return err
+		}
+		// The payload carries the delivery ID, known once the row exists
+		for _, delivery := range deliveries {
+			payload, err := json.Marshal(WebhookPayload{ID: delivery.ID, Event: event, TenantID: tenantID, Data: data, Time: now})
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&WebhookDelivery{}).Where("id = ?", delivery.ID).Update("payload", datatypes.JSON(payload)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue %s webhooks: %v", event, err)
+	}
+	return len(deliveries), nil
+}
+
+// WebhookEventFor maps a bus event to the webhook event it triggers: issues created, issues completed
+// and order status changes
+func WebhookEventFor(event Event) (string, bool) {
+	switch event.Type {
+	case EventRecordCreated:
+		if event.Data["type"] == "issue" {
+			return WebhookIssueCreated, true
+		}
+	case EventRecordStatusChanged:
+		if event.Data["type"] == "issue" && event.Data["to"] == StatusCompleted {
+			return WebhookIssueResolved, true
+		}
+	case EventOrderStatusChanged:
+		return WebhookOrderStatusChanged, true
+	}
+	return "", false
+}
+
+// Listen subscribes to the bus with a large buffer, since a full buffer drops events
+func (s *GormWebhookService) Listen(events *EventBus) {
+	ch, _ := events.Subscribe(1024)
+	for event := range ch {
+		webhookEvent, ok := WebhookEventFor(event)
+		if !ok {
+			continue
+		}
+		if _, err := s.Enqueue(event.TenantID, webhookEvent, event.Data); err != nil {
+			log.Printf("Webhooks: %v", err)
+		}
+	}
+}
+
+// Dispatch claims due deliveries by leasing them, then sends them outside the claiming transaction
+func (s *GormWebhookService) Dispatch() (WebhookDispatchResult, error) {
+	var result WebhookDispatchResult
+	var deliveries []WebhookDelivery
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", WebhookPending, now).
+			Order("id").Limit(s.batchSize).Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+		ids := make([]uint, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+		return tx.Model(&WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(webhookLease)).Error
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to claim webhook deliveries: %v", err)
+	}
+
+	for _, delivery := range deliveries {
+		switch s.deliver(&delivery) {
+		case WebhookDelivered:
+			result.Delivered++
+		case WebhookFailed:
+			result.Failed++
+		default:
+			result.Retried++
+		}
+	}
+	return result, nil
+}
+
+// deliver posts a delivery to its endpoint and records the attempt, returning the new status
+func (s *GormWebhookService) deliver(delivery *WebhookDelivery) string {
+	var endpoint WebhookEndpoint
+	var sendErr error
+	delivery.ResponseStatus = 0
+	switch err := s.db.Where("tenant_id = ?", delivery.TenantID).First(&endpoint, delivery.EndpointID).Error; {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		// Deleted endpoints are not retried
+		delivery.Attempts = s.maxAttempts - 1
+		sendErr = errors.New("webhook endpoint was deleted")
+	case err != nil:
+		sendErr = fmt.Errorf("failed to load webhook endpoint: %v", err)
+	default:
+		delivery.ResponseStatus, sendErr = s.post(endpoint, *delivery)
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	updates := map[string]interface{}{"attempts": delivery.Attempts, "response_status": delivery.ResponseStatus}
+	switch {
+	case sendErr == nil:
+		delivery.Status = WebhookDelivered
+		updates["delivered_at"] = now
+		updates["last_error"] = ""
+	case delivery.Attempts >= s.maxAttempts:
+		delivery.Status = WebhookFailed
+		updates["last_error"] = sendErr.Error()
+		log.Printf("Webhook delivery %d (%s) failed after %d attempts: %v", delivery.ID, delivery.Event, delivery.Attempts, sendErr)
+	default:
+		delivery.Status = WebhookPending
+		updates["last_error"] = sendErr.Error()
+		updates["next_attempt_at"] = now.Add(outboxBackoff(delivery.Attempts))
+	}
+	updates["status"] = delivery.Status
+	if err := s.db.Model(&WebhookDelivery{ID: delivery.ID}).Updates(updates).Error; err != nil {
+		log.Printf("Webhook delivery %d: failed to record attempt: %v", delivery.ID, err)
+	}
+	return delivery.Status
+}
+
+// SignWebhook returns the signature of a delivery, the hex HMAC-SHA256 of "<timestamp>.<body>", so
+// receivers can reject a captured delivery replayed later
+func SignWebhook(secret, timestamp string, body []byte) string {
+	return SignOutboxBody(secret, append([]byte(timestamp+"."), body...))
+}
+
+// post sends the payload signed with the endpoint secret; any 2xx response accepts it
+func (s *GormWebhookService) post(endpoint WebhookEndpoint, delivery WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhook(endpoint.Secret, timestamp, delivery.Payload))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to post webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Run dispatches until the batch is drained, then waits for the next interval
+func (s *GormWebhookService) Run(interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastPurge := time.Time{}
+	for range ticker.C {
+		for {
+			result, err := s.Dispatch()
+			if err != nil {
+				log.Printf("Webhook dispatch failed: %v", err)
+				break
+			}
+			if result.Delivered+result.Retried+result.Failed < s.batchSize {
+				break
+			}
+		}
+		if retention > 0 && time.Since(lastPurge) > time.Hour {
+			lastPurge = time.Now()
+			err := s.db.Where("status = ? AND delivered_at < ?", WebhookDelivered, lastPurge.Add(-retention)).Delete(&WebhookDelivery{}).Error
+			if err != nil {
+				log.Printf("Failed to purge webhook deliveries: %v", err)
+			}
+		}
+	}
+}
+
+// ListDeliveries returns up to 500 of the tenant's latest deliveries
+func (s *GormWebhookService) ListDeliveries(tenantID uint, filter WebhookDeliveryFilter) ([]WebhookDelivery, error) {
+	query := s.db.Where("tenant_id = ?", tenantID)
+	if filter.EndpointID != 0 {
+		query = query.Where("endpoint_id = ?", filter.EndpointID)
+	}
+	if filter.Event != "" {
+		query = query.Where("event = ?", filter.Event)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	var deliveries []WebhookDelivery
+	if err := query.Order("id desc").Limit(500).Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %v", err)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWebhookEventFor(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{Event{Type: EventRecordCreated, Data: map[string]interface{}{"type": "issue"}}, WebhookIssueCreated},
+		{Event{Type: EventRecordCreated, Data: map[string]interface{}{"type": "address"}}, ""},
+		{Event{Type: EventRecordStatusChanged, Data: map[string]interface{}{"type": "issue", "to": StatusCompleted}}, WebhookIssueResolved},
+		{Event{Type: EventRecordStatusChanged, Data: map[string]interface{}{"type": "issue", "to": StatusInProgress}}, ""},
+		{Event{Type: EventOrderStatusChanged, Data: map[string]interface{}{"order_id": "o-1"}}, WebhookOrderStatusChanged},
+		{Event{Type: EventUpstreamCall}, ""},
+	}
+	for _, tt := range tests {
+		got, ok := WebhookEventFor(tt.event)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("WebhookEventFor(%s %v) = %q, %v; want %q", tt.event.Type, tt.event.Data, got, ok, tt.want)
+		}
+	}
+}
+
+func TestWebhookEndpointSubscriptions(t *testing.T) {
+	all := WebhookEndpoint{}
+	issues := WebhookEndpoint{Events: WebhookIssueCreated + " " + WebhookIssueResolved, Secret: "s3cret"}
+	if !all.Subscribed(WebhookOrderStatusChanged) || issues.Subscribed(WebhookOrderStatusChanged) || !issues.Subscribed(WebhookIssueResolved) {
+		t.Error("unexpected subscriptions")
+	}
+	encoded, err := json.Marshal(issues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(encoded), "s3cret") || !strings.Contains(string(encoded), `"events":["issue.created","issue.resolved"]`) {
+		t.Errorf("unexpected JSON %s", encoded)
+	}
+}
+
+func TestCreateEndpointRefusesPrivateHosts(t *testing.T) {
+	webhooks := NewGormWebhookService(nil, 3, false)
+	for _, url := range []string{"http://127.0.0.1:8080/hook", "http://169.254.169.254/latest/meta-data", "https://[::1]/hook", "http://localhost/hook"} {
+		if _, err := webhooks.CreateEndpoint(1, url, "", nil); !errors.Is(err, ErrValidation) {
+			t.Errorf("CreateEndpoint(%s): got %v, want ErrValidation", url, err)
+		}
+	}
+}
+
+func TestSignWebhookCoversTimestamp(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	if SignWebhook("s3cret", "1700000000", body) == SignWebhook("s3cret", "1700000001", body) {
+		t.Error("signature does not depend on the timestamp")
+	}
+}
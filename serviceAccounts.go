@@ -0,0 +1,152 @@
+package main
+
+import (
+	"convertyApi/service"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// serviceAccountKeyGrace is how long the previous key keeps working after a rotation
+var serviceAccountKeyGrace = 24 * time.Hour
+
+type serviceAccountContextKey struct{}
+
+// serviceAccountFrom returns the service account behind the request, if any
+func serviceAccountFrom(r *http.Request) (service.ServiceAccount, bool) {
+	account, ok := r.Context().Value(serviceAccountContextKey{}).(service.ServiceAccount)
+	return account, ok
+}
+
+// authorizeServiceAccount rejects service account requests outside the account's permissions;
+// routes no permission of the access policy covers are closed to service accounts
+func authorizeServiceAccount(w http.ResponseWriter, r *http.Request, account service.ServiceAccount) bool {
+	if !policy().permissionsAllow(account.PermissionList(), r.Method, r.URL.Path) {
+		writeError(w, fmt.Sprintf("Service account %s (%s) may not %s %s", account.Name, account.Permissions, r.Method, r.URL.Path), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// serviceAccountView shows the permissions as a list
+type serviceAccountView struct {
+	service.ServiceAccount
+	Permissions []string `json:"permissions"`
+}
+
+func viewServiceAccount(account service.ServiceAccount) serviceAccountView {
+	return serviceAccountView{ServiceAccount: account, Permissions: account.PermissionList()}
+}
+
+// registerServiceAccountAdminRoutes mounts service account management under the admin router
+func registerServiceAccountAdminRoutes(r chi.Router, tenantService service.TenantService, accounts service.ServiceAccountService) {
+	r.Get("/service-accounts", func(w http.ResponseWriter, r *http.Request) {
+		list, err := accounts.ListAccounts(r.URL.Query().Get("tenant"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		views := make([]serviceAccountView, 0, len(list))
+		for _, account := range list {
+			views = append(views, viewServiceAccount(account))
+		}
+		writeJSON(w, r, http.StatusOK, views)
+	})
+
+	// The key is only returned here and on rotation; it is stored hashed
+	r.Post("/service-accounts", func(w http.ResponseWriter, r *http.Request) {
+		var input serviceAccountRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		if input.Tenant == "" {
+			input.Tenant = service.DefaultTenant.Slug
+		}
+		if _, err := tenantService.GetTenant(input.Tenant); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		account, key, err := accounts.CreateAccount(input.Tenant, input.Name, input.Description, input.Permissions)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, map[string]interface{}{
+			"account": viewServiceAccount(account),
+			"api_key": key,
+		})
+	})
+
+	r.Get("/service-accounts/{id}/keys", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		keys, err := accounts.ListKeys(uint(id))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, keys)
+	})
+
+	// Rotation: POST /service-accounts/{id}/keys?grace=1h keeps the previous keys valid for grace
+	r.Post("/service-accounts/{id}/keys", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		grace := serviceAccountKeyGrace
+		if value := r.URL.Query().Get("grace"); value != "" {
+			if grace, err = time.ParseDuration(value); err != nil || grace < 0 {
+				writeError(w, fmt.Sprintf("Invalid grace %q", value), http.StatusBadRequest)
+				return
+			}
+		}
+		key, err := accounts.RotateKey(uint(id), grace)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, map[string]interface{}{
+			"api_key":              key,
+			"previous_keys_expire": time.Now().Add(grace),
+		})
+	})
+
+	r.Delete("/service-accounts/{id}/keys/{keyId}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		keyID, err := strconv.ParseUint(chi.URLParam(r, "keyId"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid key ID format", http.StatusBadRequest)
+			return
+		}
+		if err := accounts.RevokeKey(uint(id), uint(keyID)); err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Delete("/service-accounts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		if err := accounts.DisableAccount(uint(id)); err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
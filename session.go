@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"convertyApi/api"
+	"convertyApi/service"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sessionCookieName is the cookie carrying the signed session ID after an OAuth login
+const sessionCookieName = "convertyapi_session"
+
+// sessionSecret signs session cookies; a random secret is generated when SESSION_SECRET is unset
+var sessionSecret []byte
+
+// sessionTTL is how long a browser session stays valid without remember-me
+var sessionTTL = 12 * time.Hour
+
+// sessionRememberTTL is how long a remember-me session stays valid
+var sessionRememberTTL = 30 * 24 * time.Hour
+
+// sessionMaxPerUser caps the concurrent sessions of one user; older ones are revoked (0 disables)
+var sessionMaxPerUser = 5
+
+// SessionClaims identifies the server-side session a cookie belongs to
+type SessionClaims struct {
+	StoreID string `json:"store_id,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type sessionContextKey struct{}
+
+// loadSessionConfig reads SESSION_SECRET, SESSION_TTL, SESSION_REMEMBER_TTL and SESSION_MAX_PER_USER
+func loadSessionConfig() error {
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		if len(secret) < 32 {
+			return fmt.Errorf("SESSION_SECRET must be at least 32 characters")
+		}
+		sessionSecret = []byte(secret)
+	} else {
+		sessionSecret = make([]byte, 32)
+		if _, err := rand.Read(sessionSecret); err != nil {
+			return fmt.Errorf("failed to generate session secret: %v", err)
+		}
+		log.Println("Warning: SESSION_SECRET not set, sessions will not survive a restart")
+	}
+	sessionTTL = durationEnv("SESSION_TTL", sessionTTL)
+	sessionRememberTTL = durationEnv("SESSION_REMEMBER_TTL", sessionRememberTTL)
+	if value := os.Getenv("SESSION_MAX_PER_USER"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("invalid SESSION_MAX_PER_USER %q", value)
+		}
+		sessionMaxPerUser = limit
+	}
+	return nil
+}
+
+// signSession returns the signed cookie value of a stored session
+func signSession(session service.UserSession) (string, error) {
+	claims := SessionClaims{
+		StoreID: session.StoreID,
+		Tenant:  session.Tenant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        session.ID,
+			Subject:   session.Subject,
+			IssuedAt:  jwt.NewNumericDate(session.CreatedAt),
+			ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(sessionSecret)
+}
+
+// parseSession verifies a session cookie and returns its claims
+func parseSession(token string) (*SessionClaims, error) {
+	claims := &SessionClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return sessionSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" || claims.ID == "" {
+		return nil, fmt.Errorf("session has no subject or ID")
+	}
+	if len(claims.Audience) > 0 {
+		// Operator access and refresh tokens are signed with the same secret but carry an audience
+		return nil, fmt.Errorf("not a session token")
+	}
+	return claims, nil
+}
+
+// startSession stores a session and issues its cookie. Remember-me sessions get a persistent
+// cookie; the others end with the browser session or sessionTTL, whichever comes first.
+func startSession(w http.ResponseWriter, r *http.Request, sessions service.SessionService, cookieName string, session service.UserSession) error {
+	ttl := sessionTTL
+	if session.Remember {
+		ttl = sessionRememberTTL
+	}
+	session.ExpiresAt = time.Now().Add(ttl)
+	session.UserAgent = r.UserAgent()
+	session.IPAddress = clientIP(r)
+	stored, err := sessions.Create(session, sessionMaxPerUser)
+	if err != nil {
+		return err
+	}
+	token, err := signSession(stored)
+	if err != nil {
+		return err
+	}
+	cookie := &http.Cookie{
+		Name:     cookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(publicBaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if session.Remember {
+		cookie.MaxAge = int(ttl.Seconds())
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// sessionFromCookie resolves the active stored session behind a session cookie
+func sessionFromCookie(r *http.Request, sessions service.SessionService, cookieName string) (*SessionClaims, service.UserSession, error) {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return nil, service.UserSession{}, err
+	}
+	claims, err := parseSession(cookie.Value)
+	if err != nil {
+		return nil, service.UserSession{}, err
+	}
+	session, err := sessions.Validate(claims.ID)
+	if err != nil {
+		return nil, service.UserSession{}, err
+	}
+	return claims, session, nil
+}
+
+// setSessionCookie issues the session cookie after a successful authorization
+func setSessionCookie(w http.ResponseWriter, r *http.Request, sessions service.SessionService, userID, storeID, tenant string, remember bool) error {
+	return startSession(w, r, sessions, sessionCookieName, service.UserSession{
+		Kind:     service.SessionStore,
+		Subject:  userID,
+		Tenant:   tenant,
+		StoreID:  storeID,
+		Remember: remember,
+	})
+}
+
+// endSession revokes the session behind a cookie and removes the cookie
+func endSession(w http.ResponseWriter, r *http.Request, sessions service.SessionService, cookieName string) {
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		if claims, err := parseSession(cookie.Value); err == nil {
+			if _, err := sessions.Revoke(claims.ID, "logout"); err != nil {
+				log.Printf("Failed to revoke session: %v", err)
+			}
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: cookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+}
+
+// clientIP returns the address of the client, preferring the proxy's X-Forwarded-For
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loadSession resolves the current user from the session cookie; invalid, expired and revoked
+// sessions are ignored
+func loadSession(sessions service.SessionService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := r.Cookie(sessionCookieName); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			claims, _, err := sessionFromCookie(r, sessions, sessionCookieName)
+			if err != nil {
+				log.Printf("Ignoring session cookie: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, claims)))
+		})
+	}
+}
+
+// registerSessionAdminRoutes mounts the listing and termination of active sessions
+func registerSessionAdminRoutes(r chi.Router, sessions service.SessionService) {
+	// /sessions?kind=store|admin&subject=...
+	r.Get("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		active, err := sessions.ListActive(r.URL.Query().Get("kind"), r.URL.Query().Get("subject"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, active, params))
+	})
+
+	r.Delete("/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		session, err := sessions.Revoke(chi.URLParam(r, "id"), adminActor(r))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, session)
+	})
+
+	// Terminates every session of a user: DELETE /sessions?subject=...&kind=store
+	r.Delete("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		revoked, err := sessions.RevokeSubject(r.URL.Query().Get("kind"), r.URL.Query().Get("subject"), adminActor(r))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, map[string]int64{"revoked": revoked})
+	})
+}
+
+// sessionFrom returns the session of the request, if any
+func sessionFrom(r *http.Request) (*SessionClaims, bool) {
+	claims, ok := r.Context().Value(sessionContextKey{}).(*SessionClaims)
+	return claims, ok
+}
+
+// tokenUserFor returns the public.token_infos user serving the request: the session user when the
+// browser is logged in to the request tenant, otherwise the tenant's shared authorization
+func tokenUserFor(r *http.Request) string {
+	tenant := tenantFrom(r)
+	if claims, ok := sessionFrom(r); ok && claims.Tenant == tenant.Slug {
+		return claims.Subject
+	}
+	return tenant.TokenUserID
+}
+
+// sessionTokenUserID picks the token user for a new authorization: stores identified by Converty
+// get their own token, otherwise the tenant's shared authorization is used
+func sessionTokenUserID(tenant service.Tenant, storeID string) string {
+	if storeID == "" {
+		return tenant.TokenUserID
+	}
+	if tenant.ID == service.DefaultTenant.ID {
+		return "store:" + storeID
+	}
+	return tenant.TokenUserID + ":store:" + storeID
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"convertyApi/service"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// memorySessions is an in-memory SessionService for handler tests
+type memorySessions struct {
+	sessions map[string]service.UserSession
+}
+
+func newMemorySessions() *memorySessions {
+	return &memorySessions{sessions: map[string]service.UserSession{}}
+}
+
+func (m *memorySessions) Create(session service.UserSession, maxPerSubject int) (service.UserSession, error) {
+	session.ID = fmt.Sprintf("s%d", len(m.sessions)+1)
+	session.CreatedAt = time.Now()
+	m.sessions[session.ID] = session
+	return session, nil
+}
+
+func (m *memorySessions) Validate(id string) (service.UserSession, error) {
+	session, ok := m.sessions[id]
+	if !ok || !session.Active(time.Now()) {
+		return service.UserSession{}, service.ErrSessionInactive
+	}
+	return session, nil
+}
+
+func (m *memorySessions) ListActive(kind, subject string) ([]service.UserSession, error) {
+	var active []service.UserSession
+	for _, session := range m.sessions {
+		if session.Active(time.Now()) && (kind == "" || session.Kind == kind) && (subject == "" || session.Subject == subject) {
+			active = append(active, session)
+		}
+	}
+	return active, nil
+}
+
+func (m *memorySessions) Revoke(id, actor string) (service.UserSession, error) {
+	session, ok := m.sessions[id]
+	if !ok {
+		return service.UserSession{}, service.ErrSessionInactive
+	}
+	now := time.Now()
+	session.RevokedAt, session.RevokedBy = &now, actor
+	m.sessions[id] = session
+	return session, nil
+}
+
+func (m *memorySessions) Consume(id, actor string) (service.UserSession, error) {
+	if _, err := m.Validate(id); err != nil {
+		return service.UserSession{}, err
+	}
+	return m.Revoke(id, actor)
+}
+
+func (m *memorySessions) RevokeSubject(kind, subject, actor string) (int64, error) {
+	active, _ := m.ListActive(kind, subject)
+	for _, session := range active {
+		m.Revoke(session.ID, actor)
+	}
+	return int64(len(active)), nil
+}
+
+func (m *memorySessions) MarkSecondFactor(id string) error {
+	session, err := m.Validate(id)
+	if err != nil {
+		return err
+	}
+	session.SecondFactor = true
+	m.sessions[id] = session
+	return nil
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	sessionSecret = []byte("0123456789abcdef0123456789abcdef")
+	now := time.Now()
+	session := service.UserSession{ID: "s1", Subject: "store:42", StoreID: "42", Tenant: "default", CreatedAt: now, ExpiresAt: now.Add(sessionTTL)}
+	token, err := signSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := parseSession(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ID != "s1" || claims.Subject != "store:42" || claims.StoreID != "42" || claims.Tenant != "default" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	session.ExpiresAt = now.Add(-time.Minute)
+	expired, _ := signSession(session)
+	if _, err := parseSession(expired); err == nil {
+		t.Fatal("expected an expired session to be rejected")
+	}
+	if _, err := parseSession(token[:len(token)-2] + "xx"); err == nil {
+		t.Fatal("expected a tampered session to be rejected")
+	}
+}
+
+func TestSessionRevocation(t *testing.T) {
+	sessionSecret = []byte("0123456789abcdef0123456789abcdef")
+	sessions := newMemorySessions()
+	rec := httptest.NewRecorder()
+	if err := startSession(rec, httptest.NewRequest(http.MethodGet, "/api/v1/callback", nil), sessions, sessionCookieName,
+		service.UserSession{Kind: service.SessionStore, Subject: "store:42", Remember: true}); err != nil {
+		t.Fatal(err)
+	}
+	cookie := rec.Result().Cookies()[0]
+	if cookie.MaxAge != int(sessionRememberTTL.Seconds()) {
+		t.Errorf("remember-me cookie MaxAge = %d", cookie.MaxAge)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/orders", nil)
+	req.AddCookie(cookie)
+	if _, _, err := sessionFromCookie(req, sessions, sessionCookieName); err != nil {
+		t.Fatalf("active session rejected: %v", err)
+	}
+	sessions.RevokeSubject(service.SessionStore, "store:42", "admin")
+	if _, _, err := sessionFromCookie(req, sessions, sessionCookieName); err == nil {
+		t.Fatal("revoked session was accepted")
+	}
+}
+
+func TestSessionTokenUserID(t *testing.T) {
+	shop := service.Tenant{ID: 7, Slug: "shop", TokenUserID: "tenant:shop"}
+	cases := []struct {
+		tenant  service.Tenant
+		storeID string
+		want    string
+	}{
+		{service.DefaultTenant, "", "user1"},
+		{service.DefaultTenant, "42", "store:42"},
+		{shop, "", "tenant:shop"},
+		{shop, "42", "tenant:shop:store:42"},
+	}
+	for _, c := range cases {
+		if got := sessionTokenUserID(c.tenant, c.storeID); got != c.want {
+			t.Errorf("sessionTokenUserID(%s, %q) = %q, want %q", c.tenant.Slug, c.storeID, got, c.want)
+		}
+	}
+}
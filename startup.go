@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/joho/godotenv"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// serverAddr is the address of the HTTP server, also used by the degraded startup server
+const serverAddr = ":9001"
+
+// requiredEnv lists the variables the server cannot start without
+var requiredEnv = []string{"DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME", "CLIENT_ID", "CLIENT_SECRET"}
+
+// Database startup settings: how long to retry the first connection and the backoff between attempts
+var (
+	dbConnectWindow     = time.Minute
+	dbConnectBackoff    = 500 * time.Millisecond
+	dbConnectMaxBackoff = 15 * time.Second
+)
+
+// processEnv names the variables set by the environment rather than .env; they win over .env on reload too
+var processEnv = map[string]bool{}
+
+// loadEnv reads .env when present, then the secrets of SECRETS_PROVIDER, and checks that every required
+// variable is set. The file is optional so deployments can pass the configuration through the environment.
+func loadEnv() error {
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		processEnv[name] = true
+	}
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read .env file: %v", err)
+	}
+	if err := loadSecrets(); err != nil {
+		return err
+	}
+	return validateEnv(os.Getenv)
+}
+
+// validateEnv reports all missing or malformed required variables at once
+func validateEnv(getenv func(string) string) error {
+	var missing []string
+	for _, name := range requiredEnv {
+		if getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	var errs []error
+	if len(missing) > 0 {
+		errs = append(errs, fmt.Errorf("missing required environment variables: %s (set them in the environment or in .env)", strings.Join(missing, ", ")))
+	}
+	if port := getenv("DB_PORT"); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			errs = append(errs, fmt.Errorf("DB_PORT %q is not a valid port", port))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// openDB creates the database handle without connecting; queries fail until Postgres is reachable.
+// Each new connection reads DB_PASSWORD, so a password rotated in the secret manager needs no restart.
+func openDB() (*gorm.DB, error) {
+	dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable",
+		os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_USER"), os.Getenv("DB_NAME"))
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database settings: %v", err)
+	}
+	log.Printf("Connecting to database %s on %s:%s as %s", os.Getenv("DB_NAME"), os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_USER"))
+	conn := stdlib.OpenDB(*config, stdlib.OptionBeforeConnect(func(ctx context.Context, cc *pgx.ConnConfig) error {
+		cc.Password = os.Getenv("DB_PASSWORD")
+		return nil
+	}))
+	return gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{DisableAutomaticPing: true})
+}
+
+// waitForDB pings the database until it answers or window elapses, doubling the pause
+// between attempts up to dbConnectMaxBackoff
+func waitForDB(ctx context.Context, db *gorm.DB, window time.Duration) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(window)
+	backoff := dbConnectBackoff
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = sqlDB.PingContext(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if window > 0 && time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("database unreachable after %d attempts: %v", attempt, err)
+		}
+		log.Printf("Database not reachable (attempt %d): %v; retrying in %v", attempt, err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > dbConnectMaxBackoff {
+			backoff = dbConnectMaxBackoff
+		}
+	}
+}
+
+// awaitDatabase blocks until the database answers. When it stays down for the startup window
+// the process exits, unless DEGRADED_START=true, in which case /health and /readyz are served
+// while the connection keeps being retried in the background.
+func awaitDatabase() {
+	dbConnectWindow = durationEnv("DB_CONNECT_TIMEOUT", dbConnectWindow)
+	err := waitForDB(context.Background(), db, dbConnectWindow)
+	if err == nil {
+		return
+	}
+	if os.Getenv("DEGRADED_START") != "true" {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	log.Printf("Starting in degraded mode: %v", err)
+	serveDegraded(err)
+}
+
+// serveDegraded runs a minimal server until the database comes back, then shuts it down so
+// the full server can take over the address
+func serveDegraded(dbErr error) {
+	server := &http.Server{Addr: serverAddr, Handler: degradedRouter(dbErr)}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Degraded server failed to start: %v", err)
+		}
+	}()
+	// A zero window retries until the database answers
+	waitForDB(context.Background(), db, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to stop degraded server: %v", err)
+	}
+	log.Println("Database reachable again, leaving degraded mode")
+}
+
+// degradedRouter serves health and readiness while the database is down; every other route answers 503,
+// including /login, whose state must be stored
+func degradedRouter(dbErr error) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.Logger)
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusServiceUnavailable, HealthResponse{Status: "degraded", Database: dbErr.Error()})
+	})
+	r.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusServiceUnavailable, ReadinessResponse{Status: "degraded", Database: dbErr.Error(), Queries: service.DBQueries.Stats()})
+	})
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		writeError(w, "Service is starting in degraded mode: database unavailable", http.StatusServiceUnavailable)
+	})
+	return r
+}
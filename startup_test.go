@@ -0,0 +1,136 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestValidateEnvReportsEveryProblem(t *testing.T) {
+	env := map[string]string{"DB_HOST": "localhost", "DB_PORT": "54x2", "DB_USER": "app", "CLIENT_ID": "id"}
+	err := validateEnv(func(name string) string { return env[name] })
+	if err == nil {
+		t.Fatal("expected a configuration error")
+	}
+	for _, want := range []string{"DB_PASSWORD, DB_NAME, CLIENT_SECRET", `DB_PORT "54x2"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	for _, name := range requiredEnv {
+		env[name] = "1"
+	}
+	if err := validateEnv(func(name string) string { return env[name] }); err != nil {
+		t.Errorf("complete configuration rejected: %v", err)
+	}
+}
+
+func TestDegradedRouter(t *testing.T) {
+	// A handle to a closed port behaves like a database that is down
+	down, err := gorm.Open(postgres.Open("host=127.0.0.1 port=1 user=app dbname=app sslmode=disable connect_timeout=1"), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = down
+	defer func() { db = previous }()
+
+	router := degradedRouter(errors.New("connection refused"))
+	for path, want := range map[string]int{
+		"/health":        http.StatusServiceUnavailable,
+		"/readyz":        http.StatusServiceUnavailable,
+		"/login":         http.StatusServiceUnavailable,
+		"/api/v1/orders": http.StatusServiceUnavailable,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: got %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestLoadDBPool(t *testing.T) {
+	t.Setenv("DB_MAX_OPEN_CONNS", "40")
+	t.Setenv("DB_CONN_MAX_LIFETIME", "1h")
+	t.Setenv("DB_SLOW_QUERY_THRESHOLD", "0")
+	pool, err := loadDBPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool.MaxOpen != 40 || pool.MaxIdle != defaultDBPool.MaxIdle || pool.MaxLifetime != time.Hour || pool.SlowQuery != 0 {
+		t.Errorf("unexpected pool %+v", pool)
+	}
+
+	t.Setenv("DB_MAX_IDLE_CONNS", "50")
+	if _, err := loadDBPool(); err == nil {
+		t.Error("more idle than open connections accepted")
+	}
+	t.Setenv("DB_MAX_OPEN_CONNS", "many")
+	if _, err := loadDBPool(); err == nil {
+		t.Error("non-numeric DB_MAX_OPEN_CONNS accepted")
+	}
+}
+
+func TestReadinessReportsDownDatabase(t *testing.T) {
+	down, err := gorm.Open(postgres.Open("host=127.0.0.1 port=1 user=app dbname=app sslmode=disable connect_timeout=1"), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := configureDB(down, defaultDBPool); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	readinessHandler(down)(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want 503", rec.Code)
+	}
+	var response ReadinessResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Database == "" || response.Pool == nil || response.Pool.MaxOpen != defaultDBPool.MaxOpen {
+		t.Errorf("unexpected readiness %+v", response)
+	}
+}
+
+func TestSecretProvider(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(name string) string { return env[name] }
+	if provider, err := secretProvider(getenv); provider != nil || err != nil {
+		t.Errorf("no provider configured: %v, %v", provider, err)
+	}
+
+	env["SECRETS_PROVIDER"] = "vault"
+	if _, err := secretProvider(getenv); err == nil {
+		t.Error("vault without an address accepted")
+	}
+	env["VAULT_ADDR"], env["VAULT_TOKEN"], env["VAULT_SECRET_PATH"] = "https://vault:8200", "tok", "secret/data/convertyapi"
+	if provider, err := secretProvider(getenv); err != nil || provider.Name() != "vault" {
+		t.Errorf("vault: %v, %v", provider, err)
+	}
+
+	env["SECRETS_PROVIDER"] = "aws"
+	env["AWS_DEFAULT_REGION"], env["AWS_SECRET_ID"], env["AWS_ACCESS_KEY_ID"] = "eu-west-1", "prod/convertyapi", "AKID"
+	if _, err := secretProvider(getenv); err == nil {
+		t.Error("aws without a secret key accepted")
+	}
+	env["AWS_SECRET_ACCESS_KEY"] = "secret"
+	if provider, err := secretProvider(getenv); err != nil || provider.(*service.AWSSecretProvider).Region != "eu-west-1" {
+		t.Errorf("aws: %v, %v", provider, err)
+	}
+
+	env["SECRETS_PROVIDER"] = "keychain"
+	if _, err := secretProvider(getenv); err == nil {
+		t.Error("unknown provider accepted")
+	}
+}
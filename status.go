@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Component and overall statuses of the status page
+const (
+	statusOperational   = "operational"
+	statusMaintenance   = "maintenance"
+	statusDegraded      = "degraded"
+	statusPartialOutage = "partial_outage"
+	statusMajorOutage   = "major_outage"
+)
+
+// statusRank orders statuses from healthy to down
+var statusRank = map[string]int{
+	statusOperational:   0,
+	statusMaintenance:   1,
+	statusDegraded:      2,
+	statusPartialOutage: 3,
+	statusMajorOutage:   4,
+}
+
+// incidentStatus is the status an open incident of each severity imposes
+var incidentStatus = map[string]string{
+	service.IncidentMaintenance: statusMaintenance,
+	service.IncidentMinor:       statusDegraded,
+	service.IncidentMajor:       statusPartialOutage,
+	service.IncidentCritical:    statusMajorOutage,
+}
+
+// statusCacheTTL is how long a computed status page is served before the checks run again
+var statusCacheTTL = 30 * time.Second
+
+// statusIncidentHistory is how long resolved incidents stay on the page
+var statusIncidentHistory = 7 * 24 * time.Hour
+
+// statusComponent is one checked part of the system
+type statusComponent struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// statusPage is the public GET /status document; it never includes error details
+type statusPage struct {
+	Status     string                   `json:"status"`
+	Components []statusComponent        `json:"components"`
+	Incidents  []service.StatusIncident `json:"incidents"`
+	UpdatedAt  time.Time                `json:"updated_at"`
+}
+
+// worseStatus returns the more severe of two statuses
+func worseStatus(a, b string) string {
+	if statusRank[b] > statusRank[a] {
+		return b
+	}
+	return a
+}
+
+// summarizeStatus applies open incidents to their components and derives the overall status
+func summarizeStatus(components []statusComponent, incidents []service.StatusIncident) string {
+	overall := statusOperational
+	for _, incident := range incidents {
+		if incident.ResolvedAt != nil {
+			continue
+		}
+		imposed := incidentStatus[incident.Severity]
+		matched := false
+		for i := range components {
+			if components[i].Name == incident.Component {
+				components[i].Status = worseStatus(components[i].Status, imposed)
+				matched = true
+			}
+		}
+		if !matched {
+			overall = worseStatus(overall, imposed)
+		}
+	}
+	for _, component := range components {
+		status := component.Status
+		// One component down is a partial outage of the whole service
+		if status == statusMajorOutage && len(components) > 1 {
+			status = statusPartialOutage
+		}
+		overall = worseStatus(overall, status)
+	}
+	return overall
+}
+
+// checkComponents probes the database, the Converty connector and the job queue
+func checkComponents() []statusComponent {
+	components := []statusComponent{{Name: "api", Status: statusOperational}}
+
+	database := statusOperational
+	if sqlDB, err := db.DB(); err != nil {
+		database = statusMajorOutage
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if err := sqlDB.PingContext(ctx); err != nil {
+			database = statusMajorOutage
+		}
+		cancel()
+	}
+	components = append(components, statusComponent{Name: "database", Status: database})
+
+	converty := statusOperational
+	switch service.UpstreamState() {
+	case "half-open":
+		converty = statusDegraded
+	case "open":
+		converty = statusMajorOutage
+	}
+	components = append(components, statusComponent{Name: "converty", Status: converty})
+
+	jobs := statusOperational
+	if database != statusOperational {
+		jobs = statusMajorOutage
+	} else {
+		var failed, stuck int64
+		db.Model(&service.Job{}).Where("status = ? AND updated_at > ?", service.JobFailed, time.Now().Add(-time.Hour)).Count(&failed)
+		db.Model(&service.Job{}).Where("status = ? AND run_at < ?", service.JobQueued, time.Now().Add(-15*time.Minute)).Count(&stuck)
+		if failed > 0 || stuck > 0 {
+			jobs = statusDegraded
+		}
+	}
+	return append(components, statusComponent{Name: "background_jobs", Status: jobs})
+}
+
+// statusCache holds the last rendered status page
+type statusCache struct {
+	mu      sync.Mutex
+	body    []byte
+	etag    string
+	expires time.Time
+}
+
+// render returns the cached page, computing it again once it expired
+func (c *statusCache) render(statusService service.StatusService) ([]byte, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.body != nil && time.Now().Before(c.expires) {
+		return c.body, c.etag, nil
+	}
+	page := statusPage{Components: checkComponents(), Incidents: []service.StatusIncident{}, UpdatedAt: time.Now().UTC()}
+	if incidents, err := statusService.ListIncidents(time.Now().Add(-statusIncidentHistory)); err == nil {
+		page.Incidents = incidents
+	}
+	page.Status = summarizeStatus(page.Components, page.Incidents)
+	body, err := json.Marshal(page)
+	if err != nil {
+		return nil, "", err
+	}
+	// The ETag ignores UpdatedAt so unchanged pages revalidate across refreshes
+	page.UpdatedAt = time.Time{}
+	stable, _ := json.Marshal(page)
+	sum := sha256.Sum256(stable)
+	c.body, c.etag, c.expires = body, `"`+hex.EncodeToString(sum[:8])+`"`, time.Now().Add(statusCacheTTL)
+	return c.body, c.etag, nil
+}
+
+// invalidate makes the next request recompute the page
+func (c *statusCache) invalidate() {
+	c.mu.Lock()
+	c.expires = time.Time{}
+	c.mu.Unlock()
+}
+
+// registerStatusRoutes mounts the public status page
+func registerStatusRoutes(r chi.Router, statusService service.StatusService, cache *statusCache) {
+	r.Get("/status", func(w http.ResponseWriter, r *http.Request) {
+		body, etag, err := cache.render(statusService)
+		if err != nil {
+			writeError(w, "Failed to render status", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(statusCacheTTL.Seconds())))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeRawJSON(w, r, http.StatusOK, body)
+	})
+}
+
+// registerIncidentAdminRoutes lets operators post incident notes to the status page
+func registerIncidentAdminRoutes(r chi.Router, statusService service.StatusService, cache *statusCache) {
+	r.Get("/incidents", func(w http.ResponseWriter, r *http.Request) {
+		since := time.Now().Add(-statusIncidentHistory)
+		if r.URL.Query().Get("all") == "true" {
+			since = time.Time{}
+		}
+		incidents, err := statusService.ListIncidents(since)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, incidents)
+	})
+
+	r.Post("/incidents", func(w http.ResponseWriter, r *http.Request) {
+		var input service.StatusIncident
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		incident, err := statusService.CreateIncident(input, adminActor(r))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cache.invalidate()
+		writeJSON(w, r, http.StatusCreated, incident)
+	})
+
+	r.Patch("/incidents/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		var input service.IncidentUpdate
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		incident, err := statusService.UpdateIncident(uint(id), input)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cache.invalidate()
+		writeJSON(w, r, http.StatusOK, incident)
+	})
+
+	r.Post("/incidents/{id}/resolve", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		incident, err := statusService.ResolveIncident(uint(id))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		cache.invalidate()
+		writeJSON(w, r, http.StatusOK, incident)
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestSummarizeStatus(t *testing.T) {
+	healthy := func() []statusComponent {
+		return []statusComponent{{Name: "api", Status: statusOperational}, {Name: "converty", Status: statusOperational}}
+	}
+	resolved := time.Now()
+	cases := []struct {
+		name       string
+		components []statusComponent
+		incidents  []service.StatusIncident
+		want       string
+	}{
+		{"healthy", healthy(), nil, statusOperational},
+		{"resolved incidents are ignored", healthy(), []service.StatusIncident{{Severity: service.IncidentCritical, ResolvedAt: &resolved}}, statusOperational},
+		{"maintenance", healthy(), []service.StatusIncident{{Severity: service.IncidentMaintenance}}, statusMaintenance},
+		{"critical without component", healthy(), []service.StatusIncident{{Severity: service.IncidentCritical}}, statusMajorOutage},
+		{"critical on one component", healthy(), []service.StatusIncident{{Severity: service.IncidentCritical, Component: "converty"}}, statusPartialOutage},
+		{"component down", []statusComponent{{Name: "api", Status: statusOperational}, {Name: "database", Status: statusMajorOutage}}, nil, statusPartialOutage},
+	}
+	for _, c := range cases {
+		if got := summarizeStatus(c.components, c.incidents); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+// memoryIncidents is an in-memory StatusService
+type memoryIncidents struct {
+	incidents []service.StatusIncident
+}
+
+func (m *memoryIncidents) CreateIncident(incident service.StatusIncident, actor string) (service.StatusIncident, error) {
+	incident.ID = uint(len(m.incidents) + 1)
+	m.incidents = append(m.incidents, incident)
+	return incident, nil
+}
+
+func (m *memoryIncidents) UpdateIncident(id uint, update service.IncidentUpdate) (service.StatusIncident, error) {
+	return m.incidents[id-1], nil
+}
+
+func (m *memoryIncidents) ResolveIncident(id uint) (service.StatusIncident, error) {
+	now := time.Now()
+	m.incidents[id-1].ResolvedAt = &now
+	return m.incidents[id-1], nil
+}
+
+func (m *memoryIncidents) ListIncidents(time.Time) ([]service.StatusIncident, error) {
+	return append([]service.StatusIncident(nil), m.incidents...), nil
+}
+
+func TestStatusPage(t *testing.T) {
+	down, err := gorm.Open(postgres.Open("host=127.0.0.1 port=1 user=app dbname=app sslmode=disable connect_timeout=1"), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = down
+	defer func() { db = previous }()
+
+	incidents := &memoryIncidents{}
+	incidents.CreateIncident(service.StatusIncident{Title: "Slow order sync", Severity: service.IncidentMinor, Component: "converty"}, "ops")
+	cache := &statusCache{}
+	r := chi.NewRouter()
+	registerStatusRoutes(r, incidents, cache)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") == "" || rec.Header().Get("ETag") == "" {
+		t.Fatalf("status page: %d %v", rec.Code, rec.Header())
+	}
+	var page statusPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	components := map[string]string{}
+	for _, component := range page.Components {
+		components[component.Name] = component.Status
+	}
+	if components["database"] != statusMajorOutage || components["converty"] != statusDegraded || page.Status != statusPartialOutage {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+	if len(page.Incidents) != 1 || page.Incidents[0].Title != "Slow order sync" {
+		t.Fatalf("incident missing: %+v", page.Incidents)
+	}
+
+	// Unchanged pages revalidate with the ETag
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec304 := httptest.NewRecorder()
+	r.ServeHTTP(rec304, req)
+	if rec304.Code != http.StatusNotModified {
+		t.Fatalf("revalidation: got %d, want 304", rec304.Code)
+	}
+
+	// Posting an incident update shows up without waiting for the cache to expire
+	incidents.ResolveIncident(1)
+	cache.invalidate()
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("changed page must not be 304, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// recordListFilter reads the filter of a record listing. ?filter=<name> starts from a saved filter
+// of the caller; parameters of the request override the saved ones.
+func recordListFilter(r *http.Request, tagService service.TagService) (service.RecordFilter, error) {
+	params := r.URL.Query()
+	if name := params.Get("filter"); name != "" {
+		saved, err := tagService.GetFilter(tenantFrom(r).ID, requestActor(r), name)
+		if err != nil {
+			return service.RecordFilter{}, err
+		}
+		merged := saved.Values()
+		for key, values := range params {
+			merged[key] = values
+		}
+		params = merged
+	}
+	return parseRecordFilterValues(params)
+}
+
+// recordFilterStatus maps the errors of recordListFilter to a status code
+func recordFilterStatus(err error) int {
+	if errors.Is(err, service.ErrSavedFilterNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// registerTagRoutes mounts the record tags and the saved record filters. Saved filters belong to
+// the caller: a service account, a session user or the tenant API key.
+func registerTagRoutes(r chi.Router, dataService service.DataService, tagService service.TagService) {
+	r.Get("/api/v1/records/tags", func(w http.ResponseWriter, r *http.Request) {
+		tags, err := tagService.ListTags(tenantFrom(r).ID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, tags)
+	})
+
+	r.Get("/api/v1/records/{id}/tags", func(w http.ResponseWriter, r *http.Request) {
+		record, ok := taggedRecord(w, r, dataService)
+		if !ok {
+			return
+		}
+		tags, err := tagService.RecordTags(record)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"record_id": record.ID, "tags": tags})
+	})
+
+	// {"tags": ["urgent", "refund"]} adds the tags, creating the unknown ones
+	r.Post("/api/v1/records/{id}/tags", func(w http.ResponseWriter, r *http.Request) {
+		var input tagRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		record, ok := taggedRecord(w, r, dataService)
+		if !ok {
+			return
+		}
+		tags, err := tagService.TagRecord(record, input.Tags, requestActor(r))
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"record_id": record.ID, "tags": tags})
+	})
+
+	r.Delete("/api/v1/records/{id}/tags/{tag}", func(w http.ResponseWriter, r *http.Request) {
+		record, ok := taggedRecord(w, r, dataService)
+		if !ok {
+			return
+		}
+		if err := tagService.UntagRecord(record, chi.URLParam(r, "tag")); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Get("/api/v1/records/filters", func(w http.ResponseWriter, r *http.Request) {
+		filters, err := tagService.ListFilters(tenantFrom(r).ID, requestActor(r))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, filters)
+	})
+
+	// {"query": "tag=refund&status=pending"}; GET /api/v1/records?filter=<name> applies it
+	r.Put("/api/v1/records/filters/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var input savedFilterRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		name := chi.URLParam(r, "name")
+		if name == "" || len(name) > 64 {
+			writeError(w, "Filter names are 1-64 characters", http.StatusBadRequest)
+			return
+		}
+		filter, err := tagService.SaveFilter(tenantFrom(r).ID, requestActor(r), name, input.Query)
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSON(w, r, http.StatusOK, filter)
+	})
+
+	r.Delete("/api/v1/records/filters/{name}", func(w http.ResponseWriter, r *http.Request) {
+		err := tagService.DeleteFilter(tenantFrom(r).ID, requestActor(r), chi.URLParam(r, "name"))
+		if errors.Is(err, service.ErrSavedFilterNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// taggedRecord loads the {id} record of the request tenant, writing the error response if it cannot
+func taggedRecord(w http.ResponseWriter, r *http.Request, dataService service.DataService) (service.Data, bool) {
+	var id uint
+	if _, err := fmt.Sscanf(chi.URLParam(r, "id"), "%d", &id); err != nil {
+		writeError(w, "Invalid ID format", http.StatusBadRequest)
+		return service.Data{}, false
+	}
+	record, err := tenantData(r, dataService).QueryByID(id)
+	if err != nil {
+		writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+		return service.Data{}, false
+	}
+	return record, true
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"convertyApi/api"
+	"convertyApi/grpcapi"
+	"convertyApi/service"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// tenantRequired rejects requests without an API key instead of serving them as the default tenant
+var tenantRequired bool
+
+type tenantContextKey struct{}
+
+// resolveTenant resolves the tenant from the X-API-Key header and stores it in the request context.
+// Service account keys resolve to the account's tenant and are limited to its permissions.
+func resolveTenant(tenantService service.TenantService, serviceAccounts service.ServiceAccountService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := userFrom(r); ok {
+				// resolveUser already set the operator's tenant
+				next.ServeHTTP(w, r)
+				return
+			}
+			tenant := service.DefaultTenant
+			apiKey := r.Header.Get("X-API-Key")
+			if apiKey == "" && websocket.IsWebSocketUpgrade(r) {
+				// Browsers cannot set headers on websocket handshakes
+				apiKey = r.URL.Query().Get("api_key")
+			}
+			if strings.HasPrefix(apiKey, service.ServiceAccountKeyPrefix) && serviceAccounts != nil {
+				account, err := serviceAccounts.ResolveKey(apiKey)
+				if err != nil {
+					writeError(w, "Invalid API key", http.StatusUnauthorized)
+					return
+				}
+				if tenant, err = tenantService.GetTenant(account.Tenant); err != nil {
+					writeError(w, "Invalid API key", http.StatusUnauthorized)
+					return
+				}
+				if !authorizeServiceAccount(w, r, account) {
+					return
+				}
+				ctx := context.WithValue(r.Context(), serviceAccountContextKey{}, account)
+				next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, tenantContextKey{}, tenant)))
+				return
+			}
+			if apiKey != "" {
+				resolved, err := tenantService.ResolveAPIKey(apiKey)
+				if err != nil {
+					writeError(w, "Invalid API key", http.StatusUnauthorized)
+					return
+				}
+				tenant = resolved
+			} else if tenantRequired && !isSignedDownload(r) && !isPublicPath(r.URL.Path) {
+				writeError(w, "Missing X-API-Key header", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, tenant)))
+		})
+	}
+}
+
+// grpcAuthenticator resolves gRPC callers like resolveTenant resolves HTTP ones, except that the API key
+// is always required: tenant keys reach their own tenant and service account keys are limited to the
+// policy permissions covering the HTTP route equivalent to the call
+func grpcAuthenticator(tenantService service.TenantService, serviceAccounts service.ServiceAccountService) grpcapi.Authenticator {
+	return func(apiKey, method, path string) (service.Tenant, error) {
+		if strings.HasPrefix(apiKey, service.ServiceAccountKeyPrefix) {
+			account, err := serviceAccounts.ResolveKey(apiKey)
+			if err != nil {
+				return service.Tenant{}, err
+			}
+			tenant, err := tenantService.GetTenant(account.Tenant)
+			if err != nil {
+				return service.Tenant{}, err
+			}
+			if !policy().permissionsAllow(account.PermissionList(), method, path) {
+				return service.Tenant{}, grpcapi.ErrForbidden
+			}
+			return tenant, nil
+		}
+		return tenantService.ResolveAPIKey(apiKey)
+	}
+}
+
+// tenantFrom returns the tenant resolved for the request
+func tenantFrom(r *http.Request) service.Tenant {
+	if tenant, ok := r.Context().Value(tenantContextKey{}).(service.Tenant); ok {
+		return tenant
+	}
+	return service.DefaultTenant
+}
+
+// hasCredentials reports whether the request authenticated as an operator, a service account or a
+// tenant API key, rather than falling back to the default tenant without any
+func hasCredentials(r *http.Request) bool {
+	_, user := userFrom(r)
+	_, account := serviceAccountFrom(r)
+	return user || account || r.Header.Get("X-API-Key") != ""
+}
+
+// tenantData scopes the data service to the request tenant, using the session user's Converty token when logged in
+func tenantData(r *http.Request, dataService service.DataService) service.DataService {
+	tenant := tenantFrom(r)
+	tenant.TokenUserID = tokenUserFor(r)
+	return dataService.ForTenant(tenant).WithPriority(service.PriorityInteractive).WithContext(r.Context())
+}
+
+// tenantJobPayload selects the tenant a job runs for; an empty slug runs it for every tenant
+type tenantJobPayload struct {
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// jobTenants returns the tenant named by a job payload, or every tenant including the default one
+func jobTenants(tenantService service.TenantService, payload json.RawMessage) ([]service.Tenant, error) {
+	var input tenantJobPayload
+	if len(payload) > 0 && string(payload) != "null" {
+		if err := json.Unmarshal(payload, &input); err != nil {
+			return nil, fmt.Errorf("invalid payload: %v", err)
+		}
+	}
+	if input.Tenant != "" {
+		tenant, err := tenantService.GetTenant(input.Tenant)
+		if err != nil {
+			return nil, err
+		}
+		return []service.Tenant{tenant}, nil
+	}
+	registered, err := tenantService.ListTenants()
+	if err != nil {
+		return nil, err
+	}
+	return append([]service.Tenant{service.DefaultTenant}, registered...), nil
+}
+
+// registerTenantAdminRoutes mounts tenant management under the admin router
+func registerTenantAdminRoutes(r chi.Router, tenantService service.TenantService) {
+	r.Get("/tenants", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tenants, err := tenantService.ListTenants()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, tenants, params))
+	})
+
+	// The API key is only returned here; it is stored hashed
+	r.Post("/tenants", func(w http.ResponseWriter, r *http.Request) {
+		var input tenantRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		tenant, apiKey, err := tenantService.CreateTenant(input.Slug, input.Name)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, map[string]interface{}{
+			"tenant":    tenant,
+			"api_key":   apiKey,
+			"login_url": fmt.Sprintf("%s/login?tenant=%s", publicBaseURL, tenant.Slug),
+		})
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"convertyApi/grpcapi"
+	"convertyApi/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubTenantService resolves a single hard-coded API key
+type stubTenantService struct {
+	service.TenantService
+}
+
+func (stubTenantService) ResolveAPIKey(apiKey string) (service.Tenant, error) {
+	if apiKey == "tk_shop" {
+		return service.Tenant{ID: 7, Slug: "shop", TokenUserID: "tenant:shop"}, nil
+	}
+	return service.Tenant{}, fmt.Errorf("unknown API key")
+}
+
+func TestResolveTenant(t *testing.T) {
+	var seen service.Tenant
+	handler := resolveTenant(stubTenantService{}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = tenantFrom(r)
+	}))
+
+	cases := []struct {
+		apiKey     string
+		required   bool
+		wantStatus int
+		wantSlug   string
+	}{
+		{"", false, http.StatusOK, service.DefaultTenant.Slug},
+		{"tk_shop", false, http.StatusOK, "shop"},
+		{"tk_wrong", false, http.StatusUnauthorized, ""},
+		{"", true, http.StatusUnauthorized, ""},
+	}
+	defer func() { tenantRequired = false }()
+	for _, c := range cases {
+		tenantRequired = c.required
+		seen = service.Tenant{}
+		req := httptest.NewRequest("GET", "/api/v1/records", nil)
+		if c.apiKey != "" {
+			req.Header.Set("X-API-Key", c.apiKey)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.wantStatus || seen.Slug != c.wantSlug {
+			t.Errorf("key %q required=%v: got status %d tenant %q, want %d %q", c.apiKey, c.required, rec.Code, seen.Slug, c.wantStatus, c.wantSlug)
+		}
+	}
+}
+
+// stubServiceAccounts knows a capture-only chatbot key
+type stubServiceAccounts struct {
+	service.ServiceAccountService
+}
+
+func (stubServiceAccounts) ResolveKey(key string) (service.ServiceAccount, error) {
+	if key == "sa_bot" {
+		return service.ServiceAccount{Tenant: "default", Name: "chatbot", Permissions: service.PermRecordsWrite}, nil
+	}
+	return service.ServiceAccount{}, service.ErrServiceAccountKeyInvalid
+}
+
+func (stubTenantService) GetTenant(slug string) (service.Tenant, error) {
+	return service.DefaultTenant, nil
+}
+
+func TestServiceAccountPermissions(t *testing.T) {
+	var account service.ServiceAccount
+	handler := resolveTenant(stubTenantService{}, stubServiceAccounts{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account, _ = serviceAccountFrom(r)
+	}))
+
+	for _, c := range []struct {
+		key, method, path string
+		want              int
+	}{
+		{"sa_bot", http.MethodPost, "/api/v1/records", http.StatusOK},
+		{"sa_bot", http.MethodPut, "/api/v1/records/4/status", http.StatusOK},
+		{"sa_bot", http.MethodGet, "/api/v1/records", http.StatusForbidden},
+		{"sa_bot", http.MethodGet, "/api/v1/orders/export", http.StatusForbidden},
+		{"sa_bot", http.MethodGet, "/login", http.StatusForbidden},
+		{"sa_revoked", http.MethodPost, "/api/v1/records", http.StatusUnauthorized},
+	} {
+		account = service.ServiceAccount{}
+		req := httptest.NewRequest(c.method, c.path, nil)
+		req.Header.Set("X-API-Key", c.key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s %s with %s: got %d, want %d", c.method, c.path, c.key, rec.Code, c.want)
+		}
+		if c.want == http.StatusOK && account.Name != "chatbot" {
+			t.Errorf("%s %s: service account not in the request context", c.method, c.path)
+		}
+	}
+}
+
+func TestGRPCAuthenticator(t *testing.T) {
+	authenticate := grpcAuthenticator(stubTenantService{}, stubServiceAccounts{})
+	for _, c := range []struct {
+		key, method, path string
+		wantSlug          string
+		wantForbidden     bool
+		wantErr           bool
+	}{
+		{"tk_shop", http.MethodGet, "/api/v1/records", "shop", false, false},
+		{"sa_bot", http.MethodPost, "/api/v1/records", service.DefaultTenant.Slug, false, false},
+		{"sa_bot", http.MethodGet, "/api/v1/orders/{id}", "", true, true},
+		{"sa_revoked", http.MethodPost, "/api/v1/records", "", false, true},
+		{"tk_wrong", http.MethodGet, "/api/v1/records", "", false, true},
+	} {
+		tenant, err := authenticate(c.key, c.method, c.path)
+		if (err != nil) != c.wantErr || errors.Is(err, grpcapi.ErrForbidden) != c.wantForbidden || tenant.Slug != c.wantSlug {
+			t.Errorf("%s %s with %s: got %q, %v", c.method, c.path, c.key, tenant.Slug, err)
+		}
+	}
+}
+
+func TestHasCredentials(t *testing.T) {
+	var got bool
+	handler := resolveTenant(stubTenantService{}, stubServiceAccounts{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = hasCredentials(r)
+	}))
+	for _, c := range []struct {
+		apiKey string
+		want   bool
+	}{{"", false}, {"tk_shop", true}, {"sa_bot", true}} {
+		got = false
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/records", nil)
+		if c.apiKey != "" {
+			req.Header.Set("X-API-Key", c.apiKey)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got != c.want {
+			t.Errorf("key %q: hasCredentials = %v, want %v", c.apiKey, got, c.want)
+		}
+	}
+}
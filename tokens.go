@@ -0,0 +1,510 @@
+package main
+
+import (
+	"convertyApi/api"
+	"convertyApi/console"
+	"convertyApi/service"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultRefreshTokenTTL is used when the provider does not report refresh_expires_in
+// and REFRESH_TOKEN_TTL is not set
+const defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+// refreshTokenTTL is the fallback lifetime of refresh tokens
+var refreshTokenTTL = defaultRefreshTokenTTL
+
+// TokenStatusResponse for the /api/v1/token/status endpoint
+type TokenStatusResponse struct {
+	UserID               string    `json:"user_id"`
+	TokenType            string    `json:"token_type"`
+	IssuedAt             time.Time `json:"issued_at"`
+	AccessExpiresAt      time.Time `json:"access_expires_at"`
+	AccessExpired        bool      `json:"access_expired"`
+	AccessExpiresInSecs  int64     `json:"access_expires_in"`
+	RefreshIssuedAt      time.Time `json:"refresh_issued_at"`
+	RefreshExpiresAt     time.Time `json:"refresh_expires_at"`
+	RefreshExpired       bool      `json:"refresh_expired"`
+	RefreshExpiresInSecs int64     `json:"refresh_expires_in"`
+	Scopes               []string  `json:"scopes"`
+	MissingScopes        []string  `json:"missing_scopes,omitempty"`
+}
+
+// loadRefreshTokenTTL reads REFRESH_TOKEN_TTL (a Go duration, e.g. "720h")
+func loadRefreshTokenTTL() {
+	value := os.Getenv("REFRESH_TOKEN_TTL")
+	if value == "" {
+		return
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		log.Printf("Invalid REFRESH_TOKEN_TTL %q, using default %v", value, defaultRefreshTokenTTL)
+		return
+	}
+	refreshTokenTTL = ttl
+}
+
+// loadTokenRefreshSkew reads TOKEN_REFRESH_SKEW, how long before expiry access tokens are refreshed (default 60s)
+func loadTokenRefreshSkew() {
+	service.TokenRefreshSkew = durationEnv("TOKEN_REFRESH_SKEW", service.TokenRefreshSkew)
+}
+
+// loadTokenExpiryLeeway reads TOKEN_EXPIRY_LEEWAY, how long before their recorded expiry tokens are
+// treated as expired to absorb clock drift (default 15s)
+func loadTokenExpiryLeeway() {
+	service.TokenExpiryLeeway = durationEnv("TOKEN_EXPIRY_LEEWAY", service.TokenExpiryLeeway)
+}
+
+// tokenExpiring reports whether the access token expires within the refresh skew of now, which is
+// taken on Converty's clock (service.UpstreamNow)
+func tokenExpiring(tokenInfo TokenInfo, now time.Time) bool {
+	return service.TokenExpiresWithin(tokenInfo.ExpiresAt, now, service.TokenRefreshSkew)
+}
+
+// refreshExpired reports whether the refresh token has expired at now, on Converty's clock
+func refreshExpired(tokenInfo TokenInfo, now time.Time) bool {
+	return service.TokenExpiresWithin(tokenInfo.RefreshExpiresAt, now, 0)
+}
+
+// storedTokens serves the stored tokens to the upstream routes without reading public.token_infos
+// on every request; writes to a token drop it with service.Caches.TokenChanged
+var storedTokens = service.NewTokenCache(service.DefaultTokenCacheTTL)
+
+// loadStoredToken reads the token of a user through storedTokens
+func loadStoredToken(userID string) (TokenInfo, error) {
+	if cached, ok := storedTokens.Get(userID); ok {
+		return cached.(TokenInfo), nil
+	}
+	var tokenInfo TokenInfo
+	if err := db.Where("user_id = ?", userID).First(&tokenInfo).Error; err != nil {
+		return TokenInfo{}, err
+	}
+	storedTokens.Set(userID, tokenInfo)
+	return tokenInfo, nil
+}
+
+// freshToken refreshes the Converty token of the request before a Converty-calling handler runs when
+// it expires within the refresh skew, so it cannot die in the middle of the upstream call. A token
+// that cannot be refreshed is refused up front rather than used for a call it may not outlive.
+// Missing and invalidated tokens are left to the handler, which reports them.
+func freshToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenInfo, err := loadStoredToken(tokenUserFor(r))
+		if err != nil || tokenInfo.Invalid || !tokenExpiring(tokenInfo, service.UpstreamNow()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if refreshExpired(tokenInfo, service.UpstreamNow()) {
+			writeReauthRequired(w, r, tokenInfo.UserID, fmt.Sprintf("Refresh token has expired at: %v", tokenInfo.RefreshExpiresAt))
+			return
+		}
+		if _, err := refreshStoredToken(tokenInfo); err != nil {
+			if isRefreshRejected(err) {
+				writeReauthRequired(w, r, tokenInfo.UserID, err.Error())
+				return
+			}
+			w.Header().Set("Retry-After", "5")
+			writeError(w, fmt.Sprintf("Access token expires at %s and could not be refreshed: %v",
+				tokenInfo.ExpiresAt.Format(time.RFC3339), err), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refreshExpiry computes when a refresh token issued at issuedAt expires
+func refreshExpiry(issuedAt time.Time, refreshExpiresIn int) time.Time {
+	if refreshExpiresIn > 0 {
+		return issuedAt.Add(time.Second * time.Duration(refreshExpiresIn))
+	}
+	return issuedAt.Add(refreshTokenTTL)
+}
+
+// newTokenInfo builds the TokenInfo row for a freshly issued token pair
+func newTokenInfo(userID string, tokenResp TokenResponse, issuedAt time.Time) *TokenInfo {
+	return &TokenInfo{
+		UserID:           userID,
+		AccessToken:      tokenResp.AccessToken,
+		RefreshToken:     tokenResp.RefreshToken,
+		TokenType:        tokenResp.TokenType,
+		ExpiresIn:        int64(tokenResp.ExpiresIn),
+		IssuedAt:         issuedAt,
+		ExpiresAt:        issuedAt.Add(time.Second * time.Duration(tokenResp.ExpiresIn)),
+		RefreshIssuedAt:  issuedAt,
+		RefreshExpiresAt: refreshExpiry(issuedAt, tokenResp.RefreshExpiresIn),
+	}
+}
+
+// refreshUpdates returns the columns to update after a refresh grant.
+// The refresh token lifetime is only reset when the provider rotated the refresh token.
+func refreshUpdates(tokenResp TokenResponse, issuedAt time.Time) map[string]interface{} {
+	updates := map[string]interface{}{
+		"access_token": tokenResp.AccessToken,
+		"issued_at":    issuedAt,
+		"expires_at":   issuedAt.Add(time.Second * time.Duration(tokenResp.ExpiresIn)),
+		"expires_in":   int64(tokenResp.ExpiresIn),
+	}
+	if tokenResp.TokenType != "" {
+		updates["token_type"] = tokenResp.TokenType
+	}
+	if tokenResp.Scope != "" {
+		updates["scopes"] = tokenResp.Scope
+	}
+	if tokenResp.RefreshToken != "" {
+		updates["refresh_token"] = tokenResp.RefreshToken
+		updates["refresh_issued_at"] = issuedAt
+		updates["refresh_expires_at"] = refreshExpiry(issuedAt, tokenResp.RefreshExpiresIn)
+	}
+	return updates
+}
+
+// refreshRejectedError is returned when the provider refuses the refresh token itself
+// (e.g. invalid_grant), meaning only a new authorization can recover
+type refreshRejectedError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *refreshRejectedError) Error() string {
+	return fmt.Sprintf("refresh token rejected with status %d: %s", e.StatusCode, e.Body)
+}
+
+// isRefreshRejected reports whether err means the refresh token is no longer usable
+func isRefreshRejected(err error) bool {
+	_, ok := err.(*refreshRejectedError)
+	return ok
+}
+
+// requestRefreshGrant exchanges a refresh token for a new token response, returning with it when
+// Converty issued it (service.UpstreamIssuedAt)
+func requestRefreshGrant(refreshToken string) (TokenResponse, time.Time, error) {
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("client_id", clientID)
+	data.Set("client_secret", oauthClientSecret())
+	data.Set("refresh_token", refreshToken)
+
+	client := service.NewUpstreamClient(10 * time.Second)
+	resp, err := client.PostForm(tokenURL, data)
+	if err != nil {
+		return TokenResponse{}, time.Time{}, fmt.Errorf("failed to refresh token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return TokenResponse{}, time.Time{}, fmt.Errorf("failed to read refresh response: %v", err)
+	}
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		return TokenResponse{}, time.Time{}, &refreshRejectedError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return TokenResponse{}, time.Time{}, fmt.Errorf("refresh token request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var tokenResp TokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return TokenResponse{}, time.Time{}, fmt.Errorf("failed to parse refresh response: %v", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return TokenResponse{}, time.Time{}, fmt.Errorf("no access token in refresh response")
+	}
+	return tokenResp, service.UpstreamIssuedAt(resp.Header), nil
+}
+
+// tokenRefreshes runs at most one refresh grant per user at a time; Converty may rotate the refresh
+// token, so a second concurrent grant with the old one would be rejected
+var tokenRefreshes singleflight.Group
+
+// refreshStoredToken replaces the access token of stale, the stored token as the caller read it.
+// Concurrent callers for one user share a single grant, and a caller whose token was already
+// replaced by another request or instance gets the stored token without a new grant.
+func refreshStoredToken(stale TokenInfo) (TokenInfo, error) {
+	refreshed, err, _ := tokenRefreshes.Do(stale.UserID, func() (interface{}, error) {
+		var current TokenInfo
+		if err := db.Where("user_id = ?", stale.UserID).First(&current).Error; err != nil {
+			return TokenInfo{}, fmt.Errorf("%w: %s", errTokenNotFound, stale.UserID)
+		}
+		if current.AccessToken != stale.AccessToken && !tokenExpiring(current, service.UpstreamNow()) {
+			return current, nil
+		}
+		// The stored refresh token is the latest one even if the caller read an older row
+		tokenResp, issuedAt, err := requestRefreshGrant(current.RefreshToken)
+		if err != nil {
+			return TokenInfo{}, err
+		}
+		if err := db.Model(&current).Updates(refreshUpdates(tokenResp, issuedAt)).Error; err != nil {
+			return TokenInfo{}, fmt.Errorf("failed to update token in database: %v", err)
+		}
+		service.Caches.TokenChanged(stale.UserID)
+		if err := db.Where("user_id = ?", stale.UserID).First(&current).Error; err != nil {
+			return TokenInfo{}, fmt.Errorf("failed to reload token: %v", err)
+		}
+		return current, nil
+	})
+	if err != nil {
+		return TokenInfo{}, err
+	}
+	return refreshed.(TokenInfo), nil
+}
+
+// refreshServiceToken is service.RefreshToken: the data service refreshes through the same grant,
+// singleflight group and persisted token response as /GetAccessToken
+func refreshServiceToken(userID, staleAccessToken string) (string, error) {
+	refreshed, err := refreshStoredToken(TokenInfo{UserID: userID, AccessToken: staleAccessToken})
+	if err != nil {
+		return "", err
+	}
+	return refreshed.AccessToken, nil
+}
+
+// tokenResponseFor describes a stored token the way the provider's token endpoint does
+func tokenResponseFor(tokenInfo TokenInfo, now time.Time) TokenResponse {
+	return TokenResponse{
+		AccessToken:      tokenInfo.AccessToken,
+		RefreshToken:     tokenInfo.RefreshToken,
+		ExpiresIn:        int(tokenInfo.ExpiresAt.Sub(now).Seconds()),
+		TokenType:        tokenInfo.TokenType,
+		RefreshExpiresIn: int(tokenInfo.RefreshExpiresAt.Sub(now).Seconds()),
+		StoreID:          tokenInfo.StoreID,
+		Scope:            tokenInfo.Scopes,
+	}
+}
+
+// tokenStatus reports both token expirations relative to now
+func tokenStatus(tokenInfo TokenInfo, now time.Time) TokenStatusResponse {
+	return TokenStatusResponse{
+		UserID:               tokenInfo.UserID,
+		TokenType:            tokenInfo.TokenType,
+		IssuedAt:             tokenInfo.IssuedAt,
+		AccessExpiresAt:      tokenInfo.ExpiresAt,
+		AccessExpired:        service.TokenExpiresWithin(tokenInfo.ExpiresAt, now, 0),
+		AccessExpiresInSecs:  int64(tokenInfo.ExpiresAt.Sub(now).Seconds()),
+		RefreshIssuedAt:      tokenInfo.RefreshIssuedAt,
+		RefreshExpiresAt:     tokenInfo.RefreshExpiresAt,
+		RefreshExpired:       refreshExpired(tokenInfo, now),
+		RefreshExpiresInSecs: int64(tokenInfo.RefreshExpiresAt.Sub(now).Seconds()),
+		Scopes:               strings.Fields(tokenInfo.Scopes),
+		MissingScopes:        strings.Fields(tokenInfo.MissingScopes),
+	}
+}
+
+// adminTokenView is a stored token as shown to operators; the token values themselves are never returned
+type adminTokenView struct {
+	TokenStatusResponse
+	TenantID      uint       `json:"tenant_id"`
+	StoreID       string     `json:"store_id,omitempty"`
+	Invalid       bool       `json:"invalid"`
+	InvalidReason string     `json:"invalid_reason,omitempty"`
+	InvalidatedAt *time.Time `json:"invalidated_at,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// viewAdminToken builds the operator view of a stored token
+func viewAdminToken(tokenInfo TokenInfo, now time.Time) adminTokenView {
+	return adminTokenView{
+		TokenStatusResponse: tokenStatus(tokenInfo, now),
+		TenantID:            tokenInfo.TenantID,
+		StoreID:             tokenInfo.StoreID,
+		Invalid:             tokenInfo.Invalid,
+		InvalidReason:       tokenInfo.InvalidReason,
+		InvalidatedAt:       tokenInfo.InvalidatedAt,
+		UpdatedAt:           tokenInfo.UpdatedAt,
+	}
+}
+
+// errTokenNotFound is returned when no token is stored for a user
+var errTokenNotFound = errors.New("no token stored for this user")
+
+// forceRefresh runs a refresh grant for a stored token now, whatever its access expiry.
+// A rejected refresh token starts the re-authentication workflow and returns refreshRejectedError.
+func forceRefresh(userID string) (TokenInfo, error) {
+	var tokenInfo TokenInfo
+	if err := db.Where("user_id = ?", userID).First(&tokenInfo).Error; err != nil {
+		return TokenInfo{}, fmt.Errorf("%w: %s", errTokenNotFound, userID)
+	}
+	if tokenInfo.RefreshToken == "" {
+		return TokenInfo{}, fmt.Errorf("no refresh token stored for %s", userID)
+	}
+	tokenInfo, err := refreshStoredToken(tokenInfo)
+	if err != nil {
+		if isRefreshRejected(err) {
+			if _, reauthErr := startReauth(userID, err.Error()); reauthErr != nil {
+				log.Printf("Failed to start re-authentication for %s: %v", userID, reauthErr)
+			}
+		}
+		return TokenInfo{}, err
+	}
+	if err := clearReauth(userID); err != nil {
+		return TokenInfo{}, fmt.Errorf("failed to mark token valid: %v", err)
+	}
+	tokenInfo.Invalid, tokenInfo.InvalidReason, tokenInfo.InvalidatedAt = false, "", nil
+	return tokenInfo, nil
+}
+
+// registerTokenAdminRoutes lets operators inspect, refresh and revoke stored Converty tokens
+func registerTokenAdminRoutes(r chi.Router, sessions service.SessionService) {
+	// /tokens?invalid=true lists only tokens waiting for a new authorization
+	r.Get("/tokens", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := db.Order("user_id")
+		if r.URL.Query().Get("invalid") == "true" {
+			query = query.Where("invalid = ?", true)
+		}
+		var tokens []TokenInfo
+		if err := query.Find(&tokens).Error; err != nil {
+			writeError(w, fmt.Sprintf("Failed to fetch tokens: %v", err), http.StatusInternalServerError)
+			return
+		}
+		now := time.Now()
+		views := make([]adminTokenView, 0, len(tokens))
+		for _, tokenInfo := range tokens {
+			views = append(views, viewAdminToken(tokenInfo, now))
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, views, params))
+	})
+
+	r.Post("/tokens/{user}/refresh", func(w http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "user")
+		tokenInfo, err := forceRefresh(userID)
+		switch {
+		case errors.Is(err, errTokenNotFound):
+			writeError(w, err.Error(), http.StatusNotFound)
+		case isRefreshRejected(err):
+			writeError(w, fmt.Sprintf("Converty rejected the refresh token, %s must authorize again: %v", userID, err), http.StatusConflict)
+		case err != nil:
+			writeError(w, err.Error(), http.StatusBadGateway)
+		default:
+			log.Printf("Token of %s refreshed by %s", userID, adminActor(r))
+			writeJSON(w, r, http.StatusOK, viewAdminToken(tokenInfo, time.Now()))
+		}
+	})
+
+	// Deletes the stored token and ends the browser sessions using it; the user must log in again
+	r.Delete("/tokens/{user}", func(w http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "user")
+		result := db.Unscoped().Where("user_id = ?", userID).Delete(&TokenInfo{})
+		if result.Error != nil {
+			writeError(w, fmt.Sprintf("Failed to revoke token: %v", result.Error), http.StatusInternalServerError)
+			return
+		}
+		service.Caches.TokenChanged(userID)
+		if result.RowsAffected == 0 {
+			writeError(w, fmt.Sprintf("%v: %s", errTokenNotFound, userID), http.StatusNotFound)
+			return
+		}
+		if _, err := sessions.RevokeSubject(service.SessionStore, userID, adminActor(r)); err != nil {
+			log.Printf("Failed to end sessions of revoked token %s: %v", userID, err)
+		}
+		log.Printf("Token of %s revoked by %s", userID, adminActor(r))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// consoleTokens gives the console access to the stored tokens
+type consoleTokens struct{}
+
+func consoleTokenStatus(tokenInfo TokenInfo) console.TokenStatus {
+	status := tokenStatus(tokenInfo, service.UpstreamNow())
+	return console.TokenStatus{
+		UserID:           status.UserID,
+		IssuedAt:         status.IssuedAt,
+		AccessExpiresAt:  status.AccessExpiresAt,
+		RefreshExpiresAt: status.RefreshExpiresAt,
+		Scopes:           status.Scopes,
+		MissingScopes:    status.MissingScopes,
+		Invalid:          tokenInfo.Invalid,
+		InvalidReason:    tokenInfo.InvalidReason,
+	}
+}
+
+// TokenStatus loads the stored token of a user
+func (consoleTokens) TokenStatus(userID string) (console.TokenStatus, error) {
+	tokenInfo, err := loadStoredToken(userID)
+	if err != nil {
+		return console.TokenStatus{}, fmt.Errorf("%w: %s, authenticate via /login", errTokenNotFound, userID)
+	}
+	return consoleTokenStatus(tokenInfo), nil
+}
+
+// RefreshToken forces a refresh grant; a rejected refresh token starts re-authentication
+func (consoleTokens) RefreshToken(userID string) (console.TokenStatus, error) {
+	tokenInfo, err := forceRefresh(userID)
+	if err != nil {
+		return console.TokenStatus{}, err
+	}
+	return consoleTokenStatus(tokenInfo), nil
+}
+
+// LoginURL returns the pending re-authentication link of the user, or the tenant's /login URL
+func (consoleTokens) LoginURL(userID string) (string, error) {
+	var link ReauthLink
+	result := db.Where("user_id = ? AND used_at IS NULL AND expires_at > ?", userID, time.Now()).Order("id desc").Limit(1).Find(&link)
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to look up re-authentication link: %v", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return reauthLoginURL(link), nil
+	}
+	if userID == service.DefaultTenant.TokenUserID {
+		return publicBaseURL + "/login", nil
+	}
+	var tenant service.Tenant
+	if err := db.Where("token_user_id = ?", userID).First(&tenant).Error; err != nil {
+		return "", fmt.Errorf("no tenant uses token user %s: %v", userID, err)
+	}
+	return publicBaseURL + "/login?tenant=" + url.QueryEscape(tenant.Slug), nil
+}
+
+// UserIDs lists the users having a stored token
+func (consoleTokens) UserIDs() ([]string, error) {
+	var userIDs []string
+	if err := db.Model(&TokenInfo{}).Order("user_id").Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// runTokenCommand runs `token <subcommand>` from the command line and returns the exit code.
+// `token refresh-all [--format table|wide|csv|json] [--width N] [--no-color]` refreshes every stored
+// token and fails if any refresh did.
+func runTokenCommand(args []string, out io.Writer) int {
+	if len(args) == 0 || args[0] != "refresh-all" {
+		fmt.Fprintln(os.Stderr, "Usage: token refresh-all [--format table|wide|csv|json] [--width N] [--no-color]")
+		return 2
+	}
+	output := console.DefaultOutput()
+	fs := flag.NewFlagSet("token refresh-all", flag.ContinueOnError)
+	fs.StringVar(&output.Format, "format", output.Format, "output format: "+strings.Join(console.Formats, ", "))
+	fs.IntVar(&output.Width, "width", 0, "table width, $COLUMNS or 120 when 0")
+	fs.BoolVar(&output.NoColor, "no-color", output.NoColor, "leave out the status colors")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if err := output.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	if _, err := console.RefreshAllTokens(consoleTokens{}, notifier, out, output); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefreshExpiry(t *testing.T) {
+	issuedAt := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := refreshExpiry(issuedAt, 3600); !got.Equal(issuedAt.Add(time.Hour)) {
+		t.Fatalf("expected provider lifetime to be honored, got %v", got)
+	}
+	if got := refreshExpiry(issuedAt, 0); !got.Equal(issuedAt.Add(refreshTokenTTL)) {
+		t.Fatalf("expected default lifetime %v, got %v", refreshTokenTTL, got)
+	}
+}
+
+func TestRefreshUpdatesKeepsRefreshExpiryWithoutRotation(t *testing.T) {
+	issuedAt := time.Now()
+	updates := refreshUpdates(TokenResponse{AccessToken: "new-access", ExpiresIn: 300}, issuedAt)
+
+	if _, ok := updates["refresh_expires_at"]; ok {
+		t.Fatal("refresh_expires_at must not change when the refresh token was not rotated")
+	}
+	if updates["expires_at"] != issuedAt.Add(300*time.Second) {
+		t.Fatalf("unexpected access expiry: %v", updates["expires_at"])
+	}
+
+	updates = refreshUpdates(TokenResponse{AccessToken: "a", RefreshToken: "r", ExpiresIn: 300, RefreshExpiresIn: 7200}, issuedAt)
+	if updates["refresh_expires_at"] != issuedAt.Add(2*time.Hour) {
+		t.Fatalf("unexpected refresh expiry: %v", updates["refresh_expires_at"])
+	}
+}
+
+func TestAdminTokenViewHidesSecrets(t *testing.T) {
+	now := time.Now()
+	tokenInfo := *newTokenInfo("tenant:acme", TokenResponse{AccessToken: "secret-access", RefreshToken: "secret-refresh", ExpiresIn: 60}, now.Add(-time.Hour))
+	tokenInfo.TenantID = 3
+	tokenInfo.Invalid = true
+	tokenInfo.InvalidReason = "invalid_grant"
+
+	view := viewAdminToken(tokenInfo, now)
+	if !view.AccessExpired || view.TenantID != 3 || !view.Invalid || view.UserID != "tenant:acme" {
+		t.Fatalf("unexpected view: %+v", view)
+	}
+	body, err := json.Marshal(view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), "secret-") {
+		t.Fatalf("token values leaked: %s", body)
+	}
+}
+
+func TestTokenExpiringHonorsSkew(t *testing.T) {
+	defer func(skew, leeway time.Duration) {
+		service.TokenRefreshSkew, service.TokenExpiryLeeway = skew, leeway
+	}(service.TokenRefreshSkew, service.TokenExpiryLeeway)
+	service.TokenRefreshSkew, service.TokenExpiryLeeway = time.Minute, 0
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, c := range []struct {
+		expiresIn time.Duration
+		want      bool
+	}{
+		{time.Hour, false},
+		{61 * time.Second, false},
+		{time.Minute, true},
+		{30 * time.Second, true},
+		{-time.Second, true},
+	} {
+		if got := tokenExpiring(TokenInfo{ExpiresAt: now.Add(c.expiresIn)}, now); got != c.want {
+			t.Errorf("token expiring in %v: tokenExpiring = %v, want %v", c.expiresIn, got, c.want)
+		}
+	}
+}
+
+func TestTokenExpiryLeeway(t *testing.T) {
+	defer func(skew, leeway time.Duration) {
+		service.TokenRefreshSkew, service.TokenExpiryLeeway = skew, leeway
+	}(service.TokenRefreshSkew, service.TokenExpiryLeeway)
+	service.TokenRefreshSkew, service.TokenExpiryLeeway = time.Minute, 30*time.Second
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if !tokenExpiring(TokenInfo{ExpiresAt: now.Add(80 * time.Second)}, now) {
+		t.Error("an access token expiring within skew and leeway should be refreshed")
+	}
+	if tokenExpiring(TokenInfo{ExpiresAt: now.Add(91 * time.Second)}, now) {
+		t.Error("an access token expiring after skew and leeway should be kept")
+	}
+	tokenInfo := TokenInfo{ExpiresAt: now.Add(20 * time.Second), RefreshExpiresAt: now.Add(20 * time.Second)}
+	if !refreshExpired(tokenInfo, now) {
+		t.Error("a refresh token expiring within the leeway should count as expired")
+	}
+	if status := tokenStatus(tokenInfo, now); !status.AccessExpired || !status.RefreshExpired || status.AccessExpiresInSecs != 20 {
+		t.Errorf("status within the leeway = %+v, want both expired with the raw remaining time", status)
+	}
+}
+
+func TestReauthLoginURLEscapesParams(t *testing.T) {
+	for _, c := range []struct {
+		link ReauthLink
+		want string
+	}{
+		{ReauthLink{UserID: "u1", Nonce: "abc"}, publicBaseURL + "/login?nonce=abc&user=u1"},
+		{ReauthLink{UserID: "a b&c", Nonce: "x/y+z"}, publicBaseURL + "/login?nonce=x%2Fy%2Bz&user=a+b%26c"},
+	} {
+		if got := reauthLoginURL(c.link); got != c.want {
+			t.Errorf("reauthLoginURL(%+v) = %q, want %q", c.link, got, c.want)
+		}
+	}
+}
+
+func TestConsoleTokenStatusHidesSecrets(t *testing.T) {
+	issuedAt := time.Now().Add(-time.Hour)
+	tokenInfo := *newTokenInfo("tenant:acme", TokenResponse{AccessToken: "secret-access", RefreshToken: "secret-refresh", ExpiresIn: 60}, issuedAt)
+	for _, c := range []struct {
+		invalid bool
+		reason  string
+	}{
+		{false, ""},
+		{true, "invalid_grant"},
+	} {
+		tokenInfo.Invalid, tokenInfo.InvalidReason = c.invalid, c.reason
+		status := consoleTokenStatus(tokenInfo)
+		if status.UserID != "tenant:acme" || status.Invalid != c.invalid || status.InvalidReason != c.reason ||
+			!status.AccessExpiresAt.Equal(tokenInfo.ExpiresAt) {
+			t.Errorf("unexpected status: %+v", status)
+		}
+		body, err := json.Marshal(status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(body), "secret-") {
+			t.Errorf("token values leaked: %s", body)
+		}
+	}
+}
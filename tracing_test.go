@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceRequestsContinuesIncomingTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(previousProvider)
+	defer otel.SetTextMapPropagator(previousPropagator)
+
+	r := chi.NewRouter()
+	r.Use(traceRequests)
+	r.Get("/api/v1/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, map[string]string{"id": chi.URLParam(r, "id")})
+	})
+
+	req := httptest.NewRequest("GET", "/api/v1/orders/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	var server sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if span.Name() == "GET /api/v1/orders/{id}" {
+			server = span
+		}
+	}
+	if server == nil {
+		t.Fatalf("no span named after the route in %d spans", len(spans))
+	}
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("incoming trace not continued: %v / %v", server.SpanContext().TraceID(), server.Parent().SpanID())
+	}
+	if len(spans) < 3 {
+		t.Errorf("expected serialization spans under the request, got %d spans", len(spans))
+	}
+}
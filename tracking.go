@@ -0,0 +1,57 @@
+package main
+
+import (
+	"convertyApi/service"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// loadCarriers registers every carrier adapter whose credentials are configured
+func loadCarriers(trackingService service.TrackingService) {
+	if username := os.Getenv("ARAMEX_USERNAME"); username != "" {
+		trackingService.RegisterCarrier(&service.AramexCarrier{
+			Username:           username,
+			Password:           os.Getenv("ARAMEX_PASSWORD"),
+			AccountNumber:      os.Getenv("ARAMEX_ACCOUNT_NUMBER"),
+			AccountPin:         os.Getenv("ARAMEX_ACCOUNT_PIN"),
+			AccountEntity:      os.Getenv("ARAMEX_ACCOUNT_ENTITY"),
+			AccountCountryCode: envOr("ARAMEX_ACCOUNT_COUNTRY", "TN"),
+			BaseURL:            envOr("ARAMEX_BASE_URL", "https://ws.aramex.net/ShippingAPI.V2/Tracking/Service_1_0.svc/json"),
+		})
+	}
+	if token := os.Getenv("FIRST_DELIVERY_TOKEN"); token != "" {
+		trackingService.RegisterCarrier(&service.FirstDeliveryCarrier{
+			Token:   token,
+			BaseURL: envOr("FIRST_DELIVERY_BASE_URL", "https://www.firstdeliverygroup.com/api/v2"),
+		})
+	}
+}
+
+// registerTrackingRoutes mounts the shipment tracking the chatbot reads back to customers
+func registerTrackingRoutes(upstream chi.Router, dataService service.DataService, trackingService service.TrackingService) {
+	upstream.Get("/api/v1/orders/{id}/tracking", func(w http.ResponseWriter, r *http.Request) {
+		order, err := tenantData(r, dataService).GetOrder(chi.URLParam(r, "id"))
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		localizer := customerLanguage(w, r)
+		tracking, err := trackingService.Track(order)
+		switch {
+		case errors.Is(err, service.ErrNoTrackingNumber):
+			writeError(w, localizer.T("error.no_tracking_number"), http.StatusNotFound)
+			return
+		case errors.Is(err, service.ErrCarrierNotSupported):
+			writeError(w, localizer.T("error.carrier_not_supported"), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			writeError(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		tracking.Localize(localizer)
+		writeJSON(w, r, http.StatusOK, tracking)
+	})
+}
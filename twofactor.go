@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type staffSessionContextKey struct{}
+
+// staffSession requires an admin session cookie without checking the second factor, so staff
+// can enroll and verify. Viewers may enroll as well even though they are not required to.
+func staffSession(sessions service.SessionService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, session, err := sessionFromCookie(r, sessions, adminSessionCookieName)
+			if err != nil || session.Kind != service.SessionAdmin {
+				writeError(w, "Log in through /admin/login first", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), staffSessionContextKey{}, session)))
+		})
+	}
+}
+
+// decodeCode reads the {"code": "..."} body of the 2FA routes; ok is false when an error was written
+func decodeCode(w http.ResponseWriter, r *http.Request) (code string, ok bool) {
+	var input twoFactorCodeRequest
+	if !bindJSON(w, r, &input) {
+		return "", false
+	}
+	return input.Code, true
+}
+
+// totpErrorStatus maps a TOTPService error to a status code
+func totpErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidTOTP):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrTOTPLocked):
+		return http.StatusTooManyRequests
+	case errors.Is(err, service.ErrTOTPNotEnrolled):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// registerTwoFactorRoutes mounts TOTP enrollment and verification for staff sessions
+func registerTwoFactorRoutes(r chi.Router, totp service.TOTPService, sessions service.SessionService) {
+	r.Route("/admin/2fa", func(r chi.Router) {
+		r.Use(staffSession(sessions))
+
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			session := r.Context().Value(staffSessionContextKey{}).(service.UserSession)
+			enrolled, err := totp.Enrolled(session.Subject)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, r, http.StatusOK, map[string]bool{
+				"enrolled": enrolled,
+				"required": policy().requiresSecondFactor(session.Role),
+				"verified": session.SecondFactor,
+			})
+		})
+
+		// The secret is only returned here; the otpauth URL is meant to be shown as a QR code
+		r.Post("/enroll", func(w http.ResponseWriter, r *http.Request) {
+			session := r.Context().Value(staffSessionContextKey{}).(service.UserSession)
+			secret, err := totp.Enroll(session.Subject)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusConflict)
+				return
+			}
+			account := session.Email
+			if account == "" {
+				account = session.Subject
+			}
+			writeJSON(w, r, http.StatusCreated, map[string]string{
+				"secret":      secret,
+				"otpauth_url": service.TOTPURL(envOr("TOTP_ISSUER", "Converty API"), account, secret),
+			})
+		})
+
+		// Confirming the first code activates 2FA, verifies the current session and returns the
+		// backup codes, which are not shown again
+		r.Post("/confirm", func(w http.ResponseWriter, r *http.Request) {
+			session := r.Context().Value(staffSessionContextKey{}).(service.UserSession)
+			code, ok := decodeCode(w, r)
+			if !ok {
+				return
+			}
+			backupCodes, err := totp.Confirm(session.Subject, code)
+			if err != nil {
+				writeError(w, err.Error(), totpErrorStatus(err))
+				return
+			}
+			if err := sessions.MarkSecondFactor(session.ID); err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Two-factor authentication enabled for %s", session.Subject)
+			writeJSON(w, r, http.StatusOK, map[string][]string{"backup_codes": backupCodes})
+		})
+
+		// Accepts a TOTP code or one of the backup codes
+		r.Post("/verify", func(w http.ResponseWriter, r *http.Request) {
+			session := r.Context().Value(staffSessionContextKey{}).(service.UserSession)
+			code, ok := decodeCode(w, r)
+			if !ok {
+				return
+			}
+			if err := totp.Verify(session.Subject, code); err != nil {
+				writeError(w, err.Error(), totpErrorStatus(err))
+				return
+			}
+			if err := sessions.MarkSecondFactor(session.ID); err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	})
+}
+
+// registerTwoFactorAdminRoutes lets an admin reset the second factor of a staff member who lost
+// their device; the staff member's sessions end and they enroll again at the next login
+func registerTwoFactorAdminRoutes(r chi.Router, totp service.TOTPService, sessions service.SessionService) {
+	r.Delete("/2fa/{subject}", func(w http.ResponseWriter, r *http.Request) {
+		subject := chi.URLParam(r, "subject")
+		if err := totp.Reset(subject); err != nil {
+			writeError(w, err.Error(), totpErrorStatus(err))
+			return
+		}
+		if _, err := sessions.RevokeSubject(service.SessionAdmin, subject, adminActor(r)); err != nil {
+			log.Printf("Failed to revoke sessions of %s: %v", subject, err)
+		}
+		log.Printf("Two-factor authentication of %s reset by %s", subject, adminActor(r))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
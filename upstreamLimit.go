@@ -0,0 +1,66 @@
+package main
+
+import (
+	"convertyApi/service"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// upstreamLimiterConfig reads the limits shared by every Converty call
+func upstreamLimiterConfig(getenv func(string) string) (service.LimiterConfig, error) {
+	cfg := service.LimiterConfig{MaxConcurrent: 8, PerMinute: 120, MaxWait: 10 * time.Second, PageWorkers: 4}
+	for name, target := range map[string]*int{
+		"CONVERTY_MAX_CONCURRENCY": &cfg.MaxConcurrent,
+		"CONVERTY_RATE_PER_MINUTE": &cfg.PerMinute,
+		"CONVERTY_RATE_BURST":      &cfg.Burst,
+		"CONVERTY_PAGE_WORKERS":    &cfg.PageWorkers,
+	} {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid %s %q", name, value)
+		}
+		*target = n
+	}
+	if value := getenv("CONVERTY_BACKGROUND_SHARE"); value != "" {
+		share, err := strconv.ParseFloat(value, 64)
+		if err != nil || share <= 0 || share > 1 {
+			return cfg, fmt.Errorf("invalid CONVERTY_BACKGROUND_SHARE %q, expected a fraction in (0, 1]", value)
+		}
+		cfg.BackgroundShare = share
+	}
+	if value := getenv("CONVERTY_QUEUE_TIMEOUT"); value != "" {
+		wait, err := time.ParseDuration(value)
+		if err != nil || wait <= 0 {
+			return cfg, fmt.Errorf("invalid CONVERTY_QUEUE_TIMEOUT %q", value)
+		}
+		cfg.MaxWait = wait
+	}
+	return cfg, nil
+}
+
+// loadOutboundHTTP applies the proxy and TLS settings of outbound calls: OUTBOUND_PROXY_URL,
+// OUTBOUND_NO_PROXY, OUTBOUND_CA_BUNDLE and OUTBOUND_TLS_MIN_VERSION
+func loadOutboundHTTP() error {
+	config := service.OutboundConfig{
+		ProxyURL:      os.Getenv("OUTBOUND_PROXY_URL"),
+		NoProxy:       os.Getenv("OUTBOUND_NO_PROXY"),
+		CABundle:      os.Getenv("OUTBOUND_CA_BUNDLE"),
+		TLSMinVersion: os.Getenv("OUTBOUND_TLS_MIN_VERSION"),
+	}
+	if err := service.ConfigureOutbound(config); err != nil {
+		return err
+	}
+	if proxy, err := url.Parse(config.ProxyURL); err == nil && config.ProxyURL != "" {
+		// Proxy credentials stay out of the log
+		log.Printf("Outbound calls go through proxy %s", service.RedactURL(proxy))
+	}
+	return nil
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"convertyApi/api"
+	"convertyApi/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Audiences of the operator tokens; session cookies carry none, so neither kind passes for the other
+const (
+	userAccessAudience  = "convertyapi-access"
+	userRefreshAudience = "convertyapi-refresh"
+)
+
+// userTokenTTL is the lifetime of an operator access token and userRefreshTTL that of the refresh
+// token, which is rotated on every use
+var (
+	userTokenTTL   = 15 * time.Minute
+	userRefreshTTL = 7 * 24 * time.Hour
+)
+
+// loginMaxFailures is how many failed logins an account, and loginMaxFailuresPerIP a client address,
+// may have within loginFailureWindow before its logins are refused for loginLockout
+var (
+	loginMaxFailures      = 5
+	loginMaxFailuresPerIP = 50
+	loginFailureWindow    = 15 * time.Minute
+	loginLockout          = 15 * time.Minute
+)
+
+// userLoginRequired makes the records and orders routes require an operator token (REQUIRE_USER_LOGIN);
+// service accounts are still accepted
+var userLoginRequired bool
+
+// userGuardedPaths are the route prefixes closed to plain tenant keys when userLoginRequired is set
+var userGuardedPaths = []string{"/api/v1/records", "/api/v1/orders", "/graphql"}
+
+// UserClaims are the claims of operator access and refresh tokens; the ID is the backing session's
+type UserClaims struct {
+	Role   string `json:"role,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type userContextKey struct{}
+
+// userTokens is the response of a login or refresh
+type userTokens struct {
+	AccessToken  string       `json:"access_token"`
+	TokenType    string       `json:"token_type"`
+	ExpiresIn    int          `json:"expires_in"`
+	RefreshToken string       `json:"refresh_token"`
+	User         service.User `json:"user"`
+}
+
+// loadUserAuth reads USER_TOKEN_TTL, USER_REFRESH_TTL, the LOGIN_* throttle settings and REQUIRE_USER_LOGIN
+func loadUserAuth() {
+	userTokenTTL = durationEnv("USER_TOKEN_TTL", userTokenTTL)
+	userRefreshTTL = durationEnv("USER_REFRESH_TTL", userRefreshTTL)
+	loginMaxFailures = intEnv("LOGIN_MAX_FAILURES", loginMaxFailures)
+	loginMaxFailuresPerIP = intEnv("LOGIN_MAX_FAILURES_PER_IP", loginMaxFailuresPerIP)
+	loginFailureWindow = durationEnv("LOGIN_FAILURE_WINDOW", loginFailureWindow)
+	loginLockout = durationEnv("LOGIN_LOCKOUT", loginLockout)
+	userLoginRequired = os.Getenv("REQUIRE_USER_LOGIN") == "true"
+}
+
+// signUserToken signs operator claims for one audience
+func signUserToken(session service.UserSession, audience string, expiresAt time.Time) (string, error) {
+	claims := UserClaims{
+		Role:   session.Role,
+		Tenant: session.Tenant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        session.ID,
+			Subject:   session.Subject,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(sessionSecret)
+}
+
+// parseUserToken verifies an operator token of the audience and returns its claims
+func parseUserToken(token, audience string) (*UserClaims, error) {
+	claims := &UserClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return sessionSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(audience), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" || claims.ID == "" {
+		return nil, fmt.Errorf("token has no subject or ID")
+	}
+	return claims, nil
+}
+
+// issueUserTokens starts a session for the operator and returns its access and refresh tokens
+func issueUserTokens(r *http.Request, sessions service.SessionService, user service.User) (userTokens, error) {
+	session, err := sessions.Create(service.UserSession{
+		Kind:      service.SessionUser,
+		Subject:   user.Username,
+		Tenant:    user.Tenant,
+		Email:     user.Email,
+		Role:      user.Role,
+		UserAgent: r.UserAgent(),
+		IPAddress: clientIP(r),
+		ExpiresAt: time.Now().Add(userRefreshTTL),
+	}, sessionMaxPerUser)
+	if err != nil {
+		return userTokens{}, err
+	}
+	accessExpiry := time.Now().Add(userTokenTTL)
+	if accessExpiry.After(session.ExpiresAt) {
+		accessExpiry = session.ExpiresAt
+	}
+	access, err := signUserToken(session, userAccessAudience, accessExpiry)
+	if err != nil {
+		return userTokens{}, err
+	}
+	refresh, err := signUserToken(session, userRefreshAudience, session.ExpiresAt)
+	if err != nil {
+		return userTokens{}, err
+	}
+	return userTokens{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(time.Until(accessExpiry).Seconds()),
+		RefreshToken: refresh,
+		User:         user,
+	}, nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// resolveUser authenticates operators sending an access token. The token selects the operator's
+// tenant, and the operator's role must allow the request under the access policy; the /api/v1/auth
+// routes are open to every role. Requests without a bearer token pass on to the tenant key check.
+func resolveUser(tenantService service.TenantService, sessions service.SessionService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			claims, err := parseUserToken(token, userAccessAudience)
+			if err != nil {
+				writeError(w, "Invalid or expired access token", http.StatusUnauthorized)
+				return
+			}
+			if _, err := sessions.Validate(claims.ID); err != nil {
+				writeError(w, "Invalid or expired access token", http.StatusUnauthorized)
+				return
+			}
+			tenant, err := tenantService.GetTenant(claims.Tenant)
+			if err != nil {
+				writeError(w, "Invalid or expired access token", http.StatusUnauthorized)
+				return
+			}
+			if !strings.HasPrefix(r.URL.Path, "/api/v1/auth/") && !policy().roleAllows(claims.Role, r.Method, r.URL.Path) {
+				writeError(w, fmt.Sprintf("Role %s may not %s %s", claims.Role, r.Method, r.URL.Path), http.StatusForbidden)
+				return
+			}
+			ctx := context.WithValue(r.Context(), userContextKey{}, claims)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, tenantContextKey{}, tenant)))
+		})
+	}
+}
+
+// requireUser rejects requests to the guarded routes that carry neither an operator token nor a
+// service account key when REQUIRE_USER_LOGIN is set
+func requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userLoginRequired && isUserGuardedPath(r.URL.Path) {
+			_, user := userFrom(r)
+			_, account := serviceAccountFrom(r)
+			if !user && !account {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="convertyapi"`)
+				writeError(w, "Login required: send the access token from /api/v1/auth/login", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isUserGuardedPath reports whether path requires an operator login when REQUIRE_USER_LOGIN is set
+func isUserGuardedPath(path string) bool {
+	for _, prefix := range userGuardedPaths {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// userFrom returns the operator behind the request, if any
+func userFrom(r *http.Request) (*UserClaims, bool) {
+	claims, ok := r.Context().Value(userContextKey{}).(*UserClaims)
+	return claims, ok
+}
+
+// loginThrottleKeys returns the throttle keys of a login attempt: its account and its client address
+func loginThrottleKeys(r *http.Request, username string) (account, address string) {
+	return "user:" + strings.ToLower(username), "ip:" + clientIP(r)
+}
+
+// loginFailureCleanupJobType is the job queue type that removes the failed logins that no longer count
+const loginFailureCleanupJobType = "purge_login_failures"
+
+// registerLoginFailureCleanupJob registers the handler that prunes the login throttle
+func registerLoginFailureCleanupJob(jobService service.JobService, throttle service.LoginThrottle) {
+	jobService.RegisterHandler(loginFailureCleanupJobType, func(payload json.RawMessage) (interface{}, error) {
+		pruned, err := throttle.Prune(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]int64{"pruned": pruned}, nil
+	})
+}
+
+// registerUserRoutes mounts the operator login, token refresh and logout. Logins are refused with
+// 429 once the account or the client address failed too often.
+func registerUserRoutes(r chi.Router, users service.UserService, sessions service.SessionService, throttle service.LoginThrottle) {
+	r.Post("/api/v1/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		var input loginRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		account, address := loginThrottleKeys(r, input.Username)
+		wait, err := throttle.Blocked(account, address)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())))
+			writeError(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+			return
+		}
+		user, err := users.Authenticate(input.Username, input.Password)
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			if err := throttle.Fail(service.LoginLimit{Key: account, Max: loginMaxFailures}, service.LoginLimit{Key: address, Max: loginMaxFailuresPerIP}); err != nil {
+				log.Printf("Failed to count a failed login: %v", err)
+			}
+			writeError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := throttle.Reset(account); err != nil {
+			log.Printf("Failed to reset the failed logins of %s: %v", user.Username, err)
+		}
+		tokens, err := issueUserTokens(r, sessions, user)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, tokens)
+	})
+
+	// Exchanges a refresh token for new tokens; the old refresh token stops working
+	r.Post("/api/v1/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		var input refreshRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		claims, err := parseUserToken(input.RefreshToken, userRefreshAudience)
+		if err != nil {
+			writeError(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+			return
+		}
+		// Consuming the session is the check: of two requests with the same token, one fails
+		if _, err := sessions.Consume(claims.ID, "refresh"); errors.Is(err, service.ErrSessionInactive) {
+			writeError(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Reload the user so role changes apply and disabled users cannot refresh
+		user, err := users.GetUserByUsername(claims.Subject)
+		if err != nil || user.DisabledAt != nil {
+			writeError(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+			return
+		}
+		tokens, err := issueUserTokens(r, sessions, user)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, tokens)
+	})
+
+	// Ends the session of the access token, invalidating it and its refresh token
+	r.Post("/api/v1/auth/logout", func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := userFrom(r)
+		if !ok {
+			writeError(w, "Missing access token", http.StatusUnauthorized)
+			return
+		}
+		if _, err := sessions.Revoke(claims.ID, "logout"); err != nil {
+			log.Printf("Failed to revoke operator session: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Get("/api/v1/auth/me", func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := userFrom(r)
+		if !ok {
+			writeError(w, "Missing access token", http.StatusUnauthorized)
+			return
+		}
+		user, err := users.GetUserByUsername(claims.Subject)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, user)
+	})
+}
+
+// userError maps user service errors to status codes
+func userError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrUserNotFound) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+}
+
+// registerUserAdminRoutes mounts operator management under the admin router
+func registerUserAdminRoutes(r chi.Router, tenantService service.TenantService, users service.UserService, sessions service.SessionService) {
+	r.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		list, err := users.ListUsers(r.URL.Query().Get("tenant"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, list, params))
+	})
+
+	r.Post("/users", func(w http.ResponseWriter, r *http.Request) {
+		var input userRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		if input.Tenant == "" {
+			input.Tenant = service.DefaultTenant.Slug
+		}
+		if _, err := tenantService.GetTenant(input.Tenant); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !policy().hasRole(input.Role) {
+			writeError(w, fmt.Sprintf("Unknown role %q", input.Role), http.StatusBadRequest)
+			return
+		}
+		user, err := users.CreateUser(service.User{Username: input.Username, Email: input.Email, Role: input.Role, Tenant: input.Tenant}, input.Password)
+		if err != nil {
+			userError(w, err)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, user)
+	})
+
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		user, err := users.GetUser(uint(id))
+		if err != nil {
+			userError(w, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, user)
+	})
+
+	// A new password or disabling the user ends the user's sessions
+	r.Patch("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		var input userUpdateRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		if input.Role != nil && !policy().hasRole(*input.Role) {
+			writeError(w, fmt.Sprintf("Unknown role %q", *input.Role), http.StatusBadRequest)
+			return
+		}
+		user, err := users.UpdateUser(uint(id), service.UserUpdate{
+			Email: input.Email, Role: input.Role, Password: input.Password, Disabled: input.Disabled,
+		})
+		if err != nil {
+			userError(w, err)
+			return
+		}
+		if input.Password != nil || input.Role != nil || user.DisabledAt != nil {
+			if _, err := sessions.RevokeSubject(service.SessionUser, user.Username, adminActor(r)); err != nil {
+				log.Printf("Failed to end the sessions of %s: %v", user.Username, err)
+			}
+		}
+		writeJSON(w, r, http.StatusOK, user)
+	})
+
+	r.Delete("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid ID format", http.StatusBadRequest)
+			return
+		}
+		user, err := users.GetUser(uint(id))
+		if err != nil {
+			userError(w, err)
+			return
+		}
+		if err := users.DeleteUser(user.ID); err != nil {
+			userError(w, err)
+			return
+		}
+		if _, err := sessions.RevokeSubject(service.SessionUser, user.Username, adminActor(r)); err != nil {
+			log.Printf("Failed to end the sessions of %s: %v", user.Username, err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
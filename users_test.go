@@ -0,0 +1,251 @@
+package main
+
+import (
+	"convertyApi/service"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestUserTokenAudiences(t *testing.T) {
+	sessionSecret = []byte("0123456789abcdef0123456789abcdef")
+	sessions := newMemorySessions()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", nil)
+	tokens, err := issueUserTokens(req, sessions, service.User{Username: "amira", Role: roleViewer, Tenant: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := parseUserToken(tokens.AccessToken, userAccessAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "amira" || claims.Role != roleViewer || claims.Tenant != "default" || sessions.sessions[claims.ID].Kind != service.SessionUser {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if tokens.ExpiresIn <= 0 || tokens.ExpiresIn > int(userTokenTTL.Seconds()) {
+		t.Errorf("expires_in = %d", tokens.ExpiresIn)
+	}
+
+	// Neither token passes for the other, nor for a session cookie
+	if _, err := parseUserToken(tokens.RefreshToken, userAccessAudience); err == nil {
+		t.Error("refresh token accepted as access token")
+	}
+	if _, err := parseUserToken(tokens.AccessToken, userRefreshAudience); err == nil {
+		t.Error("access token accepted as refresh token")
+	}
+	if _, err := parseSession(tokens.AccessToken); err == nil {
+		t.Error("access token accepted as session cookie")
+	}
+	cookie, _ := signSession(service.UserSession{ID: "s9", Subject: "store:42", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+	if _, err := parseUserToken(cookie, userAccessAudience); err == nil {
+		t.Error("session cookie accepted as access token")
+	}
+}
+
+func TestResolveUser(t *testing.T) {
+	sessionSecret = []byte("0123456789abcdef0123456789abcdef")
+	sessions := newMemorySessions()
+	tokens, err := issueUserTokens(httptest.NewRequest(http.MethodPost, "/", nil), sessions, service.User{Username: "amira", Role: roleViewer, Tenant: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actor string
+	handler := resolveUser(stubTenantService{}, sessions)(resolveTenant(stubTenantService{}, nil)(requireUser(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { actor = requestActor(r) }))))
+
+	defer func() { userLoginRequired = false }()
+	userLoginRequired = true
+	cases := []struct {
+		method, path, token string
+		want                int
+		wantActor           string
+	}{
+		{http.MethodGet, "/api/v1/records", tokens.AccessToken, http.StatusOK, "user:amira"},
+		// Viewers may only read
+		{http.MethodDelete, "/api/v1/records/1", tokens.AccessToken, http.StatusForbidden, ""},
+		{http.MethodPost, "/api/v1/auth/logout", tokens.AccessToken, http.StatusOK, "user:amira"},
+		{http.MethodGet, "/api/v1/orders", tokens.RefreshToken, http.StatusUnauthorized, ""},
+		{http.MethodGet, "/api/v1/orders", "", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/api/v1/categories", "", http.StatusOK, "api"},
+	}
+	for _, c := range cases {
+		actor = ""
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.want || actor != c.wantActor {
+			t.Errorf("%s %s: status %d actor %q, want %d %q", c.method, c.path, rec.Code, actor, c.want, c.wantActor)
+		}
+	}
+
+	// A revoked session invalidates its access token at once
+	claims, _ := parseUserToken(tokens.AccessToken, userAccessAudience)
+	sessions.Revoke(claims.ID, "logout")
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/records", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session: status %d", rec.Code)
+	}
+}
+
+// memoryLoginThrottle is an in-memory LoginThrottle for handler tests; its window never ends
+type memoryLoginThrottle struct {
+	failures map[string]int
+	locked   map[string]time.Time
+}
+
+func newMemoryLoginThrottle() *memoryLoginThrottle {
+	return &memoryLoginThrottle{failures: map[string]int{}, locked: map[string]time.Time{}}
+}
+
+func (m *memoryLoginThrottle) Blocked(keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range keys {
+		if until := time.Until(m.locked[key]); until > wait {
+			wait = until
+		}
+	}
+	return wait, nil
+}
+
+func (m *memoryLoginThrottle) Fail(limits ...service.LoginLimit) error {
+	for _, limit := range limits {
+		if m.failures[limit.Key]++; m.failures[limit.Key] >= limit.Max {
+			m.locked[limit.Key] = time.Now().Add(loginLockout)
+		}
+	}
+	return nil
+}
+
+func (m *memoryLoginThrottle) Reset(key string) error {
+	delete(m.failures, key)
+	return nil
+}
+
+func (m *memoryLoginThrottle) Prune(now time.Time) (int64, error) {
+	return 0, nil
+}
+
+// stubUsers knows one operator with a fixed password
+type stubUsers struct {
+	service.UserService
+}
+
+func (stubUsers) Authenticate(username, password string) (service.User, error) {
+	if username != "amira" || password != "s3cret-pass" {
+		return service.User{}, service.ErrInvalidCredentials
+	}
+	return service.User{Username: "amira", Role: roleViewer, Tenant: "default"}, nil
+}
+
+func (stubUsers) GetUserByUsername(username string) (service.User, error) {
+	return service.User{Username: username, Role: roleViewer, Tenant: "default"}, nil
+}
+
+func TestLoginThrottleAndRefreshRotation(t *testing.T) {
+	sessionSecret = []byte("0123456789abcdef0123456789abcdef")
+	defer func(account, address int) { loginMaxFailures, loginMaxFailuresPerIP = account, address }(loginMaxFailures, loginMaxFailuresPerIP)
+	loginMaxFailures, loginMaxFailuresPerIP = 3, 5
+	r := chi.NewRouter()
+	registerUserRoutes(r, stubUsers{}, newMemorySessions(), newMemoryLoginThrottle())
+	post := func(path, body, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", ip)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The account is locked after three failures, even for the right password
+	for i := 0; i < 3; i++ {
+		if rec := post("/api/v1/auth/login", `{"username": "amira", "password": "wrong-pass"}`, "10.0.0.1"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: status %d", i+1, rec.Code)
+		}
+	}
+	rec := post("/api/v1/auth/login", `{"username": "Amira", "password": "s3cret-pass"}`, "10.0.0.2")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("locked account: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// An address is locked after five failures, whatever the account
+	for i := 0; i < 5; i++ {
+		post("/api/v1/auth/login", `{"username": "guess`+string(rune('a'+i))+`", "password": "wrong-pass"}`, "10.0.0.3")
+	}
+	if rec := post("/api/v1/auth/login", `{"username": "other", "password": "wrong-pass"}`, "10.0.0.3"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked address: status %d", rec.Code)
+	}
+
+	// A refresh token is consumed by its first use
+	r = chi.NewRouter()
+	registerUserRoutes(r, stubUsers{}, newMemorySessions(), newMemoryLoginThrottle())
+	rec = post("/api/v1/auth/login", `{"username": "amira", "password": "s3cret-pass"}`, "10.0.0.4")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d", rec.Code)
+	}
+	var tokens userTokens
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	refresh := `{"refresh_token": "` + tokens.RefreshToken + `"}`
+	if rec := post("/api/v1/auth/refresh", refresh, "10.0.0.4"); rec.Code != http.StatusOK {
+		t.Fatalf("first refresh: status %d", rec.Code)
+	}
+	if rec := post("/api/v1/auth/refresh", refresh, "10.0.0.4"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("second refresh: status %d", rec.Code)
+	}
+}
+
+func (stubUsers) ListUsers(tenant string) ([]service.User, error) {
+	return []service.User{{Username: "amira", Tenant: "default"}, {Username: "karim", Tenant: "default"}, {Username: "sami", Tenant: "default"}}, nil
+}
+
+func (stubTenantService) ListTenants() ([]service.Tenant, error) {
+	return []service.Tenant{{ID: 7, Slug: "shop"}, {ID: 8, Slug: "market"}}, nil
+}
+
+func TestAdminListsArePaged(t *testing.T) {
+	r := chi.NewRouter()
+	registerUserAdminRoutes(r, stubTenantService{}, stubUsers{}, newMemorySessions())
+	registerTenantAdminRoutes(r, stubTenantService{})
+	for _, c := range []struct {
+		path       string
+		wantStatus int
+		wantItems  int
+		wantTotal  int64
+	}{
+		{"/users?page=2&limit=2", http.StatusOK, 1, 3},
+		{"/users", http.StatusOK, 3, 3},
+		{"/tenants?limit=1", http.StatusOK, 1, 2},
+		{"/tenants?page=0", http.StatusBadRequest, 0, 0},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if rec.Code != c.wantStatus {
+			t.Errorf("%s: status %d, want %d", c.path, rec.Code, c.wantStatus)
+			continue
+		}
+		if c.wantStatus != http.StatusOK {
+			continue
+		}
+		var page struct {
+			Data []json.RawMessage `json:"data"`
+			Meta struct {
+				Total int64 `json:"total"`
+			} `json:"meta"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || len(page.Data) != c.wantItems || page.Meta.Total != c.wantTotal {
+			t.Errorf("%s: %d items of %d (%v), want %d of %d", c.path, len(page.Data), page.Meta.Total, err, c.wantItems, c.wantTotal)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"convertyApi/api"
+	"convertyApi/service"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// stockSyncJobType is the job queue type of the stock sync that drives waitlist notifications
+const stockSyncJobType = "sync_stock"
+
+// stockSyncMaxPages bounds how many Converty product pages one sync walks
+const stockSyncMaxPages = 40
+
+// registerStockSyncJob registers the handler that detects replenished products per tenant
+func registerStockSyncJob(jobService service.JobService, dataService service.DataService, tenantService service.TenantService, waitlistService service.WaitlistService) {
+	jobService.RegisterHandler(stockSyncJobType, func(payload json.RawMessage) (interface{}, error) {
+		tenants, err := jobTenants(tenantService, payload)
+		if err != nil {
+			return nil, err
+		}
+		results := make(map[string]service.StockSyncResult)
+		for _, tenant := range tenants {
+			result, err := waitlistService.SyncStock(tenant.ID, dataService.ForTenant(tenant), stockSyncMaxPages)
+			if err != nil {
+				log.Printf("Stock sync for tenant %s failed: %v", tenant.Slug, err)
+				continue
+			}
+			results[tenant.Slug] = result
+		}
+		return results, nil
+	})
+}
+
+// registerWaitlistRoutes mounts the out-of-stock auto-responder and waitlist reporting
+func registerWaitlistRoutes(r, upstream chi.Router, dataService service.DataService, waitlistService service.WaitlistService) {
+	// The chatbot asks here when a customer requests a product; out-of-stock requests join the waitlist
+	upstream.Post("/api/v1/products/{id}/availability", func(w http.ResponseWriter, r *http.Request) {
+		var input service.WaitlistRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		availability, err := waitlistService.CheckAvailability(tenantFrom(r).ID, tenantData(r, dataService), chi.URLParam(r, "id"), input)
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, availability)
+	})
+
+	r.Get("/api/v1/waitlists", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, err := waitlistService.ListEntries(tenantFrom(r).ID, r.URL.Query().Get("status"), r.URL.Query().Get("product"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, entries, params))
+	})
+
+	r.Get("/api/v1/waitlists/report", func(w http.ResponseWriter, r *http.Request) {
+		report, err := waitlistService.ConversionReport(tenantFrom(r).ID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, report)
+	})
+}
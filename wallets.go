@@ -0,0 +1,65 @@
+package main
+
+import (
+	"convertyApi/service"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// registerWalletRoutes mounts the store-credit endpoints; order lookups go through the upstream router
+func registerWalletRoutes(r, upstream chi.Router, dataService service.DataService, walletService service.WalletService) {
+	r.Get("/api/v1/wallets/{phone}", func(w http.ResponseWriter, r *http.Request) {
+		balance, err := walletService.Balance(tenantFrom(r).ID, chi.URLParam(r, "phone"), r.URL.Query().Get("entries") != "false")
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, balance)
+	})
+
+	// Credits move money, so only operators may issue them
+	r.With(adminOnly).Post("/api/v1/wallets/{phone}/credits", func(w http.ResponseWriter, r *http.Request) {
+		var input walletCreditRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		balance, err := walletService.Credit(tenantFrom(r).ID, chi.URLParam(r, "phone"), service.WalletRefundCredit, input.Amount, input.OrderID, input.Reason, adminActor(r))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, balance)
+	})
+
+	r.With(adminOnly).Get("/api/v1/admin/wallets/audit", func(w http.ResponseWriter, r *http.Request) {
+		unbalanced, err := walletService.UnbalancedTransactions(tenantFrom(r).ID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"balanced":                len(unbalanced) == 0,
+			"unbalanced_transactions": unbalanced,
+		})
+	})
+
+	upstream.Post("/api/v1/orders/{id}/apply-credit", func(w http.ResponseWriter, r *http.Request) {
+		var input applyCreditRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		order, err := tenantData(r, dataService).GetOrder(chi.URLParam(r, "id"))
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		// The wallet is the order customer's and the actor the authenticated caller, whatever the body says
+		applied, err := walletService.ApplyToOrder(tenantFrom(r).ID, order, input.MaxAmount, requestActor(r))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, applied)
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"convertyApi/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// warehouseExportJobType is the job queue type of the incremental interactions export
+const warehouseExportJobType = "export_warehouse"
+
+// loadWarehouseExport builds the exporter of WAREHOUSE_EXPORT_STORAGE (local or s3), or returns nil when
+// it is unset. The s3 backend uses WAREHOUSE_S3_BUCKET and falls back to the S3_* settings of attachments.
+func loadWarehouseExport() (service.WarehouseExportService, error) {
+	backend := os.Getenv("WAREHOUSE_EXPORT_STORAGE")
+	if backend == "" {
+		return nil, nil
+	}
+	// Parquet needs a writer this build does not ship; the warehouse loaders read NDJSON as well
+	if format := envOr("WAREHOUSE_EXPORT_FORMAT", service.WarehouseFormatNDJSON); format != service.WarehouseFormatNDJSON {
+		return nil, fmt.Errorf("unsupported WAREHOUSE_EXPORT_FORMAT %q, expected ndjson", format)
+	}
+	opts := service.WarehouseExportOptions{Prefix: envOr("WAREHOUSE_EXPORT_PREFIX", "interactions")}
+	if value := os.Getenv("WAREHOUSE_EXPORT_BATCH_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid WAREHOUSE_EXPORT_BATCH_SIZE %q", value)
+		}
+		opts.BatchSize = size
+	}
+	switch backend {
+	case "local":
+		opts.Store = &service.LocalBlobStore{Dir: envOr("WAREHOUSE_EXPORT_DIR", "warehouse")}
+	case "s3":
+		store := &service.S3BlobStore{
+			Endpoint:  envOr("WAREHOUSE_S3_ENDPOINT", os.Getenv("S3_ENDPOINT")),
+			Region:    envOr("WAREHOUSE_S3_REGION", envOr("S3_REGION", "us-east-1")),
+			Bucket:    os.Getenv("WAREHOUSE_S3_BUCKET"),
+			AccessKey: envOr("WAREHOUSE_S3_ACCESS_KEY_ID", os.Getenv("S3_ACCESS_KEY_ID")),
+			SecretKey: envOr("WAREHOUSE_S3_SECRET_ACCESS_KEY", os.Getenv("S3_SECRET_ACCESS_KEY")),
+			PathStyle: envOr("WAREHOUSE_S3_PATH_STYLE", os.Getenv("S3_PATH_STYLE")) == "true",
+		}
+		if store.Bucket == "" || store.AccessKey == "" || store.SecretKey == "" {
+			return nil, fmt.Errorf("WAREHOUSE_S3_BUCKET and S3 credentials are required for s3 warehouse exports")
+		}
+		opts.Store = store
+	default:
+		return nil, fmt.Errorf("unknown WAREHOUSE_EXPORT_STORAGE %q, expected local or s3", backend)
+	}
+	return service.NewGormWarehouseExportService(db, opts), nil
+}
+
+// registerWarehouseExportJob registers the handler exporting the interactions added since the last run
+func registerWarehouseExportJob(jobService service.JobService, exporter service.WarehouseExportService) {
+	jobService.RegisterHandler(warehouseExportJobType, func(payload json.RawMessage) (interface{}, error) {
+		result, err := exporter.Export(context.Background(), 0)
+		if err != nil {
+			return result, err
+		}
+		log.Printf("Exported %d interactions to the warehouse in %d objects, cursor %d", result.Rows, len(result.Objects), result.Cursor)
+		return result, nil
+	})
+}
+
+// runWarehouseExport backs -export: it exports every interaction not exported yet and exits
+func runWarehouseExport(exporter service.WarehouseExportService) {
+	if exporter == nil {
+		log.Fatal("WAREHOUSE_EXPORT_STORAGE is not configured")
+	}
+	if err := waitForDB(context.Background(), db, durationEnv("DB_CONNECT_TIMEOUT", dbConnectWindow)); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	if err := migrateDB(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	start := time.Now()
+	result, err := exporter.Export(context.Background(), 0)
+	if err != nil {
+		log.Fatalf("Warehouse export failed after %d interactions: %v", result.Rows, err)
+	}
+	log.Printf("Exported %d interactions in %d objects in %v, cursor %d", result.Rows, len(result.Objects), time.Since(start).Round(time.Millisecond), result.Cursor)
+}
+
+// registerWarehouseExportAdminRoutes shows the export cursor and runs the export on demand
+func registerWarehouseExportAdminRoutes(r chi.Router, jobService service.JobService, exporter service.WarehouseExportService) {
+	r.Get("/warehouse-export", func(w http.ResponseWriter, r *http.Request) {
+		if exporter == nil {
+			writeError(w, "WAREHOUSE_EXPORT_STORAGE is not configured", http.StatusConflict)
+			return
+		}
+		state, err := exporter.State()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, state)
+	})
+
+	r.Post("/warehouse-export", func(w http.ResponseWriter, r *http.Request) {
+		if exporter == nil {
+			writeError(w, "WAREHOUSE_EXPORT_STORAGE is not configured", http.StatusConflict)
+			return
+		}
+		job, err := jobService.Enqueue(tenantFrom(r).ID, warehouseExportJobType, nil)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusAccepted, job)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"convertyApi/api"
+	"convertyApi/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// webhookMaxAttempts reads WEBHOOK_MAX_ATTEMPTS, the attempts before a webhook delivery is given up
+func webhookMaxAttempts() (int, error) {
+	value := os.Getenv("WEBHOOK_MAX_ATTEMPTS")
+	if value == "" {
+		return 8, nil
+	}
+	attempts, err := strconv.Atoi(value)
+	if err != nil || attempts < 1 {
+		return 0, fmt.Errorf("WEBHOOK_MAX_ATTEMPTS must be a positive integer, got %q", value)
+	}
+	return attempts, nil
+}
+
+// registerWebhookRoutes mounts the chatbot platform callbacks of the request tenant and their delivery log
+func registerWebhookRoutes(r chi.Router, webhookService service.WebhookService) {
+	r.Get("/api/v1/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		endpoints, err := webhookService.ListEndpoints(tenantFrom(r).ID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, endpoints)
+	})
+
+	// {"url": "https://bot.example/hooks", "events": ["issue.created"], "secret": "..."}; the secret is
+	// generated when omitted and only returned here
+	r.Post("/api/v1/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		var input webhookRequest
+		if !bindJSON(w, r, &input) {
+			return
+		}
+		endpoint, err := webhookService.CreateEndpoint(tenantFrom(r).ID, input.URL, input.Secret, input.Events)
+		if err != nil {
+			writeError(w, err.Error(), serviceStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, map[string]interface{}{
+			"webhook": endpoint,
+			"secret":  endpoint.Secret,
+		})
+	})
+
+	r.Delete("/api/v1/webhooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+		err = webhookService.DeleteEndpoint(tenantFrom(r).ID, uint(id))
+		if errors.Is(err, service.ErrWebhookNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// /api/v1/webhooks/deliveries?status=failed&event=issue.created&webhook=3
+	r.Get("/api/v1/webhooks/deliveries", func(w http.ResponseWriter, r *http.Request) {
+		params, err := api.ParsePageParams(r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+		filter := service.WebhookDeliveryFilter{Event: query.Get("event"), Status: query.Get("status")}
+		if value := query.Get("webhook"); value != "" {
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				writeError(w, "Invalid webhook ID", http.StatusBadRequest)
+				return
+			}
+			filter.EndpointID = uint(id)
+		}
+		deliveries, err := webhookService.ListDeliveries(tenantFrom(r).ID, filter)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, api.Slice(r, deliveries, params))
+	})
+}